@@ -105,10 +105,10 @@ func NewB2BUA(disableAuth bool) *B2BUA {
 		logger.Panic(err)
 	}
 
-	ua := ua.NewUserAgent(&ua.UserAgentConfig{
-
-		SipStack: stack,
-	})
+	ua, err := ua.NewUserAgent(ua.WithSipStack(stack))
+	if err != nil {
+		logger.Panic(err)
+	}
 
 	ua.InviteStateHandler = func(sess *session.Session, req *sip.Request, resp *sip.Response, state session.Status) {
 		logger.Infof("InviteStateHandler: state => %v, type => %s", state, sess.Direction())
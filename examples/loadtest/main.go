@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/ghettovoice/gosip/log"
+	"github.com/ghettovoice/gosip/sip/parser"
+	"github.com/sergeyu/go-sip-ua/examples/mock"
+	"github.com/sergeyu/go-sip-ua/pkg/account"
+	"github.com/sergeyu/go-sip-ua/pkg/loadtest"
+	"github.com/sergeyu/go-sip-ua/pkg/stack"
+	"github.com/sergeyu/go-sip-ua/pkg/ua"
+	"github.com/sergeyu/go-sip-ua/pkg/utils"
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, `Usage: loadtest [options] sip:called@host[:port]
+
+Makes calls to the called URI at a rate and reports their setup latencies and failures.
+
+Options:
+`)
+	flag.PrintDefaults()
+}
+
+func main() {
+	listen := flag.String("listen", "0.0.0.0:5070", "address the calls are made from")
+	transport := flag.String("transport", "udp", "transport of the calls, udp, tcp or tls")
+	from := flag.String("from", "sip:loadtest@127.0.0.1", "URI of the caller")
+	user := flag.String("user", "", "user of the digest authentication, none if empty")
+	password := flag.String("password", "", "password of the digest authentication")
+	calls := flag.Int("calls", 100, "calls made, until interrupted if 0")
+	concurrency := flag.Int("concurrency", 10, "most calls in progress at once, unlimited if 0")
+	cps := flag.Float64("cps", 10, "calls started per second, as fast as concurrency allows if 0")
+	hold := flag.Duration("hold", 5*time.Second, "how long an answered call is held")
+	setup := flag.Duration("setup-timeout", loadtest.DefaultSetupTimeout, "how long a call may ring")
+	sdp := flag.Bool("sdp", true, "offer a PCMU/PCMA audio stream")
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() != 1 {
+		usage()
+		os.Exit(2)
+	}
+
+	// Only the warnings, the debug logs of thousands of calls would be the load.
+	utils.SetLogSink(utils.LogSinkFunc(func(level log.Level, msg string, fields log.Fields) {
+		if level <= log.WarnLevel {
+			fmt.Fprintf(os.Stderr, "%s %v\n", msg, fields)
+		}
+	}))
+
+	called, err := parser.ParseSipUri(flag.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "called URI: %v\n", err)
+		os.Exit(2)
+	}
+	caller, err := parser.ParseUri(*from)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "from URI: %v\n", err)
+		os.Exit(2)
+	}
+	host, port, err := net.SplitHostPort(*listen)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "listen: %v\n", err)
+		os.Exit(2)
+	}
+
+	stackConfig := &stack.SipStackConfig{UserAgent: "Go Sip Client/example-loadtest"}
+	if ip := net.ParseIP(host); ip != nil && !ip.IsUnspecified() {
+		stackConfig.Host = host
+	}
+	sipStack := stack.NewSipStack(stackConfig)
+	if err := sipStack.Listen(*transport, *listen); err != nil {
+		fmt.Fprintf(os.Stderr, "listen: %v\n", err)
+		os.Exit(1)
+	}
+	userAgent, err := ua.NewUserAgent(ua.WithSipStack(sipStack))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "user agent: %v\n", err)
+		os.Exit(1)
+	}
+	defer userAgent.Shutdown()
+
+	var authInfo *account.AuthInfo
+	if *user != "" {
+		authInfo = &account.AuthInfo{AuthUser: *user, Password: *password}
+	}
+	profile := account.NewProfile(caller, "loadtest", authInfo, 0, sipStack)
+
+	config := loadtest.Config{
+		Profile:      profile,
+		Target:       &called,
+		Recipient:    called,
+		Calls:        *calls,
+		Concurrency:  *concurrency,
+		CPS:          *cps,
+		HoldTime:     *hold,
+		SetupTimeout: *setup,
+	}
+	if *sdp {
+		// The media is not sent, the port is only offered.
+		rtpPort, _ := strconv.Atoi(port)
+		config.SDP = mock.BuildLocalSdp(sipStack.GetNetworkInfo(*transport).Host, rtpPort+2)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGTERM, syscall.SIGINT)
+	go func() {
+		<-stop
+		cancel()
+	}()
+
+	report, err := loadtest.Run(ctx, userAgent, config)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "loadtest: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Print(report)
+}
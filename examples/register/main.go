@@ -37,9 +37,10 @@ func main() {
 		logger.Panic(err)
 	}
 
-	ua := ua.NewUserAgent(&ua.UserAgentConfig{
-		SipStack: stack,
-	})
+	ua, err := ua.NewUserAgent(ua.WithSipStack(stack))
+	if err != nil {
+		logger.Panic(err)
+	}
 
 	ua.RegisterStateHandler = func(state account.RegisterState) {
 		logger.Infof("RegisterStateHandler: user => %s, state => %v, expires => %v, reason => %v", state.Account.AuthInfo.AuthUser, state.StatusCode, state.Expiration, state.Reason)
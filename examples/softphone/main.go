@@ -0,0 +1,570 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/ghettovoice/gosip/log"
+	"github.com/ghettovoice/gosip/sip"
+	"github.com/ghettovoice/gosip/sip/parser"
+	"github.com/pixelbender/go-sdp/sdp"
+	"github.com/sergeyu/go-sip-ua/pkg/account"
+	"github.com/sergeyu/go-sip-ua/pkg/media"
+	"github.com/sergeyu/go-sip-ua/pkg/media/rtp"
+	"github.com/sergeyu/go-sip-ua/pkg/session"
+	"github.com/sergeyu/go-sip-ua/pkg/stack"
+	"github.com/sergeyu/go-sip-ua/pkg/ua"
+	"github.com/sergeyu/go-sip-ua/pkg/utils"
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, `Usage: softphone [options] sip:user@domain
+
+A softphone of the account: it registers, places and receives calls, plays a WAV file to the calls and
+records their audio, sends and prints DTMF, transfers the calls and follows the transfers.
+
+Commands, read from the standard input:
+  call <uri>        call the URI, e.g. sip:bob@example.com or a user of the domain
+  answer            answer the incoming call
+  reject            reject the incoming call with 486
+  hangup            hang the call up
+  hold, unhold      put the call on hold and resume it
+  dtmf <digits>     send the digits, as telephone-events or by INFO
+  transfer <uri>    transfer the call to the URI (blind, REFER with Target-Dialog)
+  quit
+
+Options:
+`)
+	flag.PrintDefaults()
+}
+
+// call the media of a session: its stream, the audio played and recorded, the telephone-event payload
+// type and the REFER it was made for, if any.
+type call struct {
+	session  *session.Session
+	stream   *rtp.Stream
+	dtmf     int
+	cancel   context.CancelFunc
+	recorder *media.Recorder
+	notifier *ua.Notifier
+}
+
+type phone struct {
+	userAgent *ua.UserAgent
+	profile   *account.Profile
+	recipient sip.SipUri
+	host      string
+	ports     *rtp.PortRange
+	play      string
+	record    string
+	auto      bool
+
+	mu       sync.Mutex
+	calls    map[*session.Session]*call
+	current  *session.Session
+	incoming *session.Session
+}
+
+func main() {
+	listen := flag.String("listen", "0.0.0.0:5062", "address the phone listens on")
+	transport := flag.String("transport", "udp", "transport of the calls, udp, tcp or tls")
+	server := flag.String("server", "", "URI of the registrar and proxy, e.g. sip:pbx.example.com, the domain of the account if empty")
+	user := flag.String("user", "", "user of the digest authentication, the user of the account if empty")
+	password := flag.String("password", "", "password of the digest authentication, none if empty")
+	expires := flag.Uint("expires", 600, "expiration of the registration, not registered if 0")
+	play := flag.String("play", "", "8000 Hz mono WAV file played to the calls in a loop, silence if empty")
+	record := flag.String("record", "", "directory the audio of the calls is recorded to, by Call-ID, none if empty")
+	auto := flag.Bool("auto-answer", false, "answer the calls received")
+	debug := flag.Bool("debug", false, "print the logs of the stack and of the user agent")
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() != 1 {
+		usage()
+		os.Exit(2)
+	}
+
+	if !*debug {
+		utils.SetLogSink(utils.LogSinkFunc(func(level log.Level, msg string, fields log.Fields) {
+			if level <= log.WarnLevel {
+				fmt.Fprintf(os.Stderr, "%s %v\n", msg, fields)
+			}
+		}))
+	}
+
+	aor, err := parser.ParseSipUri(flag.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "account URI: %v\n", err)
+		os.Exit(2)
+	}
+	if *server == "" {
+		*server = "sip:" + aor.Host()
+		if aor.FPort != nil {
+			*server += fmt.Sprintf(":%d", *aor.FPort)
+		}
+	}
+	recipient, err := parser.ParseSipUri(*server)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "server URI: %v\n", err)
+		os.Exit(2)
+	}
+	host, _, err := net.SplitHostPort(*listen)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "listen: %v\n", err)
+		os.Exit(2)
+	}
+
+	stackConfig := &stack.SipStackConfig{UserAgent: "Go Sip Client/example-softphone", Extensions: []string{"replaces", "tdialog"}}
+	if ip := net.ParseIP(host); ip != nil && !ip.IsUnspecified() {
+		stackConfig.Host = host
+	}
+	sipStack := stack.NewSipStack(stackConfig)
+	if err := sipStack.Listen(*transport, *listen); err != nil {
+		fmt.Fprintf(os.Stderr, "listen: %v\n", err)
+		os.Exit(1)
+	}
+	userAgent, err := ua.NewUserAgent(ua.WithSipStack(sipStack))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "user agent: %v\n", err)
+		os.Exit(1)
+	}
+	defer userAgent.Shutdown()
+
+	var authInfo *account.AuthInfo
+	if *password != "" {
+		authUser := *user
+		if authUser == "" && aor.FUser != nil {
+			authUser = aor.FUser.String()
+		}
+		authInfo = &account.AuthInfo{AuthUser: authUser, Password: *password}
+	}
+	p := &phone{
+		userAgent: userAgent,
+		profile:   account.NewProfile(&aor, "softphone", authInfo, uint32(*expires), sipStack),
+		recipient: recipient,
+		// The media are sent from the address of the Contact.
+		host:   sipStack.GetNetworkInfo(*transport).Host,
+		ports:  rtp.NewPortRange(rtp.DefaultPortMin, rtp.DefaultPortMax),
+		play:   *play,
+		record: *record,
+		auto:   *auto,
+		calls:  make(map[*session.Session]*call),
+	}
+	userAgent.InviteStateHandler = p.handleState
+	userAgent.RegisterStateHandler = func(state account.RegisterState) {
+		fmt.Printf("registration: %d %s, expires in %ds\n", state.StatusCode, state.Reason, state.Expiration)
+	}
+	userAgent.OnRefer(p.handleRefer)
+
+	var register *ua.Register
+	if *expires > 0 {
+		if register, err = userAgent.SendRegister(p.profile, recipient, uint32(*expires), nil); err != nil {
+			fmt.Fprintf(os.Stderr, "register: %v\n", err)
+		}
+	}
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGTERM, syscall.SIGINT)
+	commands := make(chan string)
+	go func() {
+		scanner := bufio.NewScanner(os.Stdin)
+		for scanner.Scan() {
+			commands <- scanner.Text()
+		}
+		close(commands)
+	}()
+
+	fmt.Printf("%s ready, type help for the commands\n", aor.String())
+loop:
+	for {
+		select {
+		case <-stop:
+			break loop
+		case line, ok := <-commands:
+			if !ok || !p.command(line) {
+				break loop
+			}
+		}
+	}
+
+	p.mu.Lock()
+	sessions := make([]*session.Session, 0, len(p.calls))
+	for is := range p.calls {
+		sessions = append(sessions, is)
+	}
+	p.mu.Unlock()
+	for _, is := range sessions {
+		is.End()
+	}
+	if register != nil {
+		register.SendRegister(0)
+	}
+}
+
+// command runs a command line, false to quit.
+func (p *phone) command(line string) bool {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return true
+	}
+	arg := ""
+	if len(fields) > 1 {
+		arg = fields[1]
+	}
+
+	var err error
+	switch fields[0] {
+	case "call":
+		err = p.call(arg, nil)
+	case "answer":
+		err = p.answer()
+	case "reject":
+		if is := p.take(&p.incoming); is != nil {
+			is.Reject(486, "Busy Here")
+		} else {
+			err = fmt.Errorf("no incoming call")
+		}
+	case "hangup":
+		err = p.withCurrent(func(is *session.Session) error { return is.End() })
+	case "hold":
+		err = p.withCurrent(func(is *session.Session) error { return is.Hold() })
+	case "unhold":
+		err = p.withCurrent(func(is *session.Session) error { return is.Unhold() })
+	case "dtmf":
+		err = p.withCurrent(func(is *session.Session) error { return p.sendDTMF(is, arg) })
+	case "transfer":
+		err = p.withCurrent(func(is *session.Session) error { return p.transfer(is, arg) })
+	case "quit", "exit":
+		return false
+	case "help":
+		usage()
+	default:
+		err = fmt.Errorf("unknown command %q, type help for the commands", fields[0])
+	}
+	if err != nil {
+		fmt.Printf("%s: %v\n", fields[0], err)
+	}
+	return true
+}
+
+// target the URI of a command, a user of the domain of the account if it has no scheme.
+func (p *phone) target(arg string) (sip.Uri, error) {
+	if arg == "" {
+		return nil, fmt.Errorf("no URI")
+	}
+	if !strings.Contains(arg, ":") {
+		arg = "sip:" + arg + "@" + p.profile.URI.Host()
+	}
+	return parser.ParseUri(arg)
+}
+
+// call calls arg with an offer of PCMU, PCMA and telephone-event, for the REFER of n if any.
+func (p *phone) call(arg string, n *ua.Notifier) error {
+	target, err := p.target(arg)
+	if err != nil {
+		return err
+	}
+	// The stream is opened before the INVITE for its port, and added to the session once created to be
+	// closed with it.
+	stream, err := rtp.NewStream(p.ports, p.host, 8000)
+	if err != nil {
+		return err
+	}
+	offer := media.NewSDP(p.host, media.NewAudio(stream.LocalAddr().Port, media.PCMU, media.PCMA, media.TelephoneEvent))
+	is, err := p.userAgent.InviteWithOffer(context.Background(), p.profile, target, p.recipient, offer)
+	if err != nil {
+		stream.Close()
+		return err
+	}
+	is.AddStream(stream)
+
+	p.mu.Lock()
+	p.calls[is] = &call{session: is, stream: stream, notifier: n}
+	p.current = is
+	p.mu.Unlock()
+	if is.IsEnded() {
+		// Failed before it was added, e.g. with a transport error.
+		p.mu.Lock()
+		delete(p.calls, is)
+		p.current = nil
+		p.mu.Unlock()
+		return fmt.Errorf("call to %s failed", target)
+	}
+	fmt.Printf("calling %s\n", target)
+	return nil
+}
+
+// answer answers the incoming call with the codecs of the offer the phone supports.
+func (p *phone) answer() error {
+	is := p.take(&p.incoming)
+	if is == nil {
+		return fmt.Errorf("no incoming call")
+	}
+	offer, err := is.ParseRemoteSdp()
+	if err != nil || offer == nil {
+		is.Reject(488, "Not Acceptable Here")
+		return fmt.Errorf("offer: %v", err)
+	}
+	stream, err := p.userAgent.NewStream(is, p.host, 8000)
+	if err != nil {
+		is.Reject(500, "Server Internal Error")
+		return err
+	}
+	answer := media.Answer(offer, p.host, media.NewAudio(stream.LocalAddr().Port, media.PCMU, media.PCMA, media.TelephoneEvent))
+
+	p.mu.Lock()
+	c := &call{session: is, stream: stream}
+	p.calls[is] = c
+	p.current = is
+	p.mu.Unlock()
+
+	is.ProvideAnswer(answer.String())
+	is.Accept(200)
+	p.startMedia(c, offer)
+	return nil
+}
+
+// take the session of *field, cleared.
+func (p *phone) take(field **session.Session) *session.Session {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	is := *field
+	*field = nil
+	return is
+}
+
+func (p *phone) withCurrent(f func(is *session.Session) error) error {
+	p.mu.Lock()
+	is := p.current
+	p.mu.Unlock()
+	if is == nil {
+		return fmt.Errorf("no call")
+	}
+	return f(is)
+}
+
+func (p *phone) handleState(is *session.Session, req *sip.Request, resp *sip.Response, status session.Status) {
+	p.mu.Lock()
+	c := p.calls[is]
+	p.mu.Unlock()
+
+	switch status {
+	case session.InviteReceived:
+		fmt.Printf("incoming call from %s\n", is.RemoteURI().Uri)
+		p.mu.Lock()
+		p.incoming = is
+		p.mu.Unlock()
+		if p.auto {
+			if err := p.answer(); err != nil {
+				fmt.Printf("answer: %v\n", err)
+			}
+		}
+	case session.ReInviteReceived:
+		// The hold of the remote or a new offer: answered with the stream of the call.
+		if c == nil || c.stream == nil {
+			is.Reject(488, "Not Acceptable Here")
+			return
+		}
+		offer, err := is.ParseRemoteSdp()
+		if err != nil || offer == nil {
+			is.Reject(488, "Not Acceptable Here")
+			return
+		}
+		is.ProvideAnswer(media.Answer(offer, p.host, media.NewAudio(c.stream.LocalAddr().Port, media.PCMU, media.PCMA, media.TelephoneEvent)).String())
+		is.Accept(200)
+	case session.Provisional:
+		if resp != nil && *resp != nil {
+			fmt.Printf("%d %s\n", (*resp).StatusCode(), (*resp).Reason())
+		}
+	case session.Confirmed:
+		if c == nil || c.cancel != nil {
+			return
+		}
+		fmt.Printf("connected to %s\n", is.RemoteURI().Uri)
+		if is.Direction() == session.Outgoing {
+			if answer, err := is.ParseRemoteSdp(); err == nil && answer != nil {
+				p.startMedia(c, answer)
+			}
+			if c.notifier != nil {
+				c.notifier.NotifyReferStatus(200, "OK")
+			}
+		}
+	case session.Failure, session.Canceled, session.Terminated:
+		reason := string(status)
+		if rc := is.ReleaseCause(); rc != nil {
+			reason = string(rc.Cause)
+			if rc.StatusCode != 0 {
+				reason = fmt.Sprintf("%d %s", rc.StatusCode, rc.Text)
+			}
+		}
+		fmt.Printf("call with %s ended: %s\n", is.RemoteURI().Uri, reason)
+		p.mu.Lock()
+		delete(p.calls, is)
+		if p.current == is {
+			p.current = nil
+		}
+		if p.incoming == is {
+			p.incoming = nil
+		}
+		p.mu.Unlock()
+		if c == nil {
+			return
+		}
+		p.stopMedia(c)
+		if c.notifier != nil && status != session.Terminated {
+			code, reason := sip.StatusCode(487), "Request Terminated"
+			if resp != nil && *resp != nil {
+				code, reason = (*resp).StatusCode(), (*resp).Reason()
+			}
+			c.notifier.NotifyReferStatus(code, reason)
+		}
+	}
+}
+
+// startMedia sends the audio of the call to the remote media of description, the first audio one, plays
+// the WAV file and records the audio received.
+func (p *phone) startMedia(c *call, description *sdp.Session) {
+	var audio *sdp.Media
+	for _, m := range description.Media {
+		if m.Type == "audio" && m.Port != 0 {
+			audio = m
+			break
+		}
+	}
+	if audio == nil {
+		fmt.Println("no audio in the session description")
+		return
+	}
+	addr, err := media.RTPAddr(description, audio)
+	if err != nil {
+		fmt.Printf("audio: %v\n", err)
+		return
+	}
+	c.stream.SetRemote(addr)
+
+	// The codec of the call, the first G.711 one of the answer or the offer.
+	payloadType := -1
+	for _, format := range audio.Format {
+		if format.Payload == media.PCMU.Payload || format.Payload == media.PCMA.Payload {
+			payloadType = int(format.Payload)
+			break
+		}
+	}
+	c.dtmf = -1
+	if pt, ok := media.TelephoneEventPayload(audio); ok {
+		c.dtmf = int(pt)
+		c.session.ReceiveDTMF(c.stream, pt)
+		c.session.OnDTMF(func(event rtp.DTMFEvent) {
+			fmt.Printf("DTMF %c\n", event.Digit)
+		})
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	p.mu.Lock()
+	c.cancel = cancel
+	p.mu.Unlock()
+	if p.record != "" {
+		callID := c.session.CallID().Value()
+		recorder, err := media.RecordWAV(c.stream, filepath.Join(p.record, callID+".wav"))
+		if err != nil {
+			fmt.Printf("record: %v\n", err)
+		} else {
+			p.mu.Lock()
+			c.recorder = recorder
+			p.mu.Unlock()
+		}
+	}
+	if p.play != "" && payloadType >= 0 {
+		go func() {
+			for ctx.Err() == nil {
+				if err := media.PlayWAV(ctx, c.stream, uint8(payloadType), p.play); err != nil && ctx.Err() == nil {
+					fmt.Printf("play: %v\n", err)
+					return
+				}
+			}
+		}()
+	}
+}
+
+func (p *phone) stopMedia(c *call) {
+	p.mu.Lock()
+	cancel, recorder := c.cancel, c.recorder
+	c.recorder = nil
+	p.mu.Unlock()
+	if cancel != nil {
+		cancel()
+	}
+	if recorder != nil {
+		recorder.Close()
+	}
+}
+
+// sendDTMF sends digits as telephone-events if the call negotiated them, by INFO else.
+func (p *phone) sendDTMF(is *session.Session, digits string) error {
+	if digits == "" {
+		return fmt.Errorf("no digits")
+	}
+	p.mu.Lock()
+	dtmf := -1
+	if c, ok := p.calls[is]; ok && c.cancel != nil {
+		dtmf = c.dtmf
+	}
+	p.mu.Unlock()
+	for _, digit := range digits {
+		if dtmf >= 0 {
+			if err := is.SendDTMF(digit, 0, uint8(dtmf)); err != nil {
+				return err
+			}
+			continue
+		}
+		is.Info(fmt.Sprintf("Signal=%c\r\nDuration=160\r\n", digit), "application/dtmf-relay")
+		time.Sleep(100 * time.Millisecond)
+	}
+	return nil
+}
+
+// transfer asks the remote of the call to call arg with a REFER out of the dialog, its Target-Dialog the
+// call (RFC 4538), and hangs the call up once the remote reached arg.
+func (p *phone) transfer(is *session.Session, arg string) error {
+	target, err := p.target(arg)
+	if err != nil {
+		return err
+	}
+	remote := is.RemoteURI()
+	_, err = p.userAgent.Refer(p.profile, remote.Uri, p.recipient, target, func(status ua.SubscriptionStatus) {
+		code, reason, err := ua.ParseSipFrag(status.Body)
+		if err != nil {
+			return
+		}
+		fmt.Printf("transfer to %s: %d %s\n", target, code, reason)
+		if code >= 200 && code < 300 && !is.IsEnded() {
+			is.End()
+		}
+	}, nil, is.NewTargetDialogHeader())
+	return err
+}
+
+// handleRefer follows the transfer of a call: the current call is hung up and the target called, the
+// progress notified to the referrer.
+func (p *phone) handleRefer(n *ua.Notifier, referTo sip.Address, referredBy *sip.Address) {
+	by := "the remote"
+	if referredBy != nil {
+		by = referredBy.String()
+	}
+	fmt.Printf("transferred to %s by %s\n", referTo.Uri, by)
+	n.NotifyReferStatus(100, "Trying")
+	p.withCurrent(func(is *session.Session) error { return is.End() })
+	if err := p.call(referTo.Uri.String(), n); err != nil {
+		fmt.Printf("transfer: %v\n", err)
+		n.NotifyReferStatus(503, "Service Unavailable")
+	}
+}
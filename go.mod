@@ -6,7 +6,9 @@ require (
 	cloud.google.com/go/firestore v1.5.0 // indirect
 	firebase.google.com/go v3.13.0+incompatible
 	github.com/c-bata/go-prompt v0.2.6
+	github.com/discoviking/fsm v0.0.0-20150126104936-f4a273feecca
 	github.com/ghettovoice/gosip v0.0.0-20210621140811-94442dfb3c1d
+	github.com/gobwas/ws v1.1.0-rc.1
 	github.com/google/uuid v1.2.0
 	github.com/kr/pretty v0.2.0 // indirect
 	github.com/mgutz/ansi v0.0.0-20200706080929-d51e80ef957d // indirect
@@ -15,6 +17,7 @@ require (
 	github.com/tevino/abool v1.2.0
 	github.com/x-cray/logrus-prefixed-formatter v0.5.2
 	golang.org/x/crypto v0.0.0-20200622213623-75b288015ac9
+	golang.org/x/sys v0.0.0-20210320140829-1e4c9ba3b0c4
 	google.golang.org/api v0.43.0
 	gopkg.in/check.v1 v1.0.0-20190902080502-41f04d3bba15 // indirect
 )
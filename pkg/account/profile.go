@@ -2,11 +2,16 @@ package account
 
 import (
 	"fmt"
+	"net"
+	"strconv"
+	"strings"
 
 	"github.com/ghettovoice/gosip/log"
 	"github.com/ghettovoice/gosip/sip"
-	"github.com/ghettovoice/gosip/sip/parser"
 	"github.com/google/uuid"
+	"github.com/sergeyu/go-sip-ua/pkg/auth"
+	"github.com/sergeyu/go-sip-ua/pkg/media"
+	"github.com/sergeyu/go-sip-ua/pkg/media/rtp"
 	"github.com/sergeyu/go-sip-ua/pkg/stack"
 	"github.com/sergeyu/go-sip-ua/pkg/utils"
 )
@@ -16,7 +21,7 @@ var (
 )
 
 func init() {
-	logger = utils.NewLogrusLogger(log.DebugLevel, "UserAgent", nil)
+	logger = utils.NewLogger(log.DebugLevel, "UserAgent", nil)
 }
 
 //AuthInfo .
@@ -25,10 +30,16 @@ type AuthInfo struct {
 	Realm    string
 	Password string
 	Ha1      string
+	// AuthInt prefers qop=auth-int when the server offers it with auth.
+	AuthInt bool
+	// CredentialProvider returns the password for the realm of a challenge, Password is used if nil.
+	CredentialProvider auth.CredentialProvider
 }
 
 // Profile .
 type Profile struct {
+	// URI the address of record of the account, the From of its requests unless a call has its own,
+	// independent of the targets called, e.g. in other domains.
 	URI           sip.Uri
 	DisplayName   string
 	AuthInfo      *AuthInfo
@@ -37,6 +48,44 @@ type Profile struct {
 	Routes        []sip.Uri
 	ContactURI    sip.Uri
 	ContactParams map[string]string
+	// PublicAddress host[:port] of the Contact instead of the one of ContactURI, e.g. the address a NAT
+	// forwards for the account. The port of ContactURI if it has none.
+	PublicAddress string
+	// OutboundDomain the host of the SIP URIs the tel: targets are translated to, e.g. the one of a carrier
+	// trunk, the host of the recipient if empty.
+	OutboundDomain string
+	// UserPhone adds user=phone to the SIP targets of telephone numbers, as carrier trunks commonly require.
+	UserPhone bool
+	// PreferredIdentity sent as P-Preferred-Identity to the trusted proxy (RFC 3325).
+	PreferredIdentity *sip.Address
+	// Privacy values sent in the Privacy header, e.g. "id" (RFC 3323).
+	Privacy []string
+	// ResourcePriority values sent in the Resource-Priority header, e.g. "ets.0" (RFC 4412).
+	ResourcePriority []string
+	// Credentials the AuthUser and the Password of each realm, e.g. of an outbound proxy and of the registrar,
+	// AuthInfo answers the challenges of the other realms.
+	Credentials map[string]*AuthInfo
+	// Authorizer answers the challenges of the requests, e.g. auth.NewBearerAuthorizer, a digest one from AuthInfo if nil.
+	Authorizer auth.Authorizer
+	// SRTP the encryption of the media offered by InviteWithOffer and answered with media.AnswerSRTP.
+	SRTP media.SRTPPolicy
+	// Codecs the codecs offered and answered by the application, media.DefaultCodecPolicy if nil.
+	Codecs *media.CodecPolicy
+	// RTPPorts the ports of the media streams of the calls of the profile, the ones of the UA if nil,
+	// e.g. the range a firewall opens for it.
+	RTPPorts *rtp.PortRange
+	// RTPAddress the address the media streams of the profile are bound on, the one of the call if empty.
+	RTPAddress string
+	// RTPOptions the options of the sockets of the media streams of the profile, the ones of the UA if nil.
+	RTPOptions *rtp.SocketOptions
+}
+
+// CodecPolicy the codecs of the profile, media.DefaultCodecPolicy if it has none.
+func (p *Profile) CodecPolicy() *media.CodecPolicy {
+	if p.Codecs == nil {
+		return media.DefaultCodecPolicy
+	}
+	return p.Codecs
 }
 
 // Contact .
@@ -47,6 +96,20 @@ func (p *Profile) Contact() *sip.Address {
 	} else {
 		uri = p.URI.Clone()
 	}
+	if p.PublicAddress != "" {
+		uri = uri.Clone()
+		host, port, err := net.SplitHostPort(p.PublicAddress)
+		if err != nil {
+			host = p.PublicAddress
+		} else if n, err := strconv.Atoi(port); err == nil {
+			public := sip.Port(n)
+			uri.SetPort(&public)
+		}
+		if strings.Contains(host, ":") && !strings.HasPrefix(host, "[") {
+			host = "[" + host + "]"
+		}
+		uri.SetHost(host)
+	}
 
 	contact := &sip.Address{
 		Uri:    uri,
@@ -57,6 +120,11 @@ func (p *Profile) Contact() *sip.Address {
 	}
 
 	for key, value := range p.ContactParams {
+		// The feature tags without value are flags, e.g. +sip.src (RFC 7866).
+		if value == "" {
+			contact.Params.Add(key, nil)
+			continue
+		}
 		contact.Params.Add(key, sip.String{Str: value})
 	}
 
@@ -84,15 +152,20 @@ func NewProfile(
 		var transport string
 		if tp, ok := uri.UriParams().Get("transport"); ok {
 			transport = tp.String()
+		} else if uri.IsEncrypted() {
+			transport = "tls"
 		} else {
 			transport = "udp"
 		}
+		// Not parsed from a string, the parser does not take an IPv6 host.
 		addr := stack.GetNetworkInfo(transport)
-		uri, err := parser.ParseUri(fmt.Sprintf("sip:%s@%s;transport=%s", p.URI.User(), addr.Addr(), transport))
-		if err == nil {
-			p.ContactURI = uri
-		} else {
-			logger.Errorf("Error parsing contact URI: %s", err)
+		p.ContactURI = &sip.SipUri{
+			FIsEncrypted: uri.IsEncrypted(),
+			FUser:        p.URI.User(),
+			FHost:        addr.Host,
+			FPort:        addr.Port,
+			FUriParams:   sip.NewParams().Add("transport", sip.String{Str: transport}),
+			FHeaders:     sip.NewParams(),
 		}
 	}
 
@@ -0,0 +1,234 @@
+package account
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ghettovoice/gosip/sip"
+	"github.com/ghettovoice/gosip/sip/parser"
+	"github.com/sergeyu/go-sip-ua/pkg/utils"
+)
+
+// TelURI a telephone number URI (RFC 3966), e.g. tel:+1-201-555-0123 or tel:7042;phone-context=example.com,
+// a target of Invite translated to a SIP URI by the profile, see Profile.Target.
+type TelURI struct {
+	// Number the number without its visual separators, global with a leading +.
+	Number string
+	Params sip.Params
+}
+
+// ParseTelURI parses a tel: URI, a local number requires a phone-context.
+func ParseTelURI(uri string) (*TelURI, error) {
+	if len(uri) < 4 || !strings.EqualFold(uri[:4], "tel:") {
+		return nil, fmt.Errorf("not a tel: URI %s", uri)
+	}
+	parts := strings.Split(uri[4:], ";")
+	number := stripVisualSeparators(parts[0])
+	params := sip.NewParams()
+	for _, param := range parts[1:] {
+		if param == "" {
+			continue
+		}
+		if i := strings.Index(param, "="); i >= 0 {
+			params.Add(strings.ToLower(param[:i]), sip.String{Str: param[i+1:]})
+		} else {
+			params.Add(strings.ToLower(param), nil)
+		}
+	}
+	if !isTelephoneNumber(number) {
+		return nil, fmt.Errorf("invalid telephone number %s", parts[0])
+	}
+	if !strings.HasPrefix(number, "+") && !params.Has("phone-context") {
+		return nil, fmt.Errorf("local number %s without phone-context", parts[0])
+	}
+	return &TelURI{Number: number, Params: params}, nil
+}
+
+// ParseTarget parses the tel: URIs as well as the SIP ones, e.g. the targets of Invite entered by a user.
+func ParseTarget(uri string) (sip.Uri, error) {
+	if len(uri) >= 4 && strings.EqualFold(uri[:4], "tel:") {
+		return ParseTelURI(uri)
+	}
+	return parser.ParseUri(uri)
+}
+
+// IsGlobal whether the number is a global one, E.164 with a leading +.
+func (u *TelURI) IsGlobal() bool {
+	return strings.HasPrefix(u.Number, "+")
+}
+
+// SipURI the SIP URI of the number on host with user=phone, its parameters in the user part (RFC 3261 19.1.6),
+// escaped, e.g. the # of a local number.
+func (u *TelURI) SipURI(host string) sip.SipUri {
+	user := u.Number
+	if u.Params.Length() > 0 {
+		user += ";" + u.Params.ToString(';')
+	}
+	return sip.SipUri{
+		FUser:      sip.String{Str: utils.EscapeUser(user)},
+		FHost:      host,
+		FUriParams: sip.NewParams().Add("user", sip.String{Str: "phone"}),
+		FHeaders:   sip.NewParams(),
+	}
+}
+
+// String .
+func (u *TelURI) String() string {
+	uri := "tel:" + u.Number
+	if u.Params.Length() > 0 {
+		uri += ";" + u.Params.ToString(';')
+	}
+	return uri
+}
+
+// Equals .
+func (u *TelURI) Equals(other interface{}) bool {
+	if o, ok := other.(*TelURI); ok {
+		return u.Number == o.Number && u.Params.Equals(o.Params)
+	}
+	return false
+}
+
+// Clone .
+func (u *TelURI) Clone() sip.Uri {
+	return &TelURI{Number: u.Number, Params: u.Params.Clone()}
+}
+
+// User the number.
+func (u *TelURI) User() sip.MaybeString {
+	return sip.String{Str: u.Number}
+}
+
+// SetUser sets the number.
+func (u *TelURI) SetUser(user sip.MaybeString) {
+	if user != nil {
+		u.Number = stripVisualSeparators(user.String())
+	}
+}
+
+// UriParams .
+func (u *TelURI) UriParams() sip.Params {
+	return u.Params
+}
+
+// SetUriParams .
+func (u *TelURI) SetUriParams(params sip.Params) {
+	u.Params = params
+}
+
+// A tel: URI has no host, port, password or headers, their setters are no-ops.
+
+func (u *TelURI) IsEncrypted() bool                    { return false }
+func (u *TelURI) SetEncrypted(flag bool)               {}
+func (u *TelURI) Password() sip.MaybeString            { return nil }
+func (u *TelURI) SetPassword(password sip.MaybeString) {}
+func (u *TelURI) Host() string                         { return "" }
+func (u *TelURI) SetHost(host string)                  {}
+func (u *TelURI) Port() *sip.Port                      { return nil }
+func (u *TelURI) SetPort(port *sip.Port)               {}
+func (u *TelURI) Headers() sip.Params                  { return sip.NewParams() }
+func (u *TelURI) SetHeaders(params sip.Params)         {}
+func (u *TelURI) IsWildcard() bool                     { return false }
+
+// Target the To and the Request-URI of a call to target sent to recipient. A tel: target is translated to
+// a SIP URI of the OutboundDomain, its number the user of recipient, or recipient itself if it has no host.
+// With UserPhone, the SIP target and recipient of a telephone number get user=phone.
+func (p *Profile) Target(target sip.Uri, recipient sip.SipUri) (sip.Uri, sip.SipUri, error) {
+	if tel, ok := target.(*TelURI); ok {
+		domain := p.OutboundDomain
+		if domain == "" {
+			domain = recipient.FHost
+		}
+		if domain == "" {
+			return nil, recipient, fmt.Errorf("no OutboundDomain to call %s", tel)
+		}
+		to := tel.SipURI(domain)
+		if recipient.FHost == "" {
+			return &to, to, nil
+		}
+		recipient = UserPhone(recipient)
+		recipient.FUser = to.FUser
+		return &to, recipient, nil
+	}
+	if !p.UserPhone {
+		return target, recipient, nil
+	}
+	if uri, ok := target.(*sip.SipUri); ok && isDialString(uri.FUser) {
+		to := UserPhone(*uri)
+		target = &to
+	}
+	if isDialString(recipient.FUser) {
+		recipient = UserPhone(recipient)
+	}
+	return target, recipient, nil
+}
+
+// GlobalNumber the E.164 number of uri, a global tel: URI or a SIP URI with a global number as user,
+// e.g. to look it up in ENUM.
+func GlobalNumber(uri sip.Uri) (string, bool) {
+	switch uri := uri.(type) {
+	case *TelURI:
+		return uri.Number, uri.IsGlobal()
+	case *sip.SipUri:
+		if !isDialString(uri.FUser) {
+			return "", false
+		}
+		number := stripVisualSeparators(uri.FUser.String())
+		return number, strings.HasPrefix(number, "+") && !strings.ContainsAny(number, "*#")
+	}
+	return "", false
+}
+
+// UserPhone a copy of uri with user=phone, its user a telephone number for the carriers requiring it.
+func UserPhone(uri sip.SipUri) sip.SipUri {
+	phone := uri.Clone().(*sip.SipUri)
+	if phone.FUriParams == nil {
+		phone.FUriParams = sip.NewParams()
+	}
+	phone.FUriParams.Add("user", sip.String{Str: "phone"})
+	return *phone
+}
+
+// isTelephoneNumber whether number, without its visual separators, is a global number or a local one.
+func isTelephoneNumber(number string) bool {
+	digits := strings.TrimPrefix(number, "+")
+	if digits == "" {
+		return false
+	}
+	for _, c := range digits {
+		switch {
+		case c >= '0' && c <= '9':
+		case number[0] != '+' && strings.ContainsRune("*#ABCDEFabcdef", c):
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// isDialString whether user is a telephone number dialed, digits with an optional leading +, * and #.
+func isDialString(user sip.MaybeString) bool {
+	if user == nil {
+		return false
+	}
+	number := strings.TrimPrefix(stripVisualSeparators(user.String()), "+")
+	if number == "" {
+		return false
+	}
+	for _, c := range number {
+		if (c < '0' || c > '9') && c != '*' && c != '#' {
+			return false
+		}
+	}
+	return true
+}
+
+// stripVisualSeparators removes the visual separators of a number, e.g. +1-201-555-0123 (RFC 3966 5.1.1).
+func stripVisualSeparators(number string) string {
+	return strings.Map(func(r rune) rune {
+		if strings.ContainsRune("-.()", r) {
+			return -1
+		}
+		return r
+	}, number)
+}
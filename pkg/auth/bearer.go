@@ -0,0 +1,61 @@
+package auth
+
+import (
+	"fmt"
+
+	"github.com/ghettovoice/gosip/sip"
+)
+
+// TokenSource returns the OAuth 2.0 access token of a BearerAuthorizer, called for each request
+// so a token expired can be refreshed.
+type TokenSource func() (string, error)
+
+// BearerAuthorizer authorizes the requests with an access token (RFC 8898), sent with every request.
+type BearerAuthorizer struct {
+	token TokenSource
+}
+
+// NewBearerAuthorizer .
+func NewBearerAuthorizer(token TokenSource) *BearerAuthorizer {
+	return &BearerAuthorizer{
+		token: token,
+	}
+}
+
+// PreauthorizeRequest adds the token to request.
+func (auth *BearerAuthorizer) PreauthorizeRequest(request sip.Request) bool {
+	token, err := auth.token()
+	if err != nil || token == "" {
+		return false
+	}
+	setAuthorization(request, "Authorization", "Bearer "+token)
+	return true
+}
+
+// AuthorizeRequest answers the Bearer challenge of response with the token.
+func (auth *BearerAuthorizer) AuthorizeRequest(request sip.Request, response sip.Response) error {
+	authorizeHeaderNames := make([]string, 0, len(authHeaders))
+	for _, names := range authHeaders {
+		if _, ok := findChallenge(response, names.authenticate, "Bearer"); ok {
+			authorizeHeaderNames = append(authorizeHeaderNames, names.authorize)
+		}
+	}
+	if len(authorizeHeaderNames) == 0 {
+		return fmt.Errorf("authorize request: no Bearer challenge in response %d", response.StatusCode())
+	}
+
+	token, err := auth.token()
+	if err != nil {
+		return fmt.Errorf("authorize request: get token: %w", err)
+	}
+
+	for _, name := range authorizeHeaderNames {
+		setAuthorization(request, name, "Bearer "+token)
+	}
+	prepareRetry(request)
+
+	return nil
+}
+
+// Accepted .
+func (auth *BearerAuthorizer) Accepted(request sip.Request) {}
@@ -0,0 +1,109 @@
+package auth
+
+import (
+	"sync"
+
+	"github.com/ghettovoice/gosip/sip"
+)
+
+// cachedChallenge a challenge answered for a user and a destination, of a proxy or of the UAS.
+type cachedChallenge struct {
+	auth       *Authorization
+	headerName string
+	// accepted once a request authorized with it succeeded, only then it is used preemptively.
+	accepted bool
+}
+
+// cachedChallenges the challenges answered for a user and a destination, one per header and realm.
+type cachedChallenges struct {
+	mu         sync.Mutex
+	challenges []*cachedChallenge
+}
+
+// CredentialCache the challenges answered by user and destination. The nonce of the last one accepted
+// is reused to authorize the next requests preemptively, with the nonce count incremented (RFC 2617 3.2.2),
+// until the server challenges again, e.g. with stale=true.
+type CredentialCache struct {
+	challenges sync.Map
+}
+
+// NewCredentialCache .
+func NewCredentialCache() *CredentialCache {
+	return &CredentialCache{}
+}
+
+// destination the host the request is sent to, its first route or its recipient.
+func destination(request sip.Request) string {
+	if hdrs := request.GetHeaders("Route"); len(hdrs) > 0 {
+		if route, ok := hdrs[0].(*sip.RouteHeader); ok && len(route.Addresses) > 0 {
+			return route.Addresses[0].Host()
+		}
+	}
+	return request.Recipient().Host()
+}
+
+func cacheKey(user string, request sip.Request) string {
+	return user + "|" + destination(request)
+}
+
+func (c *CredentialCache) load(user string, request sip.Request) *cachedChallenges {
+	v, _ := c.challenges.LoadOrStore(cacheKey(user, request), &cachedChallenges{})
+	return v.(*cachedChallenges)
+}
+
+// answered stores the challenges answered for request, replacing the ones of the same header and realm.
+func (c *CredentialCache) answered(user string, request sip.Request, answers []answer) {
+	cached := c.load(user, request)
+	cached.mu.Lock()
+	defer cached.mu.Unlock()
+	for _, answer := range answers {
+		challenge := &cachedChallenge{auth: answer.auth, headerName: answer.headerName}
+		replaced := false
+		for i, other := range cached.challenges {
+			if other.headerName == answer.headerName && other.auth.realm == answer.auth.realm {
+				cached.challenges[i] = challenge
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			cached.challenges = append(cached.challenges, challenge)
+		}
+	}
+}
+
+// accepted marks the challenges answered for request as accepted by the servers.
+func (c *CredentialCache) accepted(user string, request sip.Request) {
+	cached := c.load(user, request)
+	cached.mu.Lock()
+	defer cached.mu.Unlock()
+	for _, challenge := range cached.challenges {
+		for _, hdr := range request.GetHeaders(challenge.headerName) {
+			params := parseAuthHeader(hdr.Value())
+			if nonce, ok := params.Get("nonce"); ok && nonce.String() == challenge.auth.nonce &&
+				authRealm(params) == challenge.auth.realm {
+				challenge.accepted = true
+			}
+		}
+	}
+}
+
+// preauthorize adds the credentials of the challenges accepted to request.
+func (c *CredentialCache) preauthorize(user string, request sip.Request) bool {
+	cached := c.load(user, request)
+	cached.mu.Lock()
+	defer cached.mu.Unlock()
+	added := false
+	for _, challenge := range cached.challenges {
+		if !challenge.accepted {
+			continue
+		}
+		challenge.auth.
+			SetMethod(string(request.Method())).
+			SetUri(request.Recipient().String()).
+			CalcResponse(request)
+		setAuthorization(request, challenge.headerName, challenge.auth.String())
+		added = true
+	}
+	return added
+}
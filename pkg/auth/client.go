@@ -2,7 +2,6 @@ package auth
 
 import (
 	"fmt"
-	"regexp"
 	"strings"
 
 	"github.com/ghettovoice/gosip/sip"
@@ -41,8 +40,7 @@ func AuthFromValue(value string) *Authorization {
 		other:     make(map[string]string),
 	}
 
-	re := regexp.MustCompile(`([\w]+)=("([^"]+)"|([\w]+))`)
-	matches := re.FindAllStringSubmatch(value, -1)
+	matches := authParamRegexp.FindAllStringSubmatch(value, -1)
 	for _, match := range matches {
 		value2 := strings.Replace(match[2], "\"", "", -1)
 		switch match[1] {
@@ -68,6 +66,30 @@ func AuthFromValue(value string) *Authorization {
 	return auth
 }
 
+// SelectQop picks the qop of the response from the ones offered by the challenge,
+// auth-int when it is preferred or the only one offered.
+func (auth *Authorization) SelectQop(authInt bool) *Authorization {
+	hasAuth, hasAuthInt := false, false
+	for _, qop := range strings.Split(auth.qop, ",") {
+		switch strings.TrimSpace(qop) {
+		case "auth":
+			hasAuth = true
+		case "auth-int":
+			hasAuthInt = true
+		}
+	}
+	switch {
+	case hasAuthInt && (authInt || !hasAuth):
+		auth.qop = "auth-int"
+	case hasAuth:
+		auth.qop = "auth"
+	default:
+		auth.qop = ""
+	}
+
+	return auth
+}
+
 func (auth *Authorization) SetUsername(username string) *Authorization {
 	auth.username = username
 
@@ -95,14 +117,11 @@ func (auth *Authorization) SetPassword(password string) *Authorization {
 // calculates Authorization response https://www.ietf.org/rfc/rfc2617.txt
 func (auth *Authorization) CalcResponse(request sip.Request) *Authorization {
 	auth.nc += 1
-	hex := fmt.Sprintf("%x", auth.nc)
-	ncHex := "00000000"
-	auth.ncHex = ncHex[:len(ncHex)-1-len(hex)] + hex
 	// Nc-value = 8LHEX. Max value = 'FFFFFFFF'.
 	if auth.nc == 4294967296 {
 		auth.nc = 1
-		auth.ncHex = "00000001"
 	}
+	auth.ncHex = fmt.Sprintf("%08x", auth.nc)
 	// HA1 = MD5(A1) = MD5(username:realm:password).
 	ha1 := md5Hex(auth.username + ":" + auth.realm + ":" + auth.password)
 	if auth.qop == "auth" {
@@ -144,13 +163,9 @@ func (auth *Authorization) String() string {
 	}
 
 	if auth.qop != "" {
-		digest += fmt.Sprintf(`,qop="%s"`, auth.qop)
+		digest += fmt.Sprintf(`,qop=%s`, auth.qop)
 		digest += fmt.Sprintf(`,cnonce="%s"`, auth.cnonce)
-		digest += fmt.Sprintf(`,nc="%s"`, auth.ncHex)
-	}
-
-	if len(auth.stale) > 0 {
-		digest += fmt.Sprintf(`,stale=%s`, auth.stale)
+		digest += fmt.Sprintf(`,nc=%s`, auth.ncHex)
 	}
 
 	return digest
@@ -160,65 +175,239 @@ func AuthorizeRequest(request sip.Request, response sip.Response, user, password
 	if user == nil {
 		return fmt.Errorf("authorize request: user is nil")
 	}
+	_, err := authorizeRequest(request, response, func(realm string) (string, string, error) {
+		if password == nil {
+			return user.String(), "", nil
+		}
+		return user.String(), password.String(), nil
+	}, false)
+	return err
+}
 
-	var authenticateHeaderName, authorizeHeaderName string
-	if response.StatusCode() == 401 {
-		// on 401 Unauthorized increase request seq num, add Authorization header and send once again
-		authenticateHeaderName = "WWW-Authenticate"
-		authorizeHeaderName = "Authorization"
-	} else {
-		// 407 Proxy authentication
-		authenticateHeaderName = "Proxy-Authenticate"
-		authorizeHeaderName = "Proxy-Authorization"
+// answer the credentials of a challenge and the name of their header.
+type answer struct {
+	auth       *Authorization
+	headerName string
+}
+
+// authorizeRequest answers the challenges of response, one per realm, e.g. of each proxy on the path and of
+// the UAS aggregated by a forking proxy (RFC 3261 16.7), with qop=auth-int when authInt and offered. The user
+// and the password are asked to lookup for the realm of each challenge. The credentials of the other realms
+// are kept.
+func authorizeRequest(request sip.Request, response sip.Response, lookup func(realm string) (string, string, error), authInt bool) ([]answer, error) {
+	// on 401 Unauthorized or 407 Proxy authentication increase request seq num, add the credentials and send once again
+	answers := make([]answer, 0)
+	for _, names := range authHeaders {
+		for _, challenge := range findChallenges(response, names.authenticate, "Digest") {
+			auth := AuthFromValue(challenge).
+				SetMethod(string(request.Method())).
+				SetUri(request.Recipient().String()).
+				SelectQop(authInt)
+
+			user, password, err := lookup(auth.realm)
+			if err != nil {
+				return nil, fmt.Errorf("authorize request: %w", err)
+			}
+
+			auth.SetUsername(user).
+				SetPassword(password).
+				CalcResponse(request)
+			answers = append(answers, answer{auth: auth, headerName: names.authorize})
+		}
+	}
+	if len(answers) == 0 {
+		return nil, fmt.Errorf("authorize request: no Digest challenge in response %d", response.StatusCode())
+	}
+
+	for _, answer := range answers {
+		setAuthorization(request, answer.headerName, answer.auth.String())
+	}
+	prepareRetry(request)
+
+	return answers, nil
+}
+
+// authHeaders the names of the challenge headers and of the credentials headers answering them, of the
+// proxies and of the UAS. A 401 or a 407 may have both, aggregated by a forking proxy (RFC 3261 16.7).
+var authHeaders = []struct {
+	authenticate string
+	authorize    string
+}{
+	{"Proxy-Authenticate", "Proxy-Authorization"},
+	{"WWW-Authenticate", "Authorization"},
+}
+
+// findChallenge the parameters of the first challenge of scheme in the headers name of response.
+func findChallenge(response sip.Response, name string, scheme string) (string, bool) {
+	challenges := findChallenges(response, name, scheme)
+	if len(challenges) == 0 {
+		return "", false
 	}
+	return challenges[0], true
+}
 
-	if hdrs := response.GetHeaders(authenticateHeaderName); len(hdrs) > 0 {
-		authenticateHeader := hdrs[0].(*sip.GenericHeader)
-		auth := AuthFromValue(authenticateHeader.Contents).
-			SetMethod(string(request.Method())).
-			SetUri(request.Recipient().String()).
-			SetUsername(user.String())
+// findChallenges the parameters of the challenges of scheme in the headers name of response.
+func findChallenges(response sip.Response, name string, scheme string) []string {
+	challenges := make([]string, 0)
+	for _, hdr := range response.GetHeaders(name) {
+		contents := strings.TrimSpace(hdr.Value())
+		if len(contents) < len(scheme) || !strings.EqualFold(contents[:len(scheme)], scheme) {
+			continue
+		}
+		if params := contents[len(scheme):]; params == "" || params[0] == ' ' || params[0] == '\t' {
+			challenges = append(challenges, strings.TrimSpace(params))
+		}
+	}
+	return challenges
+}
 
-		if password != nil {
-			auth.SetPassword(password.String())
+// Stale reports whether a challenge of the 401 or 407 response is for a stale nonce (RFC 2617 3.2.1),
+// the credentials sent were right.
+func Stale(response sip.Response) bool {
+	for _, names := range authHeaders {
+		for _, hdr := range response.GetHeaders(names.authenticate) {
+			if stale, ok := parseAuthHeader(hdr.Value()).Get("stale"); ok && strings.EqualFold(stale.String(), "true") {
+				return true
+			}
 		}
+	}
+	return false
+}
 
-		auth.CalcResponse(request)
-
-		var authorizationHeader *sip.GenericHeader
-		hdrs = request.GetHeaders(authorizeHeaderName)
-		if len(hdrs) > 0 {
-			authorizationHeader = hdrs[0].(*sip.GenericHeader)
-			authorizationHeader.Contents = auth.String()
-		} else {
-			authorizationHeader = &sip.GenericHeader{
-				HeaderName: authorizeHeaderName,
-				Contents:   auth.String(),
+// Rejected reports whether request carries credentials for a realm challenged again by the 401 or 407 response
+// without stale=true, the credentials of the realm are wrong.
+func Rejected(request sip.Request, response sip.Response) bool {
+	for _, names := range authHeaders {
+		for _, hdr := range response.GetHeaders(names.authenticate) {
+			challenge := parseAuthHeader(hdr.Value())
+			if stale, ok := challenge.Get("stale"); ok && strings.EqualFold(stale.String(), "true") {
+				continue
+			}
+			for _, credentials := range request.GetHeaders(names.authorize) {
+				if authRealm(parseAuthHeader(credentials.Value())) == authRealm(challenge) {
+					return true
+				}
 			}
-			request.AppendHeader(authorizationHeader)
 		}
-	} else {
-		return fmt.Errorf("authorize request: header '%s' not found in response", authenticateHeaderName)
 	}
+	return false
+}
+
+// Challenge the scheme and the realm of a challenge of a 401 or 407 response, or of the credentials answering it.
+type Challenge struct {
+	Scheme string
+	Realm  string
+	// Stale the nonce of the credentials answering the challenge expired, they were right.
+	Stale bool
+}
+
+// Challenges the challenges of the 401 or 407 response, of the proxies and of the UAS.
+func Challenges(response sip.Response) []Challenge {
+	return append(parseChallenges(response.GetHeaders("Proxy-Authenticate")),
+		parseChallenges(response.GetHeaders("WWW-Authenticate"))...)
+}
+
+// Credentials the challenges answered by the credentials of request, of the proxies and of the UAS.
+func Credentials(request sip.Request) []Challenge {
+	return append(parseChallenges(request.GetHeaders("Proxy-Authorization")),
+		parseChallenges(request.GetHeaders("Authorization"))...)
+}
+
+func parseChallenges(hdrs []sip.Header) []Challenge {
+	challenges := make([]Challenge, 0, len(hdrs))
+	for _, hdr := range hdrs {
+		value := strings.TrimSpace(hdr.Value())
+		scheme := value
+		if i := strings.IndexAny(value, " \t"); i >= 0 {
+			scheme = value[:i]
+		}
+		params := parseAuthHeader(value)
+		stale, ok := params.Get("stale")
+		challenges = append(challenges, Challenge{
+			Scheme: scheme,
+			Realm:  authRealm(params),
+			Stale:  ok && strings.EqualFold(stale.String(), "true"),
+		})
+	}
+	return challenges
+}
+
+// authRealm the realm of the parameters of a challenge or of credentials, empty if none.
+func authRealm(params sip.Params) string {
+	if realm, ok := params.Get("realm"); ok && realm != nil {
+		return realm.String()
+	}
+	return ""
+}
 
-	if viaHop, ok := request.ViaHop(); ok {
-		viaHop.Params.Add("branch", sip.String{Str: sip.GenerateBranch()})
+// prepareRetry makes request a new transaction to send it again with its credentials.
+func prepareRetry(request sip.Request) {
+	// The stack sends it with a new branch.
+	if viaHop, ok := request.ViaHop(); ok && viaHop.Params != nil {
+		viaHop.Params.Remove("branch")
 	}
 
 	if cseq, ok := request.CSeq(); ok {
 		cseq.SeqNo++
 	}
+}
+
+// setAuthorization sets the credentials header of request, replacing the one of the same scheme and realm.
+// The credentials of the other realms are kept, e.g. of another proxy.
+func setAuthorization(request sip.Request, headerName string, value string) {
+	for _, hdr := range request.GetHeaders(headerName) {
+		if credentialsKey(hdr.Value()) == credentialsKey(value) {
+			hdr.(*sip.GenericHeader).Contents = value
+			return
+		}
+	}
+	request.AppendHeader(&sip.GenericHeader{
+		HeaderName: headerName,
+		Contents:   value,
+	})
+}
 
-	return nil
+// credentialsKey the scheme and the realm of credentials.
+func credentialsKey(value string) string {
+	value = strings.TrimSpace(value)
+	scheme := value
+	if i := strings.IndexAny(value, " \t"); i >= 0 {
+		scheme = value[:i]
+	}
+	return strings.ToLower(scheme) + " " + authRealm(parseAuthHeader(value))
 }
 
+// Authorizer answers the 401 and 407 challenges of the requests, with its scheme: Digest, Bearer...
 type Authorizer interface {
 	AuthorizeRequest(request sip.Request, response sip.Response) error
 }
 
+// Preauthorizer an Authorizer adding its credentials to the requests before they are challenged.
+type Preauthorizer interface {
+	Authorizer
+	// PreauthorizeRequest adds the credentials to request, reports whether it did.
+	PreauthorizeRequest(request sip.Request) bool
+	// Accepted is called when a request authorized succeeded.
+	Accepted(request sip.Request)
+}
+
+// CredentialProvider returns the password of the user for realm, called for each challenge answered,
+// e.g. to fetch it from a vault where it is rotated.
+type CredentialProvider func(realm string) (password string, err error)
+
 type ClientAuthorizer struct {
 	user     sip.MaybeString
 	password sip.MaybeString
+	provider CredentialProvider
+	// realms the credentials of the realms answered with another user than the default one.
+	realms  map[string]realmCredentials
+	authInt bool
+	cache   *CredentialCache
+}
+
+type realmCredentials struct {
+	user     string
+	password string
 }
 
 func NewClientAuthorizer(u string, p string) *ClientAuthorizer {
@@ -229,6 +418,80 @@ func NewClientAuthorizer(u string, p string) *ClientAuthorizer {
 	return auth
 }
 
+// SetAuthInt prefers qop=auth-int, the body is protected too, when the server offers it with auth.
+func (auth *ClientAuthorizer) SetAuthInt(authInt bool) *ClientAuthorizer {
+	auth.authInt = authInt
+
+	return auth
+}
+
+// SetCredentialProvider asks the password to provider at challenge time instead of the static one.
+func (auth *ClientAuthorizer) SetCredentialProvider(provider CredentialProvider) *ClientAuthorizer {
+	auth.provider = provider
+
+	return auth
+}
+
+// AddRealm answers the challenges of realm with user and password instead of the default ones,
+// e.g. of an outbound proxy and of a registrar in different realms.
+func (auth *ClientAuthorizer) AddRealm(realm string, user string, password string) *ClientAuthorizer {
+	if auth.realms == nil {
+		auth.realms = make(map[string]realmCredentials)
+	}
+	auth.realms[realm] = realmCredentials{user: user, password: password}
+
+	return auth
+}
+
+// credentials the user and the password answering a challenge of realm.
+func (auth *ClientAuthorizer) credentials(realm string) (string, string, error) {
+	if credentials, ok := auth.realms[realm]; ok {
+		return credentials.user, credentials.password, nil
+	}
+	if auth.user == nil || auth.user.String() == "" {
+		return "", "", fmt.Errorf("no credentials for realm '%s'", realm)
+	}
+	if auth.provider != nil {
+		password, err := auth.provider(realm)
+		if err != nil {
+			return "", "", fmt.Errorf("credential provider: %w", err)
+		}
+		return auth.user.String(), password, nil
+	}
+	if auth.password == nil {
+		return auth.user.String(), "", nil
+	}
+	return auth.user.String(), auth.password.String(), nil
+}
+
+// SetCache reuses the credentials of the challenges answered in cache to authorize the requests preemptively.
+func (auth *ClientAuthorizer) SetCache(cache *CredentialCache) *ClientAuthorizer {
+	auth.cache = cache
+
+	return auth
+}
+
 func (auth *ClientAuthorizer) AuthorizeRequest(request sip.Request, response sip.Response) error {
-	return AuthorizeRequest(request, response, auth.user, auth.password)
+	answers, err := authorizeRequest(request, response, auth.credentials, auth.authInt)
+	if err == nil && auth.cache != nil {
+		auth.cache.answered(auth.user.String(), request, answers)
+	}
+	return err
+}
+
+// PreauthorizeRequest adds the credentials of the last challenge accepted for the destination of request,
+// reports whether it did. A new challenge is answered by AuthorizeRequest as usual.
+func (auth *ClientAuthorizer) PreauthorizeRequest(request sip.Request) bool {
+	if auth == nil || auth.cache == nil {
+		return false
+	}
+	return auth.cache.preauthorize(auth.user.String(), request)
+}
+
+// Accepted marks the credentials of request as accepted by the server, its request succeeded.
+func (auth *ClientAuthorizer) Accepted(request sip.Request) {
+	if auth == nil || auth.cache == nil {
+		return
+	}
+	auth.cache.accepted(auth.user.String(), request)
 }
@@ -0,0 +1,74 @@
+package auth_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/ghettovoice/gosip/log"
+	"github.com/ghettovoice/gosip/sip"
+	"github.com/ghettovoice/gosip/sip/parser"
+	"github.com/sergeyu/go-sip-ua/pkg/auth"
+	"github.com/sergeyu/go-sip-ua/pkg/utils"
+)
+
+// TestAuthorizeAggregated a 407 aggregating the challenge of a proxy and of the UAS answered with both
+// credentials, each cached under its header.
+func TestAuthorizeAggregated(t *testing.T) {
+	logger := utils.NewLogger(log.ErrorLevel, "test", nil)
+	invite := "INVITE sip:bob@example.com SIP/2.0\r\n" +
+		"Via: SIP/2.0/UDP 192.0.2.2:5060;branch=z9hG4bK-1\r\n" +
+		"Max-Forwards: 70\r\n" +
+		"From: <sip:alice@example.com>;tag=a1\r\n" +
+		"To: <sip:bob@example.com>\r\n" +
+		"Call-ID: 1@192.0.2.2\r\n" +
+		"CSeq: 1 INVITE\r\n" +
+		"Contact: <sip:alice@192.0.2.2:5060>\r\n" +
+		"Content-Length: 0\r\n\r\n"
+	msg, err := parser.ParseMessage([]byte(invite), logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := msg.(sip.Request)
+	msg, err = parser.ParseMessage([]byte("SIP/2.0 407 Proxy Authentication Required\r\n"+
+		"Via: SIP/2.0/UDP 192.0.2.2:5060;branch=z9hG4bK-1\r\n"+
+		"From: <sip:alice@example.com>;tag=a1\r\n"+
+		"To: <sip:bob@example.com>;tag=b1\r\n"+
+		"Call-ID: 1@192.0.2.2\r\n"+
+		"CSeq: 1 INVITE\r\n"+
+		`Proxy-Authenticate: Digest realm="proxy.example.com", nonce="n1", qop="auth"`+"\r\n"+
+		`WWW-Authenticate: Digest realm="bob.example.com", nonce="n2", qop="auth"`+"\r\n"+
+		"Content-Length: 0\r\n\r\n"), logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := msg.(sip.Response)
+
+	if got := len(auth.Challenges(res)); got != 2 {
+		t.Errorf("len(Challenges) = %d; want 2", got)
+	}
+	cache := auth.NewCredentialCache()
+	authorizer := auth.NewClientAuthorizer("alice", "secret").SetCache(cache)
+	if err := authorizer.AuthorizeRequest(req, res); err != nil {
+		t.Fatal(err)
+	}
+	for name, realm := range map[string]string{"Proxy-Authorization": "proxy.example.com", "Authorization": "bob.example.com"} {
+		hdrs := req.GetHeaders(name)
+		if len(hdrs) != 1 || !strings.Contains(hdrs[0].Value(), `realm="`+realm+`"`) {
+			t.Errorf("%s = %v; want the credentials of %s", name, hdrs, realm)
+		}
+	}
+
+	// Both credentials reused for the next request once accepted.
+	authorizer.Accepted(req)
+	msg, err = parser.ParseMessage([]byte(strings.Replace(invite, "CSeq: 1", "CSeq: 2", 1)), logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	next := msg.(sip.Request)
+	if !authorizer.PreauthorizeRequest(next) {
+		t.Fatal("PreauthorizeRequest = false")
+	}
+	if len(next.GetHeaders("Proxy-Authorization")) != 1 || len(next.GetHeaders("Authorization")) != 1 {
+		t.Errorf("preauthorized with %v and %v; want one of each", next.GetHeaders("Proxy-Authorization"), next.GetHeaders("Authorization"))
+	}
+}
@@ -1,10 +1,14 @@
 package auth
 
 import (
+	"crypto/hmac"
 	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
 	"encoding/hex"
-	"math/rand"
 	"regexp"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -15,29 +19,38 @@ import (
 )
 
 const (
+	// NonceExpire the default lifetime of the nonces, a request with an older one is challenged with stale=true.
 	NonceExpire = 180 * time.Second
 )
 
 var (
 	logger log.Logger
+	// authParamRegexp the parameters of a challenge or credentials, quoted or tokens as qop=auth-int.
+	authParamRegexp = regexp.MustCompile(`([\w-]+)=("([^"]+)"|([^\s,"]+))`)
 )
 
-// AuthSession .
+// AuthSession the use of a nonce.
 type AuthSession struct {
 	nonce   string
 	created time.Time
+	// nc the highest nonce count accepted, a request with one not higher is a replay.
+	nc uint64
 }
 
 type RequestCredentialCallback func(username string) (password string, ha1 string, err error)
 
 // ServerAuthorizer Proxy-Authorization | WWW-Authenticate
 type ServerAuthorizer struct {
-	// a map[call id]authSession pair
+	// a map[nonce]authSession pair
 	sessions          map[string]AuthSession
 	requestCredential RequestCredentialCallback
 	useAuthInt        bool
 	realm             string
-	log               log.Logger
+	// lifetime of the nonces.
+	lifetime time.Duration
+	// secret signing the nonces, the ones of another secret are not accepted.
+	secret []byte
+	log    log.Logger
 
 	mx sync.RWMutex
 }
@@ -49,13 +62,18 @@ func NewServerAuthorizer(callback RequestCredentialCallback, realm string, authI
 		requestCredential: callback,
 		useAuthInt:        authInt,
 		realm:             realm,
+		lifetime:          NonceExpire,
+		secret:            make([]byte, 32),
 	}
-	auth.log = utils.NewLogrusLogger(log.DebugLevel, "ServerAuthorizer", nil)
+	if _, err := rand.Read(auth.secret); err != nil {
+		panic(err)
+	}
+	auth.log = utils.NewLogger(log.DebugLevel, "ServerAuthorizer", nil)
 	go func() {
 		for now := range time.Tick(NonceExpire) {
 			auth.mx.Lock()
 			for k, v := range auth.sessions {
-				if now.After(v.created.Add(180 * time.Second)) {
+				if now.After(v.created.Add(auth.lifetime)) {
 					delete(auth.sessions, k)
 				}
 			}
@@ -65,6 +83,55 @@ func NewServerAuthorizer(callback RequestCredentialCallback, realm string, authI
 	return auth
 }
 
+// SetNonceLifetime the time a nonce is accepted, NonceExpire by default.
+func (auth *ServerAuthorizer) SetNonceLifetime(lifetime time.Duration) *ServerAuthorizer {
+	auth.mx.Lock()
+	auth.lifetime = lifetime
+	auth.mx.Unlock()
+
+	return auth
+}
+
+// SetNonceSecret the secret signing the nonces, random by default. The nonces of the authorizers sharing it
+// are accepted by each other, e.g. after a restart or behind a load balancer.
+func (auth *ServerAuthorizer) SetNonceSecret(secret []byte) *ServerAuthorizer {
+	auth.mx.Lock()
+	auth.secret = secret
+	auth.mx.Unlock()
+
+	return auth
+}
+
+// newNonce a nonce of the time it is issued and random bytes, signed with the secret.
+func (auth *ServerAuthorizer) newNonce(now time.Time) string {
+	data := make([]byte, 16)
+	binary.BigEndian.PutUint64(data, uint64(now.UnixNano()))
+	if _, err := rand.Read(data[8:]); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(data) + auth.signNonce(data)
+}
+
+func (auth *ServerAuthorizer) signNonce(data []byte) string {
+	auth.mx.RLock()
+	mac := hmac.New(sha256.New, auth.secret)
+	auth.mx.RUnlock()
+	mac.Write(data)
+	return hex.EncodeToString(mac.Sum(nil)[:16])
+}
+
+// checkNonce returns the time nonce was issued, false if it is not signed with the secret.
+func (auth *ServerAuthorizer) checkNonce(nonce string) (time.Time, bool) {
+	if len(nonce) != 64 {
+		return time.Time{}, false
+	}
+	data, err := hex.DecodeString(nonce[:32])
+	if err != nil || !hmac.Equal([]byte(auth.signNonce(data)), []byte(nonce[32:])) {
+		return time.Time{}, false
+	}
+	return time.Unix(0, int64(binary.BigEndian.Uint64(data))), true
+}
+
 // ServerAuthorizer handles Authenticate requests.
 func (auth *ServerAuthorizer) Authenticate(request sip.Request, tx sip.ServerTransaction) (string, bool) {
 	logger := auth.log
@@ -83,7 +150,7 @@ func (auth *ServerAuthorizer) Authenticate(request sip.Request, tx sip.ServerTra
 
 	hdrs := request.GetHeaders("Authorization")
 	if len(hdrs) == 0 {
-		auth.requestAuthentication(request, tx, from)
+		auth.requestAuthentication(request, tx, from, false)
 		return "", false
 	}
 
@@ -92,15 +159,17 @@ func (auth *ServerAuthorizer) Authenticate(request sip.Request, tx sip.ServerTra
 	return auth.checkAuthorization(request, tx, authArgs, from)
 }
 
-func (auth *ServerAuthorizer) requestAuthentication(request sip.Request, tx sip.ServerTransaction, from *sip.FromHeader) {
-	callID, ok := request.CallID()
-	if !ok {
+// requestAuthentication challenges request with a fresh nonce, stale when the credentials were right
+// but the nonce expired or was replayed.
+func (auth *ServerAuthorizer) requestAuthentication(request sip.Request, tx sip.ServerTransaction, from *sip.FromHeader, stale bool) {
+	if _, ok := request.CallID(); !ok {
 		sendResponse(request, tx, 400, "Missing required Call-ID header.")
 		return
 	}
 
 	response := sip.NewResponseFromRequest(request.MessageID(), request, 401, "Unauthorized", "")
-	nonce := generateNonce(8)
+	// Its use is tracked once a request is authenticated with it.
+	nonce := auth.newNonce(time.Now())
 	opaque := generateNonce(4)
 
 	digest := sip.NewParams()
@@ -112,7 +181,7 @@ func (auth *ServerAuthorizer) requestAuthentication(request sip.Request, tx sip.
 	}
 	digest.Add("nonce", sip.String{Str: "\"" + nonce + "\""})
 	digest.Add("opaque", sip.String{Str: "\"" + opaque + "\""})
-	digest.Add("stale", sip.String{Str: "\"false\""})
+	digest.Add("stale", sip.String{Str: "\"" + strconv.FormatBool(stale) + "\""})
 	digest.Add("algorithm", sip.String{Str: "\"md5\""})
 
 	response.AppendHeader(&sip.GenericHeader{
@@ -121,44 +190,34 @@ func (auth *ServerAuthorizer) requestAuthentication(request sip.Request, tx sip.
 	})
 
 	from.Params.Add("tag", sip.String{Str: generateNonce(8)})
-	auth.mx.Lock()
-	auth.sessions[callID.String()] = AuthSession{
-		nonce:   nonce,
-		created: time.Now(),
-	}
-	auth.mx.Unlock()
 	response.SetBody("", true)
 	tx.Respond(response)
 }
 
 func (auth *ServerAuthorizer) checkAuthorization(request sip.Request, tx sip.ServerTransaction,
 	authArgs sip.Params, from *sip.FromHeader) (string, bool) {
-	callID, ok := request.CallID()
-	if !ok {
+	if _, ok := request.CallID(); !ok {
 		sendResponse(request, tx, 400, "Missing required Call-ID header.")
 		return "", false
 	}
 
-	auth.mx.RLock()
-	session, found := auth.sessions[callID.String()]
-	auth.mx.RUnlock()
-	if !found {
-		auth.requestAuthentication(request, tx, from)
+	// The nonces signed with the secret are accepted until they expire, also if issued by another authorizer.
+	nonce, _ := authArgs.Get("nonce")
+	if nonce == nil {
+		auth.requestAuthentication(request, tx, from, false)
 		return "", false
 	}
-
-	if time.Now().After(session.created.Add(NonceExpire)) {
-		auth.requestAuthentication(request, tx, from)
+	issued, ok := auth.checkNonce(nonce.String())
+	if !ok {
+		auth.requestAuthentication(request, tx, from, false)
 		return "", false
 	}
+	auth.mx.RLock()
+	expired := time.Now().After(issued.Add(auth.lifetime))
+	auth.mx.RUnlock()
 
 	if username, ok := authArgs.Get("username"); ok && username.String() != from.Address.User().String() {
-		auth.requestAuthentication(request, tx, from)
-		return "", false
-	}
-
-	if nonce, ok := authArgs.Get("nonce"); ok && nonce.String() != session.nonce {
-		auth.requestAuthentication(request, tx, from)
+		auth.requestAuthentication(request, tx, from, false)
 		return "", false
 	}
 
@@ -176,6 +235,36 @@ func (auth *ServerAuthorizer) checkAuthorization(request sip.Request, tx sip.Ser
 	qop, _ := authArgs.Get("qop")
 	realm, _ := authArgs.Get("realm")
 
+	// The parameters the response is computed from (RFC 2617 3.2.2), cnonce with qop.
+	if uri == nil || realm == nil || response == nil || qop != nil && cnonce == nil {
+		sendResponse(request, tx, 400, "Missing digest parameter")
+		return "", false
+	}
+	// The credentials of another realm are challenged again.
+	if realm.String() != auth.realm {
+		auth.requestAuthentication(request, tx, from, false)
+		return "", false
+	}
+
+	// Only the qop offered by the challenge.
+	if qop != nil && (qop.String() == "auth-int" && !auth.useAuthInt || qop.String() != "auth" && qop.String() != "auth-int") {
+		sendResponse(request, tx, 403, "Forbidden (Bad qop)")
+		return "", false
+	}
+
+	// The nonce count of qop, 1 without qop: a nonce is then used once.
+	var count uint64 = 1
+	if qop != nil {
+		if nc == nil {
+			sendResponse(request, tx, 400, "Missing nonce count")
+			return "", false
+		}
+		if count, err = strconv.ParseUint(nc.String(), 16, 32); err != nil {
+			sendResponse(request, tx, 400, "Bad nonce count")
+			return "", false
+		}
+	}
+
 	result := ""
 
 	// HA1 = MD5(A1) = MD5(username:realm:password).
@@ -188,21 +277,21 @@ func (auth *ServerAuthorizer) checkAuthorization(request sip.Request, tx sip.Ser
 		ha2 := md5Hex(string(request.Method()) + ":" + uri.String())
 
 		// Response = MD5(HA1:nonce:nonceCount:credentialsNonce:qop:HA2).
-		result = md5Hex(ha1 + ":" + session.nonce + ":" + nc.String() +
+		result = md5Hex(ha1 + ":" + nonce.String() + ":" + nc.String() +
 			":" + cnonce.String() + ":auth:" + ha2)
 	} else if qop != nil && qop.String() == "auth-int" {
 		// HA2 = MD5(A2) = MD5(method:digestURI:MD5(entityBody)).
 		ha2 := md5Hex(string(request.Method()) + ":" + uri.String() + ":" + md5Hex(request.Body()))
 
 		// Response = MD5(HA1:nonce:nonceCount:credentialsNonce:qop:HA2).
-		result = md5Hex(ha1 + ":" + session.nonce + ":" + nc.String() +
+		result = md5Hex(ha1 + ":" + nonce.String() + ":" + nc.String() +
 			":" + cnonce.String() + ":auth-int:" + ha2)
 	} else {
 		// HA2 = MD5(A2) = MD5(method:digestURI).
 		ha2 := md5Hex(string(request.Method()) + ":" + uri.String())
 
 		// Response = MD5(HA1:nonce:HA2).
-		result = md5Hex(ha1 + ":" + session.nonce + ":" + ha2)
+		result = md5Hex(ha1 + ":" + nonce.String() + ":" + ha2)
 	}
 
 	if result != response.String() {
@@ -210,14 +299,35 @@ func (auth *ServerAuthorizer) checkAuthorization(request sip.Request, tx sip.Ser
 		return "", false
 	}
 
+	// The credentials are right, an expired nonce or a replay is challenged again with stale=true.
+	if expired {
+		auth.requestAuthentication(request, tx, from, true)
+		return "", false
+	}
+	auth.mx.Lock()
+	session, found := auth.sessions[nonce.String()]
+	if !found {
+		session = AuthSession{nonce: nonce.String(), created: issued}
+	}
+	replay := count <= session.nc
+	if !replay {
+		session.nc = count
+		auth.sessions[nonce.String()] = session
+	}
+	auth.mx.Unlock()
+	if replay {
+		auth.log.Warnf("nonce count %d of %s replayed", count, username)
+		auth.requestAuthentication(request, tx, from, true)
+		return "", false
+	}
+
 	return username, true
 }
 
 // parseAuthHeader .
 func parseAuthHeader(value string) sip.Params {
 	authArgs := sip.NewParams()
-	re := regexp.MustCompile(`([\w]+)=("([^"]+)"|([\w]+))`)
-	matches := re.FindAllStringSubmatch(value, -1)
+	matches := authParamRegexp.FindAllStringSubmatch(value, -1)
 	for _, match := range matches {
 		authArgs.Add(match[1], sip.String{Str: strings.Replace(match[2], "\"", "", -1)})
 	}
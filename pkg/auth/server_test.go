@@ -0,0 +1,104 @@
+package auth_test
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"testing"
+
+	"github.com/ghettovoice/gosip/log"
+	"github.com/ghettovoice/gosip/sip"
+	"github.com/ghettovoice/gosip/sip/parser"
+	"github.com/sergeyu/go-sip-ua/pkg/auth"
+	"github.com/sergeyu/go-sip-ua/pkg/utils"
+)
+
+// serverTx records the responses of a server transaction.
+type serverTx struct {
+	sip.ServerTransaction
+	responses []sip.Response
+}
+
+func (tx *serverTx) Respond(res sip.Response) error {
+	tx.responses = append(tx.responses, res)
+	return nil
+}
+
+func md5Hex(s string) string {
+	sum := md5.Sum([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+// register a REGISTER of alice with the Authorization header authorization, none if empty.
+func register(t *testing.T, authorization string) sip.Request {
+	msg := "REGISTER sip:example.com SIP/2.0\r\n" +
+		"Via: SIP/2.0/UDP 192.0.2.2:5060;branch=z9hG4bK-1\r\n" +
+		"Max-Forwards: 70\r\n" +
+		"From: <sip:alice@example.com>;tag=a1\r\n" +
+		"To: <sip:alice@example.com>\r\n" +
+		"Call-ID: 1@192.0.2.2\r\n" +
+		"CSeq: 1 REGISTER\r\n" +
+		"Contact: <sip:alice@192.0.2.2:5060>\r\n"
+	if authorization != "" {
+		msg += "Authorization: " + authorization + "\r\n"
+	}
+	parsed, err := parser.ParseMessage([]byte(msg+"Content-Length: 0\r\n\r\n"), utils.NewLogger(log.ErrorLevel, "test", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return parsed.(sip.Request)
+}
+
+var nonceRegexp = regexp.MustCompile(`nonce="([0-9a-f]+)"`)
+
+// TestAuthenticateMalformed the Authorization headers of a fresh nonce missing a parameter rejected with a
+// 400, the ones of another realm challenged again.
+func TestAuthenticateMalformed(t *testing.T) {
+	utils.SetLogSink(utils.LogSinkFunc(func(level log.Level, msg string, fields log.Fields) {}))
+	defer utils.SetLogSink(nil)
+	server := auth.NewServerAuthorizer(func(username string) (string, string, error) {
+		return "secret", "", nil
+	}, "example.com", false)
+
+	tx := &serverTx{}
+	if _, ok := server.Authenticate(register(t, ""), tx); ok || len(tx.responses) != 1 || tx.responses[0].StatusCode() != 401 {
+		t.Fatalf("Authenticate without credentials = %v, %v; want a 401", ok, tx.responses)
+	}
+	challenge := tx.responses[0].GetHeaders("WWW-Authenticate")[0].Value()
+	match := nonceRegexp.FindStringSubmatch(challenge)
+	if match == nil {
+		t.Fatalf("no nonce in %s", challenge)
+	}
+	nonce := match[1]
+	ha1 := md5Hex("alice:example.com:secret")
+	digest := func(realm string, params string) string {
+		ha2 := md5Hex("REGISTER:sip:example.com")
+		response := md5Hex(ha1 + ":" + nonce + ":00000001:c1:auth:" + ha2)
+		return fmt.Sprintf(`Digest username="alice", realm="%s", nonce="%s", response="%s", %s`, realm, nonce, response, params)
+	}
+
+	tests := []struct {
+		name          string
+		authorization string
+		code          sip.StatusCode
+	}{
+		{"no uri", digest("example.com", `qop=auth, nc=00000001, cnonce="c1"`), 400},
+		{"no cnonce", digest("example.com", `uri="sip:example.com", qop=auth, nc=00000001`), 400},
+		{"no realm", fmt.Sprintf(`Digest username="alice", nonce="%s", uri="sip:example.com", response="0"`, nonce), 400},
+		{"no response", fmt.Sprintf(`Digest username="alice", realm="example.com", nonce="%s", uri="sip:example.com"`, nonce), 400},
+		{"other realm", digest("example.org", `uri="sip:example.com", qop=auth, nc=00000001, cnonce="c1"`), 401},
+	}
+	for _, tt := range tests {
+		tx := &serverTx{}
+		if _, ok := server.Authenticate(register(t, tt.authorization), tx); ok || len(tx.responses) != 1 || tx.responses[0].StatusCode() != tt.code {
+			t.Errorf("%s: Authenticate = %v, %v; want a %d", tt.name, ok, tx.responses, tt.code)
+		}
+	}
+
+	tx = &serverTx{}
+	authorization := digest("example.com", `uri="sip:example.com", qop=auth, nc=00000001, cnonce="c1"`)
+	if username, ok := server.Authenticate(register(t, authorization), tx); !ok || username != "alice" {
+		t.Errorf("Authenticate = %s, %v, %v; want alice", username, ok, tx.responses)
+	}
+}
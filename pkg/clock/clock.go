@@ -0,0 +1,84 @@
+// Package clock the time of the timer-driven logic of a user agent and its stack: the transaction timers,
+// the refresh of the registrations, the subscriptions and the publications, the keepalives. A Mock lets
+// the tests fast-forward the timers instead of sleeping:
+//
+//	clk := clock.NewMock(time.Now())
+//	s := stack.NewSipStack(&stack.SipStackConfig{Host: "127.0.0.1", Clock: clk})
+//	...
+//	// Timer B of an INVITE unanswered, 64*T1.
+//	clk.Add(32 * time.Second)
+package clock
+
+import "time"
+
+// Clock the current time and the timers.
+type Clock interface {
+	Now() time.Time
+	// NewTimer a timer sending the time on its channel after d.
+	NewTimer(d time.Duration) Timer
+	// AfterFunc a timer calling f after d.
+	AfterFunc(d time.Duration, f func()) Timer
+	// NewTicker a ticker sending the time on its channel every d, d > 0.
+	NewTicker(d time.Duration) Ticker
+}
+
+// Timer a time.Timer of a Clock.
+type Timer interface {
+	// C the channel the time is sent on when the timer expires, nil for a timer of AfterFunc.
+	C() <-chan time.Time
+	// Reset the timer to expire after d, whether it was active.
+	Reset(d time.Duration) bool
+	// Stop the timer, whether it was active.
+	Stop() bool
+}
+
+// Ticker a time.Ticker of a Clock.
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// Real the clock of the time package.
+var Real Clock = realClock{}
+
+// Or c, Real if nil.
+func Or(c Clock) Clock {
+	if c == nil {
+		return Real
+	}
+	return c
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) NewTimer(d time.Duration) Timer {
+	return realTimer{time.NewTimer(d)}
+}
+
+func (realClock) AfterFunc(d time.Duration, f func()) Timer {
+	return realTimer{time.AfterFunc(d, f)}
+}
+
+func (realClock) NewTicker(d time.Duration) Ticker {
+	return realTicker{time.NewTicker(d)}
+}
+
+type realTimer struct {
+	*time.Timer
+}
+
+func (t realTimer) C() <-chan time.Time {
+	return t.Timer.C
+}
+
+type realTicker struct {
+	*time.Ticker
+}
+
+func (t realTicker) C() <-chan time.Time {
+	return t.Ticker.C
+}
@@ -0,0 +1,162 @@
+package clock
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Mock a clock whose time only moves by Add and Set, the timers expired by them in the order of their
+// deadlines. The functions of AfterFunc are called by Add and Set, the receivers of the channels run in
+// their goroutines: wait for their effects, e.g. a request sent, before the next Add. A timer of a
+// non-positive duration expires at the next Add, e.g. Add(0).
+type Mock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []*mockTimer
+}
+
+// NewMock a mock clock at now.
+func NewMock(now time.Time) *Mock {
+	return &Mock{now: now}
+}
+
+func (m *Mock) Now() time.Time {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.now
+}
+
+func (m *Mock) NewTimer(d time.Duration) Timer {
+	return m.start(&mockTimer{mock: m, c: make(chan time.Time, 1)}, d)
+}
+
+func (m *Mock) AfterFunc(d time.Duration, f func()) Timer {
+	return m.start(&mockTimer{mock: m, f: f}, d)
+}
+
+func (m *Mock) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("clock: non-positive interval for NewTicker")
+	}
+	return mockTicker{m.start(&mockTimer{mock: m, c: make(chan time.Time, 1), period: d}, d)}
+}
+
+// Add moves the time forward by d, expiring the timers due by then.
+func (m *Mock) Add(d time.Duration) {
+	m.Set(m.Now().Add(d))
+}
+
+// Set moves the time to t, expiring the timers due by then. The time does not go back.
+func (m *Mock) Set(t time.Time) {
+	for {
+		m.mu.Lock()
+		if len(m.timers) == 0 || m.timers[0].when.After(t) {
+			if t.After(m.now) {
+				m.now = t
+			}
+			m.mu.Unlock()
+			return
+		}
+		timer := m.timers[0]
+		if timer.when.After(m.now) {
+			m.now = timer.when
+		}
+		now := m.now
+		m.remove(timer)
+		if timer.period > 0 {
+			timer.when = timer.when.Add(timer.period)
+			m.add(timer)
+		}
+		m.mu.Unlock()
+
+		if timer.f != nil {
+			timer.f()
+		} else {
+			// A tick is dropped if the previous one is not received, as by time.Ticker.
+			select {
+			case timer.c <- now:
+			default:
+			}
+		}
+	}
+}
+
+// Pending the number of timers and tickers active, e.g. to wait for a goroutine to start its timer before
+// an Add.
+func (m *Mock) Pending() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.timers)
+}
+
+// Next the deadline of the next timer to expire, false if none.
+func (m *Mock) Next() (time.Time, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.timers) == 0 {
+		return time.Time{}, false
+	}
+	return m.timers[0].when, true
+}
+
+func (m *Mock) start(timer *mockTimer, d time.Duration) *mockTimer {
+	m.mu.Lock()
+	timer.when = m.now.Add(d)
+	m.add(timer)
+	m.mu.Unlock()
+	return timer
+}
+
+// add timer to the timers sorted by deadline, after the ones of the same deadline. m.mu is held.
+func (m *Mock) add(timer *mockTimer) {
+	i := sort.Search(len(m.timers), func(i int) bool { return m.timers[i].when.After(timer.when) })
+	m.timers = append(m.timers, nil)
+	copy(m.timers[i+1:], m.timers[i:])
+	m.timers[i] = timer
+}
+
+// remove timer, whether it was active. m.mu is held.
+func (m *Mock) remove(timer *mockTimer) bool {
+	for i, t := range m.timers {
+		if t == timer {
+			m.timers = append(m.timers[:i], m.timers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+type mockTimer struct {
+	mock   *Mock
+	when   time.Time
+	period time.Duration
+	c      chan time.Time
+	f      func()
+}
+
+func (t *mockTimer) C() <-chan time.Time {
+	return t.c
+}
+
+func (t *mockTimer) Reset(d time.Duration) bool {
+	t.mock.mu.Lock()
+	active := t.mock.remove(t)
+	t.mock.mu.Unlock()
+	t.mock.start(t, d)
+	return active
+}
+
+func (t *mockTimer) Stop() bool {
+	t.mock.mu.Lock()
+	defer t.mock.mu.Unlock()
+	return t.mock.remove(t)
+}
+
+type mockTicker struct {
+	*mockTimer
+}
+
+func (t mockTicker) Stop() {
+	t.mockTimer.Stop()
+}
@@ -0,0 +1,146 @@
+package clock_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/sergeyu/go-sip-ua/pkg/clock"
+)
+
+// TestMockOrder the timers expired by one Add in the order of their deadlines, the ones of the same
+// deadline in the order they were started, each seeing its own deadline as Now.
+func TestMockOrder(t *testing.T) {
+	start := time.Unix(0, 0)
+	m := clock.NewMock(start)
+	var fired []string
+	var at []time.Duration
+	after := func(d time.Duration, name string) clock.Timer {
+		return m.AfterFunc(d, func() {
+			fired = append(fired, name)
+			at = append(at, m.Now().Sub(start))
+		})
+	}
+	after(3*time.Second, "c")
+	after(time.Second, "a")
+	after(2*time.Second, "b1")
+	after(2*time.Second, "b2")
+	stopped := after(2*time.Second, "stopped")
+	if !stopped.Stop() {
+		t.Error("Stop of an active timer = false")
+	}
+	reset := after(time.Second, "reset")
+	reset.Reset(4 * time.Second)
+
+	if got, ok := m.Next(); !ok || !got.Equal(start.Add(time.Second)) {
+		t.Errorf("Next = %v, %v; want %v", got, ok, start.Add(time.Second))
+	}
+	m.Add(3 * time.Second)
+	want := []string{"a", "b1", "b2", "c"}
+	if len(fired) != len(want) {
+		t.Fatalf("fired %v; want %v", fired, want)
+	}
+	for i := range want {
+		if fired[i] != want[i] {
+			t.Errorf("fired %v; want %v", fired, want)
+			break
+		}
+	}
+	for i, d := range []time.Duration{time.Second, 2 * time.Second, 2 * time.Second, 3 * time.Second} {
+		if at[i] != d {
+			t.Errorf("%s at %v; want %v", fired[i], at[i], d)
+		}
+	}
+	if got := m.Pending(); got != 1 {
+		t.Errorf("Pending = %d; want 1", got)
+	}
+	if stopped.Stop() {
+		t.Error("Stop of a stopped timer = true")
+	}
+
+	// A timer started by a function expiring in the same Add.
+	m.AfterFunc(0, func() { after(500*time.Millisecond, "nested") })
+	m.Add(time.Second)
+	if got := fired[len(fired)-2:]; got[0] != "nested" || got[1] != "reset" {
+		t.Errorf("fired %v; want nested, reset last", fired)
+	}
+	if got := m.Now().Sub(start); got != 4*time.Second {
+		t.Errorf("Now = %v; want 4s", got)
+	}
+
+	// The time does not go back.
+	m.Set(start)
+	if got := m.Now().Sub(start); got != 4*time.Second {
+		t.Errorf("Now after Set back = %v; want 4s", got)
+	}
+}
+
+// TestMockTicker the ticks of an Add past several periods: one received, the ones after it dropped while
+// it is not, as by time.Ticker.
+func TestMockTicker(t *testing.T) {
+	start := time.Unix(0, 0)
+	m := clock.NewMock(start)
+	ticker := m.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	m.Add(3 * time.Second)
+	select {
+	case tick := <-ticker.C():
+		if !tick.Equal(start.Add(time.Second)) {
+			t.Errorf("tick = %v; want the first, at 1s", tick.Sub(start))
+		}
+	default:
+		t.Fatal("no tick")
+	}
+	select {
+	case tick := <-ticker.C():
+		t.Errorf("tick at %v; want the ticks at 2s and 3s dropped", tick.Sub(start))
+	default:
+	}
+
+	m.Add(time.Second)
+	select {
+	case tick := <-ticker.C():
+		if !tick.Equal(start.Add(4 * time.Second)) {
+			t.Errorf("tick = %v; want 4s", tick.Sub(start))
+		}
+	default:
+		t.Fatal("no tick after the first received")
+	}
+
+	ticker.Stop()
+	m.Add(time.Second)
+	select {
+	case <-ticker.C():
+		t.Error("tick of a stopped ticker")
+	default:
+	}
+	if got := m.Pending(); got != 0 {
+		t.Errorf("Pending = %d; want 0", got)
+	}
+}
+
+// TestMockNewTimer a timer of a channel, expired at its deadline, and at the next Add if non-positive.
+func TestMockNewTimer(t *testing.T) {
+	m := clock.NewMock(time.Unix(0, 0))
+	timer := m.NewTimer(time.Second)
+	m.Add(time.Second - time.Nanosecond)
+	select {
+	case <-timer.C():
+		t.Fatal("timer expired before its deadline")
+	default:
+	}
+	m.Add(time.Nanosecond)
+	select {
+	case <-timer.C():
+	default:
+		t.Fatal("timer not expired at its deadline")
+	}
+
+	timer = m.NewTimer(-time.Second)
+	m.Add(0)
+	select {
+	case <-timer.C():
+	default:
+		t.Fatal("timer of a negative duration not expired by Add(0)")
+	}
+}
@@ -0,0 +1,270 @@
+package conference
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ghettovoice/gosip/log"
+	"github.com/ghettovoice/gosip/sip"
+	"github.com/sergeyu/go-sip-ua/pkg/session"
+	"github.com/sergeyu/go-sip-ua/pkg/utils"
+)
+
+// State aggregate state of the conference.
+type State string
+
+const (
+	Idle   State = "Idle"   /**< No participants. */
+	Active State = "Active" /**< At least one participant joined. */
+	Ended  State = "Ended"  /**< Conference ended, no more joins allowed. */
+)
+
+// Event participant or conference level event.
+type Event string
+
+const (
+	ParticipantJoined  Event = "ParticipantJoined"
+	ParticipantUpdated Event = "ParticipantUpdated"
+	ParticipantLeft    Event = "ParticipantLeft"
+	StateChanged       Event = "StateChanged"
+)
+
+// MixerSdpProvider returns the mixer sdp that the participant should be re-INVITEd to.
+type MixerSdpProvider func(conf *Conference, sess *session.Session) (string, error)
+
+// EventHandler .
+type EventHandler func(conf *Conference, participant *Participant, event Event)
+
+// Participant .
+type Participant struct {
+	Session  *session.Session
+	JoinedAt time.Time
+	MixerSdp string
+}
+
+// Conference manages a group of sessions as one logical conference.
+type Conference struct {
+	id           string
+	state        State
+	mixer        MixerSdpProvider
+	handler      EventHandler
+	participants map[sip.CallID]*Participant
+	joining      map[sip.CallID]bool
+	observers    []EventHandler
+	lock         sync.Mutex
+	log          log.Logger
+}
+
+// NewConference .
+func NewConference(id string, mixer MixerSdpProvider, handler EventHandler) *Conference {
+	c := &Conference{
+		id:           id,
+		state:        Idle,
+		mixer:        mixer,
+		handler:      handler,
+		participants: make(map[sip.CallID]*Participant),
+		joining:      make(map[sip.CallID]bool),
+	}
+	c.log = utils.NewLogger(log.DebugLevel, "Conference", nil)
+	return c
+}
+
+// Log .
+func (c *Conference) Log() log.Logger {
+	return c.log
+}
+
+// ID .
+func (c *Conference) ID() string {
+	return c.id
+}
+
+// State .
+func (c *Conference) State() State {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.state
+}
+
+// Count number of participants.
+func (c *Conference) Count() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return len(c.participants)
+}
+
+// Participants .
+func (c *Conference) Participants() []*Participant {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	participants := make([]*Participant, 0, len(c.participants))
+	for _, p := range c.participants {
+		participants = append(participants, p)
+	}
+	return participants
+}
+
+// Join adds the session to the conference and re-INVITEs it toward the mixer.
+func (c *Conference) Join(sess *session.Session) error {
+	c.lock.Lock()
+	if c.state == Ended {
+		c.lock.Unlock()
+		return fmt.Errorf("conference %s has ended", c.id)
+	}
+	if _, found := c.participants[*sess.CallID()]; found || c.joining[*sess.CallID()] {
+		c.lock.Unlock()
+		return fmt.Errorf("session %s already joined", *sess.CallID())
+	}
+	// reserve the call-id while the mixer is consulted outside the lock.
+	c.joining[*sess.CallID()] = true
+	c.lock.Unlock()
+
+	sdp := ""
+	if c.mixer != nil {
+		var err error
+		if sdp, err = c.mixer(c, sess); err != nil {
+			c.lock.Lock()
+			delete(c.joining, *sess.CallID())
+			c.lock.Unlock()
+			return err
+		}
+	}
+
+	participant := &Participant{
+		Session:  sess,
+		JoinedAt: time.Now(),
+		MixerSdp: sdp,
+	}
+
+	c.lock.Lock()
+	delete(c.joining, *sess.CallID())
+	if c.state == Ended {
+		c.lock.Unlock()
+		return fmt.Errorf("conference %s has ended", c.id)
+	}
+	c.participants[*sess.CallID()] = participant
+	c.lock.Unlock()
+
+	if len(sdp) > 0 && sess.IsEstablished() {
+		c.Log().Debugf("Join: re-INVITE %s toward mixer", *sess.CallID())
+		sess.ReInviteWithOffer(sdp)
+	}
+
+	c.notify(participant, ParticipantJoined)
+	c.updateState()
+	return nil
+}
+
+// Leave removes the session from the conference, hangup ends the session as well.
+func (c *Conference) Leave(sess *session.Session, hangup bool) error {
+	c.lock.Lock()
+	participant, found := c.participants[*sess.CallID()]
+	if found {
+		delete(c.participants, *sess.CallID())
+	}
+	c.lock.Unlock()
+
+	if !found {
+		return fmt.Errorf("session %s not found in conference %s", *sess.CallID(), c.id)
+	}
+
+	if hangup && !sess.IsEnded() {
+		sess.End()
+	}
+
+	c.notify(participant, ParticipantLeft)
+	c.updateState()
+	return nil
+}
+
+// HandleSessionState should be called from the InviteStateHandler,
+// returns true if the session belongs to this conference.
+func (c *Conference) HandleSessionState(sess *session.Session, status session.Status) bool {
+	c.lock.Lock()
+	participant, found := c.participants[*sess.CallID()]
+	c.lock.Unlock()
+
+	if !found {
+		return false
+	}
+
+	switch status {
+	case session.Failure:
+		fallthrough
+	case session.Canceled:
+		fallthrough
+	case session.Terminated:
+		c.Leave(sess, false)
+	case session.ReInviteReceived:
+		// Keep the participant anchored on the mixer.
+		if sess.Direction() == session.Incoming && len(participant.MixerSdp) > 0 {
+			sess.ProvideAnswer(participant.MixerSdp)
+			sess.Accept(200)
+		}
+		c.notify(participant, ParticipantUpdated)
+	case session.Confirmed:
+		c.notify(participant, ParticipantUpdated)
+	}
+	return true
+}
+
+// End hangs up all participants and ends the conference.
+func (c *Conference) End() {
+	c.lock.Lock()
+	if c.state == Ended {
+		c.lock.Unlock()
+		return
+	}
+	participants := c.participants
+	c.participants = make(map[sip.CallID]*Participant)
+	c.state = Ended
+	c.lock.Unlock()
+
+	for _, participant := range participants {
+		if !participant.Session.IsEnded() {
+			participant.Session.End()
+		}
+		c.notify(participant, ParticipantLeft)
+	}
+	c.notify(nil, StateChanged)
+}
+
+func (c *Conference) updateState() {
+	c.lock.Lock()
+	if c.state == Ended {
+		c.lock.Unlock()
+		return
+	}
+	state := Idle
+	if len(c.participants) > 0 {
+		state = Active
+	}
+	changed := state != c.state
+	c.state = state
+	c.lock.Unlock()
+
+	if changed {
+		c.notify(nil, StateChanged)
+	}
+}
+
+// observe registers an internal event handler, e.g. the conference event package server.
+func (c *Conference) observe(handler EventHandler) {
+	c.lock.Lock()
+	c.observers = append(c.observers, handler)
+	c.lock.Unlock()
+}
+
+func (c *Conference) notify(participant *Participant, event Event) {
+	c.Log().Debugf("Conference %s: event => %v", c.id, event)
+	if c.handler != nil {
+		c.handler(c, participant, event)
+	}
+	c.lock.Lock()
+	observers := c.observers
+	c.lock.Unlock()
+	for _, observer := range observers {
+		observer(c, participant, event)
+	}
+}
@@ -0,0 +1,196 @@
+package conference
+
+import (
+	"encoding/xml"
+	"strings"
+	"time"
+
+	"github.com/sergeyu/go-sip-ua/pkg/session"
+)
+
+const (
+	// ContentType conference-info document (RFC 4575).
+	ContentType = "application/conference-info+xml"
+)
+
+// Info conference-info document.
+type Info struct {
+	XMLName     xml.Name     `xml:"urn:ietf:params:xml:ns:conference-info conference-info"`
+	Entity      string       `xml:"entity,attr"`
+	State       string       `xml:"state,attr,omitempty"`
+	Version     uint         `xml:"version,attr"`
+	Description *Description `xml:"conference-description,omitempty"`
+	ConfState   *ConfState   `xml:"conference-state,omitempty"`
+	Users       *Users       `xml:"users,omitempty"`
+}
+
+// Description .
+type Description struct {
+	DisplayText string `xml:"display-text,omitempty"`
+	Subject     string `xml:"subject,omitempty"`
+}
+
+// ConfState .
+type ConfState struct {
+	UserCount int  `xml:"user-count"`
+	Active    bool `xml:"active"`
+}
+
+// Users roster of the conference.
+type Users struct {
+	State string `xml:"state,attr,omitempty"`
+	Users []User `xml:"user"`
+}
+
+// User .
+type User struct {
+	Entity      string     `xml:"entity,attr"`
+	State       string     `xml:"state,attr,omitempty"`
+	DisplayText string     `xml:"display-text,omitempty"`
+	Endpoints   []Endpoint `xml:"endpoint"`
+}
+
+// Endpoint .
+type Endpoint struct {
+	Entity        string       `xml:"entity,attr"`
+	State         string       `xml:"state,attr,omitempty"`
+	DisplayText   string       `xml:"display-text,omitempty"`
+	Status        string       `xml:"status,omitempty"`
+	JoiningMethod string       `xml:"joining-method,omitempty"`
+	JoiningInfo   *JoiningInfo `xml:"joining-info,omitempty"`
+}
+
+// JoiningInfo .
+type JoiningInfo struct {
+	When string `xml:"when,omitempty"`
+}
+
+// ParseInfo parses a conference-info+xml body.
+func ParseInfo(body string) (*Info, error) {
+	info := &Info{}
+	if err := xml.Unmarshal([]byte(body), info); err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// String .
+func (info *Info) String() string {
+	data, err := xml.MarshalIndent(info, "", "  ")
+	if err != nil {
+		return ""
+	}
+	return xml.Header + string(data)
+}
+
+// Roster users of the document.
+func (info *Info) Roster() []User {
+	if info.Users == nil {
+		return nil
+	}
+	return info.Users.Users
+}
+
+// Apply merges a partial document into the full state.
+func (info *Info) Apply(partial *Info) {
+	if partial.State != "partial" {
+		*info = *partial
+		return
+	}
+	info.Version = partial.Version
+	if partial.Description != nil {
+		info.Description = partial.Description
+	}
+	if partial.ConfState != nil {
+		info.ConfState = partial.ConfState
+	}
+	if partial.Users == nil {
+		return
+	}
+	if info.Users == nil {
+		info.Users = &Users{}
+	}
+	for _, user := range partial.Users.Users {
+		index := -1
+		for i, u := range info.Users.Users {
+			if u.Entity == user.Entity {
+				index = i
+				break
+			}
+		}
+		switch {
+		case user.State == "deleted" && index >= 0:
+			info.Users.Users = append(info.Users.Users[:index], info.Users.Users[index+1:]...)
+		case user.State == "deleted":
+		case index >= 0:
+			info.Users.Users[index] = user
+		default:
+			info.Users.Users = append(info.Users.Users, user)
+		}
+	}
+}
+
+// Info builds the full conference-info document of the conference.
+func (c *Conference) Info(entity string, version uint) *Info {
+	participants := c.Participants()
+
+	info := &Info{
+		Entity:  entity,
+		State:   "full",
+		Version: version,
+		Description: &Description{
+			DisplayText: c.id,
+		},
+		ConfState: &ConfState{
+			UserCount: len(participants),
+			Active:    c.State() == Active,
+		},
+		Users: &Users{},
+	}
+
+	for _, participant := range participants {
+		info.Users.Users = append(info.Users.Users, participant.user())
+	}
+	return info
+}
+
+func (p *Participant) user() User {
+	sess := p.Session
+	remote := sess.RemoteURI()
+
+	display := ""
+	if remote.DisplayName != nil {
+		display = strings.Trim(remote.DisplayName.String(), "\"")
+	}
+
+	endpoint := Endpoint{
+		Entity:      remote.Uri.String(),
+		DisplayText: display,
+		Status:      "pending",
+		JoiningInfo: &JoiningInfo{When: p.JoinedAt.UTC().Format(time.RFC3339)},
+	}
+	if target := sess.RemoteTarget(); target != nil {
+		endpoint.Entity = target.String()
+	}
+	switch {
+	case sess.IsEstablished():
+		endpoint.Status = "connected"
+	case sess.IsEnded():
+		endpoint.Status = "disconnected"
+	case sess.Direction() == session.Incoming:
+		endpoint.Status = "dialing-in"
+	default:
+		endpoint.Status = "dialing-out"
+	}
+	if sess.Direction() == session.Incoming {
+		endpoint.JoiningMethod = "dialed-in"
+	} else {
+		endpoint.JoiningMethod = "dialed-out"
+	}
+
+	return User{
+		Entity:      remote.Uri.String(),
+		DisplayText: display,
+		Endpoints:   []Endpoint{endpoint},
+	}
+}
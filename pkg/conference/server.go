@@ -0,0 +1,91 @@
+package conference
+
+import (
+	"sync"
+
+	"github.com/sergeyu/go-sip-ua/pkg/ua"
+	"github.com/sergeyu/go-sip-ua/pkg/utils"
+)
+
+const (
+	// EventPackage conference event package (RFC 4575).
+	EventPackage = "conference"
+)
+
+// Server serves the conference event package for the conferences added to it,
+// a SUBSCRIBE is matched to the conference by the user part of its Request-URI, unescaped.
+type Server struct {
+	ua          *ua.UserAgent
+	conferences map[string]*Conference
+	mu          sync.Mutex
+}
+
+// NewServer registers the conference event package on the UA.
+func NewServer(userAgent *ua.UserAgent) *Server {
+	s := &Server{
+		ua:          userAgent,
+		conferences: make(map[string]*Conference),
+	}
+	userAgent.OnSubscribe(EventPackage, s.handleSubscribe)
+	return s
+}
+
+// Add serves the roster of conf.
+func (s *Server) Add(conf *Conference) {
+	s.mu.Lock()
+	s.conferences[conf.ID()] = conf
+	s.mu.Unlock()
+	conf.observe(s.handleEvent)
+}
+
+// Remove stops serving conf and terminates its subscriptions.
+func (s *Server) Remove(conf *Conference) {
+	s.mu.Lock()
+	delete(s.conferences, conf.ID())
+	s.mu.Unlock()
+	for _, n := range s.notifiers(conf) {
+		n.Terminate("noresource")
+	}
+}
+
+func (s *Server) handleSubscribe(n *ua.Notifier) {
+	s.mu.Lock()
+	conf, found := s.conferences[utils.UserOf(n.Resource())]
+	s.mu.Unlock()
+
+	if !found {
+		n.Terminate("noresource")
+		return
+	}
+	// A refresh is notified the roster in a new version.
+	n.SetStateHandler(func(n *ua.Notifier) (string, string) {
+		return ContentType, conf.Info(n.Resource().String(), n.NextVersion()).String()
+	})
+	s.notify(n, conf)
+}
+
+func (s *Server) handleEvent(conf *Conference, participant *Participant, event Event) {
+	for _, n := range s.notifiers(conf) {
+		s.notify(n, conf)
+	}
+	if event == StateChanged && conf.State() == Ended {
+		s.Remove(conf)
+	}
+}
+
+func (s *Server) notifiers(conf *Conference) []*ua.Notifier {
+	notifiers := make([]*ua.Notifier, 0)
+	for _, n := range s.ua.Notifiers(EventPackage) {
+		if utils.UserOf(n.Resource()) == conf.ID() {
+			notifiers = append(notifiers, n)
+		}
+	}
+	return notifiers
+}
+
+func (s *Server) notify(n *ua.Notifier, conf *Conference) {
+	info := conf.Info(n.Resource().String(), n.NextVersion())
+	if err := n.Notify(ContentType, info.String()); err != nil {
+		conf.Log().Warnf("NOTIFY to %s failed: %v", n.Subscriber(), err)
+	}
+}
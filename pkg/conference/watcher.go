@@ -0,0 +1,115 @@
+package conference
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/ghettovoice/gosip/sip"
+	"github.com/sergeyu/go-sip-ua/pkg/account"
+	"github.com/sergeyu/go-sip-ua/pkg/ua"
+)
+
+// RosterHandler called with the merged roster on every NOTIFY of a watched conference.
+type RosterHandler func(conf sip.SipUri, info *Info)
+
+// Watcher subscribes to the conference event package of conferences.
+type Watcher struct {
+	ua      *ua.UserAgent
+	profile *account.Profile
+	expires uint32
+	handler RosterHandler
+	watches map[string]*ua.Subscription
+	rosters map[string]*Info
+	mu      sync.Mutex
+}
+
+// NewWatcher .
+func NewWatcher(userAgent *ua.UserAgent, profile *account.Profile, expires uint32, handler RosterHandler) *Watcher {
+	return &Watcher{
+		ua:      userAgent,
+		profile: profile,
+		expires: expires,
+		handler: handler,
+		watches: make(map[string]*ua.Subscription),
+		rosters: make(map[string]*Info),
+	}
+}
+
+// Watch subscribes to the roster of conf.
+func (w *Watcher) Watch(conf sip.SipUri) error {
+	key := conf.String()
+
+	w.mu.Lock()
+	if _, found := w.watches[key]; found {
+		w.mu.Unlock()
+		return fmt.Errorf("conference %s already watched", key)
+	}
+	w.mu.Unlock()
+
+	sub, err := w.ua.Subscribe(w.profile, &conf, conf, EventPackage, []string{ContentType}, w.expires,
+		func(status ua.SubscriptionStatus) {
+			w.handleStatus(conf, status)
+		}, nil)
+	if err != nil {
+		return err
+	}
+
+	w.mu.Lock()
+	w.watches[key] = sub
+	w.mu.Unlock()
+	return nil
+}
+
+// Unwatch terminates the subscription to conf.
+func (w *Watcher) Unwatch(conf sip.SipUri) error {
+	key := conf.String()
+
+	w.mu.Lock()
+	sub, found := w.watches[key]
+	delete(w.watches, key)
+	delete(w.rosters, key)
+	w.mu.Unlock()
+
+	if !found {
+		return fmt.Errorf("conference %s not watched", key)
+	}
+	return sub.Unsubscribe()
+}
+
+// Roster the last known roster of conf.
+func (w *Watcher) Roster(conf sip.SipUri) *Info {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.rosters[conf.String()]
+}
+
+func (w *Watcher) handleStatus(conf sip.SipUri, status ua.SubscriptionStatus) {
+	key := conf.String()
+	if status.State == ua.SubscriptionTerminated {
+		w.mu.Lock()
+		delete(w.watches, key)
+		w.mu.Unlock()
+	}
+
+	if status.Request == nil || len(status.Body) == 0 {
+		return
+	}
+
+	info, err := ParseInfo(status.Body)
+	if err != nil {
+		return
+	}
+
+	w.mu.Lock()
+	roster, found := w.rosters[key]
+	if !found {
+		roster = &Info{}
+		w.rosters[key] = roster
+	}
+	roster.Apply(info)
+	w.mu.Unlock()
+
+	if w.handler != nil {
+		w.handler(conf, roster)
+	}
+}
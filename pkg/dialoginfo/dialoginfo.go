@@ -0,0 +1,218 @@
+package dialoginfo
+
+import (
+	"encoding/xml"
+	"strings"
+
+	"github.com/ghettovoice/gosip/sip"
+	"github.com/sergeyu/go-sip-ua/pkg/session"
+)
+
+const (
+	// ContentType dialog-info document (RFC 4235).
+	ContentType = "application/dialog-info+xml"
+)
+
+// State dialog state of the dialog-info document.
+type State string
+
+const (
+	Trying     State = "trying"
+	Proceeding State = "proceeding"
+	Early      State = "early"
+	Confirmed  State = "confirmed"
+	Terminated State = "terminated"
+)
+
+// DialogInfo dialog-info document.
+type DialogInfo struct {
+	XMLName xml.Name `xml:"urn:ietf:params:xml:ns:dialog-info dialog-info"`
+	Version uint     `xml:"version,attr"`
+	State   string   `xml:"state,attr"`
+	Entity  string   `xml:"entity,attr"`
+	Dialogs []Dialog `xml:"dialog"`
+}
+
+// Dialog .
+type Dialog struct {
+	ID        string       `xml:"id,attr"`
+	CallID    string       `xml:"call-id,attr,omitempty"`
+	LocalTag  string       `xml:"local-tag,attr,omitempty"`
+	RemoteTag string       `xml:"remote-tag,attr,omitempty"`
+	Direction string       `xml:"direction,attr,omitempty"`
+	State     DialogState  `xml:"state"`
+	Duration  uint         `xml:"duration,omitempty"`
+	Local     *Participant `xml:"local,omitempty"`
+	Remote    *Participant `xml:"remote,omitempty"`
+}
+
+// DialogState state element with the optional event and code attributes.
+type DialogState struct {
+	Event string `xml:"event,attr,omitempty"`
+	Code  int    `xml:"code,attr,omitempty"`
+	Value State  `xml:",chardata"`
+}
+
+// Participant local or remote participant of a dialog.
+type Participant struct {
+	Identity *Identity `xml:"identity,omitempty"`
+	Target   *Target   `xml:"target,omitempty"`
+}
+
+// Identity .
+type Identity struct {
+	Display string `xml:"display,attr,omitempty"`
+	URI     string `xml:",chardata"`
+}
+
+// Target .
+type Target struct {
+	URI string `xml:"uri,attr"`
+}
+
+// Parse parses a dialog-info+xml body.
+func Parse(body string) (*DialogInfo, error) {
+	info := &DialogInfo{}
+	if err := xml.Unmarshal([]byte(body), info); err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// String .
+func (info *DialogInfo) String() string {
+	data, err := xml.MarshalIndent(info, "", "  ")
+	if err != nil {
+		return ""
+	}
+	return xml.Header + string(data)
+}
+
+// Apply merges the document doc of the subscription into the state info: a full one replaces it, the
+// dialogs of a partial one replace the ones of their id, the terminated ones removed. False if doc is stale,
+// its version not after the one of the state (RFC 4235 4.1).
+func (info *DialogInfo) Apply(doc *DialogInfo) bool {
+	if info.State != "" && doc.Version <= info.Version {
+		return false
+	}
+	if doc.State != "partial" {
+		*info = *doc
+		info.Dialogs = append([]Dialog(nil), doc.Dialogs...)
+		return true
+	}
+	info.Version = doc.Version
+	for _, dialog := range doc.Dialogs {
+		index := -1
+		for i, d := range info.Dialogs {
+			if d.ID == dialog.ID {
+				index = i
+				break
+			}
+		}
+		switch {
+		case dialog.State.Value == Terminated && index >= 0:
+			info.Dialogs = append(info.Dialogs[:index], info.Dialogs[index+1:]...)
+		case dialog.State.Value == Terminated:
+		case index >= 0:
+			info.Dialogs[index] = dialog
+		default:
+			info.Dialogs = append(info.Dialogs, dialog)
+		}
+	}
+	return true
+}
+
+// Busy reports whether any dialog of the entity is not terminated, used for BLF.
+func (info *DialogInfo) Busy() bool {
+	for _, dialog := range info.Dialogs {
+		if dialog.State.Value != Terminated {
+			return true
+		}
+	}
+	return false
+}
+
+// Ringing reports whether an incoming dialog of the entity is early, used for BLF.
+func (info *DialogInfo) Ringing() bool {
+	for _, dialog := range info.Dialogs {
+		if dialog.Direction == "recipient" && (dialog.State.Value == Early || dialog.State.Value == Proceeding) {
+			return true
+		}
+	}
+	return false
+}
+
+// SessionState maps the session status to the dialog state.
+func SessionState(status session.Status) State {
+	switch status {
+	case session.InviteSent:
+		return Trying
+	case session.Provisional:
+		return Proceeding
+	case session.EarlyMedia, session.InviteReceived, session.WaitingForAnswer:
+		return Early
+	case session.Answered, session.WaitingForACK, session.Confirmed, session.ReInviteReceived:
+		return Confirmed
+	default:
+		return Terminated
+	}
+}
+
+// NewDialog builds the dialog element of an invite session.
+func NewDialog(sess *session.Session, status session.Status) Dialog {
+	local := sess.LocalURI()
+	remote := sess.RemoteURI()
+
+	dialog := Dialog{
+		ID:        sess.CallID().String(),
+		CallID:    sess.CallID().String(),
+		LocalTag:  tag(local),
+		RemoteTag: tag(remote),
+		State:     DialogState{Value: SessionState(status)},
+		Local: &Participant{
+			Identity: identity(local),
+		},
+		Remote: &Participant{
+			Identity: identity(remote),
+		},
+	}
+	if sess.Direction() == session.Outgoing {
+		dialog.Direction = "initiator"
+	} else {
+		dialog.Direction = "recipient"
+	}
+	if target := sess.RemoteTarget(); target != nil {
+		dialog.Remote.Target = &Target{URI: target.String()}
+	}
+
+	switch status {
+	case session.Canceled:
+		dialog.State.Event = "cancelled"
+	case session.Failure:
+		dialog.State.Event = "rejected"
+		if resp := sess.Response(); resp != nil {
+			dialog.State.Code = int(resp.StatusCode())
+		}
+	}
+	return dialog
+}
+
+func tag(addr sip.Address) string {
+	if addr.Params != nil {
+		if tag, ok := addr.Params.Get("tag"); ok && tag != nil {
+			return tag.String()
+		}
+	}
+	return ""
+}
+
+func identity(addr sip.Address) *Identity {
+	if addr.Uri == nil {
+		return nil
+	}
+	id := &Identity{URI: addr.Uri.String()}
+	if addr.DisplayName != nil {
+		id.Display = strings.Trim(addr.DisplayName.String(), "\"")
+	}
+	return id
+}
@@ -0,0 +1,58 @@
+package dialoginfo_test
+
+import (
+	"testing"
+
+	"github.com/sergeyu/go-sip-ua/pkg/dialoginfo"
+)
+
+func dialog(id string, state dialoginfo.State) dialoginfo.Dialog {
+	return dialoginfo.Dialog{ID: id, Direction: "initiator", State: dialoginfo.DialogState{Value: state}}
+}
+
+// TestApply the dialogs of an entity merged from its full and partial documents, the stale ones discarded.
+func TestApply(t *testing.T) {
+	state := &dialoginfo.DialogInfo{}
+	tests := []struct {
+		name    string
+		doc     *dialoginfo.DialogInfo
+		applied bool
+		dialogs []string
+		lamp    dialoginfo.LampState
+	}{
+		{"full", &dialoginfo.DialogInfo{Version: 0, State: "full", Dialogs: []dialoginfo.Dialog{
+			dialog("a", dialoginfo.Confirmed)}}, true, []string{"a"}, dialoginfo.Busy},
+		{"partial of a second dialog", &dialoginfo.DialogInfo{Version: 1, State: "partial", Dialogs: []dialoginfo.Dialog{
+			dialog("b", dialoginfo.Confirmed)}}, true, []string{"a", "b"}, dialoginfo.Busy},
+		{"partial terminating one", &dialoginfo.DialogInfo{Version: 2, State: "partial", Dialogs: []dialoginfo.Dialog{
+			dialog("a", dialoginfo.Terminated)}}, true, []string{"b"}, dialoginfo.Busy},
+		{"stale", &dialoginfo.DialogInfo{Version: 2, State: "partial", Dialogs: []dialoginfo.Dialog{
+			dialog("b", dialoginfo.Terminated)}}, false, []string{"b"}, dialoginfo.Busy},
+		{"partial terminating the last", &dialoginfo.DialogInfo{Version: 3, State: "partial", Dialogs: []dialoginfo.Dialog{
+			dialog("b", dialoginfo.Terminated)}}, true, nil, dialoginfo.Idle},
+		{"full replacing", &dialoginfo.DialogInfo{Version: 4, State: "full", Dialogs: []dialoginfo.Dialog{
+			dialog("c", dialoginfo.Early)}}, true, []string{"c"}, dialoginfo.Busy},
+	}
+	for _, tt := range tests {
+		if applied := state.Apply(tt.doc); applied != tt.applied {
+			t.Errorf("%s: Apply = %v; want %v", tt.name, applied, tt.applied)
+		}
+		var ids []string
+		for _, d := range state.Dialogs {
+			ids = append(ids, d.ID)
+		}
+		if len(ids) != len(tt.dialogs) {
+			t.Errorf("%s: dialogs %v; want %v", tt.name, ids, tt.dialogs)
+		} else {
+			for i := range ids {
+				if ids[i] != tt.dialogs[i] {
+					t.Errorf("%s: dialogs %v; want %v", tt.name, ids, tt.dialogs)
+					break
+				}
+			}
+		}
+		if lamp := dialoginfo.LampStateOf(state); lamp != tt.lamp {
+			t.Errorf("%s: LampStateOf = %v; want %v", tt.name, lamp, tt.lamp)
+		}
+	}
+}
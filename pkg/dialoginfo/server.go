@@ -0,0 +1,96 @@
+package dialoginfo
+
+import (
+	"github.com/ghettovoice/gosip/log"
+	"github.com/ghettovoice/gosip/sip"
+	"github.com/sergeyu/go-sip-ua/pkg/session"
+	"github.com/sergeyu/go-sip-ua/pkg/ua"
+	"github.com/sergeyu/go-sip-ua/pkg/utils"
+)
+
+const (
+	// Event dialog event package (RFC 4235).
+	Event = "dialog"
+)
+
+// AuthorizeHandler decides whether the subscriber may watch the entity, nil accepts everyone.
+type AuthorizeHandler func(subscriber sip.Uri, entity sip.Uri) bool
+
+// Server serves the dialog event package from the invite sessions of the UA.
+type Server struct {
+	ua        *ua.UserAgent
+	authorize AuthorizeHandler
+	log       log.Logger
+}
+
+// NewServer registers the dialog event package on the UA.
+func NewServer(userAgent *ua.UserAgent, authorize AuthorizeHandler) *Server {
+	s := &Server{
+		ua:        userAgent,
+		authorize: authorize,
+	}
+	s.log = utils.NewLogger(log.DebugLevel, "DialogInfo", nil)
+	userAgent.OnSubscribe(Event, s.handleSubscribe)
+	return s
+}
+
+// Log .
+func (s *Server) Log() log.Logger {
+	return s.log
+}
+
+// HandleSessionState updates the watchers of the session owner, call it from the InviteStateHandler.
+func (s *Server) HandleSessionState(sess *session.Session, status session.Status) {
+	dialog := NewDialog(sess, status)
+	user := utils.UserOf(sess.LocalURI().Uri)
+	for _, n := range s.ua.Notifiers(Event) {
+		if utils.UserOf(n.Resource()) != user {
+			continue
+		}
+		s.notify(n, []Dialog{dialog}, "partial")
+	}
+}
+
+func (s *Server) handleSubscribe(n *ua.Notifier) {
+	if s.authorize != nil && !s.authorize(n.Subscriber(), n.Resource()) {
+		s.Log().Infof("Subscription from %s to %s rejected", n.Subscriber(), n.Resource())
+		n.Terminate("rejected")
+		return
+	}
+
+	// A refresh is notified the full state in a new version.
+	n.SetStateHandler(func(n *ua.Notifier) (string, string) {
+		return ContentType, s.document(n, s.dialogsOf(n), "full").String()
+	})
+	s.notify(n, s.dialogsOf(n), "full")
+}
+
+// dialogsOf the dialogs of the sessions of the entity watched by n.
+func (s *Server) dialogsOf(n *ua.Notifier) []Dialog {
+	user := utils.UserOf(n.Resource())
+	dialogs := make([]Dialog, 0)
+	for _, sess := range s.ua.Sessions() {
+		if utils.UserOf(sess.LocalURI().Uri) != user || sess.IsEnded() {
+			continue
+		}
+		dialogs = append(dialogs, NewDialog(sess, sess.Status()))
+	}
+	return dialogs
+}
+
+// document the dialog-info of dialogs notified to n, of its next version.
+func (s *Server) document(n *ua.Notifier, dialogs []Dialog, state string) *DialogInfo {
+	return &DialogInfo{
+		Version: n.NextVersion(),
+		State:   state,
+		Entity:  n.Resource().String(),
+		Dialogs: dialogs,
+	}
+}
+
+func (s *Server) notify(n *ua.Notifier, dialogs []Dialog, state string) {
+	info := s.document(n, dialogs, state)
+	if err := n.Notify(ContentType, info.String()); err != nil {
+		s.Log().Warnf("NOTIFY to %s failed: %v", n.Subscriber(), err)
+	}
+}
@@ -0,0 +1,163 @@
+package dialoginfo
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/ghettovoice/gosip/log"
+	"github.com/ghettovoice/gosip/sip"
+	"github.com/sergeyu/go-sip-ua/pkg/account"
+	"github.com/sergeyu/go-sip-ua/pkg/ua"
+	"github.com/sergeyu/go-sip-ua/pkg/utils"
+)
+
+// LampState busy lamp field state.
+type LampState string
+
+const (
+	Idle    LampState = "Idle"
+	Ringing LampState = "Ringing"
+	Busy    LampState = "Busy"
+	Unknown LampState = "Unknown"
+)
+
+// LampHandler called when a NOTIFY for a watched entity arrives, with the dialogs of the entity merged from
+// the NOTIFYs of the subscription.
+type LampHandler func(entity sip.SipUri, state LampState, info *DialogInfo)
+
+// Watcher subscribes to the dialog state of entities, e.g. for busy lamp fields.
+type Watcher struct {
+	ua      *ua.UserAgent
+	profile *account.Profile
+	expires uint32
+	handler LampHandler
+	watches map[string]*ua.Subscription
+	// states the dialogs of the entities, merged from their full and partial documents.
+	states map[string]*DialogInfo
+	mu     sync.Mutex
+	log    log.Logger
+}
+
+// NewWatcher .
+func NewWatcher(userAgent *ua.UserAgent, profile *account.Profile, expires uint32, handler LampHandler) *Watcher {
+	w := &Watcher{
+		ua:      userAgent,
+		profile: profile,
+		expires: expires,
+		handler: handler,
+		watches: make(map[string]*ua.Subscription),
+		states:  make(map[string]*DialogInfo),
+	}
+	w.log = utils.NewLogger(log.DebugLevel, "BLF", nil)
+	return w
+}
+
+// Log .
+func (w *Watcher) Log() log.Logger {
+	return w.log
+}
+
+// Watch subscribes to the dialogs of entity.
+func (w *Watcher) Watch(entity sip.SipUri) error {
+	key := entity.String()
+
+	w.mu.Lock()
+	if _, found := w.watches[key]; found {
+		w.mu.Unlock()
+		return fmt.Errorf("entity %s already watched", key)
+	}
+	w.mu.Unlock()
+
+	sub, err := w.ua.Subscribe(w.profile, &entity, entity, Event, []string{ContentType}, w.expires,
+		func(status ua.SubscriptionStatus) {
+			w.handleStatus(entity, status)
+		}, nil)
+	if err != nil {
+		return err
+	}
+
+	w.mu.Lock()
+	w.watches[key] = sub
+	w.mu.Unlock()
+	return nil
+}
+
+// Unwatch terminates the subscription to entity.
+func (w *Watcher) Unwatch(entity sip.SipUri) error {
+	key := entity.String()
+
+	w.mu.Lock()
+	sub, found := w.watches[key]
+	delete(w.watches, key)
+	delete(w.states, key)
+	w.mu.Unlock()
+
+	if !found {
+		return fmt.Errorf("entity %s not watched", key)
+	}
+	return sub.Unsubscribe()
+}
+
+func (w *Watcher) handleStatus(entity sip.SipUri, status ua.SubscriptionStatus) {
+	key := entity.String()
+	defer w.forget(key, status)
+	if status.State == ua.SubscriptionTerminated {
+		w.mu.Lock()
+		delete(w.watches, key)
+		w.mu.Unlock()
+		if w.handler != nil && status.Request == nil {
+			w.handler(entity, Unknown, nil)
+		}
+	}
+
+	if status.Request == nil || len(status.Body) == 0 {
+		return
+	}
+
+	doc, err := Parse(status.Body)
+	if err != nil {
+		w.Log().Warnf("invalid dialog-info from %s: %v", key, err)
+		return
+	}
+
+	w.mu.Lock()
+	state, found := w.states[key]
+	if !found {
+		state = &DialogInfo{}
+		w.states[key] = state
+	}
+	applied := state.Apply(doc)
+	info := *state
+	info.Dialogs = append([]Dialog(nil), state.Dialogs...)
+	w.mu.Unlock()
+	if !applied {
+		w.Log().Debugf("stale dialog-info version %d from %s", doc.Version, key)
+		return
+	}
+
+	if w.handler != nil {
+		w.handler(entity, LampStateOf(&info), &info)
+	}
+}
+
+// forget the dialogs of the entity of key once its subscription is terminated, the versions of a new one
+// starting again.
+func (w *Watcher) forget(key string, status ua.SubscriptionStatus) {
+	if status.State != ua.SubscriptionTerminated {
+		return
+	}
+	w.mu.Lock()
+	delete(w.states, key)
+	w.mu.Unlock()
+}
+
+// LampStateOf the busy lamp state of a dialog-info document.
+func LampStateOf(info *DialogInfo) LampState {
+	if info.Ringing() {
+		return Ringing
+	}
+	if info.Busy() {
+		return Busy
+	}
+	return Idle
+}
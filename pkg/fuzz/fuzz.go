@@ -0,0 +1,56 @@
+//go:build gofuzz
+// +build gofuzz
+
+// Package fuzz the entry points of go-fuzz and OSS-Fuzz for the inbound path of a user agent, built with
+// the gofuzz tag:
+//
+//	go-fuzz-build -func Fuzz github.com/sergeyu/go-sip-ua/pkg/fuzz
+//	go-fuzz -bin fuzz-fuzz.zip -workdir pkg/fuzz
+//
+// The seeds are the messages of the corpus directory of the workdir.
+package fuzz
+
+import (
+	"sync"
+	"time"
+
+	"github.com/ghettovoice/gosip/log"
+	"github.com/ghettovoice/gosip/sip"
+	"github.com/sergeyu/go-sip-ua/pkg/session"
+	"github.com/sergeyu/go-sip-ua/pkg/stack"
+	"github.com/sergeyu/go-sip-ua/pkg/ua"
+	"github.com/sergeyu/go-sip-ua/pkg/utils"
+)
+
+var (
+	once      sync.Once
+	userAgent *ua.UserAgent
+	sipStack  *stack.SipStack
+)
+
+// setup the stack and the user agent the messages are passed to, once: a stack without listeners, its
+// responses dropped, and a user agent answering the calls, their logs discarded.
+func setup() {
+	utils.SetLogSink(utils.LogSinkFunc(func(level log.Level, msg string, fields log.Fields) {}))
+	sipStack = stack.NewSipStack(&stack.SipStackConfig{Host: "192.0.2.1"})
+	userAgent, _ = ua.NewUserAgent(ua.WithSipStack(sipStack))
+	userAgent.InviteStateHandler = func(s *session.Session, req *sip.Request, resp *sip.Response, status session.Status) {
+		switch status {
+		case session.InviteReceived, session.ReInviteReceived:
+			s.ProvideAnswer(s.RemoteSdp())
+			s.Accept(200)
+		}
+	}
+}
+
+// Fuzz passes data through the parser, the transactions and the user agent as a UDP message, see
+// stack.SipStack.Receive. 1 if the message was passed to the transactions, 0 if it was dropped.
+func Fuzz(data []byte) int {
+	once.Do(setup)
+	if err := sipStack.Receive("udp", "192.0.2.2:5060", data); err != nil {
+		return 0
+	}
+	// The transactions handle the message in their goroutines.
+	time.Sleep(time.Millisecond)
+	return 1
+}
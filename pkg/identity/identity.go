@@ -0,0 +1,146 @@
+package identity
+
+import (
+	"strings"
+
+	"github.com/ghettovoice/gosip/sip"
+	"github.com/ghettovoice/gosip/sip/parser"
+)
+
+const (
+	// AssertedIdentityHeader (RFC 3325).
+	AssertedIdentityHeader = "P-Asserted-Identity"
+	// PreferredIdentityHeader (RFC 3325).
+	PreferredIdentityHeader = "P-Preferred-Identity"
+	// PrivacyHeader (RFC 3323).
+	PrivacyHeader = "Privacy"
+)
+
+// Privacy values of the Privacy header.
+type Privacy string
+
+const (
+	PrivacyNone     Privacy = "none"
+	PrivacyHeaders  Privacy = "header"
+	PrivacySession  Privacy = "session"
+	PrivacyUser     Privacy = "user"
+	PrivacyID       Privacy = "id"
+	PrivacyCritical Privacy = "critical"
+)
+
+// AssertedIdentity returns the P-Asserted-Identity addresses of the message.
+func AssertedIdentity(msg sip.Message) []sip.Address {
+	return addresses(msg, AssertedIdentityHeader)
+}
+
+// PreferredIdentity returns the P-Preferred-Identity addresses of the message.
+func PreferredIdentity(msg sip.Message) []sip.Address {
+	return addresses(msg, PreferredIdentityHeader)
+}
+
+// PrivacyValues returns the values of the Privacy header.
+func PrivacyValues(msg sip.Message) []Privacy {
+	values := make([]Privacy, 0)
+	for _, hdr := range msg.GetHeaders(PrivacyHeader) {
+		for _, value := range strings.Split(hdr.Value(), ";") {
+			value = strings.ToLower(strings.TrimSpace(value))
+			if len(value) > 0 {
+				values = append(values, Privacy(value))
+			}
+		}
+	}
+	return values
+}
+
+// HasPrivacy reports whether the message requests the privacy value.
+func HasPrivacy(msg sip.Message, privacy Privacy) bool {
+	for _, value := range PrivacyValues(msg) {
+		if value == privacy {
+			return true
+		}
+	}
+	return false
+}
+
+// NewAssertedIdentity builds a P-Asserted-Identity header, at most one sip and one tel identity.
+func NewAssertedIdentity(addrs ...sip.Address) sip.Header {
+	return newAddressHeader(AssertedIdentityHeader, addrs)
+}
+
+// NewPreferredIdentity builds a P-Preferred-Identity header.
+func NewPreferredIdentity(addrs ...sip.Address) sip.Header {
+	return newAddressHeader(PreferredIdentityHeader, addrs)
+}
+
+// NewPrivacy builds a Privacy header.
+func NewPrivacy(values ...Privacy) sip.Header {
+	parts := make([]string, 0, len(values))
+	for _, value := range values {
+		parts = append(parts, string(value))
+	}
+	return &sip.GenericHeader{HeaderName: PrivacyHeader, Contents: strings.Join(parts, ";")}
+}
+
+// ApplyPrivacy removes the asserted identity of a request leaving the trust domain if Privacy: id was requested.
+func ApplyPrivacy(msg sip.Message, trusted bool) {
+	if trusted || !HasPrivacy(msg, PrivacyID) {
+		return
+	}
+	msg.RemoveHeader(AssertedIdentityHeader)
+}
+
+func addresses(msg sip.Message, name string) []sip.Address {
+	addrs := make([]sip.Address, 0)
+	for _, hdr := range msg.GetHeaders(name) {
+		for _, value := range splitAddresses(hdr.Value()) {
+			displayName, uri, params, err := parser.ParseAddressValue(value)
+			if err != nil {
+				continue
+			}
+			addrs = append(addrs, sip.Address{DisplayName: displayName, Uri: uri, Params: params})
+		}
+	}
+	return addrs
+}
+
+// splitAddresses splits a comma separated address list, ignoring commas in quotes and brackets.
+func splitAddresses(value string) []string {
+	values := make([]string, 0)
+	inQuotes, inBrackets := false, false
+	start := 0
+	for i, c := range value {
+		switch c {
+		case '"':
+			inQuotes = !inQuotes
+		case '<':
+			if !inQuotes {
+				inBrackets = true
+			}
+		case '>':
+			if !inQuotes {
+				inBrackets = false
+			}
+		case ',':
+			if !inQuotes && !inBrackets {
+				values = append(values, strings.TrimSpace(value[start:i]))
+				start = i + 1
+			}
+		}
+	}
+	if last := strings.TrimSpace(value[start:]); len(last) > 0 {
+		values = append(values, last)
+	}
+	return values
+}
+
+func newAddressHeader(name string, addrs []sip.Address) sip.Header {
+	values := make([]string, 0, len(addrs))
+	for _, addr := range addrs {
+		value := "<" + addr.Uri.String() + ">"
+		if addr.DisplayName != nil && len(addr.DisplayName.String()) > 0 {
+			value = "\"" + addr.DisplayName.String() + "\" " + value
+		}
+		values = append(values, value)
+	}
+	return &sip.GenericHeader{HeaderName: name, Contents: strings.Join(values, ", ")}
+}
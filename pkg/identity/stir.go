@@ -0,0 +1,97 @@
+package identity
+
+import (
+	"fmt"
+
+	"github.com/ghettovoice/gosip/sip"
+)
+
+const (
+	// IdentityHeader carries the PASSporT of STIR/SHAKEN (RFC 8224).
+	IdentityHeader = "Identity"
+)
+
+// Attestation SHAKEN attestation level.
+type Attestation string
+
+const (
+	AttestationFull    Attestation = "A"
+	AttestationPartial Attestation = "B"
+	AttestationGateway Attestation = "C"
+	AttestationUnknown Attestation = ""
+)
+
+// verstat values (TN-Validation).
+const (
+	VerificationPassed   = "TN-Validation-Passed"
+	VerificationFailed   = "TN-Validation-Failed"
+	VerificationNotFound = "No-TN-Validation"
+)
+
+// Signer signs an outgoing INVITE, returns the value of the Identity header.
+type Signer interface {
+	Sign(request sip.Request) (string, error)
+}
+
+// Verifier verifies the Identity header of an incoming INVITE.
+// A *VerificationError rejects the INVITE with its status code (RFC 8224 436-438).
+type Verifier interface {
+	Verify(request sip.Request, identity string) (*Verification, error)
+}
+
+// Verification result of the Identity verification, exposed on the session.
+type Verification struct {
+	// Status verstat value, e.g. TN-Validation-Passed.
+	Status      string
+	Attestation Attestation
+	OrigTN      string
+	DestTN      []string
+	OrigID      string
+}
+
+// Passed .
+func (v *Verification) Passed() bool {
+	return v != nil && v.Status == VerificationPassed
+}
+
+// VerificationError rejects the request with StatusCode.
+type VerificationError struct {
+	StatusCode sip.StatusCode
+	Reason     string
+}
+
+func (e *VerificationError) Error() string {
+	return fmt.Sprintf("identity verification failed: %d %s", e.StatusCode, e.Reason)
+}
+
+var (
+	// ErrBadIdentityInfo 436 Bad Identity Info.
+	ErrBadIdentityInfo = &VerificationError{StatusCode: 436, Reason: "Bad Identity Info"}
+	// ErrUnsupportedCredential 437 Unsupported Credential.
+	ErrUnsupportedCredential = &VerificationError{StatusCode: 437, Reason: "Unsupported Credential"}
+	// ErrInvalidIdentity 438 Invalid Identity Header.
+	ErrInvalidIdentity = &VerificationError{StatusCode: 438, Reason: "Invalid Identity Header"}
+)
+
+// Sign adds the Identity header to the request.
+func Sign(signer Signer, request sip.Request) error {
+	if len(request.GetHeaders(IdentityHeader)) > 0 {
+		return nil
+	}
+	value, err := signer.Sign(request)
+	if err != nil {
+		return err
+	}
+	request.AppendHeader(&sip.GenericHeader{HeaderName: IdentityHeader, Contents: value})
+	return nil
+}
+
+// Verify runs the verifier on the Identity header of the request, a request without
+// Identity is passed to the verifier with an empty identity.
+func Verify(verifier Verifier, request sip.Request) (*Verification, error) {
+	value := ""
+	if hdrs := request.GetHeaders(IdentityHeader); len(hdrs) > 0 {
+		value = hdrs[0].Value()
+	}
+	return verifier.Verify(request, value)
+}
@@ -0,0 +1,233 @@
+package interop
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+
+	"github.com/ghettovoice/gosip/sip"
+	"github.com/sergeyu/go-sip-ua/pkg/account"
+	"github.com/sergeyu/go-sip-ua/pkg/media"
+	"github.com/sergeyu/go-sip-ua/pkg/session"
+	"github.com/sergeyu/go-sip-ua/pkg/stack"
+	"github.com/sergeyu/go-sip-ua/pkg/ua"
+)
+
+// Client a user of a server: a stack listening for UDP on the loopback address, its user agent and the
+// account of the user. The calls received are answered, the REFERs followed.
+type Client struct {
+	Server    Server
+	Stack     *stack.SipStack
+	UserAgent *ua.UserAgent
+	Profile   *account.Profile
+	// Addr the host:port the stack listens on.
+	Addr string
+
+	sdp      string
+	register *ua.Register
+	calls    chan *Call
+	// transfers the calls made to the targets of the REFERs received.
+	transfers chan *Call
+}
+
+// Call a call of a Client, made or received: its session and its states.
+type Call struct {
+	Session *session.Session
+	states  chan session.Status
+	// answered closed once the call is confirmed, ended once it failed or was hung up.
+	answered chan struct{}
+	ended    chan struct{}
+	answer   sync.Once
+	end      sync.Once
+}
+
+// NewClient a client of user at server listening on port of 127.0.0.1, its SDP offering PCMU on the next
+// port: the servers only answer, echo or transfer the calls, no RTP is sent.
+func NewClient(server Server, user string, port int) (*Client, error) {
+	c := &Client{
+		Server:    server,
+		Addr:      "127.0.0.1:" + strconv.Itoa(port),
+		sdp:       media.NewSDP("127.0.0.1", media.NewAudio(port+1, media.PCMU)).String(),
+		calls:     make(chan *Call, 8),
+		transfers: make(chan *Call, 8),
+	}
+	c.Stack = stack.NewSipStack(&stack.SipStackConfig{Host: "127.0.0.1", UserAgent: "go-sip-ua/interop"})
+	if err := c.Stack.Listen("udp", c.Addr); err != nil {
+		c.Stack.Shutdown()
+		return nil, err
+	}
+	userAgent, err := ua.NewUserAgent(ua.WithSipStack(c.Stack))
+	if err != nil {
+		c.Stack.Shutdown()
+		return nil, err
+	}
+	c.UserAgent = userAgent
+	c.UserAgent.InviteStateHandler = c.answer
+	c.UserAgent.OnRefer(c.followRefer)
+
+	var authInfo *account.AuthInfo
+	if server.Password != "" {
+		authInfo = &account.AuthInfo{AuthUser: user, Password: server.Password}
+	}
+	uri := server.URI(user)
+	c.Profile = account.NewProfile(&uri, user, authInfo, 600, c.Stack)
+	return c, nil
+}
+
+// Close unregisters the client if registered and shuts its user agent down.
+func (c *Client) Close() {
+	if c.register != nil {
+		c.register.SendRegister(0)
+		c.register.Stop()
+	}
+	c.UserAgent.Shutdown()
+}
+
+// Register registers the client on its server for expires seconds, unregisters it if 0.
+func (c *Client) Register(expires uint32) error {
+	if c.register == nil {
+		register, err := c.UserAgent.SendRegister(c.Profile, c.Server.URI(""), expires, nil)
+		c.register = register
+		return err
+	}
+	return c.register.SendRegister(expires)
+}
+
+// Call calls target, an extension or a user of the server, and waits for the call to be answered.
+func (c *Client) Call(ctx context.Context, target string) (*Call, error) {
+	call := newCall()
+	sdp := c.sdp
+	uri := c.Server.URI(target)
+	is, err := c.UserAgent.InviteWithHandler(ctx, c.Profile, &uri, c.Server.URI(""), &sdp, c.handler(call))
+	if err != nil {
+		return nil, err
+	}
+	call.Session = is
+	return call, call.WaitFor(ctx, session.Confirmed)
+}
+
+// Incoming waits for a call to the client, answered.
+func (c *Client) Incoming(ctx context.Context) (*Call, error) {
+	select {
+	case call := <-c.calls:
+		return call, call.WaitFor(ctx, session.Confirmed)
+	case <-ctx.Done():
+		return nil, fmt.Errorf("no call to %s: %v", c.Profile.URI, ctx.Err())
+	}
+}
+
+// Transferred waits for a call made to the target of a REFER received, answered.
+func (c *Client) Transferred(ctx context.Context) (*Call, error) {
+	select {
+	case call := <-c.transfers:
+		return call, call.WaitFor(ctx, session.Confirmed)
+	case <-ctx.Done():
+		return nil, fmt.Errorf("no REFER to %s: %v", c.Profile.URI, ctx.Err())
+	}
+}
+
+// answer answers the calls received, their states passed to their Call.
+func (c *Client) answer(s *session.Session, req *sip.Request, resp *sip.Response, status session.Status) {
+	if status != session.InviteReceived {
+		return
+	}
+	call := newCall()
+	call.Session = s
+	s.SetHandler(c.handler(call))
+	select {
+	case c.calls <- call:
+	default:
+	}
+	s.ProvideAnswer(c.sdp)
+	s.Accept(200)
+}
+
+// followRefer calls the target of a REFER through the server and notifies the referrer of the outcome.
+func (c *Client) followRefer(n *ua.Notifier, referTo sip.Address, referredBy *sip.Address) {
+	go func() {
+		call := newCall()
+		sdp := c.sdp
+		is, err := c.UserAgent.InviteWithHandler(context.Background(), c.Profile, referTo.Uri, c.Server.URI(""), &sdp, c.handler(call))
+		if err != nil {
+			n.NotifyReferStatus(503, "Service Unavailable")
+			return
+		}
+		call.Session = is
+		select {
+		case <-call.answered:
+			n.NotifyReferStatus(200, "OK")
+		case <-call.ended:
+			n.NotifyReferStatus(487, "Request Terminated")
+		}
+		select {
+		case c.transfers <- call:
+		default:
+		}
+	}()
+}
+
+// handler the handler of the session of call: the re-INVITEs answered, e.g. the hold of the peer, the
+// states passed to call.
+func (c *Client) handler(call *Call) session.Handler {
+	return func(s *session.Session, req *sip.Request, resp *sip.Response, status session.Status) {
+		if status == session.ReInviteReceived {
+			s.ProvideAnswer(c.sdp)
+			s.Accept(200)
+		}
+		call.handle(status)
+	}
+}
+
+func newCall() *Call {
+	return &Call{states: make(chan session.Status, 16), answered: make(chan struct{}), ended: make(chan struct{})}
+}
+
+func (call *Call) handle(status session.Status) {
+	select {
+	case call.states <- status:
+	default:
+	}
+	switch status {
+	case session.Confirmed:
+		call.answer.Do(func() { close(call.answered) })
+	case session.Failure, session.Canceled, session.Terminated:
+		call.end.Do(func() { close(call.ended) })
+	}
+}
+
+// WaitFor waits for the call to reach status, an error if it ends before.
+func (call *Call) WaitFor(ctx context.Context, status session.Status) error {
+	for {
+		select {
+		case s := <-call.states:
+			if s == status {
+				return nil
+			}
+			switch s {
+			case session.Failure, session.Canceled, session.Terminated:
+				return fmt.Errorf("call ended: %v", s)
+			}
+		case <-ctx.Done():
+			return fmt.Errorf("call not %v: %v", status, ctx.Err())
+		}
+	}
+}
+
+// Hangup ends the call and waits for it to be terminated.
+func (call *Call) Hangup(ctx context.Context) error {
+	if err := call.Session.End(); err != nil {
+		return err
+	}
+	return call.WaitEnded(ctx)
+}
+
+// WaitEnded waits for the call to end, hung up by either side.
+func (call *Call) WaitEnded(ctx context.Context) error {
+	select {
+	case <-call.ended:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("call not ended: %v", ctx.Err())
+	}
+}
@@ -0,0 +1,140 @@
+// Package interop the interoperability tests of the user agent against the SIP servers of the compose
+// file of testdata, Kamailio as a proxy and registrar, Asterisk and FreeSWITCH as PBXes: the register,
+// call and transfer flows of the package are run against each of them, built with the interop tag:
+//
+//	go test -tags interop -v ./pkg/interop/
+//
+// The tests start the containers with docker compose and remove them once done, left running with
+// INTEROP_KEEP=1, e.g. to look at their logs. The images may be overridden with KAMAILIO_IMAGE,
+// ASTERISK_IMAGE and FREESWITCH_IMAGE. The servers run in the network of the host, on the loopback
+// address, so the tests need Linux.
+package interop
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/ghettovoice/gosip/sip"
+	"github.com/ghettovoice/gosip/sip/parser"
+	"github.com/sergeyu/go-sip-ua/pkg/account"
+	"github.com/sergeyu/go-sip-ua/pkg/ua"
+)
+
+// Server a SIP server of the compose file.
+type Server struct {
+	// Name the service of the compose file, e.g. asterisk.
+	Name string
+	// Addr the host:port it listens on for UDP.
+	Addr string
+	// Password the password of the digest authentication of its users, none if empty.
+	Password string
+	// Echo the extension answering the calls with an echo of their audio, and Transfer the one
+	// transferring the calls to Echo with a REFER, empty for a proxy: the calls are made to its users.
+	Echo     string
+	Transfer string
+}
+
+// The servers of the compose file, their users alice, bob and carol.
+var (
+	Kamailio   = Server{Name: "kamailio", Addr: "127.0.0.1:5060"}
+	Asterisk   = Server{Name: "asterisk", Addr: "127.0.0.1:5070", Password: "interop", Echo: "600", Transfer: "700"}
+	FreeSWITCH = Server{Name: "freeswitch", Addr: "127.0.0.1:5080", Password: "interop", Echo: "600", Transfer: "700"}
+)
+
+// Servers the servers of the compose file.
+func Servers() []Server {
+	return []Server{Kamailio, Asterisk, FreeSWITCH}
+}
+
+// IsProxy whether the server routes the calls to its users rather than answering them.
+func (s Server) IsProxy() bool {
+	return s.Echo == ""
+}
+
+// URI the SIP URI of user at the server, e.g. sip:alice@127.0.0.1:5070, of the server itself if user is
+// empty.
+func (s Server) URI(user string) sip.SipUri {
+	if user != "" {
+		user += "@"
+	}
+	uri, err := parser.ParseSipUri("sip:" + user + s.Addr)
+	if err != nil {
+		panic(err)
+	}
+	return uri
+}
+
+// WaitReady pings the server with OPTIONS from the user agent until it answers, with any response, or ctx
+// is done.
+func (s Server) WaitReady(ctx context.Context, userAgent *ua.UserAgent, profile *account.Profile) error {
+	ready := make(chan struct{}, 1)
+	ping := userAgent.StartOptionsPing(profile, s.URI(""), time.Second, func(state ua.OptionsPingState) {
+		if state.Reachable {
+			select {
+			case ready <- struct{}{}:
+			default:
+			}
+		}
+	}, nil)
+	defer ping.Stop()
+
+	select {
+	case <-ready:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("%s not ready: %v", s.Name, ctx.Err())
+	}
+}
+
+// Compose the containers of a compose file, run with docker compose or docker-compose.
+type Compose struct {
+	// File the compose file and Project the name of the project of its containers.
+	File    string
+	Project string
+}
+
+// Up starts the services, all of the file if none.
+func (c *Compose) Up(ctx context.Context, services ...string) error {
+	_, err := c.run(ctx, append([]string{"up", "-d"}, services...)...)
+	return err
+}
+
+// Down stops and removes the containers.
+func (c *Compose) Down(ctx context.Context) error {
+	_, err := c.run(ctx, "down", "--remove-orphans")
+	return err
+}
+
+// Logs the logs of a service, e.g. to report a failure.
+func (c *Compose) Logs(ctx context.Context, service string) string {
+	out, err := c.run(ctx, "logs", "--no-color", "--tail", "200", service)
+	if err != nil {
+		return err.Error()
+	}
+	return out
+}
+
+func (c *Compose) run(ctx context.Context, args ...string) (string, error) {
+	name, args := composeCommand(ctx, append([]string{"-f", c.File, "-p", c.Project}, args...))
+	cmd := exec.CommandContext(ctx, name, args...)
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	if err := cmd.Run(); err != nil {
+		return out.String(), fmt.Errorf("%s %s: %v: %s", name, strings.Join(args, " "), err, strings.TrimSpace(out.String()))
+	}
+	return out.String(), nil
+}
+
+// composeCommand the command running compose with args, the compose plugin of docker if installed, else
+// docker-compose.
+func composeCommand(ctx context.Context, args []string) (string, []string) {
+	if exec.CommandContext(ctx, "docker", "compose", "version").Run() == nil {
+		return "docker", append([]string{"compose"}, args...)
+	}
+	return "docker-compose", args
+}
@@ -0,0 +1,250 @@
+//go:build interop
+// +build interop
+
+package interop_test
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"testing"
+	"time"
+
+	"github.com/ghettovoice/gosip/log"
+	"github.com/sergeyu/go-sip-ua/pkg/interop"
+	"github.com/sergeyu/go-sip-ua/pkg/session"
+	"github.com/sergeyu/go-sip-ua/pkg/ua"
+	"github.com/sergeyu/go-sip-ua/pkg/utils"
+)
+
+// flowTimeout how long a flow may take, a call answered or a REFER followed.
+const flowTimeout = 20 * time.Second
+
+var compose = &interop.Compose{File: "testdata/docker-compose.yml", Project: "go-sip-ua-interop"}
+
+func TestMain(m *testing.M) {
+	flag.Parse()
+	if _, err := exec.LookPath("docker"); err != nil {
+		fmt.Println("interop: docker not found, skipped")
+		os.Exit(0)
+	}
+	if !testing.Verbose() {
+		utils.SetLogSink(utils.LogSinkFunc(func(level log.Level, msg string, fields log.Fields) {}))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	err := compose.Up(ctx)
+	if err == nil {
+		err = waitReady(ctx)
+	}
+	cancel()
+
+	code := 1
+	if err != nil {
+		fmt.Println("interop:", err)
+	} else {
+		code = m.Run()
+	}
+	if os.Getenv("INTEROP_KEEP") == "" {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		if err := compose.Down(ctx); err != nil {
+			fmt.Println("interop:", err)
+		}
+		cancel()
+	}
+	os.Exit(code)
+}
+
+// waitReady waits for the servers to answer OPTIONS, their logs printed if one does not.
+func waitReady(ctx context.Context) error {
+	for _, server := range interop.Servers() {
+		client, err := interop.NewClient(server, "probe", 5199)
+		if err != nil {
+			return err
+		}
+		serverCtx, cancel := context.WithTimeout(ctx, 2*time.Minute)
+		err = server.WaitReady(serverCtx, client.UserAgent, client.Profile)
+		cancel()
+		client.Close()
+		if err != nil {
+			fmt.Println(compose.Logs(ctx, server.Name))
+			return err
+		}
+	}
+	return nil
+}
+
+// newClient a client of user at server, closed at the end of the test.
+func newClient(t *testing.T, server interop.Server, user string, port int) *interop.Client {
+	t.Helper()
+	client, err := interop.NewClient(server, user, port)
+	if err != nil {
+		t.Fatalf("NewClient(%s, %s) = %v", server.Name, user, err)
+	}
+	return client
+}
+
+func TestRegister(t *testing.T) {
+	for _, server := range interop.Servers() {
+		server := server
+		t.Run(server.Name, func(t *testing.T) {
+			alice := newClient(t, server, "alice", 5160)
+			defer alice.Close()
+
+			if err := alice.Register(60); err != nil {
+				t.Fatalf("Register(60) = %v; want nil", err)
+			}
+			if err := alice.Register(0); err != nil {
+				t.Errorf("Register(0) = %v; want nil", err)
+			}
+		})
+	}
+}
+
+func TestCall(t *testing.T) {
+	for _, server := range interop.Servers() {
+		server := server
+		t.Run(server.Name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), flowTimeout)
+			defer cancel()
+
+			alice := newClient(t, server, "alice", 5160)
+			defer alice.Close()
+			target := server.Echo
+			var bob *interop.Client
+			if server.IsProxy() {
+				bob = newClient(t, server, "bob", 5170)
+				defer bob.Close()
+				if err := bob.Register(60); err != nil {
+					t.Fatalf("Register(60) = %v; want nil", err)
+				}
+				target = "bob"
+			}
+
+			call, err := alice.Call(ctx, target)
+			if err != nil {
+				t.Fatalf("Call(%s) = %v; want nil", target, err)
+			}
+			var answered *interop.Call
+			if bob != nil {
+				if answered, err = bob.Incoming(ctx); err != nil {
+					t.Fatalf("Incoming() = %v; want nil", err)
+				}
+			}
+
+			if err := call.Session.Hold(); err != nil {
+				t.Fatalf("Hold() = %v; want nil", err)
+			}
+			if err := call.WaitFor(ctx, session.Confirmed); err != nil {
+				t.Fatalf("Hold: %v", err)
+			}
+			if err := call.Session.Unhold(); err != nil {
+				t.Fatalf("Unhold() = %v; want nil", err)
+			}
+			if err := call.WaitFor(ctx, session.Confirmed); err != nil {
+				t.Fatalf("Unhold: %v", err)
+			}
+
+			if err := call.Hangup(ctx); err != nil {
+				t.Errorf("Hangup() = %v; want nil", err)
+			}
+			if answered != nil {
+				if err := answered.WaitEnded(ctx); err != nil {
+					t.Errorf("callee: %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestTransfer(t *testing.T) {
+	for _, server := range interop.Servers() {
+		server := server
+		t.Run(server.Name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), flowTimeout)
+			defer cancel()
+
+			alice := newClient(t, server, "alice", 5160)
+			defer alice.Close()
+			if server.IsProxy() {
+				referOutOfDialog(ctx, t, server, alice)
+				return
+			}
+
+			// The PBX answers, REFERs alice to the echo and hangs up once it was called.
+			call, err := alice.Call(ctx, server.Transfer)
+			if err != nil {
+				t.Fatalf("Call(%s) = %v; want nil", server.Transfer, err)
+			}
+			transferred, err := alice.Transferred(ctx)
+			if err != nil {
+				t.Fatalf("Transferred() = %v; want nil", err)
+			}
+			if err := call.WaitEnded(ctx); err != nil {
+				t.Errorf("transferred call: %v", err)
+			}
+			if err := transferred.Hangup(ctx); err != nil {
+				t.Errorf("Hangup() = %v; want nil", err)
+			}
+		})
+	}
+}
+
+// referOutOfDialog alice calls bob and REFERs him to carol out of the dialog, with its Target-Dialog,
+// through the proxy.
+func referOutOfDialog(ctx context.Context, t *testing.T, server interop.Server, alice *interop.Client) {
+	bob := newClient(t, server, "bob", 5170)
+	defer bob.Close()
+	carol := newClient(t, server, "carol", 5180)
+	defer carol.Close()
+	for _, client := range []*interop.Client{bob, carol} {
+		if err := client.Register(60); err != nil {
+			t.Fatalf("Register(60) = %v; want nil", err)
+		}
+	}
+
+	call, err := alice.Call(ctx, "bob")
+	if err != nil {
+		t.Fatalf("Call(bob) = %v; want nil", err)
+	}
+	defer call.Hangup(ctx)
+	if _, err := bob.Incoming(ctx); err != nil {
+		t.Fatalf("Incoming() = %v; want nil", err)
+	}
+
+	notified := make(chan int, 8)
+	bobURI, carolURI := server.URI("bob"), server.URI("carol")
+	_, err = alice.UserAgent.Refer(alice.Profile, &bobURI, server.URI(""), &carolURI, func(status ua.SubscriptionStatus) {
+		if code, _, err := ua.ParseSipFrag(status.Body); err == nil {
+			notified <- int(code)
+		}
+	}, nil, call.Session.NewTargetDialogHeader())
+	if err != nil {
+		t.Fatalf("Refer() = %v; want nil", err)
+	}
+
+	transferred, err := bob.Transferred(ctx)
+	if err != nil {
+		t.Fatalf("Transferred() = %v; want nil", err)
+	}
+	defer transferred.Hangup(ctx)
+	if _, err := carol.Incoming(ctx); err != nil {
+		t.Fatalf("Incoming() = %v; want nil", err)
+	}
+	for {
+		select {
+		case code := <-notified:
+			if code < 200 {
+				continue
+			}
+			if code != 200 {
+				t.Errorf("NOTIFY sipfrag = %d; want 200", code)
+			}
+			return
+		case <-ctx.Done():
+			t.Fatalf("no final NOTIFY: %v", ctx.Err())
+		}
+	}
+}
@@ -0,0 +1,200 @@
+// Package loadtest originates calls from a user agent at a rate, e.g. to load a PBX or a proxy or to
+// validate the scalability of the stack itself: each call is held for a time once answered and then hung
+// up, the setup latencies and the failures reported.
+//
+//	report, err := loadtest.Run(ctx, userAgent, loadtest.Config{
+//		Profile:     profile,
+//		Target:      target,
+//		Recipient:   recipient,
+//		Calls:       10000,
+//		Concurrency: 500,
+//		CPS:         100,
+//		HoldTime:    5 * time.Second,
+//		SDP:         media.NewSDP(host, media.NewAudio(port, media.PCMU)).String(),
+//	})
+//	fmt.Println(report)
+package loadtest
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ghettovoice/gosip/sip"
+	"github.com/sergeyu/go-sip-ua/pkg/account"
+	"github.com/sergeyu/go-sip-ua/pkg/session"
+	"github.com/sergeyu/go-sip-ua/pkg/ua"
+)
+
+// DefaultSetupTimeout how long a call may ring if the SetupTimeout of the Config is zero.
+const DefaultSetupTimeout = 32 * time.Second
+
+// The failures of the calls not ended by a final response.
+const (
+	FailureSetupTimeout = "setup timeout"
+	FailureInvite       = "INVITE error"
+)
+
+// Config the calls of a Run.
+type Config struct {
+	// Profile the account the calls are made from.
+	Profile *account.Profile
+	// Target the called URI and Recipient the address the INVITEs are sent to, see ua.UserAgent.Invite.
+	Target    sip.Uri
+	Recipient sip.SipUri
+	// Calls how many calls are made, until the context is done if zero.
+	Calls int
+	// Concurrency the most calls in progress at once, a call not started until another ends. Unlimited if
+	// zero.
+	Concurrency int
+	// CPS the calls started per second, as fast as Concurrency allows if zero.
+	CPS float64
+	// HoldTime how long an answered call is held before its BYE.
+	HoldTime time.Duration
+	// SetupTimeout how long a call may ring before it is canceled, DefaultSetupTimeout if zero.
+	SetupTimeout time.Duration
+	// SDP the offer of the INVITEs, none if empty.
+	SDP string
+	// Headers the headers added to the INVITEs.
+	Headers []sip.Header
+}
+
+// Run makes the calls of config from userAgent, the states of their sessions handled by the run instead
+// of its InviteStateHandler, and reports them once they all ended. The calls in progress when ctx is done
+// are hung up.
+func Run(ctx context.Context, userAgent *ua.UserAgent, config Config) (*Report, error) {
+	if config.Profile == nil || config.Target == nil {
+		return nil, fmt.Errorf("loadtest: no Profile or Target")
+	}
+	if config.Calls < 0 || config.Concurrency < 0 || config.CPS < 0 {
+		return nil, fmt.Errorf("loadtest: negative Calls, Concurrency or CPS")
+	}
+	if config.SetupTimeout <= 0 {
+		config.SetupTimeout = DefaultSetupTimeout
+	}
+
+	var rate <-chan time.Time
+	if config.CPS > 0 {
+		ticker := time.NewTicker(time.Duration(float64(time.Second) / config.CPS))
+		defer ticker.Stop()
+		rate = ticker.C
+	}
+	var slots chan struct{}
+	if config.Concurrency > 0 {
+		slots = make(chan struct{}, config.Concurrency)
+	}
+
+	report := newReport()
+	wg := new(sync.WaitGroup)
+	start := time.Now()
+loop:
+	for i := 0; config.Calls == 0 || i < config.Calls; i++ {
+		if rate != nil {
+			select {
+			case <-rate:
+			case <-ctx.Done():
+				break loop
+			}
+		}
+		if slots != nil {
+			select {
+			case slots <- struct{}{}:
+			case <-ctx.Done():
+				break loop
+			}
+		} else if ctx.Err() != nil {
+			break
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			report.add(call(ctx, userAgent, &config))
+			if slots != nil {
+				<-slots
+			}
+		}()
+	}
+	wg.Wait()
+	report.Duration = time.Since(start)
+	return report, nil
+}
+
+// result the outcome of a call: answered after setup, or its failure.
+type result struct {
+	setup   time.Duration
+	failure string
+}
+
+// call makes a call, holds it once answered and hangs it up.
+func call(ctx context.Context, userAgent *ua.UserAgent, config *Config) result {
+	states := make(chan *sip.Response, 8)
+	ended := make(chan *sip.Response, 1)
+	handler := func(s *session.Session, req *sip.Request, resp *sip.Response, status session.Status) {
+		switch status {
+		case session.Confirmed:
+			select {
+			case states <- resp:
+			default:
+			}
+		case session.Failure, session.Canceled, session.Terminated:
+			select {
+			case ended <- resp:
+			default:
+			}
+		}
+	}
+
+	var body *string
+	if config.SDP != "" {
+		body = &config.SDP
+	}
+	start := time.Now()
+	is, err := userAgent.InviteWithHandler(ctx, config.Profile, config.Target, config.Recipient, body, handler, config.Headers...)
+	if err != nil || is == nil {
+		return result{failure: FailureInvite}
+	}
+
+	timer := time.NewTimer(config.SetupTimeout)
+	defer timer.Stop()
+	select {
+	case <-states:
+	case resp := <-ended:
+		return result{failure: failure(is, resp)}
+	case <-timer.C:
+		is.End()
+		return result{failure: FailureSetupTimeout}
+	case <-ctx.Done():
+		is.End()
+		return result{failure: ctx.Err().Error()}
+	}
+	r := result{setup: time.Since(start)}
+
+	hold := time.NewTimer(config.HoldTime)
+	defer hold.Stop()
+	select {
+	case <-hold.C:
+	case <-ended:
+		// Hung up by the callee.
+		return r
+	case <-ctx.Done():
+	}
+	is.End()
+	// The 200 to the BYE, or its timeout.
+	select {
+	case <-ended:
+	case <-time.After(DefaultSetupTimeout):
+	}
+	return r
+}
+
+// failure the failure of a call ended by resp, its status or the cause of the session.
+func failure(is *session.Session, resp *sip.Response) string {
+	if resp != nil && *resp != nil {
+		return fmt.Sprintf("%d %s", (*resp).StatusCode(), (*resp).Reason())
+	}
+	if rc := is.ReleaseCause(); rc != nil {
+		return string(rc.Cause)
+	}
+	return string(session.CauseUnknown)
+}
@@ -0,0 +1,93 @@
+package loadtest
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Report the outcome of the calls of a Run.
+type Report struct {
+	// Calls the calls made, Answered the ones answered, the others failed.
+	Calls    int
+	Answered int
+	// Failures the calls failed by their final status, e.g. 486 Busy Here, or their cause, e.g.
+	// FailureSetupTimeout.
+	Failures map[string]int
+	// Setup the setup latencies of the calls answered, from their INVITE to their 2xx, in order.
+	Setup []time.Duration
+	// Duration how long the run took.
+	Duration time.Duration
+
+	mu sync.Mutex
+}
+
+func newReport() *Report {
+	return &Report{Failures: make(map[string]int)}
+}
+
+func (r *Report) add(res result) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Calls++
+	if res.failure != "" {
+		r.Failures[res.failure]++
+		return
+	}
+	r.Answered++
+	i := sort.Search(len(r.Setup), func(i int) bool { return r.Setup[i] > res.setup })
+	r.Setup = append(r.Setup, 0)
+	copy(r.Setup[i+1:], r.Setup[i:])
+	r.Setup[i] = res.setup
+}
+
+// Percentile the setup latency p percent of the calls answered were set up within, 0 < p <= 100, e.g. 50
+// for the median. 0 if none was answered.
+func (r *Report) Percentile(p float64) time.Duration {
+	if len(r.Setup) == 0 {
+		return 0
+	}
+	i := int(p/100*float64(len(r.Setup))+0.5) - 1
+	switch {
+	case i < 0:
+		i = 0
+	case i >= len(r.Setup):
+		i = len(r.Setup) - 1
+	}
+	return r.Setup[i]
+}
+
+// CPS the calls made per second over the run.
+func (r *Report) CPS() float64 {
+	if r.Duration <= 0 {
+		return 0
+	}
+	return float64(r.Calls) / r.Duration.Seconds()
+}
+
+// String the report in lines, e.g. to print it at the end of a run.
+func (r *Report) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "calls: %d in %v (%.1f cps), answered: %d, failed: %d\n",
+		r.Calls, r.Duration.Round(time.Millisecond), r.CPS(), r.Answered, r.Calls-r.Answered)
+	if r.Answered > 0 {
+		fmt.Fprintf(&b, "setup: p50 %v, p90 %v, p95 %v, p99 %v, max %v\n",
+			r.Percentile(50), r.Percentile(90), r.Percentile(95), r.Percentile(99), r.Setup[len(r.Setup)-1])
+	}
+	failures := make([]string, 0, len(r.Failures))
+	for failure := range r.Failures {
+		failures = append(failures, failure)
+	}
+	sort.Slice(failures, func(i, j int) bool {
+		if r.Failures[failures[i]] != r.Failures[failures[j]] {
+			return r.Failures[failures[i]] > r.Failures[failures[j]]
+		}
+		return failures[i] < failures[j]
+	})
+	for _, failure := range failures {
+		fmt.Fprintf(&b, "  %s: %d\n", failure, r.Failures[failure])
+	}
+	return b.String()
+}
@@ -0,0 +1,120 @@
+// Package g711 the μ-law and A-law codecs of G.711 between 16 bits linear samples and their 8 bits code.
+package g711
+
+const (
+	ulawBias = 0x84
+	ulawClip = 32635
+)
+
+// alawSegmentEnds the upper bounds of the segments of the 13 bits samples of A-law.
+var alawSegmentEnds = [8]int{0x1f, 0x3f, 0x7f, 0xff, 0x1ff, 0x3ff, 0x7ff, 0xfff}
+
+// LinearToUlaw the μ-law code of sample.
+func LinearToUlaw(sample int16) byte {
+	s := int(sample)
+	sign := 0
+	if s < 0 {
+		s = -s
+		sign = 0x80
+	}
+	if s > ulawClip {
+		s = ulawClip
+	}
+	s += ulawBias
+	exponent := 7
+	for mask := 0x4000; s&mask == 0 && exponent > 0; mask >>= 1 {
+		exponent--
+	}
+	mantissa := (s >> uint(exponent+3)) & 0x0f
+	return ^byte(sign | exponent<<4 | mantissa)
+}
+
+// UlawToLinear the sample of the μ-law code.
+func UlawToLinear(code byte) int16 {
+	code = ^code
+	t := (int(code&0x0f) << 3) + ulawBias
+	t <<= (code & 0x70) >> 4
+	if code&0x80 != 0 {
+		return int16(ulawBias - t)
+	}
+	return int16(t - ulawBias)
+}
+
+// LinearToAlaw the A-law code of sample.
+func LinearToAlaw(sample int16) byte {
+	s := int(sample) >> 3
+	mask := byte(0xd5)
+	if s < 0 {
+		mask = 0x55
+		s = -s - 1
+	}
+	segment := 0
+	for segment < len(alawSegmentEnds) && s > alawSegmentEnds[segment] {
+		segment++
+	}
+	if segment >= len(alawSegmentEnds) {
+		return 0x7f ^ mask
+	}
+	code := byte(segment << 4)
+	if segment < 2 {
+		code |= byte(s>>1) & 0x0f
+	} else {
+		code |= byte(s>>uint(segment)) & 0x0f
+	}
+	return code ^ mask
+}
+
+// AlawToLinear the sample of the A-law code.
+func AlawToLinear(code byte) int16 {
+	code ^= 0x55
+	t := int(code&0x0f) << 4
+	switch segment := (code & 0x70) >> 4; segment {
+	case 0:
+		t += 8
+	case 1:
+		t += 0x108
+	default:
+		t += 0x108
+		t <<= segment - 1
+	}
+	if code&0x80 != 0 {
+		return int16(t)
+	}
+	return int16(-t)
+}
+
+// EncodeUlaw the μ-law codes of samples.
+func EncodeUlaw(samples []int16) []byte {
+	codes := make([]byte, len(samples))
+	for i, sample := range samples {
+		codes[i] = LinearToUlaw(sample)
+	}
+	return codes
+}
+
+// DecodeUlaw the samples of the μ-law codes.
+func DecodeUlaw(codes []byte) []int16 {
+	samples := make([]int16, len(codes))
+	for i, code := range codes {
+		samples[i] = UlawToLinear(code)
+	}
+	return samples
+}
+
+// EncodeAlaw the A-law codes of samples.
+func EncodeAlaw(samples []int16) []byte {
+	codes := make([]byte, len(samples))
+	for i, sample := range samples {
+		codes[i] = LinearToAlaw(sample)
+	}
+	return codes
+}
+
+// DecodeAlaw the samples of the A-law codes.
+func DecodeAlaw(codes []byte) []int16 {
+	samples := make([]int16, len(codes))
+	for i, code := range codes {
+		samples[i] = AlawToLinear(code)
+	}
+	return samples
+}
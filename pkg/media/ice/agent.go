@@ -0,0 +1,704 @@
+package ice
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ghettovoice/gosip/log"
+	"github.com/sergeyu/go-sip-ua/pkg/media/rtp"
+	"github.com/sergeyu/go-sip-ua/pkg/stun"
+	"github.com/sergeyu/go-sip-ua/pkg/utils"
+)
+
+const (
+	// DefaultGatherTimeout bounds the requests to the STUN and TURN servers.
+	DefaultGatherTimeout = 3 * time.Second
+	// DefaultCheckTimeout bounds the connectivity checks until a pair is selected.
+	DefaultCheckTimeout = 10 * time.Second
+	// ta the pacing of the checks (RFC 8445 14.2).
+	ta = 50 * time.Millisecond
+	// checkTimeout bounds the retransmissions of a check.
+	checkTimeout = 2500 * time.Millisecond
+	// nominationDelay the wait of the controlling agent for a better pair once one succeeded.
+	nominationDelay = time.Second
+	// keepaliveInterval the interval of the keepalives of the pair selected (RFC 8445 11).
+	keepaliveInterval = 15 * time.Second
+)
+
+var (
+	// ErrNoPair no candidate pair succeeded before the timeout of the checks.
+	ErrNoPair  = errors.New("ice: no candidate pair succeeded")
+	errTimeout = errors.New("ice: STUN transaction timed out")
+	errClosed  = errors.New("ice: agent closed")
+)
+
+// Config of the agents.
+type Config struct {
+	// STUNServers the host:port of the STUN servers of the server reflexive candidates.
+	STUNServers []string
+	// TURNServers the relays of the relayed candidates.
+	TURNServers []TURNServer
+	// GatherTimeout bounds the requests to the servers, DefaultGatherTimeout if zero.
+	GatherTimeout time.Duration
+	// CheckTimeout bounds the connectivity checks, DefaultCheckTimeout if zero.
+	CheckTimeout time.Duration
+}
+
+// TURNServer a TURN server (RFC 5766) with the long-term credentials of the allocations.
+type TURNServer struct {
+	Address  string
+	Username string
+	Password string
+}
+
+type pairState int
+
+const (
+	pairWaiting pairState = iota
+	pairInProgress
+	pairSucceeded
+	pairFailed
+)
+
+// pair a candidate pair of the check list (RFC 8445 6.1.2).
+type pair struct {
+	local     *Candidate
+	remote    *Candidate
+	state     pairState
+	nominated bool
+}
+
+// response a STUN response and its source.
+type response struct {
+	message *stun.Message
+	from    *net.UDPAddr
+}
+
+// Agent the ICE agent of a stream (RFC 8445): it gathers the candidates of its RTP port, checks the pairs
+// with the ones of the remote and sends the media to the remote candidate of the pair selected.
+// The RTCP packets are multiplexed on the RTP port, only the RTP component is checked.
+type Agent struct {
+	stream     *rtp.Stream
+	config     Config
+	ufrag      string
+	pwd        string
+	tieBreaker uint64
+
+	mu           sync.Mutex
+	controlling  bool
+	local        []*Candidate
+	remote       []*Candidate
+	remoteUfrag  string
+	remotePwd    string
+	pairs        []*pair
+	triggered    []*pair
+	nominating   bool
+	firstSuccess time.Time
+	selected     *pair
+	onSelected   func(local *Candidate, remote *Candidate)
+	pending      map[[12]byte]chan response
+	relay        *allocation
+
+	selectedCh chan struct{}
+	closed     chan struct{}
+	closeOnce  sync.Once
+	log        log.Logger
+}
+
+// NewAgent an agent of stream, controlling if it offers ICE (RFC 8445 6.1.1).
+// It handles the STUN messages received by the stream, and is closed with it.
+func NewAgent(stream *rtp.Stream, config Config, controlling bool) *Agent {
+	if config.GatherTimeout <= 0 {
+		config.GatherTimeout = DefaultGatherTimeout
+	}
+	if config.CheckTimeout <= 0 {
+		config.CheckTimeout = DefaultCheckTimeout
+	}
+	tieBreaker := make([]byte, 8)
+	rand.Read(tieBreaker)
+	a := &Agent{
+		stream:      stream,
+		config:      config,
+		ufrag:       randomString(4),
+		pwd:         randomString(12),
+		tieBreaker:  binary.BigEndian.Uint64(tieBreaker),
+		controlling: controlling,
+		pending:     make(map[[12]byte]chan response),
+		selectedCh:  make(chan struct{}),
+		closed:      make(chan struct{}),
+		log:         utils.NewLogger(log.DebugLevel, "ICE", nil),
+	}
+	stream.OnSTUN(func(buf []byte, from *net.UDPAddr) {
+		a.handleSTUN(buf, from, false)
+	})
+	stream.OnClose(func() {
+		a.Close()
+	})
+	return a
+}
+
+// randomString hex of n random bytes, the ufrag and pwd of at least 4 and 22 characters (RFC 8445 5.3).
+func randomString(n int) string {
+	buf := make([]byte, n)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+func (a *Agent) Log() log.Logger {
+	return a.log
+}
+
+// Credentials the ice-ufrag and ice-pwd of the agent.
+func (a *Agent) Credentials() (string, string) {
+	return a.ufrag, a.pwd
+}
+
+// Controlling whether the agent is controlling, its role may change on a conflict.
+func (a *Agent) Controlling() bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.controlling
+}
+
+// SetControlling the role of the agent, e.g. controlling against an ice-lite remote.
+func (a *Agent) SetControlling(controlling bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.controlling = controlling
+}
+
+// Candidates the local candidates gathered.
+func (a *Agent) Candidates() []*Candidate {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return append([]*Candidate{}, a.local...)
+}
+
+// OnSelected handles the pair selected, the media being sent to its remote candidate.
+func (a *Agent) OnSelected(handler func(local *Candidate, remote *Candidate)) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.onSelected = handler
+}
+
+// Selected the candidates of the pair selected, nil before.
+func (a *Agent) Selected() (*Candidate, *Candidate) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.selected == nil {
+		return nil, nil
+	}
+	return a.selected.local, a.selected.remote
+}
+
+// Gather the host candidates of the RTP port of the stream, its server reflexive ones from the STUN servers
+// and a relayed one from the first TURN server allocating it. The servers failing are skipped.
+func (a *Agent) Gather() error {
+	laddr := a.stream.LocalAddr()
+	hosts := hostIPs(laddr.IP)
+	if len(hosts) == 0 {
+		return fmt.Errorf("ice: no host address")
+	}
+	for i, ip := range hosts {
+		address := &net.UDPAddr{IP: ip, Port: laddr.Port}
+		a.addLocal(newCandidate(TypeHost, address, ip, nil, uint32(65535-i)))
+	}
+	base := &net.UDPAddr{IP: hosts[0], Port: laddr.Port}
+
+	var wg sync.WaitGroup
+	for _, server := range a.config.STUNServers {
+		wg.Add(1)
+		go func(server string) {
+			defer wg.Done()
+			mapped, err := a.binding(server)
+			if err != nil {
+				a.Log().Warnf("STUN server %s: %v", server, err)
+				return
+			}
+			a.addLocal(newCandidate(TypeServerReflexive, mapped, base.IP, base, 65535))
+		}(server)
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for _, server := range a.config.TURNServers {
+			relay, err := a.allocate(server)
+			if err != nil {
+				a.Log().Warnf("TURN server %s: %v", server.Address, err)
+				continue
+			}
+			a.mu.Lock()
+			a.relay = relay
+			a.mu.Unlock()
+			a.addLocal(newCandidate(TypeServerReflexive, relay.mapped, base.IP, base, 65535))
+			a.addLocal(newCandidate(TypeRelay, relay.relayed, relay.relayed.IP, relay.mapped, 65535))
+			return
+		}
+	}()
+	wg.Wait()
+	return nil
+}
+
+// addLocal adds c unless a candidate has its address, e.g. the server reflexive one of a host without NAT.
+func (a *Agent) addLocal(c *Candidate) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, local := range a.local {
+		if local.Address.String() == c.Address.String() {
+			return
+		}
+	}
+	a.local = append(a.local, c)
+}
+
+// binding the server reflexive address of the RTP port from the STUN server.
+func (a *Agent) binding(server string) (*net.UDPAddr, error) {
+	addr, err := net.ResolveUDPAddr("udp4", server)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := a.roundTrip(stun.New(stun.BindingRequest), nil, a.direct(addr), a.config.GatherTimeout)
+	if err != nil {
+		return nil, err
+	}
+	if resp.message.Class() != stun.ClassSuccess {
+		return nil, fmt.Errorf("binding error %d", resp.message.ErrorCode())
+	}
+	return resp.message.MappedAddress()
+}
+
+// direct sends the messages from the RTP port to addr.
+func (a *Agent) direct(addr *net.UDPAddr) func(buf []byte) error {
+	return func(buf []byte) error {
+		return a.stream.WriteTo(buf, addr)
+	}
+}
+
+// sender sends the messages from the base of local to remote, through the relay for a relayed candidate.
+func (a *Agent) sender(local *Candidate, remote *net.UDPAddr) func(buf []byte) error {
+	a.mu.Lock()
+	relay := a.relay
+	a.mu.Unlock()
+	if local.Type == TypeRelay && relay != nil {
+		return func(buf []byte) error {
+			return relay.send(buf, remote)
+		}
+	}
+	return a.direct(remote)
+}
+
+// roundTrip sends the request signed with key if not nil, retransmitted until its response or the timeout.
+func (a *Agent) roundTrip(request *stun.Message, key []byte, send func(buf []byte) error, timeout time.Duration) (response, error) {
+	ch := make(chan response, 1)
+	a.mu.Lock()
+	a.pending[request.ID] = ch
+	a.mu.Unlock()
+	defer func() {
+		a.mu.Lock()
+		delete(a.pending, request.ID)
+		a.mu.Unlock()
+	}()
+
+	buf := request.Marshal(key, true)
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	rto := 100 * time.Millisecond
+	for {
+		if err := send(buf); err != nil {
+			return response{}, err
+		}
+		retransmit := time.NewTimer(rto)
+		select {
+		case resp := <-ch:
+			retransmit.Stop()
+			return resp, nil
+		case <-retransmit.C:
+			if rto < 1600*time.Millisecond {
+				rto *= 2
+			}
+		case <-deadline.C:
+			retransmit.Stop()
+			return response{}, errTimeout
+		case <-a.closed:
+			retransmit.Stop()
+			return response{}, errClosed
+		}
+	}
+}
+
+// handleSTUN handles a message received on the RTP port, or relayed from the TURN allocation.
+func (a *Agent) handleSTUN(buf []byte, from *net.UDPAddr, relayed bool) {
+	m, err := stun.Parse(buf)
+	if err != nil {
+		return
+	}
+	switch {
+	case m.Class() == stun.ClassSuccess || m.Class() == stun.ClassError:
+		a.mu.Lock()
+		ch, ok := a.pending[m.ID]
+		a.mu.Unlock()
+		if ok {
+			select {
+			case ch <- response{message: m, from: from}:
+			default:
+			}
+		}
+	case m.Type == stun.DataIndication && !relayed:
+		a.mu.Lock()
+		relay := a.relay
+		a.mu.Unlock()
+		if relay != nil && relay.server.String() == from.String() {
+			relay.received(m)
+		}
+	case m.Type == stun.BindingRequest:
+		a.handleCheck(m, from, relayed)
+	}
+}
+
+// handleCheck answers the check of the remote and triggers the check of its pair (RFC 8445 7.3).
+func (a *Agent) handleCheck(m *stun.Message, from *net.UDPAddr, relayed bool) {
+	username, _ := m.Get(stun.AttrUsername)
+	a.mu.Lock()
+	remoteUfrag := a.remoteUfrag
+	a.mu.Unlock()
+	expected := a.ufrag + ":" + remoteUfrag
+	if remoteUfrag == "" && len(username) > len(a.ufrag) && string(username[:len(a.ufrag)+1]) == a.ufrag+":" {
+		// The check may arrive before the answer.
+		expected = string(username)
+	}
+	if string(username) != expected || !m.Verify([]byte(a.pwd)) {
+		a.Log().Debugf("drop check from %v with bad credentials", from)
+		return
+	}
+
+	var local *Candidate
+	a.mu.Lock()
+	for _, c := range a.local {
+		if (relayed && c.Type == TypeRelay) || (!relayed && c.Type == TypeHost && local == nil) {
+			local = c
+		}
+	}
+	conflict := false
+	if value, ok := m.Get(stun.AttrICEControlling); ok && a.controlling && len(value) == 8 {
+		if a.tieBreaker >= binary.BigEndian.Uint64(value) {
+			conflict = true
+		} else {
+			a.controlling = false
+		}
+	} else if value, ok := m.Get(stun.AttrICEControlled); ok && !a.controlling && len(value) == 8 {
+		if a.tieBreaker >= binary.BigEndian.Uint64(value) {
+			a.controlling = true
+		} else {
+			conflict = true
+		}
+	}
+	a.mu.Unlock()
+	if local == nil {
+		return
+	}
+	send := a.sender(local, from)
+	if conflict {
+		resp := m.Response(stun.BindingError).Add(stun.AttrErrorCode, stun.ErrorCodeValue(stun.ErrorRoleConflict, "Role Conflict"))
+		send(resp.Marshal([]byte(a.pwd), true))
+		return
+	}
+	resp := m.Response(stun.BindingSuccess)
+	resp.Add(stun.AttrXORMappedAddress, stun.XORAddress(from, resp.ID))
+	send(resp.Marshal([]byte(a.pwd), true))
+
+	a.mu.Lock()
+	remote := a.findRemote(from)
+	if remote == nil {
+		// A peer reflexive candidate (RFC 8445 7.3.1.3).
+		prio := priority(TypePeerReflexive, 65535, Component)
+		if value, ok := m.Get(stun.AttrPriority); ok && len(value) == 4 {
+			prio = binary.BigEndian.Uint32(value)
+		}
+		remote = &Candidate{
+			Foundation: randomString(4),
+			Component:  Component,
+			Priority:   prio,
+			Address:    from,
+			Type:       TypePeerReflexive,
+		}
+		a.remote = append(a.remote, remote)
+	}
+	p := a.findPair(local, remote)
+	if p == nil {
+		p = &pair{local: local, remote: remote}
+		a.pairs = append(a.pairs, p)
+	}
+	if m.Has(stun.AttrUseCandidate) && !a.controlling {
+		p.nominated = true
+	}
+	succeeded := p.state == pairSucceeded
+	if !succeeded && p.state != pairInProgress {
+		p.state = pairWaiting
+		a.triggered = append(a.triggered, p)
+	}
+	a.mu.Unlock()
+
+	if succeeded && p.nominated {
+		a.selectPair(p)
+	}
+}
+
+func (a *Agent) findRemote(addr *net.UDPAddr) *Candidate {
+	for _, c := range a.remote {
+		if c.Address.String() == addr.String() {
+			return c
+		}
+	}
+	return nil
+}
+
+func (a *Agent) findPair(local *Candidate, remote *Candidate) *pair {
+	for _, p := range a.pairs {
+		if p.local == local && p.remote == remote {
+			return p
+		}
+	}
+	return nil
+}
+
+// SetRemote the credentials and the candidates of the remote, pairing them with the local ones.
+func (a *Agent) SetRemote(ufrag string, pwd string, candidates []*Candidate) {
+	a.mu.Lock()
+	a.remoteUfrag = ufrag
+	a.remotePwd = pwd
+	relay := a.relay
+	for _, remote := range candidates {
+		if remote.Component != Component || remote.Address.IP.To4() == nil || a.findRemote(remote.Address) != nil {
+			continue
+		}
+		a.remote = append(a.remote, remote)
+		// The checks are sent from the bases, the host and relayed candidates (RFC 8445 6.1.2.4).
+		for _, local := range a.local {
+			if local.Type == TypeHost || local.Type == TypeRelay {
+				a.pairs = append(a.pairs, &pair{local: local, remote: remote})
+			}
+		}
+	}
+	a.mu.Unlock()
+
+	if relay != nil {
+		for _, remote := range candidates {
+			go relay.permit(remote.Address.IP)
+		}
+	}
+}
+
+// pairPriority the priority of p (RFC 8445 6.1.2.3).
+func (a *Agent) pairPriority(p *pair) uint64 {
+	g, d := uint64(p.local.Priority), uint64(p.remote.Priority)
+	if !a.controlling {
+		g, d = d, g
+	}
+	min, max := g, d
+	if min > max {
+		min, max = max, min
+	}
+	prio := min<<32 + 2*max
+	if g > d {
+		prio++
+	}
+	return prio
+}
+
+// Connect runs the checks of the pairs until one is selected: the controlling agent nominates the best pair
+// succeeded, the controlled one selects the pair nominated by the remote.
+func (a *Agent) Connect(ctx context.Context) error {
+	timeout := time.NewTimer(a.config.CheckTimeout)
+	defer timeout.Stop()
+	ticker := time.NewTicker(ta)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-a.selectedCh:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timeout.C:
+			return ErrNoPair
+		case <-a.closed:
+			return errClosed
+		case <-ticker.C:
+			if p := a.nextPair(); p != nil {
+				go a.check(p, false)
+			}
+			a.nominate()
+		}
+	}
+}
+
+// nextPair the next pair to check, a triggered one or the waiting one of the highest priority.
+func (a *Agent) nextPair() *pair {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.remotePwd == "" {
+		return nil
+	}
+	for len(a.triggered) > 0 {
+		p := a.triggered[0]
+		a.triggered = a.triggered[1:]
+		if p.state == pairWaiting {
+			p.state = pairInProgress
+			return p
+		}
+	}
+	sort.SliceStable(a.pairs, func(i, j int) bool {
+		return a.pairPriority(a.pairs[i]) > a.pairPriority(a.pairs[j])
+	})
+	for _, p := range a.pairs {
+		if p.state == pairWaiting {
+			p.state = pairInProgress
+			return p
+		}
+	}
+	return nil
+}
+
+// nominate the best pair succeeded by the controlling agent, once no better pair is checked or after a delay.
+func (a *Agent) nominate() {
+	a.mu.Lock()
+	if !a.controlling || a.nominating || a.selected != nil || a.firstSuccess.IsZero() {
+		a.mu.Unlock()
+		return
+	}
+	var best *pair
+	pending := false
+	for _, p := range a.pairs {
+		switch p.state {
+		case pairSucceeded:
+			if best == nil || a.pairPriority(p) > a.pairPriority(best) {
+				best = p
+			}
+		case pairWaiting, pairInProgress:
+			if best == nil || a.pairPriority(p) > a.pairPriority(best) {
+				pending = true
+			}
+		}
+	}
+	if best == nil || (pending && time.Since(a.firstSuccess) < nominationDelay) {
+		a.mu.Unlock()
+		return
+	}
+	a.nominating = true
+	a.mu.Unlock()
+	go a.check(best, true)
+}
+
+// check sends a connectivity check of p, nominating it if nominate (RFC 8445 7.2.4).
+func (a *Agent) check(p *pair, nominate bool) {
+	a.mu.Lock()
+	request := stun.New(stun.BindingRequest)
+	request.Add(stun.AttrUsername, []byte(a.remoteUfrag+":"+a.ufrag))
+	request.Add(stun.AttrPriority, stun.Uint32Value(priority(TypePeerReflexive, 65535, Component)))
+	if a.controlling {
+		request.Add(stun.AttrICEControlling, stun.Uint64Value(a.tieBreaker))
+		if nominate {
+			request.Add(stun.AttrUseCandidate, nil)
+		}
+	} else {
+		request.Add(stun.AttrICEControlled, stun.Uint64Value(a.tieBreaker))
+	}
+	key := []byte(a.remotePwd)
+	a.mu.Unlock()
+
+	resp, err := a.roundTrip(request, key, a.sender(p.local, p.remote.Address), checkTimeout)
+
+	a.mu.Lock()
+	if nominate {
+		a.nominating = false
+	}
+	switch {
+	case err != nil:
+		p.state = pairFailed
+	case resp.message.Class() == stun.ClassError && resp.message.ErrorCode() == stun.ErrorRoleConflict:
+		// The role is switched and the pair checked again (RFC 8445 7.2.5.1).
+		a.controlling = !a.controlling
+		p.state = pairWaiting
+		a.triggered = append(a.triggered, p)
+	case resp.message.Class() != stun.ClassSuccess || !resp.message.Verify(key) || resp.from.String() != p.remote.Address.String():
+		// The responses come from the address checked (RFC 8445 7.2.5.2.1).
+		p.state = pairFailed
+	default:
+		p.state = pairSucceeded
+		if a.firstSuccess.IsZero() {
+			a.firstSuccess = time.Now()
+		}
+		if nominate {
+			p.nominated = true
+		}
+	}
+	selected := p.state == pairSucceeded && p.nominated
+	a.mu.Unlock()
+
+	if selected {
+		a.selectPair(p)
+	}
+}
+
+// selectPair sends the media to the remote candidate of p, through the relay for a relayed local one.
+func (a *Agent) selectPair(p *pair) {
+	a.mu.Lock()
+	if a.selected != nil {
+		a.mu.Unlock()
+		return
+	}
+	a.selected = p
+	relay := a.relay
+	handler := a.onSelected
+	a.mu.Unlock()
+
+	a.Log().Infof("selected pair %s %v -> %s %v", p.local.Type, p.local.Address, p.remote.Type, p.remote.Address)
+	if p.local.Type == TypeRelay && relay != nil {
+		a.stream.SetRelay(relay.send)
+	} else {
+		a.stream.SetRelay(nil)
+	}
+	a.stream.SetRemote(p.remote.Address)
+	close(a.selectedCh)
+	go a.keepalive(p)
+	if handler != nil {
+		handler(p.local, p.remote)
+	}
+}
+
+// keepalive sends binding indications on the pair selected.
+func (a *Agent) keepalive(p *pair) {
+	ticker := time.NewTicker(keepaliveInterval)
+	defer ticker.Stop()
+	send := a.sender(p.local, p.remote.Address)
+	for {
+		select {
+		case <-ticker.C:
+			send(stun.New(stun.BindingIndication).Marshal(nil, true))
+		case <-a.closed:
+			return
+		}
+	}
+}
+
+// Close stops the checks and the keepalives, and releases the TURN allocation.
+func (a *Agent) Close() error {
+	a.closeOnce.Do(func() {
+		close(a.closed)
+		a.stream.OnSTUN(nil)
+		a.mu.Lock()
+		relay := a.relay
+		a.mu.Unlock()
+		if relay != nil {
+			relay.release()
+		}
+	})
+	return nil
+}
@@ -0,0 +1,50 @@
+package ice
+
+import "testing"
+
+func TestPriority(t *testing.T) {
+	tests := []struct {
+		typ             string
+		localPreference uint32
+		want            uint32
+	}{
+		{TypeHost, 65535, 126<<24 | 65535<<8 | 255},
+		{TypePeerReflexive, 65535, 110<<24 | 65535<<8 | 255},
+		{TypeServerReflexive, 65534, 100<<24 | 65534<<8 | 255},
+		{TypeRelay, 0, 255},
+	}
+	for _, tt := range tests {
+		if got := priority(tt.typ, tt.localPreference, Component); got != tt.want {
+			t.Errorf("priority(%s, %d) = %d; want %d", tt.typ, tt.localPreference, got, tt.want)
+		}
+	}
+}
+
+// TestPairPriority 2^32*MIN(G,D) + 2*MAX(G,D) + (G>D?1:0), G the priority of the candidate of the
+// controlling agent (RFC 8445 6.1.2.3): the same for both agents.
+func TestPairPriority(t *testing.T) {
+	tests := []struct {
+		local, remote uint32
+		controlling   bool
+		want          uint64
+	}{
+		{100, 200, true, 100<<32 + 2*200},
+		{200, 100, true, 100<<32 + 2*200 + 1},
+		{100, 200, false, 100<<32 + 2*200 + 1},
+		{200, 100, false, 100<<32 + 2*200},
+		{150, 150, true, 150<<32 + 2*150},
+		{0xffffffff, 0xfffffffe, true, 0xfffffffe<<32 + 2*0xffffffff + 1},
+	}
+	for _, tt := range tests {
+		a := &Agent{controlling: tt.controlling}
+		p := &pair{local: &Candidate{Priority: tt.local}, remote: &Candidate{Priority: tt.remote}}
+		if got := a.pairPriority(p); got != tt.want {
+			t.Errorf("pairPriority(%d, %d) controlling %v = %d; want %d", tt.local, tt.remote, tt.controlling, got, tt.want)
+		}
+		// The remote agent, of the other role, orders the pair the same.
+		remote := &Agent{controlling: !tt.controlling}
+		if got := remote.pairPriority(&pair{local: p.remote, remote: p.local}); got != tt.want {
+			t.Errorf("pairPriority of the remote agent = %d; want %d", got, tt.want)
+		}
+	}
+}
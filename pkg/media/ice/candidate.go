@@ -0,0 +1,148 @@
+package ice
+
+import (
+	"fmt"
+	"hash/crc32"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// The types of the candidates (RFC 8445 5.1.1).
+const (
+	TypeHost            = "host"
+	TypeServerReflexive = "srflx"
+	TypePeerReflexive   = "prflx"
+	TypeRelay           = "relay"
+)
+
+// typePreferences the type preferences of the priorities (RFC 8445 5.1.2.2).
+var typePreferences = map[string]uint32{
+	TypeHost:            126,
+	TypePeerReflexive:   110,
+	TypeServerReflexive: 100,
+	TypeRelay:           0,
+}
+
+// Component the component of the candidates, the RTP one, RTCP being multiplexed with it.
+const Component = 1
+
+// Candidate a transport address of a media (RFC 8445 5.1.1), an a=candidate attribute (RFC 8839 5.1).
+type Candidate struct {
+	Foundation string
+	Component  int
+	Priority   uint32
+	Address    *net.UDPAddr
+	Type       string
+	// Related the base of a reflexive candidate or the mapped address of a relayed one.
+	Related *net.UDPAddr
+}
+
+// priority the priority of a candidate of typ with localPreference (RFC 8445 5.1.2.1).
+func priority(typ string, localPreference uint32, component int) uint32 {
+	return typePreferences[typ]<<24 | localPreference<<8 | uint32(256-component)
+}
+
+func newCandidate(typ string, address *net.UDPAddr, base net.IP, related *net.UDPAddr, localPreference uint32) *Candidate {
+	return &Candidate{
+		// The candidates of a type, base and server share a foundation (RFC 8445 5.1.1.3).
+		Foundation: strconv.FormatUint(uint64(crc32.ChecksumIEEE([]byte(typ+base.String()))), 10),
+		Component:  Component,
+		Priority:   priority(typ, localPreference, Component),
+		Address:    address,
+		Type:       typ,
+		Related:    related,
+	}
+}
+
+// String the value of the a=candidate attribute.
+func (c *Candidate) String() string {
+	value := fmt.Sprintf("%s %d udp %d %s %d typ %s", c.Foundation, c.Component, c.Priority, c.Address.IP, c.Address.Port, c.Type)
+	if c.Related != nil {
+		value += fmt.Sprintf(" raddr %s rport %d", c.Related.IP, c.Related.Port)
+	}
+	return value
+}
+
+// ParseCandidate the value of an a=candidate attribute, only the UDP ones are supported.
+func ParseCandidate(value string) (*Candidate, error) {
+	fields := strings.Fields(strings.TrimPrefix(value, "candidate:"))
+	if len(fields) < 8 || fields[6] != "typ" {
+		return nil, fmt.Errorf("ice: malformed candidate %q", value)
+	}
+	if !strings.EqualFold(fields[2], "udp") {
+		return nil, fmt.Errorf("ice: unsupported transport %s", fields[2])
+	}
+	component, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return nil, fmt.Errorf("ice: malformed candidate component %q", fields[1])
+	}
+	prio, err := strconv.ParseUint(fields[3], 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("ice: malformed candidate priority %q", fields[3])
+	}
+	address, err := parseAddress(fields[4], fields[5])
+	if err != nil {
+		return nil, err
+	}
+	c := &Candidate{
+		Foundation: fields[0],
+		Component:  component,
+		Priority:   uint32(prio),
+		Address:    address,
+		Type:       fields[7],
+	}
+	// The extensions are name value pairs.
+	for i := 8; i+1 < len(fields); i += 2 {
+		if fields[i] == "raddr" && i+3 < len(fields) && fields[i+2] == "rport" {
+			if related, err := parseAddress(fields[i+1], fields[i+3]); err == nil {
+				c.Related = related
+			}
+		}
+	}
+	return c, nil
+}
+
+func parseAddress(host string, port string) (*net.UDPAddr, error) {
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return nil, fmt.Errorf("ice: unsupported candidate address %q", host)
+	}
+	p, err := strconv.Atoi(port)
+	if err != nil || p <= 0 || p > 0xffff {
+		return nil, fmt.Errorf("ice: malformed candidate port %q", port)
+	}
+	return &net.UDPAddr{IP: ip, Port: p}, nil
+}
+
+// hostIPs the addresses of the host candidates of a socket bound on ip, the addresses of the interfaces
+// up if it is unspecified, the loopback ones if there are no others.
+func hostIPs(ip net.IP) []net.IP {
+	if !ip.IsUnspecified() {
+		return []net.IP{ip}
+	}
+	var ips, loopbacks []net.IP
+	interfaces, _ := net.Interfaces()
+	for _, iface := range interfaces {
+		if iface.Flags&net.FlagUp == 0 {
+			continue
+		}
+		addrs, _ := iface.Addrs()
+		for _, addr := range addrs {
+			ipnet, ok := addr.(*net.IPNet)
+			// The sockets of the streams are IPv4.
+			if !ok || ipnet.IP.To4() == nil {
+				continue
+			}
+			if ipnet.IP.IsLoopback() {
+				loopbacks = append(loopbacks, ipnet.IP)
+			} else {
+				ips = append(ips, ipnet.IP)
+			}
+		}
+	}
+	if len(ips) == 0 {
+		return loopbacks
+	}
+	return ips
+}
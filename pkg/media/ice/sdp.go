@@ -0,0 +1,70 @@
+package ice
+
+import (
+	"fmt"
+
+	"github.com/pixelbender/go-sdp/sdp"
+)
+
+// Describe adds the ICE attributes of the agent to media (RFC 8839): its credentials, candidates
+// and a=rtcp-mux, its port and connection set to the default candidate, the relayed one if any,
+// else the server reflexive one, else the first host one.
+func (a *Agent) Describe(media *sdp.Media) {
+	candidates := a.Candidates()
+	if len(candidates) == 0 {
+		return
+	}
+	media.Attributes = append(media.Attributes,
+		sdp.NewAttr("ice-ufrag", a.ufrag),
+		sdp.NewAttr("ice-pwd", a.pwd),
+	)
+	for _, c := range candidates {
+		media.Attributes = append(media.Attributes, sdp.NewAttr("candidate", c.String()))
+	}
+	media.Attributes = append(media.Attributes, sdp.NewAttrFlag("rtcp-mux"))
+
+	preference := map[string]int{TypeRelay: 2, TypeServerReflexive: 1}
+	def := candidates[0]
+	for _, c := range candidates {
+		if preference[c.Type] > preference[def.Type] {
+			def = c
+		}
+	}
+	media.Port = def.Address.Port
+	media.Connection = []*sdp.Connection{{
+		Network: sdp.NetworkInternet,
+		Type:    sdp.TypeIPv4,
+		Address: def.Address.IP.String(),
+	}}
+}
+
+// SetRemoteDescription sets the credentials and candidates of the remote media, the credentials of its session
+// if the media has none, and the RTCP multiplexed if it has a=rtcp-mux. The agent becomes controlling
+// against an ice-lite remote (RFC 8445 6.1.1). It fails if the remote does not support ICE.
+func (a *Agent) SetRemoteDescription(session *sdp.Session, media *sdp.Media) error {
+	ufrag, pwd := media.Attributes.Get("ice-ufrag"), media.Attributes.Get("ice-pwd")
+	if ufrag == "" || pwd == "" {
+		ufrag, pwd = session.Attributes.Get("ice-ufrag"), session.Attributes.Get("ice-pwd")
+	}
+	if ufrag == "" || pwd == "" {
+		return fmt.Errorf("ice: no credentials of the remote")
+	}
+	var candidates []*Candidate
+	for _, attr := range media.Attributes {
+		if attr.Name != "candidate" {
+			continue
+		}
+		c, err := ParseCandidate(attr.Value)
+		if err != nil {
+			a.Log().Debugf("skip candidate: %v", err)
+			continue
+		}
+		candidates = append(candidates, c)
+	}
+	if session.Attributes.Has("ice-lite") {
+		a.SetControlling(true)
+	}
+	a.stream.SetRTCPMux(media.Attributes.Has("rtcp-mux"))
+	a.SetRemote(ufrag, pwd, candidates)
+	return nil
+}
@@ -0,0 +1,215 @@
+package ice
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/sergeyu/go-sip-ua/pkg/stun"
+)
+
+const (
+	// permissionLifetime the refresh interval of the permissions, which expire after 5 minutes (RFC 5766 8).
+	permissionLifetime = 4 * time.Minute
+	// allocationLifetime the lifetime of the allocations requested by the servers by default.
+	allocationLifetime = 10 * time.Minute
+)
+
+// allocation a relayed transport address of a TURN server (RFC 5766) allocated from the RTP port.
+type allocation struct {
+	agent    *Agent
+	server   *net.UDPAddr
+	username string
+	password string
+	relayed  *net.UDPAddr
+	mapped   *net.UDPAddr
+
+	mu          sync.Mutex
+	realm       string
+	nonce       string
+	key         []byte
+	permissions map[string]time.Time
+	released    chan struct{}
+}
+
+// allocate a relayed address on the server, authenticated by its long-term credentials.
+func (a *Agent) allocate(server TURNServer) (*allocation, error) {
+	addr, err := net.ResolveUDPAddr("udp4", server.Address)
+	if err != nil {
+		return nil, err
+	}
+	al := &allocation{
+		agent:       a,
+		server:      addr,
+		username:    server.Username,
+		password:    server.Password,
+		permissions: make(map[string]time.Time),
+		released:    make(chan struct{}),
+	}
+	resp, err := al.request(stun.AllocateRequest, func(m *stun.Message) {
+		m.Add(stun.AttrRequestedTransport, []byte{stun.TransportUDP, 0, 0, 0})
+	})
+	if err != nil {
+		return nil, err
+	}
+	value, ok := resp.Get(stun.AttrXORRelayedAddress)
+	if !ok {
+		return nil, fmt.Errorf("no relayed address")
+	}
+	if al.relayed, err = stun.ParseXORAddress(value, resp.ID); err != nil {
+		return nil, err
+	}
+	if al.mapped, err = resp.MappedAddress(); err != nil {
+		return nil, err
+	}
+	lifetime := allocationLifetime
+	if value, ok := resp.Get(stun.AttrLifetime); ok && len(value) == 4 {
+		lifetime = time.Duration(binary.BigEndian.Uint32(value)) * time.Second
+	}
+	go al.refresh(lifetime)
+	return al, nil
+}
+
+// request sends a request of typ with the attributes of build to the server, signed once challenged
+// for the realm and nonce (RFC 5389 10.2), and again with the new nonce when it is stale.
+func (al *allocation) request(typ uint16, build func(m *stun.Message)) (*stun.Message, error) {
+	for attempt := 0; attempt < 3; attempt++ {
+		request := stun.New(typ)
+		build(request)
+		al.mu.Lock()
+		key := al.key
+		if key != nil {
+			request.Add(stun.AttrUsername, []byte(al.username))
+			request.Add(stun.AttrRealm, []byte(al.realm))
+			request.Add(stun.AttrNonce, []byte(al.nonce))
+		}
+		al.mu.Unlock()
+
+		resp, err := al.agent.roundTrip(request, key, al.agent.direct(al.server), al.agent.config.GatherTimeout)
+		if err != nil {
+			return nil, err
+		}
+		m := resp.message
+		if m.Class() == stun.ClassSuccess {
+			return m, nil
+		}
+		code := m.ErrorCode()
+		if code != stun.ErrorUnauthorized && code != stun.ErrorStaleNonce {
+			return nil, fmt.Errorf("TURN error %d", code)
+		}
+		realm, _ := m.Get(stun.AttrRealm)
+		nonce, _ := m.Get(stun.AttrNonce)
+		al.mu.Lock()
+		if code == stun.ErrorUnauthorized && al.key != nil {
+			al.mu.Unlock()
+			return nil, fmt.Errorf("TURN credentials rejected")
+		}
+		if len(realm) > 0 {
+			al.realm = string(realm)
+		}
+		al.nonce = string(nonce)
+		al.key = stun.LongTermKey(al.username, al.realm, al.password)
+		al.mu.Unlock()
+	}
+	return nil, fmt.Errorf("TURN request not authenticated")
+}
+
+// refresh the allocation before its lifetime expires, and the permissions in use, until released.
+func (al *allocation) refresh(lifetime time.Duration) {
+	interval := lifetime / 2
+	if interval <= 0 {
+		interval = allocationLifetime / 2
+	}
+	allocationTicker := time.NewTicker(interval)
+	defer allocationTicker.Stop()
+	permissionTicker := time.NewTicker(permissionLifetime)
+	defer permissionTicker.Stop()
+	for {
+		select {
+		case <-allocationTicker.C:
+			if _, err := al.request(stun.RefreshRequest, lifetimeOf(lifetime)); err != nil {
+				al.agent.Log().Warnf("TURN refresh: %v", err)
+			}
+		case <-permissionTicker.C:
+			al.mu.Lock()
+			var ips []net.IP
+			for ip := range al.permissions {
+				ips = append(ips, net.ParseIP(ip))
+			}
+			al.mu.Unlock()
+			for _, ip := range ips {
+				al.createPermission(ip)
+			}
+		case <-al.released:
+			return
+		}
+	}
+}
+
+// permit the packets of the peers at ip through the relay, unless they are already.
+func (al *allocation) permit(ip net.IP) {
+	al.mu.Lock()
+	_, ok := al.permissions[ip.String()]
+	al.mu.Unlock()
+	if !ok {
+		al.createPermission(ip)
+	}
+}
+
+func (al *allocation) createPermission(ip net.IP) {
+	if _, err := al.request(stun.CreatePermissionRequest, func(m *stun.Message) {
+		m.Add(stun.AttrXORPeerAddress, stun.XORAddress(&net.UDPAddr{IP: ip}, m.ID))
+	}); err != nil {
+		al.agent.Log().Warnf("TURN permission for %v: %v", ip, err)
+		return
+	}
+	al.mu.Lock()
+	al.permissions[ip.String()] = time.Now()
+	al.mu.Unlock()
+}
+
+// send buf to peer through the relay in a Send indication.
+func (al *allocation) send(buf []byte, peer *net.UDPAddr) error {
+	al.mu.Lock()
+	_, ok := al.permissions[peer.IP.String()]
+	al.mu.Unlock()
+	if !ok {
+		go al.permit(peer.IP)
+	}
+	indication := stun.New(stun.SendIndication)
+	indication.Add(stun.AttrXORPeerAddress, stun.XORAddress(peer, indication.ID))
+	indication.Add(stun.AttrData, buf)
+	return al.agent.stream.WriteTo(indication.Marshal(nil, false), al.server)
+}
+
+// received handles a Data indication of the server, the STUN checks and the media of a peer.
+func (al *allocation) received(m *stun.Message) {
+	value, ok := m.Get(stun.AttrXORPeerAddress)
+	data, hasData := m.Get(stun.AttrData)
+	if !ok || !hasData {
+		return
+	}
+	peer, err := stun.ParseXORAddress(value, m.ID)
+	if err != nil {
+		return
+	}
+	if stun.IsMessage(data) {
+		al.agent.handleSTUN(data, peer, true)
+		return
+	}
+	al.agent.stream.HandlePacket(data, peer)
+}
+
+// release the allocation with a zero lifetime.
+func (al *allocation) release() {
+	close(al.released)
+	al.request(stun.RefreshRequest, lifetimeOf(0))
+}
+
+func lifetimeOf(lifetime time.Duration) func(m *stun.Message) {
+	return func(m *stun.Message) {
+		m.Add(stun.AttrLifetime, stun.Uint32Value(uint32(lifetime/time.Second)))
+	}
+}
@@ -0,0 +1,125 @@
+package ice
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/sergeyu/go-sip-ua/pkg/media/rtp"
+	"github.com/sergeyu/go-sip-ua/pkg/stun"
+)
+
+// relayAddr the relayed address allocated by the server of turnServer.
+var relayAddr = &net.UDPAddr{IP: net.IPv4(203, 0, 113, 5).To4(), Port: 49152}
+
+// turnServer a TURN server of realm and password on conn: it challenges the requests not signed, answers
+// the ones signed by the long-term key of password, and passes them to requests.
+func turnServer(t *testing.T, conn net.PacketConn, password string, requests chan<- *stun.Message) {
+	buf := make([]byte, 1500)
+	for {
+		n, from, err := conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		m, err := stun.Parse(buf[:n])
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		username, _ := m.Get(stun.AttrUsername)
+		key := stun.LongTermKey(string(username), "example.org", password)
+		var resp *stun.Message
+		switch {
+		case !m.Has(stun.AttrMessageIntegrity) || !m.Verify(key):
+			key = nil
+			resp = m.Response(m.Type|stun.ClassError).
+				Add(stun.AttrErrorCode, stun.ErrorCodeValue(stun.ErrorUnauthorized, "Unauthorized")).
+				Add(stun.AttrRealm, []byte("example.org")).
+				Add(stun.AttrNonce, []byte("n1"))
+		case m.Type == stun.AllocateRequest:
+			resp = m.Response(m.Type | stun.ClassSuccess)
+			resp.Add(stun.AttrXORRelayedAddress, stun.XORAddress(relayAddr, m.ID))
+			resp.Add(stun.AttrXORMappedAddress, stun.XORAddress(from.(*net.UDPAddr), m.ID))
+			resp.Add(stun.AttrLifetime, stun.Uint32Value(600))
+		default:
+			resp = m.Response(m.Type | stun.ClassSuccess)
+		}
+		if _, err := conn.WriteTo(resp.Marshal(key, true), from); err != nil {
+			t.Error(err)
+			return
+		}
+		requests <- m
+	}
+}
+
+// newTURN an agent on a stream of 127.0.0.1, and a TURN server of password.
+func newTURN(t *testing.T, password string) (*Agent, net.PacketConn, chan *stun.Message) {
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	requests := make(chan *stun.Message, 8)
+	go turnServer(t, conn, password, requests)
+	stream, err := rtp.NewStream(rtp.NewPortRange(0, 0), "127.0.0.1", 8000)
+	if err != nil {
+		conn.Close()
+		t.Fatal(err)
+	}
+	return NewAgent(stream, Config{GatherTimeout: time.Second}, true), conn, requests
+}
+
+// TestAllocate an allocation challenged, then signed with the realm and nonce of the challenge, and
+// released with a zero lifetime.
+func TestAllocate(t *testing.T) {
+	a, conn, requests := newTURN(t, "secret")
+	defer conn.Close()
+	defer a.stream.Close()
+
+	al, err := a.allocate(TURNServer{Address: conn.LocalAddr().String(), Username: "alice", Password: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if al.relayed.String() != relayAddr.String() {
+		t.Errorf("relayed = %s; want %s", al.relayed, relayAddr)
+	}
+	if al.mapped.String() != a.stream.LocalAddr().String() {
+		t.Errorf("mapped = %s; want %s", al.mapped, a.stream.LocalAddr())
+	}
+
+	if challenged := <-requests; challenged.Has(stun.AttrMessageIntegrity) {
+		t.Error("first Allocate signed before the challenge")
+	}
+	signed := <-requests
+	for typ, want := range map[uint16]string{stun.AttrUsername: "alice", stun.AttrRealm: "example.org", stun.AttrNonce: "n1"} {
+		if value, _ := signed.Get(typ); string(value) != want {
+			t.Errorf("attribute %#x = %q; want %q", typ, value, want)
+		}
+	}
+	if value, _ := signed.Get(stun.AttrRequestedTransport); len(value) != 4 || value[0] != stun.TransportUDP {
+		t.Errorf("REQUESTED-TRANSPORT = %v; want UDP", value)
+	}
+
+	al.release()
+	select {
+	case refresh := <-requests:
+		lifetime, _ := refresh.Get(stun.AttrLifetime)
+		if refresh.Type != stun.RefreshRequest || len(lifetime) != 4 || binary.BigEndian.Uint32(lifetime) != 0 {
+			t.Errorf("release = %#x of LIFETIME %v; want a Refresh of 0", refresh.Type, lifetime)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("allocation not released")
+	}
+}
+
+// TestAllocateRejected an allocation challenged again once signed: the credentials are rejected.
+func TestAllocateRejected(t *testing.T) {
+	a, conn, _ := newTURN(t, "secret")
+	defer conn.Close()
+	defer a.stream.Close()
+
+	_, err := a.allocate(TURNServer{Address: conn.LocalAddr().String(), Username: "alice", Password: "wrong"})
+	if err == nil || err.Error() != "TURN credentials rejected" {
+		t.Errorf("allocate = %v; want TURN credentials rejected", err)
+	}
+}
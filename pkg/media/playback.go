@@ -0,0 +1,159 @@
+package media
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/sergeyu/go-sip-ua/pkg/media/g711"
+	"github.com/sergeyu/go-sip-ua/pkg/media/rtp"
+	"github.com/sergeyu/go-sip-ua/pkg/media/wav"
+)
+
+const (
+	// g711Rate the sample rate of G.711.
+	g711Rate = 8000
+	// ptime the duration of the audio of a packet sent.
+	ptime = 20 * time.Millisecond
+	// maxGap the longest silence recorded in place of the packets lost.
+	maxGap = g711Rate
+)
+
+// encoder the G.711 encoder of payloadType, PCMU or PCMA.
+func encoder(payloadType uint8) (func([]int16) []byte, error) {
+	switch payloadType {
+	case PCMU.Payload:
+		return g711.EncodeUlaw, nil
+	case PCMA.Payload:
+		return g711.EncodeAlaw, nil
+	}
+	return nil, fmt.Errorf("media: payload type %d is not G.711", payloadType)
+}
+
+// PlayWAV sends the audio of the WAV file at path on stream in G.711 of payloadType, PCMU or PCMA,
+// in real time until its end or ctx is done. The file must be 8000 Hz mono, e.g. an announcement.
+func PlayWAV(ctx context.Context, stream *rtp.Stream, payloadType uint8, path string) error {
+	encode, err := encoder(payloadType)
+	if err != nil {
+		return err
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	reader, err := wav.NewReader(file)
+	if err != nil {
+		return err
+	}
+	if reader.SampleRate != g711Rate || reader.Channels != 1 {
+		return fmt.Errorf("media: %s is %d Hz with %d channels, 8000 Hz mono expected", path, reader.SampleRate, reader.Channels)
+	}
+
+	samples := make([]int16, g711Rate*ptime/time.Second)
+	ticker := time.NewTicker(ptime)
+	defer ticker.Stop()
+	for first := true; ; first = false {
+		n, err := reader.ReadSamples(samples)
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		// The last packet is filled with silence.
+		for i := n; i < len(samples); i++ {
+			samples[i] = 0
+		}
+		if err := stream.WriteSample(payloadType, encode(samples), uint32(len(samples)), first); err != nil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Recorder records the G.711 audio received by a stream to a WAV file.
+type Recorder struct {
+	stream *rtp.Stream
+	file   *os.File
+	writer *wav.Writer
+
+	mu     sync.Mutex
+	next   uint32
+	start  bool
+	closed bool
+}
+
+// RecordWAV records the PCMU and PCMA packets received by stream to a WAV file at path, 8000 Hz mono,
+// the packets lost as silence. It handles the packets of the stream in place of OnPacket until Close.
+func RecordWAV(stream *rtp.Stream, path string) (*Recorder, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	writer, err := wav.NewWriter(file, g711Rate)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	r := &Recorder{
+		stream: stream,
+		file:   file,
+		writer: writer,
+	}
+	stream.OnPacket(r.record)
+	return r, nil
+}
+
+func (r *Recorder) record(packet *rtp.Packet) {
+	var samples []int16
+	switch packet.PayloadType {
+	case PCMU.Payload:
+		samples = g711.DecodeUlaw(packet.Payload)
+	case PCMA.Payload:
+		samples = g711.DecodeAlaw(packet.Payload)
+	default:
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return
+	}
+	if r.start {
+		// The late packets are dropped, the gaps of the lost ones filled.
+		gap := int32(packet.Timestamp - r.next)
+		if gap < 0 {
+			return
+		}
+		if gap > 0 && gap <= maxGap {
+			r.writer.WriteSamples(make([]int16, gap))
+		}
+	}
+	r.start = true
+	r.next = packet.Timestamp + uint32(len(samples))
+	r.writer.WriteSamples(samples)
+}
+
+// Close stops recording and completes the file.
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return nil
+	}
+	r.closed = true
+	r.stream.OnPacket(nil)
+	if err := r.writer.Close(); err != nil {
+		r.file.Close()
+		return err
+	}
+	return r.file.Close()
+}
@@ -0,0 +1,97 @@
+package media
+
+import (
+	"strings"
+
+	"github.com/pixelbender/go-sdp/sdp"
+)
+
+// CodecPolicy the codecs of a profile in order of preference, offered by NewAudio and NewVideo,
+// and how Answer selects among the ones offered in common with them.
+type CodecPolicy struct {
+	Audio []sdp.Format
+	Video []sdp.Format
+	// PreferLocal orders the codecs of the answers by the preference of the policy, not the one of the offer.
+	PreferLocal bool
+	// FirstMatch answers only the most preferred codec in common, e.g. to avoid codec changes mid-call.
+	FirstMatch bool
+	// KeepTelephoneEvent answers telephone-event along with the codec of FirstMatch when offered.
+	KeepTelephoneEvent bool
+}
+
+// DefaultCodecPolicy G.711 and telephone-event, in the order of the offers.
+var DefaultCodecPolicy = &CodecPolicy{
+	Audio:              []sdp.Format{PCMU, PCMA, TelephoneEvent},
+	KeepTelephoneEvent: true,
+}
+
+// NewAudio an audio media description received on port with the audio codecs of the policy.
+func (p *CodecPolicy) NewAudio(port int) *sdp.Media {
+	return NewAudio(port, p.Audio...)
+}
+
+// NewVideo a video media description received on port with the video codecs of the policy.
+func (p *CodecPolicy) NewVideo(port int) *sdp.Media {
+	return NewVideo(port, p.Video...)
+}
+
+// Answer the answer to offer from host as Answer with local, e.g. from NewAudio and NewVideo, its codecs
+// selected by the policy. The media without codec of the policy in common are rejected with port 0.
+func (p *CodecPolicy) Answer(offer *sdp.Session, host string, local ...*sdp.Media) *sdp.Session {
+	answer := Answer(offer, host, local...)
+	for i, m := range answer.Media {
+		if m.Port == 0 {
+			continue
+		}
+		if m.Format = p.selectCodecs(m.Type, m.Format); len(m.Format) == 0 {
+			answer.Media[i] = rejected(offer.Media[i])
+		}
+	}
+	return answer
+}
+
+// selectCodecs the formats of an answer of typ in common with the policy, in the order of the policy if
+// PreferLocal, only the first if FirstMatch.
+func (p *CodecPolicy) selectCodecs(typ string, formats []*sdp.Format) []*sdp.Format {
+	preferred := p.Audio
+	if typ == "video" {
+		preferred = p.Video
+	}
+	rank := func(format *sdp.Format) int {
+		for i, codec := range preferred {
+			if sameCodec(codecOf(format), codec) {
+				return i
+			}
+		}
+		return -1
+	}
+
+	var codecs, events []*sdp.Format
+	for _, format := range formats {
+		switch {
+		case rank(format) < 0:
+		case strings.EqualFold(format.Name, TelephoneEvent.Name):
+			events = append(events, format)
+		default:
+			codecs = append(codecs, format)
+		}
+	}
+	if p.PreferLocal {
+		// An insertion sort keeps the order of the offer among the codecs of the same rank.
+		for i := 1; i < len(codecs); i++ {
+			for j := i; j > 0 && rank(codecs[j]) < rank(codecs[j-1]); j-- {
+				codecs[j], codecs[j-1] = codecs[j-1], codecs[j]
+			}
+		}
+	}
+	if len(codecs) == 0 {
+		return nil
+	}
+	if p.FirstMatch {
+		codecs = codecs[:1]
+		if !p.KeepTelephoneEvent {
+			events = nil
+		}
+	}
+	return append(codecs, events...)
+}
@@ -0,0 +1,169 @@
+package media
+
+import (
+	"net"
+
+	"github.com/pixelbender/go-sdp/sdp"
+)
+
+// Clone a deep copy of session, to rewrite it without changing the original, e.g. the offer a B2BUA relays.
+func Clone(session *sdp.Session) (*sdp.Session, error) {
+	return sdp.Parse(session.Bytes())
+}
+
+// SetDirection sets the direction of the media of session not rejected, and removes the one of the session.
+func SetDirection(session *sdp.Session, mode string) {
+	session.Mode = ""
+	for _, m := range session.Media {
+		if m.Port != 0 {
+			m.Mode = mode
+		}
+	}
+}
+
+// Hold sets the direction of the media of session to hold the remote (RFC 6337 5.3): sendonly if it
+// was sending, inactive otherwise.
+func Hold(session *sdp.Session) {
+	for _, m := range session.Media {
+		if m.Port == 0 {
+			continue
+		}
+		switch Direction(session, m) {
+		case sdp.SendRecv, sdp.SendOnly:
+			m.Mode = sdp.SendOnly
+		default:
+			m.Mode = sdp.Inactive
+		}
+	}
+	session.Mode = ""
+}
+
+// Resume sets the direction of the media of session held back to receiving, and removes the zero
+// connection addresses of a legacy hold.
+func Resume(session *sdp.Session) {
+	for _, m := range session.Media {
+		if m.Port == 0 {
+			continue
+		}
+		switch Direction(session, m) {
+		case sdp.SendOnly:
+			m.Mode = sdp.SendRecv
+		case sdp.Inactive:
+			m.Mode = sdp.RecvOnly
+		}
+	}
+	session.Mode = ""
+	if session.Origin != nil {
+		restore := func(c *sdp.Connection) {
+			if c != nil && isZeroAddress(c.Address) {
+				c.Address = session.Origin.Address
+			}
+		}
+		restore(session.Connection)
+		for _, m := range session.Media {
+			for _, c := range m.Connection {
+				restore(c)
+			}
+		}
+	}
+}
+
+// LegacyHold sets the connection addresses of session to 0.0.0.0, the hold of RFC 2543 some
+// endpoints still send or expect.
+func LegacyHold(session *sdp.Session) {
+	if session.Connection != nil {
+		session.Connection.Address = "0.0.0.0"
+	}
+	for _, m := range session.Media {
+		for _, c := range m.Connection {
+			c.Address = "0.0.0.0"
+		}
+	}
+}
+
+// SetAddress sets the connection addresses of session to host, e.g. the new address of the interface after
+// a network change. The zero addresses of a legacy hold are kept, the origin too as it identifies the session.
+func SetAddress(session *sdp.Session, host string) {
+	set := func(c *sdp.Connection) {
+		if c != nil && !isZeroAddress(c.Address) {
+			c.Type = addressType(host)
+			c.Address = host
+		}
+	}
+	set(session.Connection)
+	for _, m := range session.Media {
+		for _, c := range m.Connection {
+			set(c)
+		}
+	}
+}
+
+// Addresses the connection addresses of session, of the session and of its media.
+func Addresses(session *sdp.Session) []string {
+	var addresses []string
+	if session.Connection != nil {
+		addresses = append(addresses, session.Connection.Address)
+	}
+	for _, m := range session.Media {
+		for _, c := range m.Connection {
+			addresses = append(addresses, c.Address)
+		}
+	}
+	return addresses
+}
+
+// IsHeld whether media in session puts its receiver on hold: sendonly or inactive, or a zero
+// connection address of a legacy hold.
+func IsHeld(session *sdp.Session, media *sdp.Media) bool {
+	switch Direction(session, media) {
+	case sdp.SendOnly, sdp.Inactive:
+		return true
+	}
+	connection := session.Connection
+	if len(media.Connection) > 0 {
+		connection = media.Connection[0]
+	}
+	return connection != nil && isZeroAddress(connection.Address)
+}
+
+func isZeroAddress(address string) bool {
+	ip := net.ParseIP(address)
+	return ip != nil && ip.IsUnspecified()
+}
+
+// FilterCodecs keeps the formats of the RTP media of session of one of codecs, in the order of codecs
+// and with their payload types. The media without any left are rejected with port 0.
+func FilterCodecs(session *sdp.Session, codecs ...sdp.Format) {
+	for _, m := range session.Media {
+		if m.Port == 0 || len(m.Format) == 0 {
+			continue
+		}
+		var formats []*sdp.Format
+		for _, codec := range codecs {
+			for _, format := range m.Format {
+				if sameCodec(codecOf(format), codec) {
+					formats = append(formats, format)
+					break
+				}
+			}
+		}
+		if len(formats) == 0 || onlyTelephoneEvent(formats) {
+			m.Port = 0
+			continue
+		}
+		m.Format = formats
+	}
+}
+
+// Renumber sets the origin of next, a new description of the session of previous, to the one of previous
+// with its version incremented if next changed anything (RFC 3264 8).
+func Renumber(previous *sdp.Session, next *sdp.Session) {
+	if previous == nil || previous.Origin == nil {
+		return
+	}
+	origin := *previous.Origin
+	next.Origin = &origin
+	if string(previous.Bytes()) != string(next.Bytes()) {
+		origin.SessionVersion++
+	}
+}
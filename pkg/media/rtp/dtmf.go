@@ -0,0 +1,153 @@
+package rtp
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultDTMFDuration the duration of the digits sent without one.
+	DefaultDTMFDuration = 100 * time.Millisecond
+	// dtmfInterval the interval of the packets of an event.
+	dtmfInterval = 20 * time.Millisecond
+	// dtmfVolume the power level of the tones sent, in -dBm0.
+	dtmfVolume = 10
+	// dtmfEndPackets the times the end of an event is sent (RFC 4733 2.5.1.4).
+	dtmfEndPackets = 3
+)
+
+// dtmfDigits the digits of the events 0 to 16 (RFC 4733 3.2), the flash as '!'.
+const dtmfDigits = "0123456789*#ABCD!"
+
+// DTMFEvent a digit of the telephone-event packets received (RFC 4733).
+type DTMFEvent struct {
+	Digit    rune
+	Duration time.Duration
+	// Volume the power level of the tone, in -dBm0.
+	Volume uint8
+}
+
+// dtmfPayload a telephone-event payload: the event, its end, volume and duration in timestamp units.
+type dtmfPayload struct {
+	event    uint8
+	end      bool
+	volume   uint8
+	duration uint16
+}
+
+func (p *dtmfPayload) marshal() []byte {
+	buf := make([]byte, 4)
+	buf[0] = p.event
+	buf[1] = p.volume & 0x3f
+	if p.end {
+		buf[1] |= 0x80
+	}
+	binary.BigEndian.PutUint16(buf[2:], p.duration)
+	return buf
+}
+
+func (p *dtmfPayload) unmarshal(buf []byte) error {
+	if len(buf) < 4 {
+		return errShortPacket
+	}
+	p.event = buf[0]
+	p.end = buf[1]&0x80 != 0
+	p.volume = buf[1] & 0x3f
+	p.duration = binary.BigEndian.Uint16(buf[2:])
+	return nil
+}
+
+// SendDTMF sends digit in telephone-event packets of payloadType (RFC 4733) every 20 ms for duration,
+// DefaultDTMFDuration if zero, then its end three times. It returns once the event is sent, the audio
+// should not be written meanwhile.
+func (s *Stream) SendDTMF(payloadType uint8, digit rune, duration time.Duration) error {
+	event := strings.IndexRune(dtmfDigits, digit)
+	if event < 0 {
+		return fmt.Errorf("rtp: no DTMF event for %q", digit)
+	}
+	if duration <= 0 {
+		duration = DefaultDTMFDuration
+	}
+	samples := func(d time.Duration) uint32 {
+		return uint32(int64(d) * int64(s.clockRate) / int64(time.Second))
+	}
+	// The duration of an event fits in 16 bits.
+	if samples(duration) > 0xffff {
+		duration = time.Duration(0xffff) * time.Second / time.Duration(s.clockRate)
+	}
+
+	s.mu.Lock()
+	start := s.timestamp
+	s.mu.Unlock()
+
+	for elapsed := dtmfInterval; ; elapsed += dtmfInterval {
+		end := elapsed >= duration
+		if end {
+			elapsed = duration
+		}
+		payload := (&dtmfPayload{
+			event:    uint8(event),
+			end:      end,
+			volume:   dtmfVolume,
+			duration: uint16(samples(elapsed)),
+		}).marshal()
+		count := 1
+		if end {
+			count = dtmfEndPackets
+		}
+		for i := 0; i < count; i++ {
+			s.mu.Lock()
+			remote, relay := s.remote, s.relay
+			// All the packets of the event have its timestamp, the first one the marker.
+			packet := s.nextPacket(payloadType, payload, start, elapsed == dtmfInterval && i == 0)
+			s.mu.Unlock()
+			if err := s.write(packet, remote, relay); err != nil {
+				return err
+			}
+		}
+		if end {
+			break
+		}
+		select {
+		case <-s.closed:
+			return fmt.Errorf("rtp: stream closed")
+		case <-time.After(dtmfInterval):
+		}
+	}
+
+	s.mu.Lock()
+	s.timestamp = start + samples(duration)
+	s.mu.Unlock()
+	return nil
+}
+
+// OnDTMF handles the digits of the telephone-event packets of payloadType received, once the end of each is.
+func (s *Stream) OnDTMF(payloadType uint8, handler func(event DTMFEvent)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.dtmfPayloadType = payloadType
+	s.onDTMF = handler
+}
+
+// receivedDTMF the event ended by packet, its end packets retransmitted are ignored.
+func (s *Stream) receivedDTMF(packet *Packet) (DTMFEvent, bool) {
+	if s.onDTMF == nil || packet.PayloadType != s.dtmfPayloadType {
+		return DTMFEvent{}, false
+	}
+	payload := dtmfPayload{}
+	if err := payload.unmarshal(packet.Payload); err != nil || !payload.end || int(payload.event) >= len(dtmfDigits) {
+		return DTMFEvent{}, false
+	}
+	if s.dtmfEnded && s.dtmfTimestamp == packet.Timestamp {
+		return DTMFEvent{}, false
+	}
+	s.dtmfEnded = true
+	s.dtmfTimestamp = packet.Timestamp
+	return DTMFEvent{
+		Digit:    rune(dtmfDigits[payload.event]),
+		Duration: time.Duration(payload.duration) * time.Second / time.Duration(s.clockRate),
+		Volume:   payload.volume,
+	}, true
+}
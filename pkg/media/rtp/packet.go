@@ -0,0 +1,95 @@
+package rtp
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+const (
+	// Version of the RTP and RTCP packets (RFC 3550).
+	Version    = 2
+	headerSize = 12
+)
+
+var (
+	errShortPacket = errors.New("rtp: packet too short")
+	errVersion     = errors.New("rtp: bad version")
+)
+
+// Packet an RTP packet (RFC 3550 5.1), its header extension is skipped.
+type Packet struct {
+	Marker         bool
+	PayloadType    uint8
+	SequenceNumber uint16
+	Timestamp      uint32
+	SSRC           uint32
+	CSRC           []uint32
+	Payload        []byte
+}
+
+// Marshal the packet.
+func (p *Packet) Marshal() []byte {
+	buf := make([]byte, headerSize+4*len(p.CSRC)+len(p.Payload))
+	buf[0] = Version<<6 | uint8(len(p.CSRC)&0x0f)
+	buf[1] = p.PayloadType & 0x7f
+	if p.Marker {
+		buf[1] |= 0x80
+	}
+	binary.BigEndian.PutUint16(buf[2:], p.SequenceNumber)
+	binary.BigEndian.PutUint32(buf[4:], p.Timestamp)
+	binary.BigEndian.PutUint32(buf[8:], p.SSRC)
+	n := headerSize
+	for _, csrc := range p.CSRC {
+		binary.BigEndian.PutUint32(buf[n:], csrc)
+		n += 4
+	}
+	copy(buf[n:], p.Payload)
+	return buf
+}
+
+// Unmarshal buf into the packet, its payload refers to buf.
+func (p *Packet) Unmarshal(buf []byte) error {
+	if len(buf) < headerSize {
+		return errShortPacket
+	}
+	if buf[0]>>6 != Version {
+		return errVersion
+	}
+	padding := buf[0]&0x20 != 0
+	extension := buf[0]&0x10 != 0
+	count := int(buf[0] & 0x0f)
+
+	p.Marker = buf[1]&0x80 != 0
+	p.PayloadType = buf[1] & 0x7f
+	p.SequenceNumber = binary.BigEndian.Uint16(buf[2:])
+	p.Timestamp = binary.BigEndian.Uint32(buf[4:])
+	p.SSRC = binary.BigEndian.Uint32(buf[8:])
+
+	n := headerSize
+	if len(buf) < n+4*count {
+		return errShortPacket
+	}
+	p.CSRC = nil
+	for i := 0; i < count; i++ {
+		p.CSRC = append(p.CSRC, binary.BigEndian.Uint32(buf[n:]))
+		n += 4
+	}
+	if extension {
+		if len(buf) < n+4 {
+			return errShortPacket
+		}
+		n += 4 + 4*int(binary.BigEndian.Uint16(buf[n+2:]))
+		if len(buf) < n {
+			return errShortPacket
+		}
+	}
+	end := len(buf)
+	if padding {
+		end -= int(buf[end-1])
+		if end < n {
+			return errShortPacket
+		}
+	}
+	p.Payload = buf[n:end]
+	return nil
+}
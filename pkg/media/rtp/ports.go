@@ -0,0 +1,69 @@
+package rtp
+
+import (
+	"errors"
+	"math/rand"
+	"net"
+	"sync"
+)
+
+// ErrNoPorts no pair of ports of the range is free.
+var ErrNoPorts = errors.New("rtp: no free port pair in range")
+
+// PortRange allocates the RTP and RTCP ports of the streams, an even RTP port with the RTCP one
+// next to it (RFC 3550 11), starting at a random pair and going round the range.
+type PortRange struct {
+	min  int
+	max  int
+	mu   sync.Mutex
+	next int
+}
+
+// NewPortRange the pairs of ports from min to max, DefaultPortMin to DefaultPortMax if zero.
+func NewPortRange(min int, max int) *PortRange {
+	if min <= 0 {
+		min = DefaultPortMin
+	}
+	if max <= 0 {
+		max = DefaultPortMax
+	}
+	// RTP on the even ports.
+	min += min % 2
+	if max%2 == 0 {
+		max--
+	}
+	r := &PortRange{
+		min: min,
+		max: max,
+	}
+	if pairs := (max - min + 1) / 2; pairs > 0 {
+		r.next = min + 2*rand.Intn(pairs)
+	}
+	return r
+}
+
+// Listen binds a free pair of ports on ip, the RTP and the RTCP sockets.
+func (r *PortRange) Listen(ip string) (*net.UDPConn, *net.UDPConn, error) {
+	pairs := (r.max - r.min + 1) / 2
+	for i := 0; i < pairs; i++ {
+		r.mu.Lock()
+		port := r.next
+		r.next += 2
+		if r.next+1 > r.max {
+			r.next = r.min
+		}
+		r.mu.Unlock()
+
+		rtpConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP(ip), Port: port})
+		if err != nil {
+			continue
+		}
+		rtcpConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP(ip), Port: port + 1})
+		if err != nil {
+			rtpConn.Close()
+			continue
+		}
+		return rtpConn, rtcpConn, nil
+	}
+	return nil, nil, ErrNoPorts
+}
@@ -0,0 +1,71 @@
+package rtp
+
+import (
+	"math"
+	"time"
+)
+
+// Quality the call quality of a stream, from its statistics and the reports of the remote.
+type Quality struct {
+	Stats Stats
+	// Jitter the interarrival jitter of the packets received.
+	Jitter time.Duration
+	// Loss the fraction of the packets of the remote lost, 0 to 1.
+	Loss float64
+	// RemoteJitter and RemoteLoss the ones the remote reports of the packets sent, 0 until it does.
+	RemoteJitter time.Duration
+	RemoteLoss   float64
+	// MOS the mean opinion score estimated by the E-model (ITU-T G.107), 1 to 4.5, of the worse
+	// direction of the call.
+	MOS float64
+}
+
+// codecDelay the delay of the packetization and the jitter buffer the MOS assumes, of a G.711 payload of 20 ms.
+const codecDelay = 10 * time.Millisecond
+
+// Quality the call quality of the stream.
+func (s *Stream) Quality() Quality {
+	q := Quality{Stats: s.Stats()}
+	q.Jitter = s.duration(q.Stats.Jitter)
+	if expected := q.Stats.Lost + int64(q.Stats.PacketsReceived); expected > 0 && q.Stats.Lost > 0 {
+		q.Loss = float64(q.Stats.Lost) / float64(expected)
+	}
+	if report := q.Stats.RemoteReport; report != nil {
+		q.RemoteJitter = s.duration(report.Jitter)
+		// The packets lost are among the ones sent.
+		if q.Stats.PacketsSent > 0 {
+			q.RemoteLoss = math.Min(float64(report.TotalLost)/float64(q.Stats.PacketsSent), 1)
+		}
+	}
+	jitter, loss := q.Jitter, q.Loss
+	if q.RemoteJitter > jitter {
+		jitter = q.RemoteJitter
+	}
+	if q.RemoteLoss > loss {
+		loss = q.RemoteLoss
+	}
+	q.MOS = MOS(q.Stats.RTT/2+2*jitter+codecDelay, loss)
+	return q
+}
+
+func (s *Stream) duration(timestamp uint32) time.Duration {
+	return time.Duration(timestamp) * time.Second / time.Duration(s.clockRate)
+}
+
+// MOS the mean opinion score of a call with the one-way delay and the fraction of the packets lost,
+// by the simplified E-model of ITU-T G.107: the R factor reduced by the delay and the loss.
+func MOS(delay time.Duration, loss float64) float64 {
+	ms := float64(delay) / float64(time.Millisecond)
+	r := 93.2 - ms/40
+	if ms >= 160 {
+		r = 93.2 - (ms-120)/10
+	}
+	r -= 2.5 * loss * 100
+	switch {
+	case r <= 0:
+		return 1
+	case r >= 100:
+		return 4.5
+	}
+	return 1 + 0.035*r + 7e-6*r*(r-60)*(100-r)
+}
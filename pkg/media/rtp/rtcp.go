@@ -0,0 +1,242 @@
+package rtp
+
+import (
+	"encoding/binary"
+	"errors"
+	"time"
+)
+
+// The RTCP packet types (RFC 3550 12.1).
+const (
+	TypeSenderReport      = 200
+	TypeReceiverReport    = 201
+	TypeSourceDescription = 202
+	TypeGoodbye           = 203
+)
+
+const sdesCNAME = 1
+
+var errRTCP = errors.New("rtcp: malformed packet")
+
+// ReceptionReport the reception statistics of a source (RFC 3550 6.4.1).
+type ReceptionReport struct {
+	SSRC uint32
+	// FractionLost the fraction of the packets lost since the last report, in 1/256.
+	FractionLost uint8
+	TotalLost    uint32
+	// HighestSequence the extended highest sequence number received.
+	HighestSequence uint32
+	Jitter          uint32
+	// LastSenderReport the middle 32 bits of the NTP time of the last sender report received.
+	LastSenderReport uint32
+	// Delay since the last sender report received, in 1/65536 seconds.
+	Delay uint32
+}
+
+// SenderReport an RTCP SR (RFC 3550 6.4.1).
+type SenderReport struct {
+	SSRC        uint32
+	NTPTime     uint64
+	RTPTime     uint32
+	PacketCount uint32
+	OctetCount  uint32
+	Reports     []ReceptionReport
+}
+
+// ReceiverReport an RTCP RR (RFC 3550 6.4.2).
+type ReceiverReport struct {
+	SSRC    uint32
+	Reports []ReceptionReport
+}
+
+// SourceDescription an RTCP SDES with the CNAME of a source (RFC 3550 6.5).
+type SourceDescription struct {
+	SSRC  uint32
+	CNAME string
+}
+
+// Goodbye an RTCP BYE (RFC 3550 6.6).
+type Goodbye struct {
+	Sources []uint32
+}
+
+// NTPTime the NTP timestamp of t, seconds since 1900 and their fraction.
+func NTPTime(t time.Time) uint64 {
+	seconds := uint64(t.Unix() + 2208988800)
+	fraction := uint64(t.Nanosecond()) << 32 / 1e9
+	return seconds<<32 | fraction
+}
+
+func header(buf []byte, count int, typ uint8, size int) {
+	buf[0] = Version<<6 | uint8(count&0x1f)
+	buf[1] = typ
+	binary.BigEndian.PutUint16(buf[2:], uint16(size/4-1))
+}
+
+func marshalReports(buf []byte, reports []ReceptionReport) {
+	for i, r := range reports {
+		b := buf[24*i:]
+		binary.BigEndian.PutUint32(b, r.SSRC)
+		binary.BigEndian.PutUint32(b[4:], uint32(r.FractionLost)<<24|r.TotalLost&0xffffff)
+		binary.BigEndian.PutUint32(b[8:], r.HighestSequence)
+		binary.BigEndian.PutUint32(b[12:], r.Jitter)
+		binary.BigEndian.PutUint32(b[16:], r.LastSenderReport)
+		binary.BigEndian.PutUint32(b[20:], r.Delay)
+	}
+}
+
+func unmarshalReports(buf []byte, count int) ([]ReceptionReport, error) {
+	if len(buf) < 24*count {
+		return nil, errRTCP
+	}
+	reports := make([]ReceptionReport, 0, count)
+	for i := 0; i < count; i++ {
+		b := buf[24*i:]
+		lost := binary.BigEndian.Uint32(b[4:])
+		reports = append(reports, ReceptionReport{
+			SSRC:             binary.BigEndian.Uint32(b),
+			FractionLost:     uint8(lost >> 24),
+			TotalLost:        lost & 0xffffff,
+			HighestSequence:  binary.BigEndian.Uint32(b[8:]),
+			Jitter:           binary.BigEndian.Uint32(b[12:]),
+			LastSenderReport: binary.BigEndian.Uint32(b[16:]),
+			Delay:            binary.BigEndian.Uint32(b[20:]),
+		})
+	}
+	return reports, nil
+}
+
+// Marshal the report.
+func (sr *SenderReport) Marshal() []byte {
+	size := 28 + 24*len(sr.Reports)
+	buf := make([]byte, size)
+	header(buf, len(sr.Reports), TypeSenderReport, size)
+	binary.BigEndian.PutUint32(buf[4:], sr.SSRC)
+	binary.BigEndian.PutUint64(buf[8:], sr.NTPTime)
+	binary.BigEndian.PutUint32(buf[16:], sr.RTPTime)
+	binary.BigEndian.PutUint32(buf[20:], sr.PacketCount)
+	binary.BigEndian.PutUint32(buf[24:], sr.OctetCount)
+	marshalReports(buf[28:], sr.Reports)
+	return buf
+}
+
+// Marshal the report.
+func (rr *ReceiverReport) Marshal() []byte {
+	size := 8 + 24*len(rr.Reports)
+	buf := make([]byte, size)
+	header(buf, len(rr.Reports), TypeReceiverReport, size)
+	binary.BigEndian.PutUint32(buf[4:], rr.SSRC)
+	marshalReports(buf[8:], rr.Reports)
+	return buf
+}
+
+// Marshal the description, a chunk with the CNAME item.
+func (sd *SourceDescription) Marshal() []byte {
+	cname := sd.CNAME
+	if len(cname) > 255 {
+		cname = cname[:255]
+	}
+	// The items end with a null octet, padded to 32 bits.
+	size := (8 + 2 + len(cname) + 1 + 3) &^ 3
+	buf := make([]byte, size)
+	header(buf, 1, TypeSourceDescription, size)
+	binary.BigEndian.PutUint32(buf[4:], sd.SSRC)
+	buf[8] = sdesCNAME
+	buf[9] = uint8(len(cname))
+	copy(buf[10:], cname)
+	return buf
+}
+
+// Marshal the packet.
+func (bye *Goodbye) Marshal() []byte {
+	size := 4 + 4*len(bye.Sources)
+	buf := make([]byte, size)
+	header(buf, len(bye.Sources), TypeGoodbye, size)
+	for i, ssrc := range bye.Sources {
+		binary.BigEndian.PutUint32(buf[4+4*i:], ssrc)
+	}
+	return buf
+}
+
+// ParseRTCP the packets of a compound RTCP packet: *SenderReport, *ReceiverReport, *SourceDescription
+// and *Goodbye, the other types are skipped.
+func ParseRTCP(buf []byte) ([]interface{}, error) {
+	packets := make([]interface{}, 0, 2)
+	for len(buf) > 0 {
+		if len(buf) < 4 || buf[0]>>6 != Version {
+			return packets, errRTCP
+		}
+		count := int(buf[0] & 0x1f)
+		size := 4 * (int(binary.BigEndian.Uint16(buf[2:])) + 1)
+		if len(buf) < size {
+			return packets, errRTCP
+		}
+		body := buf[4:size]
+		switch buf[1] {
+		case TypeSenderReport:
+			if len(body) < 24 {
+				return packets, errRTCP
+			}
+			reports, err := unmarshalReports(body[24:], count)
+			if err != nil {
+				return packets, err
+			}
+			packets = append(packets, &SenderReport{
+				SSRC:        binary.BigEndian.Uint32(body),
+				NTPTime:     binary.BigEndian.Uint64(body[4:]),
+				RTPTime:     binary.BigEndian.Uint32(body[12:]),
+				PacketCount: binary.BigEndian.Uint32(body[16:]),
+				OctetCount:  binary.BigEndian.Uint32(body[20:]),
+				Reports:     reports,
+			})
+		case TypeReceiverReport:
+			if len(body) < 4 {
+				return packets, errRTCP
+			}
+			reports, err := unmarshalReports(body[4:], count)
+			if err != nil {
+				return packets, err
+			}
+			packets = append(packets, &ReceiverReport{
+				SSRC:    binary.BigEndian.Uint32(body),
+				Reports: reports,
+			})
+		case TypeSourceDescription:
+			if sd, ok := parseCNAME(body); ok {
+				packets = append(packets, sd)
+			}
+		case TypeGoodbye:
+			if len(body) < 4*count {
+				return packets, errRTCP
+			}
+			bye := &Goodbye{}
+			for i := 0; i < count; i++ {
+				bye.Sources = append(bye.Sources, binary.BigEndian.Uint32(body[4*i:]))
+			}
+			packets = append(packets, bye)
+		}
+		buf = buf[size:]
+	}
+	return packets, nil
+}
+
+// parseCNAME the CNAME of the first chunk of an SDES.
+func parseCNAME(body []byte) (*SourceDescription, bool) {
+	if len(body) < 4 {
+		return nil, false
+	}
+	sd := &SourceDescription{SSRC: binary.BigEndian.Uint32(body)}
+	items := body[4:]
+	for len(items) >= 2 && items[0] != 0 {
+		length := int(items[1])
+		if len(items) < 2+length {
+			return nil, false
+		}
+		if items[0] == sdesCNAME {
+			sd.CNAME = string(items[2 : 2+length])
+			return sd, true
+		}
+		items = items[2+length:]
+	}
+	return nil, false
+}
@@ -0,0 +1,44 @@
+package rtp
+
+import (
+	"net"
+)
+
+// DSCPEF the DSCP class of the telephony media (RFC 4594).
+const DSCPEF = 46
+
+// SocketOptions the options of the sockets of a stream, e.g. for the firewalls and the QoS of a deployment.
+type SocketOptions struct {
+	// DSCP the code point set in the IP TOS or IPv6 traffic class of the packets sent, e.g. DSCPEF, none if zero.
+	DSCP int
+	// ReadBuffer and WriteBuffer the sizes of the socket buffers, the ones of the system if zero.
+	ReadBuffer  int
+	WriteBuffer int
+}
+
+func (o SocketOptions) apply(conn *net.UDPConn) error {
+	if o.ReadBuffer > 0 {
+		if err := conn.SetReadBuffer(o.ReadBuffer); err != nil {
+			return err
+		}
+	}
+	if o.WriteBuffer > 0 {
+		if err := conn.SetWriteBuffer(o.WriteBuffer); err != nil {
+			return err
+		}
+	}
+	if o.DSCP != 0 {
+		raw, err := conn.SyscallConn()
+		if err != nil {
+			return err
+		}
+		ipv6 := conn.LocalAddr().(*net.UDPAddr).IP.To4() == nil
+		if cerr := raw.Control(func(fd uintptr) {
+			err = setDSCP(fd, o.DSCP, ipv6)
+		}); cerr != nil {
+			return cerr
+		}
+		return err
+	}
+	return nil
+}
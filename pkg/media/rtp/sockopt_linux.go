@@ -0,0 +1,25 @@
+//go:build linux
+// +build linux
+
+package rtp
+
+import (
+	"fmt"
+	"syscall"
+)
+
+func setDSCP(fd uintptr, dscp int, ipv6 bool) error {
+	tos := dscp << 2
+	if ipv6 {
+		if err := syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_TCLASS, tos); err != nil {
+			return fmt.Errorf("set IPV6_TCLASS: %w", err)
+		}
+		// The IPv4 packets of a dual-stack socket.
+		syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_TOS, tos)
+		return nil
+	}
+	if err := syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_TOS, tos); err != nil {
+		return fmt.Errorf("set IP_TOS: %w", err)
+	}
+	return nil
+}
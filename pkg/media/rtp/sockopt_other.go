@@ -0,0 +1,13 @@
+//go:build !linux
+// +build !linux
+
+package rtp
+
+import (
+	"fmt"
+	"runtime"
+)
+
+func setDSCP(fd uintptr, dscp int, ipv6 bool) error {
+	return fmt.Errorf("DSCP marking is not supported on %s", runtime.GOOS)
+}
@@ -0,0 +1,588 @@
+package rtp
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	mrand "math/rand"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/ghettovoice/gosip/log"
+	"github.com/sergeyu/go-sip-ua/pkg/utils"
+)
+
+// DefaultRTCPInterval the mean interval of the RTCP reports (RFC 3550 6.2).
+const DefaultRTCPInterval = 5 * time.Second
+
+// Stats the statistics of a stream.
+type Stats struct {
+	PacketsSent     uint32
+	OctetsSent      uint32
+	PacketsReceived uint32
+	OctetsReceived  uint64
+	// Lost the packets of the remote source lost (RFC 3550 A.3).
+	Lost int64
+	// Jitter the interarrival jitter of the remote source, in timestamp units (RFC 3550 A.8).
+	Jitter uint32
+	// RemoteReport the last reception report of the remote about the stream, nil if none.
+	RemoteReport *ReceptionReport
+	// RTT the round-trip time computed from the reports of the remote (RFC 3550 6.4.1), 0 until one
+	// reports an SR of the stream.
+	RTT time.Duration
+}
+
+// source the reception state of the remote source (RFC 3550 A.1).
+type source struct {
+	started       bool
+	ssrc          uint32
+	baseSeq       uint32
+	maxSeq        uint16
+	cycles        uint32
+	received      uint32
+	octets        uint64
+	expectedPrior uint32
+	receivedPrior uint32
+	transit       int64
+	jitter        float64
+	// lastSR the middle 32 bits of the NTP time of the last SR, received at lastSRTime.
+	lastSR     uint32
+	lastSRTime time.Time
+}
+
+func (s *source) extendedMax() uint32 {
+	return s.cycles + uint32(s.maxSeq)
+}
+
+func (s *source) lost() int64 {
+	return int64(s.extendedMax()-s.baseSeq+1) - int64(s.received)
+}
+
+// Stream an RTP session of a media (RFC 3550): the RTP and RTCP sockets, the packets sent to the remote
+// and received from it, and the RTCP reports sent periodically, an SR when packets were sent since the last one.
+type Stream struct {
+	rtpConn   *net.UDPConn
+	rtcpConn  *net.UDPConn
+	clockRate uint32
+	ssrc      uint32
+	cname     string
+	epoch     time.Time
+
+	mu         sync.Mutex
+	interval   time.Duration
+	remote     *net.UDPAddr
+	remoteRTCP *net.UDPAddr
+	onPacket   func(packet *Packet)
+	onSTUN     func(buf []byte, from *net.UDPAddr)
+	onClose    func()
+	rtcpMux    bool
+	relay      func(buf []byte, to *net.UDPAddr) error
+	// sender state.
+	sequence      uint16
+	timestamp     uint32
+	lastTimestamp uint32
+	lastSample    time.Time
+	sent          bool
+	packetsSent   uint32
+	octetsSent    uint32
+	// the source of the packets forwarded, their timestamps shifted by forwardOffset.
+	forwarding    bool
+	forwardSSRC   uint32
+	forwardOffset uint32
+	// the taps of the packets received and sent, the one of Fork removed by unfork.
+	taps    []tapEntry
+	nextTap int
+	unfork  func()
+	// receiver state.
+	source       source
+	remoteReport *ReceptionReport
+	// rtt the round-trip time of the last report of the remote with an SR of the stream.
+	rtt time.Duration
+	// the telephone-events received, the timestamp of the last one ended.
+	dtmfPayloadType uint8
+	onDTMF          func(event DTMFEvent)
+	dtmfEnded       bool
+	dtmfTimestamp   uint32
+
+	closed    chan struct{}
+	closeOnce sync.Once
+	log       log.Logger
+}
+
+// NewStream a stream of a media with clockRate, 8000 if zero, on a pair of ports of ports bound on ip.
+func NewStream(ports *PortRange, ip string, clockRate uint32) (*Stream, error) {
+	return NewStreamWithOptions(ports, ip, clockRate, SocketOptions{})
+}
+
+// NewStreamWithOptions a stream as NewStream, its sockets set with options.
+func NewStreamWithOptions(ports *PortRange, ip string, clockRate uint32, options SocketOptions) (*Stream, error) {
+	if clockRate == 0 {
+		clockRate = 8000
+	}
+	rtpConn, rtcpConn, err := ports.Listen(ip)
+	if err != nil {
+		return nil, err
+	}
+	for _, conn := range []*net.UDPConn{rtpConn, rtcpConn} {
+		if err := options.apply(conn); err != nil {
+			rtpConn.Close()
+			rtcpConn.Close()
+			return nil, err
+		}
+	}
+	s := &Stream{
+		rtpConn:   rtpConn,
+		rtcpConn:  rtcpConn,
+		clockRate: clockRate,
+		ssrc:      random32(),
+		epoch:     time.Now(),
+		interval:  DefaultRTCPInterval,
+		sequence:  uint16(random32()),
+		timestamp: random32(),
+		closed:    make(chan struct{}),
+		log:       utils.NewLogger(log.DebugLevel, "Media", nil),
+	}
+	s.cname = fmt.Sprintf("%08x@%s", s.ssrc, ip)
+
+	go s.readRTP()
+	go s.readRTCP()
+	go s.report()
+	return s, nil
+}
+
+func random32() uint32 {
+	buf := make([]byte, 4)
+	if _, err := rand.Read(buf); err != nil {
+		return mrand.Uint32()
+	}
+	return binary.BigEndian.Uint32(buf)
+}
+
+func (s *Stream) Log() log.Logger {
+	return s.log
+}
+
+// SSRC the synchronization source of the packets sent.
+func (s *Stream) SSRC() uint32 {
+	return s.ssrc
+}
+
+// LocalAddr the address of the RTP socket, the port of the SDP media.
+func (s *Stream) LocalAddr() *net.UDPAddr {
+	return s.rtpConn.LocalAddr().(*net.UDPAddr)
+}
+
+// LocalRTCPAddr the address of the RTCP socket.
+func (s *Stream) LocalRTCPAddr() *net.UDPAddr {
+	return s.rtcpConn.LocalAddr().(*net.UDPAddr)
+}
+
+// SetRemote the address the packets are sent to, the RTCP ones to the next port unless they are multiplexed.
+// Until it is set it is learned from the first packet received.
+func (s *Stream) SetRemote(addr *net.UDPAddr) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.setRemote(addr)
+}
+
+func (s *Stream) setRemote(addr *net.UDPAddr) {
+	s.remote = addr
+	if s.rtcpMux {
+		s.remoteRTCP = addr
+	} else {
+		s.remoteRTCP = &net.UDPAddr{IP: addr.IP, Port: addr.Port + 1, Zone: addr.Zone}
+	}
+}
+
+// SetRTCPMux whether the RTCP packets are sent on the RTP port (RFC 5761), e.g. with a=rtcp-mux.
+// They are received on both ports anyway.
+func (s *Stream) SetRTCPMux(mux bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rtcpMux = mux
+	if s.remote != nil {
+		s.setRemote(s.remote)
+	}
+}
+
+// SetRelay the sender of the RTP and RTCP packets in place of the sockets, e.g. through the TURN
+// allocation of the ICE pair selected, nil to send them from the sockets.
+func (s *Stream) SetRelay(relay func(buf []byte, to *net.UDPAddr) error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.relay = relay
+}
+
+// OnSTUN handles the STUN messages received on the RTP port (RFC 7983), e.g. the ICE checks,
+// buf is only valid during the call.
+func (s *Stream) OnSTUN(handler func(buf []byte, from *net.UDPAddr)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onSTUN = handler
+}
+
+// OnClose handles the closing of the stream, before its sockets are.
+func (s *Stream) OnClose(handler func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onClose = handler
+}
+
+// WriteTo sends buf from the RTP port to addr, e.g. a STUN message.
+func (s *Stream) WriteTo(buf []byte, addr *net.UDPAddr) error {
+	_, err := s.rtpConn.WriteToUDP(buf, addr)
+	return err
+}
+
+// SetRemoteRTCP the address the RTCP packets are sent to, e.g. of a=rtcp (RFC 3605).
+func (s *Stream) SetRemoteRTCP(addr *net.UDPAddr) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.remoteRTCP = addr
+}
+
+// SetRTCPInterval the mean interval of the reports.
+func (s *Stream) SetRTCPInterval(interval time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.interval = interval
+}
+
+// OnPacket handles the RTP packets received, their payload is only valid during the call.
+func (s *Stream) OnPacket(handler func(packet *Packet)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onPacket = handler
+}
+
+// WriteSample sends payload in a packet, the timestamp of the next one advanced by samples.
+func (s *Stream) WriteSample(payloadType uint8, payload []byte, samples uint32, marker bool) error {
+	s.mu.Lock()
+	remote := s.remote
+	packet := s.nextPacket(payloadType, payload, s.timestamp, marker)
+	s.timestamp += samples
+	relay := s.relay
+	s.mu.Unlock()
+
+	return s.write(packet, remote, relay)
+}
+
+// Forward sends the payload of packet relayed from another source, in sequence with the packets of the stream,
+// its timestamp shifted to the ones of the stream.
+func (s *Stream) Forward(packet *Packet) error {
+	s.mu.Lock()
+	remote := s.remote
+	if !s.forwarding || packet.SSRC != s.forwardSSRC {
+		s.forwarding = true
+		s.forwardSSRC = packet.SSRC
+		s.forwardOffset = s.timestamp - packet.Timestamp
+	}
+	s.timestamp = packet.Timestamp + s.forwardOffset
+	forwarded := s.nextPacket(packet.PayloadType, packet.Payload, s.timestamp, packet.Marker)
+	relay := s.relay
+	s.mu.Unlock()
+
+	return s.write(forwarded, remote, relay)
+}
+
+// nextPacket the packet of the payload at timestamp, counted as sent.
+func (s *Stream) nextPacket(payloadType uint8, payload []byte, timestamp uint32, marker bool) *Packet {
+	packet := &Packet{
+		Marker:         marker,
+		PayloadType:    payloadType,
+		SequenceNumber: s.sequence,
+		Timestamp:      timestamp,
+		SSRC:           s.ssrc,
+		Payload:        payload,
+	}
+	s.lastTimestamp = timestamp
+	s.lastSample = time.Now()
+	s.sequence++
+	s.sent = true
+	s.packetsSent++
+	s.octetsSent += uint32(len(payload))
+	return packet
+}
+
+func (s *Stream) write(packet *Packet, remote *net.UDPAddr, relay func(buf []byte, to *net.UDPAddr) error) error {
+	if remote == nil {
+		return fmt.Errorf("rtp: no remote address")
+	}
+	s.tap(packet, true)
+	if relay != nil {
+		return relay(packet.Marshal(), remote)
+	}
+	_, err := s.rtpConn.WriteToUDP(packet.Marshal(), remote)
+	return err
+}
+
+// Stats the statistics of the stream.
+func (s *Stream) Stats() Stats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stats := Stats{
+		PacketsSent:     s.packetsSent,
+		OctetsSent:      s.octetsSent,
+		PacketsReceived: s.source.received,
+		OctetsReceived:  s.source.octets,
+		Jitter:          uint32(s.source.jitter),
+		RTT:             s.rtt,
+	}
+	if s.source.started {
+		stats.Lost = s.source.lost()
+	}
+	if s.remoteReport != nil {
+		report := *s.remoteReport
+		stats.RemoteReport = &report
+	}
+	return stats
+}
+
+func (s *Stream) readRTP() {
+	buf := make([]byte, 1500)
+	for {
+		n, raddr, err := s.rtpConn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		s.HandlePacket(buf[:n], raddr)
+	}
+}
+
+// HandlePacket handles a packet received on the RTP port or relayed to it, e.g. by TURN:
+// RTP, RTCP multiplexed or STUN (RFC 7983).
+func (s *Stream) HandlePacket(buf []byte, from *net.UDPAddr) {
+	switch {
+	case len(buf) > 0 && buf[0] < 4:
+		s.mu.Lock()
+		handler := s.onSTUN
+		s.mu.Unlock()
+		if handler != nil {
+			handler(buf, from)
+		}
+		return
+	case len(buf) > 1 && buf[1] >= 192 && buf[1] <= 223:
+		s.receivedRTCP(buf, from)
+		return
+	}
+
+	packet := &Packet{}
+	if err := packet.Unmarshal(buf); err != nil {
+		s.Log().Debugf("drop RTP packet from %v: %v", from, err)
+		return
+	}
+
+	s.mu.Lock()
+	if s.remote == nil {
+		s.setRemote(from)
+	}
+	s.received(packet, time.Now())
+	handler := s.onPacket
+	dtmf, ended := s.receivedDTMF(packet)
+	onDTMF := s.onDTMF
+	s.mu.Unlock()
+
+	if ended {
+		onDTMF(dtmf)
+	}
+	if handler != nil {
+		handler(packet)
+	}
+	s.tap(packet, false)
+}
+
+// received updates the reception state with packet, arrived at.
+func (s *Stream) received(packet *Packet, at time.Time) {
+	src := &s.source
+	if !src.started || src.ssrc != packet.SSRC {
+		*src = source{
+			started: true,
+			ssrc:    packet.SSRC,
+			baseSeq: uint32(packet.SequenceNumber),
+			maxSeq:  packet.SequenceNumber,
+		}
+	} else if delta := packet.SequenceNumber - src.maxSeq; delta > 0 && delta < 0x8000 {
+		if packet.SequenceNumber < src.maxSeq {
+			src.cycles += 1 << 16
+		}
+		src.maxSeq = packet.SequenceNumber
+	}
+	src.received++
+	src.octets += uint64(len(packet.Payload))
+
+	// The arrival in timestamp units.
+	arrival := int64(at.Sub(s.epoch).Seconds() * float64(s.clockRate))
+	transit := arrival - int64(packet.Timestamp)
+	if src.received > 1 {
+		d := transit - src.transit
+		if d < 0 {
+			d = -d
+		}
+		src.jitter += (float64(d) - src.jitter) / 16
+	}
+	src.transit = transit
+}
+
+func (s *Stream) readRTCP() {
+	buf := make([]byte, 1500)
+	for {
+		n, raddr, err := s.rtcpConn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		s.receivedRTCP(buf[:n], raddr)
+	}
+}
+
+func (s *Stream) receivedRTCP(buf []byte, from *net.UDPAddr) {
+	packets, err := ParseRTCP(buf)
+	if err != nil {
+		s.Log().Debugf("drop RTCP packet from %v: %v", from, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, packet := range packets {
+		var reports []ReceptionReport
+		switch p := packet.(type) {
+		case *SenderReport:
+			if p.SSRC == s.source.ssrc {
+				s.source.lastSR = uint32(p.NTPTime >> 16)
+				s.source.lastSRTime = time.Now()
+			}
+			reports = p.Reports
+		case *ReceiverReport:
+			reports = p.Reports
+		case *Goodbye:
+			s.Log().Debugf("RTCP BYE from %v", from)
+		}
+		for _, report := range reports {
+			if report.SSRC == s.ssrc {
+				r := report
+				s.remoteReport = &r
+				if r.LastSenderReport != 0 {
+					// The arrival minus the time of the SR and the delay since, in 1/65536 seconds.
+					rtt := uint32(NTPTime(time.Now())>>16) - r.LastSenderReport - r.Delay
+					if int32(rtt) >= 0 {
+						s.rtt = time.Duration(rtt) * time.Second / 65536
+					}
+				}
+			}
+		}
+	}
+}
+
+// report sends the RTCP reports at a random interval of 0.5 to 1.5 times the mean one.
+func (s *Stream) report() {
+	for {
+		s.mu.Lock()
+		interval := s.interval
+		s.mu.Unlock()
+		timer := time.NewTimer(time.Duration((0.5 + mrand.Float64()) * float64(interval)))
+		select {
+		case <-timer.C:
+			s.sendRTCP(false)
+		case <-s.closed:
+			timer.Stop()
+			return
+		}
+	}
+}
+
+// sendRTCP sends a compound packet, an SR or an RR with the SDES, and a BYE when bye.
+func (s *Stream) sendRTCP(bye bool) {
+	s.mu.Lock()
+	remote := s.remoteRTCP
+	conn := s.rtcpConn
+	if s.rtcpMux {
+		conn = s.rtpConn
+	}
+	relay := s.relay
+	if remote == nil {
+		s.mu.Unlock()
+		return
+	}
+	var reports []ReceptionReport
+	if s.source.started {
+		reports = append(reports, s.receptionReport(time.Now()))
+	}
+	var buf []byte
+	if s.sent {
+		now := time.Now()
+		sr := &SenderReport{
+			SSRC:        s.ssrc,
+			NTPTime:     NTPTime(now),
+			RTPTime:     s.lastTimestamp + uint32(now.Sub(s.lastSample).Seconds()*float64(s.clockRate)),
+			PacketCount: s.packetsSent,
+			OctetCount:  s.octetsSent,
+			Reports:     reports,
+		}
+		buf = sr.Marshal()
+		s.sent = false
+	} else {
+		rr := &ReceiverReport{SSRC: s.ssrc, Reports: reports}
+		buf = rr.Marshal()
+	}
+	s.mu.Unlock()
+
+	buf = append(buf, (&SourceDescription{SSRC: s.ssrc, CNAME: s.cname}).Marshal()...)
+	if bye {
+		buf = append(buf, (&Goodbye{Sources: []uint32{s.ssrc}}).Marshal()...)
+	}
+	var err error
+	if relay != nil {
+		err = relay(buf, remote)
+	} else {
+		_, err = conn.WriteToUDP(buf, remote)
+	}
+	if err != nil {
+		s.Log().Debugf("send RTCP to %v: %v", remote, err)
+	}
+}
+
+// receptionReport the report of the remote source since the last one (RFC 3550 A.3).
+func (s *Stream) receptionReport(now time.Time) ReceptionReport {
+	src := &s.source
+	expected := src.extendedMax() - src.baseSeq + 1
+	expectedInterval := expected - src.expectedPrior
+	receivedInterval := src.received - src.receivedPrior
+	src.expectedPrior = expected
+	src.receivedPrior = src.received
+
+	report := ReceptionReport{
+		SSRC:             src.ssrc,
+		HighestSequence:  src.extendedMax(),
+		Jitter:           uint32(src.jitter),
+		LastSenderReport: src.lastSR,
+	}
+	if lost := src.lost(); lost > 0x7fffff {
+		report.TotalLost = 0x7fffff
+	} else if lost > 0 {
+		report.TotalLost = uint32(lost)
+	}
+	if lostInterval := int64(expectedInterval) - int64(receivedInterval); expectedInterval > 0 && lostInterval > 0 {
+		report.FractionLost = uint8(lostInterval << 8 / int64(expectedInterval))
+	}
+	if src.lastSR != 0 {
+		report.Delay = uint32(now.Sub(src.lastSRTime).Seconds() * 65536)
+	}
+	return report
+}
+
+// Close sends an RTCP BYE and closes the sockets.
+func (s *Stream) Close() error {
+	s.closeOnce.Do(func() {
+		s.mu.Lock()
+		onClose := s.onClose
+		s.mu.Unlock()
+		if onClose != nil {
+			onClose()
+		}
+		close(s.closed)
+		s.sendRTCP(true)
+		s.rtpConn.Close()
+		s.rtcpConn.Close()
+	})
+	return nil
+}
@@ -0,0 +1,93 @@
+package rtp
+
+// Tap receives read-only copies of the packets of a stream, e.g. to record it, stream it to a speech
+// analytics service or detect tones. It is called in the media path and must not block it.
+type Tap interface {
+	// Received a packet received from the remote.
+	Received(packet *Packet)
+	// Sent a packet sent to the remote.
+	Sent(packet *Packet)
+}
+
+// TapFunc a Tap of a function, sent whether the packet was sent or received.
+type TapFunc func(packet *Packet, sent bool)
+
+// Received .
+func (f TapFunc) Received(packet *Packet) {
+	f(packet, false)
+}
+
+// Sent .
+func (f TapFunc) Sent(packet *Packet) {
+	f(packet, true)
+}
+
+type tapEntry struct {
+	id  int
+	tap Tap
+}
+
+// AddTap adds a tap of the packets of the stream, until remove is called.
+func (s *Stream) AddTap(tap Tap) (remove func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nextTap++
+	id := s.nextTap
+	s.taps = append(s.taps, tapEntry{id: id, tap: tap})
+	return func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		for i, entry := range s.taps {
+			if entry.id == id {
+				s.taps = append(s.taps[:i:i], s.taps[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+// tap the taps of the stream with a copy of packet, the buffer of a packet received being reused.
+func (s *Stream) tap(packet *Packet, sent bool) {
+	s.mu.Lock()
+	taps := s.taps
+	s.mu.Unlock()
+	if len(taps) == 0 {
+		return
+	}
+	clone := *packet
+	clone.Payload = append([]byte(nil), packet.Payload...)
+	for _, entry := range taps {
+		if sent {
+			entry.tap.Sent(&clone)
+		} else {
+			entry.tap.Received(&clone)
+		}
+	}
+}
+
+// Fork forwards a copy of the packets received to received and of the packets sent to sent, either may be nil,
+// e.g. the streams of a recording session. Fork(nil, nil) stops it.
+func (s *Stream) Fork(received *Stream, sent *Stream) {
+	s.mu.Lock()
+	unfork := s.unfork
+	s.unfork = nil
+	s.mu.Unlock()
+	if unfork != nil {
+		unfork()
+	}
+	if received == nil && sent == nil {
+		return
+	}
+	unfork = s.AddTap(TapFunc(func(packet *Packet, isSent bool) {
+		fork := received
+		if isSent {
+			fork = sent
+		}
+		if fork != nil {
+			fork.Forward(packet)
+		}
+	}))
+	s.mu.Lock()
+	s.unfork = unfork
+	s.mu.Unlock()
+}
@@ -1 +1,225 @@
 package media
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/pixelbender/go-sdp/sdp"
+)
+
+// The codecs of the media descriptions, with their static or usual dynamic payload types.
+var (
+	PCMU           = sdp.Format{Payload: 0, Name: "PCMU", ClockRate: 8000}
+	PCMA           = sdp.Format{Payload: 8, Name: "PCMA", ClockRate: 8000}
+	G722           = sdp.Format{Payload: 9, Name: "G722", ClockRate: 8000}
+	G729           = sdp.Format{Payload: 18, Name: "G729", ClockRate: 8000, Params: []string{"annexb=no"}}
+	Opus           = sdp.Format{Payload: 111, Name: "opus", ClockRate: 48000, Channels: 2}
+	TelephoneEvent = sdp.Format{Payload: 101, Name: "telephone-event", ClockRate: 8000, Params: []string{"0-16"}}
+	VP8            = sdp.Format{Payload: 96, Name: "VP8", ClockRate: 90000}
+	H264           = sdp.Format{Payload: 97, Name: "H264", ClockRate: 90000, Params: []string{"profile-level-id=42e01f;packetization-mode=1"}}
+)
+
+// staticCodecs the names of the static payload types (RFC 3551), the formats without rtpmap.
+var staticCodecs = map[uint8]sdp.Format{
+	PCMU.Payload: PCMU,
+	PCMA.Payload: PCMA,
+	G722.Payload: G722,
+	G729.Payload: G729,
+}
+
+// NewSDP a session description of the media from host, its origin versioned by the time.
+func NewSDP(host string, media ...*sdp.Media) *sdp.Session {
+	now := time.Now().UnixNano() / 1e6
+	return &sdp.Session{
+		Origin: &sdp.Origin{
+			Username:       "-",
+			SessionID:      now,
+			SessionVersion: now,
+			Network:        sdp.NetworkInternet,
+			Type:           addressType(host),
+			Address:        host,
+		},
+		Name: "-",
+		Connection: &sdp.Connection{
+			Network: sdp.NetworkInternet,
+			Type:    addressType(host),
+			Address: host,
+		},
+		Timing: &sdp.Timing{},
+		Media:  media,
+	}
+}
+
+// NewAudio an audio media description received on port with codecs, in order of preference.
+func NewAudio(port int, codecs ...sdp.Format) *sdp.Media {
+	return newMedia("audio", port, codecs)
+}
+
+// NewVideo a video media description received on port with codecs, in order of preference.
+func NewVideo(port int, codecs ...sdp.Format) *sdp.Media {
+	return newMedia("video", port, codecs)
+}
+
+func newMedia(typ string, port int, codecs []sdp.Format) *sdp.Media {
+	m := &sdp.Media{
+		Type:  typ,
+		Port:  port,
+		Proto: "RTP/AVP",
+		Mode:  sdp.SendRecv,
+	}
+	for _, codec := range codecs {
+		format := codec
+		m.Format = append(m.Format, &format)
+	}
+	return m
+}
+
+// Direction the streaming mode of media in session, of the session if the media has none, sendrecv by default.
+func Direction(session *sdp.Session, media *sdp.Media) string {
+	if media != nil && media.Mode != "" {
+		return media.Mode
+	}
+	if session != nil && session.Mode != "" {
+		return session.Mode
+	}
+	return sdp.SendRecv
+}
+
+// Answer the answer to offer (RFC 3264) from host: each media offered is answered by the first local one
+// of its type and protocol not used yet, with the codecs of both in the order of the offer and their payload types,
+// the direction negotiated. The media without local one or without codec in common are rejected with port 0.
+func Answer(offer *sdp.Session, host string, local ...*sdp.Media) *sdp.Session {
+	return answer(offer, host, local, func(offered *sdp.Media, candidate *sdp.Media) bool {
+		return candidate.Proto == offered.Proto
+	})
+}
+
+// answer the answer to offer with the local media of the type of each media offered and of a protocol matching it.
+func answer(offer *sdp.Session, host string, local []*sdp.Media, match func(offered *sdp.Media, candidate *sdp.Media) bool) *sdp.Session {
+	answer := NewSDP(host)
+	used := make([]bool, len(local))
+	for _, offered := range offer.Media {
+		var m *sdp.Media
+		if offered.Port != 0 {
+			for i, candidate := range local {
+				if !used[i] && candidate.Type == offered.Type && match(offered, candidate) {
+					if m = answerMedia(offer, offered, candidate); m != nil {
+						used[i] = true
+						break
+					}
+				}
+			}
+		}
+		if m == nil {
+			m = rejected(offered)
+		}
+		answer.Media = append(answer.Media, m)
+	}
+	return answer
+}
+
+// rejected the answer rejecting the media offered, with port 0.
+func rejected(offered *sdp.Media) *sdp.Media {
+	return &sdp.Media{
+		Type:        offered.Type,
+		Proto:       offered.Proto,
+		Format:      offered.Format,
+		FormatDescr: offered.FormatDescr,
+	}
+}
+
+// answerMedia the answer to the media offered with the local one, nil without codec in common.
+func answerMedia(offer *sdp.Session, offered *sdp.Media, local *sdp.Media) *sdp.Media {
+	m := &sdp.Media{
+		Type:       local.Type,
+		Port:       local.Port,
+		Proto:      local.Proto,
+		Connection: local.Connection,
+		Bandwidth:  local.Bandwidth,
+		Attributes: local.Attributes,
+		Mode:       sdp.NegotiateMode(Direction(nil, local), Direction(offer, offered)),
+	}
+	for _, format := range offered.Format {
+		codec := codecOf(format)
+		for _, supported := range local.Format {
+			if sameCodec(codec, codecOf(supported)) {
+				answered := *supported
+				answered.Payload = format.Payload
+				m.Format = append(m.Format, &answered)
+				break
+			}
+		}
+	}
+	if len(m.Format) == 0 || onlyTelephoneEvent(m.Format) {
+		return nil
+	}
+	return m
+}
+
+// codecOf the codec of format, named after its static payload type without rtpmap.
+func codecOf(format *sdp.Format) sdp.Format {
+	if format.Name == "" {
+		if codec, ok := staticCodecs[format.Payload]; ok {
+			return codec
+		}
+	}
+	return *format
+}
+
+func sameCodec(a sdp.Format, b sdp.Format) bool {
+	channels := func(f sdp.Format) int {
+		if f.Channels == 0 {
+			return 1
+		}
+		return f.Channels
+	}
+	return strings.EqualFold(a.Name, b.Name) && a.ClockRate == b.ClockRate && channels(a) == channels(b)
+}
+
+func onlyTelephoneEvent(formats []*sdp.Format) bool {
+	for _, format := range formats {
+		if !strings.EqualFold(format.Name, TelephoneEvent.Name) {
+			return false
+		}
+	}
+	return true
+}
+
+// TelephoneEventPayload the payload type of the telephone-events of media (RFC 4733), false if it has none.
+func TelephoneEventPayload(media *sdp.Media) (uint8, bool) {
+	for _, format := range media.Format {
+		if strings.EqualFold(format.Name, TelephoneEvent.Name) {
+			return format.Payload, true
+		}
+	}
+	return 0, false
+}
+
+// RTPAddr the address the RTP packets of media are sent to, at the connection of media or of session.
+func RTPAddr(session *sdp.Session, media *sdp.Media) (*net.UDPAddr, error) {
+	connection := session.Connection
+	if len(media.Connection) > 0 {
+		connection = media.Connection[0]
+	}
+	if connection == nil {
+		return nil, fmt.Errorf("sdp: no connection of media %s", media.Type)
+	}
+	ip := net.ParseIP(connection.Address)
+	if ip == nil {
+		addr, err := net.ResolveIPAddr("ip", connection.Address)
+		if err != nil {
+			return nil, err
+		}
+		ip = addr.IP
+	}
+	return &net.UDPAddr{IP: ip, Port: media.Port}, nil
+}
+
+func addressType(host string) string {
+	if ip := net.ParseIP(host); ip != nil && ip.To4() == nil {
+		return sdp.TypeIPv6
+	}
+	return sdp.TypeIPv4
+}
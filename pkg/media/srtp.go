@@ -0,0 +1,254 @@
+package media
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/pixelbender/go-sdp/sdp"
+)
+
+// SRTPPolicy how the media are encrypted with SRTP keyed by SDES (RFC 4568). The keys negotiated are
+// for the media engine of the application, the streams of package rtp send RTP only.
+type SRTPPolicy int
+
+const (
+	// SRTPDisabled the media are sent in RTP, the media offered in RTP/SAVP are rejected.
+	SRTPDisabled SRTPPolicy = iota
+	// SRTPOptional the keys are offered in RTP/AVP, SRTP is used if they are answered.
+	SRTPOptional
+	// SRTPRequired the media are offered in RTP/SAVP, the media offered without keys are rejected.
+	SRTPRequired
+)
+
+// The crypto suites of SRTP (RFC 4568 6.2, RFC 6188).
+const (
+	AESCM128HMACSHA1_80 = "AES_CM_128_HMAC_SHA1_80"
+	AESCM128HMACSHA1_32 = "AES_CM_128_HMAC_SHA1_32"
+	AES256CMHMACSHA1_80 = "AES_256_CM_HMAC_SHA1_80"
+	AES256CMHMACSHA1_32 = "AES_256_CM_HMAC_SHA1_32"
+)
+
+// SRTPSuites the suites offered, in order of preference.
+var SRTPSuites = []string{AESCM128HMACSHA1_80, AESCM128HMACSHA1_32}
+
+// suiteKeyLengths the length of the master key and salt of the suites.
+var suiteKeyLengths = map[string]int{
+	AESCM128HMACSHA1_80: 30,
+	AESCM128HMACSHA1_32: 30,
+	AES256CMHMACSHA1_80: 46,
+	AES256CMHMACSHA1_32: 46,
+}
+
+// Crypto an a=crypto attribute of a media with an inline key (RFC 4568 9.1).
+type Crypto struct {
+	Tag   int
+	Suite string
+	// Key the master key and salt.
+	Key []byte
+	// KeyParams the lifetime and MKI of the key, e.g. "2^20|1:4".
+	KeyParams     string
+	SessionParams []string
+}
+
+// NewCrypto a crypto of suite with a random key.
+func NewCrypto(tag int, suite string) (*Crypto, error) {
+	length, ok := suiteKeyLengths[suite]
+	if !ok {
+		return nil, fmt.Errorf("srtp: unsupported crypto suite %s", suite)
+	}
+	key := make([]byte, length)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	return &Crypto{Tag: tag, Suite: suite, Key: key}, nil
+}
+
+// ParseCrypto the value of an a=crypto attribute, e.g. "1 AES_CM_128_HMAC_SHA1_80 inline:<key>|2^20|1:4".
+func ParseCrypto(value string) (*Crypto, error) {
+	fields := strings.Fields(value)
+	if len(fields) < 3 {
+		return nil, fmt.Errorf("srtp: malformed crypto %q", value)
+	}
+	tag, err := strconv.Atoi(fields[0])
+	if err != nil || tag < 0 {
+		return nil, fmt.Errorf("srtp: malformed crypto tag %q", fields[0])
+	}
+	// Only the first key of the inline ones is kept.
+	keyParams := strings.SplitN(fields[2], ";", 2)[0]
+	if !strings.HasPrefix(keyParams, "inline:") {
+		return nil, fmt.Errorf("srtp: unsupported key method %q", keyParams)
+	}
+	parts := strings.SplitN(strings.TrimPrefix(keyParams, "inline:"), "|", 2)
+	key, err := base64.StdEncoding.DecodeString(parts[0])
+	if err != nil {
+		// The padding may be omitted.
+		if key, err = base64.RawStdEncoding.DecodeString(parts[0]); err != nil {
+			return nil, fmt.Errorf("srtp: malformed key: %v", err)
+		}
+	}
+	c := &Crypto{
+		Tag:           tag,
+		Suite:         fields[1],
+		Key:           key,
+		SessionParams: fields[3:],
+	}
+	if len(parts) > 1 {
+		c.KeyParams = parts[1]
+	}
+	if length, ok := suiteKeyLengths[c.Suite]; ok && len(c.Key) != length {
+		return nil, fmt.Errorf("srtp: key of %d bytes for %s", len(c.Key), c.Suite)
+	}
+	return c, nil
+}
+
+// String the value of the a=crypto attribute.
+func (c *Crypto) String() string {
+	key := "inline:" + base64.StdEncoding.EncodeToString(c.Key)
+	if c.KeyParams != "" {
+		key += "|" + c.KeyParams
+	}
+	value := strconv.Itoa(c.Tag) + " " + c.Suite + " " + key
+	if len(c.SessionParams) > 0 {
+		value += " " + strings.Join(c.SessionParams, " ")
+	}
+	return value
+}
+
+// Cryptos the well formed a=crypto attributes of media.
+func Cryptos(media *sdp.Media) []*Crypto {
+	var cryptos []*Crypto
+	for _, attr := range media.Attributes {
+		if attr.Name != "crypto" {
+			continue
+		}
+		if c, err := ParseCrypto(attr.Value); err == nil {
+			cryptos = append(cryptos, c)
+		}
+	}
+	return cryptos
+}
+
+// IsSecure whether proto is a secure RTP profile, e.g. RTP/SAVP.
+func IsSecure(proto string) bool {
+	return strings.HasPrefix(proto, "RTP/SAVP")
+}
+
+func secureProto(proto string) string {
+	if strings.HasPrefix(proto, "RTP/AVP") {
+		return "RTP/SAVP" + strings.TrimPrefix(proto, "RTP/AVP")
+	}
+	return proto
+}
+
+func plainProto(proto string) string {
+	if IsSecure(proto) {
+		return "RTP/AVP" + strings.TrimPrefix(proto, "RTP/SAVP")
+	}
+	return proto
+}
+
+// SecureOffer keys the RTP media of offer with SRTPSuites according to policy: in RTP/SAVP if it is required,
+// in RTP/AVP as best effort if it is optional. The media with a=crypto already are left as is.
+func SecureOffer(offer *sdp.Session, policy SRTPPolicy) error {
+	if policy == SRTPDisabled {
+		return nil
+	}
+	for _, m := range offer.Media {
+		if m.Port == 0 || !strings.HasPrefix(plainProto(m.Proto), "RTP/AVP") || len(Cryptos(m)) > 0 {
+			continue
+		}
+		for i, suite := range SRTPSuites {
+			c, err := NewCrypto(i+1, suite)
+			if err != nil {
+				return err
+			}
+			m.Attributes = append(m.Attributes, sdp.NewAttr("crypto", c.String()))
+		}
+		if policy == SRTPRequired {
+			m.Proto = secureProto(m.Proto)
+		}
+	}
+	return nil
+}
+
+// AnswerSRTP the answer to offer as Answer, each media keyed according to policy by the first crypto offered
+// of a supported suite. The media offered in RTP/SAVP without such crypto are rejected, the ones in RTP/AVP too
+// if SRTP is required, and all the SRTP ones if it is disabled.
+func AnswerSRTP(offer *sdp.Session, host string, policy SRTPPolicy, local ...*sdp.Media) (*sdp.Session, error) {
+	if policy == SRTPDisabled {
+		return Answer(offer, host, local...), nil
+	}
+	answer := answer(offer, host, local, func(offered *sdp.Media, candidate *sdp.Media) bool {
+		return plainProto(candidate.Proto) == plainProto(offered.Proto)
+	})
+	for i, m := range answer.Media {
+		if m.Port == 0 {
+			continue
+		}
+		offered := offer.Media[i]
+		var selected *Crypto
+		for _, c := range Cryptos(offered) {
+			if _, ok := suiteKeyLengths[c.Suite]; ok {
+				selected = c
+				break
+			}
+		}
+		if selected == nil {
+			if IsSecure(offered.Proto) || policy == SRTPRequired {
+				answer.Media[i] = rejected(offered)
+			}
+			continue
+		}
+		c, err := NewCrypto(selected.Tag, selected.Suite)
+		if err != nil {
+			return nil, err
+		}
+		m.Proto = offered.Proto
+		m.Attributes = append(append(sdp.Attributes{}, m.Attributes...), sdp.NewAttr("crypto", c.String()))
+	}
+	return answer, nil
+}
+
+// SRTP the keys of a media negotiated: the suite, the key of the packets sent and of the ones received.
+type SRTP struct {
+	// Media the index of the media in the descriptions.
+	Media  int
+	Suite  string
+	Local  *Crypto
+	Remote *Crypto
+}
+
+// NegotiatedSRTP the keys of the media of the local and remote descriptions, one of them the answer to the other.
+// It fails if a media in RTP/SAVP has no crypto in common.
+func NegotiatedSRTP(local *sdp.Session, remote *sdp.Session) ([]SRTP, error) {
+	var negotiated []SRTP
+	for i, m := range local.Media {
+		if i >= len(remote.Media) || m.Port == 0 || remote.Media[i].Port == 0 {
+			continue
+		}
+		srtp, ok := commonCrypto(Cryptos(m), Cryptos(remote.Media[i]))
+		if !ok {
+			if IsSecure(m.Proto) || IsSecure(remote.Media[i].Proto) {
+				return negotiated, fmt.Errorf("srtp: no crypto in common for media %d %s", i, m.Type)
+			}
+			continue
+		}
+		srtp.Media = i
+		negotiated = append(negotiated, srtp)
+	}
+	return negotiated, nil
+}
+
+func commonCrypto(local []*Crypto, remote []*Crypto) (SRTP, bool) {
+	for _, r := range remote {
+		for _, l := range local {
+			if l.Tag == r.Tag && l.Suite == r.Suite {
+				return SRTP{Suite: l.Suite, Local: l, Remote: r}, true
+			}
+		}
+	}
+	return SRTP{}, false
+}
@@ -0,0 +1,163 @@
+package media_test
+
+import (
+	"testing"
+
+	"github.com/pixelbender/go-sdp/sdp"
+	"github.com/sergeyu/go-sip-ua/pkg/media"
+)
+
+// key the inline key of the example of RFC 4568 9.1, 30 bytes.
+const key = "PS1uQCVeeCFCanVmcjkpPywjNWhcYD0mXXtxaVBR"
+
+// key256 a key of 46 bytes of the AES-256 suites, padded.
+const key256 = "AAECAwQFBgcICQoLDA0ODxAREhMUFRYXGBkaGxwdHh8gISIjJCUmJygpKissLQ=="
+
+func TestParseCrypto(t *testing.T) {
+	tests := []struct {
+		value     string
+		tag       int
+		keyParams string
+		keyLength int
+		ok        bool
+	}{
+		{"1 AES_CM_128_HMAC_SHA1_80 inline:" + key + "|2^20|1:32", 1, "2^20|1:32", 30, true},
+		{"2 AES_CM_128_HMAC_SHA1_32 inline:" + key, 2, "", 30, true},
+		{"1 AES_CM_128_HMAC_SHA1_80 inline:" + key + " KDR=1 UNENCRYPTED_SRTCP", 1, "", 30, true},
+		{"1 AES_256_CM_HMAC_SHA1_80 inline:" + key256, 1, "", 46, true},
+		// The padding omitted.
+		{"1 AES_256_CM_HMAC_SHA1_80 inline:" + key256[:62], 1, "", 46, true},
+		{"1 AES_CM_128_HMAC_SHA1_80 inline:" + key[:39], 0, "", 0, false},
+		{"x AES_CM_128_HMAC_SHA1_80 inline:" + key, 0, "", 0, false},
+		{"-1 AES_CM_128_HMAC_SHA1_80 inline:" + key, 0, "", 0, false},
+		{"1 AES_CM_128_HMAC_SHA1_80", 0, "", 0, false},
+		{"1 AES_CM_128_HMAC_SHA1_80 key:" + key, 0, "", 0, false},
+		// A key of 30 bytes for a suite of 46.
+		{"1 AES_256_CM_HMAC_SHA1_80 inline:" + key, 0, "", 0, false},
+	}
+	for _, tt := range tests {
+		c, err := media.ParseCrypto(tt.value)
+		if (err == nil) != tt.ok {
+			t.Errorf("ParseCrypto(%q) = %v; want ok %v", tt.value, err, tt.ok)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if c.Tag != tt.tag || c.KeyParams != tt.keyParams || len(c.Key) != tt.keyLength {
+			t.Errorf("ParseCrypto(%q) = %d %q %d bytes; want %d %q %d bytes", tt.value, c.Tag, c.KeyParams, len(c.Key), tt.tag, tt.keyParams, tt.keyLength)
+		}
+		parsed, err := media.ParseCrypto(c.String())
+		if err != nil || parsed.String() != c.String() {
+			t.Errorf("ParseCrypto(%q) = %v, %v; want %s", c.String(), parsed, err, c)
+		}
+	}
+}
+
+// offer an offer of an audio in PCMU keyed by policy.
+func offer(t *testing.T, policy media.SRTPPolicy) *sdp.Session {
+	o := media.NewSDP("192.0.2.1", media.NewAudio(4000, media.PCMU))
+	if err := media.SecureOffer(o, policy); err != nil {
+		t.Fatal(err)
+	}
+	return o
+}
+
+func TestSecureOffer(t *testing.T) {
+	tests := []struct {
+		policy  media.SRTPPolicy
+		proto   string
+		cryptos int
+	}{
+		{media.SRTPDisabled, "RTP/AVP", 0},
+		{media.SRTPOptional, "RTP/AVP", len(media.SRTPSuites)},
+		{media.SRTPRequired, "RTP/SAVP", len(media.SRTPSuites)},
+	}
+	for _, tt := range tests {
+		m := offer(t, tt.policy).Media[0]
+		if m.Proto != tt.proto {
+			t.Errorf("policy %d: Proto = %s; want %s", tt.policy, m.Proto, tt.proto)
+		}
+		cryptos := media.Cryptos(m)
+		if len(cryptos) != tt.cryptos {
+			t.Fatalf("policy %d: %d cryptos; want %d", tt.policy, len(cryptos), tt.cryptos)
+		}
+		for i, c := range cryptos {
+			if c.Tag != i+1 || c.Suite != media.SRTPSuites[i] {
+				t.Errorf("policy %d: crypto %d = %d %s; want %d %s", tt.policy, i, c.Tag, c.Suite, i+1, media.SRTPSuites[i])
+			}
+		}
+	}
+}
+
+func TestAnswerSRTP(t *testing.T) {
+	tests := []struct {
+		offered  media.SRTPPolicy
+		answered media.SRTPPolicy
+		proto    string
+		// port 0 if the media is rejected.
+		port int
+		keys int
+	}{
+		{media.SRTPDisabled, media.SRTPDisabled, "RTP/AVP", 5000, 0},
+		{media.SRTPDisabled, media.SRTPOptional, "RTP/AVP", 5000, 0},
+		{media.SRTPDisabled, media.SRTPRequired, "RTP/AVP", 0, 0},
+		{media.SRTPOptional, media.SRTPDisabled, "RTP/AVP", 5000, 0},
+		{media.SRTPOptional, media.SRTPOptional, "RTP/AVP", 5000, 1},
+		{media.SRTPOptional, media.SRTPRequired, "RTP/AVP", 5000, 1},
+		{media.SRTPRequired, media.SRTPDisabled, "RTP/SAVP", 0, 0},
+		{media.SRTPRequired, media.SRTPOptional, "RTP/SAVP", 5000, 1},
+		{media.SRTPRequired, media.SRTPRequired, "RTP/SAVP", 5000, 1},
+	}
+	for _, tt := range tests {
+		o := offer(t, tt.offered)
+		answer, err := media.AnswerSRTP(o, "192.0.2.2", tt.answered, media.NewAudio(5000, media.PCMU))
+		if err != nil {
+			t.Fatal(err)
+		}
+		m := answer.Media[0]
+		if m.Port != tt.port || (m.Port != 0 && m.Proto != tt.proto) {
+			t.Errorf("%d answered by %d: %s %d; want %s %d", tt.offered, tt.answered, m.Proto, m.Port, tt.proto, tt.port)
+			continue
+		}
+		keys, err := media.NegotiatedSRTP(answer, o)
+		if err != nil {
+			t.Errorf("%d answered by %d: NegotiatedSRTP = %v", tt.offered, tt.answered, err)
+			continue
+		}
+		if len(keys) != tt.keys {
+			t.Errorf("%d answered by %d: %d keys; want %d", tt.offered, tt.answered, len(keys), tt.keys)
+			continue
+		}
+		if tt.keys == 0 {
+			continue
+		}
+		// The first crypto offered, answered with a key of the answerer.
+		local, remote := keys[0].Local, keys[0].Remote
+		if keys[0].Suite != media.SRTPSuites[0] || local.Tag != 1 || remote.Tag != 1 || string(local.Key) == string(remote.Key) {
+			t.Errorf("%d answered by %d: keys %+v", tt.offered, tt.answered, keys[0])
+		}
+	}
+}
+
+func TestNegotiatedSRTPNoCommonCrypto(t *testing.T) {
+	o := offer(t, media.SRTPRequired)
+	answer := media.NewSDP("192.0.2.2", media.NewAudio(5000, media.PCMU))
+	answer.Media[0].Proto = "RTP/SAVP"
+	c, err := media.NewCrypto(3, media.AESCM128HMACSHA1_80)
+	if err != nil {
+		t.Fatal(err)
+	}
+	answer.Media[0].Attributes = append(answer.Media[0].Attributes, sdp.NewAttr("crypto", c.String()))
+	if _, err := media.NegotiatedSRTP(o, answer); err == nil {
+		t.Error("NegotiatedSRTP of a tag not offered: no error")
+	}
+
+	// Neither in RTP/SAVP, no keys.
+	o = offer(t, media.SRTPOptional)
+	answer.Media[0].Proto = "RTP/AVP"
+	keys, err := media.NegotiatedSRTP(o, answer)
+	if err != nil || len(keys) != 0 {
+		t.Errorf("NegotiatedSRTP = %v, %v; want no keys", keys, err)
+	}
+}
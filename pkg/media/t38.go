@@ -0,0 +1,181 @@
+package media
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/pixelbender/go-sdp/sdp"
+)
+
+// The rate managements and the error corrections of T.38.
+const (
+	T38LocalTCF       = "localTCF"
+	T38TransferredTCF = "transferredTCF"
+	T38UDPRedundancy  = "t38UDPRedundancy"
+	T38UDPFEC         = "t38UDPFEC"
+)
+
+// T38 the parameters of a T.38 fax relay media (ITU-T T.38 Annex D), m=image udptl t38.
+// The zero values are not described.
+type T38 struct {
+	Version         int
+	MaxBitRate      int
+	FillBitRemoval  bool
+	TranscodingMMR  bool
+	TranscodingJBIG bool
+	// RateManagement T38LocalTCF or T38TransferredTCF.
+	RateManagement string
+	MaxBuffer      int
+	// MaxDatagram the largest UDPTL datagram the endpoint receives, declared by each side.
+	MaxDatagram int
+	// UDPEC T38UDPRedundancy or T38UDPFEC, no error correction if empty.
+	UDPEC string
+}
+
+// DefaultT38 the parameters of the T.38 media of the usual gateways, a fax at 14400 bit/s.
+var DefaultT38 = T38{
+	Version:        0,
+	MaxBitRate:     14400,
+	RateManagement: T38TransferredTCF,
+	MaxDatagram:    400,
+	UDPEC:          T38UDPRedundancy,
+}
+
+// NewT38 a T.38 media description received on port with params.
+func NewT38(port int, params T38) *sdp.Media {
+	m := &sdp.Media{
+		Type:        "image",
+		Port:        port,
+		Proto:       "udptl",
+		FormatDescr: "t38",
+	}
+	add := func(name string, value string) {
+		m.Attributes = append(m.Attributes, sdp.NewAttr(name, value))
+	}
+	flag := func(name string, set bool) {
+		if set {
+			m.Attributes = append(m.Attributes, sdp.NewAttrFlag(name))
+		}
+	}
+	add("T38FaxVersion", strconv.Itoa(params.Version))
+	if params.MaxBitRate > 0 {
+		add("T38MaxBitRate", strconv.Itoa(params.MaxBitRate))
+	}
+	flag("T38FaxFillBitRemoval", params.FillBitRemoval)
+	flag("T38FaxTranscodingMMR", params.TranscodingMMR)
+	flag("T38FaxTranscodingJBIG", params.TranscodingJBIG)
+	if params.RateManagement != "" {
+		add("T38FaxRateManagement", params.RateManagement)
+	}
+	if params.MaxBuffer > 0 {
+		add("T38FaxMaxBuffer", strconv.Itoa(params.MaxBuffer))
+	}
+	if params.MaxDatagram > 0 {
+		add("T38FaxMaxDatagram", strconv.Itoa(params.MaxDatagram))
+	}
+	if params.UDPEC != "" {
+		add("T38FaxUdpEC", params.UDPEC)
+	}
+	return m
+}
+
+// IsT38 whether media is a T.38 fax relay media.
+func IsT38(media *sdp.Media) bool {
+	return media.Type == "image" && strings.EqualFold(media.Proto, "udptl") &&
+		strings.EqualFold(strings.TrimSpace(media.FormatDescr), "t38")
+}
+
+// ParseT38 the parameters of a T.38 media, false if media is not one. The names of the attributes are
+// case insensitive and the flags may have a value of 0 or 1, as some gateways send them.
+func ParseT38(media *sdp.Media) (T38, bool) {
+	if !IsT38(media) {
+		return T38{}, false
+	}
+	params := T38{}
+	for _, attr := range media.Attributes {
+		value := strings.TrimSpace(attr.Value)
+		number, _ := strconv.Atoi(value)
+		flag := value != "0"
+		switch strings.ToLower(attr.Name) {
+		case "t38faxversion":
+			params.Version = number
+		case "t38maxbitrate":
+			params.MaxBitRate = number
+		case "t38faxfillbitremoval":
+			params.FillBitRemoval = flag
+		case "t38faxtranscodingmmr":
+			params.TranscodingMMR = flag
+		case "t38faxtranscodingjbig":
+			params.TranscodingJBIG = flag
+		case "t38faxratemanagement":
+			params.RateManagement = value
+		case "t38faxmaxbuffer":
+			params.MaxBuffer = number
+		case "t38faxmaxdatagram":
+			params.MaxDatagram = number
+		case "t38faxudpec":
+			params.UDPEC = value
+		}
+	}
+	return params, true
+}
+
+// FindT38 the first T.38 media of session not rejected and its parameters, false if it has none,
+// e.g. of a re-INVITE switching a call to fax.
+func FindT38(session *sdp.Session) (*sdp.Media, T38, bool) {
+	for _, m := range session.Media {
+		if m.Port == 0 {
+			continue
+		}
+		if params, ok := ParseT38(m); ok {
+			return m, params, true
+		}
+	}
+	return nil, T38{}, false
+}
+
+// AnswerT38 the answer to offer from host accepting its T.38 media on port, the other media rejected.
+// The parameters are negotiated with the local ones (ITU-T T.38 Annex D.2.3): the lower version and
+// bit rate, the options both support, the rate management of the offer, and its error correction
+// unless local has none or only redundancy for an offered FEC. Without T.38 offered, all are rejected.
+func AnswerT38(offer *sdp.Session, host string, port int, local T38) *sdp.Session {
+	answer := NewSDP(host)
+	accepted := false
+	for _, offered := range offer.Media {
+		params, ok := ParseT38(offered)
+		if !ok || offered.Port == 0 || accepted {
+			answer.Media = append(answer.Media, rejected(offered))
+			continue
+		}
+		accepted = true
+		answer.Media = append(answer.Media, NewT38(port, negotiateT38(params, local)))
+	}
+	return answer
+}
+
+func negotiateT38(offered T38, local T38) T38 {
+	params := T38{
+		Version:         offered.Version,
+		MaxBitRate:      offered.MaxBitRate,
+		FillBitRemoval:  offered.FillBitRemoval && local.FillBitRemoval,
+		TranscodingMMR:  offered.TranscodingMMR && local.TranscodingMMR,
+		TranscodingJBIG: offered.TranscodingJBIG && local.TranscodingJBIG,
+		RateManagement:  offered.RateManagement,
+		MaxBuffer:       local.MaxBuffer,
+		MaxDatagram:     local.MaxDatagram,
+		UDPEC:           offered.UDPEC,
+	}
+	if local.Version < params.Version {
+		params.Version = local.Version
+	}
+	if local.MaxBitRate > 0 && (params.MaxBitRate == 0 || local.MaxBitRate < params.MaxBitRate) {
+		params.MaxBitRate = local.MaxBitRate
+	}
+	switch {
+	case local.UDPEC == "":
+		params.UDPEC = ""
+	case strings.EqualFold(offered.UDPEC, T38UDPFEC) && !strings.EqualFold(local.UDPEC, T38UDPFEC):
+		params.UDPEC = T38UDPRedundancy
+	}
+	return params
+}
@@ -0,0 +1,171 @@
+// Package wav reads and writes the audio of WAV files: 16 bits linear PCM, μ-law and A-law.
+package wav
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/sergeyu/go-sip-ua/pkg/media/g711"
+)
+
+// The formats of the samples.
+const (
+	FormatPCM  = 1
+	FormatAlaw = 6
+	FormatUlaw = 7
+)
+
+const headerSize = 44
+
+var errNotWAV = errors.New("wav: not a RIFF WAVE file")
+
+// Reader the samples of a WAV file.
+type Reader struct {
+	Format        uint16
+	Channels      uint16
+	SampleRate    uint32
+	BitsPerSample uint16
+	data          io.Reader
+}
+
+// NewReader reads the header of the file of r up to its samples.
+func NewReader(r io.Reader) (*Reader, error) {
+	riff := make([]byte, 12)
+	if _, err := io.ReadFull(r, riff); err != nil {
+		return nil, err
+	}
+	if string(riff[:4]) != "RIFF" || string(riff[8:]) != "WAVE" {
+		return nil, errNotWAV
+	}
+	wr := &Reader{}
+	format := false
+	for {
+		chunk := make([]byte, 8)
+		if _, err := io.ReadFull(r, chunk); err != nil {
+			return nil, err
+		}
+		size := int64(binary.LittleEndian.Uint32(chunk[4:]))
+		switch string(chunk[:4]) {
+		case "fmt ":
+			if size < 16 {
+				return nil, errNotWAV
+			}
+			body := make([]byte, size+size%2)
+			if _, err := io.ReadFull(r, body); err != nil {
+				return nil, err
+			}
+			wr.Format = binary.LittleEndian.Uint16(body)
+			wr.Channels = binary.LittleEndian.Uint16(body[2:])
+			wr.SampleRate = binary.LittleEndian.Uint32(body[4:])
+			wr.BitsPerSample = binary.LittleEndian.Uint16(body[14:])
+			format = true
+		case "data":
+			if !format {
+				return nil, errNotWAV
+			}
+			switch {
+			case wr.Format == FormatPCM && wr.BitsPerSample == 16:
+			case (wr.Format == FormatAlaw || wr.Format == FormatUlaw) && wr.BitsPerSample == 8:
+			default:
+				return nil, fmt.Errorf("wav: unsupported format %d of %d bits", wr.Format, wr.BitsPerSample)
+			}
+			wr.data = io.LimitReader(r, size)
+			return wr, nil
+		default:
+			// The chunks are padded to an even size.
+			if _, err := io.CopyN(ioutil.Discard, r, size+size%2); err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+// ReadSamples reads up to len(samples) linear samples, interleaved if the file has several channels.
+func (r *Reader) ReadSamples(samples []int16) (int, error) {
+	size := 1
+	if r.Format == FormatPCM {
+		size = 2
+	}
+	buf := make([]byte, size*len(samples))
+	n, err := io.ReadFull(r.data, buf)
+	if err == io.ErrUnexpectedEOF {
+		err = nil
+	}
+	count := n / size
+	for i := 0; i < count; i++ {
+		switch r.Format {
+		case FormatPCM:
+			samples[i] = int16(binary.LittleEndian.Uint16(buf[2*i:]))
+		case FormatUlaw:
+			samples[i] = g711.UlawToLinear(buf[i])
+		case FormatAlaw:
+			samples[i] = g711.AlawToLinear(buf[i])
+		}
+	}
+	if count == 0 && err == nil {
+		err = io.EOF
+	}
+	return count, err
+}
+
+// Writer writes mono 16 bits linear samples to a WAV file.
+type Writer struct {
+	w    io.WriteSeeker
+	size uint32
+}
+
+// NewWriter writes the header of a file of sampleRate to w, its sizes are set by Close.
+func NewWriter(w io.WriteSeeker, sampleRate uint32) (*Writer, error) {
+	header := make([]byte, headerSize)
+	copy(header, "RIFF")
+	copy(header[8:], "WAVEfmt ")
+	binary.LittleEndian.PutUint32(header[16:], 16)
+	binary.LittleEndian.PutUint16(header[20:], FormatPCM)
+	binary.LittleEndian.PutUint16(header[22:], 1)
+	binary.LittleEndian.PutUint32(header[24:], sampleRate)
+	binary.LittleEndian.PutUint32(header[28:], sampleRate*2)
+	binary.LittleEndian.PutUint16(header[32:], 2)
+	binary.LittleEndian.PutUint16(header[34:], 16)
+	copy(header[36:], "data")
+	if _, err := w.Write(header); err != nil {
+		return nil, err
+	}
+	return &Writer{w: w}, nil
+}
+
+// WriteSamples appends samples to the file.
+func (w *Writer) WriteSamples(samples []int16) error {
+	buf := make([]byte, 2*len(samples))
+	for i, sample := range samples {
+		binary.LittleEndian.PutUint16(buf[2*i:], uint16(sample))
+	}
+	n, err := w.w.Write(buf)
+	w.size += uint32(n)
+	return err
+}
+
+// Close sets the sizes of the header, the underlying writer is left open.
+func (w *Writer) Close() error {
+	sizes := []struct {
+		offset int64
+		value  uint32
+	}{
+		{4, headerSize - 8 + w.size},
+		{40, w.size},
+	}
+	buf := make([]byte, 4)
+	for _, size := range sizes {
+		if _, err := w.w.Seek(size.offset, io.SeekStart); err != nil {
+			return err
+		}
+		binary.LittleEndian.PutUint32(buf, size.value)
+		if _, err := w.w.Write(buf); err != nil {
+			return err
+		}
+	}
+	_, err := w.w.Seek(0, io.SeekEnd)
+	return err
+}
@@ -0,0 +1,327 @@
+//go:build pion
+// +build pion
+
+package bridge
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/ghettovoice/gosip/log"
+	pionrtp "github.com/pion/rtp"
+	pion "github.com/pion/webrtc/v4"
+	"github.com/pixelbender/go-sdp/sdp"
+	"github.com/sergeyu/go-sip-ua/pkg/media"
+	"github.com/sergeyu/go-sip-ua/pkg/media/rtp"
+	"github.com/sergeyu/go-sip-ua/pkg/utils"
+)
+
+// Config of the bridges.
+type Config struct {
+	// ICEServers the STUN and TURN servers of the peer connections.
+	ICEServers []pion.ICEServer
+	// Codecs the audio codecs relayed in order of preference, PCMU and PCMA by default.
+	// The media is not transcoded, the browser and the SIP side must have one in common.
+	Codecs []sdp.Format
+}
+
+// Bridge relays the audio of a peer connection with a browser to the RTP stream of a SIP session and back.
+type Bridge struct {
+	config Config
+	pc     *pion.PeerConnection
+	stream *rtp.Stream
+	host   string
+
+	mu sync.Mutex
+	// codec the codec relayed, with the payload type of the SIP side.
+	codec     *sdp.Format
+	track     *pion.TrackLocalStaticRTP
+	onClose   func()
+	closeOnce sync.Once
+	log       log.Logger
+}
+
+// New a bridge of the stream of a SIP session, described from host, to a new peer connection.
+func New(config Config, stream *rtp.Stream, host string) (*Bridge, error) {
+	if len(config.Codecs) == 0 {
+		config.Codecs = []sdp.Format{media.PCMU, media.PCMA}
+	}
+	engine := &pion.MediaEngine{}
+	for _, codec := range config.Codecs {
+		if err := engine.RegisterCodec(pion.RTPCodecParameters{
+			RTPCodecCapability: capability(&codec),
+			PayloadType:        pion.PayloadType(codec.Payload),
+		}, pion.RTPCodecTypeAudio); err != nil {
+			return nil, err
+		}
+	}
+	api := pion.NewAPI(pion.WithMediaEngine(engine))
+	pc, err := api.NewPeerConnection(pion.Configuration{
+		ICEServers:    config.ICEServers,
+		BundlePolicy:  pion.BundlePolicyMaxBundle,
+		RTCPMuxPolicy: pion.RTCPMuxPolicyRequire,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	b := &Bridge{
+		config: config,
+		pc:     pc,
+		stream: stream,
+		host:   host,
+		log:    utils.NewLogger(log.DebugLevel, "WebRTC", nil),
+	}
+	pc.OnTrack(b.relayTrack)
+	pc.OnConnectionStateChange(func(state pion.PeerConnectionState) {
+		b.Log().Debugf("Peer connection %s", state)
+		if state == pion.PeerConnectionStateFailed || state == pion.PeerConnectionStateClosed {
+			b.closed()
+		}
+	})
+	stream.OnPacket(b.relayPacket)
+	return b, nil
+}
+
+func (b *Bridge) Log() log.Logger {
+	return b.log
+}
+
+// capability the pion codec of format.
+func capability(format *sdp.Format) pion.RTPCodecCapability {
+	return pion.RTPCodecCapability{
+		MimeType:    "audio/" + format.Name,
+		ClockRate:   uint32(format.ClockRate),
+		Channels:    uint16(format.Channels),
+		SDPFmtpLine: strings.Join(format.Params, ";"),
+	}
+}
+
+// AnswerWebRTC answers the offer of the browser, the codec relayed the first one offered of Codecs.
+// The SIP side is then offered with SIPOffer and its answer set with SetSIPAnswer.
+func (b *Bridge) AnswerWebRTC(offer string) (string, error) {
+	parsed, err := sdp.ParseString(offer)
+	if err != nil {
+		return "", err
+	}
+	_, _, format, err := negotiate(parsed, b.host, b.stream.LocalAddr().Port, b.config.Codecs)
+	if err != nil {
+		return "", err
+	}
+	// The SIP side is offered the payload type of Codecs.
+	codec := *format
+	for _, c := range b.config.Codecs {
+		if strings.EqualFold(c.Name, format.Name) && c.ClockRate == format.ClockRate {
+			codec.Payload = c.Payload
+			break
+		}
+	}
+
+	if err := b.pc.SetRemoteDescription(pion.SessionDescription{Type: pion.SDPTypeOffer, SDP: offer}); err != nil {
+		return "", err
+	}
+	if err := b.addTrack(&codec); err != nil {
+		return "", err
+	}
+	answer, err := b.pc.CreateAnswer(nil)
+	if err != nil {
+		return "", err
+	}
+	return b.setLocalDescription(answer)
+}
+
+// SIPOffer the offer of the SIP side, the stream with the codec relayed, e.g. for UserAgent.InviteWithOffer.
+func (b *Bridge) SIPOffer() (*sdp.Session, error) {
+	codec := b.relayed()
+	if codec == nil {
+		return nil, fmt.Errorf("bridge: no codec negotiated with the browser")
+	}
+	return media.NewSDP(b.host, media.NewAudio(b.stream.LocalAddr().Port, *codec)), nil
+}
+
+// SetSIPAnswer the answer of the SIP side to SIPOffer, the stream sent to its audio media.
+func (b *Bridge) SetSIPAnswer(answer *sdp.Session) error {
+	codec := b.relayed()
+	if codec == nil {
+		return fmt.Errorf("bridge: no codec negotiated with the browser")
+	}
+	m := audioMedia(answer)
+	if m == nil {
+		return fmt.Errorf("bridge: audio rejected by the SIP side")
+	}
+	format := findCodec(m, codec)
+	if format == nil {
+		return fmt.Errorf("bridge: %s rejected by the SIP side", codec.Name)
+	}
+	addr, err := media.RTPAddr(answer, m)
+	if err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	b.codec.Payload = format.Payload
+	b.mu.Unlock()
+	b.stream.SetRemote(addr)
+	return nil
+}
+
+// AnswerSIP answers the offer of the SIP side with the stream, the codec relayed the first one offered of Codecs.
+// The browser is then offered with OfferWebRTC and its answer set with SetWebRTCAnswer.
+func (b *Bridge) AnswerSIP(offer *sdp.Session) (*sdp.Session, error) {
+	answer, offered, format, err := negotiate(offer, b.host, b.stream.LocalAddr().Port, b.config.Codecs)
+	if err != nil {
+		return nil, err
+	}
+	addr, err := media.RTPAddr(offer, offered)
+	if err != nil {
+		return nil, err
+	}
+	codec := *format
+	b.mu.Lock()
+	b.codec = &codec
+	b.mu.Unlock()
+	b.stream.SetRemote(addr)
+	return answer, nil
+}
+
+// OfferWebRTC the offer to the browser, with the codec relayed of AnswerSIP.
+func (b *Bridge) OfferWebRTC() (string, error) {
+	codec := b.relayed()
+	if codec == nil {
+		return "", fmt.Errorf("bridge: no codec negotiated with the SIP side")
+	}
+	if err := b.addTrack(codec); err != nil {
+		return "", err
+	}
+	offer, err := b.pc.CreateOffer(nil)
+	if err != nil {
+		return "", err
+	}
+	return b.setLocalDescription(offer)
+}
+
+// SetWebRTCAnswer the answer of the browser to OfferWebRTC.
+func (b *Bridge) SetWebRTCAnswer(answer string) error {
+	return b.pc.SetRemoteDescription(pion.SessionDescription{Type: pion.SDPTypeAnswer, SDP: answer})
+}
+
+// OnClose handles the end of the peer connection, failed or closed, e.g. to hang up the SIP side.
+func (b *Bridge) OnClose(handler func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.onClose = handler
+}
+
+// Close the peer connection, the stream is closed with its session.
+func (b *Bridge) Close() error {
+	return b.pc.Close()
+}
+
+func (b *Bridge) closed() {
+	b.closeOnce.Do(func() {
+		b.mu.Lock()
+		handler := b.onClose
+		b.mu.Unlock()
+		if handler != nil {
+			handler()
+		}
+	})
+}
+
+func (b *Bridge) relayed() *sdp.Format {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.codec == nil {
+		return nil
+	}
+	codec := *b.codec
+	return &codec
+}
+
+// addTrack the track of the audio relayed to the browser with codec.
+func (b *Bridge) addTrack(codec *sdp.Format) error {
+	track, err := pion.NewTrackLocalStaticRTP(capability(codec), "audio", "sip")
+	if err != nil {
+		return err
+	}
+	sender, err := b.pc.AddTrack(track)
+	if err != nil {
+		return err
+	}
+	// The RTCP of the browser is read for the interceptors of the sender.
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			if _, _, err := sender.Read(buf); err != nil {
+				return
+			}
+		}
+	}()
+
+	b.mu.Lock()
+	b.codec = codec
+	b.track = track
+	b.mu.Unlock()
+	return nil
+}
+
+// setLocalDescription sets description once its candidates are gathered, the SDP sent without trickle ICE.
+func (b *Bridge) setLocalDescription(description pion.SessionDescription) (string, error) {
+	gathered := pion.GatheringCompletePromise(b.pc)
+	if err := b.pc.SetLocalDescription(description); err != nil {
+		return "", err
+	}
+	<-gathered
+	return b.pc.LocalDescription().SDP, nil
+}
+
+// relayTrack forwards the packets of the codec relayed from the browser to the SIP side.
+func (b *Bridge) relayTrack(track *pion.TrackRemote, receiver *pion.RTPReceiver) {
+	if track.Kind() != pion.RTPCodecTypeAudio {
+		return
+	}
+	b.Log().Debugf("Relaying track %s %s", track.ID(), track.Codec().MimeType)
+	for {
+		packet, _, err := track.ReadRTP()
+		if err != nil {
+			return
+		}
+		codec := b.relayed()
+		if codec == nil || packet.PayloadType != uint8(track.PayloadType()) {
+			continue
+		}
+		if err := b.stream.Forward(&rtp.Packet{
+			Marker:      packet.Marker,
+			PayloadType: codec.Payload,
+			Timestamp:   packet.Timestamp,
+			SSRC:        packet.SSRC,
+			Payload:     packet.Payload,
+		}); err != nil {
+			b.Log().Debugf("Forward to the SIP side failed: %v", err)
+		}
+	}
+}
+
+// relayPacket forwards the packets of the codec relayed from the SIP side to the browser.
+func (b *Bridge) relayPacket(packet *rtp.Packet) {
+	b.mu.Lock()
+	codec, track := b.codec, b.track
+	b.mu.Unlock()
+	if codec == nil || track == nil || packet.PayloadType != codec.Payload {
+		return
+	}
+	if err := track.WriteRTP(&pionrtp.Packet{
+		Header: pionrtp.Header{
+			Version:        rtp.Version,
+			Marker:         packet.Marker,
+			PayloadType:    packet.PayloadType,
+			SequenceNumber: packet.SequenceNumber,
+			Timestamp:      packet.Timestamp,
+			SSRC:           packet.SSRC,
+		},
+		Payload: packet.Payload,
+	}); err != nil {
+		b.Log().Debugf("Write to the browser failed: %v", err)
+	}
+}
@@ -0,0 +1,58 @@
+package bridge
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pixelbender/go-sdp/sdp"
+	"github.com/sergeyu/go-sip-ua/pkg/media"
+)
+
+// negotiate the answer to offer from host with an audio media on port, the media answered in offer
+// and the codec relayed: the first one of codecs offered, with the payload type of the offer.
+func negotiate(offer *sdp.Session, host string, port int, codecs []sdp.Format) (*sdp.Session, *sdp.Media, *sdp.Format, error) {
+	proto := "RTP/AVP"
+	for _, m := range offer.Media {
+		if m.Type == "audio" && m.Port != 0 {
+			proto = m.Proto
+			break
+		}
+	}
+	local := media.NewAudio(port, codecs...)
+	local.Proto = proto
+	answer := media.Answer(offer, host, local)
+	for i, m := range answer.Media {
+		if m.Type != "audio" || m.Port == 0 {
+			continue
+		}
+		for _, format := range m.Format {
+			if !strings.EqualFold(format.Name, media.TelephoneEvent.Name) {
+				return answer, offer.Media[i], format, nil
+			}
+		}
+	}
+	return nil, nil, nil, fmt.Errorf("bridge: no audio codec in common with the offer")
+}
+
+// findCodec the format of codec in m, by its name or its static payload type.
+func findCodec(m *sdp.Media, codec *sdp.Format) *sdp.Format {
+	for _, format := range m.Format {
+		if format.Name == "" && format.Payload == codec.Payload && codec.Payload < 96 {
+			return format
+		}
+		if strings.EqualFold(format.Name, codec.Name) && format.ClockRate == codec.ClockRate {
+			return format
+		}
+	}
+	return nil
+}
+
+// audioMedia the first audio media of session not rejected.
+func audioMedia(session *sdp.Session) *sdp.Media {
+	for _, m := range session.Media {
+		if m.Type == "audio" && m.Port != 0 {
+			return m
+		}
+	}
+	return nil
+}
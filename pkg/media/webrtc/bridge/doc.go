@@ -0,0 +1,19 @@
+// Package bridge gateways the WebRTC calls of browsers to SIP: a Bridge relays the audio of a pion/webrtc
+// peer connection (ICE, DTLS-SRTP, rtcp-mux) to the RTP stream of a SIP session and back, without transcoding.
+//
+// The bridge depends on github.com/pion/webrtc/v4, required by the go.mod of this package, a module of its
+// own so that the other packages of go-sip-ua do not depend on pion. It is built with the pion tag:
+//
+//	go build -tags pion github.com/sergeyu/go-sip-ua/pkg/media/webrtc/bridge
+//
+// A browser calling SIP:
+//
+//	stream, _ := ua.NewStream(is, ip, 8000)
+//	b, _ := bridge.New(bridge.Config{}, stream, ip)
+//	answer, _ := b.AnswerWebRTC(browserOffer) // sent back to the browser
+//	offer, _ := b.SIPOffer()                  // for UserAgent.InviteWithOffer
+//	...
+//	b.SetSIPAnswer(sipAnswer)
+//
+// A SIP call to a browser is bridged with AnswerSIP, OfferWebRTC and SetWebRTCAnswer.
+package bridge
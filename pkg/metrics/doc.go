@@ -0,0 +1,18 @@
+// Package metrics exports the Prometheus metrics of a user agent and its stack: the registrations, the
+// transactions by method and status class, the active sessions, the call setup latency and the
+// retransmissions by transport.
+//
+// The metrics depend on github.com/prometheus/client_golang, required by the go.mod of this package, a module
+// of its own so that the other packages of go-sip-ua do not depend on Prometheus. They are built with the
+// prometheus tag:
+//
+//	go build -tags prometheus github.com/sergeyu/go-sip-ua/pkg/metrics
+//
+// The metrics of a user agent, served by promhttp:
+//
+//	registry := prometheus.NewRegistry()
+//	if _, err := metrics.Register(registry, ua); err != nil {
+//		...
+//	}
+//	http.Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+package metrics
@@ -0,0 +1,13 @@
+module github.com/sergeyu/go-sip-ua/pkg/metrics
+
+go 1.13
+
+require (
+	github.com/ghettovoice/gosip v0.0.0-20210621140811-94442dfb3c1d
+	github.com/prometheus/client_golang v1.12.2
+	github.com/sergeyu/go-sip-ua v0.0.0-00010101000000-000000000000
+	golang.org/x/sys v0.7.0 // indirect
+	google.golang.org/protobuf v1.27.1 // indirect
+)
+
+replace github.com/sergeyu/go-sip-ua => ../..
@@ -0,0 +1,137 @@
+//go:build prometheus
+// +build prometheus
+
+package metrics
+
+import (
+	"fmt"
+
+	"github.com/ghettovoice/gosip/sip"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sergeyu/go-sip-ua/pkg/stack"
+	"github.com/sergeyu/go-sip-ua/pkg/ua"
+)
+
+const namespace = "sip"
+
+// CallSetupBuckets the buckets in seconds of the call setup latency, from the INVITE to its 2xx,
+// the time the callee takes to answer included.
+var CallSetupBuckets = []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 20, 30, 60}
+
+// Metrics the metrics of a user agent, a prometheus.Collector.
+type Metrics struct {
+	ua              *ua.UserAgent
+	registrations   *prometheus.CounterVec
+	transactions    *prometheus.CounterVec
+	callSetup       prometheus.Histogram
+	activeSessions  prometheus.GaugeFunc
+	retransmissions *prometheus.Desc
+}
+
+// Register registers the metrics of u into registerer, replacing the handler of OnTransactionEnd of its stack.
+func Register(registerer prometheus.Registerer, u *ua.UserAgent) (*Metrics, error) {
+	m := &Metrics{
+		ua: u,
+		registrations: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "registrations_total",
+			Help:      "REGISTER transactions sent, refreshes and unregistrations included, by result: success, challenged, failure or timeout.",
+		}, []string{"result"}),
+		transactions: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "transactions_total",
+			Help:      "Transactions ended by method, role (client or server) and status class of the final response, timeout without one.",
+		}, []string{"method", "role", "status_class"}),
+		callSetup: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "call_setup_seconds",
+			Help:      "Time from an initial INVITE sent to its 2xx.",
+			Buckets:   CallSetupBuckets,
+		}),
+		activeSessions: prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "active_sessions",
+			Help:      "Invite sessions in progress or established.",
+		}, func() float64 {
+			return float64(u.SessionCount())
+		}),
+		retransmissions: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "retransmissions_total"),
+			"Requests and responses sent again by the transactions, by transport.",
+			[]string{"transport"}, nil,
+		),
+	}
+	if err := registerer.Register(m); err != nil {
+		return nil, err
+	}
+	u.SipStack().OnTransactionEnd(m.ObserveTransaction)
+	return m, nil
+}
+
+// ObserveTransaction counts a transaction ended, to call from a handler of OnTransactionEnd replacing the one of Register.
+func (m *Metrics) ObserveTransaction(event stack.TransactionEvent) {
+	method := event.Request.Method()
+	role := "client"
+	if event.Server {
+		role = "server"
+	}
+	m.transactions.WithLabelValues(string(method), role, statusClass(event.Response)).Inc()
+	if event.Server {
+		return
+	}
+
+	switch method {
+	case sip.REGISTER:
+		m.registrations.WithLabelValues(registrationResult(event.Response)).Inc()
+	case sip.INVITE:
+		if event.Response != nil && event.Response.IsSuccess() && !inDialog(event.Request) {
+			m.callSetup.Observe(event.Duration.Seconds())
+		}
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (m *Metrics) Describe(ch chan<- *prometheus.Desc) {
+	m.registrations.Describe(ch)
+	m.transactions.Describe(ch)
+	m.callSetup.Describe(ch)
+	m.activeSessions.Describe(ch)
+	ch <- m.retransmissions
+}
+
+// Collect implements prometheus.Collector.
+func (m *Metrics) Collect(ch chan<- prometheus.Metric) {
+	m.registrations.Collect(ch)
+	m.transactions.Collect(ch)
+	m.callSetup.Collect(ch)
+	m.activeSessions.Collect(ch)
+	for network, stats := range m.ua.SipStack().Stats() {
+		ch <- prometheus.MustNewConstMetric(m.retransmissions, prometheus.CounterValue, float64(stats.Retransmissions), network)
+	}
+}
+
+// statusClass the class of a final response, e.g. 4xx, timeout without one.
+func statusClass(res sip.Response) string {
+	if res == nil {
+		return "timeout"
+	}
+	return fmt.Sprintf("%dxx", res.StatusCode()/100)
+}
+
+func registrationResult(res sip.Response) string {
+	switch {
+	case res == nil:
+		return "timeout"
+	case res.IsSuccess():
+		return "success"
+	case res.StatusCode() == 401 || res.StatusCode() == 407:
+		return "challenged"
+	}
+	return "failure"
+}
+
+// inDialog whether req is sent in a dialog, a re-INVITE.
+func inDialog(req sip.Request) bool {
+	to, ok := req.To()
+	return ok && to.Params != nil && to.Params.Has("tag")
+}
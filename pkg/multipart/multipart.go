@@ -0,0 +1,185 @@
+package multipart
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	stdmultipart "mime/multipart"
+	"net/textproto"
+	"strings"
+
+	"github.com/ghettovoice/gosip/sip"
+	"github.com/ghettovoice/gosip/util"
+)
+
+const (
+	// Mixed multipart/mixed content type (RFC 5621).
+	Mixed = "multipart/mixed"
+)
+
+// Part a body part with its own headers, e.g. application/sdp or application/isup.
+type Part struct {
+	ContentType string
+	Header      textproto.MIMEHeader
+	Body        string
+}
+
+// NewPart .
+func NewPart(contentType string, body string) Part {
+	return Part{
+		ContentType: contentType,
+		Header:      make(textproto.MIMEHeader),
+		Body:        body,
+	}
+}
+
+// Body a multipart message body.
+type Body struct {
+	Subtype  string
+	Boundary string
+	Parts    []Part
+}
+
+// New creates a multipart/mixed body.
+func New(parts ...Part) *Body {
+	return &Body{
+		Subtype:  "mixed",
+		Boundary: "unique-boundary-" + util.RandString(12),
+		Parts:    parts,
+	}
+}
+
+// Add appends a part.
+func (b *Body) Add(part Part) {
+	b.Parts = append(b.Parts, part)
+}
+
+// Part returns the first part of contentType, nil if not found.
+func (b *Body) Part(contentType string) *Part {
+	for i := range b.Parts {
+		if mediaType(b.Parts[i].ContentType) == strings.ToLower(contentType) {
+			return &b.Parts[i]
+		}
+	}
+	return nil
+}
+
+// ContentType the value of the Content-Type header with the boundary.
+func (b *Body) ContentType() string {
+	return fmt.Sprintf("multipart/%s;boundary=%s", b.Subtype, b.Boundary)
+}
+
+// String encodes the body.
+func (b *Body) String() string {
+	var sb strings.Builder
+	for _, part := range b.Parts {
+		sb.WriteString("--" + b.Boundary + "\r\n")
+		if len(part.ContentType) > 0 {
+			sb.WriteString("Content-Type: " + part.ContentType + "\r\n")
+		}
+		for name, values := range part.Header {
+			if strings.EqualFold(name, "Content-Type") {
+				continue
+			}
+			for _, value := range values {
+				sb.WriteString(name + ": " + value + "\r\n")
+			}
+		}
+		sb.WriteString("\r\n")
+		sb.WriteString(part.Body)
+		// The CRLF before the boundary belongs to the delimiter.
+		sb.WriteString("\r\n")
+	}
+	sb.WriteString("--" + b.Boundary + "--\r\n")
+	return sb.String()
+}
+
+// Parse parses a multipart body with the value of its Content-Type header.
+func Parse(contentType string, body string) (*Body, error) {
+	media, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(media, "multipart/") {
+		return nil, fmt.Errorf("not a multipart content type: %s", contentType)
+	}
+	boundary, ok := params["boundary"]
+	if !ok {
+		return nil, fmt.Errorf("missing boundary in %s", contentType)
+	}
+
+	b := &Body{
+		Subtype:  strings.TrimPrefix(media, "multipart/"),
+		Boundary: boundary,
+	}
+
+	reader := stdmultipart.NewReader(strings.NewReader(body), boundary)
+	for {
+		p, err := reader.NextPart()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, err
+		}
+		data, err := ioutil.ReadAll(p)
+		if err != nil {
+			return nil, err
+		}
+		b.Parts = append(b.Parts, Part{
+			ContentType: p.Header.Get("Content-Type"),
+			Header:      p.Header,
+			Body:        string(data),
+		})
+	}
+	return b, nil
+}
+
+// FromMessage parses the multipart body of a message.
+func FromMessage(msg sip.Message) (*Body, error) {
+	ct, ok := msg.ContentType()
+	if !ok {
+		return nil, fmt.Errorf("message has no Content-Type")
+	}
+	return Parse(ct.Value(), msg.Body())
+}
+
+// SetBody sets the multipart body and Content-Type of a message.
+func SetBody(msg sip.Message, b *Body) {
+	msg.RemoveHeader("Content-Type")
+	ct := sip.ContentType(b.ContentType())
+	msg.AppendHeader(&ct)
+	msg.SetBody(b.String(), true)
+}
+
+// IsMultipart reports whether the content type is multipart.
+func IsMultipart(contentType string) bool {
+	return strings.HasPrefix(mediaType(contentType), "multipart/")
+}
+
+// Extract returns the body of the contentType part of a message, the body itself if not multipart.
+func Extract(msg sip.Message, contentType string) (string, bool) {
+	ct, ok := msg.ContentType()
+	if !ok {
+		return "", false
+	}
+	if !IsMultipart(ct.Value()) {
+		if mediaType(ct.Value()) == strings.ToLower(contentType) {
+			return msg.Body(), true
+		}
+		return "", false
+	}
+	b, err := Parse(ct.Value(), msg.Body())
+	if err != nil {
+		return "", false
+	}
+	if part := b.Part(contentType); part != nil {
+		return part.Body, true
+	}
+	return "", false
+}
+
+func mediaType(contentType string) string {
+	return strings.ToLower(strings.TrimSpace(strings.Split(contentType, ";")[0]))
+}
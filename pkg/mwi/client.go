@@ -0,0 +1,88 @@
+package mwi
+
+import (
+	"fmt"
+
+	"github.com/ghettovoice/gosip/log"
+	"github.com/ghettovoice/gosip/sip"
+	"github.com/sergeyu/go-sip-ua/pkg/account"
+	"github.com/sergeyu/go-sip-ua/pkg/ua"
+	"github.com/sergeyu/go-sip-ua/pkg/utils"
+)
+
+const (
+	// Event message-summary event package (RFC 3842).
+	Event = "message-summary"
+)
+
+// Handler called with the new/old message counts of every NOTIFY.
+type Handler func(profile *account.Profile, summary *MessageSummary)
+
+// Client subscribes to the message summary of an account.
+type Client struct {
+	ua      *ua.UserAgent
+	profile *account.Profile
+	expires uint32
+	handler Handler
+	sub     *ua.Subscription
+	log     log.Logger
+}
+
+// NewClient .
+func NewClient(userAgent *ua.UserAgent, profile *account.Profile, expires uint32, handler Handler) *Client {
+	c := &Client{
+		ua:      userAgent,
+		profile: profile,
+		expires: expires,
+		handler: handler,
+	}
+	c.log = utils.NewLogger(log.DebugLevel, "MWI", nil)
+	return c
+}
+
+// Log .
+func (c *Client) Log() log.Logger {
+	return c.log
+}
+
+// Start subscribes to the message summary, recipient is the voicemail server or the account AOR.
+func (c *Client) Start(recipient sip.SipUri) error {
+	if c.sub != nil {
+		return fmt.Errorf("mwi subscription already started")
+	}
+	sub, err := c.ua.Subscribe(c.profile, c.profile.URI, recipient, Event, []string{ContentType}, c.expires, c.handleStatus, nil)
+	if err != nil {
+		return err
+	}
+	c.sub = sub
+	return nil
+}
+
+// Stop unsubscribes.
+func (c *Client) Stop() error {
+	if c.sub == nil {
+		return nil
+	}
+	sub := c.sub
+	c.sub = nil
+	return sub.Unsubscribe()
+}
+
+func (c *Client) handleStatus(status ua.SubscriptionStatus) {
+	if status.Request == nil || len(status.Body) == 0 {
+		return
+	}
+
+	summary, err := ParseMessageSummary(status.Body)
+	if err != nil {
+		c.Log().Warnf("invalid message summary: %v", err)
+		return
+	}
+
+	counts := summary.Voice()
+	c.Log().Debugf("MWI: waiting => %v, new => %d, old => %d", summary.MessagesWaiting, counts.New, counts.Old)
+
+	if c.handler != nil {
+		c.handler(c.profile, summary)
+	}
+}
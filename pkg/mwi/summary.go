@@ -0,0 +1,127 @@
+package mwi
+
+import (
+	"bufio"
+	"fmt"
+	"strings"
+)
+
+const (
+	// ContentType simple-message-summary (RFC 3842).
+	ContentType = "application/simple-message-summary"
+)
+
+// Counts new/old message counts, urgent counts are included in New/Old.
+type Counts struct {
+	New       int
+	Old       int
+	UrgentNew int
+	UrgentOld int
+}
+
+// MessageSummary parsed simple-message-summary body.
+type MessageSummary struct {
+	MessagesWaiting bool
+	Account         string
+	// Messages keyed by message context class, e.g. "voice-message".
+	Messages map[string]Counts
+	// Headers optional message headers following the summary.
+	Headers map[string]string
+}
+
+// Voice counts for the voice-message class.
+func (m *MessageSummary) Voice() Counts {
+	return m.Messages["voice-message"]
+}
+
+// Total counts of all message classes.
+func (m *MessageSummary) Total() Counts {
+	total := Counts{}
+	for _, counts := range m.Messages {
+		total.New += counts.New
+		total.Old += counts.Old
+		total.UrgentNew += counts.UrgentNew
+		total.UrgentOld += counts.UrgentOld
+	}
+	return total
+}
+
+// String formats the summary as a simple-message-summary body.
+func (m *MessageSummary) String() string {
+	var b strings.Builder
+	waiting := "no"
+	if m.MessagesWaiting {
+		waiting = "yes"
+	}
+	b.WriteString("Messages-Waiting: " + waiting + "\r\n")
+	if len(m.Account) > 0 {
+		b.WriteString("Message-Account: " + m.Account + "\r\n")
+	}
+	for class, counts := range m.Messages {
+		b.WriteString(fmt.Sprintf("%s: %d/%d (%d/%d)\r\n", headerCase(class), counts.New, counts.Old, counts.UrgentNew, counts.UrgentOld))
+	}
+	return b.String()
+}
+
+// ParseMessageSummary parses a simple-message-summary body.
+func ParseMessageSummary(body string) (*MessageSummary, error) {
+	summary := &MessageSummary{
+		Messages: make(map[string]Counts),
+		Headers:  make(map[string]string),
+	}
+
+	found := false
+	scanner := bufio.NewScanner(strings.NewReader(body))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 {
+			continue
+		}
+		kv := strings.SplitN(line, ":", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		name := strings.ToLower(strings.TrimSpace(kv[0]))
+		value := strings.TrimSpace(kv[1])
+
+		switch name {
+		case "messages-waiting":
+			found = true
+			summary.MessagesWaiting = strings.EqualFold(value, "yes")
+		case "message-account":
+			summary.Account = value
+		default:
+			counts, err := parseCounts(value)
+			if err != nil {
+				summary.Headers[kv[0]] = value
+				continue
+			}
+			summary.Messages[name] = counts
+		}
+	}
+
+	if !found {
+		return nil, fmt.Errorf("missing Messages-Waiting in message summary")
+	}
+	return summary, nil
+}
+
+// parseCounts parses "new/old (urgent-new/urgent-old)".
+func parseCounts(value string) (Counts, error) {
+	counts := Counts{}
+	n, err := fmt.Sscanf(value, "%d/%d (%d/%d)", &counts.New, &counts.Old, &counts.UrgentNew, &counts.UrgentOld)
+	if n >= 2 {
+		return counts, nil
+	}
+	return counts, err
+}
+
+func headerCase(name string) string {
+	parts := strings.Split(name, "-")
+	for i, part := range parts {
+		if len(part) > 0 {
+			parts[i] = strings.ToUpper(part[:1]) + part[1:]
+		}
+	}
+	return strings.Join(parts, "-")
+}
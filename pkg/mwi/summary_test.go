@@ -0,0 +1,108 @@
+package mwi_test
+
+import (
+	"testing"
+
+	"github.com/sergeyu/go-sip-ua/pkg/mwi"
+)
+
+func TestParseMessageSummary(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		waiting bool
+		account string
+		voice   mwi.Counts
+		total   mwi.Counts
+		headers map[string]string
+		err     bool
+	}{
+		{
+			name: "RFC 3842 example",
+			body: "Messages-Waiting: yes\r\n" +
+				"Message-Account: sip:alice@vmail.example.com\r\n" +
+				"Voice-Message: 4/8 (1/2)\r\n",
+			waiting: true,
+			account: "sip:alice@vmail.example.com",
+			voice:   mwi.Counts{New: 4, Old: 8, UrgentNew: 1, UrgentOld: 2},
+			total:   mwi.Counts{New: 4, Old: 8, UrgentNew: 1, UrgentOld: 2},
+		},
+		{
+			name:  "no messages waiting, urgent counts omitted",
+			body:  "messages-waiting: no\r\nvoice-message: 0/3\r\n",
+			voice: mwi.Counts{Old: 3},
+			total: mwi.Counts{Old: 3},
+		},
+		{
+			name: "several classes and headers",
+			body: "Messages-Waiting: yes\n" +
+				"Voice-Message: 2/0 (1/0)\n" +
+				"Fax-Message: 1/1\n" +
+				"\n" +
+				"To: <sip:alice@example.com>\n" +
+				"Subject: lunch\n",
+			waiting: true,
+			voice:   mwi.Counts{New: 2, UrgentNew: 1},
+			total:   mwi.Counts{New: 3, Old: 1, UrgentNew: 1},
+			headers: map[string]string{"To": "<sip:alice@example.com>", "Subject": "lunch"},
+		},
+		{
+			name: "missing Messages-Waiting",
+			body: "Voice-Message: 1/0\r\n",
+			err:  true,
+		},
+		{
+			name: "empty",
+			body: "",
+			err:  true,
+		},
+	}
+	for _, tt := range tests {
+		summary, err := mwi.ParseMessageSummary(tt.body)
+		if tt.err {
+			if err == nil {
+				t.Errorf("%s: ParseMessageSummary = %+v; want an error", tt.name, summary)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if summary.MessagesWaiting != tt.waiting {
+			t.Errorf("%s: MessagesWaiting = %v; want %v", tt.name, summary.MessagesWaiting, tt.waiting)
+		}
+		if summary.Account != tt.account {
+			t.Errorf("%s: Account = %q; want %q", tt.name, summary.Account, tt.account)
+		}
+		if got := summary.Voice(); got != tt.voice {
+			t.Errorf("%s: Voice = %+v; want %+v", tt.name, got, tt.voice)
+		}
+		if got := summary.Total(); got != tt.total {
+			t.Errorf("%s: Total = %+v; want %+v", tt.name, got, tt.total)
+		}
+		if len(summary.Headers) != len(tt.headers) {
+			t.Errorf("%s: Headers = %v; want %v", tt.name, summary.Headers, tt.headers)
+		}
+		for name, value := range tt.headers {
+			if summary.Headers[name] != value {
+				t.Errorf("%s: Headers[%s] = %q; want %q", tt.name, name, summary.Headers[name], value)
+			}
+		}
+	}
+}
+
+func TestMessageSummaryString(t *testing.T) {
+	summary := &mwi.MessageSummary{
+		MessagesWaiting: true,
+		Account:         "sip:alice@vmail.example.com",
+		Messages:        map[string]mwi.Counts{"voice-message": {New: 4, Old: 8, UrgentNew: 1, UrgentOld: 2}},
+	}
+	parsed, err := mwi.ParseMessageSummary(summary.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !parsed.MessagesWaiting || parsed.Account != summary.Account || parsed.Voice() != summary.Voice() {
+		t.Errorf("ParseMessageSummary(String()) = %+v; want %+v", parsed, summary)
+	}
+}
@@ -0,0 +1,160 @@
+package pcap
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+
+	"github.com/sergeyu/go-sip-ua/pkg/stack"
+)
+
+const (
+	// linkTypeRaw the packets begin with their IPv4 or IPv6 header.
+	linkTypeRaw = 101
+	snapLen     = 262144
+	// maxSegment the largest payload of a packet, a larger message is split in several TCP segments.
+	maxSegment = 65000
+)
+
+// Writer writes the messages of a stack as a pcap file Wireshark can analyze, each one in a UDP datagram
+// or TCP segments from its source to its destination. The IP, UDP and TCP headers are made up, the UDP
+// and TCP ones without checksum, and the TLS and WS messages are written unencrypted and unframed on TCP.
+type Writer struct {
+	mu sync.Mutex
+	w  io.Writer
+	// seq the next TCP sequence number of each direction of the connections.
+	seq map[string]uint32
+}
+
+// NewWriter a writer of a pcap file to w, its header written.
+func NewWriter(w io.Writer) (*Writer, error) {
+	header := make([]byte, 24)
+	binary.LittleEndian.PutUint32(header[0:], 0xa1b2c3d4)
+	binary.LittleEndian.PutUint16(header[4:], 2)
+	binary.LittleEndian.PutUint16(header[6:], 4)
+	binary.LittleEndian.PutUint32(header[16:], snapLen)
+	binary.LittleEndian.PutUint32(header[20:], linkTypeRaw)
+	if _, err := w.Write(header); err != nil {
+		return nil, err
+	}
+	return &Writer{w: w, seq: make(map[string]uint32)}, nil
+}
+
+// Tap writes the messages sent and received by s, replacing its handlers of OnMessageSent and OnMessageReceived.
+func (w *Writer) Tap(s *stack.SipStack) {
+	handler := func(msg stack.RawMessage) {
+		w.WriteMessage(msg)
+	}
+	s.OnMessageSent(handler)
+	s.OnMessageReceived(handler)
+}
+
+// WriteMessage writes msg in the packets of its transport.
+func (w *Writer) WriteMessage(msg stack.RawMessage) error {
+	src, srcPort := parseAddress(msg.Source)
+	dst, dstPort := parseAddress(msg.Destination)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if msg.Network == "UDP" {
+		return w.writePacket(msg, ipPacket(src, dst, 17, udpSegment(srcPort, dstPort, msg.Data)))
+	}
+	key := msg.Source + ">" + msg.Destination
+	for data := msg.Data; len(data) > 0; {
+		n := len(data)
+		if n > maxSegment {
+			n = maxSegment
+		}
+		seq := w.seq[key]
+		if seq == 0 {
+			seq = 1
+		}
+		w.seq[key] = seq + uint32(n)
+		if err := w.writePacket(msg, ipPacket(src, dst, 6, tcpSegment(srcPort, dstPort, seq, data[:n]))); err != nil {
+			return err
+		}
+		data = data[n:]
+	}
+	return nil
+}
+
+func (w *Writer) writePacket(msg stack.RawMessage, packet []byte) error {
+	record := make([]byte, 16, 16+len(packet))
+	binary.LittleEndian.PutUint32(record[0:], uint32(msg.Time.Unix()))
+	binary.LittleEndian.PutUint32(record[4:], uint32(msg.Time.Nanosecond()/1000))
+	binary.LittleEndian.PutUint32(record[8:], uint32(len(packet)))
+	binary.LittleEndian.PutUint32(record[12:], uint32(len(packet)))
+	_, err := w.w.Write(append(record, packet...))
+	return err
+}
+
+// parseAddress the IP and the port of a host:port, 0.0.0.0 for a host name or an empty address.
+func parseAddress(address string) (net.IP, uint16) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		host = address
+	}
+	n, _ := strconv.Atoi(port)
+	ip := net.ParseIP(host)
+	if ip == nil {
+		ip = net.IPv4zero
+	}
+	return ip, uint16(n)
+}
+
+// ipPacket an IPv4 packet of payload from src to dst, IPv6 unless both are IPv4 addresses.
+func ipPacket(src net.IP, dst net.IP, protocol byte, payload []byte) []byte {
+	if src4, dst4 := src.To4(), dst.To4(); src4 != nil && dst4 != nil {
+		header := make([]byte, 20)
+		header[0] = 0x45
+		binary.BigEndian.PutUint16(header[2:], uint16(len(header)+len(payload)))
+		header[8] = 64
+		header[9] = protocol
+		copy(header[12:], src4)
+		copy(header[16:], dst4)
+		binary.BigEndian.PutUint16(header[10:], checksum(header))
+		return append(header, payload...)
+	}
+	header := make([]byte, 40)
+	header[0] = 0x60
+	binary.BigEndian.PutUint16(header[4:], uint16(len(payload)))
+	header[6] = protocol
+	header[7] = 64
+	copy(header[8:], src.To16())
+	copy(header[24:], dst.To16())
+	return append(header, payload...)
+}
+
+func udpSegment(srcPort uint16, dstPort uint16, data []byte) []byte {
+	header := make([]byte, 8)
+	binary.BigEndian.PutUint16(header[0:], srcPort)
+	binary.BigEndian.PutUint16(header[2:], dstPort)
+	binary.BigEndian.PutUint16(header[4:], uint16(len(header)+len(data)))
+	return append(header, data...)
+}
+
+func tcpSegment(srcPort uint16, dstPort uint16, seq uint32, data []byte) []byte {
+	header := make([]byte, 20)
+	binary.BigEndian.PutUint16(header[0:], srcPort)
+	binary.BigEndian.PutUint16(header[2:], dstPort)
+	binary.BigEndian.PutUint32(header[4:], seq)
+	header[12] = 5 << 4
+	// PSH and ACK.
+	header[13] = 0x18
+	binary.BigEndian.PutUint16(header[14:], 65535)
+	return append(header, data...)
+}
+
+// checksum the Internet checksum of an IPv4 header (RFC 791).
+func checksum(header []byte) uint16 {
+	var sum uint32
+	for i := 0; i < len(header); i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(header[i:]))
+	}
+	for sum > 0xffff {
+		sum = sum&0xffff + sum>>16
+	}
+	return ^uint16(sum)
+}
@@ -0,0 +1,164 @@
+package presence
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/ghettovoice/gosip/log"
+	"github.com/ghettovoice/gosip/sip"
+	"github.com/sergeyu/go-sip-ua/pkg/account"
+	"github.com/sergeyu/go-sip-ua/pkg/ua"
+	"github.com/sergeyu/go-sip-ua/pkg/utils"
+)
+
+const (
+	// Event presence event package (RFC 3856).
+	Event = "presence"
+)
+
+// BuddyHandler called when a NOTIFY for a watched buddy arrives.
+type BuddyHandler func(buddy sip.SipUri, status Status, pidf *PIDF)
+
+// Client watches buddies and publishes the presence of an account.
+type Client struct {
+	ua      *ua.UserAgent
+	profile *account.Profile
+	expires uint32
+	handler BuddyHandler
+	watches map[string]*ua.Subscription
+	pub     *ua.Publication
+	mu      sync.Mutex
+	log     log.Logger
+}
+
+// NewClient .
+func NewClient(userAgent *ua.UserAgent, profile *account.Profile, expires uint32, handler BuddyHandler) *Client {
+	c := &Client{
+		ua:      userAgent,
+		profile: profile,
+		expires: expires,
+		handler: handler,
+		watches: make(map[string]*ua.Subscription),
+	}
+	c.log = utils.NewLogger(log.DebugLevel, "Presence", nil)
+	return c
+}
+
+// Log .
+func (c *Client) Log() log.Logger {
+	return c.log
+}
+
+// Watch subscribes to the presence of buddy.
+func (c *Client) Watch(buddy sip.SipUri) error {
+	key := buddy.String()
+
+	c.mu.Lock()
+	if _, found := c.watches[key]; found {
+		c.mu.Unlock()
+		return fmt.Errorf("buddy %s already watched", key)
+	}
+	c.mu.Unlock()
+
+	sub, err := c.ua.Subscribe(c.profile, &buddy, buddy, Event, []string{ContentType}, c.expires,
+		func(status ua.SubscriptionStatus) {
+			c.handleStatus(buddy, status)
+		}, nil)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	c.watches[key] = sub
+	c.mu.Unlock()
+	return nil
+}
+
+// Unwatch terminates the subscription to buddy.
+func (c *Client) Unwatch(buddy sip.SipUri) error {
+	key := buddy.String()
+
+	c.mu.Lock()
+	sub, found := c.watches[key]
+	delete(c.watches, key)
+	c.mu.Unlock()
+
+	if !found {
+		return fmt.Errorf("buddy %s not watched", key)
+	}
+	return sub.Unsubscribe()
+}
+
+func (c *Client) handleStatus(buddy sip.SipUri, status ua.SubscriptionStatus) {
+	if status.State == ua.SubscriptionTerminated {
+		c.mu.Lock()
+		delete(c.watches, buddy.String())
+		c.mu.Unlock()
+	}
+
+	if status.Request == nil || len(status.Body) == 0 {
+		return
+	}
+
+	pidf, err := ParsePIDF(status.Body)
+	if err != nil {
+		c.Log().Warnf("invalid pidf from %s: %v", buddy.String(), err)
+		return
+	}
+
+	if c.handler != nil {
+		c.handler(buddy, pidf.Status(), pidf)
+	}
+}
+
+// Publish publishes the presence status of the account.
+func (c *Client) Publish(status Status, note string) error {
+	c.mu.Lock()
+	pub := c.pub
+	c.mu.Unlock()
+
+	if status == Offline {
+		if pub == nil {
+			return nil
+		}
+		c.mu.Lock()
+		c.pub = nil
+		c.mu.Unlock()
+		return pub.Remove()
+	}
+
+	pidf := NewPIDF(c.profile.URI.String(), status, note)
+	if pub != nil {
+		return pub.Modify(ContentType, pidf.String())
+	}
+
+	recipient, ok := c.profile.URI.(*sip.SipUri)
+	if !ok {
+		return fmt.Errorf("account uri %s is not a sip uri", c.profile.URI)
+	}
+
+	pub, err := c.ua.Publish(c.profile, *recipient, Event, ContentType, pidf.String(), c.expires, nil)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	c.pub = pub
+	c.mu.Unlock()
+	return nil
+}
+
+// Online .
+func (c *Client) Online() error {
+	return c.Publish(Online, "Online")
+}
+
+// Busy .
+func (c *Client) Busy() error {
+	return c.Publish(Busy, "Busy")
+}
+
+// Offline .
+func (c *Client) Offline() error {
+	return c.Publish(Offline, "Offline")
+}
@@ -0,0 +1,144 @@
+package presence
+
+import (
+	"encoding/xml"
+	"strings"
+	"time"
+)
+
+const (
+	// ContentType pidf document (RFC 3863).
+	ContentType = "application/pidf+xml"
+
+	nsRPID = "urn:ietf:params:xml:ns:pidf:rpid"
+)
+
+// Status simplified presence status.
+type Status string
+
+const (
+	Unknown Status = "Unknown"
+	Online  Status = "Online"
+	Busy    Status = "Busy"
+	Offline Status = "Offline"
+)
+
+// PIDF presence document.
+type PIDF struct {
+	XMLName xml.Name `xml:"urn:ietf:params:xml:ns:pidf presence"`
+	Entity  string   `xml:"entity,attr"`
+	Tuples  []Tuple  `xml:"tuple"`
+	Persons []Person `xml:"urn:ietf:params:xml:ns:pidf:data-model person"`
+	Notes   []string `xml:"note,omitempty"`
+}
+
+// Tuple .
+type Tuple struct {
+	ID        string      `xml:"id,attr"`
+	Status    TupleStatus `xml:"status"`
+	Contact   string      `xml:"contact,omitempty"`
+	Notes     []string    `xml:"note,omitempty"`
+	Timestamp string      `xml:"timestamp,omitempty"`
+}
+
+// TupleStatus basic status, open or closed.
+type TupleStatus struct {
+	Basic string `xml:"basic"`
+}
+
+// Person RPID person element.
+type Person struct {
+	ID         string      `xml:"id,attr"`
+	Activities *Activities `xml:"urn:ietf:params:xml:ns:pidf:rpid activities,omitempty"`
+	Notes      []string    `xml:"urn:ietf:params:xml:ns:pidf:data-model note,omitempty"`
+}
+
+// Activities RPID activities, each activity is an empty element like <rpid:busy/>.
+type Activities struct {
+	Items []Activity `xml:",any"`
+}
+
+// Activity .
+type Activity struct {
+	XMLName xml.Name
+}
+
+// ParsePIDF parses a pidf+xml body.
+func ParsePIDF(body string) (*PIDF, error) {
+	pidf := &PIDF{}
+	if err := xml.Unmarshal([]byte(body), pidf); err != nil {
+		return nil, err
+	}
+	return pidf, nil
+}
+
+// NewPIDF builds a pidf document for the entity with a simplified status.
+func NewPIDF(entity string, status Status, note string) *PIDF {
+	basic := "open"
+	if status == Offline || status == Unknown {
+		basic = "closed"
+	}
+
+	tuple := Tuple{
+		ID:        "t1",
+		Status:    TupleStatus{Basic: basic},
+		Timestamp: time.Now().UTC().Format(time.RFC3339),
+	}
+	if len(note) > 0 {
+		tuple.Notes = []string{note}
+	}
+
+	pidf := &PIDF{
+		Entity: entity,
+		Tuples: []Tuple{tuple},
+	}
+
+	if status == Busy {
+		pidf.Persons = []Person{{
+			ID: "p1",
+			Activities: &Activities{
+				Items: []Activity{{XMLName: xml.Name{Space: nsRPID, Local: "busy"}}},
+			},
+		}}
+	}
+	return pidf
+}
+
+// String marshals the document.
+func (p *PIDF) String() string {
+	data, err := xml.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return ""
+	}
+	return xml.Header + string(data)
+}
+
+// Status derives a simplified status from the tuples and person activities.
+func (p *PIDF) Status() Status {
+	if len(p.Tuples) == 0 {
+		return Unknown
+	}
+
+	open := false
+	for _, tuple := range p.Tuples {
+		if strings.EqualFold(tuple.Status.Basic, "open") {
+			open = true
+		}
+	}
+	if !open {
+		return Offline
+	}
+
+	for _, person := range p.Persons {
+		if person.Activities == nil {
+			continue
+		}
+		for _, activity := range person.Activities.Items {
+			switch activity.XMLName.Local {
+			case "busy", "on-the-phone", "meeting", "away":
+				return Busy
+			}
+		}
+	}
+	return Online
+}
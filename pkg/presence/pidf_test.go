@@ -0,0 +1,41 @@
+package presence_test
+
+import (
+	"testing"
+
+	"github.com/sergeyu/go-sip-ua/pkg/presence"
+)
+
+func TestParsePIDF(t *testing.T) {
+	body := `<?xml version="1.0" encoding="UTF-8"?>
+<presence xmlns="urn:ietf:params:xml:ns:pidf"
+    xmlns:dm="urn:ietf:params:xml:ns:pidf:data-model"
+    xmlns:rpid="urn:ietf:params:xml:ns:pidf:rpid"
+    entity="sip:100@example.com">
+  <tuple id="a1"><status><basic>open</basic></status></tuple>
+  <dm:person id="p1"><rpid:activities><rpid:on-the-phone/></rpid:activities></dm:person>
+</presence>`
+
+	pidf, err := presence.ParsePIDF(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pidf.Entity != "sip:100@example.com" {
+		t.Errorf("Entity = %s; want sip:100@example.com", pidf.Entity)
+	}
+	if got := pidf.Status(); got != presence.Busy {
+		t.Errorf("Status = %v; want Busy", got)
+	}
+}
+
+func TestNewPIDF(t *testing.T) {
+	for _, status := range []presence.Status{presence.Online, presence.Busy, presence.Offline} {
+		pidf, err := presence.ParsePIDF(presence.NewPIDF("sip:100@example.com", status, "").String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := pidf.Status(); got != status {
+			t.Errorf("Status = %v; want %v", got, status)
+		}
+	}
+}
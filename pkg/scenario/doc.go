@@ -0,0 +1,39 @@
+// Package scenario plays scripted SIP scenarios against a user agent, like SIPp, to test the call logic of
+// an application against the responses and the misbehaviors of a peer: a Peer listening on UDP runs the
+// steps of a scenario in order, each one expecting a message or sending one.
+//
+// A registrar challenging the REGISTER once:
+//
+//	peer, err := scenario.NewPeer("127.0.0.1:0")
+//	if err != nil {
+//		...
+//	}
+//	defer peer.Close()
+//	errc := make(chan error, 1)
+//	go func() {
+//		errc <- peer.Run(ctx,
+//			scenario.Expect(sip.REGISTER),
+//			scenario.Challenge("example.com"),
+//			scenario.Expect(sip.REGISTER, scenario.HasHeader("Authorization")),
+//			scenario.Respond(200, "OK"),
+//		)
+//	}()
+//	// register the user agent at peer.URI("") ...
+//	if err := <-errc; err != nil {
+//		t.Fatal(err)
+//	}
+//
+// The retransmissions of the messages received by a step are skipped. Send writes a message from its text,
+// with the keywords of SIPp, e.g. to call the user agent:
+//
+//	scenario.Send(`INVITE sip:bob@[remote_ip]:[remote_port] SIP/2.0
+//	Via: SIP/2.0/[transport] [local_ip]:[local_port];branch=[branch]
+//	From: <sip:alice@[local_ip]:[local_port]>;tag=[tag]
+//	To: <sip:bob@[remote_ip]:[remote_port]>
+//	Call-ID: [call_id]
+//	CSeq: [cseq] INVITE
+//	Contact: <sip:alice@[local_ip]:[local_port]>
+//	Max-Forwards: 70
+//	Content-Length: [len]
+//	`)
+package scenario
@@ -0,0 +1,239 @@
+package scenario
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ghettovoice/gosip/log"
+	"github.com/ghettovoice/gosip/sip"
+	"github.com/ghettovoice/gosip/sip/parser"
+	"github.com/ghettovoice/gosip/util"
+	"github.com/sergeyu/go-sip-ua/pkg/utils"
+)
+
+// DefaultTimeout how long a step waits for a message if the Timeout of the peer is zero.
+const DefaultTimeout = 5 * time.Second
+
+// StepError a scenario failed at a step, numbered from 0.
+type StepError struct {
+	Step int
+	Err  error
+}
+
+func (e *StepError) Error() string {
+	return fmt.Sprintf("step %d: %v", e.Step, e.Err)
+}
+
+func (e *StepError) Unwrap() error {
+	return e.Err
+}
+
+type packet struct {
+	data []byte
+	from net.Addr
+}
+
+// Peer a SIP peer on UDP playing scenarios, see Run.
+type Peer struct {
+	// Timeout how long a step waits for a message, DefaultTimeout if zero.
+	Timeout time.Duration
+
+	conn    net.PacketConn
+	logger  log.Logger
+	packets chan packet
+	done    chan struct{}
+
+	mu       sync.Mutex
+	messages []sip.Message
+
+	// The state of the scenario played.
+	remote  net.Addr
+	request sip.Request
+	pending *packet
+	last    *packet
+	seen    map[string]bool
+	callID  string
+	tag     string
+	cseq    int
+}
+
+// NewPeer a peer listening on addr (host:port), e.g. 127.0.0.1:0 for a free port.
+func NewPeer(addr string) (*Peer, error) {
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	p := &Peer{
+		conn:    conn,
+		logger:  utils.NewLogger(log.ErrorLevel, "Scenario", nil),
+		packets: make(chan packet, 64),
+		done:    make(chan struct{}),
+	}
+	go p.serve()
+	return p, nil
+}
+
+// Addr the host:port the peer listens on.
+func (p *Peer) Addr() string {
+	return p.conn.LocalAddr().String()
+}
+
+// URI the SIP URI of user at the peer, e.g. the registrar or the target of a call.
+func (p *Peer) URI(user string) sip.SipUri {
+	addr := p.conn.LocalAddr().(*net.UDPAddr)
+	port := sip.Port(addr.Port)
+	uri := sip.SipUri{
+		FHost:      addr.IP.String(),
+		FPort:      &port,
+		FUriParams: sip.NewParams(),
+		FHeaders:   sip.NewParams(),
+	}
+	if user != "" {
+		uri.FUser = sip.String{Str: user}
+	}
+	return uri
+}
+
+// SetRemote sets the host:port the messages are sent to before any is received, e.g. of the user agent a
+// scenario calls. It is then the source of the last message received.
+func (p *Peer) SetRemote(addr string) error {
+	remote, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return err
+	}
+	p.remote = remote
+	return nil
+}
+
+// Messages the messages received by the scenarios, without the retransmissions.
+func (p *Peer) Messages() []sip.Message {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]sip.Message{}, p.messages...)
+}
+
+// Run plays the steps in order, a *StepError if one fails. A new Call-ID, tag and CSeq are drawn for the
+// messages sent by each run.
+func (p *Peer) Run(ctx context.Context, steps ...Step) error {
+	p.callID = util.RandString(32)
+	p.tag = util.RandString(8)
+	p.cseq = 0
+	p.seen = make(map[string]bool)
+	for i, step := range steps {
+		if err := step(ctx, p); err != nil {
+			return &StepError{Step: i, Err: err}
+		}
+	}
+	return nil
+}
+
+// Close stops the peer.
+func (p *Peer) Close() error {
+	select {
+	case <-p.done:
+		return nil
+	default:
+	}
+	close(p.done)
+	return p.conn.Close()
+}
+
+func (p *Peer) serve() {
+	buf := make([]byte, 65535)
+	for {
+		n, from, err := p.conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		data := append([]byte{}, buf[:n]...)
+		select {
+		case p.packets <- packet{data: data, from: from}:
+		case <-p.done:
+			return
+		}
+	}
+}
+
+// receive the next message not retransmitted, and its source the remote.
+func (p *Peer) receive(ctx context.Context) (sip.Message, error) {
+	timeout := p.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		var pkt packet
+		if p.pending != nil {
+			pkt, p.pending = *p.pending, nil
+		} else {
+			select {
+			case pkt = <-p.packets:
+			case <-timer.C:
+				return nil, fmt.Errorf("no message in %s", timeout)
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-p.done:
+				return nil, fmt.Errorf("peer closed")
+			}
+		}
+		// The keep-alives of RFC 5626.
+		if len(pkt.data) <= 4 {
+			continue
+		}
+		msg, err := parser.ParseMessage(pkt.data, p.logger)
+		if err != nil {
+			return nil, fmt.Errorf("malformed message from %s: %v", pkt.from, err)
+		}
+		key := transactionKey(msg)
+		if p.seen[key] {
+			continue
+		}
+		p.seen[key] = true
+		p.last = &pkt
+		p.remote = pkt.from
+		p.mu.Lock()
+		p.messages = append(p.messages, msg)
+		p.mu.Unlock()
+		return msg, nil
+	}
+}
+
+// unreceive puts msg back, the next message received.
+func (p *Peer) unreceive(msg sip.Message) {
+	delete(p.seen, transactionKey(msg))
+	p.pending = p.last
+	p.mu.Lock()
+	p.messages = p.messages[:len(p.messages)-1]
+	p.mu.Unlock()
+}
+
+// send data to the remote.
+func (p *Peer) send(data string) error {
+	if p.remote == nil {
+		return fmt.Errorf("no remote to send to, see SetRemote")
+	}
+	_, err := p.conn.WriteTo([]byte(data), p.remote)
+	return err
+}
+
+// transactionKey a key of msg the same for its retransmissions: its branch, CSeq and status code.
+func transactionKey(msg sip.Message) string {
+	key := ""
+	if via, ok := msg.ViaHop(); ok && via.Params != nil {
+		if branch, ok := via.Params.Get("branch"); ok && branch != nil {
+			key = branch.String()
+		}
+	}
+	if cseq, ok := msg.CSeq(); ok {
+		key += " " + cseq.String()
+	}
+	if res, ok := msg.(sip.Response); ok {
+		key += " " + strconv.Itoa(int(res.StatusCode()))
+	}
+	return key
+}
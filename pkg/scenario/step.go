@@ -0,0 +1,304 @@
+package scenario
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ghettovoice/gosip/sip"
+	"github.com/ghettovoice/gosip/util"
+)
+
+// Step a step of a scenario played by a peer.
+type Step func(ctx context.Context, p *Peer) error
+
+// Check checks a message expected, an error if it is not the one of the scenario.
+type Check func(msg sip.Message) error
+
+// Expect waits for a request of method, its checks passed, e.g. the REGISTER of a user agent.
+func Expect(method sip.RequestMethod, checks ...Check) Step {
+	return func(ctx context.Context, p *Peer) error {
+		msg, err := p.receive(ctx)
+		if err != nil {
+			return fmt.Errorf("expected %s: %v", method, err)
+		}
+		req, ok := msg.(sip.Request)
+		if !ok || req.Method() != method {
+			return fmt.Errorf("expected %s, received %s", method, startLine(msg))
+		}
+		p.request = req
+		return check(msg, checks)
+	}
+}
+
+// ExpectResponse waits for a response of code to the last request sent, its checks passed.
+func ExpectResponse(code sip.StatusCode, checks ...Check) Step {
+	return expectResponse(code, false, checks)
+}
+
+// ExpectOptional waits for a response of code the user agent may not send, e.g. a 100 or a 180: the step
+// passes if the next message is another one, left to the next step.
+func ExpectOptional(code sip.StatusCode, checks ...Check) Step {
+	return expectResponse(code, true, checks)
+}
+
+func expectResponse(code sip.StatusCode, optional bool, checks []Check) Step {
+	return func(ctx context.Context, p *Peer) error {
+		msg, err := p.receive(ctx)
+		if err != nil {
+			if optional {
+				return nil
+			}
+			return fmt.Errorf("expected %d: %v", code, err)
+		}
+		res, ok := msg.(sip.Response)
+		if !ok || res.StatusCode() != code {
+			if optional {
+				p.unreceive(msg)
+				return nil
+			}
+			return fmt.Errorf("expected %d, received %s", code, startLine(msg))
+		}
+		return check(msg, checks)
+	}
+}
+
+func check(msg sip.Message, checks []Check) error {
+	for _, check := range checks {
+		if err := check(msg); err != nil {
+			return fmt.Errorf("%s: %v", startLine(msg), err)
+		}
+	}
+	return nil
+}
+
+// startLine the first line of msg, e.g. SIP/2.0 180 Ringing.
+func startLine(msg sip.Message) string {
+	return strings.SplitN(msg.String(), "\r\n", 2)[0]
+}
+
+// HasHeader checks the message has a header of name, e.g. the Authorization of a request challenged.
+func HasHeader(name string) Check {
+	return func(msg sip.Message) error {
+		if len(msg.GetHeaders(name)) == 0 {
+			return fmt.Errorf("no %s header", name)
+		}
+		return nil
+	}
+}
+
+// HeaderContains checks a header of name of the message contains value.
+func HeaderContains(name string, value string) Check {
+	return func(msg sip.Message) error {
+		for _, header := range msg.GetHeaders(name) {
+			if strings.Contains(header.Value(), value) {
+				return nil
+			}
+		}
+		return fmt.Errorf("no %s header containing %q", name, value)
+	}
+}
+
+// BodyContains checks the body of the message contains value, e.g. a line of its SDP.
+func BodyContains(value string) Check {
+	return func(msg sip.Message) error {
+		if !strings.Contains(msg.Body(), value) {
+			return fmt.Errorf("body without %q", value)
+		}
+		return nil
+	}
+}
+
+// Respond responds to the last request received with code and the headers, with the tag of the peer in
+// the To but for a 100 and its Contact in a 2xx to an INVITE or a SUBSCRIBE.
+func Respond(code sip.StatusCode, reason string, headers ...sip.Header) Step {
+	return Reply(func(req sip.Request) sip.Response {
+		res := sip.NewResponseFromRequest("", req, code, reason, "")
+		for _, header := range headers {
+			res.AppendHeader(header)
+		}
+		return res
+	})
+}
+
+// Reply responds to the last request received with the response built by respond, its To tagged and its
+// Contact added as by Respond if it has none.
+func Reply(respond func(req sip.Request) sip.Response) Step {
+	return func(ctx context.Context, p *Peer) error {
+		if p.request == nil {
+			return fmt.Errorf("no request to respond to")
+		}
+		res := respond(p.request)
+		if to, ok := res.To(); ok && res.StatusCode() > 100 {
+			if to.Params == nil {
+				to.Params = sip.NewParams()
+			}
+			if !to.Params.Has("tag") {
+				to.Params.Add("tag", sip.String{Str: p.tag})
+			}
+		}
+		method := p.request.Method()
+		if res.IsSuccess() && (method == sip.INVITE || method == sip.SUBSCRIBE) && len(res.GetHeaders("Contact")) == 0 {
+			uri := p.URI("")
+			res.AppendHeader(&sip.ContactHeader{Address: &uri})
+		}
+		return p.send(res.String())
+	}
+}
+
+// Challenge responds to the last request received with a 401 challenging its digest authentication in
+// realm (RFC 3261 22.1), or a 407 as a proxy if it is not a REGISTER.
+func Challenge(realm string) Step {
+	return Reply(func(req sip.Request) sip.Response {
+		code, reason, name := sip.StatusCode(401), "Unauthorized", "WWW-Authenticate"
+		if req.Method() != sip.REGISTER {
+			code, reason, name = 407, "Proxy Authentication Required", "Proxy-Authenticate"
+		}
+		res := sip.NewResponseFromRequest("", req, code, reason, "")
+		res.AppendHeader(&sip.GenericHeader{
+			HeaderName: name,
+			Contents: fmt.Sprintf(`Digest realm="%s", nonce="%s", opaque="%s", algorithm=MD5, qop="auth"`,
+				realm, util.RandString(16), util.RandString(8)),
+		})
+		return res
+	})
+}
+
+// Send sends the message of text to the remote, its lines trimmed and ended by CRLF, and its keywords
+// replaced as by SIPp:
+//
+//	[local_ip] [local_port] [remote_ip] [remote_port] [transport]
+//	[call_id] [tag] the Call-ID and the tag of the peer for the run
+//	[branch] a new branch
+//	[cseq] the CSeq of the requests sent, incremented by each request but an ACK or a CANCEL
+//	[last_Name:] the Name headers of the last message received, e.g. [last_Via:]
+//	[len] the length of the body
+func Send(text string) Step {
+	return func(ctx context.Context, p *Peer) error {
+		data, err := p.expand(text)
+		if err != nil {
+			return err
+		}
+		return p.send(data)
+	}
+}
+
+// Pause waits for d, e.g. to let a transaction time out.
+func Pause(d time.Duration) Step {
+	return func(ctx context.Context, p *Peer) error {
+		select {
+		case <-time.After(d):
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// expand the keywords of text and its line ends, see Send.
+func (p *Peer) expand(text string) (string, error) {
+	lines := strings.Split(strings.TrimLeft(text, "\r\n"), "\n")
+	for i := range lines {
+		lines[i] = strings.TrimSpace(lines[i])
+	}
+	// The headers end at the first empty line, the body follows.
+	end := len(lines)
+	for i, line := range lines {
+		if line == "" {
+			end = i
+			break
+		}
+	}
+	body := ""
+	if end < len(lines) {
+		body = strings.TrimRight(strings.Join(lines[end+1:], "\r\n"), "\r\n")
+		if body != "" {
+			body += "\r\n"
+		}
+	}
+	// The ACK and the CANCEL have the CSeq of their INVITE.
+	if method := strings.Fields(lines[0]); len(method) > 0 && !strings.HasPrefix(method[0], "SIP/") &&
+		method[0] != string(sip.ACK) && method[0] != string(sip.CANCEL) {
+		p.cseq++
+	}
+
+	local := p.URI("")
+	remoteHost, remotePort := "", ""
+	if p.remote != nil {
+		remoteHost, remotePort, _ = net.SplitHostPort(p.remote.String())
+	}
+	keywords := map[string]string{
+		"local_ip":    local.FHost,
+		"local_port":  local.FPort.String(),
+		"remote_ip":   remoteHost,
+		"remote_port": remotePort,
+		"transport":   "UDP",
+		"call_id":     p.callID,
+		"tag":         p.tag,
+		"branch":      sip.GenerateBranch(),
+		"cseq":        strconv.Itoa(p.cseq),
+	}
+	body, err := p.expandLine(body, keywords)
+	if err != nil {
+		return "", err
+	}
+	keywords["len"] = strconv.Itoa(len(body))
+
+	var headers []string
+	for _, line := range lines[:end] {
+		expanded, err := p.expandLine(line, keywords)
+		if err != nil {
+			return "", err
+		}
+		if expanded != "" {
+			headers = append(headers, expanded)
+		}
+	}
+	return strings.Join(headers, "\r\n") + "\r\n\r\n" + body, nil
+}
+
+func (p *Peer) expandLine(line string, keywords map[string]string) (string, error) {
+	var b strings.Builder
+	for {
+		start := strings.IndexByte(line, '[')
+		if start < 0 {
+			b.WriteString(line)
+			return b.String(), nil
+		}
+		end := strings.IndexByte(line[start:], ']')
+		if end < 0 {
+			b.WriteString(line)
+			return b.String(), nil
+		}
+		keyword := line[start+1 : start+end]
+		b.WriteString(line[:start])
+		line = line[start+end+1:]
+
+		if value, ok := keywords[keyword]; ok {
+			b.WriteString(value)
+			continue
+		}
+		if strings.HasPrefix(keyword, "last_") && strings.HasSuffix(keyword, ":") {
+			name := strings.TrimSuffix(strings.TrimPrefix(keyword, "last_"), ":")
+			b.WriteString(p.lastHeaders(name))
+			continue
+		}
+		return "", fmt.Errorf("unknown keyword [%s]", keyword)
+	}
+}
+
+// lastHeaders the headers of name of the last message received, one per line.
+func (p *Peer) lastHeaders(name string) string {
+	messages := p.Messages()
+	if len(messages) == 0 {
+		return ""
+	}
+	var lines []string
+	for _, header := range messages[len(messages)-1].GetHeaders(name) {
+		lines = append(lines, header.String())
+	}
+	return strings.Join(lines, "\r\n")
+}
@@ -0,0 +1,76 @@
+package session
+
+import (
+	"strings"
+
+	"github.com/ghettovoice/gosip/sip"
+)
+
+// AnswerMode Answer-Mode / Priv-Answer-Mode values (RFC 5373).
+type AnswerMode string
+
+const (
+	AnswerModeNone   AnswerMode = ""
+	AnswerModeManual AnswerMode = "Manual"
+	AnswerModeAuto   AnswerMode = "Auto"
+)
+
+// ParseAnswerMode returns the answer mode requested by the INVITE, privileged is set for Priv-Answer-Mode
+// and require for the ;require parameter.
+func ParseAnswerMode(request sip.Request) (mode AnswerMode, privileged bool, require bool) {
+	for _, name := range []string{"Priv-Answer-Mode", "Answer-Mode"} {
+		hdrs := request.GetHeaders(name)
+		if len(hdrs) == 0 {
+			continue
+		}
+		parts := strings.Split(hdrs[0].Value(), ";")
+		switch strings.ToLower(strings.TrimSpace(parts[0])) {
+		case "auto":
+			mode = AnswerModeAuto
+		case "manual":
+			mode = AnswerModeManual
+		default:
+			continue
+		}
+		for _, param := range parts[1:] {
+			if strings.EqualFold(strings.TrimSpace(param), "require") {
+				require = true
+			}
+		}
+		return mode, name == "Priv-Answer-Mode", require
+	}
+	return AnswerModeNone, false, false
+}
+
+// IsAutoAnswerRequested reports whether the INVITE asks to be answered without user interaction,
+// by Answer-Mode: Auto or the Alert-Info/Call-Info hints used by intercom and paging systems.
+func IsAutoAnswerRequested(request sip.Request) bool {
+	if mode, _, _ := ParseAnswerMode(request); mode != AnswerModeNone {
+		return mode == AnswerModeAuto
+	}
+	for _, info := range ParseInfo(request, "Alert-Info") {
+		if strings.EqualFold(info.URI, "urn:alert:service:auto-answer") {
+			return true
+		}
+		switch strings.ToLower(info.Params["info"]) {
+		case "alert-autoanswer", "auto answer", "intercom":
+			return true
+		}
+	}
+	for _, info := range ParseInfo(request, "Call-Info") {
+		if value, ok := info.Params["answer-after"]; ok && value == "0" {
+			return true
+		}
+	}
+	return false
+}
+
+// SetAutoAnswer .
+func (s *Session) SetAutoAnswer(autoAnswer bool) {
+	s.autoAnswer = autoAnswer
+}
+
+// AutoAnswer reports whether the incoming INVITE should be answered automatically, as allowed by the UA policy.
+func (s *Session) AutoAnswer() bool {
+	return s.autoAnswer
+}
@@ -0,0 +1,160 @@
+package session
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/ghettovoice/gosip/sip"
+)
+
+// Cause normalized release cause of a session.
+type Cause string
+
+const (
+	CauseNormal         Cause = "Normal"
+	CauseBusy           Cause = "Busy"
+	CauseNoAnswer       Cause = "NoAnswer"
+	CauseRejected       Cause = "Rejected"
+	CauseCanceled       Cause = "Canceled"
+	CauseNotFound       Cause = "NotFound"
+	CauseIncompatible   Cause = "Incompatible"
+	CauseCongestion     Cause = "Congestion"
+	CauseNetworkFailure Cause = "NetworkFailure"
+	CauseAnsweredElse   Cause = "AnsweredElsewhere"
+	CauseUnknown        Cause = "Unknown"
+)
+
+// ReleaseCause why the session ended, from the final response and the Reason header (RFC 3326).
+type ReleaseCause struct {
+	Cause      Cause
+	StatusCode sip.StatusCode
+	// Q850 cause value of the Reason header, 0 if not present.
+	Q850 int
+	Text string
+}
+
+// CauseFromStatus maps a final SIP status code to a cause.
+func CauseFromStatus(code sip.StatusCode) Cause {
+	switch {
+	case code >= 200 && code < 300:
+		return CauseNormal
+	}
+	switch code {
+	case 486, 600:
+		return CauseBusy
+	case 408, 480:
+		return CauseNoAnswer
+	case 401, 403, 407, 603:
+		return CauseRejected
+	case 487:
+		return CauseCanceled
+	case 404, 410, 484, 604:
+		return CauseNotFound
+	case 415, 488, 606:
+		return CauseIncompatible
+	case 503:
+		return CauseCongestion
+	case 500, 502, 504, 513:
+		return CauseNetworkFailure
+	}
+	if code >= 400 && code < 700 {
+		return CauseRejected
+	}
+	return CauseUnknown
+}
+
+// CauseFromQ850 maps a Q.850 cause value to a cause.
+func CauseFromQ850(value int) Cause {
+	switch value {
+	case 16, 31:
+		return CauseNormal
+	case 17:
+		return CauseBusy
+	case 18, 19:
+		return CauseNoAnswer
+	case 21:
+		return CauseRejected
+	case 1, 2, 3, 22, 28:
+		return CauseNotFound
+	case 34, 42, 47:
+		return CauseCongestion
+	case 27, 38, 41, 102:
+		return CauseNetworkFailure
+	case 58, 65, 79, 88:
+		return CauseIncompatible
+	case 26:
+		return CauseAnsweredElse
+	}
+	return CauseUnknown
+}
+
+// ParseReason parses the Reason header of a message, protocol is SIP or Q.850.
+func ParseReason(msg sip.Message) (protocol string, cause int, text string, ok bool) {
+	for _, hdr := range msg.GetHeaders("Reason") {
+		parts := strings.Split(hdr.Value(), ";")
+		protocol = strings.TrimSpace(parts[0])
+		for _, part := range parts[1:] {
+			kv := strings.SplitN(strings.TrimSpace(part), "=", 2)
+			if len(kv) != 2 {
+				continue
+			}
+			switch strings.ToLower(kv[0]) {
+			case "cause":
+				cause, _ = strconv.Atoi(kv[1])
+			case "text":
+				text = strings.Trim(kv[1], "\"")
+			}
+		}
+		ok = true
+		if strings.EqualFold(protocol, "Q.850") {
+			return
+		}
+	}
+	return
+}
+
+// NewReleaseCause builds the release cause from the final response or the BYE/CANCEL, either may be nil.
+func NewReleaseCause(request sip.Request, response sip.Response) *ReleaseCause {
+	rc := &ReleaseCause{Cause: CauseNormal}
+	if response != nil {
+		rc.StatusCode = response.StatusCode()
+		rc.Cause = CauseFromStatus(response.StatusCode())
+		rc.Text = response.Reason()
+	} else if request != nil && request.IsCancel() {
+		rc.Cause = CauseCanceled
+	}
+
+	for _, msg := range []sip.Message{response, request} {
+		if msg == nil {
+			continue
+		}
+		protocol, cause, text, ok := ParseReason(msg)
+		if !ok {
+			continue
+		}
+		if strings.EqualFold(protocol, "Q.850") {
+			rc.Q850 = cause
+			if mapped := CauseFromQ850(cause); mapped != CauseUnknown {
+				rc.Cause = mapped
+			}
+		} else if strings.EqualFold(protocol, "SIP") && cause == 200 {
+			// Call completed elsewhere.
+			rc.Cause = CauseAnsweredElse
+		}
+		if len(text) > 0 {
+			rc.Text = text
+		}
+		break
+	}
+	return rc
+}
+
+// SetReleaseCause .
+func (s *Session) SetReleaseCause(rc *ReleaseCause) {
+	s.releaseCause = rc
+}
+
+// ReleaseCause why the session ended, nil while the session is not ended.
+func (s *Session) ReleaseCause() *ReleaseCause {
+	return s.releaseCause
+}
@@ -0,0 +1,75 @@
+package session
+
+import (
+	"github.com/ghettovoice/gosip/sip"
+)
+
+// DialogID identifies the dialog of a session (RFC 3261 12): its Call-ID and its local and remote tags,
+// the remote one empty until an outgoing INVITE is answered.
+type DialogID struct {
+	CallID    sip.CallID
+	LocalTag  string
+	RemoteTag string
+}
+
+// String .
+func (id DialogID) String() string {
+	return string(id.CallID) + ";local-tag=" + id.LocalTag + ";remote-tag=" + id.RemoteTag
+}
+
+// DialogID the dialog of the session.
+func (s *Session) DialogID() DialogID {
+	return DialogID{
+		CallID:    s.callID,
+		LocalTag:  addressTag(s.localURI),
+		RemoteTag: addressTag(s.remoteURI),
+	}
+}
+
+// MatchesDialog reports whether id identifies the dialog of the session, the empty tags of id matching any,
+// e.g. the local one of a CANCEL.
+func (s *Session) MatchesDialog(id DialogID) bool {
+	own := s.DialogID()
+	return id.CallID == own.CallID &&
+		(id.LocalTag == "" || id.LocalTag == own.LocalTag) &&
+		(id.RemoteTag == "" || id.RemoteTag == own.RemoteTag)
+}
+
+// IncomingDialogID the dialog of a request received, its local tag the one of the To.
+func IncomingDialogID(msg sip.Message) DialogID {
+	return messageDialogID(msg, true)
+}
+
+// OutgoingDialogID the dialog of a request sent or of its responses, its local tag the one of the From.
+func OutgoingDialogID(msg sip.Message) DialogID {
+	return messageDialogID(msg, false)
+}
+
+func messageDialogID(msg sip.Message, incoming bool) DialogID {
+	id := DialogID{}
+	if callID, ok := msg.CallID(); ok {
+		id.CallID = *callID
+	}
+	var fromTag, toTag string
+	if from, ok := msg.From(); ok {
+		fromTag = paramsTag(from.Params)
+	}
+	if to, ok := msg.To(); ok {
+		toTag = paramsTag(to.Params)
+	}
+	if incoming {
+		id.LocalTag, id.RemoteTag = toTag, fromTag
+	} else {
+		id.LocalTag, id.RemoteTag = fromTag, toTag
+	}
+	return id
+}
+
+func paramsTag(params sip.Params) string {
+	if params != nil {
+		if tag, ok := params.Get("tag"); ok && tag != nil {
+			return tag.String()
+		}
+	}
+	return ""
+}
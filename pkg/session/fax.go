@@ -0,0 +1,78 @@
+package session
+
+import (
+	"github.com/pixelbender/go-sdp/sdp"
+	"github.com/sergeyu/go-sip-ua/pkg/media"
+)
+
+// FaxMode the media of a session: audio, or a fax relayed in T.38 (ITU-T T.38 Annex D) after a re-INVITE
+// switched it to image/t38.
+type FaxMode int
+
+const (
+	AudioMode FaxMode = iota
+	T38Mode
+)
+
+func (m FaxMode) String() string {
+	if m == T38Mode {
+		return "T.38"
+	}
+	return "Audio"
+}
+
+// FaxMode the current mode of the media of the session, per the last answer.
+func (s *Session) FaxMode() FaxMode {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.faxMode
+}
+
+// OnFaxMode handles the transitions of the fax mode with the T.38 parameters negotiated, once an offer
+// switching the media to or from T.38 is answered, by the remote or by Accept.
+func (s *Session) OnFaxMode(handler func(mode FaxMode, params media.T38)) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.onFaxMode = handler
+}
+
+// ReInviteT38 sends a re-INVITE switching the media of the session to a T.38 fax relay received on port
+// of host, e.g. once a gateway detects the CNG tone of a fax.
+func (s *Session) ReInviteT38(host string, port int, params media.T38) {
+	s.ReInviteWithOffer(media.NewSDP(host, media.NewT38(port, params)).String())
+}
+
+// updateFaxMode the fax mode of the answer, the mode unchanged if it has neither T.38 nor audio.
+func (s *Session) updateFaxMode(answer string) {
+	description, err := parseSdp(answer)
+	if err != nil || description == nil {
+		return
+	}
+	mode := AudioMode
+	_, params, ok := media.FindT38(description)
+	if ok {
+		mode = T38Mode
+	} else if !hasAudio(description.Media) {
+		return
+	}
+	s.lock.Lock()
+	changed := mode != s.faxMode
+	s.faxMode = mode
+	handler := s.onFaxMode
+	s.lock.Unlock()
+	if changed {
+		s.Log().Infof("Fax mode: %v", mode)
+		if handler != nil {
+			handler(mode, params)
+		}
+	}
+}
+
+func hasAudio(descriptions []*sdp.Media) bool {
+	for _, m := range descriptions {
+		if m.Type == "audio" && m.Port != 0 {
+			return true
+		}
+	}
+	return false
+}
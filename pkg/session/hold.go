@@ -0,0 +1,47 @@
+package session
+
+import (
+	"fmt"
+
+	"github.com/pixelbender/go-sdp/sdp"
+	"github.com/sergeyu/go-sip-ua/pkg/media"
+)
+
+// Hold sends a re-INVITE putting the remote on hold (RFC 6337 5.3), the local description with its media
+// sendonly, or inactive if they were not sending.
+func (s *Session) Hold() error {
+	return s.reInviteWith(media.Hold)
+}
+
+// Unhold sends a re-INVITE resuming the media held by Hold.
+func (s *Session) Unhold() error {
+	return s.reInviteWith(media.Resume)
+}
+
+// reInviteWith sends a re-INVITE with the local description rewritten by rewrite, renumbered.
+func (s *Session) reInviteWith(rewrite func(session *sdp.Session)) error {
+	offer, err := s.rewriteLocalSdp(rewrite)
+	if err != nil {
+		return err
+	}
+	s.ReInviteWithOffer(offer)
+	return nil
+}
+
+// rewriteLocalSdp the local description rewritten by rewrite, renumbered.
+func (s *Session) rewriteLocalSdp(rewrite func(session *sdp.Session)) (string, error) {
+	local, err := s.ParseLocalSdp()
+	if err != nil {
+		return "", err
+	}
+	if local == nil {
+		return "", fmt.Errorf("no local sdp to re-INVITE with")
+	}
+	offer, err := media.Clone(local)
+	if err != nil {
+		return "", err
+	}
+	rewrite(offer)
+	media.Renumber(local, offer)
+	return offer.String(), nil
+}
@@ -0,0 +1,115 @@
+package session
+
+import (
+	"strings"
+
+	"github.com/ghettovoice/gosip/sip"
+)
+
+// InfoValue a value of the Alert-Info or Call-Info header, e.g. <urn:alert:service:auto-answer> or <http://x/a.png>;purpose=icon.
+type InfoValue struct {
+	URI    string
+	Params map[string]string
+}
+
+// String .
+func (v InfoValue) String() string {
+	var b strings.Builder
+	b.WriteString("<" + v.URI + ">")
+	for key, value := range v.Params {
+		b.WriteString(";" + key)
+		if len(value) > 0 {
+			b.WriteString("=" + value)
+		}
+	}
+	return b.String()
+}
+
+// NewAlertInfo builds an Alert-Info header for distinctive ring or auto-answer.
+func NewAlertInfo(values ...InfoValue) sip.Header {
+	return newInfoHeader("Alert-Info", values)
+}
+
+// NewCallInfo builds a Call-Info header.
+func NewCallInfo(values ...InfoValue) sip.Header {
+	return newInfoHeader("Call-Info", values)
+}
+
+// ParseInfo parses the Alert-Info or Call-Info headers of a message.
+func ParseInfo(msg sip.Message, name string) []InfoValue {
+	values := make([]InfoValue, 0)
+	for _, hdr := range msg.GetHeaders(name) {
+		for _, part := range splitInfo(hdr.Value()) {
+			start := strings.Index(part, "<")
+			end := strings.Index(part, ">")
+			if start < 0 || end < start {
+				continue
+			}
+			value := InfoValue{
+				URI:    part[start+1 : end],
+				Params: make(map[string]string),
+			}
+			for _, param := range strings.Split(part[end+1:], ";") {
+				kv := strings.SplitN(strings.TrimSpace(param), "=", 2)
+				if len(kv[0]) == 0 {
+					continue
+				}
+				if len(kv) == 2 {
+					value.Params[strings.ToLower(kv[0])] = strings.Trim(kv[1], "\"")
+				} else {
+					value.Params[strings.ToLower(kv[0])] = ""
+				}
+			}
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// AlertInfo the Alert-Info of the INVITE, or of the last response for outgoing sessions.
+func (s *Session) AlertInfo() []InfoValue {
+	return s.info("Alert-Info")
+}
+
+// CallInfo the Call-Info of the INVITE, or of the last response for outgoing sessions.
+func (s *Session) CallInfo() []InfoValue {
+	return s.info("Call-Info")
+}
+
+func (s *Session) info(name string) []InfoValue {
+	if s.uaType == "UAC" && s.response != nil {
+		return ParseInfo(s.response, name)
+	}
+	if s.request != nil {
+		return ParseInfo(s.request, name)
+	}
+	return nil
+}
+
+func newInfoHeader(name string, values []InfoValue) sip.Header {
+	parts := make([]string, 0, len(values))
+	for _, value := range values {
+		parts = append(parts, value.String())
+	}
+	return &sip.GenericHeader{HeaderName: name, Contents: strings.Join(parts, ", ")}
+}
+
+// splitInfo splits the comma separated values, ignoring commas inside <>.
+func splitInfo(value string) []string {
+	parts := make([]string, 0)
+	depth, start := 0, 0
+	for i, c := range value {
+		switch c {
+		case '<':
+			depth++
+		case '>':
+			depth--
+		case ',':
+			if depth == 0 {
+				parts = append(parts, strings.TrimSpace(value[start:i]))
+				start = i + 1
+			}
+		}
+	}
+	return append(parts, strings.TrimSpace(value[start:]))
+}
@@ -0,0 +1,70 @@
+package session
+
+import (
+	"fmt"
+
+	"github.com/ghettovoice/gosip/sip"
+	"github.com/pixelbender/go-sdp/sdp"
+	"github.com/sergeyu/go-sip-ua/pkg/media"
+)
+
+// LocalTarget the Contact the remote sends the requests of the dialog to, the one of the INVITE or of its
+// 2xx until the session is moved.
+func (s *Session) LocalTarget() *sip.ContactHeader {
+	if target := s.movedTarget(); target != nil {
+		return target
+	}
+	var msg sip.Message = s.request
+	if s.uaType == "UAS" {
+		msg = s.response
+	}
+	if msg == nil {
+		return nil
+	}
+	contact, _ := msg.Contact()
+	return contact
+}
+
+// Move moves the session to a new local address, e.g. after a network change: a re-INVITE is sent with
+// contact as the new local target of the dialog (RFC 3261 12.2.1.1) and the local description with its
+// connection addresses set to host, if not empty. The media streams have to receive on host already.
+// The local target is the new one once the re-INVITE succeeded only.
+func (s *Session) Move(contact *sip.ContactHeader, host string) error {
+	offer, err := s.rewriteLocalSdp(func(session *sdp.Session) {
+		if host != "" {
+			media.SetAddress(session, host)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	req := s.reInviteRequest(offer)
+	req.RemoveHeader("Contact")
+	req.AppendHeader(contact.Clone())
+	response, err := s.sendRequest(req)
+	if err != nil {
+		return err
+	}
+	if response == nil || !response.IsSuccess() {
+		return fmt.Errorf("re-INVITE to move the session failed")
+	}
+	s.lock.Lock()
+	s.localTarget = contact
+	s.lock.Unlock()
+	return nil
+}
+
+// movedTarget the local target set by Move, nil until the session is moved.
+func (s *Session) movedTarget() *sip.ContactHeader {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.localTarget
+}
+
+// responseContact the Contact of the 2xx to an INVITE, the local target once moved.
+func (s *Session) responseContact() *sip.ContactHeader {
+	if target := s.movedTarget(); target != nil {
+		return target.Clone().(*sip.ContactHeader)
+	}
+	return s.localURI.AsContactHeader()
+}
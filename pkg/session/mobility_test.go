@@ -0,0 +1,62 @@
+package session_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ghettovoice/gosip/log"
+	"github.com/ghettovoice/gosip/sip"
+	"github.com/ghettovoice/gosip/sip/parser"
+	"github.com/sergeyu/go-sip-ua/pkg/auth"
+	"github.com/sergeyu/go-sip-ua/pkg/media"
+	"github.com/sergeyu/go-sip-ua/pkg/session"
+	"github.com/sergeyu/go-sip-ua/pkg/utils"
+)
+
+// TestMove the local target of alice moved by a re-INVITE with the new Contact, kept until it is answered
+// with a 2xx.
+func TestMove(t *testing.T) {
+	logger := utils.NewLogger(log.ErrorLevel, "test", nil)
+	msg, err := parser.ParseMessage([]byte(invite), logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := msg.(sip.Request)
+	callID, _ := req.CallID()
+	contact, _ := req.Contact()
+
+	var sent sip.Request
+	statusCode := sip.StatusCode(488)
+	reqcb := func(ctx context.Context, request sip.Request, authorizer auth.Authorizer, waitForResult bool, attempt int) (sip.Response, error) {
+		sent = request
+		if statusCode >= 300 {
+			return nil, sip.NewRequestError(uint(statusCode), "", request, nil)
+		}
+		return sip.NewResponseFromRequest("", request, statusCode, "OK", ""), nil
+	}
+	alice := session.NewInviteSession(reqcb, "UAC", contact, req, *callID, nil, session.Outgoing, logger)
+	alice.ProvideOffer(media.NewSDP("192.0.2.1", media.NewAudio(4000, media.PCMU)).String())
+
+	moved := &sip.ContactHeader{Address: &sip.SipUri{FUser: sip.String{Str: "alice"}, FHost: "192.0.2.10"}}
+	if err := alice.Move(moved, "192.0.2.10"); err == nil {
+		t.Fatal("Move answered with 488 succeeded")
+	}
+	if got, ok := sent.Contact(); !ok || got.Address.Host() != "192.0.2.10" {
+		t.Errorf("Contact of the re-INVITE = %v; want sip:alice@192.0.2.10", got)
+	}
+	if got := alice.LocalTarget().Address.Host(); got != "192.0.2.1" {
+		t.Errorf("LocalTarget host after a failed Move = %s; want 192.0.2.1", got)
+	}
+
+	statusCode = 200
+	if err := alice.Move(moved, "192.0.2.10"); err != nil {
+		t.Fatalf("Move: %v", err)
+	}
+	if got := alice.LocalTarget().Address.Host(); got != "192.0.2.10" {
+		t.Errorf("LocalTarget host = %s; want 192.0.2.10", got)
+	}
+	alice.Bye()
+	if got, ok := sent.Contact(); !ok || got.Address.Host() != "192.0.2.10" {
+		t.Errorf("Contact of the BYE = %v; want sip:alice@192.0.2.10", got)
+	}
+}
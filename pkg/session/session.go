@@ -4,14 +4,22 @@ import (
 	"context"
 	"fmt"
 	"sync"
+	"time"
 
 	"github.com/ghettovoice/gosip/log"
 	"github.com/ghettovoice/gosip/sip"
 	"github.com/ghettovoice/gosip/util"
+	"github.com/pixelbender/go-sdp/sdp"
+	"github.com/sergeyu/go-sip-ua/pkg/auth"
+	"github.com/sergeyu/go-sip-ua/pkg/clock"
+	"github.com/sergeyu/go-sip-ua/pkg/identity"
+	"github.com/sergeyu/go-sip-ua/pkg/media"
+	"github.com/sergeyu/go-sip-ua/pkg/media/rtp"
+	"github.com/sergeyu/go-sip-ua/pkg/multipart"
 	"github.com/sergeyu/go-sip-ua/pkg/utils"
 )
 
-type RequestCallback func(ctx context.Context, request sip.Request, authorizer sip.Authorizer, waitForResult bool, attempt int) (sip.Response, error)
+type RequestCallback func(ctx context.Context, request sip.Request, authorizer auth.Authorizer, waitForResult bool, attempt int) (sip.Response, error)
 
 type Session struct {
 	lock           sync.Mutex
@@ -29,6 +37,19 @@ type Session struct {
 	localURI       sip.Address
 	remoteURI      sip.Address
 	remoteTarget   sip.Uri
+	localTarget    *sip.ContactHeader
+	verification   *identity.Verification
+	autoAnswer     bool
+	releaseCause   *ReleaseCause
+	streams        []*rtp.Stream
+	onDTMF         func(event rtp.DTMFEvent)
+	onState        func(status Status, response sip.Response)
+	handler        Handler
+	mediaStatsStop chan struct{}
+	faxMode        FaxMode
+	onFaxMode      func(mode FaxMode, params media.T38)
+	maxForwards    int
+	clock          clock.Clock
 	logger         log.Logger
 }
 
@@ -44,9 +65,14 @@ func NewInviteSession(reqcb RequestCallback, uaType string,
 		offer:          "",
 		answer:         "",
 		contact:        contact,
+		maxForwards:    70,
+		clock:          clock.Real,
 	}
 
-	s.logger = utils.NewLogrusLogger(log.DebugLevel, "Session", nil)
+	if logger == nil {
+		logger = utils.NewLogger(log.DebugLevel, "Session", nil)
+	}
+	s.logger = logger.WithPrefix("Session").WithFields(utils.RequestFields(req, dir == Incoming))
 
 	to, _ := req.To()
 	from, _ := req.From()
@@ -61,18 +87,27 @@ func NewInviteSession(reqcb RequestCallback, uaType string,
 		s.localURI = sip.Address{Uri: to.Address, Params: to.Params}
 		s.remoteURI = sip.Address{Uri: from.Address, Params: from.Params}
 		s.remoteTarget = contact.Address
-		s.offer = req.Body()
+		s.offer = sdpOf(req)
 	} else if uaType == "UAC" {
 		s.localURI = sip.Address{Uri: from.Address, Params: from.Params}
 		s.remoteURI = sip.Address{Uri: to.Address, Params: to.Params}
 		s.remoteTarget = req.Recipient()
-		s.offer = req.Body()
+		s.offer = sdpOf(req)
 	}
 
 	s.request = req
 	return s
 }
 
+// sdpOf returns the SDP of the message body, which may be multipart.
+func sdpOf(msg sip.Message) string {
+	if ct, ok := msg.ContentType(); ok && multipart.IsMultipart(ct.Value()) {
+		sdp, _ := multipart.Extract(msg, "application/sdp")
+		return sdp
+	}
+	return msg.Body()
+}
+
 func (s *Session) Log() log.Logger {
 	return s.logger
 }
@@ -95,10 +130,74 @@ func (s *Session) RemoteSdp() string {
 	return s.answer
 }
 
+// ParseLocalSdp the local session description, nil if there is none yet.
+func (s *Session) ParseLocalSdp() (*sdp.Session, error) {
+	return parseSdp(s.LocalSdp())
+}
+
+// ParseRemoteSdp the remote session description, nil if there is none yet,
+// e.g. the offer to answer with media.Answer.
+func (s *Session) ParseRemoteSdp() (*sdp.Session, error) {
+	return parseSdp(s.RemoteSdp())
+}
+
+// SRTP the SRTP keys negotiated by the local and remote descriptions, none before the answer.
+// It fails if a media in RTP/SAVP was answered without a crypto in common.
+func (s *Session) SRTP() ([]media.SRTP, error) {
+	local, err := s.ParseLocalSdp()
+	if err != nil || local == nil {
+		return nil, err
+	}
+	remote, err := s.ParseRemoteSdp()
+	if err != nil || remote == nil {
+		return nil, err
+	}
+	return media.NegotiatedSRTP(local, remote)
+}
+
+func parseSdp(body string) (*sdp.Session, error) {
+	if body == "" {
+		return nil, nil
+	}
+	return sdp.ParseString(body)
+}
+
 func (s *Session) Contact() string {
 	return s.contact.String()
 }
 
+func (s *Session) LocalURI() sip.Address {
+	return s.localURI
+}
+
+func (s *Session) RemoteURI() sip.Address {
+	return s.remoteURI
+}
+
+func (s *Session) RemoteTarget() sip.Uri {
+	return s.remoteTarget
+}
+
+// SetVerification stores the STIR/SHAKEN verification result of the INVITE.
+func (s *Session) SetVerification(v *identity.Verification) {
+	s.verification = v
+}
+
+// SetMaxForwards sets the Max-Forwards of the requests of the session, 70 by default.
+func (s *Session) SetMaxForwards(maxForwards int) {
+	s.maxForwards = maxForwards
+}
+
+// SetClock sets the clock of the timers of the session, clock.Real by default.
+func (s *Session) SetClock(c clock.Clock) {
+	s.clock = clock.Or(c)
+}
+
+// Verification the STIR/SHAKEN verification result, nil if not verified.
+func (s *Session) Verification() *identity.Verification {
+	return s.verification
+}
+
 func (s *Session) CallID() *sip.CallID {
 	return &s.callID
 }
@@ -166,12 +265,22 @@ func (s *Session) StoreResponse(response sip.Response) {
 			s.remoteURI = sip.Address{Uri: to.Address, Params: to.Params}
 		}
 
-		sdp := response.Body()
+		sdp := sdpOf(response)
 		if len(sdp) > 0 {
 			s.answer = sdp
 		}
+	} else if s.uaType == "UAS" {
+		// Answer for a re-INVITE sent by the UAS side.
+		if cseq, ok := response.CSeq(); ok && cseq.MethodName == sip.INVITE {
+			if sdp := response.Body(); len(sdp) > 0 {
+				s.offer = sdp
+			}
+		}
 	}
 	s.response = response
+	if cseq, ok := response.CSeq(); ok && cseq.MethodName == sip.INVITE && response.IsSuccess() {
+		s.updateFaxMode(sdpOf(response))
+	}
 }
 
 func (s *Session) StoreTransaction(tx sip.Transaction) {
@@ -185,6 +294,140 @@ func (s *Session) SetState(status Status) {
 	s.lock.Lock()
 	defer s.lock.Unlock()
 	s.status = status
+	if s.IsEnded() {
+		for _, stream := range s.streams {
+			stream.Close()
+		}
+		if s.mediaStatsStop != nil {
+			close(s.mediaStatsStop)
+			s.mediaStatsStop = nil
+		}
+	}
+}
+
+// AddStream ties the lifetime of stream to the session, it is closed when the session ends.
+func (s *Session) AddStream(stream *rtp.Stream) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.streams = append(s.streams, stream)
+}
+
+// Streams the media streams of the session.
+func (s *Session) Streams() []*rtp.Stream {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]*rtp.Stream{}, s.streams...)
+}
+
+// MediaStats the call quality of the media streams of the session: their jitter, loss, round-trip time
+// and MOS, in the order they were added.
+func (s *Session) MediaStats() []rtp.Quality {
+	streams := s.Streams()
+	stats := make([]rtp.Quality, 0, len(streams))
+	for _, stream := range streams {
+		stats = append(stats, stream.Quality())
+	}
+	return stats
+}
+
+// OnMediaStats handles the MediaStats of the session every interval until it ends, e.g. to monitor the
+// quality of the calls. A handler replaces the previous one, nil stops them.
+func (s *Session) OnMediaStats(interval time.Duration, handler func(stats []rtp.Quality)) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.mediaStatsStop != nil {
+		close(s.mediaStatsStop)
+		s.mediaStatsStop = nil
+	}
+	if handler == nil || interval <= 0 || s.IsEnded() {
+		return
+	}
+	stop := make(chan struct{})
+	s.mediaStatsStop = stop
+	go func() {
+		ticker := s.clock.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C():
+				handler(s.MediaStats())
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// OnState handles the states of the session and the responses they were reached by, e.g. the ringing, the
+// answer or the failure of an outgoing call once Invite returns it. The handler is called at once with the
+// current state, the ones reached before it is set are not missed.
+func (s *Session) OnState(handler func(status Status, response sip.Response)) {
+	s.lock.Lock()
+	s.onState = handler
+	status := s.status
+	response := s.response
+	s.lock.Unlock()
+	if handler != nil {
+		handler(status, response)
+	}
+}
+
+// DispatchState calls the handler of OnState with status reached by response, nil if by a request.
+func (s *Session) DispatchState(status Status, response sip.Response) {
+	s.lock.Lock()
+	handler := s.onState
+	s.lock.Unlock()
+	if handler != nil {
+		handler(status, response)
+	}
+}
+
+// Handler the handler owning a session, see SetHandler.
+type Handler func(s *Session, req *sip.Request, resp *sip.Response, status Status)
+
+// SetHandler makes handler the owner of the session, e.g. a B2BUA or a transfer: the states reached from
+// now on are passed to it instead of the InviteStateHandler of the UA, OnState still called. A nil handler
+// gives the session back to the InviteStateHandler.
+func (s *Session) SetHandler(handler Handler) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.handler = handler
+}
+
+// Handler the handler of SetHandler, nil if none.
+func (s *Session) Handler() Handler {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.handler
+}
+
+// OnDTMF handles the digits received in telephone-event packets (RFC 4733) by the streams of ReceiveDTMF.
+func (s *Session) OnDTMF(handler func(event rtp.DTMFEvent)) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.onDTMF = handler
+}
+
+// ReceiveDTMF the telephone-events of payloadType received by stream, e.g. the one of the answer.
+func (s *Session) ReceiveDTMF(stream *rtp.Stream, payloadType uint8) {
+	stream.OnDTMF(payloadType, func(event rtp.DTMFEvent) {
+		s.lock.Lock()
+		handler := s.onDTMF
+		s.lock.Unlock()
+		if handler != nil {
+			handler(event)
+		}
+	})
+}
+
+// SendDTMF sends digit in telephone-event packets of payloadType on the first stream of the session,
+// e.g. instead of Info with application/dtmf-relay when the remote supports telephone-event.
+func (s *Session) SendDTMF(digit rune, duration time.Duration, payloadType uint8) error {
+	streams := s.Streams()
+	if len(streams) == 0 {
+		return fmt.Errorf("no media stream to send DTMF")
+	}
+	return streams[0].SendDTMF(payloadType, digit, duration)
 }
 
 func (s *Session) Status() Status {
@@ -232,6 +475,26 @@ func (s *Session) ReInvite() {
 	s.sendRequest(req)
 }
 
+// ReInviteWithOffer send re-INVITE with a new local sdp.
+func (s *Session) ReInviteWithOffer(sdp string) {
+	s.sendRequest(s.reInviteRequest(sdp))
+}
+
+// reInviteRequest a re-INVITE with sdp, kept as the new local sdp.
+func (s *Session) reInviteRequest(sdp string) sip.Request {
+	if s.uaType == "UAC" {
+		s.offer = sdp
+	} else {
+		s.answer = sdp
+	}
+	method := sip.INVITE
+	req := s.makeRequest(s.uaType, method, sip.MessageID(s.callID), s.request, s.response)
+	req.SetBody(sdp, true)
+	hdr := sip.ContentType("application/sdp")
+	req.AppendHeader(&hdr)
+	return req
+}
+
 //Bye send Bye request.
 func (s *Session) Bye() {
 	method := sip.BYE
@@ -316,11 +579,12 @@ func (s *Session) Accept(statusCode sip.StatusCode) {
 		sip.CopyHeaders("Content-Type", request, response)
 	}
 
-	response.AppendHeader(s.localURI.AsContactHeader())
+	response.AppendHeader(s.responseContact())
 	response.SetBody(s.answer, true)
 
 	s.response = response
 	tx.Respond(response)
+	s.updateFaxMode(s.answer)
 
 	s.SetState(WaitingForACK)
 }
@@ -330,8 +594,8 @@ func (s *Session) Redirect(target string, code sip.StatusCode) {
 
 }
 
-// Provisional send a provisional code 100|180|183
-func (s *Session) Provisional(statusCode sip.StatusCode, reason string) {
+// Provisional send a provisional code 100|180|183, headers such as Alert-Info are appended to it.
+func (s *Session) Provisional(statusCode sip.StatusCode, reason string, headers ...sip.Header) {
 	tx := (s.transaction.(sip.ServerTransaction))
 	request := s.request
 	var response sip.Response
@@ -349,6 +613,9 @@ func (s *Session) Provisional(statusCode sip.StatusCode, reason string) {
 		response = sip.NewResponseFromRequest(request.MessageID(), request, statusCode, reason, "")
 	}
 	response.AppendHeader(s.localURI.AsContactHeader())
+	for _, header := range headers {
+		response.AppendHeader(header)
+	}
 	s.response = response
 	tx.Respond(response)
 }
@@ -372,10 +639,23 @@ func (s *Session) makeRequest(uaType string, method sip.RequestMethod, msgID sip
 	to := s.remoteURI.Clone().AsToHeader()
 	newRequest.AppendHeader(to)
 	newRequest.SetRecipient(s.request.Recipient())
-	sip.CopyHeaders("Via", inviteRequest, newRequest)
+	// A Via of its own, its branch and sent-by set by the stack: with the one of the INVITE, a re-INVITE
+	// would be taken for a retransmission of the INVITE.
+	if viaHop, ok := inviteRequest.ViaHop(); ok {
+		newRequest.AppendHeader(sip.ViaHeader{&sip.ViaHop{
+			ProtocolName:    viaHop.ProtocolName,
+			ProtocolVersion: viaHop.ProtocolVersion,
+			Transport:       viaHop.Transport,
+			Params:          sip.NewParams().Add("rport", nil),
+		}})
+	}
 
+	localTarget := s.movedTarget()
+	if localTarget != nil {
+		newRequest.AppendHeader(localTarget.Clone())
+	}
 	if uaType == "UAC" {
-		if contact, ok := s.request.Contact(); ok {
+		if contact, ok := s.request.Contact(); ok && localTarget == nil {
 			newRequest.AppendHeader(contact)
 		}
 
@@ -383,7 +663,7 @@ func (s *Session) makeRequest(uaType string, method sip.RequestMethod, msgID sip
 			sip.CopyHeaders("Route", inviteRequest, newRequest)
 		}
 	} else if uaType == "UAS" {
-		if contact, ok := s.response.Contact(); ok {
+		if contact, ok := s.response.Contact(); ok && localTarget == nil {
 			newRequest.AppendHeader(contact)
 		}
 
@@ -394,7 +674,7 @@ func (s *Session) makeRequest(uaType string, method sip.RequestMethod, msgID sip
 		newRequest.SetSource(inviteResponse.Source())
 	}
 
-	maxForwardsHeader := sip.MaxForwards(70)
+	maxForwardsHeader := sip.MaxForwards(s.maxForwards)
 	newRequest.AppendHeader(&maxForwardsHeader)
 	sip.CopyHeaders("Call-ID", inviteRequest, newRequest)
 	sip.CopyHeaders("CSeq", inviteRequest, newRequest)
@@ -0,0 +1,66 @@
+package session
+
+import (
+	"strings"
+
+	"github.com/ghettovoice/gosip/sip"
+)
+
+// TargetDialog value of the Target-Dialog header (RFC 4538).
+type TargetDialog struct {
+	CallID sip.CallID
+	// LocalTag and RemoteTag are from the perspective of the sender of the request, LocalTag its own tag.
+	LocalTag  string
+	RemoteTag string
+}
+
+// String .
+func (td TargetDialog) String() string {
+	return string(td.CallID) + ";local-tag=" + td.LocalTag + ";remote-tag=" + td.RemoteTag
+}
+
+// ParseTargetDialog parses the Target-Dialog header of a request.
+func ParseTargetDialog(request sip.Request) (*TargetDialog, bool) {
+	hdrs := request.GetHeaders("Target-Dialog")
+	if len(hdrs) == 0 {
+		return nil, false
+	}
+	parts := strings.Split(hdrs[0].Value(), ";")
+	td := &TargetDialog{CallID: sip.CallID(strings.TrimSpace(parts[0]))}
+	for _, part := range parts[1:] {
+		kv := strings.SplitN(strings.TrimSpace(part), "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		switch strings.ToLower(kv[0]) {
+		case "local-tag":
+			td.LocalTag = kv[1]
+		case "remote-tag":
+			td.RemoteTag = kv[1]
+		}
+	}
+	return td, true
+}
+
+// TargetDialog the Target-Dialog of the session for a request sent to the remote party.
+func (s *Session) TargetDialog() TargetDialog {
+	return TargetDialog{
+		CallID:    s.callID,
+		LocalTag:  addressTag(s.localURI),
+		RemoteTag: addressTag(s.remoteURI),
+	}
+}
+
+// NewTargetDialogHeader builds the Target-Dialog header of the session.
+func (s *Session) NewTargetDialogHeader() sip.Header {
+	return &sip.GenericHeader{HeaderName: "Target-Dialog", Contents: s.TargetDialog().String()}
+}
+
+// Matches reports whether a Target-Dialog received from the remote party identifies the session.
+func (s *Session) Matches(td *TargetDialog) bool {
+	return td.CallID == s.callID && td.LocalTag == addressTag(s.remoteURI) && td.RemoteTag == addressTag(s.localURI)
+}
+
+func addressTag(addr sip.Address) string {
+	return paramsTag(addr.Params)
+}
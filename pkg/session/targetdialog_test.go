@@ -0,0 +1,65 @@
+package session_test
+
+import (
+	"testing"
+
+	"github.com/ghettovoice/gosip/log"
+	"github.com/ghettovoice/gosip/sip"
+	"github.com/ghettovoice/gosip/sip/parser"
+	"github.com/sergeyu/go-sip-ua/pkg/session"
+	"github.com/sergeyu/go-sip-ua/pkg/utils"
+)
+
+// invite an INVITE of alice, tag a1, answered by bob, tag b1.
+const invite = "INVITE sip:bob@192.0.2.2 SIP/2.0\r\n" +
+	"Via: SIP/2.0/UDP 192.0.2.1:5060;branch=z9hG4bK-1\r\n" +
+	"Max-Forwards: 70\r\n" +
+	"From: <sip:alice@example.com>;tag=a1\r\n" +
+	"To: <sip:bob@example.com>;tag=b1\r\n" +
+	"Call-ID: 1@192.0.2.1\r\n" +
+	"CSeq: 1 INVITE\r\n" +
+	"Contact: <sip:alice@192.0.2.1:5060>\r\n" +
+	"Target-Dialog: 1@192.0.2.1;local-tag=a1;remote-tag=b1\r\n" +
+	"Content-Length: 0\r\n\r\n"
+
+func newSession(t *testing.T, uaType string, dir session.Direction) *session.Session {
+	logger := utils.NewLogger(log.ErrorLevel, "test", nil)
+	msg, err := parser.ParseMessage([]byte(invite), logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := msg.(sip.Request)
+	callID, _ := req.CallID()
+	contact, _ := req.Contact()
+	return session.NewInviteSession(nil, uaType, contact, req, *callID, nil, dir, logger)
+}
+
+// TestTargetDialog the Target-Dialog of a request of alice to bob (RFC 4538): its local-tag the one of
+// alice, matched by the session of bob only.
+func TestTargetDialog(t *testing.T) {
+	alice := newSession(t, "UAC", session.Outgoing)
+	bob := newSession(t, "UAS", session.Incoming)
+
+	td := alice.TargetDialog()
+	if got, want := td.String(), "1@192.0.2.1;local-tag=a1;remote-tag=b1"; got != want {
+		t.Errorf("TargetDialog = %s; want %s", got, want)
+	}
+	if !bob.Matches(&td) {
+		t.Errorf("%s does not match the session of bob", td)
+	}
+	if alice.Matches(&td) {
+		t.Errorf("%s matches the session of alice", td)
+	}
+
+	msg, err := parser.ParseMessage([]byte(invite), utils.NewLogger(log.ErrorLevel, "test", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, ok := session.ParseTargetDialog(msg.(sip.Request))
+	if !ok {
+		t.Fatal("no Target-Dialog parsed")
+	}
+	if *parsed != td {
+		t.Errorf("ParseTargetDialog = %+v; want %+v", *parsed, td)
+	}
+}
@@ -0,0 +1,143 @@
+package siprec
+
+import (
+	"encoding/base64"
+	"encoding/xml"
+	"strings"
+	"time"
+
+	"github.com/ghettovoice/gosip/sip"
+	"github.com/google/uuid"
+	"github.com/sergeyu/go-sip-ua/pkg/session"
+)
+
+const (
+	// MetadataContentType recording metadata document (RFC 7865).
+	MetadataContentType = "application/rs-metadata+xml"
+	// OptionTag the option tag required by the recording sessions (RFC 7866 6.1.1).
+	OptionTag = "siprec"
+	// FeatureTag the feature tag of the Contact of the session recording clients (RFC 7866 6.1.1).
+	FeatureTag = "+sip.src"
+)
+
+// Metadata recording metadata document, the communication sessions recorded, their participants and streams.
+type Metadata struct {
+	XMLName                  xml.Name                  `xml:"urn:ietf:params:xml:ns:recording:1 recording"`
+	DataMode                 string                    `xml:"datamode"`
+	Sessions                 []Session                 `xml:"session"`
+	Participants             []Participant             `xml:"participant"`
+	Streams                  []Stream                  `xml:"stream"`
+	SessionRecordingAssocs   []SessionRecordingAssoc   `xml:"sessionrecordingassoc"`
+	ParticipantSessionAssocs []ParticipantSessionAssoc `xml:"participantsessionassoc"`
+	ParticipantStreamAssocs  []ParticipantStreamAssoc  `xml:"participantstreamassoc"`
+}
+
+// Session a communication session recorded.
+type Session struct {
+	ID           string   `xml:"session_id,attr"`
+	SIPSessionID []string `xml:"sipSessionID,omitempty"`
+}
+
+// Participant .
+type Participant struct {
+	ID     string `xml:"participant_id,attr"`
+	NameID NameID `xml:"nameID"`
+}
+
+// NameID the address of record of a participant and its display name.
+type NameID struct {
+	AOR  string `xml:"aor,attr"`
+	Name string `xml:"name,omitempty"`
+}
+
+// Stream a media stream of a session, the a=label of its media in the SDP of the recording session.
+type Stream struct {
+	ID        string `xml:"stream_id,attr"`
+	SessionID string `xml:"session_id,attr"`
+	Label     string `xml:"label"`
+}
+
+// SessionRecordingAssoc the time a session is recorded from, and until.
+type SessionRecordingAssoc struct {
+	SessionID        string `xml:"session_id,attr"`
+	AssociateTime    string `xml:"associate-time,omitempty"`
+	DisassociateTime string `xml:"disassociate-time,omitempty"`
+}
+
+// ParticipantSessionAssoc the time a participant joins a session, and leaves it.
+type ParticipantSessionAssoc struct {
+	ParticipantID    string `xml:"participant_id,attr"`
+	SessionID        string `xml:"session_id,attr"`
+	AssociateTime    string `xml:"associate-time,omitempty"`
+	DisassociateTime string `xml:"disassociate-time,omitempty"`
+}
+
+// ParticipantStreamAssoc the streams a participant sends and receives.
+type ParticipantStreamAssoc struct {
+	ParticipantID string   `xml:"participant_id,attr"`
+	Send          []string `xml:"send"`
+	Recv          []string `xml:"recv"`
+}
+
+// NewID a unique identifier of the metadata, a UUID in base64 (RFC 7865 6.9).
+func NewID() string {
+	id := uuid.New()
+	return base64.StdEncoding.EncodeToString(id[:])
+}
+
+// NewMetadata the metadata of the recording of the call is from at: its local participant sending the stream
+// labeled sent, the remote one the stream labeled received.
+func NewMetadata(is *session.Session, sent string, received string, at time.Time) *Metadata {
+	sessionID := NewID()
+	local := Participant{ID: NewID(), NameID: nameID(is.LocalURI())}
+	remote := Participant{ID: NewID(), NameID: nameID(is.RemoteURI())}
+	sentStream := Stream{ID: NewID(), SessionID: sessionID, Label: sent}
+	receivedStream := Stream{ID: NewID(), SessionID: sessionID, Label: received}
+	associated := at.UTC().Format(time.RFC3339)
+	return &Metadata{
+		DataMode:     "complete",
+		Sessions:     []Session{{ID: sessionID}},
+		Participants: []Participant{local, remote},
+		Streams:      []Stream{sentStream, receivedStream},
+		SessionRecordingAssocs: []SessionRecordingAssoc{
+			{SessionID: sessionID, AssociateTime: associated},
+		},
+		ParticipantSessionAssocs: []ParticipantSessionAssoc{
+			{ParticipantID: local.ID, SessionID: sessionID, AssociateTime: associated},
+			{ParticipantID: remote.ID, SessionID: sessionID, AssociateTime: associated},
+		},
+		ParticipantStreamAssocs: []ParticipantStreamAssoc{
+			{ParticipantID: local.ID, Send: []string{sentStream.ID}, Recv: []string{receivedStream.ID}},
+			{ParticipantID: remote.ID, Send: []string{receivedStream.ID}, Recv: []string{sentStream.ID}},
+		},
+	}
+}
+
+func nameID(addr sip.Address) NameID {
+	id := NameID{}
+	if addr.Uri != nil {
+		id.AOR = addr.Uri.String()
+	}
+	if addr.DisplayName != nil {
+		id.Name = strings.Trim(addr.DisplayName.String(), "\"")
+	}
+	return id
+}
+
+// String .
+func (m *Metadata) String() string {
+	data, err := xml.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return ""
+	}
+	return xml.Header + string(data)
+}
+
+// ParseMetadata parses an rs-metadata+xml body.
+func ParseMetadata(body string) (*Metadata, error) {
+	m := &Metadata{}
+	if err := xml.Unmarshal([]byte(body), m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
@@ -0,0 +1,219 @@
+package siprec
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ghettovoice/gosip/log"
+	"github.com/ghettovoice/gosip/sip"
+	"github.com/pixelbender/go-sdp/sdp"
+	"github.com/sergeyu/go-sip-ua/pkg/account"
+	"github.com/sergeyu/go-sip-ua/pkg/media"
+	"github.com/sergeyu/go-sip-ua/pkg/media/rtp"
+	"github.com/sergeyu/go-sip-ua/pkg/multipart"
+	"github.com/sergeyu/go-sip-ua/pkg/session"
+	"github.com/sergeyu/go-sip-ua/pkg/ua"
+	"github.com/sergeyu/go-sip-ua/pkg/utils"
+)
+
+// The labels of the media of the recording sessions, the audio sent by the local participant and received from the remote.
+const (
+	SentLabel     = "1"
+	ReceivedLabel = "2"
+)
+
+// Recorder a session recording client (SRC, RFC 7866) of the UA, recording the calls of its choice on a
+// session recording server (SRS): each in a recording session with the metadata of the call, its audio
+// forked to two sendonly media.
+type Recorder struct {
+	ua      *ua.UserAgent
+	profile *account.Profile
+	srs     sip.SipUri
+	host    string
+	codec   sdp.Format
+	// recordings by the Call-ID of their recording session, and of the call recorded.
+	recordings sync.Map
+	calls      sync.Map
+	log        log.Logger
+}
+
+// NewRecorder a recorder of the calls of the UA on srs from profile, the audio forked from host in codec,
+// the one of the calls.
+func NewRecorder(userAgent *ua.UserAgent, profile *account.Profile, srs sip.SipUri, host string, codec sdp.Format) *Recorder {
+	// The Contact of the recording sessions has the feature tag of the SRC.
+	src := *profile
+	src.ContactParams = map[string]string{FeatureTag: ""}
+	for key, value := range profile.ContactParams {
+		src.ContactParams[key] = value
+	}
+	return &Recorder{
+		ua:      userAgent,
+		profile: &src,
+		srs:     srs,
+		host:    host,
+		codec:   codec,
+		log:     utils.NewLogger(log.DebugLevel, "SIPREC", nil),
+	}
+}
+
+// Log .
+func (r *Recorder) Log() log.Logger {
+	return r.log
+}
+
+// Recording the recording of a call.
+type Recording struct {
+	recorder *Recorder
+	call     *session.Session
+	stream   *rtp.Stream
+	// sent and received the streams of the audio sent by the call and received by it.
+	sent     *rtp.Stream
+	received *rtp.Stream
+	session  *session.Session
+	stopOnce sync.Once
+}
+
+// Record starts recording the call is, its first media stream forked to a recording session sent to the SRS.
+// The recording stops with the call, or by Stop.
+func (r *Recorder) Record(is *session.Session) (*Recording, error) {
+	streams := is.Streams()
+	if len(streams) == 0 {
+		return nil, fmt.Errorf("siprec: no media stream to record")
+	}
+	if _, found := r.calls.Load(is.CallID().String()); found {
+		return nil, fmt.Errorf("siprec: call already recorded")
+	}
+	rec := &Recording{recorder: r, call: is, stream: streams[0]}
+	var err error
+	// The streams of the recording are closed with the call.
+	if rec.sent, err = r.ua.NewStream(is, r.host, uint32(r.codec.ClockRate)); err != nil {
+		return nil, err
+	}
+	if rec.received, err = r.ua.NewStream(is, r.host, uint32(r.codec.ClockRate)); err != nil {
+		rec.sent.Close()
+		return nil, err
+	}
+
+	offer := media.NewSDP(r.host,
+		r.recordingMedia(rec.sent.LocalAddr().Port, SentLabel),
+		r.recordingMedia(rec.received.LocalAddr().Port, ReceivedLabel),
+	)
+	metadata := multipart.NewPart(MetadataContentType, NewMetadata(is, SentLabel, ReceivedLabel, time.Now()).String())
+	metadata.Header.Set("Content-Disposition", "recording-session")
+	body := multipart.New(multipart.NewPart("application/sdp", offer.String()), metadata)
+	content := body.String()
+	contentType := sip.ContentType(body.ContentType())
+	require := &sip.GenericHeader{HeaderName: "Require", Contents: OptionTag}
+
+	rs, err := r.ua.Invite(r.profile, &r.srs, r.srs, &content, &contentType, require)
+	if err != nil {
+		rec.sent.Close()
+		rec.received.Close()
+		return nil, err
+	}
+	rec.session = rs
+	r.recordings.Store(rs.CallID().String(), rec)
+	r.calls.Store(is.CallID().String(), rec)
+	r.Log().Infof("Recording %s in %s", is.CallID(), rs.CallID())
+	return rec, nil
+}
+
+func (r *Recorder) recordingMedia(port int, label string) *sdp.Media {
+	m := media.NewAudio(port, r.codec)
+	m.Mode = sdp.SendOnly
+	m.Attributes = append(m.Attributes, sdp.NewAttr("label", label))
+	return m
+}
+
+// Recording the recording of the call is, nil if it is not recorded.
+func (r *Recorder) Recording(is *session.Session) *Recording {
+	if v, found := r.calls.Load(is.CallID().String()); found {
+		return v.(*Recording)
+	}
+	return nil
+}
+
+// HandleSessionState forks the audio once a recording session is answered and stops the recordings of the
+// calls ended, call it from the InviteStateHandler. It reports whether sess is a recording session, not
+// a call of the application.
+func (r *Recorder) HandleSessionState(sess *session.Session, status session.Status) bool {
+	if v, found := r.recordings.Load(sess.CallID().String()); found {
+		rec := v.(*Recording)
+		switch {
+		case status == session.Confirmed:
+			if err := rec.start(); err != nil {
+				r.Log().Errorf("Recording %s: %v", rec.call.CallID(), err)
+				rec.Stop()
+			}
+		case sess.IsEnded():
+			rec.Stop()
+		}
+		return true
+	}
+	if sess.IsEnded() {
+		if rec := r.Recording(sess); rec != nil {
+			rec.Stop()
+		}
+	}
+	return false
+}
+
+// start forks the audio of the call to the media of the answer of the SRS.
+func (rec *Recording) start() error {
+	answer, err := rec.session.ParseRemoteSdp()
+	if err != nil {
+		return err
+	}
+	if answer == nil || len(answer.Media) < 2 {
+		return fmt.Errorf("no answer of the two media")
+	}
+	labels := []string{SentLabel, ReceivedLabel}
+	for i, stream := range []*rtp.Stream{rec.sent, rec.received} {
+		m := labeled(answer, labels[i])
+		if m == nil {
+			m = answer.Media[i]
+		}
+		if m.Port == 0 {
+			return fmt.Errorf("media %s rejected", labels[i])
+		}
+		addr, err := media.RTPAddr(answer, m)
+		if err != nil {
+			return err
+		}
+		stream.SetRemote(addr)
+	}
+	rec.stream.Fork(rec.received, rec.sent)
+	return nil
+}
+
+// labeled the media of the answer of label, nil if the SRS did not label them.
+func labeled(answer *sdp.Session, label string) *sdp.Media {
+	for _, m := range answer.Media {
+		if m.Attributes.Get("label") == label {
+			return m
+		}
+	}
+	return nil
+}
+
+// Session the recording session.
+func (rec *Recording) Session() *session.Session {
+	return rec.session
+}
+
+// Stop stops forking the audio and ends the recording session.
+func (rec *Recording) Stop() {
+	rec.stopOnce.Do(func() {
+		rec.stream.Fork(nil, nil)
+		rec.sent.Close()
+		rec.received.Close()
+		if !rec.session.IsEnded() {
+			rec.session.End()
+		}
+		r := rec.recorder
+		r.recordings.Delete(rec.session.CallID().String())
+		r.calls.Delete(rec.call.CallID().String())
+		r.Log().Infof("Recording %s stopped", rec.call.CallID())
+	})
+}
@@ -0,0 +1,267 @@
+package stack_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ghettovoice/gosip/log"
+	"github.com/ghettovoice/gosip/sip"
+	"github.com/sergeyu/go-sip-ua/pkg/session"
+	"github.com/sergeyu/go-sip-ua/pkg/stack"
+	"github.com/sergeyu/go-sip-ua/pkg/ua"
+	"github.com/sergeyu/go-sip-ua/pkg/utils"
+)
+
+// responseTimeout how long a benchmark waits for the final response to a request.
+const responseTimeout = 5 * time.Second
+
+// fixture a message of testdata/bench, its placeholders {call-id}, {branch}, {tag} and {to-tag}
+// replaced for each message sent, its lines ended by CRLF and its Content-Length set.
+type fixture struct {
+	head string
+	body string
+}
+
+func loadFixture(b *testing.B, name string) fixture {
+	data, err := ioutil.ReadFile(filepath.Join("testdata", "bench", name))
+	if err != nil {
+		b.Fatal(err)
+	}
+	text := strings.Replace(string(data), "\n", "\r\n", -1)
+	parts := strings.SplitN(text, "\r\n\r\n", 2)
+	f := fixture{head: parts[0] + "\r\n\r\n"}
+	if len(parts) == 2 {
+		f.body = parts[1]
+	}
+	f.head = strings.Replace(f.head, "{content-length}", strconv.Itoa(len(f.body)), 1)
+	return f
+}
+
+// message the fixture of the dialog d with branch.
+func (f fixture) message(d *dialog, branch string) []byte {
+	return []byte(strings.NewReplacer(
+		"{call-id}", d.callID,
+		"{branch}", branch,
+		"{tag}", d.tag,
+		"{to-tag}", d.toTag,
+	).Replace(f.head) + f.body)
+}
+
+// dialog the identifiers of a call of a benchmark.
+type dialog struct {
+	callID string
+	tag    string
+	toTag  string
+}
+
+// bench a stack answering the messages received by a user agent, accepting or rejecting the calls,
+// its responses sent to a UDP socket as if to a peer and reported to the requests waiting for them.
+type bench struct {
+	stack  *stack.SipStack
+	peer   net.PacketConn
+	source string
+	next   uint64
+	// waiting the channels of the requests waiting for their final response by Call-ID and method.
+	waiting sync.Map
+
+	invite, ack, bye, options fixture
+}
+
+func newBench(b *testing.B, accept bool) *bench {
+	utils.SetLogSink(utils.LogSinkFunc(func(level log.Level, msg string, fields log.Fields) {}))
+	s := stack.NewSipStack(&stack.SipStackConfig{Host: "127.0.0.1"})
+	if err := s.Listen("udp", "127.0.0.1:0"); err != nil {
+		b.Fatal(err)
+	}
+	peer, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		b.Fatal(err)
+	}
+	go func() {
+		buf := make([]byte, 65536)
+		for {
+			if _, _, err := peer.ReadFrom(buf); err != nil {
+				return
+			}
+		}
+	}()
+	userAgent, err := ua.NewUserAgent(ua.WithSipStack(s))
+	if err != nil {
+		b.Fatal(err)
+	}
+	userAgent.InviteStateHandler = func(is *session.Session, req *sip.Request, resp *sip.Response, status session.Status) {
+		if status != session.InviteReceived {
+			return
+		}
+		if accept {
+			is.ProvideAnswer(is.RemoteSdp())
+			is.Accept(200)
+		} else {
+			is.Reject(486, "Busy Here")
+		}
+	}
+
+	bn := &bench{
+		stack:   s,
+		peer:    peer,
+		source:  peer.LocalAddr().String(),
+		invite:  loadFixture(b, "invite.sip"),
+		ack:     loadFixture(b, "ack.sip"),
+		bye:     loadFixture(b, "bye.sip"),
+		options: loadFixture(b, "options.sip"),
+	}
+	s.InterceptOutgoing(func(msg sip.Message) sip.Message {
+		if res, ok := msg.(sip.Response); ok && !res.IsProvisional() {
+			callID, _ := res.CallID()
+			cseq, _ := res.CSeq()
+			if v, ok := bn.waiting.Load(string(*callID) + string(cseq.MethodName)); ok {
+				select {
+				case v.(chan sip.Response) <- res:
+				default:
+				}
+			}
+		}
+		return msg
+	})
+	return bn
+}
+
+func (bn *bench) close() {
+	bn.stack.Shutdown()
+	bn.peer.Close()
+}
+
+// newDialog the identifiers of a new call.
+func (bn *bench) newDialog() *dialog {
+	n := strconv.FormatUint(atomic.AddUint64(&bn.next, 1), 10)
+	return &dialog{callID: "bench-" + n + "@203.0.113.20", tag: "t" + n}
+}
+
+// request passes a request of the dialog through the stack and returns its final response.
+func (bn *bench) request(f fixture, d *dialog, method sip.RequestMethod) (sip.Response, error) {
+	key := d.callID + string(method)
+	responses := make(chan sip.Response, 1)
+	bn.waiting.Store(key, responses)
+	defer bn.waiting.Delete(key)
+
+	if err := bn.stack.Receive("udp", bn.source, f.message(d, "z9hG4bK"+d.callID+string(method))); err != nil {
+		return nil, err
+	}
+	timer := time.NewTimer(responseTimeout)
+	defer timer.Stop()
+	select {
+	case res := <-responses:
+		return res, nil
+	case <-timer.C:
+		return nil, fmt.Errorf("no response to %s %s", method, d.callID)
+	}
+}
+
+// call an INVITE and its ACK, a BYE after it if answered.
+func (bn *bench) call() error {
+	d := bn.newDialog()
+	res, err := bn.request(bn.invite, d, sip.INVITE)
+	if err != nil {
+		return err
+	}
+	if to, ok := res.To(); ok && to.Params != nil {
+		if tag, ok := to.Params.Get("tag"); ok {
+			d.toTag = tag.String()
+		}
+	}
+	if res.IsSuccess() {
+		// The ACK of a 2xx is a transaction of its own.
+		if err := bn.stack.Receive("udp", bn.source, bn.ack.message(d, "z9hG4bKack"+d.callID)); err != nil {
+			return err
+		}
+		res, err := bn.request(bn.bye, d, sip.BYE)
+		if err != nil {
+			return err
+		}
+		if res.StatusCode() != 200 {
+			return fmt.Errorf("BYE: %d %s", res.StatusCode(), res.Reason())
+		}
+		return nil
+	}
+	// The ACK of a non-2xx belongs to the INVITE transaction.
+	return bn.stack.Receive("udp", bn.source, bn.ack.message(d, "z9hG4bK"+d.callID+string(sip.INVITE)))
+}
+
+// reportRate reports the transactions per second of the benchmark, n per iteration.
+func reportRate(b *testing.B, start time.Time, n int) {
+	b.ReportMetric(float64(b.N*n)/time.Since(start).Seconds(), "tx/s")
+}
+
+// BenchmarkOptions an OPTIONS answered by the user agent: the parser, a non-INVITE server transaction
+// and the transport.
+func BenchmarkOptions(b *testing.B) {
+	bn := newBench(b, false)
+	defer bn.close()
+	b.ReportAllocs()
+	b.ResetTimer()
+	start := time.Now()
+	for i := 0; i < b.N; i++ {
+		if _, err := bn.request(bn.options, bn.newDialog(), sip.OPTIONS); err != nil {
+			b.Fatal(err)
+		}
+	}
+	reportRate(b, start, 1)
+}
+
+// BenchmarkInviteRejected an INVITE rejected by the user agent with a 486, and its ACK: the allocations
+// per INVITE of the transactions and of a session.
+func BenchmarkInviteRejected(b *testing.B) {
+	bn := newBench(b, false)
+	defer bn.close()
+	b.ReportAllocs()
+	b.ResetTimer()
+	start := time.Now()
+	for i := 0; i < b.N; i++ {
+		if err := bn.call(); err != nil {
+			b.Fatal(err)
+		}
+	}
+	reportRate(b, start, 1)
+}
+
+// BenchmarkCall a call answered and hung up: the INVITE, its ACK and the BYE, through the session map.
+func BenchmarkCall(b *testing.B) {
+	bn := newBench(b, true)
+	defer bn.close()
+	b.ReportAllocs()
+	b.ResetTimer()
+	start := time.Now()
+	for i := 0; i < b.N; i++ {
+		if err := bn.call(); err != nil {
+			b.Fatal(err)
+		}
+	}
+	reportRate(b, start, 2)
+}
+
+// BenchmarkCallParallel the calls of BenchmarkCall from GOMAXPROCS goroutines at once, e.g. with -cpu to
+// measure the contention of the transactions and of the session map.
+func BenchmarkCallParallel(b *testing.B) {
+	bn := newBench(b, true)
+	defer bn.close()
+	b.ReportAllocs()
+	b.ResetTimer()
+	start := time.Now()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if err := bn.call(); err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+	reportRate(b, start, 2)
+}
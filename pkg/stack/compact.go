@@ -0,0 +1,67 @@
+package stack
+
+import (
+	"strings"
+
+	"github.com/ghettovoice/gosip/sip"
+)
+
+// compactNames the compact forms of the header names (RFC 3261 7.3.3, RFC 3515, RFC 3892, RFC 4028, RFC 6665).
+var compactNames = map[string]string{
+	"call-id":          "i",
+	"contact":          "m",
+	"content-encoding": "e",
+	"content-length":   "l",
+	"content-type":     "c",
+	"from":             "f",
+	"subject":          "s",
+	"supported":        "k",
+	"to":               "t",
+	"via":              "v",
+	"event":            "o",
+	"allow-events":     "u",
+	"refer-to":         "r",
+	"referred-by":      "b",
+	"session-expires":  "x",
+}
+
+// compactMessage a message written with the compact form of its header names, the message itself unchanged
+// for the transactions and the retransmissions.
+type compactMessage struct {
+	sip.Message
+	data string
+}
+
+func (msg *compactMessage) String() string {
+	return msg.data
+}
+
+// wire the message written by the protocols, with the compact header names if enabled.
+func (l *layer) wire(msg sip.Message) sip.Message {
+	if !l.compact {
+		return msg
+	}
+	return &compactMessage{Message: msg, data: compactString(msg)}
+}
+
+// compactString msg with the compact form of the names of its headers having one.
+func compactString(msg sip.Message) string {
+	data := msg.String()
+	end := strings.Index(data, "\r\n\r\n")
+	if end < 0 {
+		return data
+	}
+	lines := strings.Split(data[:end], "\r\n")
+	// The start line is kept.
+	for i := 1; i < len(lines); i++ {
+		colon := strings.IndexByte(lines[i], ':')
+		if colon < 0 {
+			continue
+		}
+		name := strings.TrimSpace(lines[i][:colon])
+		if short, ok := compactNames[strings.ToLower(name)]; ok {
+			lines[i] = short + lines[i][colon:]
+		}
+	}
+	return strings.Join(lines, "\r\n") + data[end:]
+}
@@ -0,0 +1,207 @@
+package stack
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ghettovoice/gosip/sip"
+)
+
+// conformanceWait how long CheckConformance waits for the final response to a message.
+const conformanceWait = 500 * time.Millisecond
+
+// conformanceSource the address the messages of CheckConformance are received from (RFC 5737).
+const conformanceSource = "192.0.2.254:5060"
+
+// ConformanceResult how the stack handled a torture message.
+type ConformanceResult struct {
+	Message TortureMessage
+	// Status the final response sent, else the provisional one, 0 if none.
+	Status sip.StatusCode
+	// Err why the transports dropped the message, answered or not, nil if it was passed to the transactions.
+	Err error
+	// Pass whether the message was handled as RFC 4475 expects.
+	Pass bool
+}
+
+func (r ConformanceResult) String() string {
+	outcome := "no response"
+	switch {
+	case r.Err != nil && r.Status != 0:
+		outcome = fmt.Sprintf("%d, dropped: %v", r.Status, r.Err)
+	case r.Err != nil:
+		outcome = fmt.Sprintf("dropped: %v", r.Err)
+	case r.Status != 0:
+		outcome = fmt.Sprintf("%d", r.Status)
+	}
+	expected := "handled"
+	if !r.Message.Valid {
+		expected = "dropped"
+		if r.Message.Status != 0 {
+			expected = fmt.Sprintf("%d", r.Message.Status)
+		}
+	}
+	result := "PASS"
+	if !r.Pass {
+		result = "FAIL"
+	}
+	return fmt.Sprintf("%s %-8s %-10s expected %s, got %s", result, r.Message.Section, r.Message.Name, expected, outcome)
+}
+
+// ConformanceReport the results of CheckConformance, in the order of the messages.
+type ConformanceReport struct {
+	Results []ConformanceResult
+}
+
+// Passed the number of messages handled as expected.
+func (r *ConformanceReport) Passed() int {
+	passed := 0
+	for _, result := range r.Results {
+		if result.Pass {
+			passed++
+		}
+	}
+	return passed
+}
+
+// Failed the results of the messages not handled as expected.
+func (r *ConformanceReport) Failed() []ConformanceResult {
+	var failed []ConformanceResult
+	for _, result := range r.Results {
+		if !result.Pass {
+			failed = append(failed, result)
+		}
+	}
+	return failed
+}
+
+func (r *ConformanceReport) String() string {
+	var b strings.Builder
+	for _, result := range r.Results {
+		b.WriteString(result.String())
+		b.WriteString("\n")
+	}
+	fmt.Fprintf(&b, "%d/%d passed\n", r.Passed(), len(r.Results))
+	return b.String()
+}
+
+// conformance the responses to the messages of CheckConformance in progress, by Call-ID and by branch.
+type conformance struct {
+	checks    int32
+	responses sync.Map
+}
+
+// CheckConformance passes the torture messages through the stack as UDP datagrams, TortureMessages if
+// none, and reports how they were handled: their responses are checked, not sent, past the outgoing
+// interceptors. The requests accepted reach the handlers, e.g. the calls of a UA, so the check is to be
+// run on a stack of a test setup, e.g. in a test or with a conformance tool, not in production.
+func (s *SipStack) CheckConformance(ctx context.Context, messages ...TortureMessage) *ConformanceReport {
+	if len(messages) == 0 {
+		messages = TortureMessages()
+	}
+	atomic.AddInt32(&s.conformance.checks, 1)
+	defer atomic.AddInt32(&s.conformance.checks, -1)
+
+	report := &ConformanceReport{}
+	for _, message := range messages {
+		if ctx.Err() != nil {
+			break
+		}
+		report.Results = append(report.Results, s.checkConformance(ctx, message))
+	}
+	return report
+}
+
+func (s *SipStack) checkConformance(ctx context.Context, message TortureMessage) ConformanceResult {
+	result := ConformanceResult{Message: message}
+	responses := make(chan sip.StatusCode, 8)
+	keys := conformanceKeys(s.guard, message.Data)
+	for _, key := range keys {
+		s.conformance.responses.Store(key, responses)
+	}
+	defer func() {
+		for _, key := range keys {
+			s.conformance.responses.Delete(key)
+		}
+	}()
+
+	// The response to a message dropped is sent by Receive, the others by the transactions.
+	result.Err = s.Receive("udp", conformanceSource, message.Data)
+	if result.Err != nil {
+		select {
+		case result.Status = <-responses:
+		default:
+		}
+	} else {
+		timer := time.NewTimer(conformanceWait)
+		defer timer.Stop()
+	wait:
+		for {
+			select {
+			case code := <-responses:
+				result.Status = code
+				if code >= 200 {
+					break wait
+				}
+			case <-timer.C:
+				break wait
+			case <-ctx.Done():
+				break wait
+			}
+		}
+	}
+
+	request := !strings.HasPrefix(string(message.Data), "SIP/")
+	switch {
+	case message.Valid && request:
+		result.Pass = (result.Err == nil || result.Status != 0) && result.Status != 400 && result.Status != 505
+	case message.Valid:
+		result.Pass = result.Err == nil
+	case message.Status == 0:
+		result.Pass = result.Status == 0
+	default:
+		result.Pass = result.Status == message.Status
+	}
+	return result
+}
+
+// conformanceKeys the Call-ID and the branch of data the responses are matched to.
+func conformanceKeys(g *guard, data []byte) []string {
+	msg := g.parseLenient(data)
+	if msg == nil {
+		return nil
+	}
+	return responseKeys(msg)
+}
+
+func responseKeys(msg sip.Message) []string {
+	var keys []string
+	if callID, ok := msg.CallID(); ok {
+		keys = append(keys, "call-id:"+callID.Value())
+	}
+	if branch := branchOf(msg); branch != "" {
+		keys = append(keys, "branch:"+branch)
+	}
+	return keys
+}
+
+// capture whether res answers a message of CheckConformance in progress, passed to it instead of sent.
+func (c *conformance) capture(res sip.Response) bool {
+	if atomic.LoadInt32(&c.checks) == 0 {
+		return false
+	}
+	for _, key := range responseKeys(res) {
+		if v, ok := c.responses.Load(key); ok {
+			select {
+			case v.(chan sip.StatusCode) <- res.StatusCode():
+			default:
+			}
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,180 @@
+package stack
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// connKeepAlive the period of the TCP keepalive probes, a half-open connection is closed when they fail.
+	connKeepAlive = 30 * time.Second
+	// writeTimeout how long a write may block on a connection before it is closed.
+	writeTimeout = 10 * time.Second
+	// minDialBackoff the wait before a new dial to an address after a failed one, doubled on each failure.
+	minDialBackoff = time.Second
+	// maxDialBackoff the longest wait between the dials to an address.
+	maxDialBackoff = time.Minute
+)
+
+// ConnectionState the state of a connection of a stream transport, TCP, TLS, WS or WSS.
+type ConnectionState int
+
+const (
+	// ConnectionOpened a connection was dialed or accepted.
+	ConnectionOpened ConnectionState = iota
+	// ConnectionClosed a connection was closed, by the peer, an error or its expiry.
+	ConnectionClosed
+	// ConnectionFailed a dial failed, the next one waits for a backoff.
+	ConnectionFailed
+)
+
+func (state ConnectionState) String() string {
+	switch state {
+	case ConnectionOpened:
+		return "opened"
+	case ConnectionClosed:
+		return "closed"
+	case ConnectionFailed:
+		return "failed"
+	}
+	return fmt.Sprintf("ConnectionState(%d)", int(state))
+}
+
+// ConnectionEvent a change of the state of a connection.
+type ConnectionEvent struct {
+	Network    string
+	LocalAddr  string
+	RemoteAddr string
+	State      ConnectionState
+	// Err the error of a failed dial.
+	Err error
+}
+
+// ConnectionEventHandler is called on the connection events of the stream transports.
+type ConnectionEventHandler func(event ConnectionEvent)
+
+type dialBackoff struct {
+	failures int
+	next     time.Time
+}
+
+// connManager the events and the dial backoff by remote address of the connections of the stream transports,
+// the connections themselves are pooled by their protocols.
+type connManager struct {
+	backoffs sync.Map
+	// peers the identities of the TLS clients verified by their certificate, by local and remote address.
+	peers   sync.Map
+	mu      sync.RWMutex
+	handler ConnectionEventHandler
+}
+
+func (m *connManager) setHandler(handler ConnectionEventHandler) {
+	m.mu.Lock()
+	m.handler = handler
+	m.mu.Unlock()
+}
+
+func (m *connManager) notify(event ConnectionEvent) {
+	m.mu.RLock()
+	handler := m.handler
+	m.mu.RUnlock()
+	if handler != nil {
+		handler(event)
+	}
+}
+
+func (m *connManager) opened(network string, conn net.Conn) {
+	m.notify(ConnectionEvent{
+		Network:    strings.ToUpper(network),
+		LocalAddr:  conn.LocalAddr().String(),
+		RemoteAddr: conn.RemoteAddr().String(),
+		State:      ConnectionOpened,
+	})
+}
+
+func (m *connManager) closed(network string, conn net.Conn) {
+	m.peers.Delete(peerKey(conn.LocalAddr().String(), conn.RemoteAddr().String()))
+	m.notify(ConnectionEvent{
+		Network:    strings.ToUpper(network),
+		LocalAddr:  conn.LocalAddr().String(),
+		RemoteAddr: conn.RemoteAddr().String(),
+		State:      ConnectionClosed,
+	})
+}
+
+func peerKey(laddr string, raddr string) string {
+	return laddr + "|" + raddr
+}
+
+func (m *connManager) verified(laddr string, raddr string, identity string) {
+	m.peers.Store(peerKey(laddr, raddr), identity)
+}
+
+// peer the identity of the verified client on the connection from raddr to laddr.
+func (m *connManager) peer(laddr string, raddr string) (string, bool) {
+	v, ok := m.peers.Load(peerKey(laddr, raddr))
+	if !ok {
+		return "", false
+	}
+	return v.(string), true
+}
+
+// allow reports an error while the dials to raddr are backed off.
+func (m *connManager) allow(network string, raddr string) error {
+	v, ok := m.backoffs.Load(flowKey(network, raddr))
+	if !ok {
+		return nil
+	}
+	if wait := time.Until(v.(*dialBackoff).next); wait > 0 {
+		return fmt.Errorf("backed off for %s after %d failures", wait.Round(time.Millisecond), v.(*dialBackoff).failures)
+	}
+	return nil
+}
+
+// dialed records the result of a dial to raddr, a failure backs off the next one.
+func (m *connManager) dialed(network string, raddr string, err error) {
+	key := flowKey(network, raddr)
+	if err == nil {
+		m.backoffs.Delete(key)
+		return
+	}
+
+	backoff := &dialBackoff{}
+	if v, ok := m.backoffs.Load(key); ok {
+		backoff.failures = v.(*dialBackoff).failures
+	}
+	wait := maxDialBackoff
+	if backoff.failures < 16 && minDialBackoff<<uint(backoff.failures) < wait {
+		wait = minDialBackoff << uint(backoff.failures)
+	}
+	backoff.failures++
+	backoff.next = time.Now().Add(wait)
+	m.backoffs.Store(key, backoff)
+
+	m.notify(ConnectionEvent{
+		Network:    strings.ToUpper(network),
+		RemoteAddr: raddr,
+		State:      ConnectionFailed,
+		Err:        err,
+	})
+}
+
+// OnConnectionEvent registers the handler of the connection events of the stream transports.
+func (s *SipStack) OnConnectionEvent(handler ConnectionEventHandler) {
+	s.conns.setHandler(handler)
+}
+
+// dialer the dialer of the stream transports, control sets the options of the sockets.
+func dialer(control sockopt) *net.Dialer {
+	return &net.Dialer{Timeout: dialTimeout, KeepAlive: connKeepAlive, Control: control}
+}
+
+// listenStream the TCP listener of the stream transports, control sets the options of the socket.
+func listenStream(addr string, control sockopt) (net.Listener, error) {
+	config := net.ListenConfig{KeepAlive: connKeepAlive, Control: control}
+	return config.Listen(context.Background(), "tcp", addr)
+}
@@ -0,0 +1,55 @@
+package stack
+
+import (
+	"context"
+	"net"
+	"strings"
+
+	"github.com/ghettovoice/gosip/log"
+	"github.com/ghettovoice/gosip/sip"
+	"github.com/ghettovoice/gosip/transport"
+)
+
+// Transport a custom transport plugged into the stack, e.g. an in-memory one for the tests, a tunnel or an overlay.
+// Its connections carry the SIP messages as a stream, framed by their Content-Length as over TCP.
+// The addresses are host:port, passed as they are: the stack does not resolve them by DNS.
+// The remote address of a connection is the one the responses are sent back to, it must be unique.
+type Transport interface {
+	// Listen accepts the connections on addr.
+	Listen(addr string) (net.Listener, error)
+	// Dial connects to addr.
+	Dial(ctx context.Context, addr string) (net.Conn, error)
+}
+
+// customTransport the custom transport of network, see SipStackConfig.Transports.
+func (s *SipStack) customTransport(network string) (Transport, bool) {
+	for n, tp := range s.config.Transports {
+		if strings.EqualFold(n, network) && tp != nil {
+			return tp, true
+		}
+	}
+	return nil, false
+}
+
+// newCustomProtocol the stream protocol of a custom transport, its addresses are not resolved.
+func (s *SipStack) newCustomProtocol(
+	network string,
+	tp Transport,
+	output chan<- sip.Message,
+	errs chan<- error,
+	cancel <-chan struct{},
+	msgMapper sip.MessageMapper,
+	logger log.Logger,
+) transport.Protocol {
+	listen := func(addr string, control sockopt, options ...transport.ListenOption) (net.Listener, error) {
+		return tp.Listen(addr)
+	}
+	dial := func(host string, addr string, control sockopt) (net.Conn, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), dialTimeout)
+		defer cancel()
+		return tp.Dial(ctx, addr)
+	}
+	p := newStreamProtocol(network, listen, dial, nil, s.flows, s.conns, s.stats, s.guard, output, errs, cancel, msgMapper, logger).(*streamProtocol)
+	p.opaque = true
+	return p
+}
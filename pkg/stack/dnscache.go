@@ -0,0 +1,108 @@
+package stack
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultDNSCacheTTL how long SRV and A/AAAA answers are cached, the net package does not report their TTL.
+	DefaultDNSCacheTTL = time.Minute
+	// DefaultDNSNegativeTTL how long a name not found is cached (RFC 2308).
+	DefaultDNSNegativeTTL = 30 * time.Second
+)
+
+type dnsCacheEntry struct {
+	value   interface{}
+	err     error
+	expires time.Time
+}
+
+// cachingResolver a Resolver caching the answers of another one.
+type cachingResolver struct {
+	resolver    Resolver
+	ttl         time.Duration
+	negativeTTL time.Duration
+	entries     sync.Map
+}
+
+// NewCachingResolver caches the answers of resolver. NAPTR answers are kept for the TTL of their records,
+// at most ttl, the other ones for ttl. Names not found are kept for negativeTTL, other errors are not cached.
+func NewCachingResolver(resolver Resolver, ttl time.Duration, negativeTTL time.Duration) Resolver {
+	return &cachingResolver{
+		resolver:    resolver,
+		ttl:         ttl,
+		negativeTTL: negativeTTL,
+	}
+}
+
+func (c *cachingResolver) LookupNAPTR(ctx context.Context, name string) ([]*NAPTR, error) {
+	value, err := c.lookup(ctx, "NAPTR:"+name, func(ctx context.Context) (interface{}, time.Duration, error) {
+		records, err := c.resolver.LookupNAPTR(ctx, name)
+		ttl := c.ttl
+		for _, record := range records {
+			if recordTTL := time.Duration(record.TTL) * time.Second; recordTTL < ttl {
+				ttl = recordTTL
+			}
+		}
+		return records, c.answerTTL(len(records), ttl), err
+	})
+	records, _ := value.([]*NAPTR)
+	return records, err
+}
+
+func (c *cachingResolver) LookupSRV(ctx context.Context, name string) ([]*net.SRV, error) {
+	value, err := c.lookup(ctx, "SRV:"+name, func(ctx context.Context) (interface{}, time.Duration, error) {
+		records, err := c.resolver.LookupSRV(ctx, name)
+		return records, c.answerTTL(len(records), c.ttl), err
+	})
+	records, _ := value.([]*net.SRV)
+	return records, err
+}
+
+func (c *cachingResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	value, err := c.lookup(ctx, "A:"+host, func(ctx context.Context) (interface{}, time.Duration, error) {
+		addrs, err := c.resolver.LookupIPAddr(ctx, host)
+		return addrs, c.answerTTL(len(addrs), c.ttl), err
+	})
+	addrs, _ := value.([]net.IPAddr)
+	return addrs, err
+}
+
+func (c *cachingResolver) lookup(
+	ctx context.Context,
+	key string,
+	query func(ctx context.Context) (interface{}, time.Duration, error),
+) (interface{}, error) {
+	if v, ok := c.entries.Load(key); ok {
+		entry := v.(*dnsCacheEntry)
+		if time.Now().Before(entry.expires) {
+			return entry.value, entry.err
+		}
+		c.entries.Delete(key)
+	}
+
+	value, ttl, err := query(ctx)
+	if err != nil {
+		var dnsErr *net.DNSError
+		if !errors.As(err, &dnsErr) || !dnsErr.IsNotFound {
+			return nil, err
+		}
+		ttl = c.negativeTTL
+	}
+	if ttl > 0 {
+		c.entries.Store(key, &dnsCacheEntry{value: value, err: err, expires: time.Now().Add(ttl)})
+	}
+	return value, err
+}
+
+// answerTTL the negative TTL for an empty answer.
+func (c *cachingResolver) answerTTL(records int, ttl time.Duration) time.Duration {
+	if records == 0 {
+		return c.negativeTTL
+	}
+	return ttl
+}
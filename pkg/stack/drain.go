@@ -0,0 +1,143 @@
+package stack
+
+import (
+	"sync"
+	"time"
+
+	"github.com/ghettovoice/gosip/sip"
+
+	"github.com/sergeyu/go-sip-ua/pkg/clock"
+	"github.com/sergeyu/go-sip-ua/pkg/transaction"
+)
+
+// DefaultShutdownTimeout how long Shutdown waits for the transactions in progress, Timer B.
+const DefaultShutdownTimeout = 64 * transaction.T1
+
+// txTracker the transactions in progress by key, waited for by Shutdown. A transaction ends with
+// its final response, not after the retransmissions it absorbs.
+type txTracker struct {
+	mu     sync.Mutex
+	active map[sip.TransactionKey]*trackedTx
+	// idle is closed when the last transaction ends.
+	idle chan struct{}
+	// handler the handler of the transactions ended, see SipStack.OnTransactionEnd.
+	handler TransactionHandler
+	// clock the clock of the durations of the transactions.
+	clock clock.Clock
+}
+
+// trackedTx a transaction in progress.
+type trackedTx struct {
+	request sip.Request
+	server  bool
+	start   time.Time
+}
+
+func (t *txTracker) track(key sip.TransactionKey, request sip.Request, server bool, done <-chan bool) {
+	t.mu.Lock()
+	if len(t.active) == 0 {
+		t.idle = make(chan struct{})
+	}
+	t.active[key] = &trackedTx{request: request, server: server, start: t.clock.Now()}
+	t.mu.Unlock()
+
+	go func() {
+		<-done
+		t.end(key, nil)
+	}()
+}
+
+// end ends the transaction of key with its final response, nil if it timed out or failed.
+func (t *txTracker) end(key sip.TransactionKey, res sip.Response) {
+	t.mu.Lock()
+	tx, ok := t.active[key]
+	if !ok {
+		t.mu.Unlock()
+		return
+	}
+	delete(t.active, key)
+	if len(t.active) == 0 {
+		close(t.idle)
+	}
+	handler := t.handler
+	t.mu.Unlock()
+
+	if handler != nil {
+		handler(TransactionEvent{
+			Request:  tx.request,
+			Response: res,
+			Server:   tx.server,
+			Duration: t.clock.Now().Sub(tx.start),
+		})
+	}
+}
+
+// responded ends the server transaction of a final response sent.
+func (t *txTracker) responded(res sip.Response) {
+	if res.IsProvisional() {
+		return
+	}
+	if key, err := transaction.MakeServerTxKey(res); err == nil {
+		t.end(key, res)
+	}
+}
+
+// received ends the client transaction of a final response received.
+func (t *txTracker) received(res sip.Response) {
+	if res.IsProvisional() {
+		return
+	}
+	if key, err := transaction.MakeClientTxKey(res); err == nil {
+		t.end(key, res)
+	}
+}
+
+// wait for the transactions to end, reports the number still in progress after the timeout.
+func (t *txTracker) wait(timeout time.Duration) int {
+	t.mu.Lock()
+	if len(t.active) == 0 {
+		t.mu.Unlock()
+		return 0
+	}
+	idle := t.idle
+	t.mu.Unlock()
+
+	select {
+	case <-idle:
+		return 0
+	case <-time.After(timeout):
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.active)
+}
+
+// drainable reports whether a request is still handled while the stack drains,
+// the ones ending the calls and transactions in progress.
+func drainable(method sip.RequestMethod) bool {
+	switch method {
+	case sip.ACK, sip.CANCEL, sip.BYE:
+		return true
+	}
+	return false
+}
+
+// rejectDraining answers a new request with 503 while the stack drains.
+func (s *SipStack) rejectDraining(req sip.Request) {
+	res := sip.NewResponseFromRequest("", req, 503, "Service Unavailable", "")
+	if _, err := s.Respond(res); err != nil {
+		s.Log().Errorf("respond '%d %s' failed: %s", res.StatusCode(), res.Reason(), err)
+	}
+}
+
+// Draining reports whether the stack is shutting down, waiting for the transactions in progress.
+func (s *SipStack) Draining() bool {
+	return s.draining.IsSet()
+}
+
+func (c *SipStackConfig) shutdownTimeout() time.Duration {
+	if c.ShutdownTimeout <= 0 {
+		return c.Timers.Timeout()
+	}
+	return c.ShutdownTimeout
+}
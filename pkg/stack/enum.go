@@ -0,0 +1,185 @@
+package stack
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/ghettovoice/gosip/sip"
+	"github.com/ghettovoice/gosip/sip/parser"
+)
+
+// DefaultENUMSuffix the domain of the public ENUM tree (RFC 6116 3.2).
+const DefaultENUMSuffix = "e164.arpa"
+
+// LookupENUM the SIP URI of number, an E.164 number with a leading +, by the NAPTR records of its domain
+// under each suffix in turn (RFC 6116, RFC 3764), e.g. +1-201-555-0123 looked up as
+// 3.2.1.0.5.5.5.1.0.2.1.e164.arpa. Only the terminal E2U+sip records are used.
+func (s *SipStack) LookupENUM(ctx context.Context, number string, suffixes ...string) (sip.Uri, error) {
+	aus, ok := enumNumber(number)
+	if !ok {
+		return nil, fmt.Errorf("%s not an E.164 number", number)
+	}
+	if len(suffixes) == 0 {
+		suffixes = []string{DefaultENUMSuffix}
+	}
+	var lastErr error
+	for _, suffix := range suffixes {
+		name := enumDomain(aus, suffix)
+		records, err := s.tp.resolver.dns.LookupNAPTR(ctx, name)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		uri, err := enumURI(records, aus)
+		if err == nil {
+			return uri, nil
+		}
+		lastErr = fmt.Errorf("ENUM %s: %w", name, err)
+	}
+	return nil, lastErr
+}
+
+// enumNumber the application unique string of number (RFC 6116 2.4), its digits with the leading +.
+func enumNumber(number string) (string, bool) {
+	if !strings.HasPrefix(number, "+") {
+		return "", false
+	}
+	var digits strings.Builder
+	digits.WriteByte('+')
+	for _, c := range number[1:] {
+		switch {
+		case c >= '0' && c <= '9':
+			digits.WriteRune(c)
+		case strings.ContainsRune("-.() ", c):
+		default:
+			return "", false
+		}
+	}
+	return digits.String(), digits.Len() > 1
+}
+
+// enumDomain the digits of aus reversed, separated by dots, under suffix.
+func enumDomain(aus string, suffix string) string {
+	digits := aus[1:]
+	labels := make([]string, 0, len(digits)+1)
+	for i := len(digits) - 1; i >= 0; i-- {
+		labels = append(labels, digits[i:i+1])
+	}
+	labels = append(labels, strings.Trim(suffix, "."))
+	return strings.Join(labels, ".") + "."
+}
+
+// enumURI the URI of the first E2U+sip record of records by order and preference its regexp rewrites aus to.
+func enumURI(records []*NAPTR, aus string) (sip.Uri, error) {
+	sorted := append([]*NAPTR{}, records...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].Order != sorted[j].Order {
+			return sorted[i].Order < sorted[j].Order
+		}
+		return sorted[i].Preference < sorted[j].Preference
+	})
+	for _, record := range sorted {
+		if !strings.EqualFold(record.Flags, "u") || !isSIPEnumService(record.Service) {
+			continue
+		}
+		rewritten, err := rewriteNAPTR(record.Regexp, aus)
+		if err != nil {
+			continue
+		}
+		uri, err := parser.ParseUri(rewritten)
+		if err != nil {
+			continue
+		}
+		if _, ok := uri.(*sip.SipUri); ok {
+			return uri, nil
+		}
+	}
+	return nil, fmt.Errorf("no E2U+sip record")
+}
+
+// isSIPEnumService whether service is the E2U service of the sip Enumservice, e.g. E2U+sip.
+func isSIPEnumService(service string) bool {
+	fields := strings.Split(strings.ToLower(service), "+")
+	if len(fields) < 2 || fields[0] != "e2u" {
+		return false
+	}
+	for _, field := range fields[1:] {
+		if i := strings.IndexByte(field, ':'); i >= 0 {
+			field = field[:i]
+		}
+		if field == "sip" {
+			return true
+		}
+	}
+	return false
+}
+
+// rewriteNAPTR applies the substitution expression of a NAPTR record, e.g. !^.*$!sip:info@example.com!,
+// to aus (RFC 3402 3.2): its \1 to \9 the groups of the match, \\ a backslash.
+func rewriteNAPTR(expression string, aus string) (string, error) {
+	if len(expression) < 3 {
+		return "", fmt.Errorf("short NAPTR regexp %q", expression)
+	}
+	delim := expression[0]
+	parts := splitUnescaped(expression[1:], delim)
+	if len(parts) != 3 {
+		return "", fmt.Errorf("malformed NAPTR regexp %q", expression)
+	}
+	pattern := parts[0]
+	if parts[2] == "i" {
+		pattern = "(?i)" + pattern
+	} else if parts[2] != "" {
+		return "", fmt.Errorf("unknown NAPTR regexp flags %q", parts[2])
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return "", err
+	}
+	match := re.FindStringSubmatchIndex(aus)
+	if match == nil {
+		return "", fmt.Errorf("NAPTR regexp %q does not match %s", expression, aus)
+	}
+
+	var b strings.Builder
+	replacement := parts[1]
+	for i := 0; i < len(replacement); i++ {
+		c := replacement[i]
+		if c != '\\' || i+1 == len(replacement) {
+			b.WriteByte(c)
+			continue
+		}
+		i++
+		next := replacement[i]
+		if next >= '1' && next <= '9' {
+			group := int(next - '0')
+			if 2*group+1 < len(match) && match[2*group] >= 0 {
+				b.WriteString(aus[match[2*group]:match[2*group+1]])
+			}
+			continue
+		}
+		b.WriteByte(next)
+	}
+	return b.String(), nil
+}
+
+// splitUnescaped s at the delim not escaped by a backslash, the escaping backslash removed.
+func splitUnescaped(s string, delim byte) []string {
+	var parts []string
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\' && i+1 < len(s) && s[i+1] == delim:
+			b.WriteByte(delim)
+			i++
+		case s[i] == delim:
+			parts = append(parts, b.String())
+			b.Reset()
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return append(parts, b.String())
+}
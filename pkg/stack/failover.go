@@ -0,0 +1,189 @@
+package stack
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/ghettovoice/gosip/sip"
+
+	"github.com/sergeyu/go-sip-ua/pkg/transaction"
+)
+
+// failoverTx a client transaction retried on the next server of the request on a timeout or 503 (RFC 3263 4.3),
+// then on the next transport of the failover chain on a timeout. The last server is tried again once after the
+// Retry-After of its 503, see SipStackConfig.MaxRetryAfter.
+type failoverTx struct {
+	s *SipStack
+	// key the key of the request for the shutdown, ended with the final response passed up.
+	key      sip.TransactionKey
+	origin   sip.Request
+	switched bool
+	// retriedLater the last server sent the request again after the Retry-After of its 503.
+	retriedLater bool
+	tx           sip.ClientTransaction
+	mu           sync.RWMutex
+	responses    chan sip.Response
+	errs         chan error
+	done         chan bool
+	// canceled is closed by Cancel, the request not retried anymore.
+	canceled   chan struct{}
+	cancelOnce sync.Once
+}
+
+func newFailoverTx(s *SipStack, origin sip.Request, tx sip.ClientTransaction) *failoverTx {
+	ftx := &failoverTx{
+		s:         s,
+		key:       sip.TransactionKey("failover__" + tx.Key().String()),
+		origin:    origin,
+		tx:        tx,
+		responses: make(chan sip.Response),
+		errs:      make(chan error),
+		done:      make(chan bool),
+		canceled:  make(chan struct{}),
+	}
+	go ftx.serve()
+	return ftx
+}
+
+func (ftx *failoverTx) current() sip.ClientTransaction {
+	ftx.mu.RLock()
+	defer ftx.mu.RUnlock()
+	return ftx.tx
+}
+
+func (ftx *failoverTx) Origin() sip.Request {
+	ftx.mu.RLock()
+	defer ftx.mu.RUnlock()
+	return ftx.origin
+}
+
+func (ftx *failoverTx) Key() sip.TransactionKey {
+	return ftx.current().Key()
+}
+
+func (ftx *failoverTx) String() string {
+	return ftx.current().String()
+}
+
+func (ftx *failoverTx) Errors() <-chan error {
+	return ftx.errs
+}
+
+func (ftx *failoverTx) Done() <-chan bool {
+	return ftx.done
+}
+
+func (ftx *failoverTx) Responses() <-chan sip.Response {
+	return ftx.responses
+}
+
+func (ftx *failoverTx) Cancel() error {
+	ftx.cancelOnce.Do(func() {
+		close(ftx.canceled)
+	})
+	return ftx.current().Cancel()
+}
+
+func (ftx *failoverTx) serve() {
+	defer func() {
+		close(ftx.responses)
+		close(ftx.errs)
+		close(ftx.done)
+	}()
+
+	for tx := ftx.current(); tx != nil; {
+		tx = ftx.forward(tx)
+	}
+}
+
+// forward passes up the responses and errors of tx, returns the transaction of the next server on a failover.
+func (ftx *failoverTx) forward(tx sip.ClientTransaction) sip.ClientTransaction {
+	responses, errs := tx.Responses(), tx.Errors()
+	for responses != nil || errs != nil {
+		select {
+		case response, ok := <-responses:
+			if !ok {
+				responses = nil
+				continue
+			}
+			if response.StatusCode() == 503 {
+				if next := ftx.retry(); next != nil {
+					return next
+				}
+				if next := ftx.retryLater(response); next != nil {
+					return next
+				}
+			}
+			if ftx.switched && response.StatusCode() >= 200 {
+				ftx.s.Log().Infof("%s got %d over %s", ftx.Origin().Short(), response.StatusCode(), ftx.Origin().Transport())
+			}
+			ftx.responses <- response
+			if response.StatusCode() >= 200 {
+				ftx.s.txs.end(ftx.key, response)
+			}
+		case err, ok := <-errs:
+			if !ok {
+				errs = nil
+				continue
+			}
+			var timeout *transaction.TxTimeoutError
+			if errors.As(err, &timeout) {
+				if next := ftx.retry(); next != nil {
+					return next
+				}
+				if next := ftx.switchTransport(); next != nil {
+					return next
+				}
+			}
+			ftx.errs <- err
+		}
+	}
+	return nil
+}
+
+// retry sends the request to the next server in a new transaction.
+func (ftx *failoverTx) retry() sip.ClientTransaction {
+	hops, ok := ftx.s.tp.failover(ftx.origin)
+	if !ok {
+		return nil
+	}
+
+	ftx.s.Log().Infof("%s failed, trying %s", ftx.origin.Short(), hops[0].Addr())
+	// A new transaction needs a new branch.
+	if viaHop, ok := ftx.origin.ViaHop(); ok {
+		viaHop.Params.Add("branch", sip.String{Str: ftx.s.NewBranch()})
+	}
+	ftx.s.tp.pin(ftx.origin, hops)
+	tx, err := ftx.s.tx.Request(ftx.origin)
+	if err != nil {
+		ftx.s.Log().Warnf("%s failed on all servers: %s", ftx.origin.Short(), err)
+		return nil
+	}
+	ftx.mu.Lock()
+	ftx.tx = tx
+	ftx.mu.Unlock()
+	return tx
+}
+
+// switchTransport sends the request over the next transport of the failover chain in a new transaction.
+func (ftx *failoverTx) switchTransport() sip.ClientTransaction {
+	origin := ftx.Origin()
+	for {
+		req, ok := ftx.s.switchTransport(origin)
+		if !ok {
+			return nil
+		}
+		origin = req
+		tx, err := ftx.s.tx.Request(req)
+		if err != nil {
+			ftx.s.Log().Warnf("send %s over %s failed: %s", req.Short(), req.Transport(), err)
+			continue
+		}
+		ftx.mu.Lock()
+		ftx.origin = req
+		ftx.switched = true
+		ftx.tx = tx
+		ftx.mu.Unlock()
+		return tx
+	}
+}
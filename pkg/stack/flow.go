@@ -0,0 +1,338 @@
+package stack
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// pongTimeout how long a keepalive waits for the pong (RFC 5626 4.4.1).
+	pongTimeout = 10 * time.Second
+	// maxFlowLine the longest header line buffered, a longer one is passed up in parts.
+	maxFlowLine = 8192
+)
+
+// The framing states of a flowConn.
+const (
+	flowBoundary = iota
+	flowHeaders
+	flowBody
+)
+
+var (
+	crlfPing = []byte("\r\n\r\n")
+	crlfPong = []byte("\r\n")
+)
+
+// keepAliver a protocol sending CRLF keepalives.
+type keepAliver interface {
+	keepAlive(raddr string) error
+}
+
+// Flow a connection to a peer kept alive by CRLF keepalives (RFC 5626 4.4).
+type Flow struct {
+	Network  string
+	Addr     string
+	pongs    chan struct{}
+	failed   chan struct{}
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// Failed is closed when the peer did not answer a keepalive.
+func (f *Flow) Failed() <-chan struct{} {
+	return f.failed
+}
+
+// Stopped is closed when the keepalives are stopped.
+func (f *Flow) Stopped() <-chan struct{} {
+	return f.stop
+}
+
+// Stop the keepalives.
+func (f *Flow) Stop() {
+	f.stopOnce.Do(func() {
+		close(f.stop)
+	})
+}
+
+// flowTable the kept alive flows by network and remote address, the pongs are received by the transports.
+type flowTable struct {
+	flows sync.Map
+}
+
+func flowKey(network string, raddr string) string {
+	return strings.ToUpper(network) + ":" + raddr
+}
+
+func (t *flowTable) pong(network string, raddr string) {
+	if v, ok := t.flows.Load(flowKey(network, raddr)); ok {
+		select {
+		case v.(*Flow).pongs <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// KeepAlive sends a CRLF keepalive on the flow to addr every interval until the flow is stopped,
+// the flow fails when the connection is lost or the pong is missing.
+func (s *SipStack) KeepAlive(network string, addr string, interval time.Duration) *Flow {
+	f := &Flow{
+		Network: strings.ToUpper(network),
+		Addr:    addr,
+		pongs:   make(chan struct{}, 1),
+		failed:  make(chan struct{}),
+		stop:    make(chan struct{}),
+	}
+	key := flowKey(network, addr)
+	if v, loaded := s.flows.flows.LoadOrStore(key, f); loaded {
+		// One keepalive per flow.
+		v.(*Flow).Stop()
+		s.flows.flows.Store(key, f)
+	}
+	go s.keepAlive(f, interval)
+	return f
+}
+
+func (s *SipStack) keepAlive(f *Flow, interval time.Duration) {
+	key := flowKey(f.Network, f.Addr)
+	defer func() {
+		if v, ok := s.flows.flows.Load(key); ok && v == f {
+			s.flows.flows.Delete(key)
+		}
+	}()
+
+	timeout := pongTimeout
+	if interval < timeout {
+		timeout = interval
+	}
+	ticker := s.Clock().NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-f.stop:
+			return
+		case <-s.tp.Done():
+			return
+		case <-ticker.C():
+		}
+
+		if err := s.ping(f); err != nil {
+			s.Log().Warnf("keepalive of %s flow to %s failed: %s", f.Network, f.Addr, err)
+			close(f.failed)
+			return
+		}
+		timer := s.Clock().NewTimer(timeout)
+		select {
+		case <-f.pongs:
+			timer.Stop()
+		case <-f.stop:
+			timer.Stop()
+			return
+		case <-timer.C():
+			s.Log().Warnf("no pong on %s flow to %s", f.Network, f.Addr)
+			close(f.failed)
+			return
+		}
+	}
+}
+
+func (s *SipStack) ping(f *Flow) error {
+	protocol, ok := s.tp.protocol(f.Network)
+	if !ok {
+		return fmt.Errorf("no %s transport", f.Network)
+	}
+	p, ok := protocol.(keepAliver)
+	if !ok {
+		return fmt.Errorf("no keepalive on %s transport", f.Network)
+	}
+	return p.keepAlive(f.Addr)
+}
+
+// isCRLF reports whether b has only CR and LF, a keepalive.
+func isCRLF(b []byte) bool {
+	for _, ch := range b {
+		if ch != '\r' && ch != '\n' {
+			return false
+		}
+	}
+	return len(b) > 0
+}
+
+// flowConn a stream connection of a flow. The CRLF keepalives between the messages are answered
+// and not passed up to the SIP parser, it only handles messages.
+type flowConn struct {
+	net.Conn
+	network   string
+	flows     *flowTable
+	manager   *connManager
+	stats     *stats
+	guard     *guard
+	closeOnce sync.Once
+	state     int
+	crlf      int
+	line      []byte
+	long      bool
+	body      int
+	// msg the message framed so far, passed up when it is whole.
+	msg []byte
+	// drop the rest of an oversized message is skipped.
+	drop bool
+	// out the filtered bytes not read yet.
+	out []byte
+}
+
+func (c *flowConn) RemoteAddr() net.Addr {
+	return &netAddr{Addr: c.Conn.RemoteAddr(), network: strings.ToLower(c.network)}
+}
+
+func (c *flowConn) Close() error {
+	err := c.Conn.Close()
+	c.closeOnce.Do(func() {
+		c.manager.closed(c.network, c.Conn)
+	})
+	return err
+}
+
+func (c *flowConn) Read(b []byte) (int, error) {
+	for len(c.out) == 0 {
+		n, err := c.Conn.Read(b)
+		if n > 0 {
+			c.filter(b[:n])
+		}
+		if err != nil && len(c.out) == 0 {
+			return 0, err
+		}
+	}
+	n := copy(b, c.out)
+	c.out = c.out[n:]
+	return n, nil
+}
+
+// filter drops the CR and LF between the messages, by the Content-Length of each one.
+// The header lines are passed up whole with their IPv6 references encoded, and each message
+// once it is whole and checked by the guard.
+func (c *flowConn) filter(b []byte) {
+	for i := 0; i < len(b); i++ {
+		ch := b[i]
+		switch c.state {
+		case flowBoundary:
+			if ch == '\r' || ch == '\n' {
+				c.crlf++
+				continue
+			}
+			c.keepAlive()
+			c.stats.messageFramed(c.network)
+			c.state = flowHeaders
+			c.line = c.line[:0]
+			c.long = false
+			c.body = 0
+			c.msg = c.msg[:0]
+			c.drop = false
+			c.header(ch)
+		case flowHeaders:
+			c.header(ch)
+		case flowBody:
+			n := len(b) - i
+			if n > c.body {
+				n = c.body
+			}
+			if !c.drop {
+				c.msg = append(c.msg, b[i:i+n]...)
+			}
+			c.body -= n
+			i += n - 1
+			if c.body <= 0 {
+				c.end()
+			}
+		}
+	}
+	if c.state == flowBoundary {
+		c.keepAlive()
+	}
+}
+
+// add data to the header section, an oversized one is dropped.
+func (c *flowConn) add(data []byte) {
+	if c.drop {
+		return
+	}
+	if len(c.msg)+len(data) > c.guard.maxHeader {
+		c.guard.oversized(c.network, len(c.msg)+len(data))
+		c.drop = true
+		c.msg = c.msg[:0]
+		return
+	}
+	c.msg = append(c.msg, data...)
+}
+
+// end passes up the message framed.
+func (c *flowConn) end() {
+	c.state = flowBoundary
+	if c.drop {
+		return
+	}
+	if _, err := c.guard.parse(c.msg); err != nil {
+		c.guard.log.Warnf("drop malformed %s message: %s", c.network, err)
+		return
+	}
+	c.out = append(c.out, c.msg...)
+}
+
+func (c *flowConn) header(ch byte) {
+	c.line = append(c.line, ch)
+	if ch != '\n' {
+		if len(c.line) >= maxFlowLine {
+			c.add(c.line)
+			c.line = c.line[:0]
+			c.long = true
+		}
+		return
+	}
+	if c.long {
+		c.add(c.line)
+		c.line = c.line[:0]
+		c.long = false
+		return
+	}
+	c.add(encodeIPv6(c.line))
+	line := strings.TrimRight(string(c.line), "\r\n")
+	c.line = c.line[:0]
+	if len(line) == 0 {
+		if !c.drop && len(c.msg)+c.body > c.guard.maxMessage {
+			c.guard.oversized(c.network, len(c.msg)+c.body)
+			if res := c.guard.tooLarge(c.msg); res != nil {
+				c.Conn.Write(res)
+			}
+			c.drop = true
+		}
+		if c.body > 0 {
+			c.state = flowBody
+		} else {
+			c.end()
+		}
+		return
+	}
+	if i := strings.IndexByte(line, ':'); i > 0 {
+		switch strings.ToLower(strings.TrimSpace(line[:i])) {
+		case "content-length", "l":
+			c.body, _ = strconv.Atoi(strings.TrimSpace(line[i+1:]))
+		}
+	}
+}
+
+// keepAlive answers a ping, a double CRLF, or passes up a pong, a single one.
+func (c *flowConn) keepAlive() {
+	switch {
+	case c.crlf >= len(crlfPing):
+		c.Conn.Write(crlfPong)
+	case c.crlf >= len(crlfPong):
+		c.flows.pong(c.network, c.Conn.RemoteAddr().String())
+	}
+	c.crlf = 0
+}
@@ -0,0 +1,305 @@
+package stack
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/ghettovoice/gosip/log"
+	"github.com/ghettovoice/gosip/sip"
+	"github.com/ghettovoice/gosip/sip/parser"
+)
+
+const (
+	// DefaultMaxMessageSize the largest message received, the largest UDP datagram read.
+	DefaultMaxMessageSize = 65535
+	// DefaultMaxHeaderSize the largest header section received, the start line and the headers.
+	DefaultMaxHeaderSize = 16384
+)
+
+// guard the limits of the messages received, checked by the transports before a message is passed to the parser.
+// The parser runs in its own goroutine where a panic takes down the stack, so the start line and the headers
+// are parsed once here first where it is recovered.
+type guard struct {
+	maxMessage int
+	maxHeader  int
+	headers    parser.Parser
+	stats      *stats
+	log        log.Logger
+}
+
+func newGuard(maxMessage int, maxHeader int, stats *stats, logger log.Logger) *guard {
+	if maxMessage <= 0 {
+		maxMessage = DefaultMaxMessageSize
+	}
+	if maxHeader <= 0 {
+		maxHeader = DefaultMaxHeaderSize
+	}
+	if maxHeader > maxMessage {
+		maxHeader = maxMessage
+	}
+	// Only its header parsers are used.
+	headers := parser.NewParser(make(chan sip.Message), make(chan error), true, logger)
+	headers.Stop()
+	return &guard{
+		maxMessage: maxMessage,
+		maxHeader:  maxHeader,
+		headers:    headers,
+		stats:      stats,
+		log:        logger,
+	}
+}
+
+// headerSize the size of the header section of data, -1 if it is not complete.
+func headerSize(data []byte) int {
+	i := bytes.Index(data, []byte("\r\n\r\n"))
+	if i < 0 {
+		return -1
+	}
+	return i + 4
+}
+
+// check reports whether a message received whole, a UDP datagram, may be passed to the parser,
+// returns the 513 answering an oversized request.
+func (g *guard) check(network string, data []byte) (bool, []byte) {
+	size := headerSize(data)
+	switch {
+	case size > g.maxHeader || size < 0 && len(data) > g.maxHeader:
+		g.oversized(network, len(data))
+		return false, nil
+	case len(data) > g.maxMessage:
+		g.oversized(network, len(data))
+		return false, g.tooLarge(data[:size])
+	}
+	msg, err := g.parse(data)
+	if err != nil {
+		g.log.Warnf("drop malformed %s message: %s", network, err)
+		return false, nil
+	}
+	// The parser of the socket reads the lines to their LF but finds the body after the first CRLFCRLF,
+	// a message it reads otherwise is parsed with the next one received.
+	if size < 0 {
+		g.log.Warnf("drop malformed %s message: no end of headers", network)
+		return false, nil
+	}
+	if i := bareLineEnd(data[:size]); i >= 0 {
+		g.log.Warnf("drop malformed %s message: CR or LF not in CRLF at %d", network, i)
+		return false, nil
+	}
+	if reason, code := malformedMessage(msg, data, size); reason != "" {
+		g.log.Warnf("drop malformed %s message: %s", network, reason)
+		return false, g.reject(data[:size], code)
+	}
+	return true, nil
+}
+
+// malformedMessage why the message of data, msg as parsed by the guard, is not to be passed to the parser
+// and the code of the response rejecting it if a request, empty if it is to be passed (RFC 4475 3.1.2):
+// a 400 for a start line the parser fails on, a 416 for a Request-URI of a scheme it does not know, a 505
+// for a version other than 2.0, a 400 for the headers of a request the transactions and the dialogs
+// depend on, see malformedRequest, for a URI with headers not in angle brackets, or for a Content-Length
+// larger than the body of the datagram.
+func malformedMessage(msg sip.Message, data []byte, size int) (string, sip.StatusCode) {
+	if msg == nil {
+		line := headerLines(data)[0]
+		if strings.HasPrefix(line, "SIP/") {
+			return fmt.Sprintf("malformed status line %q", line), 0
+		}
+		// The parser only knows the URIs of the sip and sips schemes.
+		if parts := strings.Split(line, " "); len(parts) == 3 && uriScheme.MatchString(parts[1]) {
+			scheme := strings.ToLower(parts[1][:strings.IndexByte(parts[1], ':')])
+			if scheme != "sip" && scheme != "sips" {
+				return fmt.Sprintf("Request-URI of unknown scheme %s", scheme), 416
+			}
+		}
+		return fmt.Sprintf("malformed request line %q", line), 400
+	}
+	if msg.SipVersion() != "SIP/2.0" {
+		return fmt.Sprintf("version %s not supported", msg.SipVersion()), 505
+	}
+	req, ok := msg.(sip.Request)
+	if !ok {
+		return "", 0
+	}
+	if reason := malformedRequest(req); reason != "" {
+		return reason, 400
+	}
+	if name := bareURIWithHeaders(unfold(headerLines(data)[1:])); name != "" {
+		return fmt.Sprintf("URI with headers not in angle brackets in %s header", name), 400
+	}
+	if length, ok := req.ContentLength(); ok && int(*length) > len(data)-size {
+		return fmt.Sprintf("Content-Length %d larger than the body of %d bytes", *length, len(data)-size), 400
+	}
+	return "", 0
+}
+
+// uriScheme a URI of a scheme (RFC 3261 25.1).
+var uriScheme = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9+.-]*:[^\s<>]+$`)
+
+// bareLineEnd the offset of the first CR or LF of head not in a CRLF, -1 if none.
+func bareLineEnd(head []byte) int {
+	for i, c := range head {
+		switch {
+		case c == '\r' && (i+1 == len(head) || head[i+1] != '\n'):
+			return i
+		case c == '\n' && (i == 0 || head[i-1] != '\r'):
+			return i
+		}
+	}
+	return -1
+}
+
+func (g *guard) oversized(network string, size int) {
+	g.log.Warnf("drop oversized %s message of %d bytes", network, size)
+	g.stats.messageOversized(network)
+}
+
+// parse the start line and the headers of data, a panic of the parser is returned as an error.
+// The errors of the parser itself are left to it.
+func (g *guard) parse(data []byte) (msg sip.Message, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			msg, err = nil, fmt.Errorf("parser panic: %v", r)
+		}
+	}()
+
+	lines := headerLines(data)
+	startLine := lines[0]
+	if strings.HasPrefix(startLine, "SIP/") {
+		version, code, reason, err := parser.ParseStatusLine(startLine)
+		if err != nil {
+			return nil, nil
+		}
+		msg = sip.NewResponse("", version, code, reason, []sip.Header{}, "", nil)
+	} else {
+		method, recipient, version, err := parser.ParseRequestLine(startLine)
+		if err != nil {
+			return nil, nil
+		}
+		msg = sip.NewRequest("", method, recipient, version, []sip.Header{}, "", nil)
+	}
+	g.appendHeaders(msg, lines[1:])
+	return msg, nil
+}
+
+// headerLines the start line and the header lines of data.
+func headerLines(data []byte) []string {
+	head := string(data)
+	if size := headerSize(data); size >= 0 {
+		head = head[:size-4]
+	}
+	return strings.Split(head, "\r\n")
+}
+
+// appendHeaders the headers of lines parsed to msg, the ones failing to parse skipped as by the parser.
+func (g *guard) appendHeaders(msg sip.Message, lines []string) {
+	for _, header := range unfold(lines) {
+		if hdrs, err := g.headers.ParseHeader(header); err == nil {
+			for _, h := range hdrs {
+				msg.AppendHeader(h)
+			}
+		}
+	}
+}
+
+// unfold the headers of the header lines, the folded lines joined as the parser does.
+func unfold(lines []string) []string {
+	var headers []string
+	var header string
+	for _, line := range lines {
+		if len(line) > 0 && (line[0] == ' ' || line[0] == '\t') {
+			if len(header) > 0 {
+				header += " " + line
+			}
+			continue
+		}
+		if len(header) > 0 {
+			headers = append(headers, header)
+		}
+		header = line
+	}
+	if len(header) > 0 {
+		headers = append(headers, header)
+	}
+	return headers
+}
+
+// bareURIWithHeaders the name of the first From, To or Contact header of a URI with headers not in angle
+// brackets, empty if none: they are not allowed as they would be taken for the ones of the message (RFC
+// 3261 20, 20.10). The parser takes them for the ones of the URI.
+func bareURIWithHeaders(headers []string) string {
+	for _, header := range headers {
+		i := strings.IndexByte(header, ':')
+		if i < 0 {
+			continue
+		}
+		name := strings.TrimSpace(header[:i])
+		switch strings.ToLower(name) {
+		case "from", "f", "to", "t", "contact", "m":
+		default:
+			continue
+		}
+		if value := header[i+1:]; !strings.Contains(value, "<") && strings.Contains(value, "?") {
+			return name
+		}
+	}
+	return ""
+}
+
+// tooLarge the 513 answering the request of the header section head, nil if it is not a request to answer.
+func (g *guard) tooLarge(head []byte) []byte {
+	msg, err := g.parse(head)
+	if err != nil || msg == nil {
+		return nil
+	}
+	req, ok := msg.(sip.Request)
+	if !ok || req.IsAck() {
+		return nil
+	}
+	if _, ok := req.ViaHop(); !ok {
+		return nil
+	}
+	res := sip.NewResponseFromRequest("", req, 513, "Message Too Large", "")
+	return []byte(res.String())
+}
+
+// reject the response of code rejecting the request of the header section head, of the headers parsed if
+// its start line is not, nil if it is not a request to answer. It is sent back to the source of the
+// request as the Via of a malformed request may not be.
+func (g *guard) reject(head []byte, code sip.StatusCode) []byte {
+	if code == 0 {
+		return nil
+	}
+	req, ok := g.parseLenient(head).(sip.Request)
+	if !ok || req.IsAck() {
+		return nil
+	}
+	res := sip.NewResponseFromRequest("", req, code, statusReasons[code], "")
+	res.SetSipVersion("SIP/2.0")
+	return []byte(res.String())
+}
+
+// statusReasons the reasons of the responses of the guard.
+var statusReasons = map[sip.StatusCode]string{
+	400: "Bad Request",
+	416: "Unsupported URI Scheme",
+	505: "Version Not Supported",
+}
+
+// parseLenient as parse, a request of the method of the start line of data if it fails to parse, e.g. to
+// answer it, nil if none.
+func (g *guard) parseLenient(data []byte) sip.Message {
+	msg, err := g.parse(data)
+	if err != nil || msg != nil {
+		return msg
+	}
+	lines := headerLines(data)
+	fields := strings.Fields(lines[0])
+	if len(fields) == 0 || strings.HasPrefix(fields[0], "SIP/") {
+		return nil
+	}
+	msg = sip.NewRequest("", sip.RequestMethod(strings.ToUpper(fields[0])), &sip.SipUri{}, "SIP/2.0", []sip.Header{}, "", nil)
+	g.appendHeaders(msg, lines[1:])
+	return msg
+}
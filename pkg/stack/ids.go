@@ -0,0 +1,47 @@
+package stack
+
+import (
+	"strings"
+
+	"github.com/ghettovoice/gosip/sip"
+	"github.com/ghettovoice/gosip/util"
+)
+
+// IDGenerator the generators of the identifiers of the requests, e.g. to embed a tenant or a shard in
+// them for tracing, or to use UUIDv7. The identifiers of the ones nil are random.
+type IDGenerator struct {
+	// CallID the Call-ID of a new dialog or registration.
+	CallID func() string
+	// Branch the Via branch of a new transaction, prefixed with the magic cookie z9hG4bK if it is not.
+	Branch func() string
+	// Tag the From tag of a request out of a dialog, and the To tag of a dialog answered.
+	Tag func() string
+}
+
+// NewCallID a new Call-ID, see SipStackConfig.IDs.
+func (s *SipStack) NewCallID() sip.CallID {
+	if s.config.IDs.CallID != nil {
+		return sip.CallID(s.config.IDs.CallID())
+	}
+	return sip.CallID(util.RandString(32))
+}
+
+// NewBranch a new Via branch, see SipStackConfig.IDs.
+func (s *SipStack) NewBranch() string {
+	if s.config.IDs.Branch != nil {
+		branch := s.config.IDs.Branch()
+		if !strings.HasPrefix(branch, sip.RFC3261BranchMagicCookie) {
+			branch = sip.RFC3261BranchMagicCookie + branch
+		}
+		return branch
+	}
+	return sip.GenerateBranch()
+}
+
+// NewTag a new From or To tag, see SipStackConfig.IDs.
+func (s *SipStack) NewTag() string {
+	if s.config.IDs.Tag != nil {
+		return s.config.IDs.Tag()
+	}
+	return util.RandString(8)
+}
@@ -0,0 +1,55 @@
+package stack
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/ghettovoice/gosip/sip"
+)
+
+// ErrDropped a message not sent, dropped by an outgoing interceptor.
+var ErrDropped = errors.New("message dropped by an interceptor")
+
+// Interceptor inspects or changes a message received or sent by the stack, e.g. to normalize headers,
+// hide the topology, log or enforce a policy. It returns the message passed on, nil to drop it. The
+// interceptors are called in the transport path and must not block it.
+type Interceptor func(msg sip.Message) sip.Message
+
+// interceptors the interceptors of the stack in the order they were added.
+type interceptors struct {
+	mu       sync.RWMutex
+	incoming []Interceptor
+	outgoing []Interceptor
+}
+
+// InterceptIncoming adds an interceptor of the requests and responses received, before the transactions.
+// A request dropped is not answered.
+func (s *SipStack) InterceptIncoming(interceptor Interceptor) {
+	s.interceptors.mu.Lock()
+	defer s.interceptors.mu.Unlock()
+	s.interceptors.incoming = append(s.interceptors.incoming, interceptor)
+}
+
+// InterceptOutgoing adds an interceptor of the requests and responses sent, with the headers of the
+// stack, before the transport. A message dropped fails with ErrDropped.
+func (s *SipStack) InterceptOutgoing(interceptor Interceptor) {
+	s.interceptors.mu.Lock()
+	defer s.interceptors.mu.Unlock()
+	s.interceptors.outgoing = append(s.interceptors.outgoing, interceptor)
+}
+
+// intercept passes msg through the interceptors of a direction, nil if one dropped it.
+func (i *interceptors) intercept(outgoing bool, msg sip.Message) sip.Message {
+	i.mu.RLock()
+	chain := i.incoming
+	if outgoing {
+		chain = i.outgoing
+	}
+	i.mu.RUnlock()
+	for _, interceptor := range chain {
+		if msg = interceptor(msg); msg == nil {
+			return nil
+		}
+	}
+	return msg
+}
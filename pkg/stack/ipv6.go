@@ -0,0 +1,115 @@
+package stack
+
+import (
+	"bytes"
+	"net"
+	"regexp"
+	"strings"
+
+	"github.com/ghettovoice/gosip/sip"
+)
+
+// ipv6Suffix the domain of the IPv6 references passed to the gosip parser, it does not parse "[2001:db8::1]:5060".
+// The bracketed literals of the headers are encoded into "2001-db8--1.ipv6.invalid" before the parsing
+// and decoded back in the parsed message.
+const ipv6Suffix = ".ipv6.invalid"
+
+var (
+	ipv6Reference = regexp.MustCompile(`\[[0-9A-Fa-f:.]+\]`)
+	ipv6Encoded   = regexp.MustCompile(`[0-9A-Fa-f.-]+` + regexp.QuoteMeta(ipv6Suffix))
+)
+
+// encodeIPv6 encodes the IPv6 references of a start line or of the headers.
+func encodeIPv6(b []byte) []byte {
+	if bytes.IndexByte(b, '[') < 0 {
+		return b
+	}
+	return ipv6Reference.ReplaceAllFunc(b, func(ref []byte) []byte {
+		literal := string(ref[1 : len(ref)-1])
+		if !strings.Contains(literal, ":") || net.ParseIP(literal) == nil {
+			return ref
+		}
+		return []byte(strings.ReplaceAll(literal, ":", "-") + ipv6Suffix)
+	})
+}
+
+// encodeIPv6Message encodes the IPv6 references of the headers of a datagram.
+func encodeIPv6Message(b []byte) []byte {
+	end := bytes.Index(b, []byte("\r\n\r\n"))
+	if end < 0 {
+		end = len(b)
+	}
+	head := encodeIPv6(b[:end])
+	if len(head) == end {
+		return b
+	}
+	return append(head, b[end:]...)
+}
+
+// decodeIPv6Host the bracketed literal of an encoded host.
+func decodeIPv6Host(host string) string {
+	if !strings.HasSuffix(host, ipv6Suffix) {
+		return host
+	}
+	return "[" + strings.ReplaceAll(strings.TrimSuffix(host, ipv6Suffix), "-", ":") + "]"
+}
+
+func decodeIPv6String(s string) string {
+	if !strings.Contains(s, ipv6Suffix) {
+		return s
+	}
+	return ipv6Encoded.ReplaceAllStringFunc(s, decodeIPv6Host)
+}
+
+func decodeIPv6URI(uri sip.Uri) {
+	if uri != nil {
+		if host := uri.Host(); strings.HasSuffix(host, ipv6Suffix) {
+			uri.SetHost(decodeIPv6Host(host))
+		}
+	}
+}
+
+// decodeIPv6 restores the IPv6 references of a parsed message.
+func decodeIPv6(msg sip.Message) sip.Message {
+	if req, ok := msg.(sip.Request); ok {
+		decodeIPv6URI(req.Recipient())
+	}
+	for _, header := range msg.Headers() {
+		switch h := header.(type) {
+		case sip.ViaHeader:
+			for _, hop := range h {
+				hop.Host = decodeIPv6Host(hop.Host)
+			}
+		case *sip.ToHeader:
+			decodeIPv6URI(h.Address)
+		case *sip.FromHeader:
+			decodeIPv6URI(h.Address)
+		case *sip.ContactHeader:
+			decodeIPv6URI(h.Address)
+		case *sip.RouteHeader:
+			for _, uri := range h.Addresses {
+				decodeIPv6URI(uri)
+			}
+		case *sip.RecordRouteHeader:
+			for _, uri := range h.Addresses {
+				decodeIPv6URI(uri)
+			}
+		case *sip.CallID:
+			*h = sip.CallID(decodeIPv6String(string(*h)))
+		case *sip.GenericHeader:
+			h.Contents = decodeIPv6String(h.Contents)
+		}
+	}
+	return msg
+}
+
+// ipv6Mapper decodes the IPv6 references before msgMapper.
+func ipv6Mapper(msgMapper sip.MessageMapper) sip.MessageMapper {
+	return func(msg sip.Message) sip.Message {
+		msg = decodeIPv6(msg)
+		if msgMapper != nil {
+			msg = msgMapper(msg)
+		}
+		return msg
+	}
+}
@@ -0,0 +1,804 @@
+package stack
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ghettovoice/gosip/log"
+	"github.com/ghettovoice/gosip/sip"
+	"github.com/ghettovoice/gosip/transport"
+)
+
+// layer the transport layer of the stack, same as the gosip one but the protocols
+// are created by the stack, so each stack has its own TLS settings.
+type layer struct {
+	protocols map[string]transport.Protocol
+	pmu       sync.RWMutex
+	listeners map[string][]listener
+	// host and ip of the stack, advertised by the listeners on all the interfaces, changed by a network
+	// change if resolved.
+	host      string
+	ip        net.IP
+	amu       sync.RWMutex
+	resolver  *resolver
+	msgMapper sip.MessageMapper
+	factory   transport.ProtocolFactory
+	// hops the servers of the requests by Via branch, so CANCEL and ACK follow the INVITE.
+	hops   map[string][]hop
+	hopsMu sync.Mutex
+	// mapped the public addresses by network found by STUN.
+	mapped sync.Map
+	// public the address advertised by the listeners, its port nil for the ones of the listeners.
+	public     *transport.Target
+	rewriteSDP bool
+	// compact the header names written in their compact form.
+	compact bool
+	// opaque the networks of the custom transports, their destinations are not resolved.
+	opaque map[string]bool
+	stats  *stats
+	tap    *messageTap
+
+	msgs     chan sip.Message
+	errs     chan error
+	pmsgs    chan sip.Message
+	perrs    chan error
+	canceled chan struct{}
+	done     chan struct{}
+
+	cancelOnce sync.Once
+
+	log log.Logger
+}
+
+// listener a listening address of a transport.
+type listener struct {
+	// ip the bound IP, unspecified for all the interfaces.
+	ip   net.IP
+	port sip.Port
+	// host advertised in the Via and Contact of the messages sent through the listener, if not empty.
+	host string
+}
+
+// bound reports whether the listener is bound to one interface.
+func (ln listener) bound() bool {
+	return ln.ip != nil && !ln.ip.IsUnspecified()
+}
+
+// connector a protocol connecting to one of the servers of a request before sending it.
+type connector interface {
+	connect(hops []hop) (int, error)
+}
+
+const (
+	// hopTTL how long the servers of a request are kept for its CANCEL and ACK.
+	hopTTL = 5 * time.Minute
+)
+
+func newLayer(
+	host string,
+	ip net.IP,
+	resolver *resolver,
+	msgMapper sip.MessageMapper,
+	factory transport.ProtocolFactory,
+	logger log.Logger,
+) *layer {
+	l := &layer{
+		protocols: make(map[string]transport.Protocol),
+		listeners: make(map[string][]listener),
+		host:      host,
+		ip:        ip,
+		resolver:  resolver,
+		msgMapper: msgMapper,
+		factory:   factory,
+		hops:      make(map[string][]hop),
+		opaque:    make(map[string]bool),
+		tap:       &messageTap{},
+
+		msgs:     make(chan sip.Message),
+		errs:     make(chan error),
+		pmsgs:    make(chan sip.Message),
+		perrs:    make(chan error),
+		canceled: make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+
+	l.log = logger.WithFields(log.Fields{
+		"transport_layer_ptr": fmt.Sprintf("%p", l),
+	})
+
+	go l.serveProtocols()
+
+	return l
+}
+
+func (l *layer) String() string {
+	if l == nil {
+		return "<nil>"
+	}
+	return fmt.Sprintf("transport.Layer<%s>", l.Log().Fields())
+}
+
+func (l *layer) Log() log.Logger {
+	return l.log
+}
+
+func (l *layer) Cancel() {
+	l.cancelOnce.Do(func() {
+		close(l.canceled)
+		l.Log().Debug("transport layer canceled")
+	})
+}
+
+func (l *layer) Done() <-chan struct{} {
+	return l.done
+}
+
+func (l *layer) Messages() <-chan sip.Message {
+	return l.msgs
+}
+
+func (l *layer) Errors() <-chan error {
+	return l.errs
+}
+
+func (l *layer) IsReliable(network string) bool {
+	if protocol, ok := l.protocol(network); ok {
+		return protocol.Reliable()
+	}
+	// The protocol is created on the first send, e.g. TCP for an oversized UDP request.
+	return !strings.EqualFold(network, "UDP")
+}
+
+func (l *layer) IsStreamed(network string) bool {
+	if protocol, ok := l.protocol(network); ok && protocol.Streamed() {
+		return true
+	}
+	return false
+}
+
+func (l *layer) protocol(network string) (transport.Protocol, bool) {
+	l.pmu.RLock()
+	defer l.pmu.RUnlock()
+	protocol, ok := l.protocols[strings.ToUpper(network)]
+	return protocol, ok
+}
+
+func (l *layer) Listen(network string, addr string, options ...transport.ListenOption) error {
+	return l.listen(network, addr, "", options...)
+}
+
+// listen on addr, host is advertised in the Via and Contact of the messages sent through it if not empty.
+func (l *layer) listen(network string, addr string, host string, options ...transport.ListenOption) error {
+	select {
+	case <-l.canceled:
+		return fmt.Errorf("transport layer is canceled")
+	default:
+	}
+
+	network = strings.ToUpper(network)
+	protocol, err := l.getOrCreateProtocol(network)
+	if err != nil {
+		return err
+	}
+
+	target, err := transport.NewTargetFromAddr(addr)
+	if err != nil {
+		return err
+	}
+	target = transport.FillTargetHostAndPort(network, target)
+
+	if err := protocol.Listen(target, options...); err != nil {
+		return err
+	}
+	l.pmu.Lock()
+	l.listeners[network] = append(l.listeners[network], listener{
+		ip:   net.ParseIP(target.Host),
+		port: *target.Port,
+		host: sipHost(host),
+	})
+	l.pmu.Unlock()
+	return nil
+}
+
+// listener the listener of network to send to host: the one bound to the local IP the system routes
+// from to host, else one on all the interfaces of the address family. The index 0 is the first one.
+func (l *layer) listener(network string, host string) (listener, int, bool) {
+	l.pmu.RLock()
+	listeners := l.listeners[strings.ToUpper(network)]
+	l.pmu.RUnlock()
+	if len(listeners) == 0 {
+		return listener{}, 0, false
+	}
+	if len(listeners) == 1 || host == "" {
+		return listeners[0], 0, true
+	}
+
+	src := sourceIP(host)
+	if src == nil {
+		return listeners[0], 0, true
+	}
+	found := -1
+	for i, ln := range listeners {
+		switch {
+		case ln.bound() && ln.ip.Equal(src):
+			return ln, i, true
+		case !ln.bound() && found < 0 && (ln.ip == nil || (ln.ip.To4() == nil) == (src.To4() == nil)):
+			found = i
+		}
+	}
+	if found < 0 {
+		found = 0
+	}
+	return listeners[found], found, true
+}
+
+// networkInfo the advertised address of the listener of network to send to host, of the first one if host is empty.
+func (l *layer) networkInfo(network string, host string) *transport.Target {
+	network = strings.ToUpper(network)
+	ln, i, ok := l.listener(network, host)
+	if !ok {
+		if l.public != nil {
+			return l.publicAddress(sip.DefaultPort(network))
+		}
+		if mapped, ok := l.mappedAddress(network); ok {
+			mapped.Host = sipHost(mapped.Host)
+			return mapped
+		}
+		localHost, _ := l.local()
+		return transport.NewTarget(sipHost(localHost), int(sip.DefaultPort(network)))
+	}
+	target, ok := l.advertised(network, ln, i)
+	if !ok {
+		localHost, localIP := l.local()
+		target.Host = sipHost(localHost)
+		if ip := l.localIP(host); !ip.Equal(localIP) {
+			target.Host = sipHost(ip.String())
+		}
+	}
+	return target
+}
+
+// advertised the address of a listener in the Via and Contact: its configured host, the public address
+// of the stack, the one found by STUN for the first one, or its IP. Reports false with an empty host
+// for a listener on all the interfaces, its host is the one of the stack.
+func (l *layer) advertised(network string, ln listener, i int) (*transport.Target, bool) {
+	port := ln.port
+	switch {
+	case ln.host != "":
+		return &transport.Target{Host: ln.host, Port: &port}, true
+	case l.public != nil:
+		return l.publicAddress(port), true
+	case i == 0:
+		if mapped, ok := l.mappedAddress(network); ok {
+			mapped.Host = sipHost(mapped.Host)
+			return mapped, true
+		}
+	}
+	if ln.bound() {
+		return &transport.Target{Host: sipHost(ln.ip.String()), Port: &port}, true
+	}
+	return &transport.Target{Port: &port}, false
+}
+
+// getOrCreateProtocol returns the protocol of network, a client only protocol is created on the first send.
+func (l *layer) getOrCreateProtocol(network string) (transport.Protocol, error) {
+	l.pmu.Lock()
+	defer l.pmu.Unlock()
+
+	if protocol, ok := l.protocols[network]; ok {
+		return protocol, nil
+	}
+	protocol, err := l.factory(network, l.pmsgs, l.perrs, l.canceled, l.msgMapper, l.Log())
+	if err != nil {
+		return nil, err
+	}
+	l.protocols[network] = protocol
+	return protocol, nil
+}
+
+func (l *layer) Send(msg sip.Message) error {
+	select {
+	case <-l.canceled:
+		return fmt.Errorf("transport layer is canceled")
+	default:
+	}
+
+	viaHop, ok := msg.ViaHop()
+	if !ok {
+		return &sip.MalformedMessageError{
+			Err: fmt.Errorf("missing required 'Via' header"),
+			Msg: msg.String(),
+		}
+	}
+
+	switch msg := msg.(type) {
+	// RFC 3261 - 18.1.1.
+	case sip.Request:
+		network := msg.Transport()
+		// rewrite sent-by transport
+		viaHop.Transport = network
+		// Ask for the response to the source address and port (RFC 3581).
+		if viaHop.Params == nil {
+			viaHop.Params = sip.NewParams()
+		}
+		if !viaHop.Params.Has("rport") {
+			viaHop.Params.Add("rport", nil)
+		}
+
+		protocol, err := l.getOrCreateProtocol(network)
+		if err != nil {
+			return err
+		}
+
+		// sent-by is set by the listener of each server, unless the request was already sent.
+		pick := viaHop.Port == nil
+		contact := l.defaultContact(msg, network)
+		l.rewriteBody(msg)
+
+		hops, err := l.route(msg)
+		if err != nil {
+			return err
+		}
+
+		if c, ok := protocol.(connector); ok {
+			i, err := c.connect(hops)
+			if err != nil {
+				return err
+			}
+			if i > 0 {
+				hops = append([]hop{hops[i]}, append(append([]hop{}, hops[:i]...), hops[i+1:]...)...)
+				l.pin(msg, hops)
+			}
+		}
+
+		logger := log.AddFieldsFrom(l.Log(), protocol, msg)
+		logger.Debugf("sending SIP request:\n%s", msg)
+
+		// Try the next server when the connection to one fails (RFC 3263 4.3).
+		for i, hop := range hops {
+			l.setSentBy(msg, viaHop, network, hop, pick, contact)
+			wire := l.wire(msg)
+			if err = protocol.Send(transport.NewTarget(hop.Host, int(hop.Port)), wire); err == nil {
+				if i > 0 {
+					l.pin(msg, hops[i:])
+				}
+				l.stats.messageSent(network)
+				l.tap.messageSent(network, wire, hop.Addr())
+				return nil
+			}
+			logger.Warnf("send SIP message through %s protocol to %s failed: %s", protocol.Network(), hop.Addr(), err)
+		}
+		return fmt.Errorf("send SIP message through %s protocol to %s: %w", protocol.Network(), msg.Destination(), err)
+	// RFC 3261 - 18.2.2.
+	case sip.Response:
+		// The transport the request came in, the one of the response may be switched to TCP by its size.
+		network := viaHop.Transport
+		if len(network) == 0 {
+			network = msg.Transport()
+		}
+		protocol, ok := l.protocol(network)
+		if !ok {
+			return transport.UnsupportedProtocolError(fmt.Sprintf("protocol %s is not supported", viaHop.Transport))
+		}
+
+		target, err := transport.NewTargetFromAddr(msg.Destination())
+		if err != nil {
+			return fmt.Errorf("build address target for %s: %w", msg.Destination(), err)
+		}
+
+		l.rewriteBody(msg)
+
+		logger := log.AddFieldsFrom(l.Log(), protocol, msg)
+		logger.Debugf("sending SIP response:\n%s", msg)
+
+		wire := l.wire(msg)
+		if err = protocol.Send(target, wire); err != nil {
+			return fmt.Errorf("send SIP message through %s protocol to %s: %w", protocol.Network(), msg.Destination(), err)
+		}
+		l.stats.messageSent(network)
+		l.tap.messageSent(network, wire, msg.Destination())
+		return nil
+	default:
+		return &sip.UnsupportedMessageError{
+			Err: fmt.Errorf("unsupported message %s", msg.Short()),
+			Msg: msg.String(),
+		}
+	}
+}
+
+// setSentBy sets the Via of a request to h by the listener of h: its advertised address,
+// or its port and the local IP of the address family of h. The Contact follows the Via
+// if it is not nil, with more than one listener.
+func (l *layer) setSentBy(req sip.Request, viaHop *sip.ViaHop, network string, h hop, pick bool, contact sip.Uri) {
+	ln, i, ok := l.listener(network, h.Host)
+	if !ok {
+		if pick {
+			port := sip.DefaultPort(network)
+			viaHop.Port = &port
+			_, ip := l.local()
+			req.SetSource(net.JoinHostPort(ip.String(), port.String()))
+		}
+		viaHop.Host = sipHost(l.localIP(h.Host).String())
+		return
+	}
+
+	// The socket is picked by the source, sent-by may be the public address.
+	_, ip := l.local()
+	if ln.bound() {
+		ip = ln.ip
+	}
+	if pick {
+		req.SetSource(net.JoinHostPort(ip.String(), ln.port.String()))
+	}
+
+	target, ok := l.advertised(network, ln, i)
+	if !ok {
+		target.Host = sipHost(l.localIP(h.Host).String())
+	}
+	if pick || ok {
+		viaHop.Port = target.Port
+	}
+	viaHop.Host = target.Host
+
+	if contact != nil && (ok || i > 0) {
+		contact.SetHost(viaHop.Host)
+		contact.SetPort(viaHop.Port)
+	}
+}
+
+// defaultContact the Contact URI of a request with the default address of network, see SipStack.GetNetworkInfo.
+// It is only changed with more than one listener.
+func (l *layer) defaultContact(req sip.Request, network string) sip.Uri {
+	l.pmu.RLock()
+	listeners := len(l.listeners[strings.ToUpper(network)])
+	l.pmu.RUnlock()
+	if listeners < 2 {
+		return nil
+	}
+	hdrs := req.GetHeaders("Contact")
+	if len(hdrs) == 0 {
+		return nil
+	}
+	contact, ok := hdrs[0].(*sip.ContactHeader)
+	if !ok || contact.Address == nil || contact.Address.Port() == nil {
+		return nil
+	}
+	def := l.networkInfo(network, "")
+	if contact.Address.Host() != def.Host || *contact.Address.Port() != *def.Port {
+		return nil
+	}
+	return contact.Address
+}
+
+// localIP the IP of the stack, or for a host of the other address family the source IP the system routes to it.
+func (l *layer) localIP(host string) net.IP {
+	_, local := l.local()
+	ip := net.ParseIP(unbracket(host))
+	if ip == nil || (ip.To4() == nil) == (local.To4() == nil) {
+		return local
+	}
+	if src := sourceIP(host); src != nil {
+		return src
+	}
+	return local
+}
+
+// local the host and the IP of the stack.
+func (l *layer) local() (string, net.IP) {
+	l.amu.RLock()
+	defer l.amu.RUnlock()
+	return l.host, l.ip
+}
+
+// setLocal changes the host and the IP of the stack, e.g. resolved again after a network change.
+func (l *layer) setLocal(host string, ip net.IP) {
+	l.amu.Lock()
+	defer l.amu.Unlock()
+	l.host, l.ip = host, ip
+}
+
+// sourceIP the local IP the system routes from to the IP host, nil if none.
+func sourceIP(host string) net.IP {
+	if net.ParseIP(unbracket(host)) == nil {
+		return nil
+	}
+	// No packet is sent by a UDP dial.
+	conn, err := net.Dial("udp", net.JoinHostPort(unbracket(host), "5060"))
+	if err != nil {
+		return nil
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).IP
+}
+
+func (l *layer) mappedAddress(network string) (*transport.Target, bool) {
+	v, ok := l.mapped.Load(strings.ToUpper(network))
+	if !ok {
+		return nil, false
+	}
+	target := v.(*transport.Target)
+	return &transport.Target{Host: target.Host, Port: target.Port.Clone()}, true
+}
+
+// setMappedAddress reports whether the public address of network changed.
+func (l *layer) setMappedAddress(network string, target *transport.Target) bool {
+	if current, ok := l.mappedAddress(network); ok && current.Host == target.Host && *current.Port == *target.Port {
+		return false
+	}
+	l.mapped.Store(strings.ToUpper(network), target)
+	return true
+}
+
+// rewriteBody replaces the local IP of an SDP body by the public one.
+func (l *layer) rewriteBody(msg sip.Message) {
+	if !l.rewriteSDP || len(msg.Body()) == 0 {
+		return
+	}
+	mapped, ok := l.mappedAddress("UDP")
+	if !ok {
+		return
+	}
+	_, ip := l.local()
+	local := ip.String()
+	if mapped.Host == local {
+		return
+	}
+	family := "IN IP4 "
+	if ip := net.ParseIP(mapped.Host); ip != nil && ip.To4() == nil {
+		family = "IN IP6 "
+	}
+	// The o= and c= lines.
+	body := msg.Body()
+	for _, eol := range []string{"\r\n", "\n"} {
+		body = strings.ReplaceAll(body, family+local+eol, family+mapped.Host+eol)
+	}
+	if body != msg.Body() {
+		msg.SetBody(body, true)
+	}
+}
+
+// route the servers to send the request to: the ones already used by its branch, the explicit
+// destination of the request, or the servers of the next hop URI (RFC 3263).
+func (l *layer) route(req sip.Request) ([]hop, error) {
+	branch := branchOf(req)
+	l.hopsMu.Lock()
+	hops, ok := l.hops[branch]
+	l.hopsMu.Unlock()
+	if ok && len(hops) > 0 {
+		return hops, nil
+	}
+
+	if l.opaque[strings.ToUpper(req.Transport())] {
+		return destinationHop(req, req.Transport())
+	}
+
+	uri := nextHop(req)
+	dest := req.Destination()
+	if uri == nil || dest != uriDestination(req, uri) || net.ParseIP(unbracket(uri.Host())) != nil {
+		target, err := transport.NewTargetFromAddr(dest)
+		if err != nil {
+			return nil, fmt.Errorf("build address target for %s: %w", dest, err)
+		}
+		if net.ParseIP(target.Host) != nil {
+			return []hop{{Network: req.Transport(), Host: target.Host, Port: *target.Port}}, nil
+		}
+		return l.resolver.lookupHost(context.Background(), target.Host, req.Transport(), *target.Port)
+	}
+
+	hops, err := l.resolver.resolve(context.Background(), uri, "")
+	if err != nil {
+		return nil, fmt.Errorf("resolve %s: %w", uri.Host(), err)
+	}
+	if len(hops) == 0 {
+		return nil, fmt.Errorf("resolve %s: no server found", uri.Host())
+	}
+	l.pin(req, hops)
+	return hops, nil
+}
+
+// reroute moves the request to network, its servers are resolved again for the transport.
+func (l *layer) reroute(req sip.Request, network string) ([]hop, error) {
+	uri := nextHop(req)
+	explicit := uri == nil || req.Destination() != uriDestination(req, uri) || net.ParseIP(unbracket(uri.Host())) != nil
+	req.SetTransport(network)
+
+	var hops []hop
+	var err error
+	if l.opaque[strings.ToUpper(network)] {
+		hops, err = destinationHop(req, network)
+	} else if explicit {
+		target, terr := transport.NewTargetFromAddr(req.Destination())
+		if terr != nil {
+			return nil, fmt.Errorf("build address target for %s: %w", req.Destination(), terr)
+		}
+		hops, err = l.resolver.lookupHost(context.Background(), target.Host, network, *target.Port)
+	} else {
+		hops, err = l.resolver.resolve(context.Background(), uri, network)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("resolve %s over %s: %w", req.Destination(), network, err)
+	}
+	if len(hops) == 0 {
+		return nil, fmt.Errorf("resolve %s over %s: no server found", req.Destination(), network)
+	}
+	l.pin(req, hops)
+	return hops, nil
+}
+
+// connections the open connections of each protocol, the sockets of UDP.
+func (l *layer) connections() map[string]int {
+	l.pmu.RLock()
+	defer l.pmu.RUnlock()
+	counts := make(map[string]int, len(l.protocols))
+	for network, protocol := range l.protocols {
+		switch p := protocol.(type) {
+		case *udpProtocol:
+			counts[network] = len(p.connections.All())
+		case *streamProtocol:
+			counts[network] = len(p.connections.All())
+		}
+	}
+	return counts
+}
+
+// destinationHop the destination of the request as it is, not resolved.
+func destinationHop(req sip.Request, network string) ([]hop, error) {
+	target, err := transport.NewTargetFromAddr(req.Destination())
+	if err != nil {
+		return nil, fmt.Errorf("build address target for %s: %w", req.Destination(), err)
+	}
+	return []hop{{Network: strings.ToUpper(network), Host: target.Host, Port: *target.Port}}, nil
+}
+
+// pin keeps the servers of the request for the requests of the same branch.
+func (l *layer) pin(req sip.Request, hops []hop) {
+	branch := branchOf(req)
+	if len(branch) == 0 {
+		return
+	}
+	l.hopsMu.Lock()
+	_, exists := l.hops[branch]
+	l.hops[branch] = hops
+	l.hopsMu.Unlock()
+
+	if !exists {
+		time.AfterFunc(hopTTL, func() {
+			l.hopsMu.Lock()
+			delete(l.hops, branch)
+			l.hopsMu.Unlock()
+		})
+	}
+}
+
+// failover drops the first server of the request, reports whether another one is left.
+func (l *layer) failover(req sip.Request) ([]hop, bool) {
+	branch := branchOf(req)
+	l.hopsMu.Lock()
+	defer l.hopsMu.Unlock()
+	hops, ok := l.hops[branch]
+	if !ok || len(hops) < 2 {
+		return nil, false
+	}
+	return hops[1:], true
+}
+
+func branchOf(msg sip.Message) string {
+	if viaHop, ok := msg.ViaHop(); ok && viaHop.Params != nil {
+		if branch, ok := viaHop.Params.Get("branch"); ok && branch != nil {
+			return branch.String()
+		}
+	}
+	return ""
+}
+
+// uriDestination the destination gosip computes from the URI, a different one was set explicitly.
+func uriDestination(req sip.Request, uri sip.Uri) string {
+	port := sip.DefaultPort(req.Transport())
+	if uri.Port() != nil {
+		port = *uri.Port()
+	}
+	return fmt.Sprintf("%v:%v", uri.Host(), port)
+}
+
+func (l *layer) serveProtocols() {
+	defer func() {
+		l.dispose()
+		close(l.done)
+	}()
+
+	l.Log().Debug("begin serve protocols")
+	defer l.Log().Debug("stop serve protocols")
+
+	for {
+		select {
+		case <-l.canceled:
+			return
+		case msg := <-l.pmsgs:
+			l.handleMessage(msg)
+		case err := <-l.perrs:
+			l.handleError(err)
+		}
+	}
+}
+
+func (l *layer) dispose() {
+	l.Log().Debug("disposing...")
+	l.pmu.Lock()
+	protocols := l.protocols
+	l.protocols = make(map[string]transport.Protocol)
+	l.listeners = make(map[string][]listener)
+	l.pmu.Unlock()
+
+	for _, protocol := range protocols {
+		<-protocol.Done()
+	}
+
+	close(l.pmsgs)
+	close(l.perrs)
+	close(l.msgs)
+	close(l.errs)
+}
+
+func (l *layer) handleMessage(msg sip.Message) {
+	logger := l.Log().WithFields(msg.Fields())
+	logger.Debugf("received SIP message:\n%s", msg)
+	l.stats.messageReceived(msg.Transport())
+	l.tap.messageReceived(msg)
+
+	select {
+	case <-l.canceled:
+	case l.msgs <- msg:
+	}
+}
+
+func (l *layer) handleError(err error) {
+	var terr transport.Error
+	if errors.As(err, &terr) {
+		l.Log().Warnf("SIP transport error: %s", err)
+	}
+
+	select {
+	case <-l.canceled:
+	case l.errs <- err:
+	}
+}
+
+// sipHost the host of a URI or Via, IPv6 literals are bracketed (RFC 3261 25.1).
+func sipHost(host string) string {
+	if strings.Contains(host, ":") && !strings.HasPrefix(host, "[") {
+		return "[" + host + "]"
+	}
+	return host
+}
+
+// publicAddress the public address of the stack, with port if it has none.
+func (l *layer) publicAddress(port sip.Port) *transport.Target {
+	if l.public.Port != nil {
+		port = *l.public.Port
+	}
+	return &transport.Target{Host: l.public.Host, Port: &port}
+}
+
+// parsePublicAddress parses host[:port], an IPv6 host is bracketed as in a URI.
+func parsePublicAddress(address string) *transport.Target {
+	host, portString, err := net.SplitHostPort(address)
+	if err != nil {
+		return &transport.Target{Host: sipHost(unbracket(address))}
+	}
+	target := &transport.Target{Host: sipHost(host)}
+	if port, err := strconv.Atoi(portString); err == nil {
+		p := sip.Port(port)
+		target.Port = &p
+	}
+	return target
+}
+
+func unbracket(host string) string {
+	return strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+}
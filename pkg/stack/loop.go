@@ -0,0 +1,105 @@
+package stack
+
+import (
+	"sync"
+	"time"
+
+	"github.com/ghettovoice/gosip/sip"
+)
+
+// DefaultMaxForwards the Max-Forwards of the requests originated (RFC 3261 8.1.1.6).
+const DefaultMaxForwards = 70
+
+// loopDetector the branches of the requests sent, a request received with one of them in its Via
+// came back to the stack (RFC 3261 16.3).
+type loopDetector struct {
+	mu       sync.Mutex
+	branches map[string]bool
+	// ttl how long a branch is kept, the lifetime of its transaction.
+	ttl time.Duration
+}
+
+func newLoopDetector(ttl time.Duration) *loopDetector {
+	return &loopDetector{branches: make(map[string]bool), ttl: ttl}
+}
+
+func (d *loopDetector) sent(req sip.Request) {
+	branch := branchOf(req)
+	if len(branch) == 0 {
+		return
+	}
+	d.mu.Lock()
+	exists := d.branches[branch]
+	d.branches[branch] = true
+	d.mu.Unlock()
+
+	if !exists {
+		time.AfterFunc(d.ttl, func() {
+			d.mu.Lock()
+			delete(d.branches, branch)
+			d.mu.Unlock()
+		})
+	}
+}
+
+// looped reports whether a Via of req has the branch of a request sent.
+func (d *loopDetector) looped(req sip.Request) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, header := range req.GetHeaders("Via") {
+		via, ok := header.(sip.ViaHeader)
+		if !ok {
+			continue
+		}
+		for _, hop := range via {
+			if hop.Params == nil {
+				continue
+			}
+			if branch, ok := hop.Params.Get("branch"); ok && branch != nil && d.branches[branch.String()] {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// MaxForwards the Max-Forwards of the requests originated by the stack, see SipStackConfig.MaxForwards.
+func (s *SipStack) MaxForwards() int {
+	if s.config.MaxForwards > 0 {
+		return s.config.MaxForwards
+	}
+	return DefaultMaxForwards
+}
+
+// rejectLoop answers a request looped back to the stack with 482 and one out of hops with 483,
+// except an OPTIONS, reports whether it did. Only with SipStackConfig.LoopDetection.
+func (s *SipStack) rejectLoop(req sip.Request) bool {
+	if s.loops == nil {
+		return false
+	}
+	var res sip.Response
+	switch {
+	case s.loops.looped(req):
+		res = sip.NewResponseFromRequest("", req, 482, "Loop Detected", "")
+	case req.Method() != sip.OPTIONS && maxForwardsOf(req) == 0:
+		res = sip.NewResponseFromRequest("", req, 483, "Too Many Hops", "")
+	default:
+		return false
+	}
+	logger := s.Log().WithFields(req.Fields())
+	logger.Warnf("reject %s: %d %s", req.Short(), res.StatusCode(), res.Reason())
+	if _, err := s.Respond(res); err != nil {
+		logger.Errorf("respond '%d %s' failed: %s", res.StatusCode(), res.Reason(), err)
+	}
+	return true
+}
+
+// maxForwardsOf the Max-Forwards of req, -1 without one.
+func maxForwardsOf(req sip.Request) int {
+	if hdrs := req.GetHeaders("Max-Forwards"); len(hdrs) > 0 {
+		if maxForwards, ok := hdrs[0].(*sip.MaxForwards); ok {
+			return int(*maxForwards)
+		}
+	}
+	return -1
+}
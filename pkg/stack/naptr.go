@@ -0,0 +1,206 @@
+package stack
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"math/rand"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	dnsTypeNAPTR = 35
+	dnsClassIN   = 1
+)
+
+// NAPTR a NAPTR record (RFC 3403) used to select the transport of a SIP domain (RFC 3263 4.1).
+type NAPTR struct {
+	Order       uint16
+	Preference  uint16
+	Flags       string
+	Service     string
+	Regexp      string
+	Replacement string
+	TTL         uint32
+}
+
+// lookupNAPTR queries the NAPTR records of name from server, the net package has no NAPTR lookup.
+func lookupNAPTR(ctx context.Context, server string, name string) ([]*NAPTR, error) {
+	query, id := buildQuery(name, dnsTypeNAPTR)
+
+	dialer := net.Dialer{Timeout: 5 * time.Second}
+	conn, err := dialer.DialContext(ctx, "udp", server)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(5 * time.Second)
+	}
+	conn.SetDeadline(deadline)
+
+	if _, err := conn.Write(query); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 4096)
+	n, err := conn.Read(buf)
+	if err != nil {
+		return nil, err
+	}
+	return parseNAPTR(buf[:n], id)
+}
+
+func buildQuery(name string, qtype uint16) ([]byte, uint16) {
+	id := uint16(rand.Intn(1 << 16))
+	msg := make([]byte, 12, 512)
+	binary.BigEndian.PutUint16(msg[0:], id)
+	// Recursion desired.
+	binary.BigEndian.PutUint16(msg[2:], 0x0100)
+	binary.BigEndian.PutUint16(msg[4:], 1)
+	for _, label := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+		msg = append(msg, byte(len(label)))
+		msg = append(msg, label...)
+	}
+	msg = append(msg, 0, byte(qtype>>8), byte(qtype), 0, dnsClassIN)
+	return msg, id
+}
+
+func parseNAPTR(msg []byte, id uint16) ([]*NAPTR, error) {
+	if len(msg) < 12 {
+		return nil, fmt.Errorf("short DNS response")
+	}
+	if binary.BigEndian.Uint16(msg[0:]) != id {
+		return nil, fmt.Errorf("DNS response id mismatch")
+	}
+	flags := binary.BigEndian.Uint16(msg[2:])
+	switch rcode := flags & 0x000f; rcode {
+	case 0:
+	case 3:
+		return nil, &net.DNSError{Err: "no such host", IsNotFound: true}
+	default:
+		return nil, fmt.Errorf("DNS response code %d", rcode)
+	}
+	qdcount := int(binary.BigEndian.Uint16(msg[4:]))
+	ancount := int(binary.BigEndian.Uint16(msg[6:]))
+
+	off := 12
+	var err error
+	for i := 0; i < qdcount; i++ {
+		if _, off, err = readName(msg, off); err != nil {
+			return nil, err
+		}
+		off += 4
+	}
+
+	records := make([]*NAPTR, 0, ancount)
+	for i := 0; i < ancount; i++ {
+		if _, off, err = readName(msg, off); err != nil {
+			return nil, err
+		}
+		if off+10 > len(msg) {
+			return nil, fmt.Errorf("short DNS record")
+		}
+		rtype := binary.BigEndian.Uint16(msg[off:])
+		ttl := binary.BigEndian.Uint32(msg[off+4:])
+		rdlen := int(binary.BigEndian.Uint16(msg[off+8:]))
+		off += 10
+		if off+rdlen > len(msg) {
+			return nil, fmt.Errorf("short DNS record data")
+		}
+		if rtype == dnsTypeNAPTR {
+			record, err := parseNAPTRData(msg, off, off+rdlen)
+			if err != nil {
+				return nil, err
+			}
+			record.TTL = ttl
+			records = append(records, record)
+		}
+		off += rdlen
+	}
+	return records, nil
+}
+
+func parseNAPTRData(msg []byte, off int, end int) (*NAPTR, error) {
+	if off+4 > end {
+		return nil, fmt.Errorf("short NAPTR record")
+	}
+	record := &NAPTR{
+		Order:      binary.BigEndian.Uint16(msg[off:]),
+		Preference: binary.BigEndian.Uint16(msg[off+2:]),
+	}
+	off += 4
+	strs := make([]string, 3)
+	for i := range strs {
+		if off >= end || off+1+int(msg[off]) > end {
+			return nil, fmt.Errorf("short NAPTR record")
+		}
+		l := int(msg[off])
+		strs[i] = string(msg[off+1 : off+1+l])
+		off += 1 + l
+	}
+	record.Flags, record.Service, record.Regexp = strs[0], strs[1], strs[2]
+	replacement, _, err := readName(msg, off)
+	if err != nil {
+		return nil, err
+	}
+	record.Replacement = replacement
+	return record, nil
+}
+
+// readName reads a possibly compressed domain name at off, returns the offset after it.
+func readName(msg []byte, off int) (string, int, error) {
+	labels := make([]string, 0)
+	next := -1
+	for hops := 0; hops < 64; hops++ {
+		if off >= len(msg) {
+			return "", 0, fmt.Errorf("short DNS name")
+		}
+		l := int(msg[off])
+		switch {
+		case l == 0:
+			if next < 0 {
+				next = off + 1
+			}
+			return strings.Join(labels, ".") + ".", next, nil
+		case l&0xc0 == 0xc0:
+			if off+1 >= len(msg) {
+				return "", 0, fmt.Errorf("short DNS name pointer")
+			}
+			if next < 0 {
+				next = off + 2
+			}
+			off = int(binary.BigEndian.Uint16(msg[off:]) & 0x3fff)
+		default:
+			if off+1+l > len(msg) {
+				return "", 0, fmt.Errorf("short DNS label")
+			}
+			labels = append(labels, string(msg[off+1:off+1+l]))
+			off += 1 + l
+		}
+	}
+	return "", 0, fmt.Errorf("DNS name compression loop")
+}
+
+// systemNameserver the first nameserver of /etc/resolv.conf.
+func systemNameserver() string {
+	file, err := os.Open("/etc/resolv.conf")
+	if err != nil {
+		return "127.0.0.1:53"
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == "nameserver" {
+			return net.JoinHostPort(fields[1], "53")
+		}
+	}
+	return "127.0.0.1:53"
+}
@@ -0,0 +1,149 @@
+package stack
+
+import (
+	"net"
+	"sort"
+
+	"github.com/ghettovoice/gosip/util"
+)
+
+// NetworkChange the addresses of the interfaces added and removed, e.g. when a laptop moves from Wi-Fi to LTE.
+type NetworkChange struct {
+	Added   []net.IP
+	Removed []net.IP
+	// IP the IP of the stack after the change, resolved again unless SipStackConfig.Host is set.
+	IP net.IP
+}
+
+// NetworkChangeHandler handles a network change after the stack rebound its transports.
+type NetworkChangeHandler func(change NetworkChange)
+
+// OnNetworkChange registers the handler of the network changes, see SipStackConfig.NetworkCheckInterval.
+func (s *SipStack) OnNetworkChange(handler NetworkChangeHandler) {
+	s.hmu.Lock()
+	s.networkChangeHandler = handler
+	s.hmu.Unlock()
+}
+
+// CheckNetwork checks the addresses of the interfaces now, e.g. on a notification of the system, as if the
+// interval of NetworkCheckInterval elapsed. A no-op if the network is not monitored.
+func (s *SipStack) CheckNetwork() {
+	if s.config.NetworkCheckInterval <= 0 {
+		return
+	}
+	select {
+	case s.networkCheck <- struct{}{}:
+	default:
+	}
+}
+
+// serveNetwork compares the addresses of the interfaces every interval until the stack is shut down.
+func (s *SipStack) serveNetwork() {
+	ticker := s.Clock().NewTicker(s.config.NetworkCheckInterval)
+	defer ticker.Stop()
+
+	addrs := interfaceIPs()
+	for {
+		select {
+		case <-s.networkCheck:
+		case <-ticker.C():
+		case <-s.tp.Done():
+			return
+		}
+		current := interfaceIPs()
+		added, removed := diffIPs(addrs, current), diffIPs(current, addrs)
+		addrs = current
+		if len(added) > 0 || len(removed) > 0 {
+			s.networkChanged(NetworkChange{Added: added, Removed: removed})
+		}
+	}
+}
+
+// networkChanged rebinds the stack to the network: its IP is resolved again, the connections of the stream
+// transports dialed from the former addresses are closed, dialed again by the next requests, and the public
+// addresses are discovered again by STUN.
+func (s *SipStack) networkChanged(change NetworkChange) {
+	host, ip := s.tp.local()
+	if s.config.Host == "" {
+		if resolved, err := util.ResolveSelfIP(); err == nil {
+			host, ip = resolved.String(), resolved
+			s.tp.setLocal(host, ip)
+		} else {
+			s.Log().Warnf("resolve host IP after a network change failed: %s", err)
+		}
+	}
+	change.IP = ip
+	s.Log().Infof("network changed, added %v, removed %v, host %s", change.Added, change.Removed, host)
+
+	s.tp.closeConnections()
+	s.tp.mapped.Range(func(key, value interface{}) bool {
+		s.tp.mapped.Delete(key)
+		return true
+	})
+	s.checkSTUN()
+
+	s.hmu.RLock()
+	handler := s.networkChangeHandler
+	s.hmu.RUnlock()
+	if handler != nil {
+		handler(change)
+	}
+}
+
+// closeConnections closes the connections of the stream transports, the listeners and the UDP sockets kept.
+func (l *layer) closeConnections() {
+	l.pmu.RLock()
+	defer l.pmu.RUnlock()
+	for _, protocol := range l.protocols {
+		if p, ok := protocol.(*streamProtocol); ok {
+			for _, conn := range p.connections.All() {
+				conn.Close()
+			}
+		}
+	}
+}
+
+// interfaceIPs the unicast addresses of the interfaces up, but the loopback and link-local ones, sorted.
+func interfaceIPs() []net.IP {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil
+	}
+	ips := make([]net.IP, 0)
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			ipnet, ok := addr.(*net.IPNet)
+			if !ok || !ipnet.IP.IsGlobalUnicast() {
+				continue
+			}
+			ips = append(ips, ipnet.IP)
+		}
+	}
+	sort.Slice(ips, func(i, j int) bool { return ips[i].String() < ips[j].String() })
+	return ips
+}
+
+// diffIPs the IPs of b not in a.
+func diffIPs(a, b []net.IP) []net.IP {
+	var diff []net.IP
+	for _, ip := range b {
+		found := false
+		for _, other := range a {
+			if ip.Equal(other) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			diff = append(diff, ip)
+		}
+	}
+	return diff
+}
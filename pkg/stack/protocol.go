@@ -0,0 +1,313 @@
+package stack
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/ghettovoice/gosip/log"
+	"github.com/ghettovoice/gosip/sip"
+	"github.com/ghettovoice/gosip/transport"
+)
+
+const (
+	connTTL     = time.Hour
+	dialTimeout = 10 * time.Second
+	// connectionAttemptDelay the delay before the next server is dialed (RFC 8305 5).
+	connectionAttemptDelay = 250 * time.Millisecond
+)
+
+type streamListener struct {
+	net.Listener
+	network string
+	flows   *flowTable
+	manager *connManager
+	stats   *stats
+	guard   *guard
+}
+
+func (l *streamListener) Network() string {
+	return l.network
+}
+
+func (l *streamListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	l.manager.opened(l.network, conn)
+	return &flowConn{Conn: conn, network: l.network, flows: l.flows, manager: l.manager, stats: l.stats, guard: l.guard}, nil
+}
+
+// streamProtocol a connection oriented transport, TCP, TLS, WS, WSS or a custom one, with the listen and dial of the network.
+type streamProtocol struct {
+	network     string
+	listen      func(addr string, control sockopt, options ...transport.ListenOption) (net.Listener, error)
+	dial        func(host string, addr string, control sockopt) (net.Conn, error)
+	control     sockopt
+	flows       *flowTable
+	manager     *connManager
+	stats       *stats
+	guard       *guard
+	listeners   transport.ListenerPool
+	connections transport.ConnectionPool
+	conns       chan transport.Connection
+	log         log.Logger
+	// opaque the addresses of a custom transport, passed to its dial as they are.
+	opaque bool
+}
+
+func newStreamProtocol(
+	network string,
+	listen func(addr string, control sockopt, options ...transport.ListenOption) (net.Listener, error),
+	dial func(host string, addr string, control sockopt) (net.Conn, error),
+	control sockopt,
+	flows *flowTable,
+	manager *connManager,
+	stats *stats,
+	guard *guard,
+	output chan<- sip.Message,
+	errs chan<- error,
+	cancel <-chan struct{},
+	msgMapper sip.MessageMapper,
+	logger log.Logger,
+) transport.Protocol {
+	p := &streamProtocol{
+		network: strings.ToUpper(network),
+		listen:  listen,
+		dial:    dial,
+		control: control,
+		flows:   flows,
+		manager: manager,
+		stats:   stats,
+		guard:   guard,
+		conns:   make(chan transport.Connection),
+	}
+	p.log = logger.WithFields(log.Fields{
+		"protocol_ptr": fmt.Sprintf("%p", p),
+	})
+	p.listeners = transport.NewListenerPool(p.conns, errs, cancel, p.log)
+	p.connections = transport.NewConnectionPool(output, errs, cancel, msgMapper, p.log)
+	go p.pipePools()
+	return p
+}
+
+func (p *streamProtocol) String() string {
+	return fmt.Sprintf("transport.Protocol<%s>", p.log.Fields().WithFields(log.Fields{"network": p.network}))
+}
+
+func (p *streamProtocol) Network() string {
+	return p.network
+}
+
+func (p *streamProtocol) Reliable() bool {
+	return true
+}
+
+func (p *streamProtocol) Streamed() bool {
+	return true
+}
+
+func (p *streamProtocol) Done() <-chan struct{} {
+	return p.connections.Done()
+}
+
+// pipePools serves the accepted connections.
+func (p *streamProtocol) pipePools() {
+	defer close(p.conns)
+
+	for {
+		select {
+		case <-p.listeners.Done():
+			return
+		case conn := <-p.conns:
+			if err := p.connections.Put(conn, connTTL); err != nil {
+				p.log.Errorf("put %s connection to the pool failed: %s", conn.Key(), err)
+				conn.Close()
+			}
+		}
+	}
+}
+
+func (p *streamProtocol) Listen(target *transport.Target, options ...transport.ListenOption) error {
+	target = transport.FillTargetHostAndPort(p.network, target)
+	addr := net.JoinHostPort(target.Host, target.Port.String())
+	listener, err := p.listen(addr, p.control, options...)
+	if err != nil {
+		return fmt.Errorf("listen on %s %s: %w", p.network, addr, err)
+	}
+
+	p.log.Debugf("begin listening on %s %s", p.network, addr)
+
+	key := transport.ListenerKey(strings.ToLower(p.network) + ":" + listener.Addr().String())
+	return p.listeners.Put(key, &streamListener{Listener: listener, network: p.network, flows: p.flows, manager: p.manager, stats: p.stats, guard: p.guard})
+}
+
+func (p *streamProtocol) Send(target *transport.Target, msg sip.Message) error {
+	target = transport.FillTargetHostAndPort(p.network, target)
+	if target.Host == "" {
+		return fmt.Errorf("empty remote target host")
+	}
+
+	raddr := net.JoinHostPort(target.Host, target.Port.String())
+	if !p.opaque {
+		addr, err := net.ResolveTCPAddr("tcp", raddr)
+		if err != nil {
+			return fmt.Errorf("resolve target address %s: %w", raddr, err)
+		}
+		raddr = addr.String()
+	}
+
+	data := []byte(msg.String())
+	conn, err := p.connections.Get(p.connectionKey(raddr))
+	if err == nil {
+		if err = p.write(conn, data); err == nil {
+			return nil
+		}
+		// A lost connection is dialed again.
+		p.log.Warnf("%s, reconnecting", err)
+	}
+
+	conn, err = p.getOrCreateConnection(target.Host, raddr)
+	if err != nil {
+		return err
+	}
+	return p.write(conn, data)
+}
+
+// write data to conn, the connection is closed on a failure so the next send dials a new one.
+func (p *streamProtocol) write(conn transport.Connection, data []byte) error {
+	conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	if _, err := conn.Write(data); err != nil {
+		conn.Close()
+		return fmt.Errorf("write to the %s connection: %w", conn.Key(), err)
+	}
+	return nil
+}
+
+func (p *streamProtocol) connectionKey(raddr string) transport.ConnectionKey {
+	return transport.ConnectionKey(strings.ToLower(p.network) + ":" + raddr)
+}
+
+func (p *streamProtocol) getOrCreateConnection(host string, raddr string) (transport.Connection, error) {
+	if conn, err := p.connections.Get(p.connectionKey(raddr)); err == nil {
+		return conn, nil
+	}
+
+	baseConn, err := p.dialBackoff(host, raddr)
+	if err != nil {
+		return nil, fmt.Errorf("dial to %s %s: %w", p.network, raddr, err)
+	}
+	return p.put(baseConn, raddr)
+}
+
+// dialBackoff dials addr unless a previous dial to it failed less than its backoff ago.
+func (p *streamProtocol) dialBackoff(host string, addr string) (net.Conn, error) {
+	if err := p.manager.allow(p.network, addr); err != nil {
+		return nil, err
+	}
+	conn, err := p.dial(host, addr, p.control)
+	p.manager.dialed(p.network, addr, err)
+	return conn, err
+}
+
+func (p *streamProtocol) put(baseConn net.Conn, raddr string) (transport.Connection, error) {
+	p.manager.opened(p.network, baseConn)
+	conn := transport.NewConnection(
+		&flowConn{Conn: baseConn, network: p.network, flows: p.flows, manager: p.manager, stats: p.stats, guard: p.guard},
+		p.connectionKey(raddr),
+		strings.ToLower(p.network),
+		p.log,
+	)
+	if err := p.connections.Put(conn, connTTL); err != nil {
+		return conn, fmt.Errorf("put %s connection to the pool: %w", conn.Key(), err)
+	}
+	return conn, nil
+}
+
+// connect connects to one of the servers, the next one is dialed when the previous one failed or did not
+// answer in connectionAttemptDelay (RFC 8305). TLS verifies the name of the server. Returns the connected one.
+func (p *streamProtocol) connect(hops []hop) (int, error) {
+	for i, h := range hops {
+		if _, err := p.connections.Get(p.connectionKey(h.Addr())); err == nil {
+			return i, nil
+		}
+	}
+
+	type attempt struct {
+		i    int
+		conn net.Conn
+		err  error
+	}
+	attempts := make(chan attempt, len(hops))
+	next, pending := 0, 0
+	var wait <-chan time.Time
+	start := func() {
+		h := hops[next]
+		go func(i int) {
+			name := h.Name
+			if len(name) == 0 {
+				name = h.Host
+			}
+			conn, err := p.dialBackoff(name, h.Addr())
+			attempts <- attempt{i: i, conn: conn, err: err}
+		}(next)
+		next++
+		pending++
+		wait = nil
+		if next < len(hops) {
+			wait = time.After(connectionAttemptDelay)
+		}
+	}
+
+	start()
+	var err error
+	for {
+		select {
+		case <-wait:
+			start()
+		case a := <-attempts:
+			pending--
+			if a.err == nil {
+				// Close the connections of the slower servers.
+				go func(pending int) {
+					for ; pending > 0; pending-- {
+						if late := <-attempts; late.conn != nil {
+							late.conn.Close()
+						}
+					}
+				}(pending)
+				if _, err := p.put(a.conn, hops[a.i].Addr()); err != nil {
+					return a.i, err
+				}
+				return a.i, nil
+			}
+			p.log.Warnf("dial to %s %s failed: %s", p.network, hops[a.i].Addr(), a.err)
+			err = a.err
+			if next < len(hops) {
+				start()
+			} else if pending == 0 {
+				return 0, fmt.Errorf("dial to %s %s: %w", p.network, hops[a.i].Addr(), err)
+			}
+		}
+	}
+}
+
+// keepAlive sends a ping on the connection to raddr, the flow is lost with the connection.
+func (p *streamProtocol) keepAlive(raddr string) error {
+	key := transport.ConnectionKey(strings.ToLower(p.network) + ":" + raddr)
+	conn, err := p.connections.Get(key)
+	if err != nil {
+		return err
+	}
+	return p.write(conn, crlfPing)
+}
+
+func listenTCP(addr string, control sockopt, options ...transport.ListenOption) (net.Listener, error) {
+	return listenStream(addr, control)
+}
+
+func dialTCP(host string, addr string, control sockopt) (net.Conn, error) {
+	return dialer(control).Dial("tcp", addr)
+}
@@ -0,0 +1,48 @@
+package stack
+
+import (
+	"strings"
+	"syscall"
+)
+
+// The DSCP classes of RFC 4594.
+const (
+	// DSCPCS3 the class of the signaling.
+	DSCPCS3 = 24
+	// DSCPAF41 the class of the interactive video.
+	DSCPAF41 = 34
+	// DSCPEF the class of the telephony media.
+	DSCPEF = 46
+)
+
+// QoS the marking of the packets of the sockets of a transport.
+type QoS struct {
+	// DSCP the code point set in the IP TOS or IPv6 traffic class, e.g. DSCPCS3, none if zero.
+	DSCP int
+	// Priority the SO_PRIORITY of the sockets on Linux, none if zero.
+	Priority int
+}
+
+// sockopt sets the options of a socket before it is bound or connected, see net.Dialer.Control.
+type sockopt func(network string, address string, c syscall.RawConn) error
+
+// control the sockopt setting the QoS, network is the one of the socket, "udp4", "tcp6"...
+func (q QoS) control(network string, address string, c syscall.RawConn) error {
+	var err error
+	if cerr := c.Control(func(fd uintptr) {
+		err = q.set(fd, strings.HasSuffix(network, "6"))
+	}); cerr != nil {
+		return cerr
+	}
+	return err
+}
+
+// sockopt the sockopt of the QoS of network, nil if none is configured.
+func (s *SipStack) sockopt(network string) sockopt {
+	for n, qos := range s.config.QoS {
+		if strings.EqualFold(n, network) && (qos.DSCP != 0 || qos.Priority != 0) {
+			return qos.control
+		}
+	}
+	return nil
+}
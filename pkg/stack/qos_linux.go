@@ -0,0 +1,30 @@
+//go:build linux
+// +build linux
+
+package stack
+
+import (
+	"fmt"
+	"syscall"
+)
+
+func (q QoS) set(fd uintptr, ipv6 bool) error {
+	if q.DSCP != 0 {
+		tos := q.DSCP << 2
+		if ipv6 {
+			if err := syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_TCLASS, tos); err != nil {
+				return fmt.Errorf("set IPV6_TCLASS: %w", err)
+			}
+			// The IPv4 packets of a dual-stack socket, not supported by an IPv6 only one.
+			syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_TOS, tos)
+		} else if err := syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_TOS, tos); err != nil {
+			return fmt.Errorf("set IP_TOS: %w", err)
+		}
+	}
+	if q.Priority != 0 {
+		if err := syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_PRIORITY, q.Priority); err != nil {
+			return fmt.Errorf("set SO_PRIORITY: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+// +build !linux
+
+package stack
+
+import (
+	"fmt"
+	"runtime"
+)
+
+func (q QoS) set(fd uintptr, ipv6 bool) error {
+	return fmt.Errorf("QoS marking is not supported on %s", runtime.GOOS)
+}
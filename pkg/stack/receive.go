@@ -0,0 +1,90 @@
+package stack
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/ghettovoice/gosip/log"
+	"github.com/ghettovoice/gosip/sip"
+	"github.com/ghettovoice/gosip/sip/parser"
+)
+
+// Receive passes data, a whole message received on network from source (host:port), through the stack as
+// if a listener read it, without a socket: the limits of MaxMessageSize and MaxHeaderSize, the parser,
+// the transactions and the handlers, e.g. to replay a capture or to fuzz the inbound path. The responses,
+// the ones rejecting a malformed message too, are sent by the listener of network if any. An error if
+// the message is dropped.
+func (s *SipStack) Receive(network string, source string, data []byte) error {
+	network = strings.ToUpper(network)
+	data = encodeIPv6Message(data)
+	if ok, res := s.guard.check(network, data); !ok {
+		if res != nil {
+			s.reply(network, source, res)
+		}
+		return fmt.Errorf("%s message from %s dropped", network, source)
+	}
+	msg, err := parseMessage(data, s.Log())
+	if err != nil {
+		return err
+	}
+	msg = ipv6Mapper(s.tp.msgMapper)(msg)
+	if msg == nil {
+		return fmt.Errorf("%s message from %s dropped by the mapper", network, source)
+	}
+
+	host, port, err := net.SplitHostPort(source)
+	if err != nil {
+		return err
+	}
+	local := s.GetNetworkInfo(network)
+	msg.SetDestination(net.JoinHostPort(unbracket(local.Host), local.Port.String()))
+	msg.SetTransport(network)
+	msg.SetSource(source)
+	// The received and rport of the Via as set by the transports (RFC 3261 18.2.1, RFC 3581).
+	if req, ok := msg.(sip.Request); ok {
+		viaHop, ok := req.ViaHop()
+		if !ok {
+			return fmt.Errorf("%s request from %s without Via", network, source)
+		}
+		if viaHop.Params == nil {
+			viaHop.Params = sip.NewParams()
+		}
+		viaHop.Params.Add("received", sip.String{Str: host})
+		if viaHop.Params.Has("rport") {
+			viaHop.Params.Add("rport", sip.String{Str: port})
+		}
+	}
+
+	select {
+	case <-s.tp.canceled:
+		return fmt.Errorf("stack shut down")
+	case s.tp.pmsgs <- msg:
+		return nil
+	}
+}
+
+// reply sends data, the response of the guard to a message dropped, back to source on network as the
+// listener would.
+func (s *SipStack) reply(network string, source string, data []byte) {
+	msg, err := parseMessage(data, s.Log())
+	if err != nil {
+		s.Log().Errorf("parse the response to the %s message from %s failed: %s", network, source, err)
+		return
+	}
+	msg.SetTransport(network)
+	msg.SetDestination(source)
+	if err := s.Send(msg); err != nil && err != ErrDropped {
+		s.Log().Warnf("send %s to %s failed: %s", msg.Short(), source, err)
+	}
+}
+
+// parseMessage parses data, a panic of the parser returned as an error.
+func parseMessage(data []byte, logger log.Logger) (msg sip.Message, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			msg, err = nil, fmt.Errorf("parser panic: %v", r)
+		}
+	}()
+	return parser.ParseMessage(data, logger)
+}
@@ -0,0 +1,253 @@
+package stack
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ghettovoice/gosip/sip"
+)
+
+// naptrServices the NAPTR services of the supported transports (RFC 3263, RFC 7118).
+var naptrServices = map[string]string{
+	"SIP+D2U":  "UDP",
+	"SIP+D2T":  "TCP",
+	"SIPS+D2T": "TLS",
+	"SIP+D2W":  "WS",
+	"SIPS+D2W": "WSS",
+}
+
+// hop a server of the next hop of a request.
+type hop struct {
+	Network string
+	Host    string
+	Port    sip.Port
+	// Name the domain the server was resolved from, verified by TLS (RFC 5922).
+	Name string
+}
+
+func (h hop) Addr() string {
+	return net.JoinHostPort(h.Host, strconv.Itoa(int(h.Port)))
+}
+
+// Resolver the DNS lookups used to locate the servers of a SIP URI,
+// it can be replaced to use a custom DNS client or static records.
+type Resolver interface {
+	LookupNAPTR(ctx context.Context, name string) ([]*NAPTR, error)
+	LookupSRV(ctx context.Context, name string) ([]*net.SRV, error)
+	LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error)
+}
+
+// dnsResolver the Resolver of a DNS server, the system one if nameserver is empty.
+type dnsResolver struct {
+	net        *net.Resolver
+	nameserver string
+}
+
+// NewResolver the Resolver querying nameserver (host:port), the system DNS if empty.
+func NewResolver(nameserver string) Resolver {
+	if len(nameserver) == 0 {
+		return &dnsResolver{
+			net:        net.DefaultResolver,
+			nameserver: systemNameserver(),
+		}
+	}
+	return &dnsResolver{
+		net: &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+				d := net.Dialer{}
+				return d.DialContext(ctx, "udp", nameserver)
+			},
+		},
+		nameserver: nameserver,
+	}
+}
+
+func (r *dnsResolver) LookupNAPTR(ctx context.Context, name string) ([]*NAPTR, error) {
+	return lookupNAPTR(ctx, r.nameserver, name)
+}
+
+func (r *dnsResolver) LookupSRV(ctx context.Context, name string) ([]*net.SRV, error) {
+	_, records, err := r.net.LookupSRV(ctx, "", "", name)
+	return records, err
+}
+
+func (r *dnsResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	return r.net.LookupIPAddr(ctx, host)
+}
+
+// resolver locates the servers of a SIP URI (RFC 3263).
+type resolver struct {
+	dns Resolver
+}
+
+// resolve returns the servers of uri in the order they should be tried,
+// network overrides the transport of uri if not empty.
+func (r *resolver) resolve(ctx context.Context, uri sip.Uri, network string) ([]hop, error) {
+	hops, err := r.resolveURI(ctx, uri, network)
+	for i := range hops {
+		hops[i].Name = unbracket(uri.Host())
+	}
+	return hops, err
+}
+
+func (r *resolver) resolveURI(ctx context.Context, uri sip.Uri, network string) ([]hop, error) {
+	host := unbracket(uri.Host())
+	secure := uri.IsEncrypted()
+
+	if len(network) == 0 && uri.UriParams() != nil {
+		if tp, ok := uri.UriParams().Get("transport"); ok && tp != nil && tp.String() != "" {
+			network = strings.ToUpper(tp.String())
+			if secure {
+				network = secureTransport(network)
+			}
+		}
+	}
+
+	// 4.1 numeric IP or explicit port: no NAPTR nor SRV.
+	if net.ParseIP(host) != nil || uri.Port() != nil {
+		if len(network) == 0 {
+			network = defaultNetwork(secure)
+		}
+		port := sip.DefaultPort(network)
+		if uri.Port() != nil {
+			port = *uri.Port()
+		}
+		return r.lookupHost(ctx, host, network, port)
+	}
+
+	if len(network) > 0 {
+		if hops, err := r.lookupSRV(ctx, host, network); err == nil && len(hops) > 0 {
+			return hops, nil
+		}
+		return r.lookupHost(ctx, host, network, sip.DefaultPort(network))
+	}
+
+	if hops := r.lookupNAPTR(ctx, host, secure); len(hops) > 0 {
+		return hops, nil
+	}
+
+	networks := []string{"UDP", "TCP", "TLS"}
+	if secure {
+		networks = []string{"TLS"}
+	}
+	for _, network := range networks {
+		if hops, err := r.lookupSRV(ctx, host, network); err == nil && len(hops) > 0 {
+			return hops, nil
+		}
+	}
+
+	network = defaultNetwork(secure)
+	return r.lookupHost(ctx, host, network, sip.DefaultPort(network))
+}
+
+// lookupNAPTR selects the transport by the NAPTR records of domain and resolves the SRV of the first usable one.
+func (r *resolver) lookupNAPTR(ctx context.Context, domain string, secure bool) []hop {
+	records, err := r.dns.LookupNAPTR(ctx, domain)
+	if err != nil {
+		return nil
+	}
+	sort.SliceStable(records, func(i, j int) bool {
+		if records[i].Order != records[j].Order {
+			return records[i].Order < records[j].Order
+		}
+		return records[i].Preference < records[j].Preference
+	})
+	for _, record := range records {
+		network, ok := naptrServices[strings.ToUpper(record.Service)]
+		if !ok || !strings.EqualFold(record.Flags, "s") {
+			continue
+		}
+		if secure && !strings.HasPrefix(strings.ToUpper(record.Service), "SIPS") {
+			continue
+		}
+		if hops, err := r.lookupSRVName(ctx, record.Replacement, network); err == nil && len(hops) > 0 {
+			return hops
+		}
+	}
+	return nil
+}
+
+func (r *resolver) lookupSRV(ctx context.Context, domain string, network string) ([]hop, error) {
+	service, proto := "sip", strings.ToLower(network)
+	switch network {
+	case "TLS":
+		service, proto = "sips", "tcp"
+	case "WS", "WSS":
+		return nil, fmt.Errorf("no SRV for %s", network)
+	}
+	return r.lookupSRVName(ctx, fmt.Sprintf("_%s._%s.%s", service, proto, domain), network)
+}
+
+// lookupSRVName the servers of the SRV records of name, sorted by priority and randomized by weight.
+func (r *resolver) lookupSRVName(ctx context.Context, name string, network string) ([]hop, error) {
+	records, err := r.dns.LookupSRV(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	hops := make([]hop, 0, len(records))
+	for _, record := range records {
+		// "." target: the service is not available at this domain.
+		if record.Target == "." {
+			continue
+		}
+		targets, err := r.lookupHost(ctx, strings.TrimSuffix(record.Target, "."), network, sip.Port(record.Port))
+		if err != nil {
+			continue
+		}
+		hops = append(hops, targets...)
+	}
+	return hops, nil
+}
+
+func (r *resolver) lookupHost(ctx context.Context, host string, network string, port sip.Port) ([]hop, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		return []hop{{Network: network, Host: ip.String(), Port: port}}, nil
+	}
+	addrs, err := r.dns.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	// IPv6 and IPv4 alternate, IPv6 first (RFC 8305 4).
+	var ip6, ip4 []hop
+	for _, addr := range addrs {
+		h := hop{Network: network, Host: addr.IP.String(), Port: port, Name: host}
+		if addr.IP.To4() == nil {
+			ip6 = append(ip6, h)
+		} else {
+			ip4 = append(ip4, h)
+		}
+	}
+	hops := make([]hop, 0, len(addrs))
+	for i := 0; i < len(ip6) || i < len(ip4); i++ {
+		if i < len(ip6) {
+			hops = append(hops, ip6[i])
+		}
+		if i < len(ip4) {
+			hops = append(hops, ip4[i])
+		}
+	}
+	return hops, nil
+}
+
+func defaultNetwork(secure bool) string {
+	if secure {
+		return "TLS"
+	}
+	return "UDP"
+}
+
+// nextHop the URI the request is sent to, the first Route or the Request-URI.
+func nextHop(req sip.Request) sip.Uri {
+	uri := req.Recipient()
+	if hdrs := req.GetHeaders("Route"); len(hdrs) > 0 {
+		if route, ok := hdrs[0].(*sip.RouteHeader); ok && len(route.Addresses) > 0 {
+			uri = route.Addresses[0]
+		}
+	}
+	return uri
+}
@@ -0,0 +1,76 @@
+package stack
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ghettovoice/gosip/sip"
+)
+
+// RetryAfter the Retry-After of res, e.g. of a 503, a 486 or a 600 (RFC 3261 20.33), without its comment
+// and its duration, false if res has none.
+func RetryAfter(res sip.Response) (time.Duration, bool) {
+	if res == nil {
+		return 0, false
+	}
+	hdrs := res.GetHeaders("Retry-After")
+	if len(hdrs) == 0 {
+		return 0, false
+	}
+	value := strings.TrimSpace(hdrs[0].Value())
+	end := strings.IndexFunc(value, func(r rune) bool { return r < '0' || r > '9' })
+	if end >= 0 {
+		value = value[:end]
+	}
+	seconds, err := strconv.ParseUint(value, 10, 32)
+	if err != nil {
+		return 0, false
+	}
+	return time.Duration(seconds) * time.Second, true
+}
+
+// retryLater sends the request to the last server again after the Retry-After of its 503, if within
+// SipStackConfig.MaxRetryAfter, in a new transaction, once. Nil if the request is not retried or was canceled
+// meanwhile.
+func (ftx *failoverTx) retryLater(res sip.Response) sip.ClientTransaction {
+	if ftx.s.config.MaxRetryAfter == 0 || ftx.retriedLater {
+		return nil
+	}
+	delay, ok := RetryAfter(res)
+	if !ok || delay > ftx.s.config.MaxRetryAfter {
+		return nil
+	}
+	ftx.retriedLater = true
+	// The first of the servers left is the last one tried.
+	hops, err := ftx.s.tp.route(ftx.origin)
+	if err != nil {
+		return nil
+	}
+	hops = hops[:1]
+
+	ftx.s.Log().Infof("%s got 503, trying %s again after %v", ftx.origin.Short(), hops[0].Addr(), delay)
+	timer := ftx.s.Clock().NewTimer(delay)
+	select {
+	case <-timer.C():
+	case <-ftx.canceled:
+		timer.Stop()
+		return nil
+	case <-ftx.s.tp.Done():
+		timer.Stop()
+		return nil
+	}
+	if viaHop, ok := ftx.origin.ViaHop(); ok {
+		viaHop.Params.Add("branch", sip.String{Str: ftx.s.NewBranch()})
+	}
+	ftx.s.tp.pin(ftx.origin, hops)
+	tx, err := ftx.s.tx.Request(ftx.origin)
+	if err != nil {
+		ftx.s.Log().Warnf("%s failed again: %s", ftx.origin.Short(), err)
+		return nil
+	}
+	ftx.mu.Lock()
+	ftx.tx = tx
+	ftx.mu.Unlock()
+	return tx
+}
@@ -0,0 +1,17 @@
+//go:build linux
+// +build linux
+
+package stack
+
+import (
+	"fmt"
+
+	"golang.org/x/sys/unix"
+)
+
+func reusePort(fd uintptr) error {
+	if err := unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEPORT, 1); err != nil {
+		return fmt.Errorf("set SO_REUSEPORT: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+// +build !linux
+
+package stack
+
+import (
+	"fmt"
+	"runtime"
+)
+
+func reusePort(fd uintptr) error {
+	return fmt.Errorf("SO_REUSEPORT sockets are not supported on %s", runtime.GOOS)
+}
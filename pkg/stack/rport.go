@@ -0,0 +1,29 @@
+package stack
+
+import (
+	"strconv"
+
+	"github.com/ghettovoice/gosip/sip"
+)
+
+// Received the address the request of res was received from, by the received and rport parameters
+// of its top Via (RFC 3581), an IPv6 host is bracketed as in a URI. ok is false if the peer did not fill rport.
+func Received(res sip.Response) (host string, port sip.Port, ok bool) {
+	viaHop, found := res.ViaHop()
+	if !found || viaHop.Params == nil {
+		return "", 0, false
+	}
+	rport, found := viaHop.Params.Get("rport")
+	if !found || rport == nil || rport.String() == "" {
+		return "", 0, false
+	}
+	p, err := strconv.Atoi(rport.String())
+	if err != nil {
+		return "", 0, false
+	}
+	host = viaHop.Host
+	if received, found := viaHop.Params.Get("received"); found && received != nil && received.String() != "" {
+		host = received.String()
+	}
+	return sipHost(host), sip.Port(p), true
+}
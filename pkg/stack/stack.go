@@ -1,21 +1,22 @@
 package stack
 
 import (
-	"context"
 	"errors"
 	"fmt"
 	"io"
 	"net"
+	"sort"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/sergeyu/go-sip-ua/pkg/auth"
+	"github.com/sergeyu/go-sip-ua/pkg/clock"
+	"github.com/sergeyu/go-sip-ua/pkg/transaction"
 	"github.com/tevino/abool"
 
 	"github.com/ghettovoice/gosip/log"
 	"github.com/ghettovoice/gosip/sip"
-	"github.com/ghettovoice/gosip/transaction"
 	"github.com/ghettovoice/gosip/transport"
 	"github.com/ghettovoice/gosip/util"
 	"github.com/sergeyu/go-sip-ua/pkg/utils"
@@ -26,6 +27,24 @@ const (
 	DefaultUserAgent = "Go SipStack/1.0.0"
 )
 
+// knownMethods the methods defined by the SIP RFCs, other methods are answered with 501.
+var knownMethods = map[sip.RequestMethod]bool{
+	sip.INVITE:    true,
+	sip.ACK:       true,
+	sip.CANCEL:    true,
+	sip.BYE:       true,
+	sip.REGISTER:  true,
+	sip.OPTIONS:   true,
+	sip.SUBSCRIBE: true,
+	sip.NOTIFY:    true,
+	sip.REFER:     true,
+	sip.INFO:      true,
+	sip.MESSAGE:   true,
+	"PRACK":       true,
+	"UPDATE":      true,
+	"PUBLISH":     true,
+}
+
 // RequestHandler is a callback that will be called on the incoming request
 // of the certain method
 // tx argument can be nil for 2xx ACK request
@@ -38,26 +57,89 @@ type RequiresChallengeHandler func(req sip.Request) bool
 type ServerAuthManager struct {
 	Authenticator     *auth.ServerAuthorizer
 	RequiresChallenge RequiresChallengeHandler
+	// TrustPeerCertificates skips the digest challenge of the requests of the peers
+	// authenticated by their TLS client certificate, see TLSConfig.ClientAuth.
+	TrustPeerCertificates bool
 }
 
 // SipStackConfig describes available options
 type SipStackConfig struct {
 	// Public IP address or domain name, if empty auto resolved IP will be used.
 	Host string
-	// Dns is an address of the public DNS server to use in SRV lookup.
+	// Dns is an address of the public DNS server to use in NAPTR and SRV lookups.
 	Dns               string
 	Extensions        []string
 	MsgMapper         sip.MessageMapper
 	ServerAuthManager ServerAuthManager
 	UserAgent         string
+	// TLS certificates and verification of the TLS transport,
+	// if nil the peers are verified against the system CAs.
+	TLS *TLSConfig
+	// DisableTCPFallback keeps requests larger than the UDP MTU threshold on UDP
+	// instead of switching them to TCP (RFC 3261 18.1.1).
+	DisableTCPFallback bool
+	// Resolver of the NAPTR, SRV and A/AAAA lookups, overrides Dns.
+	// If nil the DNS server is queried through a cache.
+	Resolver Resolver
+	// TransportFailover the transports a request timing out on all the servers of one is retried on,
+	// in order, e.g. UDP, TCP, TLS. Disabled if empty.
+	TransportFailover []string
+	// STUN discovers the public address used in Via and Contact, disabled if nil.
+	STUN *STUNConfig
+	// PublicAddress host[:port] advertised in the Via and Contact of the listeners without their own host
+	// instead of their address or the one found by STUN, e.g. the address a NAT forwards to the stack.
+	// The port of each listener if it has none.
+	PublicAddress string
+	// QoS marks the packets of the sockets by transport, e.g. "UDP": {DSCP: DSCPCS3}.
+	QoS map[string]QoS
+	// Transports the custom transports by network, e.g. "MEM", used as the built-in ones by Listen
+	// and by the requests with the transport.
+	Transports map[string]Transport
+	// UDPSockets the sockets opened with SO_REUSEPORT on each UDP address, each one with its read loop,
+	// to spread the receiving of a high rate of requests across the cores. Linux only, one if zero.
+	UDPSockets int
+	// ShutdownTimeout how long Shutdown waits for the transactions in progress, Timer B if zero.
+	ShutdownTimeout time.Duration
+	// Timers the timers of the transactions, T1, T2, T4 and the timeouts, the RFC 3261 defaults if zero.
+	Timers transaction.Timers
+	// MaxMessageSize the largest message received, a larger request is answered with 513 and dropped,
+	// DefaultMaxMessageSize if zero.
+	MaxMessageSize int
+	// MaxHeaderSize the largest header section received, a larger message is dropped, DefaultMaxHeaderSize if zero.
+	MaxHeaderSize int
+	// IDs the generators of the Call-IDs, the Via branches and the tags, random if nil.
+	IDs IDGenerator
+	// MaxForwards the Max-Forwards of the requests originated by the stack and its UA, DefaultMaxForwards if zero.
+	MaxForwards int
+	// LoopDetection answers 482 to the requests received with the Via of a request the stack sent, and 483
+	// to the ones with Max-Forwards 0 but OPTIONS, e.g. for a B2BUA or a proxy. A spiral back to the stack
+	// and a request sent to itself are taken for a loop too.
+	LoopDetection bool
+	// MaxRetryAfter the longest Retry-After of a 503 the last server of a failover is sent the request again
+	// after, once, in the same client transaction, instead of failing it. Disabled if zero.
+	MaxRetryAfter time.Duration
+	// CompactHeaders writes the names of the headers having one in their compact form, e.g. v, f, t, i, m
+	// and c (RFC 3261 7.3.3), to keep header-heavy requests on UDP under the MTU.
+	CompactHeaders bool
+	// StrictValidation checks the requests and responses before they are sent, e.g. their mandatory headers,
+	// the method of their CSeq, the Contact of the dialog-forming ones and their Expires, and fails the
+	// malformed ones with a *ValidationError instead of sending them.
+	StrictValidation bool
+	// NetworkCheckInterval how often the addresses of the interfaces are checked for a network change, e.g. from
+	// Wi-Fi to LTE, the transports rebound and STUN run again on a change, see OnNetworkChange. Disabled if zero.
+	NetworkCheckInterval time.Duration
+	// Clock the clock of the timers of the stack and its UA: the transactions, the keepalives, the checks
+	// of STUN and of the network, the refreshes of the registrations, e.g. a clock.Mock to fast-forward
+	// them in the tests. clock.Real if nil.
+	Clock clock.Clock
 }
 
 // SipStack a golang SIP Stack
 type SipStack struct {
 	running               abool.AtomicBool
+	draining              abool.AtomicBool
 	config                *SipStackConfig
-	listenPorts           map[string]*sip.Port
-	tp                    transport.Layer
+	tp                    *layer
 	tx                    transaction.Layer
 	host                  string
 	ip                    net.IP
@@ -69,6 +151,20 @@ type SipStack struct {
 	invites               map[transaction.TxKey]sip.Request
 	invitesLock           *sync.RWMutex
 	authenticator         *ServerAuthManager
+	tlsConfig             *TLSConfig
+	stun                  *stunClient
+	stunCheck             chan struct{}
+	flows                 *flowTable
+	conns                 *connManager
+	txs                   *txTracker
+	stats                 *stats
+	guard                 *guard
+	mappedAddressHandler  MappedAddressHandler
+	networkChangeHandler  NetworkChangeHandler
+	networkCheck          chan struct{}
+	interceptors          *interceptors
+	loops                 *loopDetector
+	conformance           conformance
 	log                   log.Logger
 }
 
@@ -78,7 +174,7 @@ func NewSipStack(config *SipStackConfig) *SipStack {
 		config = &SipStackConfig{}
 	}
 
-	logger := utils.NewLogrusLogger(log.DebugLevel, "SipStack", nil)
+	logger := utils.NewLogger(log.DebugLevel, "SipStack", nil)
 
 	var host string
 	var ip net.IP
@@ -98,17 +194,9 @@ func NewSipStack(config *SipStackConfig) *SipStack {
 		}
 	}
 
-	var dnsResolver *net.Resolver
-	if config.Dns != "" {
-		dnsResolver = &net.Resolver{
-			PreferGo: true,
-			Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
-				d := net.Dialer{}
-				return d.DialContext(ctx, "udp", config.Dns)
-			},
-		}
-	} else {
-		dnsResolver = net.DefaultResolver
+	res := &resolver{dns: config.Resolver}
+	if res.dns == nil {
+		res.dns = NewCachingResolver(NewResolver(config.Dns), DefaultDNSCacheTTL, DefaultDNSNegativeTTL)
 	}
 
 	var extensions []string
@@ -118,7 +206,6 @@ func NewSipStack(config *SipStackConfig) *SipStack {
 
 	s := &SipStack{
 		config:          config,
-		listenPorts:     make(map[string]*sip.Port),
 		host:            host,
 		ip:              ip,
 		hwg:             new(sync.WaitGroup),
@@ -127,22 +214,58 @@ func NewSipStack(config *SipStackConfig) *SipStack {
 		extensions:      extensions,
 		invites:         make(map[transaction.TxKey]sip.Request),
 		invitesLock:     new(sync.RWMutex),
+		stun:            &stunClient{},
+		stunCheck:       make(chan struct{}, 1),
+		networkCheck:    make(chan struct{}, 1),
+		flows:           &flowTable{},
+		conns:           &connManager{},
+		txs:             &txTracker{active: make(map[sip.TransactionKey]*trackedTx), clock: clock.Or(config.Clock)},
+		stats:           &stats{window: config.Timers.Timeout()},
+		interceptors:    &interceptors{},
+	}
+
+	if config.LoopDetection {
+		s.loops = newLoopDetector(config.Timers.Timeout())
 	}
 
 	if config.ServerAuthManager.Authenticator != nil {
 		s.authenticator = &config.ServerAuthManager
 	}
 
+	if config.TLS != nil {
+		tlsConfig := *config.TLS
+		s.tlsConfig = &tlsConfig
+	} else {
+		s.tlsConfig = &TLSConfig{}
+	}
+	s.tlsConfig.peers = s.conns
+
 	s.log = logger
-	s.tp = transport.NewLayer(ip, dnsResolver, config.MsgMapper, utils.NewLogrusLogger(log.DebugLevel, "transport.Layer", nil))
-	sipTp := &sipTransport{
-		tpl: s.tp,
-		s:   s,
+	s.guard = newGuard(config.MaxMessageSize, config.MaxHeaderSize, s.stats, logger)
+	s.tp = newLayer(host, ip, res, config.MsgMapper, s.newProtocol, utils.NewLogger(log.DebugLevel, "transport.Layer", nil))
+	s.tp.rewriteSDP = config.STUN != nil && config.STUN.RewriteSDP
+	s.tp.compact = config.CompactHeaders
+	if config.PublicAddress != "" {
+		s.tp.public = parsePublicAddress(config.PublicAddress)
 	}
-	s.tx = transaction.NewLayer(sipTp, utils.NewLogrusLogger(log.DebugLevel, "transaction.Layer", nil))
+	s.tp.stats = s.stats
+	for network := range config.Transports {
+		s.tp.opaque[strings.ToUpper(network)] = true
+	}
+	timers := config.Timers
+	if timers.Clock == nil {
+		timers.Clock = config.Clock
+	}
+	s.tx = transaction.NewLayer(newSipTransport(s.tp, s), timers, utils.NewLogger(log.DebugLevel, "transaction.Layer", nil))
 
 	s.running.Set()
 	go s.serve()
+	if config.STUN != nil {
+		go s.serveSTUN()
+	}
+	if config.NetworkCheckInterval > 0 {
+		go s.serveNetwork()
+	}
 
 	return s
 }
@@ -152,30 +275,64 @@ func (s *SipStack) Log() log.Logger {
 	return s.log
 }
 
-// ListenTLS starts serving listeners on the provided address
+// ListenTLS starts serving listeners on the provided address,
+// the cert and key of options override the ones of the TLS config of the stack.
 func (s *SipStack) ListenTLS(protocol string, listenAddr string, options *transport.TLSConfig) error {
+	return s.listen(protocol, listenAddr, "", options)
+}
+
+func (s *SipStack) Listen(protocol string, listenAddr string) error {
+	return s.ListenTLS(protocol, listenAddr, nil)
+}
+
+// ListenWithHost starts serving listeners on the provided address, e.g. of one interface, host is
+// advertised in the Via and Contact of the messages sent through it instead of the host of the stack.
+// The listener of a transport used for a destination is the one bound to the local IP routed to it.
+func (s *SipStack) ListenWithHost(protocol string, listenAddr string, host string) error {
+	return s.listen(protocol, listenAddr, host, nil)
+}
+
+func (s *SipStack) listen(protocol string, listenAddr string, host string, options *transport.TLSConfig) error {
 	var err error
 	network := strings.ToUpper(protocol)
 	if options != nil {
-		err = s.tp.Listen(network, listenAddr, options)
+		err = s.tp.listen(network, listenAddr, host, options)
 	} else {
-		err = s.tp.Listen(network, listenAddr)
+		err = s.tp.listen(network, listenAddr, host)
 	}
 	if err == nil {
-		target, err := transport.NewTargetFromAddr(listenAddr)
-		if err != nil {
-			return err
-		}
-		target = transport.FillTargetHostAndPort(network, target)
-		if _, ok := s.listenPorts[network]; !ok {
-			s.listenPorts[network] = target.Port
-		}
+		s.checkSTUN()
 	}
 	return err
 }
 
-func (s *SipStack) Listen(protocol string, listenAddr string) error {
-	return s.ListenTLS(protocol, listenAddr, nil)
+// newProtocol creates the protocols of the transport layer, TLS and WSS use the TLS config of the stack.
+// The IPv6 references encoded by their connections are decoded after the parsing.
+func (s *SipStack) newProtocol(
+	network string,
+	output chan<- sip.Message,
+	errs chan<- error,
+	cancel <-chan struct{},
+	msgMapper sip.MessageMapper,
+	logger log.Logger,
+) (transport.Protocol, error) {
+	msgMapper = ipv6Mapper(msgMapper)
+	if tp, ok := s.customTransport(network); ok {
+		return s.newCustomProtocol(network, tp, output, errs, cancel, msgMapper, logger), nil
+	}
+	switch strings.ToUpper(network) {
+	case "UDP":
+		return newUDPProtocol(s.stun, s.flows, s.stats, s.guard, s.sockopt(network), s.config.UDPSockets, output, errs, cancel, msgMapper, logger), nil
+	case "TCP":
+		return newStreamProtocol(network, listenTCP, dialTCP, s.sockopt(network), s.flows, s.conns, s.stats, s.guard, output, errs, cancel, msgMapper, logger), nil
+	case "TLS":
+		return newStreamProtocol(network, s.tlsConfig.listenTLS, s.tlsConfig.dialTLS, s.sockopt(network), s.flows, s.conns, s.stats, s.guard, output, errs, cancel, msgMapper, logger), nil
+	case "WS":
+		return newStreamProtocol(network, listenWS(nil), dialWS(nil), s.sockopt(network), s.flows, s.conns, s.stats, s.guard, output, errs, cancel, msgMapper, logger), nil
+	case "WSS":
+		return newStreamProtocol(network, listenWS(s.tlsConfig), dialWS(s.tlsConfig), s.sockopt(network), s.flows, s.conns, s.stats, s.guard, output, errs, cancel, msgMapper, logger), nil
+	}
+	return transport.GetProtocolFactory()(network, output, errs, cancel, msgMapper, logger)
 }
 
 func (s *SipStack) serve() {
@@ -187,6 +344,11 @@ func (s *SipStack) serve() {
 			if !ok {
 				return
 			}
+			if s.draining.IsSet() && !drainable(tx.Origin().Method()) {
+				go s.rejectDraining(tx.Origin())
+				continue
+			}
+			s.txs.track(tx.Key(), tx.Origin(), true, tx.Done())
 			s.hwg.Add(1)
 			go s.handleRequest(tx.Origin(), tx)
 		case ack, ok := <-s.tx.Acks():
@@ -245,6 +407,29 @@ func (s *SipStack) handleRequest(req sip.Request, tx sip.ServerTransaction) {
 	logger := s.Log().WithFields(req.Fields())
 	logger.Debugf("routing incoming SIP request...")
 
+	if reason := malformedRequest(req); reason != "" {
+		logger.Warnf("drop malformed SIP request: %s", reason)
+		if tx != nil && !req.IsAck() {
+			if _, err := s.Respond(sip.NewResponseFromRequest("", req, 400, "Bad Request", "")); err != nil {
+				logger.Errorf("respond '400 Bad Request' failed: %s", err)
+			}
+		}
+		return
+	}
+	if res := s.unsupportedRequest(req); res != nil {
+		logger.Warnf("reject unsupported SIP request: %d %s", res.StatusCode(), res.Reason())
+		if tx != nil {
+			if _, err := s.Respond(res); err != nil {
+				logger.Errorf("respond '%d %s' failed: %s", res.StatusCode(), res.Reason(), err)
+			}
+		}
+		return
+	}
+
+	if tx != nil && s.rejectLoop(req) {
+		return
+	}
+
 	s.hmu.RLock()
 	handler, ok := s.requestHandlers[req.Method()]
 	s.hmu.RUnlock()
@@ -252,6 +437,10 @@ func (s *SipStack) handleRequest(req sip.Request, tx sip.ServerTransaction) {
 	if !ok {
 		logger.Warnf("SIP request %v handler not found", req.Method())
 
+		if req.IsAck() || tx == nil {
+			return
+		}
+
 		go func(tx sip.ServerTransaction, logger log.Logger) {
 			for {
 				select {
@@ -267,9 +456,20 @@ func (s *SipStack) handleRequest(req sip.Request, tx sip.ServerTransaction) {
 			}
 		}(tx, logger)
 
-		res := sip.NewResponseFromRequest("", req, 405, "Method Not Allowed", "")
+		// 405 for methods we know but do not handle, 501 for unknown methods.
+		var res sip.Response
+		if _, known := knownMethods[req.Method()]; known {
+			res = sip.NewResponseFromRequest("", req, 405, "Method Not Allowed", "")
+		} else {
+			res = sip.NewResponseFromRequest("", req, 501, "Not Implemented", "")
+		}
+		allow := make(sip.AllowHeader, 0)
+		for _, method := range s.getAllowedMethods() {
+			allow = append(allow, method)
+		}
+		res.AppendHeader(allow)
 		if _, err := s.Respond(res); err != nil {
-			logger.Errorf("respond '405 Method Not Allowed' failed: %s", err)
+			logger.Errorf("respond '%d %s' failed: %s", res.StatusCode(), res.Reason(), err)
 		}
 
 		return
@@ -278,7 +478,7 @@ func (s *SipStack) handleRequest(req sip.Request, tx sip.ServerTransaction) {
 	if s.authenticator != nil {
 		authenticator := s.authenticator.Authenticator
 		requiresChallenge := s.authenticator.RequiresChallenge
-		if requiresChallenge(req) {
+		if !s.trustedPeer(req) && requiresChallenge(req) {
 			go func() {
 				if _, ok := authenticator.Authenticate(req, tx); ok {
 					handler(req, tx)
@@ -291,34 +491,125 @@ func (s *SipStack) handleRequest(req sip.Request, tx sip.ServerTransaction) {
 	go handler(req, tx)
 }
 
+// Authenticated reports whether the request was authenticated by the server authenticator before it was passed to the handler.
+func (s *SipStack) Authenticated(req sip.Request) bool {
+	if s.authenticator == nil || s.authenticator.Authenticator == nil {
+		return false
+	}
+	return s.trustedPeer(req) || s.authenticator.RequiresChallenge(req)
+}
+
+// PeerIdentity the identity of the peer of a request received over a connection authenticated
+// by its TLS client certificate, see TLSConfig.PeerIdentity.
+func (s *SipStack) PeerIdentity(req sip.Request) (string, bool) {
+	return s.conns.peer(req.Destination(), req.Source())
+}
+
+// trustedPeer reports whether the request is from a peer authenticated by its certificate that skips the digest.
+func (s *SipStack) trustedPeer(req sip.Request) bool {
+	if !s.authenticator.TrustPeerCertificates {
+		return false
+	}
+	_, ok := s.PeerIdentity(req)
+	return ok
+}
+
 //Request Send SIP message
 func (s *SipStack) Request(req sip.Request) (sip.ClientTransaction, error) {
 	if !s.running.IsSet() {
 		return nil, fmt.Errorf("can not send through stopped server")
 	}
-	return s.tx.Request(s.prepareRequest(req))
-}
+	if s.draining.IsSet() && !drainable(req.Method()) {
+		return nil, fmt.Errorf("can not send %s through shutting down server", req.Method())
+	}
 
-func (s *SipStack) GetNetworkInfo(protocol string) *transport.Target {
-	logger := s.Log()
+	req = s.prepareRequest(req)
+	if err := s.validate(req); err != nil {
+		return nil, err
+	}
+	hops, err := s.tp.route(req)
+	if err != nil {
+		return nil, err
+	}
+	// The transport selected by NAPTR or SRV.
+	if network := hops[0].Network; !strings.EqualFold(network, req.Transport()) {
+		req.SetTransport(network)
+	}
 
-	var target transport.Target
-	if s.host != "" {
-		target.Host = s.host
-	} else if v, err := util.ResolveSelfIP(); err == nil {
-		target.Host = v.String()
-	} else {
-		logger.Panicf("resolve host IP failed: %s", err)
+	tx, err := s.tx.Request(req)
+	for err != nil {
+		s.Log().Warnf("send %s over %s failed: %s", req.Short(), req.Transport(), err)
+		var ok bool
+		if req, ok = s.switchTransport(req); !ok {
+			return nil, err
+		}
+		tx, err = s.tx.Request(req)
+	}
+	if _, ok := s.nextTransport(req); len(hops) < 2 && !ok {
+		s.txs.track(tx.Key(), req, false, tx.Done())
+		return tx, nil
 	}
+	ftx := newFailoverTx(s, req, tx)
+	s.txs.track(ftx.key, req, false, ftx.done)
+	return ftx, nil
+}
 
-	network := strings.ToUpper(protocol)
-	if p, ok := s.listenPorts[network]; ok {
-		target.Port = p
-	} else {
-		defPort := sip.DefaultPort(network)
-		target.Port = &defPort
+// nextTransport the transport after the one of the request in the failover chain,
+// a sips: request stays on a secure one.
+func (s *SipStack) nextTransport(req sip.Request) (string, bool) {
+	chain := s.config.TransportFailover
+	for i := range chain {
+		if !strings.EqualFold(chain[i], req.Transport()) {
+			continue
+		}
+		for _, network := range chain[i+1:] {
+			network = strings.ToUpper(network)
+			if !isSecure(req) || network == secureTransport(network) {
+				return network, true
+			}
+		}
 	}
-	return &target
+	return "", false
+}
+
+// switchTransport moves the request in a new transaction to the next transport of the failover chain
+// its servers can be resolved for.
+func (s *SipStack) switchTransport(req sip.Request) (sip.Request, bool) {
+	for {
+		network, ok := s.nextTransport(req)
+		if !ok {
+			return req, false
+		}
+		from := req.Transport()
+		if viaHop, ok := req.ViaHop(); ok {
+			viaHop.Params.Add("branch", sip.String{Str: s.NewBranch()})
+			// sent-by is set again by the listener of the transport.
+			viaHop.Host, viaHop.Port = "", nil
+		}
+		req = withTransport(req, network)
+		if _, err := s.tp.reroute(req, network); err != nil {
+			s.Log().Warnf("%s can not fail over from %s: %s", req.Short(), from, err)
+			continue
+		}
+		s.Log().Infof("%s failed over %s, trying %s", req.Short(), from, network)
+		return req, true
+	}
+}
+
+// GetNetworkInfo the address of the transport for a Contact, an IPv6 host is bracketed as in a URI.
+func (s *SipStack) GetNetworkInfo(protocol string) *transport.Target {
+	return s.tp.networkInfo(protocol, "")
+}
+
+// GetNetworkInfoFor the address of the transport for a Contact in the messages to host,
+// the one of its listener bound to the local IP routed to host.
+func (s *SipStack) GetNetworkInfoFor(protocol string, host string) *transport.Target {
+	return s.tp.networkInfo(protocol, host)
+}
+
+// Clock the clock of the timers of the stack, see SipStackConfig.Clock.
+func (s *SipStack) Clock() clock.Clock {
+	return clock.Or(s.config.Clock)
 }
 
 func (s *SipStack) RememberInviteRequest(request sip.Request) {
@@ -327,7 +618,7 @@ func (s *SipStack) RememberInviteRequest(request sip.Request) {
 		s.invites[key] = request
 		s.invitesLock.Unlock()
 
-		time.AfterFunc(time.Minute, func() {
+		s.Clock().AfterFunc(time.Minute, func() {
 			s.invitesLock.Lock()
 			delete(s.invites, key)
 			s.invitesLock.Unlock()
@@ -371,14 +662,14 @@ func (s *SipStack) prepareRequest(req sip.Request) sip.Request {
 			viaHop.Params = sip.NewParams()
 		}
 		if !viaHop.Params.Has("branch") {
-			viaHop.Params.Add("branch", sip.String{Str: sip.GenerateBranch()})
+			viaHop.Params.Add("branch", sip.String{Str: s.NewBranch()})
 		}
 	} else {
 		viaHop = &sip.ViaHop{
 			ProtocolName:    "SIP",
 			ProtocolVersion: "2.0",
 			Params: sip.NewParams().
-				Add("branch", sip.String{Str: sip.GenerateBranch()}),
+				Add("branch", sip.String{Str: s.NewBranch()}),
 		}
 
 		req.PrependHeaderAfter(sip.ViaHeader{
@@ -386,18 +677,87 @@ func (s *SipStack) prepareRequest(req sip.Request) sip.Request {
 		}, "Route")
 	}
 
+	if isSecure(req) {
+		req.SetTransport(secureTransport(req.Transport()))
+	}
+
+	// The ACK and CANCEL built by the transactions have the default of gosip.
+	if req.IsAck() || req.IsCancel() {
+		req.RemoveHeader("Max-Forwards")
+	}
+	if len(req.GetHeaders("Max-Forwards")) == 0 {
+		maxForwards := sip.MaxForwards(s.MaxForwards())
+		req.AppendHeader(&maxForwards)
+	}
+
 	s.appendAutoHeaders(req)
 
+	if s.config.DisableTCPFallback && isSizeFallback(req) {
+		return withTransport(req, "UDP")
+	}
+	// The size of a request sent with the compact header names.
+	if s.config.CompactHeaders && isSizeFallback(req) && len(compactString(req)) <= int(sip.MTU)-200 {
+		return withTransport(req, "UDP")
+	}
+
 	return req
 }
 
+// transportRequest keeps a request on the transport set, gosip switches an oversized UDP one to TCP
+// and the transport of its URI wins over the set one.
+type transportRequest struct {
+	sip.Request
+	transport string
+}
+
+func (req *transportRequest) Transport() string {
+	return req.transport
+}
+
+func (req *transportRequest) SetTransport(tp string) {
+	req.transport = strings.ToUpper(tp)
+	req.Request.SetTransport(tp)
+}
+
+// WithFields keeps the wrapper, the transactions send the request returned.
+func (req *transportRequest) WithFields(fields log.Fields) sip.Message {
+	req.Request.WithFields(fields)
+	return req
+}
+
+func withTransport(req sip.Request, network string) sip.Request {
+	if treq, ok := req.(*transportRequest); ok {
+		treq.SetTransport(network)
+		return treq
+	}
+	req.SetTransport(network)
+	return &transportRequest{Request: req, transport: strings.ToUpper(network)}
+}
+
+// isSizeFallback reports whether the request was switched from UDP to TCP because of its size.
+func isSizeFallback(req sip.Request) bool {
+	if req.Transport() != "TCP" || len(req.String()) <= int(sip.MTU)-200 {
+		return false
+	}
+	// The transport of the request without its body and other headers.
+	headers := make([]sip.Header, 0)
+	headers = append(headers, req.GetHeaders("Via")...)
+	headers = append(headers, req.GetHeaders("Route")...)
+	probe := sip.NewRequest("", req.Method(), req.Recipient(), req.SipVersion(), headers, "", nil)
+	return probe.Transport() == "UDP"
+}
+
 // Respond .
 func (s *SipStack) Respond(res sip.Response) (sip.ServerTransaction, error) {
 	if !s.running.IsSet() {
 		return nil, fmt.Errorf("can not send through stopped server")
 	}
 
-	return s.tx.Respond(s.prepareResponse(res))
+	res = s.prepareResponse(res)
+	if err := s.validate(res); err != nil {
+		return nil, err
+	}
+	return s.tx.Respond(res)
 }
 
 func (s *SipStack) RespondOnRequest(
@@ -425,13 +785,32 @@ func (s *SipStack) Send(msg sip.Message) error {
 		return fmt.Errorf("can not send through stopped server")
 	}
 
+	var response sip.Response
 	switch m := msg.(type) {
 	case sip.Request:
 		msg = s.prepareRequest(m)
 	case sip.Response:
 		msg = s.prepareResponse(m)
+		response = m
+	}
+	if err := s.validate(msg); err != nil {
+		return err
 	}
 
+	// A response dropped does not end its transaction.
+	if msg = s.interceptors.intercept(true, msg); msg == nil {
+		return ErrDropped
+	}
+	if response != nil {
+		s.txs.responded(response)
+	}
+	if req, ok := msg.(sip.Request); ok && s.loops != nil {
+		s.loops.sent(req)
+	}
+	// The responses to the messages of CheckConformance are checked, not sent.
+	if res, ok := msg.(sip.Response); ok && s.conformance.capture(res) {
+		return nil
+	}
 	return s.tp.Send(msg)
 }
 
@@ -440,11 +819,15 @@ func (s *SipStack) prepareResponse(res sip.Response) sip.Response {
 	return res
 }
 
-// Shutdown gracefully shutdowns SIP server
+// Shutdown gracefully shutdowns SIP server. The new requests are answered with 503 and the transactions
+// in progress are waited for, up to the shutdown timeout, before the listeners are closed.
 func (s *SipStack) Shutdown() {
-	if !s.running.IsSet() {
+	if !s.running.IsSet() || !s.draining.SetToIf(false, true) {
 		return
 	}
+	if n := s.txs.wait(s.config.shutdownTimeout()); n > 0 {
+		s.Log().Warnf("shutting down with %d transactions in progress", n)
+	}
 	s.running.UnSet()
 	// stop transaction layer
 	s.tx.Cancel()
@@ -528,8 +911,13 @@ func (s *SipStack) appendAutoHeaders(msg sip.Message) {
 	}
 }
 
+// AllowedMethods returns the methods with a registered request handler.
+func (s *SipStack) AllowedMethods() []sip.RequestMethod {
+	return s.getAllowedMethods()
+}
+
 func (s *SipStack) getAllowedMethods() []sip.RequestMethod {
-	methods := []sip.RequestMethod{
+	ordered := []sip.RequestMethod{
 		sip.INVITE,
 		sip.ACK,
 		sip.BYE,
@@ -537,37 +925,78 @@ func (s *SipStack) getAllowedMethods() []sip.RequestMethod {
 		sip.INFO,
 		sip.OPTIONS,
 	}
-	added := map[sip.RequestMethod]bool{
-		sip.INVITE:  true,
-		sip.ACK:     true,
-		sip.BYE:     true,
-		sip.CANCEL:  true,
-		sip.INFO:    true,
-		sip.OPTIONS: true,
-	}
 
 	s.hmu.RLock()
+	defer s.hmu.RUnlock()
+
+	methods := make([]sip.RequestMethod, 0, len(s.requestHandlers))
+	added := make(map[sip.RequestMethod]bool)
+	for _, method := range ordered {
+		if _, ok := s.requestHandlers[method]; ok {
+			methods = append(methods, method)
+			added[method] = true
+		}
+	}
+
+	others := make([]string, 0)
 	for method := range s.requestHandlers {
 		if _, ok := added[method]; !ok {
-			methods = append(methods, method)
+			others = append(others, string(method))
 		}
 	}
-	s.hmu.RUnlock()
+	sort.Strings(others)
+	for _, method := range others {
+		methods = append(methods, sip.RequestMethod(method))
+	}
 
 	return methods
 }
 
 type sipTransport struct {
-	tpl transport.Layer
-	s   *SipStack
+	tpl      transport.Layer
+	s        *SipStack
+	messages chan sip.Message
+}
+
+func newSipTransport(tpl transport.Layer, s *SipStack) *sipTransport {
+	tp := &sipTransport{
+		tpl:      tpl,
+		s:        s,
+		messages: make(chan sip.Message),
+	}
+	go tp.serve()
+	return tp
+}
+
+// serve passes up the messages of the transport layer, the final responses end their transactions
+// for the shutdown.
+func (tp *sipTransport) serve() {
+	defer close(tp.messages)
+	for msg := range tp.tpl.Messages() {
+		if msg = tp.s.interceptors.intercept(false, msg); msg == nil {
+			continue
+		}
+		if res, ok := msg.(sip.Response); ok {
+			tp.s.txs.received(res)
+		}
+		select {
+		case tp.messages <- msg:
+		case <-tp.tpl.Done():
+			return
+		}
+	}
 }
 
 func (tp *sipTransport) Messages() <-chan sip.Message {
-	return tp.tpl.Messages()
+	return tp.messages
 }
 
 func (tp *sipTransport) Send(msg sip.Message) error {
-	return tp.s.Send(msg)
+	if err := tp.s.Send(msg); err != nil {
+		return err
+	}
+	tp.s.stats.transactionSent(msg)
+	return nil
 }
 
 func (tp *sipTransport) IsReliable(network string) bool {
@@ -0,0 +1,147 @@
+package stack
+
+import (
+	"expvar"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ghettovoice/gosip/sip"
+)
+
+// TransportStats the counters of a transport.
+type TransportStats struct {
+	MessagesSent     uint64
+	MessagesReceived uint64
+	// ParseErrors the messages framed by the transport but dropped by the parser.
+	ParseErrors uint64
+	// Oversized the messages dropped for exceeding MaxMessageSize or MaxHeaderSize.
+	Oversized uint64
+	// Retransmissions the requests and responses sent again by the transactions.
+	Retransmissions uint64
+	// ActiveConnections the open connections, or the sockets of UDP.
+	ActiveConnections int
+}
+
+type counters struct {
+	sent          uint64
+	received      uint64
+	framed        uint64
+	retransmitted uint64
+	oversized     uint64
+}
+
+// stats the counters of the transports by network.
+type stats struct {
+	counters sync.Map
+	// sent the messages sent by the transactions by ID and branch, for the retransmissions.
+	sent sync.Map
+	// window how long a message sent is remembered to count its retransmissions, Timer B.
+	window time.Duration
+}
+
+func (st *stats) of(network string) *counters {
+	network = strings.ToUpper(network)
+	if v, ok := st.counters.Load(network); ok {
+		return v.(*counters)
+	}
+	v, _ := st.counters.LoadOrStore(network, &counters{})
+	return v.(*counters)
+}
+
+func (st *stats) messageSent(network string) {
+	atomic.AddUint64(&st.of(network).sent, 1)
+}
+
+func (st *stats) messageReceived(network string) {
+	atomic.AddUint64(&st.of(network).received, 1)
+}
+
+// messageFramed counts a message cut by the transport before the parsing.
+func (st *stats) messageFramed(network string) {
+	atomic.AddUint64(&st.of(network).framed, 1)
+}
+
+func (st *stats) messageOversized(network string) {
+	atomic.AddUint64(&st.of(network).oversized, 1)
+}
+
+// transactionSent counts a message sent by the transactions as a retransmission if it was sent before.
+func (st *stats) transactionSent(msg sip.Message) {
+	viaHop, ok := msg.ViaHop()
+	if !ok {
+		return
+	}
+	key := string(msg.MessageID())
+	if viaHop.Params != nil {
+		if branch, ok := viaHop.Params.Get("branch"); ok && branch != nil {
+			key += ";" + branch.String()
+		}
+	}
+	if _, loaded := st.sent.LoadOrStore(key, struct{}{}); loaded {
+		network := viaHop.Transport
+		if req, ok := msg.(sip.Request); ok {
+			network = req.Transport()
+		}
+		atomic.AddUint64(&st.of(network).retransmitted, 1)
+		return
+	}
+	time.AfterFunc(st.window, func() {
+		st.sent.Delete(key)
+	})
+}
+
+// Stats the counters of the transports by network, "UDP", "TCP"...
+func (s *SipStack) Stats() map[string]TransportStats {
+	all := make(map[string]TransportStats)
+	s.stats.counters.Range(func(k, v interface{}) bool {
+		c := v.(*counters)
+		ts := TransportStats{
+			MessagesSent:     atomic.LoadUint64(&c.sent),
+			MessagesReceived: atomic.LoadUint64(&c.received),
+			Retransmissions:  atomic.LoadUint64(&c.retransmitted),
+			Oversized:        atomic.LoadUint64(&c.oversized),
+		}
+		if framed := atomic.LoadUint64(&c.framed); framed > ts.MessagesReceived+ts.Oversized {
+			ts.ParseErrors = framed - ts.MessagesReceived - ts.Oversized
+		}
+		all[k.(string)] = ts
+		return true
+	})
+	for network, n := range s.tp.connections() {
+		ts := all[network]
+		ts.ActiveConnections = n
+		all[network] = ts
+	}
+	return all
+}
+
+// PublishExpvar publishes the counters of the transports as the expvar name, e.g. "sip_transports".
+// It panics if name is already published.
+func (s *SipStack) PublishExpvar(name string) {
+	expvar.Publish(name, expvar.Func(func() interface{} {
+		return s.Stats()
+	}))
+}
+
+// TransactionEvent the end of a transaction of the stack, once, with its final response.
+type TransactionEvent struct {
+	Request sip.Request
+	// Response the final response sent or received, nil if the transaction timed out or failed.
+	Response sip.Response
+	// Server whether the stack answered the request, false if it sent it.
+	Server bool
+	// Duration from the request to the final response.
+	Duration time.Duration
+}
+
+// TransactionHandler is called when a transaction ends, it must not block the transactions.
+type TransactionHandler func(event TransactionEvent)
+
+// OnTransactionEnd registers the handler of the transactions ended, e.g. for the metrics of the stack.
+func (s *SipStack) OnTransactionEnd(handler TransactionHandler) {
+	s.txs.mu.Lock()
+	s.txs.handler = handler
+	s.txs.mu.Unlock()
+}
@@ -0,0 +1,240 @@
+package stack
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ghettovoice/gosip/sip"
+	"github.com/ghettovoice/gosip/transport"
+	"github.com/sergeyu/go-sip-ua/pkg/stun"
+)
+
+const (
+	// DefaultSTUNInterval how often the public address is checked.
+	DefaultSTUNInterval = 5 * time.Minute
+
+	stunRTO               = 500 * time.Millisecond
+	stunMaxRetransmits    = 4
+	stunDefaultServerPort = "3478"
+)
+
+// STUNConfig the discovery of the public address by a STUN server (RFC 5389).
+type STUNConfig struct {
+	// Server host[:port] of the STUN server.
+	Server string
+	// Interval of the re-checks, DefaultSTUNInterval if zero.
+	Interval time.Duration
+	// RewriteSDP replaces the local IP in the SDP bodies by the public one.
+	RewriteSDP bool
+}
+
+func (c *STUNConfig) interval() time.Duration {
+	if c.Interval <= 0 {
+		return DefaultSTUNInterval
+	}
+	return c.Interval
+}
+
+func (c *STUNConfig) server() (*net.UDPAddr, error) {
+	addr := c.Server
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(strings.Trim(addr, "[]"), stunDefaultServerPort)
+	}
+	return net.ResolveUDPAddr("udp", addr)
+}
+
+// MappedAddressHandler is called when the public address of a transport changes.
+type MappedAddressHandler func(network string, addr *transport.Target)
+
+// stunClient the binding transactions, the responses are received by the sockets of the transports.
+type stunClient struct {
+	pending sync.Map
+}
+
+// binding the public address of conn seen by server.
+func (c *stunClient) binding(conn net.PacketConn, server net.Addr) (*net.UDPAddr, error) {
+	request := stun.New(stun.BindingRequest)
+	req := request.Marshal(nil, false)
+
+	responses := make(chan *net.UDPAddr, 1)
+	c.pending.Store(request.ID, responses)
+	defer c.pending.Delete(request.ID)
+
+	rto := stunRTO
+	for i := 0; i <= stunMaxRetransmits; i++ {
+		if _, err := conn.WriteTo(req, server); err != nil {
+			return nil, err
+		}
+		select {
+		case addr := <-responses:
+			return addr, nil
+		case <-time.After(rto):
+			rto *= 2
+		}
+	}
+	return nil, fmt.Errorf("STUN binding to %s timed out", server)
+}
+
+// handle passes a binding response to its transaction, reports whether b is a STUN message.
+func (c *stunClient) handle(b []byte) bool {
+	if !stun.IsMessage(b) {
+		return false
+	}
+	m, err := stun.Parse(b)
+	if err != nil || m.Type != stun.BindingSuccess {
+		return true
+	}
+	if v, ok := c.pending.Load(m.ID); ok {
+		// The XOR-MAPPED-ADDRESS, or the MAPPED-ADDRESS of an RFC 3489 server.
+		if addr, err := m.MappedAddress(); err == nil {
+			select {
+			case v.(chan *net.UDPAddr) <- addr:
+			default:
+			}
+		}
+	}
+	return true
+}
+
+// OnMappedAddress registers the handler of the public address changes found by STUN.
+func (s *SipStack) OnMappedAddress(handler MappedAddressHandler) {
+	s.hmu.Lock()
+	s.mappedAddressHandler = handler
+	s.hmu.Unlock()
+}
+
+// MappedAddress the public address of the transport found by STUN.
+func (s *SipStack) MappedAddress(network string) (*transport.Target, bool) {
+	return s.tp.mappedAddress(network)
+}
+
+// serveSTUN checks the public address of the transports until the stack is shut down.
+func (s *SipStack) serveSTUN() {
+	ticker := s.Clock().NewTicker(s.config.STUN.interval())
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stunCheck:
+		case <-ticker.C():
+		case <-s.tp.Done():
+			return
+		}
+		s.discover()
+	}
+}
+
+// checkSTUN asks for a check of the public address, e.g. after a new listener.
+func (s *SipStack) checkSTUN() {
+	if s.config.STUN == nil {
+		return
+	}
+	select {
+	case s.stunCheck <- struct{}{}:
+	default:
+	}
+}
+
+// discover the public address of each listening transport. The UDP one is asked through the SIP socket,
+// the other ones get the public IP and their listening port.
+func (s *SipStack) discover() {
+	server, err := s.config.STUN.server()
+	if err != nil {
+		s.Log().Warnf("resolve STUN server %s failed: %s", s.config.STUN.Server, err)
+		return
+	}
+
+	// The first listener of each transport.
+	s.tp.pmu.RLock()
+	listeners := make(map[string]listener, len(s.tp.listeners))
+	for network, networkListeners := range s.tp.listeners {
+		if len(networkListeners) > 0 {
+			listeners[network] = networkListeners[0]
+		}
+	}
+	s.tp.pmu.RUnlock()
+
+	var ip net.IP
+	if ln, ok := listeners["UDP"]; ok {
+		addr, err := s.bindingUDP(server, &ln)
+		if err != nil {
+			s.Log().Warnf("STUN binding of UDP port %d failed: %s", ln.port, err)
+		} else {
+			ip = addr.IP
+			s.setMappedAddress("UDP", addr)
+		}
+	}
+	for network, ln := range listeners {
+		if network == "UDP" {
+			continue
+		}
+		if ip == nil {
+			addr, err := s.bindingUDP(server, nil)
+			if err != nil {
+				s.Log().Warnf("STUN binding failed: %s", err)
+				return
+			}
+			ip = addr.IP
+		}
+		s.setMappedAddress(network, &net.UDPAddr{IP: ip, Port: int(ln.port)})
+	}
+}
+
+// bindingUDP the public address of the SIP socket of ln, of a new socket if ln is nil.
+func (s *SipStack) bindingUDP(server *net.UDPAddr, ln *listener) (*net.UDPAddr, error) {
+	if ln == nil {
+		conn, err := net.ListenUDP("udp", nil)
+		if err != nil {
+			return nil, err
+		}
+		defer conn.Close()
+		go func() {
+			buf := make([]byte, 1024)
+			for {
+				n, _, err := conn.ReadFrom(buf)
+				if err != nil {
+					return
+				}
+				s.stun.handle(buf[:n])
+			}
+		}()
+		return s.stun.binding(conn, server)
+	}
+
+	protocol, ok := s.tp.protocol("UDP")
+	if !ok {
+		return nil, fmt.Errorf("no UDP transport")
+	}
+	udp, ok := protocol.(*udpProtocol)
+	if !ok {
+		return nil, fmt.Errorf("unexpected UDP transport %s", protocol)
+	}
+	_, ip := s.tp.local()
+	if ln.bound() {
+		ip = ln.ip
+	}
+	conn, ok := udp.conn(net.JoinHostPort(ip.String(), ln.port.String()))
+	if !ok {
+		return nil, fmt.Errorf("no UDP socket on port %d", ln.port)
+	}
+	return s.stun.binding(conn, server)
+}
+
+func (s *SipStack) setMappedAddress(network string, addr *net.UDPAddr) {
+	port := sip.Port(addr.Port)
+	target := &transport.Target{Host: addr.IP.String(), Port: &port}
+	if !s.tp.setMappedAddress(network, target) {
+		return
+	}
+
+	s.Log().Infof("public address of %s is %s", network, target.Addr())
+	s.hmu.RLock()
+	handler := s.mappedAddressHandler
+	s.hmu.RUnlock()
+	if handler != nil {
+		handler(network, target)
+	}
+}
@@ -0,0 +1,76 @@
+package stack_test
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/ghettovoice/gosip/log"
+	"github.com/ghettovoice/gosip/transport"
+	"github.com/sergeyu/go-sip-ua/pkg/stack"
+	"github.com/sergeyu/go-sip-ua/pkg/stun"
+	"github.com/sergeyu/go-sip-ua/pkg/utils"
+)
+
+// TestSTUNMappedAddress the public address of the UDP transport, found by a binding through its SIP socket.
+func TestSTUNMappedAddress(t *testing.T) {
+	utils.SetLogSink(utils.LogSinkFunc(func(level log.Level, msg string, fields log.Fields) {}))
+	defer utils.SetLogSink(nil)
+	server, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	public := &net.UDPAddr{IP: net.IPv4(198, 51, 100, 7).To4(), Port: 5062}
+	sources := make(chan net.Addr, 1)
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, from, err := server.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			m, err := stun.Parse(buf[:n])
+			if err != nil || m.Type != stun.BindingRequest {
+				continue
+			}
+			resp := m.Response(stun.BindingSuccess).Add(stun.AttrXORMappedAddress, stun.XORAddress(public, m.ID))
+			server.WriteTo(resp.Marshal(nil, true), from)
+			select {
+			case sources <- from:
+			default:
+			}
+		}
+	}()
+
+	s := stack.NewSipStack(&stack.SipStackConfig{Host: "127.0.0.1", STUN: &stack.STUNConfig{Server: server.LocalAddr().String()}})
+	defer s.Shutdown()
+	mapped := make(chan *transport.Target, 1)
+	s.OnMappedAddress(func(network string, addr *transport.Target) {
+		if network == "UDP" {
+			mapped <- addr
+		}
+	})
+	// A port of its own, the listener of port 0 not knowing the one of its socket.
+	free, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := free.LocalAddr().String()
+	free.Close()
+	if err := s.Listen("udp", addr); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case addr := <-mapped:
+		if addr.Addr() != public.String() {
+			t.Errorf("mapped address = %s; want %s", addr.Addr(), public)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("no mapped address")
+	}
+	// Asked through the SIP socket.
+	if from := <-sources; from.String() != addr {
+		t.Errorf("binding from %s; want the SIP socket %s", from, addr)
+	}
+}
@@ -0,0 +1,244 @@
+package stack
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"strings"
+
+	"github.com/ghettovoice/gosip/sip"
+	"github.com/ghettovoice/gosip/transport"
+)
+
+// TLSVerifyMode how the certificate of the peer is verified.
+type TLSVerifyMode int
+
+const (
+	// TLSVerifyPeer verifies the certificate chain and that it matches the target host.
+	TLSVerifyPeer TLSVerifyMode = iota
+	// TLSVerifyCA verifies the certificate chain only, e.g. for peers addressed by IP.
+	TLSVerifyCA
+	// TLSVerifyNone accepts any certificate.
+	TLSVerifyNone
+)
+
+// TLSConfig certificates and verification of the TLS transport.
+type TLSConfig struct {
+	// Cert and Key PEM files of the local certificate, required to listen,
+	// presented to the servers asking for a client certificate too.
+	Cert string
+	Key  string
+	// CA PEM file of the trusted CAs, the system pool is used if empty.
+	CA string
+	// RootCAs trusted CAs, overrides CA.
+	RootCAs *x509.CertPool
+	// MinVersion e.g. tls.VersionTLS12, TLS 1.2 if zero.
+	MinVersion uint16
+	Verify     TLSVerifyMode
+	// ServerName expected in the certificate of the peer, the target host if empty.
+	ServerName string
+	// ClientAuth the certificates asked from the clients by the listeners, e.g. tls.RequireAndVerifyClientCert,
+	// verified against ClientCAs, or the trusted CAs if nil. None if zero.
+	ClientAuth tls.ClientAuthType
+	ClientCAs  *x509.CertPool
+	// PeerIdentity maps a verified client certificate to the identity of the peer, e.g. an account or a trunk,
+	// reports false for an unknown one. The SIP URI, DNS name or common name of the certificate if nil.
+	PeerIdentity func(cert *x509.Certificate) (string, bool)
+
+	// peers records the identities of the verified clients.
+	peers *connManager
+}
+
+func (c *TLSConfig) minVersion() uint16 {
+	if c.MinVersion == 0 {
+		return tls.VersionTLS12
+	}
+	return c.MinVersion
+}
+
+func (c *TLSConfig) rootCAs() (*x509.CertPool, error) {
+	if c.RootCAs != nil || len(c.CA) == 0 {
+		return c.RootCAs, nil
+	}
+	data, err := ioutil.ReadFile(c.CA)
+	if err != nil {
+		return nil, fmt.Errorf("read CA file %s: %w", c.CA, err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificate found in %s", c.CA)
+	}
+	return pool, nil
+}
+
+// serverConfig the config of the listener, cert and key of the listen options win over the configured ones.
+func (c *TLSConfig) serverConfig(options ...transport.ListenOption) (*tls.Config, error) {
+	certFile, keyFile := c.Cert, c.Key
+	opts := transport.ListenOptions{}
+	for _, opt := range options {
+		opt.ApplyListen(&opts)
+	}
+	if len(opts.TLSConfig.Cert) > 0 {
+		certFile, keyFile = opts.TLSConfig.Cert, opts.TLSConfig.Key
+	}
+	if len(certFile) == 0 {
+		return nil, fmt.Errorf("no TLS certificate configured")
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("load TLS certificate %s: %w", certFile, err)
+	}
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   c.minVersion(),
+		ClientAuth:   c.ClientAuth,
+	}
+	if c.ClientAuth == tls.NoClientCert {
+		return config, nil
+	}
+	config.ClientCAs = c.ClientCAs
+	if config.ClientCAs == nil {
+		if config.ClientCAs, err = c.rootCAs(); err != nil {
+			return nil, err
+		}
+	}
+	// The identity is recorded by the addresses of the connection.
+	config.GetConfigForClient = func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+		conf := config.Clone()
+		conf.GetConfigForClient = nil
+		laddr, raddr := hello.Conn.LocalAddr().String(), hello.Conn.RemoteAddr().String()
+		conf.VerifyConnection = func(state tls.ConnectionState) error {
+			if len(state.VerifiedChains) == 0 || c.peers == nil {
+				return nil
+			}
+			if identity, ok := c.peerIdentity(state.PeerCertificates[0]); ok {
+				c.peers.verified(laddr, raddr, identity)
+			}
+			return nil
+		}
+		return conf, nil
+	}
+	return config, nil
+}
+
+func (c *TLSConfig) peerIdentity(cert *x509.Certificate) (string, bool) {
+	if c.PeerIdentity != nil {
+		return c.PeerIdentity(cert)
+	}
+	return certIdentity(cert)
+}
+
+// certIdentity the identity of a certificate: its SIP URI, else its DNS name, its common name
+// without a subject alternative name (RFC 5922 7.1).
+func certIdentity(cert *x509.Certificate) (string, bool) {
+	for _, uri := range cert.URIs {
+		if uri.Scheme == "sip" || uri.Scheme == "sips" {
+			return uri.Opaque, len(uri.Opaque) > 0
+		}
+	}
+	if len(cert.DNSNames) > 0 {
+		return cert.DNSNames[0], true
+	}
+	if len(cert.URIs) == 0 && len(cert.Subject.CommonName) > 0 {
+		return cert.Subject.CommonName, true
+	}
+	return "", false
+}
+
+// clientConfig the config to dial host.
+func (c *TLSConfig) clientConfig(host string) (*tls.Config, error) {
+	roots, err := c.rootCAs()
+	if err != nil {
+		return nil, err
+	}
+	config := &tls.Config{
+		RootCAs:    roots,
+		MinVersion: c.minVersion(),
+		ServerName: c.ServerName,
+	}
+	if len(c.Cert) > 0 {
+		cert, err := tls.LoadX509KeyPair(c.Cert, c.Key)
+		if err != nil {
+			return nil, fmt.Errorf("load TLS certificate %s: %w", c.Cert, err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	if len(config.ServerName) == 0 {
+		config.ServerName = host
+	}
+
+	switch c.Verify {
+	case TLSVerifyCA:
+		// Verify the chain by hand, the host name is not checked.
+		config.InsecureSkipVerify = true
+		config.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			return verifyChain(rawCerts, roots)
+		}
+	case TLSVerifyNone:
+		config.InsecureSkipVerify = true
+	}
+	return config, nil
+}
+
+func verifyChain(rawCerts [][]byte, roots *x509.CertPool) error {
+	if len(rawCerts) == 0 {
+		return fmt.Errorf("no peer certificate")
+	}
+	certs := make([]*x509.Certificate, 0, len(rawCerts))
+	for _, raw := range rawCerts {
+		cert, err := x509.ParseCertificate(raw)
+		if err != nil {
+			return err
+		}
+		certs = append(certs, cert)
+	}
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, err := certs[0].Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+	})
+	return err
+}
+
+// listenTLS the listener of the TLS transport.
+func (c *TLSConfig) listenTLS(addr string, control sockopt, options ...transport.ListenOption) (net.Listener, error) {
+	config, err := c.serverConfig(options...)
+	if err != nil {
+		return nil, err
+	}
+	listener, err := listenStream(addr, control)
+	if err != nil {
+		return nil, err
+	}
+	return tls.NewListener(listener, config), nil
+}
+
+// dialTLS connects to addr, host is the name verified in the certificate of the peer.
+func (c *TLSConfig) dialTLS(host string, addr string, control sockopt) (net.Conn, error) {
+	config, err := c.clientConfig(host)
+	if err != nil {
+		return nil, err
+	}
+	return tls.DialWithDialer(dialer(control), "tcp", addr, config)
+}
+
+// isSecure reports whether the request goes to a sips: URI, the first Route or the Request-URI.
+func isSecure(req sip.Request) bool {
+	uri := nextHop(req)
+	return uri != nil && uri.IsEncrypted()
+}
+
+// secureTransport the transport of a sips: request, TLS or WSS (RFC 3261 26.2.2).
+func secureTransport(network string) string {
+	switch strings.ToUpper(network) {
+	case "WS", "WSS":
+		return "WSS"
+	default:
+		return "TLS"
+	}
+}
@@ -0,0 +1,51 @@
+package stack_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ghettovoice/gosip/log"
+	"github.com/ghettovoice/gosip/sip"
+	"github.com/sergeyu/go-sip-ua/pkg/session"
+	"github.com/sergeyu/go-sip-ua/pkg/stack"
+	"github.com/sergeyu/go-sip-ua/pkg/ua"
+	"github.com/sergeyu/go-sip-ua/pkg/utils"
+)
+
+// limitations the torture messages the parser of gosip fails on, rejected with a 400.
+var limitations = map[string]string{
+	"wsinv":  "the escaped quotes of a display name and the LWS around the parameters of the From",
+	"unksm2": "the URIs of schemes other than sip and sips of the To and the From",
+}
+
+func TestCheckConformance(t *testing.T) {
+	utils.SetLogSink(utils.LogSinkFunc(func(level log.Level, msg string, fields log.Fields) {}))
+	s := stack.NewSipStack(&stack.SipStackConfig{Host: "192.0.2.1"})
+	defer s.Shutdown()
+	userAgent, err := ua.NewUserAgent(ua.WithSipStack(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	userAgent.InviteStateHandler = func(is *session.Session, req *sip.Request, resp *sip.Response, status session.Status) {
+		if status == session.InviteReceived {
+			is.Reject(486, "Busy Here")
+		}
+	}
+
+	report := s.CheckConformance(context.Background())
+	if got, want := len(report.Results), len(stack.TortureMessages()); got != want {
+		t.Fatalf("len(Results) = %d; want %d", got, want)
+	}
+	for _, result := range report.Results {
+		if limitation, ok := limitations[result.Message.Name]; ok {
+			if result.Pass || result.Status != 400 {
+				t.Errorf("%v; want a 400 for %s", result, limitation)
+			}
+			continue
+		}
+		if !result.Pass {
+			t.Error(result)
+		}
+	}
+	t.Logf("\n%s", report)
+}
@@ -0,0 +1,82 @@
+package stack
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ghettovoice/gosip/sip"
+)
+
+// RawMessage a message sent or received by the stack, its bytes and addresses, e.g. to trace it or to
+// write it to a pcap file.
+type RawMessage struct {
+	// Network the transport, e.g. UDP, TCP, TLS or WS.
+	Network string
+	// Source and Destination host:port. The Source of a request sent on a stream transport is the address
+	// of the listener, not the one of the connection.
+	Source      string
+	Destination string
+	Time        time.Time
+	// Data the message as written to the transport, or as parsed if it was received.
+	Data []byte
+}
+
+// RawMessageHandler is called with the messages sent or received in the transport path, it must not block it.
+type RawMessageHandler func(msg RawMessage)
+
+// messageTap the handlers of the messages sent and received by the transport layer.
+type messageTap struct {
+	mu       sync.RWMutex
+	sent     RawMessageHandler
+	received RawMessageHandler
+}
+
+// OnMessageSent registers the handler of the messages sent, once per server tried.
+func (s *SipStack) OnMessageSent(handler RawMessageHandler) {
+	s.tp.tap.mu.Lock()
+	s.tp.tap.sent = handler
+	s.tp.tap.mu.Unlock()
+}
+
+// OnMessageReceived registers the handler of the messages received, the ones dropped by the transports excluded.
+func (s *SipStack) OnMessageReceived(handler RawMessageHandler) {
+	s.tp.tap.mu.Lock()
+	s.tp.tap.received = handler
+	s.tp.tap.mu.Unlock()
+}
+
+// handler the handler of a direction, nil if none.
+func (t *messageTap) handler(sent bool) RawMessageHandler {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if sent {
+		return t.sent
+	}
+	return t.received
+}
+
+// messageSent passes msg sent to destination to the handler.
+func (t *messageTap) messageSent(network string, msg sip.Message, destination string) {
+	if handler := t.handler(true); handler != nil {
+		handler(RawMessage{
+			Network:     strings.ToUpper(network),
+			Source:      msg.Source(),
+			Destination: destination,
+			Time:        time.Now(),
+			Data:        []byte(msg.String()),
+		})
+	}
+}
+
+func (t *messageTap) messageReceived(msg sip.Message) {
+	if handler := t.handler(false); handler != nil {
+		handler(RawMessage{
+			Network:     strings.ToUpper(msg.Transport()),
+			Source:      msg.Source(),
+			Destination: msg.Destination(),
+			Time:        time.Now(),
+			Data:        []byte(msg.String()),
+		})
+	}
+}
@@ -0,0 +1,238 @@
+package stack
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"syscall"
+
+	"github.com/ghettovoice/gosip/log"
+	"github.com/ghettovoice/gosip/sip"
+	"github.com/ghettovoice/gosip/transport"
+)
+
+// udpConn a UDP socket shared by SIP, STUN and the CRLF keepalives, only the SIP messages are passed to the parser.
+type udpConn struct {
+	*net.UDPConn
+	stun  *stunClient
+	flows *flowTable
+	stats *stats
+	guard *guard
+}
+
+func (c *udpConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	for {
+		n, raddr, err := c.UDPConn.ReadFrom(b)
+		switch {
+		case err != nil:
+			return n, raddr, err
+		case c.stun.handle(b[:n]):
+		case isCRLF(b[:n]):
+			if n >= len(crlfPing) {
+				c.UDPConn.WriteTo(crlfPong, raddr)
+			} else {
+				c.flows.pong("UDP", raddr.String())
+			}
+		default:
+			c.stats.messageFramed("UDP")
+			data := encodeIPv6Message(b[:n])
+			if ok, res := c.guard.check("UDP", data); !ok {
+				if res != nil {
+					c.UDPConn.WriteTo(res, raddr)
+				}
+				continue
+			}
+			// The headers are encoded in place if they still fit.
+			if len(data) != n && len(data) <= len(b) {
+				n = copy(b, data)
+			}
+			return n, raddr, err
+		}
+	}
+}
+
+// udpProtocol the UDP transport, same as the gosip one but the sockets are used for STUN and keepalives too.
+type udpProtocol struct {
+	stun    *stunClient
+	flows   *flowTable
+	stats   *stats
+	guard   *guard
+	control sockopt
+	// sockets the sockets opened with SO_REUSEPORT on each address, each one with its read loop.
+	sockets     int
+	connections transport.ConnectionPool
+	log         log.Logger
+}
+
+func newUDPProtocol(
+	stun *stunClient,
+	flows *flowTable,
+	stats *stats,
+	guard *guard,
+	control sockopt,
+	sockets int,
+	output chan<- sip.Message,
+	errs chan<- error,
+	cancel <-chan struct{},
+	msgMapper sip.MessageMapper,
+	logger log.Logger,
+) transport.Protocol {
+	p := &udpProtocol{
+		stun:    stun,
+		flows:   flows,
+		stats:   stats,
+		guard:   guard,
+		control: control,
+		sockets: sockets,
+	}
+	p.log = logger.WithFields(log.Fields{
+		"protocol_ptr": fmt.Sprintf("%p", p),
+	})
+	p.connections = transport.NewConnectionPool(output, errs, cancel, msgMapper, p.log)
+	return p
+}
+
+func (p *udpProtocol) String() string {
+	return fmt.Sprintf("transport.Protocol<%s>", p.log.Fields().WithFields(log.Fields{"network": "UDP"}))
+}
+
+func (p *udpProtocol) Network() string {
+	return "UDP"
+}
+
+func (p *udpProtocol) Reliable() bool {
+	return false
+}
+
+func (p *udpProtocol) Streamed() bool {
+	return false
+}
+
+func (p *udpProtocol) Done() <-chan struct{} {
+	return p.connections.Done()
+}
+
+func (p *udpProtocol) Listen(target *transport.Target, options ...transport.ListenOption) error {
+	target = transport.FillTargetHostAndPort("UDP", target)
+	addr := net.JoinHostPort(target.Host, target.Port.String())
+	laddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return fmt.Errorf("resolve target address UDP %s: %w", addr, err)
+	}
+	sockets, control := 1, p.control
+	if p.sockets > 1 {
+		sockets, control = p.sockets, withReusePort(p.control)
+	}
+	conns := make([]*net.UDPConn, 0, sockets)
+	for i := 0; i < sockets; i++ {
+		config := net.ListenConfig{Control: control}
+		packetConn, err := config.ListenPacket(context.Background(), "udp", addr)
+		if err != nil {
+			for _, conn := range conns {
+				conn.Close()
+			}
+			return fmt.Errorf("listen on UDP %s: %w", laddr, err)
+		}
+		conns = append(conns, packetConn.(*net.UDPConn))
+		// The port picked for the first one.
+		addr = packetConn.LocalAddr().String()
+	}
+
+	p.log.Debugf("begin listening on UDP %s with %d sockets", addr, sockets)
+
+	// Indexed by the local address, no expiry.
+	for i, conn := range conns {
+		key := transport.ConnectionKey("udp:" + addr)
+		if i > 0 {
+			key = transport.ConnectionKey(fmt.Sprintf("udp:%s#%d", addr, i))
+		}
+		if err := p.connections.Put(transport.NewConnection(&udpConn{UDPConn: conn, stun: p.stun, flows: p.flows, stats: p.stats, guard: p.guard}, key, "udp", p.log), 0); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// withReusePort the sockopt setting SO_REUSEPORT before control, the kernel spreads the datagrams
+// to a port across its sockets.
+func withReusePort(control sockopt) sockopt {
+	return func(network string, address string, c syscall.RawConn) error {
+		var err error
+		if cerr := c.Control(func(fd uintptr) {
+			err = reusePort(fd)
+		}); cerr != nil {
+			return cerr
+		}
+		if err != nil || control == nil {
+			return err
+		}
+		return control(network, address, c)
+	}
+}
+
+func (p *udpProtocol) Send(target *transport.Target, msg sip.Message) error {
+	target = transport.FillTargetHostAndPort("UDP", target)
+	if target.Host == "" {
+		return fmt.Errorf("empty remote target host")
+	}
+
+	addr := net.JoinHostPort(target.Host, target.Port.String())
+	raddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return fmt.Errorf("resolve target address UDP %s: %w", addr, err)
+	}
+
+	conn, ok := p.conn(msg.Source())
+	if !ok {
+		// e.g. a CANCEL with the public port of the INVITE.
+		conns := p.connections.All()
+		if len(conns) == 0 {
+			return fmt.Errorf("connection on %s not found", msg.Source())
+		}
+		conn = conns[0]
+	}
+
+	log.AddFieldsFrom(p.log, conn, msg).Tracef("writing SIP message to UDP %s", raddr)
+	if _, err = conn.WriteTo([]byte(msg.String()), raddr); err != nil {
+		return fmt.Errorf("write SIP message to the %s connection: %w", conn.Key(), err)
+	}
+	return nil
+}
+
+// conn the socket listening on addr, or on its port on all the interfaces.
+func (p *udpProtocol) conn(addr string) (transport.Connection, bool) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, false
+	}
+	ip := net.ParseIP(host)
+	var found transport.Connection
+	for _, conn := range p.connections.All() {
+		laddr, ok := conn.LocalAddr().(*net.UDPAddr)
+		if !ok || strconv.Itoa(laddr.Port) != port {
+			continue
+		}
+		if laddr.IP.Equal(ip) {
+			return conn, true
+		}
+		if found == nil || laddr.IP.IsUnspecified() {
+			found = conn
+		}
+	}
+	return found, found != nil
+}
+
+// keepAlive sends a double CRLF probe to raddr.
+func (p *udpProtocol) keepAlive(raddr string) error {
+	addr, err := net.ResolveUDPAddr("udp", raddr)
+	if err != nil {
+		return err
+	}
+	conns := p.connections.All()
+	if len(conns) == 0 {
+		return fmt.Errorf("no UDP socket")
+	}
+	_, err = conns[0].WriteTo(crlfPing, addr)
+	return err
+}
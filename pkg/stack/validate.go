@@ -0,0 +1,240 @@
+package stack
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/ghettovoice/gosip/sip"
+	"github.com/sergeyu/go-sip-ua/pkg/multipart"
+)
+
+// ValidationError a message not sent, failed the validation of SipStackConfig.StrictValidation.
+type ValidationError struct {
+	Message sip.Message
+	Reason  string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("invalid %s: %s", messageName(e.Message), e.Reason)
+}
+
+// mandatoryHeaders the headers of all the requests (RFC 3261 8.1.1), the responses have them but Max-Forwards.
+var mandatoryHeaders = []string{"Via", "From", "To", "Call-ID", "CSeq", "Max-Forwards"}
+
+// validate checks the message before it is sent if the validation is enabled, a *ValidationError if malformed.
+func (s *SipStack) validate(msg sip.Message) error {
+	if !s.config.StrictValidation {
+		return nil
+	}
+	if reason := validationFailure(msg); reason != "" {
+		return &ValidationError{Message: msg, Reason: reason}
+	}
+	return nil
+}
+
+// validationFailure why msg is malformed, empty if not.
+func validationFailure(msg sip.Message) string {
+	req, isRequest := msg.(sip.Request)
+	for _, name := range mandatoryHeaders {
+		if name == "Max-Forwards" && !isRequest {
+			continue
+		}
+		if len(msg.GetHeaders(name)) == 0 {
+			return fmt.Sprintf("missing %s header", name)
+		}
+	}
+	cseq, ok := msg.CSeq()
+	if !ok {
+		return "malformed CSeq header"
+	}
+	if from, ok := msg.From(); !ok || from.Address == nil {
+		return "malformed From header"
+	} else if isRequest && (from.Params == nil || !from.Params.Has("tag")) {
+		return "missing From tag"
+	}
+	if to, ok := msg.To(); !ok || to.Address == nil {
+		return "malformed To header"
+	}
+
+	method := cseq.MethodName
+	if isRequest {
+		method = req.Method()
+		if cseq.MethodName != method {
+			return fmt.Sprintf("CSeq method %s differs from request method %s", cseq.MethodName, method)
+		}
+		if req.Recipient() == nil {
+			return "missing Request-URI"
+		}
+	}
+
+	// The requests and the responses creating a dialog have the Contact of the target of the dialog
+	// (RFC 3261 8.1.1.8, 12.1.1, RFC 6665 4.1.2.4).
+	if dialogForming(msg, method) && len(msg.GetHeaders("Contact")) == 0 {
+		return "missing Contact header"
+	}
+
+	for _, header := range msg.GetHeaders("Expires") {
+		if _, err := strconv.ParseUint(strings.TrimSpace(header.Value()), 10, 32); err != nil {
+			return fmt.Sprintf("Expires %q not a number of seconds", header.Value())
+		}
+	}
+	for _, header := range msg.GetHeaders("Contact") {
+		contact, ok := header.(*sip.ContactHeader)
+		if !ok || contact.Params == nil {
+			continue
+		}
+		if expires, ok := contact.Params.Get("expires"); ok && expires != nil {
+			if _, err := strconv.ParseUint(expires.String(), 10, 32); err != nil {
+				return fmt.Sprintf("Contact expires %q not a number of seconds", expires)
+			}
+		}
+	}
+	// A REGISTER removing all the bindings has Expires 0 (RFC 3261 10.2.2).
+	if isRequest && method == sip.REGISTER {
+		for _, header := range msg.GetHeaders("Contact") {
+			if contact, ok := header.(*sip.ContactHeader); ok && contact.Address != nil && contact.Address.IsWildcard() {
+				expires := msg.GetHeaders("Expires")
+				if len(expires) == 0 || strings.TrimSpace(expires[0].Value()) != "0" {
+					return "Contact * without Expires 0"
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// malformedRequest why a request received is too malformed to be handled, empty if not: the headers the
+// transactions and the dialogs depend on missing or unparsable (RFC 3261 8.2). The ones of an RFC 2543
+// endpoint, e.g. a From without tag or no Max-Forwards, are accepted.
+func malformedRequest(req sip.Request) string {
+	if _, ok := req.ViaHop(); !ok {
+		return "missing Via header"
+	}
+	if from, ok := req.From(); !ok || from.Address == nil {
+		return "missing From header"
+	}
+	if to, ok := req.To(); !ok || to.Address == nil {
+		return "missing To header"
+	}
+	if _, ok := req.CallID(); !ok {
+		return "missing Call-ID header"
+	}
+	cseq, ok := req.CSeq()
+	if !ok {
+		return "missing CSeq header"
+	}
+	// The parser upper-cases the method of the request line only.
+	if !strings.EqualFold(string(cseq.MethodName), string(req.Method())) {
+		return fmt.Sprintf("CSeq method %s differs from request method %s", cseq.MethodName, req.Method())
+	}
+	if req.Recipient() == nil {
+		return "missing Request-URI"
+	}
+	for _, name := range singleHeaders {
+		if len(req.GetHeaders(name)) > 1 {
+			return fmt.Sprintf("multiple %s headers", name)
+		}
+	}
+	if hdrs := req.GetHeaders("Max-Forwards"); len(hdrs) > 0 {
+		if maxForwards, ok := hdrs[0].(*sip.MaxForwards); ok && *maxForwards > 255 {
+			return fmt.Sprintf("Max-Forwards %d out of range", *maxForwards)
+		}
+	}
+	// The headers of a URI are for the request built from it, not allowed in a Request-URI (RFC 3261 19.1.1).
+	if headers := req.Recipient().Headers(); headers != nil && headers.Length() > 0 {
+		return "headers in Request-URI"
+	}
+	// The remote target of the dialog (RFC 3261 8.1.1.8), the From of an RFC 2543 endpoint without one.
+	if dialogForming(req, req.Method()) && !rfc2543(req) {
+		if contact, ok := req.Contact(); !ok || contact.Address == nil {
+			return "missing Contact header"
+		}
+	}
+	return ""
+}
+
+// unsupportedRequest the response rejecting a request the stack does not support, nil if none: a 420 for
+// an option tag required that is not one of its extensions (RFC 3261 8.2.2.3), a 415 for a session
+// description of an INVITE or an UPDATE neither SDP nor multipart (RFC 3261 8.2.3).
+func (s *SipStack) unsupportedRequest(req sip.Request) sip.Response {
+	if req.IsAck() || req.IsCancel() {
+		return nil
+	}
+	var unsupported []string
+	for _, header := range req.GetHeaders("Require") {
+		for _, option := range strings.Split(header.Value(), ",") {
+			if option = strings.TrimSpace(option); option != "" && !s.supports(option) {
+				unsupported = append(unsupported, option)
+			}
+		}
+	}
+	if len(unsupported) > 0 {
+		res := sip.NewResponseFromRequest("", req, 420, "Bad Extension", "")
+		res.AppendHeader(&sip.UnsupportedHeader{Options: unsupported})
+		return res
+	}
+
+	if method := req.Method(); method != sip.INVITE && method != "UPDATE" || req.Body() == "" {
+		return nil
+	}
+	if disposition := req.GetHeaders("Content-Disposition"); len(disposition) > 0 &&
+		!strings.EqualFold(strings.TrimSpace(strings.Split(disposition[0].Value(), ";")[0]), "session") {
+		return nil
+	}
+	if contentType, ok := req.ContentType(); ok {
+		mediaType := strings.TrimSpace(strings.Split(contentType.Value(), ";")[0])
+		if !strings.EqualFold(mediaType, "application/sdp") && !multipart.IsMultipart(mediaType) {
+			res := sip.NewResponseFromRequest("", req, 415, "Unsupported Media Type", "")
+			res.AppendHeader(&sip.GenericHeader{HeaderName: "Accept", Contents: "application/sdp"})
+			return res
+		}
+	}
+	return nil
+}
+
+// supports whether option is one of the extensions of the stack.
+func (s *SipStack) supports(option string) bool {
+	for _, extension := range s.extensions {
+		if strings.EqualFold(extension, option) {
+			return true
+		}
+	}
+	return false
+}
+
+// singleHeaders the headers a request has one of at most (RFC 3261 7.3.1).
+var singleHeaders = []string{"From", "To", "Call-ID", "CSeq", "Max-Forwards", "Content-Length", "Content-Type"}
+
+// rfc2543 whether req was sent by an RFC 2543 endpoint, its branch without the magic cookie of RFC 3261.
+func rfc2543(req sip.Request) bool {
+	return !strings.HasPrefix(branchOf(req), sip.RFC3261BranchMagicCookie)
+}
+
+// dialogForming whether msg of method creates or refreshes the target of a dialog, an INVITE, SUBSCRIBE or
+// REFER or one of their 101-299 responses.
+func dialogForming(msg sip.Message, method sip.RequestMethod) bool {
+	switch method {
+	case sip.INVITE, sip.SUBSCRIBE, sip.REFER:
+	default:
+		return false
+	}
+	if res, ok := msg.(sip.Response); ok {
+		return res.StatusCode() > 100 && res.StatusCode() < 300
+	}
+	return true
+}
+
+// messageName e.g. INVITE request or 180 response to INVITE.
+func messageName(msg sip.Message) string {
+	switch msg := msg.(type) {
+	case sip.Request:
+		return fmt.Sprintf("%s request", msg.Method())
+	case sip.Response:
+		if cseq, ok := msg.CSeq(); ok {
+			return fmt.Sprintf("%d response to %s", msg.StatusCode(), cseq.MethodName)
+		}
+		return fmt.Sprintf("%d response", msg.StatusCode())
+	}
+	return "message"
+}
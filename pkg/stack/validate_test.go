@@ -0,0 +1,116 @@
+package stack
+
+import (
+	"testing"
+
+	"github.com/ghettovoice/gosip/log"
+	"github.com/ghettovoice/gosip/sip"
+	"github.com/ghettovoice/gosip/sip/parser"
+	"github.com/sergeyu/go-sip-ua/pkg/utils"
+)
+
+func TestValidationFailure(t *testing.T) {
+	logger := utils.NewLogger(log.ErrorLevel, "test", nil)
+	tests := []struct {
+		name    string
+		message string
+		// header appended to the message parsed, e.g. one the parser rejects.
+		header sip.Header
+		reason string
+	}{
+		{"valid INVITE", "INVITE sip:bob@192.0.2.1 SIP/2.0\r\n" +
+			"Via: SIP/2.0/UDP 192.0.2.2:5060;branch=z9hG4bK-1\r\n" +
+			"Max-Forwards: 70\r\n" +
+			"From: <sip:alice@example.com>;tag=a1\r\n" +
+			"To: <sip:bob@example.com>\r\n" +
+			"Call-ID: 1@192.0.2.2\r\n" +
+			"CSeq: 1 INVITE\r\n" +
+			"Contact: <sip:alice@192.0.2.2:5060>\r\n", nil, ""},
+		{"missing header", "OPTIONS sip:bob@192.0.2.1 SIP/2.0\r\n" +
+			"Via: SIP/2.0/UDP 192.0.2.2:5060;branch=z9hG4bK-1\r\n" +
+			"From: <sip:alice@example.com>;tag=a1\r\n" +
+			"To: <sip:bob@example.com>\r\n" +
+			"Call-ID: 1@192.0.2.2\r\n" +
+			"CSeq: 1 OPTIONS\r\n", nil, "missing Max-Forwards header"},
+		{"response without Max-Forwards", "SIP/2.0 200 OK\r\n" +
+			"Via: SIP/2.0/UDP 192.0.2.2:5060;branch=z9hG4bK-1\r\n" +
+			"From: <sip:alice@example.com>;tag=a1\r\n" +
+			"To: <sip:bob@example.com>;tag=b1\r\n" +
+			"Call-ID: 1@192.0.2.2\r\n" +
+			"CSeq: 1 OPTIONS\r\n", nil, ""},
+		{"CSeq method mismatch", "OPTIONS sip:bob@192.0.2.1 SIP/2.0\r\n" +
+			"Via: SIP/2.0/UDP 192.0.2.2:5060;branch=z9hG4bK-1\r\n" +
+			"Max-Forwards: 70\r\n" +
+			"From: <sip:alice@example.com>;tag=a1\r\n" +
+			"To: <sip:bob@example.com>\r\n" +
+			"Call-ID: 1@192.0.2.2\r\n" +
+			"CSeq: 1 INFO\r\n", nil, "CSeq method INFO differs from request method OPTIONS"},
+		{"SUBSCRIBE without Contact", "SUBSCRIBE sip:bob@192.0.2.1 SIP/2.0\r\n" +
+			"Via: SIP/2.0/UDP 192.0.2.2:5060;branch=z9hG4bK-1\r\n" +
+			"Max-Forwards: 70\r\n" +
+			"From: <sip:alice@example.com>;tag=a1\r\n" +
+			"To: <sip:bob@example.com>\r\n" +
+			"Call-ID: 1@192.0.2.2\r\n" +
+			"CSeq: 1 SUBSCRIBE\r\n" +
+			"Event: presence\r\n", nil, "missing Contact header"},
+		{"180 to INVITE without Contact", "SIP/2.0 180 Ringing\r\n" +
+			"Via: SIP/2.0/UDP 192.0.2.2:5060;branch=z9hG4bK-1\r\n" +
+			"From: <sip:alice@example.com>;tag=a1\r\n" +
+			"To: <sip:bob@example.com>;tag=b1\r\n" +
+			"Call-ID: 1@192.0.2.2\r\n" +
+			"CSeq: 1 INVITE\r\n", nil, "missing Contact header"},
+		{"100 to INVITE without Contact", "SIP/2.0 100 Trying\r\n" +
+			"Via: SIP/2.0/UDP 192.0.2.2:5060;branch=z9hG4bK-1\r\n" +
+			"From: <sip:alice@example.com>;tag=a1\r\n" +
+			"To: <sip:bob@example.com>\r\n" +
+			"Call-ID: 1@192.0.2.2\r\n" +
+			"CSeq: 1 INVITE\r\n", nil, ""},
+		{"bad Expires", "REGISTER sip:example.com SIP/2.0\r\n" +
+			"Via: SIP/2.0/UDP 192.0.2.2:5060;branch=z9hG4bK-1\r\n" +
+			"Max-Forwards: 70\r\n" +
+			"From: <sip:alice@example.com>;tag=a1\r\n" +
+			"To: <sip:alice@example.com>\r\n" +
+			"Call-ID: 1@192.0.2.2\r\n" +
+			"CSeq: 1 REGISTER\r\n" +
+			"Contact: <sip:alice@192.0.2.2:5060>\r\n",
+			&sip.GenericHeader{HeaderName: "Expires", Contents: "-1"}, `Expires "-1" not a number of seconds`},
+		{"bad Contact expires", "REGISTER sip:example.com SIP/2.0\r\n" +
+			"Via: SIP/2.0/UDP 192.0.2.2:5060;branch=z9hG4bK-1\r\n" +
+			"Max-Forwards: 70\r\n" +
+			"From: <sip:alice@example.com>;tag=a1\r\n" +
+			"To: <sip:alice@example.com>\r\n" +
+			"Call-ID: 1@192.0.2.2\r\n" +
+			"CSeq: 1 REGISTER\r\n" +
+			"Contact: <sip:alice@192.0.2.2:5060>;expires=soon\r\n", nil, `Contact expires "soon" not a number of seconds`},
+		{"Contact * without Expires 0", "REGISTER sip:example.com SIP/2.0\r\n" +
+			"Via: SIP/2.0/UDP 192.0.2.2:5060;branch=z9hG4bK-1\r\n" +
+			"Max-Forwards: 70\r\n" +
+			"From: <sip:alice@example.com>;tag=a1\r\n" +
+			"To: <sip:alice@example.com>\r\n" +
+			"Call-ID: 1@192.0.2.2\r\n" +
+			"CSeq: 1 REGISTER\r\n" +
+			"Contact: *\r\n" +
+			"Expires: 3600\r\n", nil, "Contact * without Expires 0"},
+		{"Contact * with Expires 0", "REGISTER sip:example.com SIP/2.0\r\n" +
+			"Via: SIP/2.0/UDP 192.0.2.2:5060;branch=z9hG4bK-1\r\n" +
+			"Max-Forwards: 70\r\n" +
+			"From: <sip:alice@example.com>;tag=a1\r\n" +
+			"To: <sip:alice@example.com>\r\n" +
+			"Call-ID: 1@192.0.2.2\r\n" +
+			"CSeq: 1 REGISTER\r\n" +
+			"Contact: *\r\n" +
+			"Expires: 0\r\n", nil, ""},
+	}
+	for _, tt := range tests {
+		msg, err := parser.ParseMessage([]byte(tt.message+"Content-Length: 0\r\n\r\n"), logger)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if tt.header != nil {
+			msg.AppendHeader(tt.header)
+		}
+		if got := validationFailure(msg); got != tt.reason {
+			t.Errorf("%s: validationFailure = %q; want %q", tt.name, got, tt.reason)
+		}
+	}
+}
@@ -0,0 +1,213 @@
+package stack
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ghettovoice/gosip/transport"
+	"github.com/gobwas/ws"
+	"github.com/gobwas/ws/wsutil"
+)
+
+const (
+	// wsSubProtocol the WebSocket subprotocol of SIP (RFC 7118).
+	wsSubProtocol = "sip"
+)
+
+// netAddr reports the network of the protocol, so the listener pool keys the accepted connections by it.
+type netAddr struct {
+	net.Addr
+	network string
+}
+
+func (a *netAddr) Network() string {
+	return a.network
+}
+
+// wsConn a SIP over WebSocket connection, each frame carries one SIP message.
+// The server side handshake runs on the first read, not in the accept loop.
+type wsConn struct {
+	net.Conn
+	network  string
+	client   bool
+	reader   io.Reader
+	upgrade  sync.Once
+	upgraded error
+	wmu      sync.Mutex
+}
+
+func (c *wsConn) RemoteAddr() net.Addr {
+	return &netAddr{Addr: c.Conn.RemoteAddr(), network: c.network}
+}
+
+func (c *wsConn) handshake() error {
+	c.upgrade.Do(func() {
+		if c.client {
+			return
+		}
+		selected := false
+		u := ws.Upgrader{
+			Protocol: func(protocol []byte) bool {
+				selected = string(protocol) == wsSubProtocol
+				return selected
+			},
+			OnBeforeUpgrade: func() (ws.HandshakeHeader, error) {
+				if !selected {
+					return nil, ws.RejectConnectionError(
+						ws.RejectionStatus(400),
+						ws.RejectionReason("sip subprotocol required"),
+					)
+				}
+				return ws.HandshakeHeaderString(""), nil
+			},
+		}
+		c.Conn.SetDeadline(time.Now().Add(dialTimeout))
+		_, c.upgraded = u.Upgrade(c.Conn)
+		c.Conn.SetDeadline(time.Time{})
+	})
+	return c.upgraded
+}
+
+func (c *wsConn) Read(b []byte) (int, error) {
+	if err := c.handshake(); err != nil {
+		return 0, fmt.Errorf("websocket handshake: %w", err)
+	}
+	rw := struct {
+		io.Reader
+		io.Writer
+	}{c.reader, c.Conn}
+
+	for {
+		var data []byte
+		var op ws.OpCode
+		var err error
+		if c.client {
+			data, op, err = wsutil.ReadServerData(rw)
+		} else {
+			data, op, err = wsutil.ReadClientData(rw)
+		}
+		if err != nil {
+			var closed wsutil.ClosedError
+			if errors.As(err, &closed) {
+				return 0, io.EOF
+			}
+			return 0, err
+		}
+		if op == ws.OpClose {
+			return 0, io.EOF
+		}
+		if len(data) == 0 {
+			continue
+		}
+		return copy(b, data), nil
+	}
+}
+
+func (c *wsConn) Write(b []byte) (int, error) {
+	if err := c.handshake(); err != nil {
+		return 0, fmt.Errorf("websocket handshake: %w", err)
+	}
+
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+
+	var err error
+	if c.client {
+		err = wsutil.WriteClientMessage(c.Conn, ws.OpText, b)
+	} else {
+		err = wsutil.WriteServerMessage(c.Conn, ws.OpText, b)
+	}
+	if err != nil {
+		var closed wsutil.ClosedError
+		if errors.As(err, &closed) {
+			return 0, io.EOF
+		}
+		return 0, err
+	}
+	return len(b), nil
+}
+
+type wsListener struct {
+	net.Listener
+	network string
+}
+
+func (l *wsListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &wsConn{
+		Conn:    conn,
+		network: l.network,
+		reader:  conn,
+	}, nil
+}
+
+// listenWS the listener of the WS transport, WSS if config is not nil.
+func listenWS(config *TLSConfig) func(addr string, control sockopt, options ...transport.ListenOption) (net.Listener, error) {
+	return func(addr string, control sockopt, options ...transport.ListenOption) (net.Listener, error) {
+		var listener net.Listener
+		var err error
+		network := "ws"
+		if config != nil {
+			network = "wss"
+			listener, err = config.listenTLS(addr, control, options...)
+		} else {
+			listener, err = listenStream(addr, control)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return &wsListener{Listener: listener, network: network}, nil
+	}
+}
+
+// dialWS connects to the WS server, WSS if config is not nil. The sip subprotocol must be accepted by the server.
+func dialWS(config *TLSConfig) func(host string, addr string, control sockopt) (net.Conn, error) {
+	return func(host string, addr string, control sockopt) (net.Conn, error) {
+		scheme := "ws"
+		dialer := ws.Dialer{
+			Protocols: []string{wsSubProtocol},
+			Timeout:   dialTimeout,
+			NetDial:   dialer(control).DialContext,
+		}
+		if config != nil {
+			scheme = "wss"
+			tlsConfig, err := config.clientConfig(host)
+			if err != nil {
+				return nil, err
+			}
+			dialer.TLSConfig = tlsConfig
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), dialTimeout)
+		defer cancel()
+		conn, br, hs, err := dialer.Dial(ctx, fmt.Sprintf("%s://%s", scheme, addr))
+		if err != nil {
+			return nil, err
+		}
+		if !strings.EqualFold(hs.Protocol, wsSubProtocol) {
+			conn.Close()
+			return nil, fmt.Errorf("server did not accept the %s subprotocol", wsSubProtocol)
+		}
+
+		var reader io.Reader = conn
+		if br != nil {
+			// Frames sent by the server right after the handshake.
+			reader = io.MultiReader(br, conn)
+		}
+		return &wsConn{
+			Conn:    conn,
+			network: scheme,
+			client:  true,
+			reader:  reader,
+		}, nil
+	}
+}
@@ -0,0 +1,329 @@
+// Package stun the codec of the STUN messages (RFC 5389) of the public address of the stack, of the
+// connectivity checks of ICE (RFC 8445) and of the relays of TURN (RFC 5766).
+package stun
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"net"
+)
+
+// The types of the messages of STUN, ICE and TURN.
+const (
+	BindingRequest          = 0x0001
+	BindingSuccess          = 0x0101
+	BindingError            = 0x0111
+	BindingIndication       = 0x0011
+	AllocateRequest         = 0x0003
+	AllocateError           = 0x0113
+	RefreshRequest          = 0x0004
+	CreatePermissionRequest = 0x0008
+	SendIndication          = 0x0016
+	DataIndication          = 0x0017
+
+	ClassMask       = 0x0110
+	ClassSuccess    = 0x0100
+	ClassError      = 0x0110
+	ClassIndication = 0x0010
+)
+
+// The attributes.
+const (
+	AttrMappedAddress      = 0x0001
+	AttrUsername           = 0x0006
+	AttrMessageIntegrity   = 0x0008
+	AttrErrorCode          = 0x0009
+	AttrLifetime           = 0x000d
+	AttrXORPeerAddress     = 0x0012
+	AttrData               = 0x0013
+	AttrRealm              = 0x0014
+	AttrNonce              = 0x0015
+	AttrXORRelayedAddress  = 0x0016
+	AttrRequestedTransport = 0x0019
+	AttrXORMappedAddress   = 0x0020
+	AttrPriority           = 0x0024
+	AttrUseCandidate       = 0x0025
+	AttrFingerprint        = 0x8028
+	AttrICEControlled      = 0x8029
+	AttrICEControlling     = 0x802a
+)
+
+// The error codes.
+const (
+	ErrorUnauthorized = 401
+	ErrorRoleConflict = 487
+	ErrorStaleNonce   = 438
+)
+
+const (
+	MagicCookie = 0x2112a442
+	HeaderSize  = 20
+	// TransportUDP the REQUESTED-TRANSPORT of a UDP relay.
+	TransportUDP = 17
+
+	fingerprintXOR           = 0x5354554e
+	messageIntegritySize     = 20
+	integrityAttributeSize   = 4 + messageIntegritySize
+	fingerprintAttributeSize = 8
+)
+
+// ErrMalformed the error of Parse.
+var ErrMalformed = errors.New("stun: malformed message")
+
+type Attribute struct {
+	Type  uint16
+	Value []byte
+}
+
+// Message a STUN message, Raw the bytes it was parsed from.
+type Message struct {
+	Type       uint16
+	ID         [12]byte
+	Attributes []Attribute
+	Raw        []byte
+}
+
+// New a message of typ with a random transaction id.
+func New(typ uint16) *Message {
+	m := &Message{Type: typ}
+	rand.Read(m.ID[:])
+	return m
+}
+
+// Response a response of typ to the request m, with its transaction id.
+func (m *Message) Response(typ uint16) *Message {
+	return &Message{Type: typ, ID: m.ID}
+}
+
+func (m *Message) Add(typ uint16, value []byte) *Message {
+	m.Attributes = append(m.Attributes, Attribute{Type: typ, Value: value})
+	return m
+}
+
+func (m *Message) Get(typ uint16) ([]byte, bool) {
+	for _, a := range m.Attributes {
+		if a.Type == typ {
+			return a.Value, true
+		}
+	}
+	return nil, false
+}
+
+func (m *Message) Has(typ uint16) bool {
+	_, ok := m.Get(typ)
+	return ok
+}
+
+func (m *Message) Class() uint16 {
+	return m.Type & ClassMask
+}
+
+// Marshal the message, with a MESSAGE-INTEGRITY of key if not nil and a FINGERPRINT if fingerprint.
+func (m *Message) Marshal(key []byte, fingerprint bool) []byte {
+	buf := make([]byte, HeaderSize, 256)
+	binary.BigEndian.PutUint16(buf, m.Type)
+	binary.BigEndian.PutUint32(buf[4:], MagicCookie)
+	copy(buf[8:], m.ID[:])
+	for _, a := range m.Attributes {
+		buf = appendAttribute(buf, a.Type, a.Value)
+	}
+	if key != nil {
+		// The length covers the integrity, not the fingerprint (RFC 5389 15.4).
+		binary.BigEndian.PutUint16(buf[2:], uint16(len(buf)-HeaderSize+integrityAttributeSize))
+		mac := hmac.New(sha1.New, key)
+		mac.Write(buf)
+		buf = appendAttribute(buf, AttrMessageIntegrity, mac.Sum(nil))
+	}
+	if fingerprint {
+		binary.BigEndian.PutUint16(buf[2:], uint16(len(buf)-HeaderSize+fingerprintAttributeSize))
+		buf = appendAttribute(buf, AttrFingerprint, Uint32Value(crc32.ChecksumIEEE(buf)^fingerprintXOR))
+	}
+	binary.BigEndian.PutUint16(buf[2:], uint16(len(buf)-HeaderSize))
+	return buf
+}
+
+func appendAttribute(buf []byte, typ uint16, value []byte) []byte {
+	header := make([]byte, 4)
+	binary.BigEndian.PutUint16(header, typ)
+	binary.BigEndian.PutUint16(header[2:], uint16(len(value)))
+	buf = append(buf, header...)
+	buf = append(buf, value...)
+	// The values are padded to 32 bits.
+	for len(buf)%4 != 0 {
+		buf = append(buf, 0)
+	}
+	return buf
+}
+
+// IsMessage whether buf has the header of a STUN message, its first byte in 0-3 to tell it from the RTP,
+// DTLS and SIP ones on the same port (RFC 7983).
+func IsMessage(buf []byte) bool {
+	return len(buf) >= HeaderSize && buf[0] < 4 && binary.BigEndian.Uint32(buf[4:]) == MagicCookie
+}
+
+// Parse the STUN message of buf, ErrMalformed if it is not one.
+func Parse(buf []byte) (*Message, error) {
+	if !IsMessage(buf) {
+		return nil, ErrMalformed
+	}
+	length := int(binary.BigEndian.Uint16(buf[2:]))
+	if len(buf) < HeaderSize+length || length%4 != 0 {
+		return nil, ErrMalformed
+	}
+	raw := make([]byte, HeaderSize+length)
+	copy(raw, buf)
+	m := &Message{Type: binary.BigEndian.Uint16(raw), Raw: raw}
+	copy(m.ID[:], raw[8:20])
+	for body := raw[HeaderSize:]; len(body) > 0; {
+		if len(body) < 4 {
+			return nil, ErrMalformed
+		}
+		typ := binary.BigEndian.Uint16(body)
+		size := int(binary.BigEndian.Uint16(body[2:]))
+		padded := (size + 3) &^ 3
+		if len(body) < 4+padded {
+			return nil, ErrMalformed
+		}
+		m.Attributes = append(m.Attributes, Attribute{Type: typ, Value: body[4 : 4+size]})
+		body = body[4+padded:]
+	}
+	return m, nil
+}
+
+// Verify the MESSAGE-INTEGRITY of a message parsed with key.
+func (m *Message) Verify(key []byte) bool {
+	offset := HeaderSize
+	for body := m.Raw[HeaderSize:]; len(body) >= 4; {
+		typ := binary.BigEndian.Uint16(body)
+		size := int(binary.BigEndian.Uint16(body[2:]))
+		if typ == AttrMessageIntegrity {
+			if size != messageIntegritySize || len(body) < 4+size {
+				return false
+			}
+			signed := make([]byte, offset)
+			copy(signed, m.Raw[:offset])
+			binary.BigEndian.PutUint16(signed[2:], uint16(offset-HeaderSize+integrityAttributeSize))
+			mac := hmac.New(sha1.New, key)
+			mac.Write(signed)
+			return hmac.Equal(mac.Sum(nil), body[4:4+size])
+		}
+		padded := (size + 3) &^ 3
+		offset += 4 + padded
+		if len(body) < 4+padded {
+			return false
+		}
+		body = body[4+padded:]
+	}
+	return false
+}
+
+// LongTermKey the key of the long-term credentials of TURN (RFC 5389 15.4).
+func LongTermKey(username string, realm string, password string) []byte {
+	sum := md5.Sum([]byte(username + ":" + realm + ":" + password))
+	return sum[:]
+}
+
+// XORAddress the value of an XOR-*-ADDRESS attribute of addr in the transaction id.
+func XORAddress(addr *net.UDPAddr, id [12]byte) []byte {
+	ip := addr.IP.To4()
+	family := byte(1)
+	if ip == nil {
+		ip = addr.IP.To16()
+		family = 2
+	}
+	value := make([]byte, 4+len(ip))
+	value[1] = family
+	binary.BigEndian.PutUint16(value[2:], uint16(addr.Port)^(MagicCookie>>16))
+	key := xorKey(id)
+	for i := range ip {
+		value[4+i] = ip[i] ^ key[i]
+	}
+	return value
+}
+
+// ParseXORAddress the address of an XOR-*-ADDRESS attribute in the transaction id.
+func ParseXORAddress(value []byte, id [12]byte) (*net.UDPAddr, error) {
+	key := xorKey(id)
+	return parseAddress(value, key[:])
+}
+
+// xorKey the magic cookie and the transaction id the XOR-*-ADDRESS attributes are xored with.
+func xorKey(id [12]byte) [16]byte {
+	var key [16]byte
+	binary.BigEndian.PutUint32(key[:], MagicCookie)
+	copy(key[4:], id[:])
+	return key
+}
+
+// parseAddress decodes an address attribute, xored by key if not nil.
+func parseAddress(value []byte, key []byte) (*net.UDPAddr, error) {
+	if len(value) < 4 {
+		return nil, ErrMalformed
+	}
+	var ip net.IP
+	switch value[1] {
+	case 1:
+		ip = make(net.IP, net.IPv4len)
+	case 2:
+		ip = make(net.IP, net.IPv6len)
+	default:
+		return nil, ErrMalformed
+	}
+	if len(value) < 4+len(ip) {
+		return nil, ErrMalformed
+	}
+	port := binary.BigEndian.Uint16(value[2:])
+	copy(ip, value[4:])
+	if key != nil {
+		port ^= MagicCookie >> 16
+		for i := range ip {
+			ip[i] ^= key[i]
+		}
+	}
+	return &net.UDPAddr{IP: ip, Port: int(port)}, nil
+}
+
+// MappedAddress the XOR-MAPPED-ADDRESS of a response, or its MAPPED-ADDRESS of RFC 3489 servers.
+func (m *Message) MappedAddress() (*net.UDPAddr, error) {
+	if value, ok := m.Get(AttrXORMappedAddress); ok {
+		return ParseXORAddress(value, m.ID)
+	}
+	value, ok := m.Get(AttrMappedAddress)
+	if !ok {
+		return nil, fmt.Errorf("stun: no mapped address")
+	}
+	return parseAddress(value, nil)
+}
+
+// ErrorCode the ERROR-CODE of an error response, 0 if none.
+func (m *Message) ErrorCode() int {
+	value, ok := m.Get(AttrErrorCode)
+	if !ok || len(value) < 4 {
+		return 0
+	}
+	return int(value[2]&0x7)*100 + int(value[3])
+}
+
+// ErrorCodeValue the value of an ERROR-CODE attribute.
+func ErrorCodeValue(code int, reason string) []byte {
+	value := []byte{0, 0, byte(code / 100), byte(code % 100)}
+	return append(value, reason...)
+}
+
+func Uint32Value(v uint32) []byte {
+	value := make([]byte, 4)
+	binary.BigEndian.PutUint32(value, v)
+	return value
+}
+
+func Uint64Value(v uint64) []byte {
+	value := make([]byte, 8)
+	binary.BigEndian.PutUint64(value, v)
+	return value
+}
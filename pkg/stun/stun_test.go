@@ -0,0 +1,185 @@
+package stun_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"hash/crc32"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/sergeyu/go-sip-ua/pkg/stun"
+)
+
+// decode the hex of a test vector, spaces and line breaks ignored.
+func decode(t *testing.T, s string) []byte {
+	b, err := hex.DecodeString(strings.Join(strings.Fields(s), ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// fingerprintOK whether the FINGERPRINT ending buf is the CRC-32 of the message before it (RFC 5389 15.5).
+func fingerprintOK(buf []byte) bool {
+	n := len(buf) - 8
+	if n < stun.HeaderSize || binary.BigEndian.Uint16(buf[n:]) != stun.AttrFingerprint {
+		return false
+	}
+	return crc32.ChecksumIEEE(buf[:n])^0x5354554e == binary.BigEndian.Uint32(buf[n+4:])
+}
+
+// TestVectors the sample request and IPv4 response of RFC 5769 2.1 and 2.2, of the short-term password
+// "VOkJxbRl1RmTxUk/WvJxBt".
+func TestVectors(t *testing.T) {
+	key := []byte("VOkJxbRl1RmTxUk/WvJxBt")
+	request := decode(t, `
+		00 01 00 58 21 12 a4 42 b7 e7 a7 01 bc 34 d6 86 fa 87 df ae
+		80 22 00 10 53 54 55 4e 20 74 65 73 74 20 63 6c 69 65 6e 74
+		00 24 00 04 6e 00 01 ff
+		80 29 00 08 93 2f f9 b1 51 26 3b 36
+		00 06 00 09 65 76 74 6a 3a 68 36 76 59 20 20 20
+		00 08 00 14 9a ea a7 0c bf d8 cb 56 78 1e f2 b5 b2 d3 f2 49 c1 b5 71 a2
+		80 28 00 04 e5 7a 3b cf`)
+	m, err := stun.Parse(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Type != stun.BindingRequest || len(m.Attributes) != 6 {
+		t.Errorf("Parse = %#x with %d attributes; want %#x with 6", m.Type, len(m.Attributes), stun.BindingRequest)
+	}
+	if username, _ := m.Get(stun.AttrUsername); string(username) != "evtj:h6vY" {
+		t.Errorf("USERNAME = %q; want evtj:h6vY", username)
+	}
+	if priority, _ := m.Get(stun.AttrPriority); binary.BigEndian.Uint32(priority) != 0x6e0001ff {
+		t.Errorf("PRIORITY = %x; want 6e0001ff", priority)
+	}
+	if !m.Verify(key) {
+		t.Error("Verify of the request = false")
+	}
+	if m.Verify([]byte("wrong")) {
+		t.Error("Verify of the request with a wrong key = true")
+	}
+	if !fingerprintOK(request) {
+		t.Error("FINGERPRINT of the request not verified")
+	}
+
+	response := decode(t, `
+		01 01 00 3c 21 12 a4 42 b7 e7 a7 01 bc 34 d6 86 fa 87 df ae
+		80 22 00 0b 74 65 73 74 20 76 65 63 74 6f 72 20
+		00 20 00 08 00 01 a1 47 e1 12 a6 43
+		00 08 00 14 2b 91 f5 99 fd 9e 90 c3 8c 74 89 f9 2a f9 ba 53 f0 6b e7 d7
+		80 28 00 04 c0 7d 4c 96`)
+	m, err = stun.Parse(response)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Class() != stun.ClassSuccess || !m.Verify(key) {
+		t.Errorf("response of class %#x, Verify %v; want %#x, true", m.Class(), m.Verify(key), stun.ClassSuccess)
+	}
+	addr, err := m.MappedAddress()
+	if err != nil || addr.String() != "192.0.2.1:32853" {
+		t.Errorf("MappedAddress = %v, %v; want 192.0.2.1:32853", addr, err)
+	}
+	if !fingerprintOK(response) {
+		t.Error("FINGERPRINT of the response not verified")
+	}
+}
+
+// TestRoundTrip a message marshaled then parsed: its attributes padded, signed and fingerprinted.
+func TestRoundTrip(t *testing.T) {
+	key := stun.LongTermKey("alice", "example.org", "secret")
+	request := stun.New(stun.AllocateRequest).
+		Add(stun.AttrRequestedTransport, []byte{stun.TransportUDP, 0, 0, 0}).
+		Add(stun.AttrUsername, []byte("alice")).
+		Add(stun.AttrRealm, []byte("example.org")).
+		Add(stun.AttrNonce, []byte("f//499k954d6OL34oL9FSTvy64sA")).
+		Add(stun.AttrLifetime, stun.Uint32Value(600))
+	for _, tt := range []struct {
+		key         []byte
+		fingerprint bool
+	}{
+		{nil, false},
+		{nil, true},
+		{key, false},
+		{key, true},
+	} {
+		buf := request.Marshal(tt.key, tt.fingerprint)
+		if len(buf)%4 != 0 || int(binary.BigEndian.Uint16(buf[2:])) != len(buf)-stun.HeaderSize {
+			t.Errorf("key %v, fingerprint %v: length %d of %d bytes", tt.key != nil, tt.fingerprint, binary.BigEndian.Uint16(buf[2:]), len(buf))
+		}
+		m, err := stun.Parse(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if m.Type != request.Type || m.ID != request.ID {
+			t.Errorf("Parse = %#x %x; want %#x %x", m.Type, m.ID, request.Type, request.ID)
+		}
+		for _, a := range request.Attributes {
+			if value, ok := m.Get(a.Type); !ok || !bytes.Equal(value, a.Value) {
+				t.Errorf("attribute %#x = %q; want %q", a.Type, value, a.Value)
+			}
+		}
+		if got := m.Verify(key); got != (tt.key != nil) {
+			t.Errorf("key %v, fingerprint %v: Verify = %v", tt.key != nil, tt.fingerprint, got)
+		}
+		if got := fingerprintOK(buf); got != tt.fingerprint {
+			t.Errorf("key %v, fingerprint %v: fingerprint verified %v", tt.key != nil, tt.fingerprint, got)
+		}
+		if tt.key != nil {
+			// An attribute changed after the integrity.
+			buf[stun.HeaderSize+4] ^= 0xff
+			if m, err := stun.Parse(buf); err != nil || m.Verify(key) {
+				t.Errorf("Verify of a message altered = true, %v", err)
+			}
+		}
+	}
+}
+
+func TestXORAddress(t *testing.T) {
+	id := stun.New(stun.BindingRequest).ID
+	for _, s := range []string{"192.0.2.1:32853", "[2001:db8:1234:5678:11:2233:4455:6677]:32853"} {
+		addr, err := net.ResolveUDPAddr("udp", s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := stun.ParseXORAddress(stun.XORAddress(addr, id), id)
+		if err != nil || got.String() != addr.String() {
+			t.Errorf("ParseXORAddress(XORAddress(%s)) = %v, %v", addr, got, err)
+		}
+	}
+}
+
+func TestParseMalformed(t *testing.T) {
+	valid := stun.New(stun.BindingRequest).Add(stun.AttrUsername, []byte("a:b")).Marshal(nil, false)
+	tests := []struct {
+		name string
+		buf  []byte
+	}{
+		{"short", valid[:stun.HeaderSize-1]},
+		{"truncated", valid[:len(valid)-4]},
+		{"SIP", []byte("OPTIONS sip:bob@example.com SIP/2.0\r\n\r\n")},
+		{"RTP", append([]byte{0x80}, valid[1:]...)},
+		{"no cookie", append(append([]byte{}, valid[:4]...), valid[8:]...)},
+	}
+	for _, tt := range tests {
+		if _, err := stun.Parse(tt.buf); err != stun.ErrMalformed {
+			t.Errorf("Parse of %s = %v; want ErrMalformed", tt.name, err)
+		}
+	}
+	m, err := stun.Parse(valid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code := m.ErrorCode(); code != 0 {
+		t.Errorf("ErrorCode = %d; want 0", code)
+	}
+	m, err = stun.Parse(stun.New(stun.AllocateError).Add(stun.AttrErrorCode, stun.ErrorCodeValue(stun.ErrorStaleNonce, "Stale Nonce")).Marshal(nil, false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Class() != stun.ClassError || m.ErrorCode() != stun.ErrorStaleNonce {
+		t.Errorf("error response of class %#x, ErrorCode %d; want %#x, %d", m.Class(), m.ErrorCode(), stun.ClassError, stun.ErrorStaleNonce)
+	}
+}
@@ -0,0 +1,134 @@
+package transaction_test
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ghettovoice/gosip/log"
+	"github.com/ghettovoice/gosip/sip"
+	"github.com/ghettovoice/gosip/sip/parser"
+	"github.com/sergeyu/go-sip-ua/pkg/clock"
+	"github.com/sergeyu/go-sip-ua/pkg/transaction"
+	"github.com/sirupsen/logrus"
+)
+
+var logger log.Logger
+
+func init() {
+	logrusNew := logrus.New()
+	logrusNew.SetLevel(logrus.ErrorLevel)
+	logger = log.NewLogrusLogger(logrusNew, "transaction_test", nil)
+}
+
+// transport the messages sent by the transactions, the ones of messages received by the layer. onSend,
+// if set, is called by Send before it returns, e.g. to receive a response before the request is sent.
+type transport struct {
+	messages chan sip.Message
+	onSend   func(msg sip.Message)
+
+	mu   sync.Mutex
+	sent []sip.Message
+}
+
+func newTransport() *transport {
+	return &transport{messages: make(chan sip.Message)}
+}
+
+func (tp *transport) Messages() <-chan sip.Message {
+	return tp.messages
+}
+
+func (tp *transport) Send(msg sip.Message) error {
+	tp.mu.Lock()
+	tp.sent = append(tp.sent, msg)
+	tp.mu.Unlock()
+	if tp.onSend != nil {
+		tp.onSend(msg)
+	}
+	return nil
+}
+
+func (tp *transport) IsReliable(network string) bool {
+	return false
+}
+
+func (tp *transport) IsStreamed(network string) bool {
+	return false
+}
+
+// Sent the number of messages sent.
+func (tp *transport) Sent() int {
+	tp.mu.Lock()
+	defer tp.mu.Unlock()
+	return len(tp.sent)
+}
+
+// newRequest a request of method from alice to bob over UDP, its branch and Call-ID numbered by seq.
+func newRequest(t *testing.T, method sip.RequestMethod, seq int) sip.Request {
+	msg, err := parser.ParseMessage([]byte(fmt.Sprintf("%[1]s sip:bob@192.0.2.2 SIP/2.0\r\n"+
+		"Via: SIP/2.0/UDP 192.0.2.1:5060;branch=z9hG4bK-%[2]d\r\n"+
+		"Max-Forwards: 70\r\n"+
+		"From: <sip:alice@example.com>;tag=a%[2]d\r\n"+
+		"To: <sip:bob@example.com>\r\n"+
+		"Call-ID: %[2]d@192.0.2.1\r\n"+
+		"CSeq: 1 %[1]s\r\n"+
+		"Contact: <sip:alice@192.0.2.1:5060>\r\n"+
+		"Content-Length: 0\r\n\r\n", method, seq)), logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return msg.(sip.Request)
+}
+
+// waitPending waits for the clock to have n timers active.
+func waitPending(t *testing.T, clk *clock.Mock, n int) {
+	deadline := time.Now().Add(time.Second)
+	for clk.Pending() != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("Pending = %d; want %d", clk.Pending(), n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// TestRequestEarlyResponse a response received while the request is sent, before Request returns: it
+// is matched to its transaction, which stops Timers A and F.
+func TestRequestEarlyResponse(t *testing.T) {
+	clk := clock.NewMock(time.Now())
+	tp := newTransport()
+	txl := transaction.NewLayer(tp, transaction.Timers{Clock: clk}, logger)
+	defer txl.Cancel()
+
+	unmatched := make(chan sip.Response, 1)
+	tp.onSend = func(msg sip.Message) {
+		tp.messages <- sip.NewResponseFromRequest("", msg.(sip.Request), 200, "OK", "")
+		// Passed up as not matched if the transaction is not stored yet.
+		select {
+		case res := <-txl.Responses():
+			unmatched <- res
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+
+	tx, err := txl.Request(newRequest(t, sip.OPTIONS, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case res := <-unmatched:
+		t.Fatalf("%s not matched to its transaction", res.Short())
+	default:
+	}
+	select {
+	case res := <-tx.Responses():
+		if res.StatusCode() != 200 {
+			t.Errorf("StatusCode = %d; want 200", res.StatusCode())
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no response passed to the transaction")
+	}
+	// Timer K only.
+	waitPending(t, clk, 1)
+}
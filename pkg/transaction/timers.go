@@ -0,0 +1,107 @@
+package transaction
+
+import (
+	"time"
+
+	"github.com/sergeyu/go-sip-ua/pkg/clock"
+)
+
+// The RFC 3261 defaults of the timers.
+const (
+	T1 = 500 * time.Millisecond
+	T2 = 4 * time.Second
+	T4 = 5 * time.Second
+	// Timer_D the wait for the response retransmissions of an INVITE on an unreliable transport.
+	Timer_D = 32 * time.Second
+	// Timer_1xx the wait before a server INVITE transaction sends 100 Trying.
+	Timer_1xx = 200 * time.Millisecond
+)
+
+// Timers the timers of the transactions (RFC 3261 17, Table 4), a zero one is the RFC default.
+// The other timers are derived from T1 and T4.
+type Timers struct {
+	// T1 the RTT estimate, the first retransmission interval.
+	T1 time.Duration
+	// T2 the longest retransmission interval of the non-INVITE requests and the INVITE responses.
+	T2 time.Duration
+	// T4 how long a message may stay in the network.
+	T4 time.Duration
+	// TimerB the INVITE transaction timeout, 64*T1.
+	TimerB time.Duration
+	// TimerF the non-INVITE transaction timeout, 64*T1.
+	TimerF time.Duration
+	// TimerH the wait for the ACK of a final response to an INVITE, 64*T1.
+	TimerH time.Duration
+	// Clock the clock the timers run on, clock.Real if nil.
+	Clock clock.Clock
+}
+
+func (t Timers) clock() clock.Clock { return clock.Or(t.Clock) }
+
+func (t Timers) t1() time.Duration {
+	if t.T1 <= 0 {
+		return T1
+	}
+	return t.T1
+}
+
+func (t Timers) t2() time.Duration {
+	if t.T2 <= 0 {
+		return T2
+	}
+	return t.T2
+}
+
+func (t Timers) t4() time.Duration {
+	if t.T4 <= 0 {
+		return T4
+	}
+	return t.T4
+}
+
+func (t Timers) timerA() time.Duration { return t.t1() }
+
+func (t Timers) timerB() time.Duration {
+	if t.TimerB <= 0 {
+		return 64 * t.t1()
+	}
+	return t.TimerB
+}
+
+func (t Timers) timerD() time.Duration { return Timer_D }
+
+func (t Timers) timer1xx() time.Duration { return Timer_1xx }
+
+func (t Timers) timerF() time.Duration {
+	if t.TimerF <= 0 {
+		return 64 * t.t1()
+	}
+	return t.TimerF
+}
+
+func (t Timers) timerG() time.Duration { return t.t1() }
+
+func (t Timers) timerH() time.Duration {
+	if t.TimerH <= 0 {
+		return 64 * t.t1()
+	}
+	return t.TimerH
+}
+
+func (t Timers) timerI() time.Duration { return t.t4() }
+
+func (t Timers) timerJ() time.Duration { return 64 * t.t1() }
+
+func (t Timers) timerK() time.Duration { return t.t4() }
+
+func (t Timers) timerL() time.Duration { return 64 * t.t1() }
+
+func (t Timers) timerM() time.Duration { return 64 * t.t1() }
+
+// Timeout the longest a transaction may wait for its final response, Timer B or F.
+func (t Timers) Timeout() time.Duration {
+	if t.timerF() > t.timerB() {
+		return t.timerF()
+	}
+	return t.timerB()
+}
@@ -0,0 +1,178 @@
+package transaction_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ghettovoice/gosip/sip"
+	"github.com/sergeyu/go-sip-ua/pkg/clock"
+	"github.com/sergeyu/go-sip-ua/pkg/transaction"
+)
+
+func TestTimersTimeout(t *testing.T) {
+	tests := []struct {
+		timers transaction.Timers
+		want   time.Duration
+	}{
+		{transaction.Timers{}, 32 * time.Second},
+		{transaction.Timers{T1: 100 * time.Millisecond}, 6400 * time.Millisecond},
+		{transaction.Timers{TimerB: 10 * time.Second}, 32 * time.Second},
+		{transaction.Timers{TimerB: 40 * time.Second}, 40 * time.Second},
+		{transaction.Timers{T1: 100 * time.Millisecond, TimerF: 20 * time.Second}, 20 * time.Second},
+	}
+	for _, tt := range tests {
+		if got := tt.timers.Timeout(); got != tt.want {
+			t.Errorf("%+v.Timeout() = %v; want %v", tt.timers, got, tt.want)
+		}
+	}
+}
+
+// expectEnded expects tx to end after the next d of clk, not before.
+func expectEnded(t *testing.T, clk *clock.Mock, tx transaction.Tx, d time.Duration) {
+	clk.Add(d - time.Millisecond)
+	select {
+	case <-tx.Done():
+		t.Fatalf("transaction ended before %v", d)
+	default:
+	}
+	clk.Add(time.Millisecond)
+	select {
+	case <-tx.Done():
+	case <-time.After(time.Second):
+		t.Fatalf("transaction not ended after %v", d)
+	}
+}
+
+// expectTimeout expects tx to fail with a TxTimeoutError after the next d of clk, not before.
+func expectTimeout(t *testing.T, clk *clock.Mock, tx transaction.Tx, d time.Duration) {
+	expectEnded(t, clk, tx, d)
+	if err, ok := (<-tx.Errors()).(*transaction.TxTimeoutError); !ok {
+		t.Errorf("error = %v; want a TxTimeoutError", err)
+	}
+}
+
+// TestTimerB an INVITE unanswered: retransmitted by Timer A doubling from T1, timed out by Timer B.
+func TestTimerB(t *testing.T) {
+	clk := clock.NewMock(time.Now())
+	tp := newTransport()
+	tx, err := transaction.NewClientTx(newRequest(t, sip.INVITE, 1), tp,
+		transaction.Timers{T1: 100 * time.Millisecond, Clock: clk}, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Init(); err != nil {
+		t.Fatal(err)
+	}
+	expectTimeout(t, clk, tx, 6400*time.Millisecond)
+	// Sent, then retransmitted at 100, 300, 700, 1500, 3100 and 6300 ms.
+	if got := tp.Sent(); got != 7 {
+		t.Errorf("Sent = %d; want 7", got)
+	}
+}
+
+// TestTimerF a non-INVITE unanswered: retransmitted by Timer A capped at T2, timed out by Timer F and not
+// by Timer B.
+func TestTimerF(t *testing.T) {
+	clk := clock.NewMock(time.Now())
+	tp := newTransport()
+	timers := transaction.Timers{
+		T1:     100 * time.Millisecond,
+		T2:     400 * time.Millisecond,
+		TimerB: 10 * time.Second,
+		TimerF: 2 * time.Second,
+		Clock:  clk,
+	}
+	tx, err := transaction.NewClientTx(newRequest(t, sip.OPTIONS, 1), tp, timers, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Init(); err != nil {
+		t.Fatal(err)
+	}
+	expectTimeout(t, clk, tx, 2*time.Second)
+	// Sent, then retransmitted at 100, 300, 700, 1100, 1500 and 1900 ms.
+	if got := tp.Sent(); got != 7 {
+		t.Errorf("Sent = %d; want 7", got)
+	}
+}
+
+// TestTimerK a non-INVITE answered over UDP: ended by Timer K, T4 after its final response.
+func TestTimerK(t *testing.T) {
+	clk := clock.NewMock(time.Now())
+	tp := newTransport()
+	timers := transaction.Timers{T4: time.Second, Clock: clk}
+	req := newRequest(t, sip.OPTIONS, 1)
+	tx, err := transaction.NewClientTx(req, tp, timers, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Init(); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Receive(sip.NewResponseFromRequest("", req, 200, "OK", "")); err != nil {
+		t.Fatal(err)
+	}
+	<-tx.Responses()
+
+	expectEnded(t, clk, tx, time.Second)
+	if got := tp.Sent(); got != 1 {
+		t.Errorf("Sent = %d; want 1", got)
+	}
+}
+
+// TestTimerH a final response to an INVITE never acknowledged: retransmitted by Timer G, the transaction
+// ended by Timer H.
+func TestTimerH(t *testing.T) {
+	clk := clock.NewMock(time.Now())
+	tp := newTransport()
+	timers := transaction.Timers{T1: 100 * time.Millisecond, TimerH: time.Second, Clock: clk}
+	req := newRequest(t, sip.INVITE, 1)
+	tx, err := transaction.NewServerTx(req, tp, timers, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Init(); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Respond(sip.NewResponseFromRequest("", req, 486, "Busy Here", "")); err != nil {
+		t.Fatal(err)
+	}
+	expectEnded(t, clk, tx, time.Second)
+	// Sent, then retransmitted at 100, 300 and 700 ms.
+	if got := tp.Sent(); got != 4 {
+		t.Errorf("Sent = %d; want 4", got)
+	}
+}
+
+// TestRespondAfterFinal a 487 after the final response to an INVITE, e.g. to a CANCEL crossing it:
+// ignored, Timer G retransmits the final response.
+func TestRespondAfterFinal(t *testing.T) {
+	clk := clock.NewMock(time.Now())
+	tp := newTransport()
+	timers := transaction.Timers{T1: 100 * time.Millisecond, TimerH: time.Second, Clock: clk}
+	req := newRequest(t, sip.INVITE, 1)
+	tx, err := transaction.NewServerTx(req, tp, timers, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Init(); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Respond(sip.NewResponseFromRequest("", req, 486, "Busy Here", "")); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Respond(sip.NewResponseFromRequest("", req, 487, "Request Terminated", "")); err != nil {
+		t.Fatal(err)
+	}
+	clk.Add(100 * time.Millisecond)
+	tp.mu.Lock()
+	defer tp.mu.Unlock()
+	if len(tp.sent) != 2 {
+		t.Fatalf("Sent = %d; want 2", len(tp.sent))
+	}
+	for _, msg := range tp.sent {
+		if code := msg.(sip.Response).StatusCode(); code != 486 {
+			t.Errorf("StatusCode = %d; want 486", code)
+		}
+	}
+}
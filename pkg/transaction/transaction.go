@@ -0,0 +1,94 @@
+// Package transaction the SIP transaction layer (RFC 3261 17) of gosip with configurable timers.
+//
+// client_tx.go, server_tx.go, layer.go and tx.go are derived from github.com/ghettovoice/gosip/transaction,
+// Copyright (c) 2017, The GoSIP authors, under the BSD 2-Clause license in LICENSE. They differ from it
+// by the Timers of a layer instead of the package variables of gosip, run on a clock.Clock, by Timers F
+// and K for the non-INVITE transactions, by the non-INVITE abandoned when canceled, by the client
+// transactions stored before their request is sent, and by the responses after a final one ignored.
+// The keys and the errors are the gosip ones.
+package transaction
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ghettovoice/gosip/sip"
+	"github.com/ghettovoice/gosip/transaction"
+)
+
+type (
+	TxError           = transaction.TxError
+	TxTerminatedError = transaction.TxTerminatedError
+	TxTimeoutError    = transaction.TxTimeoutError
+	TxTransportError  = transaction.TxTransportError
+)
+
+// MakeServerTxKey the key of the server transaction of msg (RFC 3261 17.2.3), an error if its Via
+// branch or its From tag has no value, on which the key of gosip panics.
+func MakeServerTxKey(msg sip.Message) (TxKey, error) {
+	if err := checkKeyParams(msg); err != nil {
+		return "", err
+	}
+	if untaggedRFC2543(msg) {
+		return rfc2543ServerTxKey(msg)
+	}
+	return transaction.MakeServerTxKey(msg)
+}
+
+// MakeClientTxKey the key of the client transaction of msg (RFC 3261 17.1.3), see MakeServerTxKey.
+func MakeClientTxKey(msg sip.Message) (TxKey, error) {
+	if err := checkKeyParams(msg); err != nil {
+		return "", err
+	}
+	return transaction.MakeClientTxKey(msg)
+}
+
+// checkKeyParams an error if the branch of the top Via or the tag of the From of msg is a param without
+// a value, e.g. ";branch".
+func checkKeyParams(msg sip.Message) error {
+	if via, ok := msg.ViaHop(); ok && via.Params != nil {
+		if branch, ok := via.Params.Get("branch"); ok && branch == nil {
+			return fmt.Errorf("'branch' without value in 'Via' header of message '%s'", msg.Short())
+		}
+	}
+	if from, ok := msg.From(); ok && from.Params != nil {
+		if tag, ok := from.Params.Get("tag"); ok && tag == nil {
+			return fmt.Errorf("'tag' without value in 'From' header of message '%s'", msg.Short())
+		}
+	}
+	return nil
+}
+
+// untaggedRFC2543 whether msg is of an RFC 2543 transaction, its branch without the magic cookie, and its
+// From without tag, which the key of gosip requires.
+func untaggedRFC2543(msg sip.Message) bool {
+	via, ok := msg.ViaHop()
+	if !ok {
+		return false
+	}
+	if via.Params != nil {
+		if branch, ok := via.Params.Get("branch"); ok && strings.HasPrefix(branch.String(), sip.RFC3261BranchMagicCookie) {
+			return false
+		}
+	}
+	from, ok := msg.From()
+	return ok && (from.Params == nil || !from.Params.Has("tag"))
+}
+
+// rfc2543ServerTxKey the key of gosip for an RFC 2543 transaction (RFC 3261 17.2.3), of an empty From tag.
+func rfc2543ServerTxKey(msg sip.Message) (TxKey, error) {
+	via, _ := msg.ViaHop()
+	cseq, ok := msg.CSeq()
+	if !ok {
+		return "", fmt.Errorf("'CSeq' header not found in message '%s'", msg.Short())
+	}
+	callID, ok := msg.CallID()
+	if !ok {
+		return "", fmt.Errorf("'Call-ID' header not found in message '%s'", msg.Short())
+	}
+	method := cseq.MethodName
+	if method == sip.ACK || method == sip.CANCEL {
+		method = sip.INVITE
+	}
+	return TxKey(strings.Join([]string{"", callID.String(), string(method), fmt.Sprint(cseq.SeqNo), via.String()}, "__")), nil
+}
@@ -0,0 +1,47 @@
+package ua
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/ghettovoice/gosip/sip"
+)
+
+// DefaultMaxAuthAttempts the requests sent for a request challenged, the first one included.
+const DefaultMaxAuthAttempts = 3
+
+// ErrAuthFailed a request not authenticated, an *AuthError is ErrAuthFailed.
+var ErrAuthFailed = errors.New("authentication failed")
+
+// AuthError a request still challenged, or forbidden, after its credentials were sent.
+type AuthError struct {
+	*sip.RequestError
+	// Stale the last challenge was for a stale nonce, the credentials were right but the attempts ran out.
+	Stale bool
+	// Attempts the requests sent.
+	Attempts int
+}
+
+func (e *AuthError) Error() string {
+	if e.Stale {
+		return fmt.Sprintf("%s: nonce still stale after %d attempts", ErrAuthFailed, e.Attempts)
+	}
+	return fmt.Sprintf("%s: credentials rejected after %d attempts", ErrAuthFailed, e.Attempts)
+}
+
+// Is .
+func (e *AuthError) Is(target error) bool {
+	return target == ErrAuthFailed
+}
+
+// Unwrap .
+func (e *AuthError) Unwrap() error {
+	return e.RequestError
+}
+
+func (ua *UserAgent) maxAuthAttempts() int {
+	if ua.config.MaxAuthAttempts > 0 {
+		return ua.config.MaxAuthAttempts
+	}
+	return DefaultMaxAuthAttempts
+}
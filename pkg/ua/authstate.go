@@ -0,0 +1,51 @@
+package ua
+
+import (
+	"github.com/ghettovoice/gosip/sip"
+	"github.com/sergeyu/go-sip-ua/pkg/auth"
+)
+
+// AuthStatus .
+type AuthStatus string
+
+const (
+	// AuthChallenged a request was challenged, it is sent again with credentials if it has an authorizer.
+	AuthChallenged AuthStatus = "Challenged"
+	// AuthAuthorized a request with credentials succeeded.
+	AuthAuthorized AuthStatus = "Authorized"
+	// AuthFailed a request was not authenticated, without Stale the credentials of the realm are wrong or missing.
+	AuthFailed AuthStatus = "AuthFailed"
+)
+
+// AuthState an authentication event of a request, for a realm.
+type AuthState struct {
+	Status   AuthStatus
+	Scheme   string
+	Realm    string
+	Stale    bool
+	Request  sip.Request
+	Response sip.Response
+	// Err why the request failed, an *AuthError, an error of the authorizer or a *sip.RequestError without one.
+	Err error
+}
+
+// AuthHandler reports the challenges of the requests, and whether they were authorized.
+type AuthHandler func(state AuthState)
+
+// authState reports an authentication event for each of challenges.
+func (ua *UserAgent) authState(status AuthStatus, challenges []auth.Challenge, request sip.Request, response sip.Response, err error) {
+	if ua.AuthStateHandler == nil {
+		return
+	}
+	for _, challenge := range challenges {
+		ua.AuthStateHandler(AuthState{
+			Status:   status,
+			Scheme:   challenge.Scheme,
+			Realm:    challenge.Realm,
+			Stale:    challenge.Stale,
+			Request:  request,
+			Response: response,
+			Err:      err,
+		})
+	}
+}
@@ -0,0 +1,81 @@
+package ua
+
+import (
+	"fmt"
+
+	"github.com/ghettovoice/gosip/log"
+	"github.com/sergeyu/go-sip-ua/pkg/media/rtp"
+	"github.com/sergeyu/go-sip-ua/pkg/stack"
+)
+
+// Option configures the UserAgent of NewUserAgent.
+type Option func(config *UserAgentConfig)
+
+// WithConfig the settings of config, the options before it are overridden.
+func WithConfig(config UserAgentConfig) Option {
+	return func(c *UserAgentConfig) {
+		*c = config
+	}
+}
+
+// WithSipStack the stack the UA sends and receives its requests on.
+func WithSipStack(sipStack *stack.SipStack) Option {
+	return func(c *UserAgentConfig) {
+		c.SipStack = sipStack
+	}
+}
+
+// WithUserAgentString the User-Agent header of the requests of the UA.
+func WithUserAgentString(userAgent string) Option {
+	return func(c *UserAgentConfig) {
+		c.UserAgent = userAgent
+	}
+}
+
+// WithLogger the logger of the UA.
+func WithLogger(logger log.Logger) Option {
+	return func(c *UserAgentConfig) {
+		c.Logger = logger
+	}
+}
+
+// WithDefaults the defaults of the settings not set by the other options: a stack on the IP of the
+// host, not listening yet, DefaultMaxAuthAttempts and the RTP ports rtp.DefaultPortMin to rtp.DefaultPortMax.
+func WithDefaults() Option {
+	return func(c *UserAgentConfig) {
+		c.defaults = true
+	}
+}
+
+// setDefaults the defaults of WithDefaults.
+func (c *UserAgentConfig) setDefaults() {
+	if c.SipStack == nil {
+		c.SipStack = stack.NewSipStack(&stack.SipStackConfig{
+			UserAgent:  c.UserAgent,
+			Extensions: []string{"replaces"},
+		})
+	}
+	if c.MaxAuthAttempts == 0 {
+		c.MaxAuthAttempts = DefaultMaxAuthAttempts
+	}
+	if c.RTPPorts == nil {
+		c.RTPPorts = rtp.NewPortRange(rtp.DefaultPortMin, rtp.DefaultPortMax)
+	}
+}
+
+// Validate checks the settings of the config.
+func (c *UserAgentConfig) Validate() error {
+	if c.SipStack == nil {
+		return fmt.Errorf("ua: no SIP stack, use WithSipStack or WithDefaults")
+	}
+	if c.MaxAuthAttempts < 0 {
+		return fmt.Errorf("ua: negative MaxAuthAttempts %d", c.MaxAuthAttempts)
+	}
+	if c.KeepAliveInterval < 0 {
+		return fmt.Errorf("ua: negative KeepAliveInterval %v", c.KeepAliveInterval)
+	}
+	if c.RTPOptions.DSCP < 0 || c.RTPOptions.DSCP > 63 {
+		return fmt.Errorf("ua: DSCP %d out of range 0-63", c.RTPOptions.DSCP)
+	}
+	return nil
+}
@@ -0,0 +1,27 @@
+package ua
+
+import (
+	"context"
+
+	"github.com/ghettovoice/gosip/sip"
+	"github.com/sergeyu/go-sip-ua/pkg/account"
+)
+
+// lookupENUM the SIP URI of the global number of target found in ENUM as both the target and the recipient,
+// see UserAgentConfig.ENUMSuffixes, target and recipient unchanged if not found.
+func (ua *UserAgent) lookupENUM(ctx context.Context, target sip.Uri, recipient sip.SipUri) (sip.Uri, sip.SipUri) {
+	if len(ua.config.ENUMSuffixes) == 0 {
+		return target, recipient
+	}
+	number, ok := account.GlobalNumber(target)
+	if !ok {
+		return target, recipient
+	}
+	uri, err := ua.config.SipStack.LookupENUM(ctx, number, ua.config.ENUMSuffixes...)
+	if err != nil {
+		ua.Log().Debugf("ENUM lookup of %s failed, err => %v", number, err)
+		return target, recipient
+	}
+	ua.Log().Infof("ENUM %s => %s", number, uri)
+	return uri, *uri.(*sip.SipUri)
+}
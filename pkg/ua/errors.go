@@ -0,0 +1,75 @@
+package ua
+
+import (
+	"errors"
+	"time"
+
+	"github.com/ghettovoice/gosip/sip"
+	"github.com/sergeyu/go-sip-ua/pkg/stack"
+)
+
+// The failures of the requests a *ResponseError is, to branch on with errors.Is.
+var (
+	// ErrTimeout a request unanswered, 408.
+	ErrTimeout = errors.New("request timeout")
+	// ErrCanceled an INVITE canceled, by its context or its Expires, 487. A non-INVITE canceled by its
+	// context fails with a *CanceledError of the error of the context, e.g. context.Canceled.
+	ErrCanceled = errors.New("request canceled")
+	// ErrBusy a callee busy, 486 or 600.
+	ErrBusy = errors.New("busy")
+	// ErrNotRegistered a callee not registered, 480 (RFC 3261 21.4.18) or 404.
+	ErrNotRegistered = errors.New("not registered")
+)
+
+// ResponseError a request failed with a final response, or a timeout or the cancellation of an INVITE reported
+// as 408 and 487.
+// Code and Reason are the ones of the response.
+type ResponseError struct {
+	*sip.RequestError
+}
+
+// Is .
+func (e *ResponseError) Is(target error) bool {
+	switch target {
+	case ErrTimeout:
+		return e.Code == 408
+	case ErrCanceled:
+		return e.Code == 487
+	case ErrBusy:
+		return e.Code == 486 || e.Code == 600
+	case ErrNotRegistered:
+		return e.Code == 480 || e.Code == 404
+	case ErrAuthFailed:
+		return e.Code == 401 || e.Code == 407
+	}
+	return false
+}
+
+// RetryAfter the Retry-After of the response, e.g. of a 503, a 486 or a 600, false without one.
+func (e *ResponseError) RetryAfter() (time.Duration, bool) {
+	return stack.RetryAfter(e.Response)
+}
+
+// Unwrap .
+func (e *ResponseError) Unwrap() error {
+	return e.RequestError
+}
+
+// CanceledError a non-INVITE canceled by its context, Err the error of the context.
+type CanceledError struct {
+	Err error
+}
+
+func (e *CanceledError) Error() string {
+	return ErrCanceled.Error() + ": " + e.Err.Error()
+}
+
+// Is .
+func (e *CanceledError) Is(target error) bool {
+	return target == ErrCanceled
+}
+
+// Unwrap .
+func (e *CanceledError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,23 @@
+package ua
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+// TestCanceledError a non-INVITE canceled by its context both ErrCanceled and the error of the context.
+func TestCanceledError(t *testing.T) {
+	for _, cause := range []error{context.Canceled, context.DeadlineExceeded} {
+		var err error = &CanceledError{Err: cause}
+		if !errors.Is(err, ErrCanceled) {
+			t.Errorf("errors.Is(%v, ErrCanceled) = false", err)
+		}
+		if !errors.Is(err, cause) {
+			t.Errorf("errors.Is(%v, %v) = false", err, cause)
+		}
+		if errors.Is(err, ErrTimeout) {
+			t.Errorf("errors.Is(%v, ErrTimeout) = true", err)
+		}
+	}
+}
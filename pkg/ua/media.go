@@ -0,0 +1,57 @@
+package ua
+
+import (
+	"errors"
+
+	"github.com/sergeyu/go-sip-ua/pkg/account"
+	"github.com/sergeyu/go-sip-ua/pkg/media/ice"
+	"github.com/sergeyu/go-sip-ua/pkg/media/rtp"
+	"github.com/sergeyu/go-sip-ua/pkg/session"
+)
+
+// ErrSRTPStream the error of NewStream for a session which negotiated SRTP, a Stream sends RTP only.
+var ErrSRTPStream = errors.New("media: SRTP negotiated, not supported by rtp.Stream")
+
+// NewStream a media stream of the session with clockRate, bound on ip to a pair of ports of RTPPorts.
+// It is closed when the session ends, refused with ErrSRTPStream if the session negotiated SRTP.
+func (ua *UserAgent) NewStream(is *session.Session, ip string, clockRate uint32) (*rtp.Stream, error) {
+	return ua.NewStreamWithOptions(is, ua.rtpPorts, ip, clockRate, ua.config.RTPOptions)
+}
+
+// NewStreamWithProfile a media stream of the session as NewStream, bound to the RTP ports and address
+// of the profile with its socket options, the ones of the UA for those it has not.
+func (ua *UserAgent) NewStreamWithProfile(is *session.Session, profile *account.Profile, ip string, clockRate uint32) (*rtp.Stream, error) {
+	ports := ua.rtpPorts
+	if profile.RTPPorts != nil {
+		ports = profile.RTPPorts
+	}
+	if profile.RTPAddress != "" {
+		ip = profile.RTPAddress
+	}
+	options := ua.config.RTPOptions
+	if profile.RTPOptions != nil {
+		options = *profile.RTPOptions
+	}
+	return ua.NewStreamWithOptions(is, ports, ip, clockRate, options)
+}
+
+// NewStreamWithOptions a media stream of the session with clockRate, bound on ip to a pair of ports of ports
+// with the socket options of the call. It is closed when the session ends, refused as NewStream.
+func (ua *UserAgent) NewStreamWithOptions(is *session.Session, ports *rtp.PortRange, ip string, clockRate uint32, options rtp.SocketOptions) (*rtp.Stream, error) {
+	// The media would be sent in clear to a peer expecting SRTP.
+	if keys, err := is.SRTP(); err != nil || len(keys) > 0 {
+		return nil, ErrSRTPStream
+	}
+	stream, err := rtp.NewStreamWithOptions(ports, ip, clockRate, options)
+	if err != nil {
+		return nil, err
+	}
+	is.AddStream(stream)
+	return stream, nil
+}
+
+// NewAgent an ICE agent of stream with the servers of the ICE config, controlling if the UA offers.
+// Its candidates are gathered by Gather and described in the SDP by Describe.
+func (ua *UserAgent) NewAgent(stream *rtp.Stream, controlling bool) *ice.Agent {
+	return ice.NewAgent(stream, ua.config.ICE, controlling)
+}
@@ -0,0 +1,76 @@
+package ua
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/ghettovoice/gosip/log"
+	"github.com/ghettovoice/gosip/sip"
+	"github.com/ghettovoice/gosip/sip/parser"
+	"github.com/sergeyu/go-sip-ua/pkg/media"
+	"github.com/sergeyu/go-sip-ua/pkg/media/rtp"
+	"github.com/sergeyu/go-sip-ua/pkg/session"
+	"github.com/sergeyu/go-sip-ua/pkg/utils"
+)
+
+// answered a session of a call received, offered and answered with SRTP according to the policies.
+func answered(t *testing.T, offered media.SRTPPolicy, answerer media.SRTPPolicy) *session.Session {
+	logger := utils.NewLogger(log.ErrorLevel, "test", nil)
+	offer := media.NewSDP("192.0.2.2", media.NewAudio(4000, media.PCMU))
+	if err := media.SecureOffer(offer, offered); err != nil {
+		t.Fatal(err)
+	}
+	body := offer.String()
+	msg, err := parser.ParseMessage([]byte(fmt.Sprintf("INVITE sip:bob@192.0.2.1:5060 SIP/2.0\r\n"+
+		"Via: SIP/2.0/UDP 192.0.2.2:5060;branch=z9hG4bK-1\r\n"+
+		"Max-Forwards: 70\r\n"+
+		"From: <sip:alice@example.com>;tag=a1\r\n"+
+		"To: <sip:bob@example.com>\r\n"+
+		"Call-ID: 1@192.0.2.2\r\n"+
+		"CSeq: 1 INVITE\r\n"+
+		"Contact: <sip:alice@192.0.2.2:5060>\r\n"+
+		"Content-Type: application/sdp\r\n"+
+		"Content-Length: %d\r\n\r\n%s", len(body), body)), logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := msg.(sip.Request)
+	callID, _ := req.CallID()
+	contact, _ := req.Contact()
+	is := session.NewInviteSession(nil, "UAS", contact, req, *callID, nil, session.Incoming, logger)
+	answer, err := media.AnswerSRTP(offer, "192.0.2.1", answerer, media.NewAudio(5000, media.PCMU))
+	if err != nil {
+		t.Fatal(err)
+	}
+	is.ProvideAnswer(answer.String())
+	return is
+}
+
+// TestNewStreamSRTP a stream refused to a session keyed with SRTP, its media would be sent in clear.
+func TestNewStreamSRTP(t *testing.T) {
+	ua := &UserAgent{config: &UserAgentConfig{}, rtpPorts: rtp.NewPortRange(0, 0)}
+	tests := []struct {
+		offered, answerer media.SRTPPolicy
+		refused           bool
+	}{
+		{media.SRTPDisabled, media.SRTPDisabled, false},
+		{media.SRTPOptional, media.SRTPDisabled, false},
+		{media.SRTPOptional, media.SRTPOptional, true},
+		{media.SRTPRequired, media.SRTPRequired, true},
+	}
+	for _, tt := range tests {
+		is := answered(t, tt.offered, tt.answerer)
+		stream, err := ua.NewStream(is, "127.0.0.1", 8000)
+		if tt.refused {
+			if err != ErrSRTPStream {
+				t.Errorf("%d answered by %d: NewStream = %v; want ErrSRTPStream", tt.offered, tt.answerer, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%d answered by %d: NewStream = %v", tt.offered, tt.answerer, err)
+			continue
+		}
+		stream.Close()
+	}
+}
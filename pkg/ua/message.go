@@ -0,0 +1,140 @@
+package ua
+
+import (
+	"context"
+	"strings"
+
+	"github.com/ghettovoice/gosip/sip"
+	"github.com/sergeyu/go-sip-ua/pkg/account"
+)
+
+// MessageStatus delivery status of an outgoing MESSAGE.
+type MessageStatus struct {
+	Account    *account.Profile
+	Request    sip.Request
+	Response   sip.Response
+	StatusCode sip.StatusCode
+	Reason     string
+}
+
+// MessageStatusHandler .
+type MessageStatusHandler func(status MessageStatus)
+
+// MessageHandler handles incoming MESSAGE requests.
+type MessageHandler func(req sip.Request, contentType string, body string)
+
+// OnMessage registers the handler for incoming MESSAGE requests,
+// requests with a Content-Type not in contentTypes are rejected with 415,
+// an empty list accepts any Content-Type.
+func (ua *UserAgent) OnMessage(handler MessageHandler, contentTypes ...string) {
+	ua.hmu.Lock()
+	ua.messageHandler = handler
+	ua.messageTypes = contentTypes
+	ua.hmu.Unlock()
+	ua.config.SipStack.OnRequest(sip.MESSAGE, ua.handleMessage)
+}
+
+// SendMessage send MESSAGE (RFC 3428) with headers, a From replacing the one of the profile, the delivery status is reported to MessageStatusHandler.
+func (ua *UserAgent) SendMessage(profile *account.Profile, target sip.SipUri, contentType string, body string, headers ...sip.Header) (sip.Request, error) {
+	from, headers := ua.fromAddress(profile, headers)
+
+	to := &sip.Address{
+		Uri: &target,
+	}
+
+	request, err := ua.buildRequest(sip.MESSAGE, from, to, nil, target, profile.Routes, nil)
+	if err != nil {
+		ua.Log().Errorf("MESSAGE: err = %v", err)
+		return nil, err
+	}
+
+	(*request).SetBody(body, true)
+	ct := sip.ContentType(contentType)
+	(*request).AppendHeader(&ct)
+	ua.appendIdentity(profile, *request)
+	appendResourcePriority(profile, *request)
+	for _, header := range headers {
+		(*request).AppendHeader(header)
+	}
+
+	authorizer := ua.authorizer(profile)
+
+	go func(request sip.Request) {
+		resp, err := ua.RequestWithContext(context.TODO(), request, authorizer, true, 1)
+		status := MessageStatus{
+			Account:  profile,
+			Request:  request,
+			Response: resp,
+		}
+		if err != nil {
+			ua.Log().Errorf("MESSAGE: Request [MESSAGE] failed, err => %v", err)
+			if reqErr, ok := asRequestError(err); ok {
+				status.StatusCode = sip.StatusCode(reqErr.Code)
+				status.Reason = reqErr.Reason
+			} else {
+				status.StatusCode = 500
+				status.Reason = err.Error()
+			}
+		} else if resp != nil {
+			status.StatusCode = resp.StatusCode()
+			status.Reason = resp.Reason()
+		}
+
+		if ua.MessageStatusHandler != nil {
+			ua.MessageStatusHandler(status)
+		}
+	}(*request)
+
+	return *request, nil
+}
+
+func (ua *UserAgent) handleMessage(request sip.Request, tx sip.ServerTransaction) {
+	ua.requestLog(request, true).Debugf("handleMessage => %s, body => %s", request.Short(), request.Body())
+
+	if !ua.checkTargetDialog(request, tx) {
+		return
+	}
+
+	ua.hmu.RLock()
+	handler := ua.messageHandler
+	accepted := ua.messageTypes
+	ua.hmu.RUnlock()
+
+	contentType := "text/plain"
+	if ct, ok := request.ContentType(); ok {
+		contentType = ct.Value()
+	}
+
+	if !matchContentType(accepted, contentType) {
+		response := sip.NewResponseFromRequest(request.MessageID(), request, 415, "Unsupported Media Type", "")
+		accept := sip.Accept(strings.Join(accepted, ", "))
+		response.AppendHeader(&accept)
+		tx.Respond(response)
+		return
+	}
+
+	response := sip.NewResponseFromRequest(request.MessageID(), request, 200, "OK", "")
+	tx.Respond(response)
+
+	if handler != nil {
+		handler(request, contentType, request.Body())
+	}
+}
+
+// matchContentType check the media type against a list of accepted types, wildcards are allowed.
+func matchContentType(accepted []string, contentType string) bool {
+	if len(accepted) == 0 {
+		return true
+	}
+	mediaType := strings.ToLower(strings.TrimSpace(strings.Split(contentType, ";")[0]))
+	for _, value := range accepted {
+		value = strings.ToLower(strings.TrimSpace(strings.Split(value, ";")[0]))
+		if value == "*/*" || value == mediaType {
+			return true
+		}
+		if strings.HasSuffix(value, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(value, "*")) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,131 @@
+package ua
+
+import (
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/ghettovoice/gosip/sip"
+	"github.com/sergeyu/go-sip-ua/pkg/media"
+	"github.com/sergeyu/go-sip-ua/pkg/session"
+	"github.com/sergeyu/go-sip-ua/pkg/stack"
+)
+
+// OnNetworkChange registers the handler of the network changes of the stack, called once the accounts are
+// registered again, see stack.SipStackConfig.NetworkCheckInterval.
+func (ua *UserAgent) OnNetworkChange(handler stack.NetworkChangeHandler) {
+	ua.hmu.Lock()
+	ua.networkChangeHandler = handler
+	ua.hmu.Unlock()
+}
+
+// handleNetworkChange registers the accounts again from the new network: the Contact of the former address
+// is unregistered and the one of the new address registered, the registrar notified of the change at once
+// instead of at the next refresh. The calls on an address removed are moved to the new one.
+func (ua *UserAgent) handleNetworkChange(change stack.NetworkChange) {
+	var wg sync.WaitGroup
+	ua.registers.Range(func(key, value interface{}) bool {
+		wg.Add(1)
+		go func(r *Register) {
+			defer wg.Done()
+			r.rebind()
+		}(key.(*Register))
+		return true
+	})
+	wg.Wait()
+
+	for _, is := range ua.iss.all() {
+		if is.IsEstablished() {
+			wg.Add(1)
+			go func(is *session.Session) {
+				defer wg.Done()
+				ua.move(is, change)
+			}(is)
+		}
+	}
+	wg.Wait()
+
+	ua.hmu.RLock()
+	handler := ua.networkChangeHandler
+	ua.hmu.RUnlock()
+	if handler != nil {
+		handler(change)
+	}
+}
+
+// rebind registers the profile of r from the new network, its Contact the new address of the stack.
+// The RegisterStateHandler is told of the unregistration then of the registration.
+func (r *Register) rebind() {
+	if r.request == nil {
+		return
+	}
+	if err := r.SendRegister(0); err != nil {
+		r.ua.Log().Warnf("Unregister %s after a network change failed, err => %v", r.profile.URI, err)
+	}
+	if contact, ok := r.profile.ContactURI.(*sip.SipUri); ok {
+		r.profile.ContactURI = r.ua.rebound(contact)
+	}
+	if err := r.SendRegister(r.profile.Expires); err != nil {
+		r.ua.Log().Warnf("Register %s after a network change failed, err => %v", r.profile.URI, err)
+	}
+}
+
+// move re-INVITEs the call is with the new address of the stack if its Contact or its media are on an address
+// removed, see session.Move. The media addresses of another host, e.g. of a relay, are kept.
+func (ua *UserAgent) move(is *session.Session, change stack.NetworkChange) {
+	var contact *sip.ContactHeader
+	if target := is.LocalTarget(); target != nil && target.Address != nil {
+		if uri, ok := target.Address.(*sip.SipUri); ok && removed(change, uri.FHost) {
+			contact = target.Clone().(*sip.ContactHeader)
+			contact.Address = ua.rebound(uri)
+		}
+	}
+	var host string
+	if local, err := is.ParseLocalSdp(); err == nil && local != nil && change.IP != nil {
+		for _, address := range media.Addresses(local) {
+			if removed(change, address) {
+				host = change.IP.String()
+				break
+			}
+		}
+	}
+	if contact == nil && host == "" {
+		return
+	}
+	if contact == nil {
+		contact = is.LocalTarget()
+	}
+	ua.Log().Infof("Move call %s to %s after a network change", is.CallID(), contact.Address)
+	if err := is.Move(contact, host); err != nil {
+		ua.Log().Warnf("Move call %s after a network change failed, err => %v", is.CallID(), err)
+	}
+}
+
+// rebound uri with the host and the port of the stack for its transport.
+func (ua *UserAgent) rebound(uri *sip.SipUri) *sip.SipUri {
+	transport := "udp"
+	if uri.FUriParams != nil {
+		if tp, ok := uri.FUriParams.Get("transport"); ok && tp != nil {
+			transport = tp.String()
+		}
+	}
+	addr := ua.config.SipStack.GetNetworkInfo(transport)
+	rebound := uri.Clone().(*sip.SipUri)
+	rebound.FHost = addr.Host
+	rebound.FPort = addr.Port
+	return rebound
+}
+
+// removed whether host is the IP of an interface removed by change.
+func removed(change stack.NetworkChange, host string) bool {
+	ip := net.ParseIP(strings.Trim(host, "[]"))
+	if ip == nil {
+		return false
+	}
+	for _, other := range change.Removed {
+		if ip.Equal(other) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,402 @@
+package ua
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ghettovoice/gosip/sip"
+	"github.com/sergeyu/go-sip-ua/pkg/clock"
+)
+
+const (
+	// DefaultSubscribeExpires used when SUBSCRIBE has no Expires header.
+	DefaultSubscribeExpires = 3600
+)
+
+// SubscribeHandler called for every new incoming subscription, the handler
+// should send the initial NOTIFY or terminate the notifier.
+type SubscribeHandler func(n *Notifier)
+
+// StateHandler the current event state of a notifier, notified to a refresh of its subscription.
+type StateHandler func(n *Notifier) (contentType string, body string)
+
+// Notifier a server side subscription (RFC 6665).
+type Notifier struct {
+	ua          *UserAgent
+	event       string
+	id          string
+	callID      sip.CallID
+	local       *sip.Address
+	remote      *sip.Address
+	target      sip.Uri
+	routes      []sip.Uri
+	contact     *sip.Address
+	source      string
+	transport   string
+	seqNo       uint
+	version     uint
+	expires     uint32
+	state       SubscriptionState
+	contentType string
+	body        string
+	// stateHandler the event state notified to a refresh, the last one notified if nil.
+	stateHandler StateHandler
+	timer        clock.Timer
+	request      sip.Request
+	mu           sync.Mutex
+}
+
+// OnSubscribe registers the handler for incoming SUBSCRIBE requests of an event package.
+func (ua *UserAgent) OnSubscribe(event string, handler SubscribeHandler) {
+	ua.hmu.Lock()
+	if ua.subscribeHandlers == nil {
+		ua.subscribeHandlers = make(map[string]SubscribeHandler)
+	}
+	ua.subscribeHandlers[strings.ToLower(event)] = handler
+	ua.hmu.Unlock()
+	ua.config.SipStack.OnRequest(sip.SUBSCRIBE, ua.handleSubscribe)
+}
+
+// Notifiers returns the active notifiers of an event package.
+func (ua *UserAgent) Notifiers(event string) []*Notifier {
+	notifiers := make([]*Notifier, 0)
+	ua.notifiers.Range(func(key, value interface{}) bool {
+		n := value.(*Notifier)
+		if n.event == strings.ToLower(event) && n.State() != SubscriptionTerminated {
+			notifiers = append(notifiers, n)
+		}
+		return true
+	})
+	return notifiers
+}
+
+func (ua *UserAgent) handleSubscribe(request sip.Request, tx sip.ServerTransaction) {
+	ua.requestLog(request, true).Debugf("handleSubscribe => %s", request.Short())
+
+	event, params := "", sip.Params(nil)
+	if hdrs := request.GetHeaders("Event"); len(hdrs) > 0 {
+		event, params = parseEvent(hdrs[0].Value())
+	}
+
+	var expires uint32 = DefaultSubscribeExpires
+	if hdrs := request.GetHeaders("Expires"); len(hdrs) > 0 {
+		if value, ok := hdrs[0].(*sip.Expires); ok {
+			expires = uint32(*value)
+		}
+	}
+
+	callID, _ := request.CallID()
+	to, _ := request.To()
+
+	// Refresh or unsubscribe of an existing subscription.
+	if to.Params != nil && to.Params.Has("tag") {
+		if v, found := ua.notifiers.Load(*callID); found {
+			n := v.(*Notifier)
+			response := sip.NewResponseFromRequest(request.MessageID(), request, 200, "OK", "")
+			expiresHeader := sip.Expires(expires)
+			response.AppendHeader(&expiresHeader)
+			response.AppendHeader(n.contact.AsContactHeader())
+			tx.Respond(response)
+			if expires == 0 {
+				n.Terminate("timeout")
+			} else {
+				n.refresh(expires)
+			}
+			return
+		}
+		tx.Respond(sip.NewResponseFromRequest(request.MessageID(), request, 481, "Subscription Does Not Exist", ""))
+		return
+	}
+
+	ua.hmu.RLock()
+	handler, ok := ua.subscribeHandlers[event]
+	ua.hmu.RUnlock()
+
+	if !ok {
+		response := sip.NewResponseFromRequest(request.MessageID(), request, 489, "Bad Event", "")
+		allowEvents := make([]string, 0)
+		ua.hmu.RLock()
+		for name := range ua.subscribeHandlers {
+			allowEvents = append(allowEvents, name)
+		}
+		ua.hmu.RUnlock()
+		response.AppendHeader(&sip.GenericHeader{HeaderName: "Allow-Events", Contents: strings.Join(allowEvents, ", ")})
+		tx.Respond(response)
+		return
+	}
+
+	if !ua.checkTargetDialog(request, tx) {
+		return
+	}
+
+	n := ua.newNotifier(request, event, params, expires)
+	n.respond(request, tx, 202, "Accepted")
+
+	if expires == 0 {
+		// Fetch: one NOTIFY then terminate.
+		n.state = SubscriptionTerminated
+	} else {
+		ua.notifiers.Store(*callID, n)
+		n.startTimer(expires)
+	}
+
+	handler(n)
+}
+
+// newNotifier creates the notifier side of the dialog established by a SUBSCRIBE or REFER.
+func (ua *UserAgent) newNotifier(request sip.Request, event string, params sip.Params, expires uint32) *Notifier {
+	callID, _ := request.CallID()
+	from, _ := request.From()
+	to, _ := request.To()
+
+	localParams := sip.NewParams()
+	if to.Params != nil {
+		localParams = to.Params.Clone()
+	}
+	if !localParams.Has("tag") {
+		localParams.Add("tag", sip.String{Str: ua.config.SipStack.NewTag()})
+	}
+
+	n := &Notifier{
+		ua:        ua,
+		event:     event,
+		callID:    *callID,
+		local:     &sip.Address{DisplayName: to.DisplayName, Uri: to.Address.Clone(), Params: localParams},
+		remote:    &sip.Address{DisplayName: from.DisplayName, Uri: from.Address.Clone(), Params: from.Params.Clone()},
+		source:    request.Source(),
+		transport: request.Transport(),
+		expires:   expires,
+		state:     SubscriptionPending,
+		request:   request,
+	}
+	if params != nil {
+		if id, ok := params.Get("id"); ok && id != nil {
+			n.id = id.String()
+		}
+	}
+	if contact, ok := request.Contact(); ok {
+		n.target = contact.Address.Clone()
+	} else {
+		n.target = from.Address.Clone()
+	}
+	for _, hdr := range request.GetHeaders("Record-Route") {
+		if rr, ok := hdr.(*sip.RecordRouteHeader); ok {
+			n.routes = append(n.routes, rr.Addresses...)
+		}
+	}
+	n.contact = ua.localContact(request.Recipient().User(), n.transport)
+	return n
+}
+
+// respond sends the response establishing the dialog, with our To tag, Expires and Contact.
+func (n *Notifier) respond(request sip.Request, tx sip.ServerTransaction, statusCode sip.StatusCode, reason string) {
+	response := sip.NewResponseFromRequest(request.MessageID(), request, statusCode, reason, "")
+	if to, ok := response.To(); ok {
+		if to.Params == nil {
+			to.Params = sip.NewParams()
+		}
+		if tag, ok := n.local.Params.Get("tag"); ok && !to.Params.Has("tag") {
+			to.Params.Add("tag", tag)
+		}
+	}
+	expiresHeader := sip.Expires(n.expires)
+	response.AppendHeader(&expiresHeader)
+	response.AppendHeader(n.contact.AsContactHeader())
+	tx.Respond(response)
+}
+
+// localContact builds a Contact from the listening address of the stack.
+func (ua *UserAgent) localContact(user sip.MaybeString, transport string) *sip.Address {
+	addr := ua.config.SipStack.GetNetworkInfo(transport)
+	uri := &sip.SipUri{
+		FUser:      user,
+		FHost:      addr.Host,
+		FPort:      addr.Port,
+		FUriParams: sip.NewParams(),
+	}
+	if len(transport) > 0 && strings.ToUpper(transport) != "UDP" {
+		uri.FUriParams.Add("transport", sip.String{Str: strings.ToLower(transport)})
+	}
+	return &sip.Address{Uri: uri}
+}
+
+// Event .
+func (n *Notifier) Event() string {
+	return n.event
+}
+
+// Request the initial SUBSCRIBE request.
+func (n *Notifier) Request() sip.Request {
+	return n.request
+}
+
+// Subscriber .
+func (n *Notifier) Subscriber() sip.Uri {
+	return n.remote.Uri
+}
+
+// Resource the Request-URI of the initial SUBSCRIBE.
+func (n *Notifier) Resource() sip.Uri {
+	return n.request.Recipient()
+}
+
+// State .
+func (n *Notifier) State() SubscriptionState {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.state
+}
+
+// NextVersion the version of the next document notified, counted from 0 for the subscription, e.g. of a
+// dialog-info (RFC 4235) or a conference-info (RFC 4575).
+func (n *Notifier) NextVersion() uint {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	version := n.version
+	n.version++
+	return version
+}
+
+// SetStateHandler notifies a refresh of the subscription with the state of handler instead of the last one
+// notified, e.g. a document of the next version of a dialog-info or a conference-info.
+func (n *Notifier) SetStateHandler(handler StateHandler) {
+	n.mu.Lock()
+	n.stateHandler = handler
+	n.mu.Unlock()
+}
+
+// Accept activates a pending subscription.
+func (n *Notifier) Accept() {
+	n.mu.Lock()
+	if n.state == SubscriptionPending {
+		n.state = SubscriptionActive
+	}
+	n.mu.Unlock()
+}
+
+// Notify sends a NOTIFY with the current event state.
+func (n *Notifier) Notify(contentType string, body string) error {
+	n.mu.Lock()
+	if n.state == SubscriptionPending {
+		n.state = SubscriptionActive
+	}
+	n.contentType = contentType
+	n.body = body
+	state := n.state
+	n.mu.Unlock()
+
+	reason := ""
+	if state == SubscriptionTerminated {
+		reason = "timeout"
+	}
+	return n.send(state, reason)
+}
+
+// Terminate sends the final NOTIFY and removes the subscription.
+func (n *Notifier) Terminate(reason string) error {
+	n.mu.Lock()
+	if n.state == SubscriptionTerminated {
+		n.mu.Unlock()
+		return nil
+	}
+	n.state = SubscriptionTerminated
+	if n.timer != nil {
+		n.timer.Stop()
+		n.timer = nil
+	}
+	n.mu.Unlock()
+
+	n.ua.notifiers.Delete(n.callID)
+	return n.send(SubscriptionTerminated, reason)
+}
+
+func (n *Notifier) refresh(expires uint32) {
+	n.mu.Lock()
+	n.expires = expires
+	contentType := n.contentType
+	body := n.body
+	handler := n.stateHandler
+	n.mu.Unlock()
+
+	if handler != nil {
+		contentType, body = handler(n)
+	}
+	n.startTimer(expires)
+	n.Notify(contentType, body)
+}
+
+func (n *Notifier) startTimer(expires uint32) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.timer != nil {
+		n.timer.Stop()
+	}
+	n.timer = n.ua.clock().AfterFunc(time.Duration(expires)*time.Second, func() {
+		n.Terminate("timeout")
+	})
+}
+
+func (n *Notifier) send(state SubscriptionState, reason string) error {
+	n.mu.Lock()
+	n.seqNo++
+	seqNo := n.seqNo
+	contentType := n.contentType
+	body := n.body
+	expires := n.expires
+	n.mu.Unlock()
+
+	builder := sip.NewRequestBuilder()
+	builder.SetMethod(sip.NOTIFY)
+	builder.SetFrom(n.local)
+	builder.SetTo(n.remote)
+	builder.SetContact(n.contact)
+	builder.SetRecipient(n.target.Clone())
+	builder.SetCallID(&n.callID)
+	builder.SetSeqNo(seqNo)
+	maxForwards := sip.MaxForwards(n.ua.config.SipStack.MaxForwards())
+	builder.SetMaxForwards(&maxForwards)
+	if n.ua.config.UserAgent != "" {
+		userAgent := sip.UserAgentHeader(n.ua.config.UserAgent)
+		builder.SetUserAgent(&userAgent)
+	}
+	if len(n.routes) > 0 {
+		builder.SetRoutes(n.routes)
+	}
+
+	event := n.event
+	if len(n.id) > 0 {
+		event += ";id=" + n.id
+	}
+	builder.AddHeader(&sip.GenericHeader{HeaderName: "Event", Contents: event})
+
+	subState := string(state)
+	if state == SubscriptionTerminated {
+		if len(reason) > 0 {
+			subState += ";reason=" + reason
+		}
+	} else {
+		subState += fmt.Sprintf(";expires=%d", expires)
+	}
+	builder.AddHeader(&sip.GenericHeader{HeaderName: "Subscription-State", Contents: subState})
+
+	if len(body) > 0 {
+		ct := sip.ContentType(contentType)
+		builder.SetContentType(&ct)
+		builder.SetBody(body)
+	}
+
+	request, err := builder.Build()
+	if err != nil {
+		return err
+	}
+	if len(n.routes) == 0 {
+		request.SetDestination(n.source)
+	}
+	request.SetTransport(n.transport)
+
+	_, err = n.ua.RequestWithContext(context.TODO(), request, nil, false, 1)
+	return err
+}
@@ -0,0 +1,170 @@
+package ua
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ghettovoice/gosip/sip"
+	"github.com/sergeyu/go-sip-ua/pkg/account"
+	"github.com/sergeyu/go-sip-ua/pkg/session"
+)
+
+// OptionsPingState reachability of an OPTIONS ping target.
+type OptionsPingState struct {
+	Account    *account.Profile
+	Target     sip.SipUri
+	Reachable  bool
+	StatusCode sip.StatusCode
+	Reason     string
+	RTT        time.Duration
+	UserData   interface{}
+}
+
+// OptionsPingHandler called when the reachability of the target changes.
+type OptionsPingHandler func(state OptionsPingState)
+
+// OptionsPing sends OPTIONS periodically to a target.
+type OptionsPing struct {
+	ua        *UserAgent
+	profile   *account.Profile
+	target    sip.SipUri
+	interval  time.Duration
+	handler   OptionsPingHandler
+	reachable *bool
+	mu        sync.Mutex
+	ctx       context.Context
+	cancel    context.CancelFunc
+	data      interface{}
+}
+
+// NewOptionsPing .
+func NewOptionsPing(ua *UserAgent, profile *account.Profile, target sip.SipUri, interval time.Duration, handler OptionsPingHandler, data interface{}) *OptionsPing {
+	p := &OptionsPing{
+		ua:       ua,
+		profile:  profile,
+		target:   target,
+		interval: interval,
+		handler:  handler,
+		data:     data,
+	}
+	p.ctx, p.cancel = context.WithCancel(context.Background())
+	return p
+}
+
+// StartOptionsPing starts pinging the target with OPTIONS every interval.
+func (ua *UserAgent) StartOptionsPing(profile *account.Profile, target sip.SipUri, interval time.Duration, handler OptionsPingHandler, userdata interface{}) *OptionsPing {
+	ping := NewOptionsPing(ua, profile, target, interval, handler, userdata)
+	go ping.run()
+	return ping
+}
+
+func (p *OptionsPing) run() {
+	ticker := p.ua.clock().NewTicker(p.interval)
+	defer ticker.Stop()
+	for {
+		p.Ping()
+		select {
+		case <-ticker.C():
+		case <-p.ctx.Done():
+			return
+		}
+	}
+}
+
+// Reachable last known reachability of the target.
+func (p *OptionsPing) Reachable() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.reachable != nil && *p.reachable
+}
+
+// Ping sends a single OPTIONS request and updates the reachability state.
+func (p *OptionsPing) Ping() {
+	ua := p.ua
+	profile := p.profile
+
+	from := &sip.Address{
+		Uri:    profile.URI,
+		Params: sip.NewParams().Add("tag", sip.String{Str: ua.config.SipStack.NewTag()}),
+	}
+	to := &sip.Address{
+		Uri: &p.target,
+	}
+
+	request, err := ua.buildRequest(sip.OPTIONS, from, to, profile.Contact(), p.target, profile.Routes, nil)
+	if err != nil {
+		ua.Log().Errorf("OPTIONS: err = %v", err)
+		return
+	}
+
+	authorizer := ua.authorizer(profile)
+
+	start := time.Now()
+	resp, err := ua.RequestWithContext(p.ctx, *request, authorizer, true, 1)
+	if p.ctx.Err() != nil {
+		return
+	}
+
+	state := OptionsPingState{
+		Account:  profile,
+		Target:   p.target,
+		RTT:      time.Since(start),
+		UserData: p.data,
+	}
+
+	if err != nil {
+		// Any final response means the peer is alive, only timeouts and
+		// transport errors make it unreachable.
+		if reqErr, ok := asRequestError(err); ok {
+			state.StatusCode = sip.StatusCode(reqErr.Code)
+			state.Reason = reqErr.Reason
+			state.Reachable = reqErr.Response != nil && reqErr.Code != 408
+		} else {
+			state.StatusCode = 500
+			state.Reason = err.Error()
+		}
+	} else if resp != nil {
+		state.StatusCode = resp.StatusCode()
+		state.Reason = resp.Reason()
+		state.Reachable = true
+	}
+
+	p.mu.Lock()
+	changed := p.reachable == nil || *p.reachable != state.Reachable
+	p.reachable = &state.Reachable
+	p.mu.Unlock()
+
+	ua.Log().Debugf("OPTIONS ping %v: reachable => %v, code => %d", p.target.String(), state.Reachable, state.StatusCode)
+
+	if changed && p.handler != nil {
+		p.handler(state)
+	}
+}
+
+// Stop .
+func (p *OptionsPing) Stop() {
+	p.cancel()
+}
+
+func (ua *UserAgent) handleOptions(request sip.Request, tx sip.ServerTransaction) {
+	ua.requestLog(request, true).Debugf("handleOptions => %s", request.Short())
+
+	response := sip.NewResponseFromRequest(request.MessageID(), request, 200, "OK", "")
+
+	accepted := strings.Split(session.AcceptedBody, ",")
+	ua.hmu.RLock()
+	accepted = append(accepted, ua.messageTypes...)
+	ua.hmu.RUnlock()
+	for i := range accepted {
+		accepted[i] = strings.TrimSpace(accepted[i])
+	}
+	accept := sip.Accept(strings.Join(accepted, ", "))
+	response.AppendHeader(&accept)
+
+	allow := sip.AllowHeader(ua.config.SipStack.AllowedMethods())
+	response.AppendHeader(allow)
+
+	tx.Respond(response)
+}
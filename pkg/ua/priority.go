@@ -0,0 +1,111 @@
+package ua
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/ghettovoice/gosip/sip"
+	"github.com/sergeyu/go-sip-ua/pkg/account"
+)
+
+// ResourcePriority a namespace.priority value of the Resource-Priority header (RFC 4412), e.g. ets.0.
+type ResourcePriority struct {
+	Namespace string
+	Priority  string
+}
+
+// String .
+func (rp ResourcePriority) String() string {
+	return rp.Namespace + "." + rp.Priority
+}
+
+// ParseResourcePriority parses the Resource-Priority or Accept-Resource-Priority headers of a message.
+func ParseResourcePriority(msg sip.Message, name string) []ResourcePriority {
+	values := make([]ResourcePriority, 0)
+	for _, hdr := range msg.GetHeaders(name) {
+		for _, value := range strings.Split(hdr.Value(), ",") {
+			if rp, ok := parseResourcePriority(value); ok {
+				values = append(values, rp)
+			}
+		}
+	}
+	return values
+}
+
+// parseResourcePriority a namespace.priority value, false if it has no namespace or no priority.
+func parseResourcePriority(value string) (ResourcePriority, bool) {
+	value = strings.TrimSpace(value)
+	dot := strings.LastIndex(value, ".")
+	if dot <= 0 || dot == len(value)-1 {
+		return ResourcePriority{}, false
+	}
+	return ResourcePriority{
+		Namespace: strings.ToLower(value[:dot]),
+		Priority:  strings.ToLower(value[dot+1:]),
+	}, true
+}
+
+// NewResourcePriorityHeader builds a Resource-Priority header.
+func NewResourcePriorityHeader(values ...ResourcePriority) sip.Header {
+	parts := make([]string, 0, len(values))
+	for _, value := range values {
+		parts = append(parts, value.String())
+	}
+	return &sip.GenericHeader{HeaderName: "Resource-Priority", Contents: strings.Join(parts, ", ")}
+}
+
+// appendResourcePriority adds the Resource-Priority header of the values of the profile, the malformed
+// ones left out.
+func appendResourcePriority(profile *account.Profile, request sip.Request) {
+	values := make([]ResourcePriority, 0, len(profile.ResourcePriority))
+	for _, value := range profile.ResourcePriority {
+		if rp, ok := parseResourcePriority(value); ok {
+			values = append(values, rp)
+		}
+	}
+	if len(values) > 0 {
+		request.AppendHeader(NewResourcePriorityHeader(values...))
+	}
+}
+
+// ResourcePriorityError a request rejected with 417 Unknown Resource-Priority.
+type ResourcePriorityError struct {
+	*sip.RequestError
+	// Accepted values of the Accept-Resource-Priority header of the response.
+	Accepted []ResourcePriority
+}
+
+func (e *ResourcePriorityError) Error() string {
+	return fmt.Sprintf("unknown resource priority, accepted: %v", e.Accepted)
+}
+
+// Unwrap .
+func (e *ResourcePriorityError) Unwrap() error {
+	return e.RequestError
+}
+
+// newRequestError maps the request errors with a typed form, e.g. 417, the others to a *ResponseError.
+func newRequestError(err error) error {
+	reqErr, ok := err.(*sip.RequestError)
+	if !ok {
+		return err
+	}
+	if reqErr.Code != 417 {
+		return &ResponseError{RequestError: reqErr}
+	}
+	rpErr := &ResourcePriorityError{RequestError: reqErr}
+	if reqErr.Response != nil {
+		rpErr.Accepted = ParseResourcePriority(reqErr.Response, "Accept-Resource-Priority")
+	}
+	return rpErr
+}
+
+// asRequestError returns the *sip.RequestError of err, also if wrapped in a typed error.
+func asRequestError(err error) (*sip.RequestError, bool) {
+	var reqErr *sip.RequestError
+	if errors.As(err, &reqErr) {
+		return reqErr, true
+	}
+	return nil, false
+}
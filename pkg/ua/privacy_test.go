@@ -0,0 +1,47 @@
+package ua
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/ghettovoice/gosip/log"
+	"github.com/ghettovoice/gosip/sip"
+	"github.com/ghettovoice/gosip/sip/parser"
+	"github.com/sergeyu/go-sip-ua/pkg/utils"
+)
+
+func TestNextHopTrust(t *testing.T) {
+	ua := &UserAgent{config: &UserAgentConfig{TrustedHosts: []string{"proxy.example.com", "192.0.2.10:5070"}}}
+	tests := []struct {
+		uri, route string
+		want       string
+		trusted    bool
+	}{
+		{"sip:bob@proxy.example.com", "", "proxy.example.com", true},
+		{"sip:bob@192.0.2.10:5070", "", "192.0.2.10:5070", true},
+		{"sip:bob@192.0.2.10:5060", "", "192.0.2.10:5060", false},
+		{"sip:bob@example.org", "", "example.org", false},
+		{"sip:bob@example.org", "Route: <sip:proxy.example.com;lr>\r\n", "proxy.example.com", true},
+		{"sip:bob@proxy.example.com", "Route: <sip:edge.example.org;lr>\r\n", "edge.example.org", false},
+	}
+	for _, tt := range tests {
+		msg, err := parser.ParseMessage([]byte(fmt.Sprintf("INVITE %s SIP/2.0\r\n"+
+			"Via: SIP/2.0/UDP 192.0.2.1:5060;branch=z9hG4bK-1\r\n"+
+			"%s"+
+			"From: <sip:alice@example.com>;tag=a1\r\n"+
+			"To: <sip:bob@example.org>\r\n"+
+			"Call-ID: 1@192.0.2.1\r\n"+
+			"CSeq: 1 INVITE\r\n"+
+			"Content-Length: 0\r\n\r\n", tt.uri, tt.route)), utils.NewLogger(log.ErrorLevel, "test", nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		request := msg.(sip.Request)
+		if got := nextHop(request); got != tt.want {
+			t.Errorf("nextHop(%s, %q) = %s; want %s", tt.uri, tt.route, got, tt.want)
+		}
+		if got := ua.isTrustedHost(nextHop(request)); got != tt.trusted {
+			t.Errorf("isTrustedHost(%s) = %v; want %v", nextHop(request), got, tt.trusted)
+		}
+	}
+}
@@ -0,0 +1,251 @@
+package ua
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ghettovoice/gosip/sip"
+	"github.com/sergeyu/go-sip-ua/pkg/account"
+	"github.com/sergeyu/go-sip-ua/pkg/auth"
+	"github.com/sergeyu/go-sip-ua/pkg/clock"
+)
+
+// PUBLISH is not defined by gosip.
+const PUBLISH sip.RequestMethod = "PUBLISH"
+
+// PublicationHandler reports the result of every PUBLISH of the publication.
+type PublicationHandler func(pub *Publication, statusCode sip.StatusCode, reason string)
+
+// Publication an event state publication (RFC 3903).
+type Publication struct {
+	ua          *UserAgent
+	profile     *account.Profile
+	recipient   sip.SipUri
+	event       string
+	contentType string
+	body        string
+	expires     uint32
+	etag        string
+	handler     PublicationHandler
+	authorizer  auth.Authorizer
+	timer       clock.Timer
+	mu          sync.Mutex
+	ctx         context.Context
+	cancel      context.CancelFunc
+}
+
+// Publish publishes the initial event state of the account, the publication is refreshed automatically until removed.
+func (ua *UserAgent) Publish(profile *account.Profile, recipient sip.SipUri, event string, contentType string, body string, expires uint32, handler PublicationHandler) (*Publication, error) {
+	pub := &Publication{
+		ua:          ua,
+		profile:     profile,
+		recipient:   recipient,
+		event:       event,
+		contentType: contentType,
+		body:        body,
+		expires:     expires,
+		handler:     handler,
+	}
+	pub.ctx, pub.cancel = context.WithCancel(context.Background())
+
+	pub.authorizer = ua.authorizer(profile)
+
+	if err := pub.send(body, expires); err != nil {
+		pub.cancel()
+		return nil, err
+	}
+	return pub, nil
+}
+
+// ETag entity-tag assigned by the event state compositor.
+func (pub *Publication) ETag() string {
+	pub.mu.Lock()
+	defer pub.mu.Unlock()
+	return pub.etag
+}
+
+// Event .
+func (pub *Publication) Event() string {
+	return pub.event
+}
+
+// Refresh refreshes the publication without a body, or publishes its state again without an entity-tag,
+// e.g. after a failed PUBLISH: a refresh without SIP-If-Match is rejected (RFC 3903 6).
+func (pub *Publication) Refresh() error {
+	pub.mu.Lock()
+	body := ""
+	if pub.etag == "" {
+		body = pub.body
+	}
+	expires := pub.expires
+	pub.mu.Unlock()
+	return pub.send(body, expires)
+}
+
+// Modify replaces the published event state.
+func (pub *Publication) Modify(contentType string, body string) error {
+	pub.mu.Lock()
+	pub.contentType = contentType
+	pub.body = body
+	expires := pub.expires
+	pub.mu.Unlock()
+	return pub.send(body, expires)
+}
+
+// Remove removes the published event state, nothing sent without an entity-tag, no state published.
+func (pub *Publication) Remove() error {
+	pub.stopTimer()
+	// A removal is a PUBLISH of Expires 0 with the SIP-If-Match of the state (RFC 3903 4.5), one without
+	// it would be rejected with a 400.
+	if pub.ETag() == "" {
+		pub.cancel()
+		return nil
+	}
+	err := pub.send("", 0)
+	pub.cancel()
+	return err
+}
+
+func (pub *Publication) send(body string, expires uint32) error {
+	ua := pub.ua
+	profile := pub.profile
+
+	from := &sip.Address{
+		DisplayName: sip.String{Str: profile.DisplayName},
+		Uri:         profile.URI,
+		Params:      sip.NewParams().Add("tag", sip.String{Str: ua.config.SipStack.NewTag()}),
+	}
+
+	to := &sip.Address{
+		Uri: profile.URI,
+	}
+
+	request, err := ua.buildRequest(PUBLISH, from, to, nil, pub.recipient, profile.Routes, nil)
+	if err != nil {
+		ua.Log().Errorf("PUBLISH: err = %v", err)
+		return err
+	}
+
+	pub.mu.Lock()
+	etag := pub.etag
+	contentType := pub.contentType
+	pub.mu.Unlock()
+
+	(*request).AppendHeader(&sip.GenericHeader{HeaderName: "Event", Contents: pub.event})
+	expiresHeader := sip.Expires(expires)
+	(*request).AppendHeader(&expiresHeader)
+	if len(etag) > 0 {
+		(*request).AppendHeader(&sip.GenericHeader{HeaderName: "SIP-If-Match", Contents: etag})
+	}
+	if len(body) > 0 {
+		(*request).SetBody(body, true)
+		ct := sip.ContentType(contentType)
+		(*request).AppendHeader(&ct)
+	}
+
+	resp, err := ua.RequestWithContext(pub.ctx, *request, pub.authorizer, true, 1)
+	if err != nil {
+		reqErr, ok := asRequestError(err)
+		if ok && reqErr.Code == 412 && len(etag) > 0 {
+			// The entity-tag expired on the compositor, publish the full state again.
+			ua.Log().Debugf("PUBLISH: etag %s is unknown, republish", etag)
+			pub.mu.Lock()
+			pub.etag = ""
+			body = pub.body
+			pub.mu.Unlock()
+			if expires == 0 {
+				return nil
+			}
+			return pub.send(body, expires)
+		}
+		if ok && reqErr.Code == 423 && reqErr.Response != nil {
+			if min := minExpires(reqErr.Response); min > expires {
+				pub.mu.Lock()
+				pub.expires = min
+				pub.mu.Unlock()
+				return pub.send(body, min)
+			}
+		}
+
+		ua.Log().Errorf("Request [%s] failed, err => %v", PUBLISH, err)
+		if pub.handler != nil {
+			if ok {
+				pub.handler(pub, sip.StatusCode(reqErr.Code), reqErr.Reason)
+			} else {
+				pub.handler(pub, 500, err.Error())
+			}
+		}
+		return err
+	}
+
+	if resp == nil {
+		return fmt.Errorf("PUBLISH: no response")
+	}
+
+	if hdrs := resp.GetHeaders("SIP-ETag"); len(hdrs) > 0 {
+		pub.mu.Lock()
+		pub.etag = strings.TrimSpace(hdrs[0].Value())
+		pub.mu.Unlock()
+	}
+
+	if expires > 0 {
+		granted := expires
+		if hdrs := resp.GetHeaders("Expires"); len(hdrs) > 0 {
+			if value, ok := hdrs[0].(*sip.Expires); ok {
+				granted = uint32(*value)
+			}
+		}
+		pub.scheduleRefresh(granted)
+	}
+
+	if pub.handler != nil {
+		pub.handler(pub, resp.StatusCode(), resp.Reason())
+	}
+	return nil
+}
+
+func (pub *Publication) scheduleRefresh(expires uint32) {
+	interval := time.Duration(expires) * time.Second
+	if expires > 20 {
+		interval -= 10 * time.Second
+	} else {
+		interval /= 2
+	}
+
+	pub.mu.Lock()
+	if pub.timer != nil {
+		pub.timer.Stop()
+	}
+	pub.timer = pub.ua.clock().AfterFunc(interval, func() {
+		select {
+		case <-pub.ctx.Done():
+			return
+		default:
+		}
+		pub.Refresh()
+	})
+	pub.mu.Unlock()
+}
+
+func (pub *Publication) stopTimer() {
+	pub.mu.Lock()
+	if pub.timer != nil {
+		pub.timer.Stop()
+		pub.timer = nil
+	}
+	pub.mu.Unlock()
+}
+
+// minExpires reads the Min-Expires of a 423 response.
+func minExpires(response sip.Response) uint32 {
+	if hdrs := response.GetHeaders("Min-Expires"); len(hdrs) > 0 {
+		if value, err := strconv.Atoi(strings.TrimSpace(hdrs[0].Value())); err == nil {
+			return uint32(value)
+		}
+	}
+	return 0
+}
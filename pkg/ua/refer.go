@@ -0,0 +1,154 @@
+package ua
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/ghettovoice/gosip/sip"
+	"github.com/ghettovoice/gosip/sip/parser"
+	"github.com/sergeyu/go-sip-ua/pkg/account"
+)
+
+const (
+	// ReferEvent implicit subscription event of REFER (RFC 3515).
+	ReferEvent = "refer"
+	// ContentTypeSipFrag body of the refer NOTIFYs.
+	ContentTypeSipFrag = "message/sipfrag"
+
+	referExpires = 180
+)
+
+// ReferHandler called for an incoming REFER, report the progress of the referred request with n.NotifyReferStatus.
+type ReferHandler func(n *Notifier, referTo sip.Address, referredBy *sip.Address)
+
+// OnRefer registers the handler for incoming REFER requests.
+func (ua *UserAgent) OnRefer(handler ReferHandler) {
+	ua.hmu.Lock()
+	ua.referHandler = handler
+	ua.hmu.Unlock()
+	stack := ua.config.SipStack
+	stack.OnRequest(sip.REFER, ua.handleRefer)
+	// In-dialog SUBSCRIBE may refresh or terminate the implicit subscription.
+	stack.OnRequest(sip.SUBSCRIBE, ua.handleSubscribe)
+}
+
+// Refer sends an out-of-dialog REFER asking target to contact referTo, e.g. for click-to-dial.
+// The progress of the referred request is reported to the handler by the NOTIFYs of the implicit subscription.
+// headers are appended to the REFER, e.g. the Target-Dialog of a related session.
+func (ua *UserAgent) Refer(profile *account.Profile, target sip.Uri, recipient sip.SipUri, referTo sip.Uri, handler SubscriptionHandler, userdata interface{}, headers ...sip.Header) (*Subscription, error) {
+	from, headers := ua.fromAddress(profile, headers)
+
+	to := &sip.Address{
+		Uri: target,
+	}
+
+	request, err := ua.buildRequest(sip.REFER, from, to, profile.Contact(), recipient, profile.Routes, nil)
+	if err != nil {
+		ua.Log().Errorf("REFER: err = %v", err)
+		return nil, err
+	}
+
+	(*request).AppendHeader(&sip.GenericHeader{HeaderName: "Refer-To", Contents: "<" + referTo.String() + ">"})
+	(*request).AppendHeader(&sip.GenericHeader{HeaderName: "Referred-By", Contents: "<" + profile.URI.String() + ">"})
+	for _, header := range headers {
+		(*request).AppendHeader(header)
+	}
+
+	sub := &Subscription{
+		ua:      ua,
+		profile: profile,
+		event:   ReferEvent,
+		accept:  []string{ContentTypeSipFrag},
+		expires: referExpires,
+		handler: handler,
+		request: *request,
+		state:   SubscriptionInit,
+		data:    userdata,
+	}
+	sub.ctx, sub.cancel = context.WithCancel(context.Background())
+
+	sub.authorizer = ua.authorizer(profile)
+
+	if callID, ok := sub.request.CallID(); ok {
+		ua.subs.Store(*callID, sub)
+	}
+
+	if err := sub.send(referExpires); err != nil {
+		sub.release()
+		return nil, err
+	}
+
+	return sub, nil
+}
+
+func (ua *UserAgent) handleRefer(request sip.Request, tx sip.ServerTransaction) {
+	ua.requestLog(request, true).Debugf("handleRefer => %s", request.Short())
+
+	ua.hmu.RLock()
+	handler := ua.referHandler
+	ua.hmu.RUnlock()
+
+	if !ua.checkTargetDialog(request, tx) {
+		return
+	}
+
+	hdrs := request.GetHeaders("Refer-To")
+	if len(hdrs) != 1 {
+		tx.Respond(sip.NewResponseFromRequest(request.MessageID(), request, 400, "Bad Request", ""))
+		return
+	}
+
+	displayName, uri, params, err := parser.ParseAddressValue(hdrs[0].Value())
+	if err != nil {
+		ua.Log().Warnf("Invalid Refer-To: %v", err)
+		tx.Respond(sip.NewResponseFromRequest(request.MessageID(), request, 400, "Bad Refer-To", ""))
+		return
+	}
+	referTo := sip.Address{DisplayName: displayName, Uri: uri, Params: params}
+
+	var referredBy *sip.Address
+	if hdrs := request.GetHeaders("Referred-By"); len(hdrs) > 0 {
+		if displayName, uri, params, err := parser.ParseAddressValue(hdrs[0].Value()); err == nil {
+			referredBy = &sip.Address{DisplayName: displayName, Uri: uri, Params: params}
+		}
+	}
+
+	if handler == nil {
+		tx.Respond(sip.NewResponseFromRequest(request.MessageID(), request, 603, "Decline", ""))
+		return
+	}
+
+	n := ua.newNotifier(request, ReferEvent, nil, referExpires)
+	n.respond(request, tx, 202, "Accepted")
+
+	callID, _ := request.CallID()
+	ua.notifiers.Store(*callID, n)
+	n.startTimer(referExpires)
+
+	handler(n, referTo, referredBy)
+}
+
+// NotifyReferStatus reports the status of the referred request, a final status terminates the subscription.
+func (n *Notifier) NotifyReferStatus(statusCode sip.StatusCode, reason string) error {
+	body := fmt.Sprintf("SIP/2.0 %d %s\r\n", statusCode, reason)
+	if statusCode < 200 {
+		return n.Notify(ContentTypeSipFrag, body)
+	}
+
+	n.mu.Lock()
+	n.contentType = ContentTypeSipFrag
+	n.body = body
+	n.mu.Unlock()
+	return n.Terminate("noresource")
+}
+
+// ParseSipFrag returns the status line of a message/sipfrag body.
+func ParseSipFrag(body string) (sip.StatusCode, string, error) {
+	line := strings.TrimSpace(strings.SplitN(body, "\n", 2)[0])
+	_, statusCode, reason, err := parser.ParseStatusLine(line)
+	if err != nil {
+		return 0, "", err
+	}
+	return statusCode, reason, nil
+}
@@ -3,35 +3,55 @@ package ua
 import (
 	"context"
 	"strconv"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/ghettovoice/gosip/sip"
-	"github.com/ghettovoice/gosip/util"
 	"github.com/sergeyu/go-sip-ua/pkg/account"
 	"github.com/sergeyu/go-sip-ua/pkg/auth"
+	"github.com/sergeyu/go-sip-ua/pkg/clock"
+	"github.com/sergeyu/go-sip-ua/pkg/stack"
+	"github.com/sergeyu/go-sip-ua/pkg/utils"
 )
 
 type Register struct {
 	ua         *UserAgent
-	timer      *time.Timer
+	timer      clock.Timer
 	profile    *account.Profile
-	authorizer *auth.ClientAuthorizer
+	authorizer auth.Authorizer
 	recipient  sip.SipUri
 	request    *sip.Request
 	ctx        context.Context
 	cancel     context.CancelFunc
 	data       interface{}
+	// headers appended to the REGISTERs, e.g. the X-headers of a PBX.
+	headers []sip.Header
+	// staleContact the Contact removed by the next REGISTER after a rewrite.
+	staleContact *sip.ContactHeader
+	flow         *stack.Flow
+	// retries the REGISTERs sent again after a Retry-After since the last response.
+	retries int32
 }
 
-func NewRegister(ua *UserAgent, profile *account.Profile, recipient sip.SipUri, data interface{}) *Register {
+const (
+	// maxRegisterRetries the REGISTERs sent again in a row after the Retry-After of their failure.
+	maxRegisterRetries = 3
+	// minRegisterRetryAfter the shortest delay a REGISTER is sent again after, e.g. of a Retry-After 0.
+	minRegisterRetryAfter = 5 * time.Second
+)
+
+func NewRegister(ua *UserAgent, profile *account.Profile, recipient sip.SipUri, data interface{}, headers ...sip.Header) *Register {
 	r := &Register{
 		ua:        ua,
 		profile:   profile,
 		recipient: recipient,
 		request:   nil,
 		data:      data,
+		headers:   headers,
 	}
 	r.ctx, r.cancel = context.WithCancel(context.Background())
+	ua.registers.Store(r, true)
 	return r
 }
 
@@ -43,7 +63,7 @@ func (r *Register) SendRegister(expires uint32) error {
 
 	from := &sip.Address{
 		Uri:    profile.URI,
-		Params: sip.NewParams().Add("tag", sip.String{Str: util.RandString(8)}),
+		Params: sip.NewParams().Add("tag", sip.String{Str: ua.config.SipStack.NewTag()}),
 	}
 
 	to := &sip.Address{
@@ -51,6 +71,7 @@ func (r *Register) SendRegister(expires uint32) error {
 	}
 
 	contact := profile.Contact()
+	rewriting := false
 
 	if r.request == nil || expires == 0 {
 		request, err := ua.buildRequest(sip.REGISTER, from, to, contact, recipient, profile.Routes, nil)
@@ -60,20 +81,38 @@ func (r *Register) SendRegister(expires uint32) error {
 		}
 		expiresHeader := sip.Expires(expires)
 		(*request).AppendHeader(&expiresHeader)
+		for _, header := range r.headers {
+			(*request).AppendHeader(header)
+		}
 		r.request = request
 	} else {
 		cseq, _ := (*r.request).CSeq()
 		cseq.SeqNo++
 		cseq.MethodName = sip.REGISTER
+		if viaHop, ok := (*r.request).ViaHop(); ok && viaHop.Params != nil {
+			viaHop.Params.Add("branch", sip.String{Str: ua.config.SipStack.NewBranch()})
+		}
 
 		(*r.request).RemoveHeader("Expires")
 		// replace Expires header.
 		expiresHeader := sip.Expires(expires)
 		(*r.request).AppendHeader(&expiresHeader)
+
+		(*r.request).RemoveHeader("Contact")
+		(*r.request).AppendHeader(&sip.ContactHeader{
+			DisplayName: contact.DisplayName,
+			Address:     contact.Uri,
+			Params:      contact.Params,
+		})
+		if r.staleContact != nil {
+			(*r.request).AppendHeader(r.staleContact)
+			r.staleContact = nil
+			rewriting = true
+		}
 	}
 
-	if profile.AuthInfo != nil && r.authorizer == nil {
-		r.authorizer = auth.NewClientAuthorizer(profile.AuthInfo.AuthUser, profile.AuthInfo.Password)
+	if r.authorizer == nil {
+		r.authorizer = ua.authorizer(profile)
 	}
 	resp, err := ua.RequestWithContext(r.ctx, *r.request, r.authorizer, true, 1)
 
@@ -82,8 +121,7 @@ func (r *Register) SendRegister(expires uint32) error {
 
 		var code sip.StatusCode
 		var reason string
-		if _, ok := err.(*sip.RequestError); ok {
-			reqErr := err.(*sip.RequestError)
+		if reqErr, ok := asRequestError(err); ok {
 			code = sip.StatusCode(reqErr.Code)
 			reason = reqErr.Reason
 		} else {
@@ -105,19 +143,28 @@ func (r *Register) SendRegister(expires uint32) error {
 		if ua.RegisterStateHandler != nil {
 			ua.RegisterStateHandler(state)
 		}
+		if expires > 0 && ua.config.RegisterRetryAfter {
+			r.retryLater(err, expires)
+		}
+		return err
 	}
 	if resp != nil {
+		atomic.StoreInt32(&r.retries, 0)
 		stateCode := resp.StatusCode()
 		ua.Log().Debugf("%s resp %d => %s", sip.REGISTER, stateCode, resp.String())
 
+		if stateCode >= 200 && stateCode < 300 && expires > 0 && !rewriting &&
+			!ua.config.DisableContactRewrite && r.rewriteContact(resp) {
+			return r.SendRegister(expires)
+		}
+
 		var expires uint32 = 0
 		hdrs := resp.GetHeaders("Expires")
 		if len(hdrs) > 0 {
 			expires = uint32(*(hdrs[0]).(*sip.Expires))
 		} else {
-			hdrs = resp.GetHeaders("Contact")
-			if len(hdrs) > 0 {
-				if cexpires, cexpirescok := (hdrs[0].(*sip.ContactHeader)).Params.Get("expires"); cexpirescok {
+			if contact := r.binding(resp); contact != nil && contact.Params != nil {
+				if cexpires, cexpirescok := contact.Params.Get("expires"); cexpirescok {
 					cexpiresint, _ := strconv.Atoi(cexpires.String())
 					expires = uint32(cexpiresint)
 				}
@@ -132,14 +179,15 @@ func (r *Register) SendRegister(expires uint32) error {
 			UserData:   r.data,
 		}
 		if expires > 0 {
+			r.keepAlive(resp, expires)
 			go func() {
 				if r.timer == nil {
-					r.timer = time.NewTimer(time.Second * time.Duration(expires-10))
+					r.timer = r.ua.clock().NewTimer(time.Second * time.Duration(expires-10))
 				} else {
 					r.timer.Reset(time.Second * time.Duration(expires-10))
 				}
 				select {
-				case <-r.timer.C:
+				case <-r.timer.C():
 					r.SendRegister(expires)
 				case <-r.ctx.Done():
 					return
@@ -150,6 +198,10 @@ func (r *Register) SendRegister(expires uint32) error {
 				r.timer.Stop()
 				r.timer = nil
 			}
+			if r.flow != nil {
+				r.flow.Stop()
+				r.flow = nil
+			}
 			r.request = nil
 		}
 
@@ -163,10 +215,135 @@ func (r *Register) SendRegister(expires uint32) error {
 	return nil
 }
 
+// binding the Contact of resp to the REGISTER of r, the registrar listing all the bindings of the AOR
+// (RFC 3261 10.3), compared as URIs (RFC 3261 19.1.4). The first Contact if none is the one registered.
+func (r *Register) binding(resp sip.Response) *sip.ContactHeader {
+	var contacts []*sip.ContactHeader
+	for _, hdr := range resp.GetHeaders("Contact") {
+		if contact, ok := hdr.(*sip.ContactHeader); ok {
+			contacts = append(contacts, contact)
+		}
+	}
+	if len(contacts) == 0 {
+		return nil
+	}
+	if r.request != nil {
+		if own, ok := (*r.request).Contact(); ok {
+			for _, contact := range contacts {
+				if utils.URIEqual(contact.Address, own.Address) {
+					return contact
+				}
+			}
+		}
+	}
+	return contacts[0]
+}
+
+// rewriteContact registers the address the registrar received the REGISTER from instead of the
+// Contact of the profile, not reachable behind a NAT. Returns false if the Contact is that address.
+func (r *Register) rewriteContact(resp sip.Response) bool {
+	// The source port of a connection is not the listening one, the requests come back on the flow.
+	if !strings.EqualFold(resp.Transport(), "UDP") {
+		return false
+	}
+	host, port, ok := stack.Received(resp)
+	if !ok {
+		return false
+	}
+	contact := r.profile.Contact()
+	uri := contact.Uri
+	current := sip.DefaultPort(resp.Transport())
+	if uri.Port() != nil {
+		current = *uri.Port()
+	}
+	if uri.Host() == host && current == port {
+		return false
+	}
+
+	r.ua.Log().Infof("REGISTER received from %s:%d, rewrite Contact %s", host, port, uri)
+	r.staleContact = &sip.ContactHeader{
+		Address: uri,
+		Params:  sip.NewParams().Add("expires", sip.String{Str: "0"}),
+	}
+	rewritten := uri.Clone()
+	rewritten.SetHost(host)
+	rewritten.SetPort(&port)
+	r.profile.ContactURI = rewritten
+	return true
+}
+
+// retryLater sends the REGISTER failed with err again after the Retry-After of its 503, 486 or 600, at
+// least minRegisterRetryAfter, maxRegisterRetries times in a row at most.
+func (r *Register) retryLater(err error, expires uint32) {
+	reqErr, ok := asRequestError(err)
+	if !ok {
+		return
+	}
+	switch reqErr.Code {
+	case 503, 486, 600:
+	default:
+		return
+	}
+	delay, ok := stack.RetryAfter(reqErr.Response)
+	if !ok {
+		return
+	}
+	if atomic.AddInt32(&r.retries, 1) > maxRegisterRetries {
+		r.ua.Log().Warnf("REGISTER %s got %d, given up after %d retries", r.profile.URI, reqErr.Code, maxRegisterRetries)
+		return
+	}
+	if delay < minRegisterRetryAfter {
+		delay = minRegisterRetryAfter
+	}
+	r.ua.Log().Infof("REGISTER %s got %d, register again after %v", r.profile.URI, reqErr.Code, delay)
+	go func() {
+		timer := r.ua.clock().NewTimer(delay)
+		defer timer.Stop()
+		select {
+		case <-timer.C():
+			r.SendRegister(expires)
+		case <-r.ctx.Done():
+		}
+	}()
+}
+
+// keepAlive sends keepalives on the flow of the registration, registers again when the flow fails.
+func (r *Register) keepAlive(resp sip.Response, expires uint32) {
+	interval := r.ua.config.KeepAliveInterval
+	if interval <= 0 || resp.StatusCode() >= 300 {
+		return
+	}
+	if r.flow != nil {
+		if r.flow.Network == resp.Transport() && r.flow.Addr == resp.Source() {
+			return
+		}
+		r.flow.Stop()
+	}
+
+	flow := r.ua.config.SipStack.KeepAlive(resp.Transport(), resp.Source(), interval)
+	r.flow = flow
+	go func() {
+		select {
+		case <-flow.Failed():
+			r.ua.Log().Warnf("flow of %s to %s failed, register again", r.profile.URI, flow.Addr)
+			r.flow = nil
+			r.SendRegister(expires)
+		case <-flow.Stopped():
+		case <-r.ctx.Done():
+			flow.Stop()
+		}
+	}()
+}
+
 func (r *Register) Stop() {
 	if r.timer != nil {
 		r.timer.Stop()
 		r.timer = nil
 	}
+	if r.flow != nil {
+		r.flow.Stop()
+		r.flow = nil
+	}
 	r.cancel()
+	r.ua.registers.Delete(r)
 }
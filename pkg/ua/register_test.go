@@ -0,0 +1,215 @@
+package ua
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/ghettovoice/gosip/log"
+	"github.com/ghettovoice/gosip/sip"
+	"github.com/ghettovoice/gosip/sip/parser"
+	"github.com/sergeyu/go-sip-ua/pkg/account"
+	"github.com/sergeyu/go-sip-ua/pkg/clock"
+	"github.com/sergeyu/go-sip-ua/pkg/stack"
+	"github.com/sergeyu/go-sip-ua/pkg/utils"
+)
+
+// registrar answers the REGISTERs received on conn with a 200 of Expires expires, passing them to registers.
+// A 503 of Retry-After 0 if expires is 0.
+func registrar(t *testing.T, conn net.PacketConn, expires uint32, registers chan<- sip.Request) {
+	logger := utils.NewLogger(log.ErrorLevel, "test", nil)
+	buf := make([]byte, 65536)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		msg, err := parser.ParseMessage(append([]byte{}, buf[:n]...), logger)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		req, ok := msg.(sip.Request)
+		if !ok || req.Method() != sip.REGISTER {
+			continue
+		}
+		var res sip.Response
+		if expires == 0 {
+			res = sip.NewResponseFromRequest("", req, 503, "Service Unavailable", "")
+			res.AppendHeader(&sip.GenericHeader{HeaderName: "Retry-After", Contents: "0"})
+		} else {
+			res = sip.NewResponseFromRequest("", req, 200, "OK", "")
+			sip.CopyHeaders("Contact", req, res)
+			header := sip.Expires(expires)
+			res.AppendHeader(&header)
+		}
+		if to, ok := res.To(); ok {
+			to.Params = sip.NewParams().Add("tag", sip.String{Str: "r1"})
+		}
+		if _, err := conn.WriteTo([]byte(res.String()), addr); err != nil {
+			t.Error(err)
+			return
+		}
+		registers <- req
+	}
+}
+
+// waitPending waits for clk to have n timers active.
+func waitPending(t *testing.T, clk *clock.Mock, n int) {
+	deadline := time.Now().Add(time.Second)
+	for clk.Pending() != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("Pending = %d; want %d", clk.Pending(), n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// TestRegisterRefresh a registration of 60 s refreshed 10 s before it expires, on a mock clock.
+func TestRegisterRefresh(t *testing.T) {
+	utils.SetLogSink(utils.LogSinkFunc(func(level log.Level, msg string, fields log.Fields) {}))
+	defer utils.SetLogSink(nil)
+	clk := clock.NewMock(time.Now())
+	s := stack.NewSipStack(&stack.SipStackConfig{Host: "127.0.0.1", Clock: clk})
+	defer s.Shutdown()
+	if err := s.Listen("udp", "127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	registers := make(chan sip.Request, 2)
+	go registrar(t, conn, 60, registers)
+
+	userAgent, err := NewUserAgent(WithSipStack(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	states := make(chan account.RegisterState, 2)
+	userAgent.RegisterStateHandler = func(state account.RegisterState) {
+		states <- state
+	}
+	uri, err := parser.ParseUri("sip:100@127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	recipient, err := parser.ParseSipUri("sip:" + conn.LocalAddr().String() + ";transport=udp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	profile := account.NewProfile(uri, "", nil, 60, s)
+
+	pending := clk.Pending()
+	register, err := userAgent.SendRegister(profile, recipient, 60, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer register.Stop()
+	first := <-registers
+	if state := <-states; state.StatusCode != 200 || state.Expiration != 60 {
+		t.Fatalf("state = %d, %d s; want 200, 60 s", state.StatusCode, state.Expiration)
+	}
+	// Timer K of the REGISTER and the refresh.
+	waitPending(t, clk, pending+2)
+
+	clk.Add(50*time.Second - time.Millisecond)
+	select {
+	case <-registers:
+		t.Fatal("REGISTER refreshed before 50 s")
+	case <-time.After(50 * time.Millisecond):
+	}
+	clk.Add(time.Millisecond)
+	select {
+	case refresh := <-registers:
+		firstCSeq, _ := first.CSeq()
+		cseq, _ := refresh.CSeq()
+		if cseq.SeqNo != firstCSeq.SeqNo+1 {
+			t.Errorf("CSeq = %d; want %d", cseq.SeqNo, firstCSeq.SeqNo+1)
+		}
+		firstCallID, _ := first.CallID()
+		callID, _ := refresh.CallID()
+		if *callID != *firstCallID {
+			t.Errorf("Call-ID = %s; want %s", *callID, *firstCallID)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("REGISTER not refreshed after 50 s")
+	}
+	if state := <-states; state.StatusCode != 200 || state.Expiration != 60 {
+		t.Errorf("state = %d, %d s; want 200, 60 s", state.StatusCode, state.Expiration)
+	}
+}
+
+// TestRegisterRetryAfter a REGISTER failed with a 503 of Retry-After 0 sent again after minRegisterRetryAfter,
+// maxRegisterRetries times.
+func TestRegisterRetryAfter(t *testing.T) {
+	utils.SetLogSink(utils.LogSinkFunc(func(level log.Level, msg string, fields log.Fields) {}))
+	defer utils.SetLogSink(nil)
+	clk := clock.NewMock(time.Now())
+	s := stack.NewSipStack(&stack.SipStackConfig{Host: "127.0.0.1", Clock: clk})
+	defer s.Shutdown()
+	if err := s.Listen("udp", "127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	registers := make(chan sip.Request, maxRegisterRetries+2)
+	go registrar(t, conn, 0, registers)
+
+	userAgent, err := NewUserAgent(WithConfig(UserAgentConfig{SipStack: s, RegisterRetryAfter: true}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	states := make(chan account.RegisterState, maxRegisterRetries+2)
+	userAgent.RegisterStateHandler = func(state account.RegisterState) {
+		states <- state
+	}
+	uri, err := parser.ParseUri("sip:100@127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	recipient, err := parser.ParseSipUri("sip:" + conn.LocalAddr().String() + ";transport=udp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	profile := account.NewProfile(uri, "", nil, 60, s)
+
+	pending := clk.Pending()
+	register, err := userAgent.SendRegister(profile, recipient, 60, nil)
+	if err == nil {
+		t.Fatal("SendRegister answered 503 = nil; want an error")
+	}
+	defer register.Stop()
+	<-registers
+	for i := 0; i < maxRegisterRetries; i++ {
+		if state := <-states; state.StatusCode != 503 {
+			t.Fatalf("state = %d; want 503", state.StatusCode)
+		}
+		// Timer K of the REGISTER, of T4 as the retry, and the retry.
+		waitPending(t, clk, pending+2)
+		clk.Add(minRegisterRetryAfter - time.Millisecond)
+		select {
+		case <-registers:
+			t.Fatalf("REGISTER %d sent again before %v", i+1, minRegisterRetryAfter)
+		case <-time.After(50 * time.Millisecond):
+		}
+		clk.Add(time.Millisecond)
+		select {
+		case <-registers:
+		case <-time.After(time.Second):
+			t.Fatalf("REGISTER %d not sent again after %v", i+1, minRegisterRetryAfter)
+		}
+	}
+	<-states
+	waitPending(t, clk, pending+1)
+	clk.Add(time.Minute)
+	select {
+	case <-registers:
+		t.Errorf("REGISTER sent again more than %d times", maxRegisterRetries)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
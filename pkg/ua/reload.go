@@ -0,0 +1,141 @@
+package ua
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"github.com/ghettovoice/gosip/sip"
+	"github.com/sergeyu/go-sip-ua/pkg/account"
+	"github.com/sergeyu/go-sip-ua/pkg/utils"
+)
+
+// Account an account of ReloadConfig, its profile registered on its registrar.
+type Account struct {
+	// Profile the account, e.g. its credentials and its Routes, registered for its Expires.
+	Profile   *account.Profile
+	Registrar sip.SipUri
+	// Headers appended to the REGISTERs, see SendRegister.
+	Headers  []sip.Header
+	UserData interface{}
+}
+
+// ReloadConfig the settings applied at runtime by Reload.
+type ReloadConfig struct {
+	// Accounts the accounts registered by the UA, told apart by the URI of their profile, see utils.NormalizeURI.
+	Accounts []Account
+	// TrustedHosts replaces the ones of UserAgentConfig.
+	TrustedHosts []string
+}
+
+// Reload applies config without restarting the stack, the sessions and the subscriptions in progress kept:
+// the accounts registered not in config are unregistered, the new ones registered and the ones with another
+// Profile or Registrar registered again, their new Routes used by the next requests. The calls in progress
+// keep the profile they were made with. It returns the error of the first registration failed, the others
+// are still sent.
+func (ua *UserAgent) Reload(config ReloadConfig) error {
+	ua.hmu.Lock()
+	ua.config.TrustedHosts = config.TrustedHosts
+	ua.hmu.Unlock()
+
+	registered := make(map[string]*Register)
+	ua.registers.Range(func(key, value interface{}) bool {
+		r := key.(*Register)
+		registered[aorKey(r.profile)] = r
+		return true
+	})
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+	)
+	failed := func(err error) {
+		mu.Lock()
+		if firstErr == nil {
+			firstErr = err
+		}
+		mu.Unlock()
+	}
+	for _, acc := range config.Accounts {
+		aor := aorKey(acc.Profile)
+		old, found := registered[aor]
+		delete(registered, aor)
+		if found && old.profile == acc.Profile && utils.URIEqual(&old.recipient, &acc.Registrar) {
+			continue
+		}
+		wg.Add(1)
+		go func(acc Account) {
+			defer wg.Done()
+			// The binding of the same Contact on the same registrar is refreshed by the new REGISTER,
+			// without a gap in the registration.
+			if found && !sameBinding(old, acc) {
+				ua.unregister(old)
+			}
+			_, err := ua.SendRegister(acc.Profile, acc.Registrar, acc.Profile.Expires, acc.UserData, acc.Headers...)
+			if found && sameBinding(old, acc) {
+				old.Stop()
+			}
+			if err != nil {
+				failed(err)
+			}
+		}(acc)
+	}
+	for _, r := range registered {
+		wg.Add(1)
+		go func(r *Register) {
+			defer wg.Done()
+			ua.unregister(r)
+		}(r)
+	}
+	wg.Wait()
+	return firstErr
+}
+
+// ReloadOnSignal calls load then Reload on each SIGHUP until ctx is done, e.g. to read the accounts from a
+// configuration file edited. A failed load or reload is logged, the config in use kept.
+func (ua *UserAgent) ReloadOnSignal(ctx context.Context, load func() (ReloadConfig, error)) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	go func() {
+		defer signal.Stop(signals)
+		for {
+			select {
+			case <-signals:
+				config, err := load()
+				if err != nil {
+					ua.Log().Errorf("Reload: load config failed, err => %v", err)
+					continue
+				}
+				if err := ua.Reload(config); err != nil {
+					ua.Log().Errorf("Reload failed, err => %v", err)
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// unregister removes the binding of r and stops it, r is stopped even if the unregistration failed.
+func (ua *UserAgent) unregister(r *Register) {
+	if r.request != nil {
+		if err := r.SendRegister(0); err != nil {
+			ua.Log().Warnf("Unregister %s failed, err => %v", r.profile.URI, err)
+		}
+	}
+	r.Stop()
+}
+
+// sameBinding whether acc registers the Contact of r on the registrar of r.
+func sameBinding(r *Register, acc Account) bool {
+	return utils.URIEqual(&r.recipient, &acc.Registrar) &&
+		utils.URIEqual(r.profile.Contact().Uri, acc.Profile.Contact().Uri)
+}
+
+// aorKey the AOR of profile in its canonical form, e.g. sip:alice@example.com for sip:%61lice@EXAMPLE.com.
+func aorKey(profile *account.Profile) string {
+	return utils.NormalizeURI(profile.URI).String()
+}
@@ -0,0 +1,61 @@
+package ua
+
+import (
+	"github.com/ghettovoice/gosip/sip"
+	"github.com/sergeyu/go-sip-ua/pkg/session"
+)
+
+// RespondTo answers a request received, e.g. by a handler of OnRequest, with code and reason, the
+// session.ReasonPhrase of code if empty. The response has the Via, Record-Route, From, To, Call-ID and
+// CSeq of request, its To a local tag unless code is 100 or request has one, the same for each response
+// of request. A response creating a dialog, 101-299 to an INVITE, SUBSCRIBE or REFER, has the Contact of
+// the stack unless headers have one. headers are appended, body set with its Content-Length, its Content-Type
+// in headers.
+func (ua *UserAgent) RespondTo(request sip.Request, code sip.StatusCode, reason string, headers []sip.Header, body string) (sip.Response, error) {
+	if reason == "" {
+		reason = session.ReasonPhrase[uint16(code)]
+	}
+	if code != 100 {
+		ua.addToTag(request)
+	}
+	response := sip.NewResponseFromRequest("", request, code, reason, body)
+	for _, header := range headers {
+		response.AppendHeader(header)
+	}
+	if createsDialog(request.Method(), code) && !hasHeader(headers, "Contact") {
+		var user sip.MaybeString
+		if to, ok := request.To(); ok && to.Address != nil {
+			user = to.Address.User()
+		}
+		response.AppendHeader(ua.localContact(user, request.Transport()).AsContactHeader())
+	}
+	if _, err := ua.config.SipStack.Respond(response); err != nil {
+		ua.requestLog(request, true).Errorf("respond '%d %s' failed: %s", code, reason, err)
+		return nil, err
+	}
+	return response, nil
+}
+
+// addToTag adds a local tag to the To of request without one, the To of its responses.
+func (ua *UserAgent) addToTag(request sip.Request) {
+	to, ok := request.To()
+	if !ok {
+		return
+	}
+	if to.Params == nil {
+		to.Params = sip.NewParams()
+	}
+	if !to.Params.Has("tag") {
+		to.Params.Add("tag", sip.String{Str: ua.config.SipStack.NewTag()})
+	}
+}
+
+// createsDialog whether a response with code to a request of method creates a dialog (RFC 3261 12.1,
+// RFC 6665 4.1.2.4).
+func createsDialog(method sip.RequestMethod, code sip.StatusCode) bool {
+	switch method {
+	case sip.INVITE, sip.SUBSCRIBE, sip.REFER:
+		return code > 100 && code < 300
+	}
+	return false
+}
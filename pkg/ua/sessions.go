@@ -0,0 +1,102 @@
+package ua
+
+import (
+	"sync"
+
+	"github.com/ghettovoice/gosip/sip"
+	"github.com/sergeyu/go-sip-ua/pkg/session"
+)
+
+// sessionTable the invite sessions by Call-ID, the sessions of a Call-ID told apart by their dialog,
+// e.g. the two legs of a B2BUA loop or the calls of a forking proxy.
+type sessionTable struct {
+	mu       sync.RWMutex
+	byCallID map[string][]*session.Session
+}
+
+func newSessionTable() *sessionTable {
+	return &sessionTable{byCallID: make(map[string][]*session.Session)}
+}
+
+func (t *sessionTable) store(is *session.Session) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	callID := string(*is.CallID())
+	t.byCallID[callID] = append(t.byCallID[callID], is)
+}
+
+// delete reports whether is was in the table, to end it only once.
+func (t *sessionTable) delete(is *session.Session) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	callID := string(*is.CallID())
+	sessions := t.byCallID[callID]
+	deleted := false
+	for i, s := range sessions {
+		if s == is {
+			sessions = append(sessions[:i:i], sessions[i+1:]...)
+			deleted = true
+			break
+		}
+	}
+	if len(sessions) == 0 {
+		delete(t.byCallID, callID)
+	} else {
+		t.byCallID[callID] = sessions
+	}
+	return deleted
+}
+
+// load the session of the dialog id, see Session.MatchesDialog.
+func (t *sessionTable) load(id session.DialogID) (*session.Session, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	for _, is := range t.byCallID[string(id.CallID)] {
+		if is.MatchesDialog(id) {
+			return is, true
+		}
+	}
+	return nil, false
+}
+
+func (t *sessionTable) all() []*session.Session {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	sessions := make([]*session.Session, 0, len(t.byCallID))
+	for _, list := range t.byCallID {
+		sessions = append(sessions, list...)
+	}
+	return sessions
+}
+
+func (t *sessionTable) count() int {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	n := 0
+	for _, list := range t.byCallID {
+		n += len(list)
+	}
+	return n
+}
+
+// sentDialogID the dialog of a request sent or of its responses by its local tag only, the forks of an
+// INVITE answering with their own To tags.
+func sentDialogID(msg sip.Message) session.DialogID {
+	id := session.OutgoingDialogID(msg)
+	id.RemoteTag = ""
+	return id
+}
+
+// GetSession the session of the dialog id, nil if none. The empty tags of id match any, e.g. to find
+// a session by its Call-ID only.
+func (ua *UserAgent) GetSession(id session.DialogID) *session.Session {
+	if is, found := ua.iss.load(id); found {
+		return is
+	}
+	return nil
+}
+
+// SessionCount the number of the current invite sessions.
+func (ua *UserAgent) SessionCount() int {
+	return ua.iss.count()
+}
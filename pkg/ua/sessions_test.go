@@ -0,0 +1,122 @@
+package ua
+
+import (
+	"fmt"
+	"sync/atomic"
+	"testing"
+
+	"github.com/ghettovoice/gosip/log"
+	"github.com/ghettovoice/gosip/sip"
+	"github.com/ghettovoice/gosip/sip/parser"
+	"github.com/sergeyu/go-sip-ua/pkg/session"
+	"github.com/sergeyu/go-sip-ua/pkg/utils"
+)
+
+// benchInvite an INVITE received, its Call-ID and From tag numbered.
+const benchInvite = "INVITE sip:bob@192.0.2.1:5060 SIP/2.0\r\n" +
+	"Via: SIP/2.0/UDP 203.0.113.20:5062;branch=z9hG4bK-%[1]d;rport\r\n" +
+	"Max-Forwards: 70\r\n" +
+	"From: <sip:alice@example.com>;tag=t%[1]d\r\n" +
+	"To: <sip:bob@example.com>\r\n" +
+	"Call-ID: bench-%[1]d@203.0.113.20\r\n" +
+	"CSeq: 1 INVITE\r\n" +
+	"Contact: <sip:alice@203.0.113.20:5062>\r\n" +
+	"Content-Length: 0\r\n\r\n"
+
+// benchSessions n sessions of calls received, of distinct Call-IDs, and their dialogs.
+func benchSessions(b *testing.B, n int) ([]*session.Session, []session.DialogID) {
+	utils.SetLogSink(utils.LogSinkFunc(func(level log.Level, msg string, fields log.Fields) {}))
+	logger := utils.NewLogger(log.ErrorLevel, "bench", nil)
+	sessions := make([]*session.Session, n)
+	ids := make([]session.DialogID, n)
+	for i := range sessions {
+		msg, err := parser.ParseMessage([]byte(fmt.Sprintf(benchInvite, i)), logger)
+		if err != nil {
+			b.Fatal(err)
+		}
+		req := msg.(sip.Request)
+		callID, _ := req.CallID()
+		contact, _ := req.Contact()
+		sessions[i] = session.NewInviteSession(nil, "UAS", contact, req, *callID, nil, session.Incoming, logger)
+		ids[i] = sessions[i].DialogID()
+	}
+	return sessions, ids
+}
+
+// benchSizes the numbers of calls in progress of the benchmarks of the session table.
+var benchSizes = []int{100, 10000}
+
+// BenchmarkSessionTableLoad the lookups of the in-dialog requests, from GOMAXPROCS goroutines.
+func BenchmarkSessionTableLoad(b *testing.B) {
+	for _, size := range benchSizes {
+		b.Run(fmt.Sprintf("sessions=%d", size), func(b *testing.B) {
+			sessions, ids := benchSessions(b, size)
+			table := newSessionTable()
+			for _, is := range sessions {
+				table.store(is)
+			}
+			var next uint64
+			b.ReportAllocs()
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					id := ids[atomic.AddUint64(&next, 1)%uint64(len(ids))]
+					if _, ok := table.load(id); !ok {
+						b.Error("session not found")
+						return
+					}
+				}
+			})
+		})
+	}
+}
+
+// BenchmarkSessionTableStoreDelete the calls set up and ended, from GOMAXPROCS goroutines.
+func BenchmarkSessionTableStoreDelete(b *testing.B) {
+	for _, size := range benchSizes {
+		b.Run(fmt.Sprintf("sessions=%d", size), func(b *testing.B) {
+			sessions, _ := benchSessions(b, size)
+			table := newSessionTable()
+			var next uint64
+			b.ReportAllocs()
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					is := sessions[atomic.AddUint64(&next, 1)%uint64(len(sessions))]
+					table.store(is)
+					table.delete(is)
+				}
+			})
+		})
+	}
+}
+
+// BenchmarkSessionTableMixed the lookups of in-dialog requests with a call set up and ended every tenth,
+// from GOMAXPROCS goroutines: the contention of the readers with the writers.
+func BenchmarkSessionTableMixed(b *testing.B) {
+	for _, size := range benchSizes {
+		b.Run(fmt.Sprintf("sessions=%d", size), func(b *testing.B) {
+			sessions, ids := benchSessions(b, 2*size)
+			table := newSessionTable()
+			// The first half in progress, the second set up and ended.
+			for _, is := range sessions[:size] {
+				table.store(is)
+			}
+			var next uint64
+			b.ReportAllocs()
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					n := atomic.AddUint64(&next, 1)
+					if n%10 == 0 {
+						is := sessions[size+int(n/10)%size]
+						table.store(is)
+						table.delete(is)
+						continue
+					}
+					table.load(ids[n%uint64(size)])
+				}
+			})
+		})
+	}
+}
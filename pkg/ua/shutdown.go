@@ -0,0 +1,83 @@
+package ua
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// sessionsPollInterval how often ShutdownGracefully checks whether the sessions ended.
+const sessionsPollInterval = 100 * time.Millisecond
+
+// ShutdownGracefully unregisters the accounts registered, terminates the subscriptions and waits for the
+// sessions to end until ctx is done, the ones left are ended with BYE or CANCEL, then shuts the stack down.
+// It returns ctx.Err() if ctx was done before the accounts were unregistered and the subscriptions terminated.
+func (ua *UserAgent) ShutdownGracefully(ctx context.Context) error {
+	var wg sync.WaitGroup
+	ua.registers.Range(func(key, value interface{}) bool {
+		r := key.(*Register)
+		if r.request == nil {
+			r.Stop()
+			return true
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ua.unregister(r)
+		}()
+		return true
+	})
+	ua.subs.Range(func(key, value interface{}) bool {
+		sub := value.(*Subscription)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := sub.Unsubscribe(); err != nil {
+				ua.Log().Warnf("Unsubscribe %s failed, err => %v", sub.Event(), err)
+			}
+		}()
+		return true
+	})
+	ua.notifiers.Range(func(key, value interface{}) bool {
+		n := value.(*Notifier)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := n.Terminate("noresource"); err != nil {
+				ua.Log().Warnf("Terminate %s failed, err => %v", n.Event(), err)
+			}
+		}()
+		return true
+	})
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	var err error
+	select {
+	case <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	ticker := time.NewTicker(sessionsPollInterval)
+	defer ticker.Stop()
+wait:
+	for ua.SessionCount() > 0 {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			break wait
+		}
+	}
+	for _, is := range ua.Sessions() {
+		if !is.IsEnded() {
+			is.End()
+		}
+	}
+
+	ua.Shutdown()
+	return err
+}
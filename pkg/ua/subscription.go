@@ -0,0 +1,503 @@
+package ua
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ghettovoice/gosip/sip"
+	"github.com/sergeyu/go-sip-ua/pkg/account"
+	"github.com/sergeyu/go-sip-ua/pkg/auth"
+	"github.com/sergeyu/go-sip-ua/pkg/clock"
+)
+
+// SubscriptionState Subscription-State values (RFC 6665).
+type SubscriptionState string
+
+const (
+	SubscriptionInit       SubscriptionState = "init"
+	SubscriptionPending    SubscriptionState = "pending"
+	SubscriptionActive     SubscriptionState = "active"
+	SubscriptionTerminated SubscriptionState = "terminated"
+)
+
+// SubscriptionStatus is passed to the SubscriptionHandler on every state change or NOTIFY.
+type SubscriptionStatus struct {
+	Subscription *Subscription
+	State        SubscriptionState
+	Reason       string
+	Expires      uint32
+	RetryAfter   uint32
+	StatusCode   sip.StatusCode
+	ContentType  string
+	Body         string
+	// Request is the NOTIFY request, nil if the status comes from a SUBSCRIBE response.
+	Request  sip.Request
+	Response sip.Response
+}
+
+// SubscriptionHandler .
+type SubscriptionHandler func(status SubscriptionStatus)
+
+// Subscription a client side subscription to an event package.
+type Subscription struct {
+	ua         *UserAgent
+	profile    *account.Profile
+	event      string
+	id         string
+	accept     []string
+	expires    uint32
+	handler    SubscriptionHandler
+	authorizer auth.Authorizer
+	request    sip.Request
+	state      SubscriptionState
+	timer      clock.Timer
+	mu         sync.Mutex
+	ctx        context.Context
+	cancel     context.CancelFunc
+	data       interface{}
+}
+
+// Subscribe creates a subscription to the event package of target.
+func (ua *UserAgent) Subscribe(profile *account.Profile, target sip.Uri, recipient sip.SipUri, event string, accept []string, expires uint32, handler SubscriptionHandler, userdata interface{}) (*Subscription, error) {
+	from := &sip.Address{
+		DisplayName: sip.String{Str: profile.DisplayName},
+		Uri:         profile.URI,
+		Params:      sip.NewParams().Add("tag", sip.String{Str: ua.config.SipStack.NewTag()}),
+	}
+
+	to := &sip.Address{
+		Uri: target,
+	}
+
+	request, err := ua.buildRequest(sip.SUBSCRIBE, from, to, profile.Contact(), recipient, profile.Routes, nil)
+	if err != nil {
+		ua.Log().Errorf("SUBSCRIBE: err = %v", err)
+		return nil, err
+	}
+
+	sub := &Subscription{
+		ua:      ua,
+		profile: profile,
+		event:   event,
+		accept:  accept,
+		expires: expires,
+		handler: handler,
+		request: *request,
+		state:   SubscriptionInit,
+		data:    userdata,
+	}
+	sub.ctx, sub.cancel = context.WithCancel(context.Background())
+
+	if name, params := parseEvent(event); params != nil {
+		sub.event = name
+		if id, ok := params.Get("id"); ok && id != nil {
+			sub.id = id.String()
+		}
+	}
+
+	eventHeader := &sip.GenericHeader{HeaderName: "Event", Contents: event}
+	sub.request.AppendHeader(eventHeader)
+	if len(accept) > 0 {
+		acceptHeader := sip.Accept(strings.Join(accept, ", "))
+		sub.request.AppendHeader(&acceptHeader)
+	}
+
+	sub.authorizer = ua.authorizer(profile)
+
+	if callID, ok := sub.request.CallID(); ok {
+		ua.subs.Store(*callID, sub)
+	}
+
+	if err := sub.send(expires); err != nil {
+		sub.release()
+		return nil, err
+	}
+
+	return sub, nil
+}
+
+// Event event package name.
+func (sub *Subscription) Event() string {
+	return sub.event
+}
+
+// ID event id parameter.
+func (sub *Subscription) ID() string {
+	return sub.id
+}
+
+// State .
+func (sub *Subscription) State() SubscriptionState {
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+	return sub.state
+}
+
+// Account .
+func (sub *Subscription) Account() *account.Profile {
+	return sub.profile
+}
+
+// UserData .
+func (sub *Subscription) UserData() interface{} {
+	return sub.data
+}
+
+// Refresh sends a refreshing SUBSCRIBE.
+func (sub *Subscription) Refresh() error {
+	return sub.send(sub.expires)
+}
+
+// Unsubscribe terminates the subscription by sending SUBSCRIBE with Expires: 0.
+func (sub *Subscription) Unsubscribe() error {
+	sub.stopTimer()
+	if sub.State() == SubscriptionTerminated {
+		return nil
+	}
+	return sub.send(0)
+}
+
+// Stop releases the subscription without unsubscribing.
+func (sub *Subscription) Stop() {
+	sub.release()
+}
+
+func (sub *Subscription) send(expires uint32) error {
+	ua := sub.ua
+
+	sub.mu.Lock()
+	request := sub.request
+	if cseq, ok := request.CSeq(); ok && sub.state != SubscriptionInit {
+		cseq.SeqNo++
+	}
+	if viaHop, ok := request.ViaHop(); ok && viaHop.Params != nil {
+		viaHop.Params.Add("branch", sip.String{Str: ua.config.SipStack.NewBranch()})
+	}
+	if request.Method() == sip.REFER && sub.state != SubscriptionInit {
+		// The implicit subscription of a REFER is refreshed or terminated by SUBSCRIBE.
+		request.SetMethod(sip.SUBSCRIBE)
+		if cseq, ok := request.CSeq(); ok {
+			cseq.MethodName = sip.SUBSCRIBE
+		}
+		request.RemoveHeader("Refer-To")
+		request.RemoveHeader("Referred-By")
+		request.AppendHeader(&sip.GenericHeader{HeaderName: "Event", Contents: sub.event})
+	}
+	method := request.Method()
+	if method == sip.SUBSCRIBE {
+		request.RemoveHeader("Expires")
+		expiresHeader := sip.Expires(expires)
+		request.AppendHeader(&expiresHeader)
+	}
+	// The request sent is a copy, the template is updated by the NOTIFYs and the responses meanwhile.
+	request = request.Clone().(sip.Request)
+	sub.mu.Unlock()
+
+	resp, err := ua.RequestWithContext(sub.ctx, request, sub.authorizer, true, 1)
+	sub.mu.Lock()
+	sub.keepCredentials(request)
+	sub.mu.Unlock()
+	if err != nil {
+		ua.Log().Errorf("Request [%s] failed, err => %v", method, err)
+		status := SubscriptionStatus{
+			Subscription: sub,
+			State:        SubscriptionTerminated,
+			Reason:       "rejected",
+		}
+		if reqErr, ok := asRequestError(err); ok {
+			status.StatusCode = sip.StatusCode(reqErr.Code)
+			status.Response = reqErr.Response
+			status.RetryAfter = retryAfter(reqErr.Response)
+		} else {
+			status.StatusCode = 500
+		}
+		sub.terminate(status)
+		return err
+	}
+
+	if resp == nil {
+		return fmt.Errorf("%s: no response", method)
+	}
+
+	// Update the dialog from the 2xx.
+	sub.mu.Lock()
+	sub.updateDialog(resp)
+	sub.mu.Unlock()
+
+	if expires == 0 {
+		// Keep the subscription around for the final NOTIFY (Timer N).
+		sub.stopTimer()
+		sub.ua.clock().AfterFunc(32*time.Second, sub.release)
+		sub.notifyTerminated(SubscriptionStatus{
+			Subscription: sub,
+			State:        SubscriptionTerminated,
+			Reason:       "unsubscribed",
+			StatusCode:   resp.StatusCode(),
+			Response:     resp,
+		})
+		return nil
+	}
+
+	granted := expires
+	if hdrs := resp.GetHeaders("Expires"); len(hdrs) > 0 {
+		if value, ok := hdrs[0].(*sip.Expires); ok {
+			granted = uint32(*value)
+		}
+	}
+
+	sub.mu.Lock()
+	state := sub.state
+	if state == SubscriptionInit {
+		// 202 means pending until the first NOTIFY arrives.
+		state = SubscriptionPending
+		sub.state = state
+	}
+	sub.mu.Unlock()
+
+	sub.scheduleRefresh(granted)
+
+	if sub.handler != nil {
+		sub.handler(SubscriptionStatus{
+			Subscription: sub,
+			State:        state,
+			Expires:      granted,
+			StatusCode:   resp.StatusCode(),
+			Response:     resp,
+		})
+	}
+	return nil
+}
+
+// keepCredentials copies the CSeq and the credentials of the request sent, e.g. after a challenge, to the
+// template, lock must be held.
+func (sub *Subscription) keepCredentials(sent sip.Request) {
+	if cseq, ok := sent.CSeq(); ok {
+		if template, ok := sub.request.CSeq(); ok && template.SeqNo < cseq.SeqNo {
+			template.SeqNo = cseq.SeqNo
+		}
+	}
+	for _, name := range []string{"Authorization", "Proxy-Authorization"} {
+		if hdrs := sent.GetHeaders(name); len(hdrs) > 0 {
+			sub.request.RemoveHeader(name)
+			for _, hdr := range hdrs {
+				sub.request.AppendHeader(hdr.Clone())
+			}
+		}
+	}
+}
+
+// updateDialog stores remote tag and remote target, lock must be held.
+func (sub *Subscription) updateDialog(msg sip.Message) {
+	var tag sip.MaybeString
+	switch m := msg.(type) {
+	case sip.Response:
+		if to, ok := m.To(); ok && to.Params != nil {
+			tag, _ = to.Params.Get("tag")
+		}
+	case sip.Request:
+		if from, ok := m.From(); ok && from.Params != nil {
+			tag, _ = from.Params.Get("tag")
+		}
+	}
+
+	if tag != nil && tag.String() != "" {
+		if to, ok := sub.request.To(); ok {
+			if to.Params == nil {
+				to.Params = sip.NewParams()
+			}
+			to.Params.Add("tag", tag)
+		}
+	}
+
+	if contact, ok := msg.Contact(); ok {
+		sub.request.SetRecipient(contact.Address.Clone())
+	}
+}
+
+func (sub *Subscription) scheduleRefresh(expires uint32) {
+	if expires == 0 {
+		return
+	}
+	interval := time.Duration(expires) * time.Second
+	if expires > 20 {
+		interval -= 10 * time.Second
+	} else {
+		interval /= 2
+	}
+
+	sub.mu.Lock()
+	if sub.timer != nil {
+		sub.timer.Stop()
+	}
+	sub.timer = sub.ua.clock().AfterFunc(interval, func() {
+		select {
+		case <-sub.ctx.Done():
+			return
+		default:
+		}
+		sub.Refresh()
+	})
+	sub.mu.Unlock()
+}
+
+func (sub *Subscription) stopTimer() {
+	sub.mu.Lock()
+	if sub.timer != nil {
+		sub.timer.Stop()
+		sub.timer = nil
+	}
+	sub.mu.Unlock()
+}
+
+func (sub *Subscription) terminate(status SubscriptionStatus) {
+	sub.release()
+	sub.notifyTerminated(status)
+}
+
+func (sub *Subscription) notifyTerminated(status SubscriptionStatus) {
+	sub.mu.Lock()
+	terminated := sub.state == SubscriptionTerminated
+	sub.state = SubscriptionTerminated
+	sub.mu.Unlock()
+
+	if !terminated && sub.handler != nil {
+		sub.handler(status)
+	}
+}
+
+func (sub *Subscription) release() {
+	sub.stopTimer()
+	if callID, ok := sub.request.CallID(); ok {
+		sub.ua.subs.Delete(*callID)
+	}
+	sub.cancel()
+}
+
+func (sub *Subscription) handleNotify(request sip.Request, tx sip.ServerTransaction) {
+	state, params := parseSubscriptionState(request)
+
+	sub.mu.Lock()
+	sub.updateDialog(request)
+	if state != SubscriptionTerminated {
+		sub.state = state
+	}
+	sub.mu.Unlock()
+
+	response := sip.NewResponseFromRequest(request.MessageID(), request, 200, "OK", "")
+	tx.Respond(response)
+
+	status := SubscriptionStatus{
+		Subscription: sub,
+		State:        state,
+		Body:         request.Body(),
+		Request:      request,
+	}
+	if ct, ok := request.ContentType(); ok {
+		status.ContentType = ct.Value()
+	}
+	if reason, ok := params.Get("reason"); ok && reason != nil {
+		status.Reason = reason.String()
+	}
+	if value, ok := params.Get("expires"); ok && value != nil {
+		if expires, err := strconv.Atoi(value.String()); err == nil {
+			status.Expires = uint32(expires)
+		}
+	}
+	if value, ok := params.Get("retry-after"); ok && value != nil {
+		if retry, err := strconv.Atoi(value.String()); err == nil {
+			status.RetryAfter = uint32(retry)
+		}
+	}
+
+	if state == SubscriptionTerminated {
+		sub.terminate(status)
+		return
+	}
+
+	if status.Expires > 0 {
+		sub.scheduleRefresh(status.Expires)
+	}
+
+	if sub.handler != nil {
+		sub.handler(status)
+	}
+}
+
+func (ua *UserAgent) handleNotify(request sip.Request, tx sip.ServerTransaction) {
+	ua.requestLog(request, true).Debugf("handleNotify => %s, body => %s", request.Short(), request.Body())
+
+	callID, ok := request.CallID()
+	if ok {
+		if v, found := ua.subs.Load(*callID); found {
+			sub := v.(*Subscription)
+			name, params := "", sip.Params(nil)
+			if hdrs := request.GetHeaders("Event"); len(hdrs) > 0 {
+				name, params = parseEvent(hdrs[0].Value())
+			}
+			id := ""
+			if params != nil {
+				if value, ok := params.Get("id"); ok && value != nil {
+					id = value.String()
+				}
+			}
+			// A refer NOTIFY may carry the CSeq of the REFER as id.
+			if name == sub.event && (id == sub.id || sub.event == ReferEvent) {
+				sub.handleNotify(request, tx)
+				return
+			}
+		}
+	}
+
+	response := sip.NewResponseFromRequest(request.MessageID(), request, 481, "Subscription Does Not Exist", "")
+	tx.Respond(response)
+}
+
+// parseEvent splits an Event header value into package name and params.
+func parseEvent(value string) (string, sip.Params) {
+	parts := strings.Split(value, ";")
+	params := sip.NewParams()
+	for _, part := range parts[1:] {
+		kv := strings.SplitN(strings.TrimSpace(part), "=", 2)
+		if len(kv) == 2 {
+			params.Add(strings.ToLower(kv[0]), sip.String{Str: strings.Trim(kv[1], "\"")})
+		} else if len(kv[0]) > 0 {
+			params.Add(strings.ToLower(kv[0]), nil)
+		}
+	}
+	return strings.ToLower(strings.TrimSpace(parts[0])), params
+}
+
+// parseSubscriptionState parses the Subscription-State header of a NOTIFY.
+func parseSubscriptionState(request sip.Request) (SubscriptionState, sip.Params) {
+	hdrs := request.GetHeaders("Subscription-State")
+	if len(hdrs) == 0 {
+		return SubscriptionActive, sip.NewParams()
+	}
+	state, params := parseEvent(hdrs[0].Value())
+	switch SubscriptionState(state) {
+	case SubscriptionPending:
+		return SubscriptionPending, params
+	case SubscriptionTerminated:
+		return SubscriptionTerminated, params
+	}
+	return SubscriptionActive, params
+}
+
+// retryAfter reads the Retry-After seconds of a response.
+func retryAfter(response sip.Response) uint32 {
+	if response == nil {
+		return 0
+	}
+	if hdrs := response.GetHeaders("Retry-After"); len(hdrs) > 0 {
+		value := strings.TrimSpace(strings.Split(hdrs[0].Value(), ";")[0])
+		value = strings.TrimSpace(strings.Split(value, "(")[0])
+		if seconds, err := strconv.Atoi(value); err == nil {
+			return uint32(seconds)
+		}
+	}
+	return 0
+}
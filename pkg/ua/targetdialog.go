@@ -0,0 +1,32 @@
+package ua
+
+import (
+	"fmt"
+
+	"github.com/ghettovoice/gosip/sip"
+	"github.com/sergeyu/go-sip-ua/pkg/session"
+)
+
+// TargetSession returns the session identified by the Target-Dialog header of an out-of-dialog request,
+// nil if the request has no Target-Dialog.
+func (ua *UserAgent) TargetSession(request sip.Request) (*session.Session, error) {
+	td, ok := session.ParseTargetDialog(request)
+	if !ok {
+		return nil, nil
+	}
+	// The tags of the sender, the remote ones of the session.
+	if is, found := ua.iss.load(session.DialogID{CallID: td.CallID, LocalTag: td.RemoteTag, RemoteTag: td.LocalTag}); found {
+		return is, nil
+	}
+	return nil, fmt.Errorf("target dialog %s not found", td.String())
+}
+
+// checkTargetDialog responds 481 if the Target-Dialog of the request does not match a session.
+func (ua *UserAgent) checkTargetDialog(request sip.Request, tx sip.ServerTransaction) bool {
+	if _, err := ua.TargetSession(request); err != nil {
+		ua.Log().Warnf("%s: %v", request.Method(), err)
+		tx.Respond(sip.NewResponseFromRequest(request.MessageID(), request, 481, "Call/Transaction Does Not Exist", ""))
+		return false
+	}
+	return true
+}
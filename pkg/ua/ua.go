@@ -3,25 +3,76 @@ package ua
 import (
 	"context"
 	"fmt"
+	"net"
 	"strconv"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/sergeyu/go-sip-ua/pkg/account"
 	"github.com/sergeyu/go-sip-ua/pkg/auth"
+	"github.com/sergeyu/go-sip-ua/pkg/clock"
+	"github.com/sergeyu/go-sip-ua/pkg/identity"
+	"github.com/sergeyu/go-sip-ua/pkg/media"
+	"github.com/sergeyu/go-sip-ua/pkg/media/ice"
+	"github.com/sergeyu/go-sip-ua/pkg/media/rtp"
+	"github.com/sergeyu/go-sip-ua/pkg/multipart"
 	"github.com/sergeyu/go-sip-ua/pkg/session"
 	"github.com/sergeyu/go-sip-ua/pkg/stack"
+	"github.com/sergeyu/go-sip-ua/pkg/transaction"
 
 	"github.com/ghettovoice/gosip/log"
 	"github.com/ghettovoice/gosip/sip"
-	"github.com/ghettovoice/gosip/transaction"
-	"github.com/ghettovoice/gosip/util"
+	"github.com/pixelbender/go-sdp/sdp"
 
 	"github.com/sergeyu/go-sip-ua/pkg/utils"
 )
 
-// UserAgentConfig .
+// UserAgentConfig the settings of a UserAgent, set by the options of NewUserAgent.
 type UserAgentConfig struct {
 	SipStack *stack.SipStack
+	// UserAgent the User-Agent header of the requests, the one of the stack if empty.
+	UserAgent string
+	// Logger the logger of the UA, a logrus one at the debug level if nil.
+	Logger log.Logger
+	// TrustedElement the UA is part of a trust domain (RFC 3325), P-Asserted-Identity
+	// is removed from requests with Privacy: id sent to hosts not in TrustedHosts, the host of their first
+	// Route or else of their Request-URI.
+	TrustedElement bool
+	// TrustedHosts the hosts or host:port of the trust domain, replaced at runtime by Reload.
+	TrustedHosts []string
+	// IdentitySigner signs outgoing INVITEs with an Identity header (RFC 8224).
+	IdentitySigner identity.Signer
+	// IdentityVerifier verifies the Identity header of incoming INVITEs.
+	IdentityVerifier identity.Verifier
+	// AutoAnswerRequireAuth only honor auto-answer hints of INVITEs authenticated by the stack.
+	AutoAnswerRequireAuth bool
+	// DisableImmediateTrying leaves the 100 Trying of the incoming INVITEs to their transactions, sent after
+	// transaction.Timer_1xx unless the application responded, instead of at once on reception.
+	DisableImmediateTrying bool
+	// DisableContactRewrite keeps the registered Contact when the registrar sees
+	// the REGISTER from another address, e.g. behind a NAT (RFC 3581).
+	DisableContactRewrite bool
+	// KeepAliveInterval of the CRLF keepalives on the flows of the registrations (RFC 5626),
+	// a flow without pong is registered again. Disabled if zero.
+	KeepAliveInterval time.Duration
+	// RegisterRetryAfter sends a REGISTER failed with a 503, a 486 or a 600 again after its Retry-After,
+	// of 5 s at least, the registration given up without one or after 3 retries in a row.
+	RegisterRetryAfter bool
+	// MaxAuthAttempts the requests sent for a request challenged, DefaultMaxAuthAttempts if zero.
+	MaxAuthAttempts int
+	// RTPPorts the ports of the media streams, rtp.DefaultPortMin to rtp.DefaultPortMax if nil.
+	RTPPorts *rtp.PortRange
+	// RTPOptions the options of the sockets of the media streams, e.g. DSCP rtp.DSCPEF.
+	RTPOptions rtp.SocketOptions
+	// ICE the STUN and TURN servers of the ICE agents of the media streams (RFC 8445).
+	ICE ice.Config
+	// ENUMSuffixes the ENUM domains the global numbers called are looked up under, in order, e.g.
+	// stack.DefaultENUMSuffix: a number found is called at its SIP URI, the others through the OutboundDomain
+	// of the profile. Disabled if empty.
+	ENUMSuffixes []string
+	// defaults of WithDefaults.
+	defaults bool
 }
 
 //InviteSessionHandler .
@@ -34,32 +85,87 @@ type RegisterHandler func(regState account.RegisterState)
 type UserAgent struct {
 	InviteStateHandler   InviteSessionHandler
 	RegisterStateHandler RegisterHandler
+	MessageStatusHandler MessageStatusHandler
+	AuthStateHandler     AuthHandler
 	config               *UserAgentConfig
-	iss                  sync.Map /*Invite Session*/
-	log                  log.Logger
+	iss                  *sessionTable /*Invite Session*/
+	subs                 sync.Map      /*Subscription*/
+	notifiers            sync.Map      /*Notifier*/
+	registers            sync.Map      /*Register*/
+	messageHandler       MessageHandler
+	messageTypes         []string
+	subscribeHandlers    map[string]SubscribeHandler
+	referHandler         ReferHandler
+	networkChangeHandler stack.NetworkChangeHandler
+	hmu                  sync.RWMutex
+	// credentials the challenges answered, to authorize the requests preemptively.
+	credentials *auth.CredentialCache
+	rtpPorts    *rtp.PortRange
+	log         log.Logger
 }
 
-//NewUserAgent .
-func NewUserAgent(config *UserAgentConfig) *UserAgent {
+//NewUserAgent creates a UA configured by opts, e.g. NewUserAgent(WithSipStack(s)), an error if the config is not valid.
+func NewUserAgent(opts ...Option) (*UserAgent, error) {
+	config := &UserAgentConfig{}
+	for _, opt := range opts {
+		opt(config)
+	}
+	if config.defaults {
+		config.setDefaults()
+	}
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
 	ua := &UserAgent{
 		config:               config,
-		iss:                  sync.Map{},
+		iss:                  newSessionTable(),
 		InviteStateHandler:   nil,
 		RegisterStateHandler: nil,
-		log:                  utils.NewLogrusLogger(log.DebugLevel, "UserAgent", nil),
+		credentials:          auth.NewCredentialCache(),
+		log:                  config.Logger,
+	}
+	if ua.log == nil {
+		ua.log = utils.NewLogger(log.DebugLevel, "UserAgent", nil)
+	}
+	ua.rtpPorts = config.RTPPorts
+	if ua.rtpPorts == nil {
+		ua.rtpPorts = rtp.NewPortRange(rtp.DefaultPortMin, rtp.DefaultPortMax)
 	}
 	stack := config.SipStack
 	stack.OnRequest(sip.INVITE, ua.handleInvite)
 	stack.OnRequest(sip.ACK, ua.handleACK)
 	stack.OnRequest(sip.BYE, ua.handleBye)
 	stack.OnRequest(sip.CANCEL, ua.handleCancel)
-	return ua
+	stack.OnRequest(sip.OPTIONS, ua.handleOptions)
+	stack.OnRequest(sip.NOTIFY, ua.handleNotify)
+	stack.OnNetworkChange(ua.handleNetworkChange)
+	return ua, nil
+}
+
+// SipStack the stack of the UA, e.g. to listen on the one of WithDefaults.
+func (ua *UserAgent) SipStack() *stack.SipStack {
+	return ua.config.SipStack
+}
+
+// requestLog the logger of a request received or sent, with its call fields, see utils.RequestFields.
+func (ua *UserAgent) requestLog(request sip.Request, incoming bool) log.Logger {
+	return ua.Log().WithFields(utils.RequestFields(request, incoming))
 }
 
 func (ua *UserAgent) Log() log.Logger {
 	return ua.log
 }
 
+// clock the clock of the timers of the stack, see stack.SipStackConfig.Clock.
+func (ua *UserAgent) clock() clock.Clock {
+	return ua.config.SipStack.Clock()
+}
+
+//Sessions returns the current invite sessions.
+func (ua *UserAgent) Sessions() []*session.Session {
+	return ua.iss.all()
+}
+
 func (ua *UserAgent) handleInviteState(is *session.Session, request *sip.Request, response *sip.Response, state session.Status, tx *sip.Transaction) {
 	if request != nil && *request != nil {
 		is.StoreRequest(*request)
@@ -75,9 +181,34 @@ func (ua *UserAgent) handleInviteState(is *session.Session, request *sip.Request
 
 	is.SetState(state)
 
-	if ua.InviteStateHandler != nil {
+	if is.ReleaseCause() == nil {
+		switch state {
+		case session.Failure:
+			if response != nil && *response != nil {
+				is.SetReleaseCause(session.NewReleaseCause(nil, *response))
+			} else {
+				is.SetReleaseCause(&session.ReleaseCause{Cause: session.CauseNetworkFailure})
+			}
+		case session.Canceled:
+			is.SetReleaseCause(&session.ReleaseCause{Cause: session.CauseCanceled, StatusCode: 487})
+		case session.Terminated:
+			if request != nil && *request != nil {
+				is.SetReleaseCause(session.NewReleaseCause(*request, nil))
+			}
+		}
+	}
+
+	if handler := is.Handler(); handler != nil {
+		handler(is, request, response, state)
+	} else if ua.InviteStateHandler != nil {
 		ua.InviteStateHandler(is, request, response, state)
 	}
+
+	var resp sip.Response
+	if response != nil {
+		resp = *response
+	}
+	is.DispatchState(state, resp)
 }
 
 func (ua *UserAgent) buildRequest(
@@ -94,16 +225,26 @@ func (ua *UserAgent) buildRequest(
 	builder.SetMethod(method)
 	builder.SetFrom(from)
 	builder.SetTo(to)
-	builder.SetContact(contact)
+	if contact != nil {
+		builder.SetContact(contact)
+	}
 	builder.SetRecipient(recipient.Clone())
+	maxForwards := sip.MaxForwards(ua.config.SipStack.MaxForwards())
+	builder.SetMaxForwards(&maxForwards)
+	if ua.config.UserAgent != "" {
+		userAgent := sip.UserAgentHeader(ua.config.UserAgent)
+		builder.SetUserAgent(&userAgent)
+	}
 
 	if len(routes) > 0 {
 		builder.SetRoutes(routes)
 	}
 
-	if callID != nil {
-		builder.SetCallID(callID)
+	if callID == nil {
+		newCallID := ua.config.SipStack.NewCallID()
+		callID = &newCallID
 	}
+	builder.SetCallID(callID)
 
 	req, err := builder.Build()
 	if err != nil {
@@ -115,27 +256,112 @@ func (ua *UserAgent) buildRequest(
 	return &req, nil
 }
 
-func (ua *UserAgent) SendRegister(profile *account.Profile, recipient sip.SipUri, expires uint32, userdata interface{}) (*Register, error) {
-	register := NewRegister(ua, profile, recipient, userdata)
+// appendIdentity adds the P-Preferred-Identity and Privacy headers of the profile.
+func (ua *UserAgent) appendIdentity(profile *account.Profile, request sip.Request) {
+	if profile.PreferredIdentity != nil {
+		request.AppendHeader(identity.NewPreferredIdentity(*profile.PreferredIdentity))
+	}
+	if len(profile.Privacy) > 0 {
+		values := make([]identity.Privacy, 0, len(profile.Privacy))
+		for _, value := range profile.Privacy {
+			values = append(values, identity.Privacy(value))
+		}
+		request.AppendHeader(identity.NewPrivacy(values...))
+	}
+}
+
+// nextHop the host:port the request is sent to, of its first Route or else of its Request-URI, the host
+// alone if the URI has no port.
+func nextHop(request sip.Request) string {
+	uri := request.Recipient()
+	if hdrs := request.GetHeaders("Route"); len(hdrs) > 0 {
+		if route, ok := hdrs[0].(*sip.RouteHeader); ok && len(route.Addresses) > 0 {
+			uri = route.Addresses[0]
+		}
+	}
+	if port := uri.Port(); port != nil {
+		return net.JoinHostPort(uri.Host(), strconv.Itoa(int(*port)))
+	}
+	return uri.Host()
+}
+
+//isTrustedHost reports whether the destination host:port belongs to the trust domain.
+func (ua *UserAgent) isTrustedHost(destination string) bool {
+	host := destination
+	if h, _, err := net.SplitHostPort(destination); err == nil {
+		host = h
+	}
+	ua.hmu.RLock()
+	trustedHosts := ua.config.TrustedHosts
+	ua.hmu.RUnlock()
+	for _, trusted := range trustedHosts {
+		if strings.EqualFold(trusted, host) || strings.EqualFold(trusted, destination) {
+			return true
+		}
+	}
+	return false
+}
+
+// SendRegister registers profile on recipient for expires seconds, the headers appended to the REGISTERs
+// and their refreshes, e.g. the X-headers a PBX keys the account off. A failed registration returns the
+// Register with the error, e.g. a *ResponseError or an *AuthError, to send it again.
+func (ua *UserAgent) SendRegister(profile *account.Profile, recipient sip.SipUri, expires uint32, userdata interface{}, headers ...sip.Header) (*Register, error) {
+	register := NewRegister(ua, profile, recipient, userdata, headers...)
 	err := register.SendRegister(expires)
 	if err != nil {
 		ua.Log().Errorf("SendRegister failed, err => %v", err)
-		return nil, err
+		return register, err
 	}
 	return register, nil
 }
 
-func (ua *UserAgent) Invite(profile *account.Profile, target sip.Uri, recipient sip.SipUri, body *string) (*session.Session, error) {
-	return ua.InviteWithContext(context.TODO(), profile, target, recipient, body)
+// Invite sends an INVITE and returns its session as soon as it is sent, in the InviteSent state: the
+// ringing, the answer and the failure are handled by the InviteStateHandler and the OnState of the session.
+// The headers, e.g. Alert-Info, Subject, Call-Info or X-headers, are appended to the INVITE, a From
+// replaces the address and the display name of the profile for this call. The target can be a tel: URI,
+// e.g. from account.ParseTarget, see Profile.Target.
+func (ua *UserAgent) Invite(profile *account.Profile, target sip.Uri, recipient sip.SipUri, body *string, headers ...sip.Header) (*session.Session, error) {
+	return ua.InviteWithContext(context.TODO(), profile, target, recipient, body, headers...)
 }
 
-func (ua *UserAgent) InviteWithContext(ctx context.Context, profile *account.Profile, target sip.Uri, recipient sip.SipUri, body *string) (*session.Session, error) {
+func (ua *UserAgent) InviteWithContext(ctx context.Context, profile *account.Profile, target sip.Uri, recipient sip.SipUri, body *string, headers ...sip.Header) (*session.Session, error) {
+	return ua.inviteWithContext(ctx, profile, target, recipient, body, 0, headers)
+}
 
-	from := &sip.Address{
-		DisplayName: sip.String{Str: profile.DisplayName},
-		Uri:         profile.URI,
-		Params:      sip.NewParams().Add("tag", sip.String{Str: util.RandString(8)}),
+// InviteWithHandler sends an INVITE like InviteWithContext, the session owned by handler from its creation,
+// its states passed to it instead of the InviteStateHandler, see Session.SetHandler.
+func (ua *UserAgent) InviteWithHandler(ctx context.Context, profile *account.Profile, target sip.Uri, recipient sip.SipUri, body *string, handler session.Handler, headers ...sip.Header) (*session.Session, error) {
+	return ua.inviteWithContext(context.WithValue(ctx, handlerKey{}, handler), profile, target, recipient, body, 0, headers)
+}
+
+// handlerKey the context key of the handler of the session of an INVITE sent, see InviteWithHandler.
+type handlerKey struct{}
+
+// InviteWithExpires send INVITE with an Expires header, the INVITE will be canceled if it is still unanswered after expires seconds.
+func (ua *UserAgent) InviteWithExpires(ctx context.Context, profile *account.Profile, target sip.Uri, recipient sip.SipUri, body *string, expires uint32, headers ...sip.Header) (*session.Session, error) {
+	return ua.inviteWithContext(ctx, profile, target, recipient, body, expires, headers)
+}
+
+// InviteWithOffer sends an INVITE with the session description offer, e.g. built with media.NewSDP,
+// its media keyed for SRTP according to the policy of the profile.
+func (ua *UserAgent) InviteWithOffer(ctx context.Context, profile *account.Profile, target sip.Uri, recipient sip.SipUri, offer *sdp.Session, headers ...sip.Header) (*session.Session, error) {
+	if err := media.SecureOffer(offer, profile.SRTP); err != nil {
+		return nil, err
 	}
+	body := offer.String()
+	return ua.inviteWithContext(ctx, profile, target, recipient, &body, 0, headers)
+}
+
+func (ua *UserAgent) inviteWithContext(ctx context.Context, profile *account.Profile, target sip.Uri, recipient sip.SipUri, body *string, expires uint32, headers []sip.Header) (*session.Session, error) {
+
+	target, recipient = ua.lookupENUM(ctx, target, recipient)
+	target, recipient, err := profile.Target(target, recipient)
+	if err != nil {
+		ua.Log().Errorf("INVITE: err = %v", err)
+		return nil, err
+	}
+
+	from, headers := ua.fromAddress(profile, headers)
 
 	contact := profile.Contact()
 
@@ -149,181 +375,322 @@ func (ua *UserAgent) InviteWithContext(ctx context.Context, profile *account.Pro
 		return nil, err
 	}
 
+	logger := ua.requestLog(*request, false)
+
 	if body != nil {
 		(*request).SetBody(*body, true)
-		contentType := sip.ContentType("application/sdp")
-		(*request).AppendHeader(&contentType)
+		// The body is an SDP unless the headers have another Content-Type, e.g. multipart/mixed.
+		if !hasHeader(headers, "Content-Type") {
+			contentType := sip.ContentType("application/sdp")
+			(*request).AppendHeader(&contentType)
+		}
 	}
 
-	var authorizer *auth.ClientAuthorizer = nil
-	if profile.AuthInfo != nil {
-		authorizer = auth.NewClientAuthorizer(profile.AuthInfo.AuthUser, profile.AuthInfo.Password)
+	if expires > 0 {
+		expiresHeader := sip.Expires(expires)
+		(*request).AppendHeader(&expiresHeader)
+	}
+
+	ua.appendIdentity(profile, *request)
+	appendResourcePriority(profile, *request)
+
+	for _, header := range headers {
+		(*request).AppendHeader(header)
 	}
 
+	if ua.config.IdentitySigner != nil {
+		if err := identity.Sign(ua.config.IdentitySigner, *request); err != nil {
+			logger.Errorf("INVITE: sign identity failed, err => %v", err)
+			return nil, err
+		}
+	}
+
+	authorizer := ua.authorizer(profile)
+
 	resp, err := ua.RequestWithContext(ctx, *request, authorizer, false, 1)
 	if err != nil {
-		ua.Log().Errorf("INVITE: Request [INVITE] failed, err => %v", err)
+		logger.Errorf("INVITE: Request [INVITE] failed, err => %v", err)
 		return nil, err
 	}
 
 	if resp != nil {
 		stateCode := resp.StatusCode()
-		ua.Log().Debugf("INVITE: resp %d => %s", stateCode, resp.String())
+		logger.Debugf("INVITE: resp %d => %s", stateCode, resp.String())
 		return nil, fmt.Errorf("Invite session is unsuccessful, code: %d, reason: %s", stateCode, resp.String())
 	}
 
-	callID, ok := (*request).CallID()
-	if ok {
-		if v, found := ua.iss.Load(*callID); found {
-			return v.(*session.Session), nil
-		}
+	if is, found := ua.iss.load(sentDialogID(*request)); found {
+		return is, nil
 	}
 
 	return nil, fmt.Errorf("invite session not found, unknown errors")
 }
 
+// fromAddress the From of a request of profile with a new tag, the address and the display name of a From of
+// the headers if any, e.g. to call with another identity than the one of the profile, and the other headers.
+func (ua *UserAgent) fromAddress(profile *account.Profile, headers []sip.Header) (*sip.Address, []sip.Header) {
+	from := &sip.Address{
+		DisplayName: sip.String{Str: profile.DisplayName},
+		Uri:         profile.URI,
+		Params:      sip.NewParams().Add("tag", sip.String{Str: ua.config.SipStack.NewTag()}),
+	}
+	others := make([]sip.Header, 0, len(headers))
+	for _, header := range headers {
+		if h, ok := header.(*sip.FromHeader); ok {
+			from.DisplayName = h.DisplayName
+			from.Uri = h.Address
+			continue
+		}
+		others = append(others, header)
+	}
+	return from, others
+}
+
+func hasHeader(headers []sip.Header, name string) bool {
+	for _, header := range headers {
+		if strings.EqualFold(header.Name(), name) {
+			return true
+		}
+	}
+	return false
+}
+
 func (ua *UserAgent) Request(req *sip.Request) (sip.ClientTransaction, error) {
 	return ua.config.SipStack.Request(*req)
 }
 
+//OnRequest registers a handler for requests of any SIP method, the handler must respond on tx.
+//Registering a method handled by the UA itself (INVITE, BYE, ...) replaces the built-in handling.
+func (ua *UserAgent) OnRequest(method sip.RequestMethod, handler stack.RequestHandler) {
+	ua.config.SipStack.OnRequest(method, handler)
+}
+
 func (ua *UserAgent) handleBye(request sip.Request, tx sip.ServerTransaction) {
-	ua.Log().Debugf("handleBye: Request => %s, body => %s", request.Short(), request.Body())
+	ua.requestLog(request, true).Debugf("handleBye: Request => %s, body => %s", request.Short(), request.Body())
 	response := sip.NewResponseFromRequest(request.MessageID(), request, 200, "OK", "")
-
-	if viaHop, ok := request.ViaHop(); ok {
-		var (
-			host string
-			port sip.Port
-		)
-		host = viaHop.Host
-		if viaHop.Params != nil {
-			if received, ok := viaHop.Params.Get("received"); ok && received.String() != "" {
-				host = received.String()
-			}
-			if rport, ok := viaHop.Params.Get("rport"); ok && rport != nil && rport.String() != "" {
-				if p, err := strconv.Atoi(rport.String()); err == nil {
-					port = sip.Port(uint16(p))
-				}
-			} else if request.Recipient().Port() != nil {
-				port = *request.Recipient().Port()
-			} else {
-				port = sip.DefaultPort(request.Transport())
-			}
-		}
-
-		dest := fmt.Sprintf("%v:%v", host, port)
-		response.SetDestination(dest)
-	}
-
 	tx.Respond(response)
-	callID, ok := request.CallID()
-	if ok {
-		if v, found := ua.iss.Load(*callID); found {
-			is := v.(*session.Session)
-			ua.iss.Delete(*callID)
-			var transaction sip.Transaction = tx.(sip.Transaction)
-			ua.handleInviteState(is, &request, &response, session.Terminated, &transaction)
-		}
+	if is, found := ua.iss.load(session.IncomingDialogID(request)); found {
+		ua.iss.delete(is)
+		var transaction sip.Transaction = tx.(sip.Transaction)
+		ua.handleInviteState(is, &request, &response, session.Terminated, &transaction)
 	}
 }
 
 func (ua *UserAgent) handleCancel(request sip.Request, tx sip.ServerTransaction) {
 
-	ua.Log().Debugf("handleCancel: Request => %s, body => %s", request.Short(), request.Body())
-	response := sip.NewResponseFromRequest(request.MessageID(), request, 200, "OK", "")
-	tx.Respond(response)
+	ua.requestLog(request, true).Debugf("handleCancel: Request => %s, body => %s", request.Short(), request.Body())
+	is, found := ua.iss.load(session.IncomingDialogID(request))
+	if !found {
+		// A CANCEL of no INVITE pending (RFC 3261 9.2).
+		tx.Respond(sip.NewResponseFromRequest(request.MessageID(), request, 481, "Call/Transaction Does Not Exist", ""))
+		return
+	}
+	ua.cancelInvite(is, request, tx)
+}
 
-	callID, ok := request.CallID()
-	if ok {
-		if v, found := ua.iss.Load(*callID); found {
-			is := v.(*session.Session)
-			ua.iss.Delete(*callID)
-			var transaction sip.Transaction = tx.(sip.Transaction)
-			is.SetState(session.Canceled)
-			ua.handleInviteState(is, &request, nil, session.Canceled, &transaction)
-		}
+// cancelInvite answers the CANCEL of the INVITE of is with 200 on tx and, while the INVITE is unanswered,
+// the INVITE with 487, the session then Canceled (RFC 3261 9.2). The CANCEL of an INVITE answered has no effect.
+func (ua *UserAgent) cancelInvite(is *session.Session, cancel sip.Request, tx sip.ServerTransaction) {
+	tx.Respond(sip.NewResponseFromRequest(cancel.MessageID(), cancel, 200, "OK", ""))
+	switch is.Status() {
+	case session.InviteReceived, session.WaitingForAnswer, session.Provisional, session.EarlyMedia:
+	default:
+		return
 	}
+	// Canceled once, by the first of the CANCEL and the Expires of the INVITE.
+	if !ua.iss.delete(is) {
+		return
+	}
+	is.Reject(487, "Request Terminated")
+	is.SetReleaseCause(session.NewReleaseCause(cancel, nil))
+	is.SetState(session.Canceled)
+	request := is.Request()
+	ua.handleInviteState(is, &request, nil, session.Canceled, nil)
 }
 
 func (ua *UserAgent) handleACK(request sip.Request, tx sip.ServerTransaction) {
 
-	ua.Log().Debugf("handleACK => %s, body => %s", request.Short(), request.Body())
-	callID, ok := request.CallID()
-	if ok {
-		if v, found := ua.iss.Load(*callID); found {
-			// handle Ringing or Processing with sdp
-			is := v.(*session.Session)
-			is.SetState(session.Confirmed)
-			ua.handleInviteState(is, &request, nil, session.Confirmed, nil)
-		}
+	ua.requestLog(request, true).Debugf("handleACK => %s, body => %s", request.Short(), request.Body())
+	if is, found := ua.iss.load(session.IncomingDialogID(request)); found {
+		is.SetState(session.Confirmed)
+		ua.handleInviteState(is, &request, nil, session.Confirmed, nil)
 	}
 }
 
 func (ua *UserAgent) handleInvite(request sip.Request, tx sip.ServerTransaction) {
 
-	ua.Log().Debugf("handleInvite => %s, body => %s", request.Short(), request.Body())
+	logger := ua.requestLog(request, true)
+	logger.Debugf("handleInvite => %s, body => %s", request.Short(), request.Body())
+
+	// Stop the retransmissions of the INVITE on UDP while the application decides (RFC 3261 8.2.6.1).
+	if !ua.config.DisableImmediateTrying {
+		tx.Respond(sip.NewResponseFromRequest(request.MessageID(), request, 100, "Trying", ""))
+	}
 
+	// initial the session of the INVITE, not of a re-INVITE, canceled by a CANCEL.
+	var initial *session.Session
 	callID, ok := request.CallID()
 	if ok {
 		var transaction sip.Transaction = tx.(sip.Transaction)
-		if v, found := ua.iss.Load(*callID); found {
-			is := v.(*session.Session)
+		id := session.IncomingDialogID(request)
+		if is, found := ua.iss.load(id); found {
+			if id.LocalTag == "" {
+				// Another INVITE of the dialog out of it, e.g. of a loop (RFC 3261 8.2.2.2).
+				tx.Respond(sip.NewResponseFromRequest(request.MessageID(), request, 482, "Loop Detected", ""))
+				return
+			}
 			is.SetState(session.ReInviteReceived)
 			ua.handleInviteState(is, &request, nil, session.ReInviteReceived, &transaction)
 		} else {
-			contact, _ := request.Contact()
+			var verification *identity.Verification
+			if ua.config.IdentityVerifier != nil {
+				v, err := identity.Verify(ua.config.IdentityVerifier, request)
+				if verr, ok := err.(*identity.VerificationError); ok {
+					logger.Warnf("INVITE: %v", verr)
+					tx.Respond(sip.NewResponseFromRequest(request.MessageID(), request, verr.StatusCode, verr.Reason, ""))
+					return
+				} else if err != nil {
+					logger.Warnf("INVITE: identity verification error => %v", err)
+				}
+				verification = v
+			}
+
+			// The local tag of the dialog, NewInviteSession only adds a random one.
+			ua.addToTag(request)
+			contact, ok := request.Contact()
+			if !ok {
+				// An RFC 2543 endpoint may not send a Contact, its From is the target.
+				from, _ := request.From()
+				contact = &sip.ContactHeader{Address: from.Address}
+			}
 			is := session.NewInviteSession(ua.RequestWithContext, "UAS", contact, request, *callID, transaction, session.Incoming, ua.Log())
-			ua.iss.Store(*callID, is)
+			is.SetVerification(verification)
+			is.SetMaxForwards(ua.config.SipStack.MaxForwards())
+			is.SetClock(ua.clock())
+			if session.IsAutoAnswerRequested(request) {
+				is.SetAutoAnswer(!ua.config.AutoAnswerRequireAuth || ua.config.SipStack.Authenticated(request))
+			}
+			ua.iss.store(is)
+			initial = is
 			is.SetState(session.InviteReceived)
 			ua.handleInviteState(is, &request, nil, session.InviteReceived, &transaction)
 			is.SetState(session.WaitingForAnswer)
+
+			if hdrs := request.GetHeaders("Expires"); len(hdrs) > 0 {
+				if expires, ok := hdrs[0].(*sip.Expires); ok && *expires > 0 {
+					ua.expireInvite(is, request, time.Duration(*expires)*time.Second)
+				}
+			}
 		}
 	}
 
 	go func() {
 		cancel := <-tx.Cancels()
 		if cancel != nil {
-			ua.Log().Debugf("Cancel => %s, body => %s", cancel.Short(), cancel.Body())
-			response := sip.NewResponseFromRequest(cancel.MessageID(), cancel, 200, "OK", "")
-			if callID, ok := response.CallID(); ok {
-				if v, found := ua.iss.Load(*callID); found {
-					ua.iss.Delete(*callID)
-					is := v.(*session.Session)
-					is.SetState(session.Canceled)
-					ua.handleInviteState(is, &request, &response, session.Canceled, nil)
-				}
+			logger.Debugf("Cancel => %s, body => %s", cancel.Short(), cancel.Body())
+			if initial != nil {
+				ua.cancelInvite(initial, cancel, tx)
+			} else {
+				tx.Respond(sip.NewResponseFromRequest(cancel.MessageID(), cancel, 200, "OK", ""))
+				// The re-INVITE terminated, ignored by tx if it was answered already (RFC 3261 9.2).
+				tx.Respond(sip.NewResponseFromRequest(request.MessageID(), request, 487, "Request Terminated", ""))
 			}
-
-			tx.Respond(response)
 		}
 	}()
 
 	go func() {
 		ack := <-tx.Acks()
 		if ack != nil {
-			ua.Log().Debugf("ack => %v", ack)
+			logger.Debugf("ack => %v", ack)
 		}
 	}()
 }
 
+// expireInvite reject the incoming INVITE with 487 if it is still unanswered after timeout.
+func (ua *UserAgent) expireInvite(is *session.Session, request sip.Request, timeout time.Duration) {
+	ua.clock().AfterFunc(timeout, func() {
+		switch is.Status() {
+		case session.InviteReceived:
+			fallthrough
+		case session.WaitingForAnswer:
+			if !ua.iss.delete(is) {
+				return
+			}
+			ua.Log().Debugf("INVITE expired after %v, reject with 487", timeout)
+			is.Reject(487, "Request Terminated")
+			is.SetReleaseCause(&session.ReleaseCause{Cause: session.CauseNoAnswer, StatusCode: 487})
+			is.SetState(session.Canceled)
+			ua.handleInviteState(is, &request, nil, session.Canceled, nil)
+		}
+	})
+}
+
+// authorizer the authorizer of the requests of an account, its own one or a digest one with its credentials
+// by realm sharing the credentials cache of the ua, nil without credentials.
+func (ua *UserAgent) authorizer(profile *account.Profile) auth.Authorizer {
+	if profile.Authorizer != nil {
+		return profile.Authorizer
+	}
+	if profile.AuthInfo == nil && len(profile.Credentials) == 0 {
+		return nil
+	}
+	authorizer := auth.NewClientAuthorizer("", "")
+	if info := profile.AuthInfo; info != nil {
+		authorizer = auth.NewClientAuthorizer(info.AuthUser, info.Password).
+			SetAuthInt(info.AuthInt).
+			SetCredentialProvider(info.CredentialProvider)
+	}
+	for realm, info := range profile.Credentials {
+		authorizer.AddRealm(realm, info.AuthUser, info.Password)
+	}
+	return authorizer.SetCache(ua.credentials)
+}
+
 // RequestWithContext .
-func (ua *UserAgent) RequestWithContext(ctx context.Context, request sip.Request, authorizer sip.Authorizer, waitForResult bool, attempt int) (sip.Response, error) {
+func (ua *UserAgent) RequestWithContext(ctx context.Context, request sip.Request, authorizer auth.Authorizer, waitForResult bool, attempt int) (sip.Response, error) {
 	s := ua.config.SipStack
+	if ua.config.TrustedElement {
+		identity.ApplyPrivacy(request, ua.isTrustedHost(nextHop(request)))
+	}
+	preauthorizer, _ := authorizer.(auth.Preauthorizer)
+	if preauthorizer != nil && attempt == 1 {
+		preauthorizer.PreauthorizeRequest(request)
+	}
 	tx, err := s.Request(request)
 	if err != nil {
 		return nil, err
 	}
+
+	// Cancel the INVITE transaction when the Expires lapses.
+	cancelExpires := func() {}
+	if request.IsInvite() && attempt == 1 {
+		if hdrs := request.GetHeaders("Expires"); len(hdrs) > 0 {
+			if expires, ok := hdrs[0].(*sip.Expires); ok && *expires > 0 {
+				ctx, cancelExpires = context.WithTimeout(ctx, time.Duration(*expires)*time.Second)
+			}
+		}
+	}
+
 	var cts sip.Transaction = tx.(sip.Transaction)
 
 	if request.IsInvite() {
 		callID, ok := request.CallID()
 		if ok {
 
-			if _, found := ua.iss.Load(*callID); !found {
+			if _, found := ua.iss.load(sentDialogID(request)); !found {
 				contact, _ := request.Contact()
 				is := session.NewInviteSession(ua.RequestWithContext, "UAC", contact, request, *callID, cts, session.Outgoing, ua.Log())
-				ua.iss.Store(*callID, is)
-				is.ProvideOffer(request.Body())
+				is.SetMaxForwards(ua.config.SipStack.MaxForwards())
+				is.SetClock(ua.clock())
+				if handler, ok := ctx.Value(handlerKey{}).(session.Handler); ok {
+					is.SetHandler(handler)
+				}
+				ua.iss.store(is)
+				offer, ok := multipart.Extract(request, "application/sdp")
+				if !ok {
+					offer = request.Body()
+				}
+				is.ProvideOffer(offer)
 				is.SetState(session.InviteSent)
 				ua.handleInviteState(is, &request, nil, session.InviteSent, &cts)
 			}
@@ -334,6 +701,7 @@ func (ua *UserAgent) RequestWithContext(ctx context.Context, request sip.Request
 	provisionals := make(chan sip.Response)
 	errs := make(chan error)
 	go func() {
+		defer cancelExpires()
 		var lastResponse sip.Response
 
 		previousResponses := make([]sip.Response, 0)
@@ -342,13 +710,20 @@ func (ua *UserAgent) RequestWithContext(ctx context.Context, request sip.Request
 		for {
 			select {
 			case <-ctx.Done():
-				if lastResponse != nil && lastResponse.IsProvisional() {
-					s.CancelRequest(request, lastResponse)
-				}
-				if lastResponse != nil {
-					lastResponse.SetPrevious(previousResponses)
+				if request.IsInvite() {
+					if lastResponse != nil && lastResponse.IsProvisional() {
+						s.CancelRequest(request, lastResponse)
+					}
+					if lastResponse != nil {
+						lastResponse.SetPrevious(previousResponses)
+					}
+					errs <- sip.NewRequestError(487, "Request Terminated", request, lastResponse)
+				} else {
+					// A non-INVITE is not canceled by a CANCEL (RFC 3261 9.1), its transaction ends
+					// at once without retransmitting it and it fails with the error of ctx.
+					tx.Cancel()
+					errs <- &CanceledError{Err: ctx.Err()}
 				}
-				errs <- sip.NewRequestError(487, "Request Terminated", request, lastResponse)
 				// pull out later possible transaction responses and errors
 				go func() {
 					for {
@@ -403,6 +778,10 @@ func (ua *UserAgent) RequestWithContext(ctx context.Context, request sip.Request
 				// success
 				if response.IsSuccess() {
 					response.SetPrevious(previousResponses)
+					if preauthorizer != nil {
+						preauthorizer.Accepted(request)
+					}
+					ua.authState(AuthAuthorized, auth.Credentials(request), request, response, nil)
 
 					if request.IsInvite() {
 						s.AckInviteRequest(request, response)
@@ -419,9 +798,25 @@ func (ua *UserAgent) RequestWithContext(ctx context.Context, request sip.Request
 				}
 
 				// unauth request
-				needAuth := (response.StatusCode() == 401 || response.StatusCode() == 407) && attempt < 2
+				needAuth := response.StatusCode() == 401 || response.StatusCode() == 407
 				if needAuth && authorizer != nil {
+					// Challenged again for a realm answered, without stale=true the credentials are wrong.
+					// A request authorized preemptively is challenged as usual.
+					stale := auth.Stale(response)
+					if attempt > 1 && auth.Rejected(request, response) || attempt >= ua.maxAuthAttempts() {
+						response.SetPrevious(previousResponses)
+						err := &AuthError{
+							RequestError: sip.NewRequestError(uint(response.StatusCode()), response.Reason(), request, response),
+							Stale:        stale,
+							Attempts:     attempt,
+						}
+						ua.authState(AuthFailed, auth.Challenges(response), request, response, err)
+						errs <- err
+						return
+					}
+					ua.authState(AuthChallenged, auth.Challenges(response), request, response, nil)
 					if err := authorizer.AuthorizeRequest(request, response); err != nil {
+						ua.authState(AuthFailed, auth.Challenges(response), request, response, err)
 						errs <- err
 						return
 					}
@@ -437,7 +832,18 @@ func (ua *UserAgent) RequestWithContext(ctx context.Context, request sip.Request
 				if lastResponse != nil {
 					lastResponse.SetPrevious(previousResponses)
 				}
-				errs <- sip.NewRequestError(uint(response.StatusCode()), response.Reason(), request, lastResponse)
+				reqErr := sip.NewRequestError(uint(response.StatusCode()), response.Reason(), request, lastResponse)
+				switch {
+				case needAuth:
+					ua.authState(AuthFailed, auth.Challenges(response), request, response, reqErr)
+				case response.StatusCode() == 403 && attempt > 1:
+					// Forbidden the credentials answering the challenge, e.g. with a wrong password.
+					err := &AuthError{RequestError: reqErr, Attempts: attempt}
+					ua.authState(AuthFailed, auth.Credentials(request), request, response, err)
+					errs <- err
+					return
+				}
+				errs <- reqErr
 				return
 			}
 		}
@@ -447,17 +853,13 @@ func (ua *UserAgent) RequestWithContext(ctx context.Context, request sip.Request
 		for {
 			select {
 			case provisional := <-provisionals:
-				callID, ok := provisional.CallID()
-				if ok {
-					if v, found := ua.iss.Load(*callID); found {
-						is := v.(*session.Session)
-						is.StoreResponse(provisional)
-						// handle Ringing or Processing with sdp
-						ua.handleInviteState(is, &request, &provisional, session.Provisional, cts)
-						if len(provisional.Body()) > 0 {
-							is.SetState(session.EarlyMedia)
-							ua.handleInviteState(is, &request, &provisional, session.EarlyMedia, cts)
-						}
+				if is, found := ua.iss.load(sentDialogID(provisional)); found {
+					is.StoreResponse(provisional)
+					// handle Ringing or Processing with sdp
+					ua.handleInviteState(is, &request, &provisional, session.Provisional, cts)
+					if len(provisional.Body()) > 0 {
+						is.SetState(session.EarlyMedia)
+						ua.handleInviteState(is, &request, &provisional, session.EarlyMedia, cts)
 					}
 				}
 			case err := <-errs:
@@ -467,32 +869,25 @@ func (ua *UserAgent) RequestWithContext(ctx context.Context, request sip.Request
 					//errs <- sip.NewRequestError(408, "Request Timeout", nil, nil)
 					return nil, err
 				}
-				request := (err.(*sip.RequestError)).Request
-				response := (err.(*sip.RequestError)).Response
-				callID, ok := request.CallID()
-				if ok {
-					if v, found := ua.iss.Load(*callID); found {
-						is := v.(*session.Session)
-						ua.iss.Delete(*callID)
-						is.SetState(session.Failure)
-						ua.handleInviteState(is, &request, &response, session.Failure, nil)
-					}
+				var response sip.Response
+				if reqErr, ok := asRequestError(err); ok {
+					response = reqErr.Response
+				}
+				if is, found := ua.iss.load(sentDialogID(request)); found {
+					ua.iss.delete(is)
+					is.SetState(session.Failure)
+					ua.handleInviteState(is, &request, &response, session.Failure, nil)
 				}
-				return nil, err
+				return nil, newRequestError(err)
 			case response := <-responses:
-				callID, ok := response.CallID()
-				if ok {
-					if v, found := ua.iss.Load(*callID); found {
-						if request.IsInvite() {
-							is := v.(*session.Session)
-							is.SetState(session.Confirmed)
-							ua.handleInviteState(is, &request, &response, session.Confirmed, nil)
-						} else if request.Method() == sip.BYE {
-							is := v.(*session.Session)
-							ua.iss.Delete(*callID)
-							is.SetState(session.Terminated)
-							ua.handleInviteState(is, &request, &response, session.Terminated, nil)
-						}
+				if is, found := ua.iss.load(sentDialogID(response)); found {
+					if request.IsInvite() {
+						is.SetState(session.Confirmed)
+						ua.handleInviteState(is, &request, &response, session.Confirmed, nil)
+					} else if request.Method() == sip.BYE {
+						ua.iss.delete(is)
+						is.SetState(session.Terminated)
+						ua.handleInviteState(is, &request, &response, session.Terminated, nil)
 					}
 				}
 				return response, nil
@@ -69,6 +69,12 @@ func SetLogLevel(prefix string, level log.Level) error {
 		logger.Logger.SetLevel(level)
 		return nil
 	}
+	sinkMu.RLock()
+	defer sinkMu.RUnlock()
+	if logger, found := structured[prefix]; found {
+		logger.SetLevel(level)
+		return nil
+	}
 	return fmt.Errorf("logger [%v] not found", prefix)
 }
 
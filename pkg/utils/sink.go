@@ -0,0 +1,200 @@
+package utils
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+
+	"github.com/ghettovoice/gosip/log"
+	"github.com/ghettovoice/gosip/sip"
+)
+
+// The fields attached to the log lines of a call or a request, see RequestFields.
+const (
+	FieldCallID    = "call_id"
+	FieldMethod    = "method"
+	FieldAccount   = "account"
+	FieldDirection = "direction"
+	// FieldPrefix the prefix of the logger, e.g. UserAgent or Session.
+	FieldPrefix = "prefix"
+)
+
+// LogSink the backend of the structured loggers of NewLogger, e.g. an adapter of zap, zerolog or slog.
+// A line is logged with the fields of its logger, FieldPrefix included, instead of a string prefix.
+type LogSink interface {
+	Log(level log.Level, msg string, fields log.Fields)
+}
+
+// LogSinkFunc a function as a LogSink.
+type LogSinkFunc func(level log.Level, msg string, fields log.Fields)
+
+// Log calls f.
+func (f LogSinkFunc) Log(level log.Level, msg string, fields log.Fields) {
+	f(level, msg, fields)
+}
+
+var (
+	sinkMu sync.RWMutex
+	sink   LogSink
+	// structured the structured loggers by prefix, for SetLogLevel.
+	structured = make(map[string]*structuredLogger)
+)
+
+// SetLogSink sets the backend of the loggers created by NewLogger, nil for the logrus ones. The structured
+// loggers already created log to the new one from now on, to the logrus logger of their prefix if nil.
+func SetLogSink(s LogSink) {
+	sinkMu.Lock()
+	defer sinkMu.Unlock()
+	sink = s
+}
+
+// NewLogger a logger of prefix, a structured one on the LogSink if one is set, else NewLogrusLogger.
+func NewLogger(level log.Level, prefix string, fields log.Fields) log.Logger {
+	sinkMu.Lock()
+	defer sinkMu.Unlock()
+	if sink == nil {
+		return NewLogrusLogger(level, prefix, fields)
+	}
+	logger, found := structured[prefix]
+	if !found {
+		logger = &structuredLogger{level: new(uint32)}
+		logger.SetLevel(level)
+		structured[prefix] = logger
+	}
+	return logger.WithPrefix(prefix).WithFields(fields)
+}
+
+// RequestFields the fields of the log lines of a request received or sent: its Call-ID, its method, its
+// direction, Incoming or Outgoing, and its account, the To of a request received and the From of one sent.
+func RequestFields(request sip.Request, incoming bool) log.Fields {
+	fields := log.Fields{FieldMethod: string(request.Method())}
+	if callID, ok := request.CallID(); ok {
+		fields[FieldCallID] = string(*callID)
+	}
+	if incoming {
+		fields[FieldDirection] = "Incoming"
+		if to, ok := request.To(); ok && to.Address != nil {
+			fields[FieldAccount] = to.Address.String()
+		}
+	} else {
+		fields[FieldDirection] = "Outgoing"
+		if from, ok := request.From(); ok && from.Address != nil {
+			fields[FieldAccount] = from.Address.String()
+		}
+	}
+	return fields
+}
+
+// structuredLogger a log.Logger passing the lines and their fields to the LogSink set when they are logged.
+type structuredLogger struct {
+	prefix string
+	fields log.Fields
+	// level shared by the loggers derived by WithPrefix and WithFields.
+	level *uint32
+}
+
+func (l *structuredLogger) log(level log.Level, msg string) {
+	if uint32(level) > atomic.LoadUint32(l.level) {
+		return
+	}
+	sinkMu.RLock()
+	s := sink
+	sinkMu.RUnlock()
+	if s == nil {
+		sinkMu.Lock()
+		logger := NewLogrusLogger(log.Level(atomic.LoadUint32(l.level)), l.prefix, nil)
+		sinkMu.Unlock()
+		logTo(logger.WithFields(l.fields), level, msg)
+		return
+	}
+	fields := l.fields
+	if l.prefix != "" {
+		fields = fields.WithFields(log.Fields{FieldPrefix: l.prefix})
+	}
+	s.Log(level, msg, fields)
+}
+
+// logTo logs msg at level to logger, the Fatal and Panic ones as errors, exiting and panicking left to
+// the caller.
+func logTo(logger log.Logger, level log.Level, msg string) {
+	switch level {
+	case log.TraceLevel:
+		logger.Trace(msg)
+	case log.DebugLevel:
+		logger.Debug(msg)
+	case log.InfoLevel:
+		logger.Info(msg)
+	case log.WarnLevel:
+		logger.Warn(msg)
+	default:
+		logger.Error(msg)
+	}
+}
+
+func (l *structuredLogger) Print(args ...interface{}) { l.log(log.InfoLevel, fmt.Sprint(args...)) }
+func (l *structuredLogger) Printf(format string, args ...interface{}) {
+	l.log(log.InfoLevel, fmt.Sprintf(format, args...))
+}
+func (l *structuredLogger) Trace(args ...interface{}) { l.log(log.TraceLevel, fmt.Sprint(args...)) }
+func (l *structuredLogger) Tracef(format string, args ...interface{}) {
+	l.log(log.TraceLevel, fmt.Sprintf(format, args...))
+}
+func (l *structuredLogger) Debug(args ...interface{}) { l.log(log.DebugLevel, fmt.Sprint(args...)) }
+func (l *structuredLogger) Debugf(format string, args ...interface{}) {
+	l.log(log.DebugLevel, fmt.Sprintf(format, args...))
+}
+func (l *structuredLogger) Info(args ...interface{}) { l.log(log.InfoLevel, fmt.Sprint(args...)) }
+func (l *structuredLogger) Infof(format string, args ...interface{}) {
+	l.log(log.InfoLevel, fmt.Sprintf(format, args...))
+}
+func (l *structuredLogger) Warn(args ...interface{}) { l.log(log.WarnLevel, fmt.Sprint(args...)) }
+func (l *structuredLogger) Warnf(format string, args ...interface{}) {
+	l.log(log.WarnLevel, fmt.Sprintf(format, args...))
+}
+func (l *structuredLogger) Error(args ...interface{}) { l.log(log.ErrorLevel, fmt.Sprint(args...)) }
+func (l *structuredLogger) Errorf(format string, args ...interface{}) {
+	l.log(log.ErrorLevel, fmt.Sprintf(format, args...))
+}
+
+// Fatal logs and exits like logrus.
+func (l *structuredLogger) Fatal(args ...interface{}) {
+	l.log(log.FatalLevel, fmt.Sprint(args...))
+	os.Exit(1)
+}
+func (l *structuredLogger) Fatalf(format string, args ...interface{}) {
+	l.log(log.FatalLevel, fmt.Sprintf(format, args...))
+	os.Exit(1)
+}
+
+// Panic logs and panics like logrus.
+func (l *structuredLogger) Panic(args ...interface{}) {
+	msg := fmt.Sprint(args...)
+	l.log(log.PanicLevel, msg)
+	panic(msg)
+}
+func (l *structuredLogger) Panicf(format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	l.log(log.PanicLevel, msg)
+	panic(msg)
+}
+
+func (l *structuredLogger) WithPrefix(prefix string) log.Logger {
+	return &structuredLogger{prefix: prefix, fields: l.fields, level: l.level}
+}
+
+func (l *structuredLogger) Prefix() string {
+	return l.prefix
+}
+
+func (l *structuredLogger) WithFields(fields log.Fields) log.Logger {
+	return &structuredLogger{prefix: l.prefix, fields: l.fields.WithFields(fields), level: l.level}
+}
+
+func (l *structuredLogger) Fields() log.Fields {
+	return l.fields.WithFields(nil)
+}
+
+func (l *structuredLogger) SetLevel(level log.Level) {
+	atomic.StoreUint32(l.level, uint32(level))
+}
@@ -0,0 +1,33 @@
+package utils_test
+
+import (
+	"testing"
+
+	"github.com/ghettovoice/gosip/log"
+	"github.com/sergeyu/go-sip-ua/pkg/utils"
+)
+
+// TestSetLogSink the lines of a logger created before SetLogSink passed to the sink set after it.
+func TestSetLogSink(t *testing.T) {
+	defer utils.SetLogSink(nil)
+	var first, second []string
+	utils.SetLogSink(utils.LogSinkFunc(func(level log.Level, msg string, fields log.Fields) {
+		first = append(first, msg)
+	}))
+	logger := utils.NewLogger(log.InfoLevel, "sink-test", nil)
+	logger.Info("a")
+	utils.SetLogSink(utils.LogSinkFunc(func(level log.Level, msg string, fields log.Fields) {
+		if prefix := fields[utils.FieldPrefix]; prefix != "sink-test" {
+			t.Errorf("%s = %v; want sink-test", utils.FieldPrefix, prefix)
+		}
+		second = append(second, msg)
+	}))
+	logger.Info("b")
+	utils.NewLogger(log.InfoLevel, "sink-test", nil).Info("c")
+	if len(first) != 1 || first[0] != "a" {
+		t.Errorf("first sink got %v; want [a]", first)
+	}
+	if len(second) != 2 || second[0] != "b" || second[1] != "c" {
+		t.Errorf("second sink got %v; want [b c]", second)
+	}
+}
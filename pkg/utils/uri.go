@@ -0,0 +1,237 @@
+package utils
+
+import (
+	"net/url"
+	"strings"
+
+	"github.com/ghettovoice/gosip/sip"
+)
+
+const upperhex = "0123456789ABCDEF"
+
+// uriParamsMatched the URI parameters of two URIs equal only if both have the same or neither has them,
+// the others compared only if both have them (RFC 3261 19.1.4).
+var uriParamsMatched = []string{"user", "ttl", "method", "maddr", "transport"}
+
+// EscapeUser escapes the characters of user not allowed in the user part of a SIP URI, e.g. a space,
+// # or %, as %XX (RFC 3261 25.1).
+func EscapeUser(user string) string {
+	return escape(user, isUserChar)
+}
+
+// UnescapeUser decodes the %XX of the user part of a SIP URI.
+func UnescapeUser(user string) (string, error) {
+	return url.PathUnescape(user)
+}
+
+// UserOf the user part of uri unescaped, empty without one, e.g. the conference or the resource a
+// request is sent to.
+func UserOf(uri sip.Uri) string {
+	if uri == nil || uri.User() == nil {
+		return ""
+	}
+	user := uri.User().String()
+	if unescaped, err := UnescapeUser(user); err == nil {
+		return unescaped
+	}
+	return user
+}
+
+// NormalizeURI a copy of uri in a canonical form, e.g. to key a table by URI: its user and password
+// escaped the same way, its host and parameters lower case and the default port of its scheme
+// and transport removed. Unlike URIEqual, it takes sip:alice@host:5060 for sip:alice@host.
+func NormalizeURI(uri sip.Uri) sip.Uri {
+	sipURI, ok := uri.(*sip.SipUri)
+	if !ok {
+		return uri.Clone()
+	}
+	normalized := sipURI.Clone().(*sip.SipUri)
+	if normalized.FUser != nil {
+		normalized.FUser = sip.String{Str: canonicalEscape(normalized.FUser.String(), isUserChar)}
+	}
+	if normalized.FPassword != nil {
+		normalized.FPassword = sip.String{Str: canonicalEscape(normalized.FPassword.String(), isPasswordChar)}
+	}
+	normalized.FHost = strings.ToLower(normalized.FHost)
+	if normalized.FUriParams != nil {
+		params := sip.NewParams()
+		for _, key := range normalized.FUriParams.Keys() {
+			value, _ := normalized.FUriParams.Get(key)
+			if value != nil {
+				value = sip.String{Str: strings.ToLower(value.String())}
+			}
+			params.Add(strings.ToLower(key), value)
+		}
+		normalized.FUriParams = params
+	}
+	if normalized.FPort != nil && *normalized.FPort == defaultURIPort(normalized) {
+		normalized.FPort = nil
+	}
+	return normalized
+}
+
+// URIEqual whether a and b are equivalent SIP or SIPS URIs (RFC 3261 19.1.4): the same scheme, user and
+// password, the escaped characters equal to themselves, a host told apart case-insensitively, the same port,
+// the same user, ttl, method, maddr and transport parameters, the other parameters equal if in both, and
+// the same headers. A port or a parameter omitted does not match its default value. The other URIs are
+// compared by their Equals.
+func URIEqual(a, b sip.Uri) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	x, ok := a.(*sip.SipUri)
+	y, ok2 := b.(*sip.SipUri)
+	if !ok || !ok2 {
+		return a.Equals(b)
+	}
+	if x.FIsEncrypted != y.FIsEncrypted ||
+		!escapedEqual(x.FUser, y.FUser, isUserChar) ||
+		!escapedEqual(x.FPassword, y.FPassword, isPasswordChar) ||
+		!strings.EqualFold(x.FHost, y.FHost) {
+		return false
+	}
+	if (x.FPort == nil) != (y.FPort == nil) || x.FPort != nil && *x.FPort != *y.FPort {
+		return false
+	}
+
+	xparams, yparams := lowerParams(x.FUriParams), lowerParams(y.FUriParams)
+	for _, name := range uriParamsMatched {
+		xv, xok := xparams[name]
+		yv, yok := yparams[name]
+		if xok != yok || xok && !strings.EqualFold(xv, yv) {
+			return false
+		}
+	}
+	for name, xv := range xparams {
+		if yv, ok := yparams[name]; ok && !strings.EqualFold(xv, yv) {
+			return false
+		}
+	}
+
+	xheaders, yheaders := lowerParams(x.FHeaders), lowerParams(y.FHeaders)
+	if len(xheaders) != len(yheaders) {
+		return false
+	}
+	for name, xv := range xheaders {
+		if yv, ok := yheaders[name]; !ok || xv != yv {
+			return false
+		}
+	}
+	return true
+}
+
+// defaultURIPort the port of uri if omitted, the one of its transport parameter, 5061 for a SIPS URI.
+func defaultURIPort(uri *sip.SipUri) sip.Port {
+	if uri.FIsEncrypted {
+		return sip.DefaultTlsPort
+	}
+	if uri.FUriParams != nil {
+		if transport, ok := uri.FUriParams.Get("transport"); ok && transport != nil {
+			return sip.DefaultPort(transport.String())
+		}
+	}
+	return sip.DefaultUdpPort
+}
+
+// lowerParams the parameters by lower case name, their values canonically escaped.
+func lowerParams(params sip.Params) map[string]string {
+	values := make(map[string]string)
+	if params == nil {
+		return values
+	}
+	for _, key := range params.Keys() {
+		value, _ := params.Get(key)
+		var s string
+		if value != nil {
+			s = canonicalEscape(value.String(), isParamChar)
+		}
+		values[strings.ToLower(key)] = s
+	}
+	return values
+}
+
+func escapedEqual(a, b sip.MaybeString, allowed func(byte) bool) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return canonicalEscape(a.String(), allowed) == canonicalEscape(b.String(), allowed)
+}
+
+// escape s, the characters not allowed as %XX.
+func escape(s string, allowed func(byte) bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if allowed(c) {
+			b.WriteByte(c)
+			continue
+		}
+		writeEscaped(&b, c)
+	}
+	return b.String()
+}
+
+func writeEscaped(b *strings.Builder, c byte) {
+	b.WriteByte('%')
+	b.WriteByte(upperhex[c>>4])
+	b.WriteByte(upperhex[c&15])
+}
+
+// canonicalEscape s with its %XX of the unreserved characters decoded, the reserved ones and the characters
+// not allowed escaped in upper case, so two equivalent strings are the same (RFC 3261 19.1.4).
+func canonicalEscape(s string, allowed func(byte) bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c == '%' && i+2 < len(s) && isHex(s[i+1]) && isHex(s[i+2]) {
+			decoded := unhex(s[i+1])<<4 | unhex(s[i+2])
+			i += 2
+			if !isReserved(decoded) && allowed(decoded) {
+				b.WriteByte(decoded)
+			} else {
+				writeEscaped(&b, decoded)
+			}
+			continue
+		}
+		if allowed(c) {
+			b.WriteByte(c)
+		} else {
+			writeEscaped(&b, c)
+		}
+	}
+	return b.String()
+}
+
+func isUnreserved(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || strings.IndexByte("-_.!~*'()", c) >= 0
+}
+
+func isReserved(c byte) bool {
+	return strings.IndexByte(";/?:@&=+$,", c) >= 0
+}
+
+func isUserChar(c byte) bool {
+	return isUnreserved(c) || strings.IndexByte("&=+$,;?/", c) >= 0
+}
+
+func isPasswordChar(c byte) bool {
+	return isUnreserved(c) || strings.IndexByte("&=+$,", c) >= 0
+}
+
+func isParamChar(c byte) bool {
+	return isUnreserved(c) || strings.IndexByte("[]/:&+$", c) >= 0
+}
+
+func isHex(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
+
+func unhex(c byte) byte {
+	switch {
+	case '0' <= c && c <= '9':
+		return c - '0'
+	case 'a' <= c && c <= 'f':
+		return c - 'a' + 10
+	}
+	return c - 'A' + 10
+}